@@ -0,0 +1,162 @@
+package container
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const (
+	passwdPath = "etc/passwd"
+	groupPath  = "etc/group"
+)
+
+// Accounts maps user and group IDs to names as defined by /etc/passwd and
+// /etc/group of an image
+type Accounts struct {
+	users  map[int]string // nil if /etc/passwd is missing
+	groups map[int]string // nil if /etc/group is missing
+}
+
+// LoadAccounts reads /etc/passwd and /etc/group of fsys, which may be a
+// single layer or a MergedFS. Missing files define no names.
+func LoadAccounts(fsys fs.FS) (*Accounts, error) {
+	users, err := readAccountFile(fsys, passwdPath)
+	if err != nil {
+		return nil, err
+	}
+	groups, err := readAccountFile(fsys, groupPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Accounts{users: users, groups: groups}, nil
+}
+
+// User returns the name of the user uid
+func (a *Accounts) User(uid int) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	name, ok := a.users[uid]
+	return name, ok
+}
+
+// Complete reports whether both /etc/passwd and /etc/group were found or
+// deleted, so that the layers below can't change the names
+func (a *Accounts) Complete() bool {
+	return a != nil && a.users != nil && a.groups != nil
+}
+
+// stack returns the accounts of a layer stacked on those of the layer below
+// it: each file is taken from the upper layer if it has it
+func (a *Accounts) stack(lower *Accounts) *Accounts {
+	stacked := &Accounts{}
+	if a != nil {
+		*stacked = *a
+	}
+	if lower == nil {
+		return stacked
+	}
+	if stacked.users == nil {
+		stacked.users = lower.users
+	}
+	if stacked.groups == nil {
+		stacked.groups = lower.groups
+	}
+	return stacked
+}
+
+// StackedAccounts returns the accounts of the layer at index stacked on the
+// layers below it, whose /etc/passwd or /etc/group applies when the layer
+// doesn't have its own. top are the accounts of the layer itself. The lower
+// layers are read from the top until both files are found, initializing
+// copies of those that aren't.
+func (i *Image) StackedAccounts(ctx context.Context, index int, top *Accounts) (*Accounts, error) {
+	accounts := top.stack(nil)
+	for j := index - 1; j >= 0 && !accounts.Complete(); j-- {
+		if !i.Layers[j].IsArchive() {
+			continue
+		}
+		lower, err := i.layerAccounts(ctx, j)
+		if err != nil {
+			return nil, err
+		}
+		accounts = accounts.stack(lower)
+	}
+	return accounts, nil
+}
+
+// layerAccounts returns the accounts of the layer at index alone
+func (i *Image) layerAccounts(ctx context.Context, index int) (*Accounts, error) {
+	layer, err := i.Layers[index].initialized(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer layer.Close()
+	accounts, err := LoadAccounts(layer.files())
+	if err != nil {
+		// Owners are shown with the names of the layers below
+		debug("StackedAccounts: Failed to load accounts: %v", err)
+	}
+	return accounts, nil
+}
+
+// Group returns the name of the group gid
+func (a *Accounts) Group(gid int) (string, bool) {
+	if a == nil {
+		return "", false
+	}
+	name, ok := a.groups[gid]
+	return name, ok
+}
+
+func readAccountFile(fsys fs.FS, name string) (map[int]string, error) {
+	f, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		// A whiteout in a layer deletes the file of the layers below
+		if _, err := fs.Stat(fsys, path.Join(path.Dir(name), whiteoutPrefix+path.Base(name))); err == nil {
+			return map[int]string{}, nil
+		}
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", name, err)
+	}
+	defer f.Close()
+
+	names, err := parseAccounts(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return names, nil
+}
+
+// parseAccounts parses the names and IDs in the first and third fields of
+// passwd or group entries. The first entry of an ID wins as in getpwuid(3).
+func parseAccounts(r io.Reader) (map[int]string, error) {
+	names := make(map[int]string)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, ":")
+		if len(fields) < 3 || fields[0] == "" {
+			continue
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil || id < 0 {
+			continue
+		}
+		if _, ok := names[id]; !ok {
+			names[id] = fields[0]
+		}
+	}
+	return names, scanner.Err()
+}
@@ -0,0 +1,249 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+const testPasswd = `root:x:0:0:root:/root:/bin/sh
+# comment
+
+nginx:x:101:101:nginx:/var/cache/nginx:/sbin/nologin
+broken
+invalid:x:abc:0::/:/bin/sh
+duplicate:x:101:101::/:/bin/sh
+`
+
+const testGroup = `root:x:0:
+nginx:x:101:
+`
+
+func TestParseAccounts(t *testing.T) {
+	got, err := parseAccounts(strings.NewReader(testPasswd))
+	if err != nil {
+		t.Fatalf("parseAccounts() error = %v", err)
+	}
+	want := map[int]string{0: "root", 101: "nginx"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseAccounts() = %v, want %v", got, want)
+	}
+}
+
+func TestLoadAccounts(t *testing.T) {
+	t.Run("merged image", func(t *testing.T) {
+		// /etc/passwd of the lower layer is replaced and /etc/group is kept
+		m, err := Merge(
+			createMergeLayer(t,
+				testEntry{name: "etc/", dir: true},
+				testEntry{name: "etc/passwd", content: "root:x:0:0::/root:/bin/sh\napp:x:1000:1000::/app:/bin/sh\n"},
+			),
+			createMergeLayer(t,
+				testEntry{name: "etc/", dir: true},
+				testEntry{name: "etc/passwd", content: testPasswd},
+				testEntry{name: "etc/group", content: testGroup},
+			),
+		)
+		if err != nil {
+			t.Fatalf("Merge() error = %v", err)
+		}
+		accounts, err := LoadAccounts(m)
+		if err != nil {
+			t.Fatalf("LoadAccounts() error = %v", err)
+		}
+
+		if name, ok := accounts.User(1000); !ok || name != "app" {
+			t.Errorf("User(1000) = %q, %v, want %q", name, ok, "app")
+		}
+		if name, ok := accounts.User(101); ok {
+			t.Errorf("User(101) = %q, want no user", name)
+		}
+		if name, ok := accounts.Group(101); !ok || name != "nginx" {
+			t.Errorf("Group(101) = %q, %v, want %q", name, ok, "nginx")
+		}
+	})
+
+	t.Run("missing files", func(t *testing.T) {
+		accounts, err := LoadAccounts(createMergeLayer(t, testEntry{name: "file", content: "x"}).fs)
+		if err != nil {
+			t.Fatalf("LoadAccounts() error = %v", err)
+		}
+		if _, ok := accounts.User(0); ok {
+			t.Error("Expected no users")
+		}
+	})
+
+	t.Run("nil accounts", func(t *testing.T) {
+		var accounts *Accounts
+		if _, ok := accounts.Group(0); ok {
+			t.Error("Expected no groups")
+		}
+	})
+}
+
+func TestGetFilesOwnerNames(t *testing.T) {
+	content := writeTestTar(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/passwd", content: testPasswd},
+		testEntry{name: "etc/group", content: testGroup},
+		testEntry{name: "var/", dir: true},
+		testEntry{name: "var/cache", dir: true, uid: 101, gid: 101},
+		testEntry{name: "var/data", content: "data", uid: 1000, gid: 101},
+	)
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	diffID, err := layer.DiffID()
+	if err != nil {
+		t.Fatalf("Failed to get diff ID: %v", err)
+	}
+
+	l := Layer{DiffID: diffID.String(), layer: layer}
+	if err := l.InitializeLayer(context.Background(), nil); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+	defer l.Close()
+
+	owners := func() map[string]string {
+		files, err := l.GetFiles(context.Background(), "var")
+		if err != nil {
+			t.Fatalf("GetFiles() error = %v", err)
+		}
+		owners := make(map[string]string)
+		for _, f := range files {
+			owners[f.Name] = f.Owner
+		}
+		return owners
+	}
+
+	want := map[string]string{"cache": "nginx:nginx", "data": "1000:nginx"}
+	if got := owners(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Owners = %v, want %v", got, want)
+	}
+
+	// Accounts of the merged image take precedence
+	accounts, err := LoadAccounts(createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/passwd", content: "app:x:1000:1000::/app:/bin/sh\n"},
+	).fs)
+	if err != nil {
+		t.Fatalf("LoadAccounts() error = %v", err)
+	}
+	l.SetAccounts(accounts)
+	want = map[string]string{"cache": "101:101", "data": "app:101"}
+	if got := owners(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Owners = %v, want %v", got, want)
+	}
+}
+
+func TestStackedAccounts(t *testing.T) {
+	content := writeTestTar(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/passwd", content: testPasswd},
+		testEntry{name: "etc/group", content: testGroup},
+	)
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	diffID, err := layer.DiffID()
+	if err != nil {
+		t.Fatalf("Failed to get diff ID: %v", err)
+	}
+	cache := NewCache("")
+	t.Cleanup(func() { cache.Cleanup() })
+
+	// The base layer isn't initialized, as the layers of the image browsed
+	base := Layer{DiffID: diffID.String(), layer: layer, layerCache: cache}
+	app := createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/passwd", content: "app:x:1000:1000::/app:/bin/sh\n"},
+	)
+	noGroups := createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/.wh.group"},
+	)
+	files := createMergeLayer(t, testEntry{name: "file", content: "x"})
+
+	tests := []struct {
+		name       string
+		layers     []Layer
+		wantUsers  map[int]string
+		wantGroups map[int]string
+	}{
+		{
+			name:      "layer alone",
+			layers:    []Layer{*app},
+			wantUsers: map[int]string{1000: "app"},
+		},
+		{
+			name:       "files of lower layers",
+			layers:     []Layer{base, *app, *files},
+			wantUsers:  map[int]string{1000: "app"},
+			wantGroups: map[int]string{0: "root", 101: "nginx"},
+		},
+		{
+			name:       "whiteout",
+			layers:     []Layer{base, *noGroups, *app},
+			wantUsers:  map[int]string{1000: "app"},
+			wantGroups: map[int]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			image := &Image{Layers: tt.layers}
+			index := len(tt.layers) - 1
+			var top *Accounts
+			if fsys := tt.layers[index].files(); fsys != nil {
+				if top, err = LoadAccounts(fsys); err != nil {
+					t.Fatalf("LoadAccounts() error = %v", err)
+				}
+			}
+
+			got, err := image.StackedAccounts(context.Background(), index, top)
+			if err != nil {
+				t.Fatalf("StackedAccounts() error = %v", err)
+			}
+			if index > 0 && !got.Complete() {
+				t.Error("Expected the accounts to be complete")
+			}
+			for id, want := range tt.wantUsers {
+				if name, ok := got.User(id); !ok || name != want {
+					t.Errorf("User(%d) = %q, %v, want %q", id, name, ok, want)
+				}
+			}
+			if name, ok := got.User(101); ok && tt.wantUsers[101] == "" {
+				t.Errorf("User(101) = %q, want no user", name)
+			}
+			for id, want := range tt.wantGroups {
+				if name, ok := got.Group(id); !ok || name != want {
+					t.Errorf("Group(%d) = %q, %v, want %q", id, name, ok, want)
+				}
+			}
+			if name, ok := got.Group(101); ok && tt.wantGroups[101] == "" {
+				t.Errorf("Group(101) = %q, want no group", name)
+			}
+
+			if index == 0 {
+				return
+			}
+			// The layers initialized to read the accounts are closed
+			if image.Layers[0].files() != nil {
+				t.Error("Expected the base layer to be left uninitialized")
+			}
+			if cache.getLayerContent(base.DiffID) != nil || cache.getLayer(base.DiffID) != "" {
+				t.Error("Expected the base layer to be released from the cache")
+			}
+		})
+	}
+}
@@ -0,0 +1,238 @@
+package container
+
+import (
+	"archive/tar"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/knqyf263/sou/tarfs"
+)
+
+// IsImageArchive reports whether ref is the path of an image archive written
+// by `docker save`, with manifest.json or, before Docker 1.10, a repositories
+// file or the json of layers, which Open reads instead of an image of the
+// Docker daemon or of a registry
+func IsImageArchive(ref string) bool {
+	f, err := os.Open(ref)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err != nil || !info.Mode().IsRegular() {
+		return false
+	}
+	// Layers are skipped by seeking, and manifest.json is written last
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			return false
+		}
+		name := path.Clean(hdr.Name)
+		if name == "manifest.json" || name == legacyRepositories || isLegacyLayerJSON(name) {
+			return true
+		}
+	}
+}
+
+// isLegacyLayerJSON reports whether name is the json of a layer of a legacy
+// image archive, in a directory named by the hex ID of the layer
+func isLegacyLayerJSON(name string) bool {
+	id, ok := strings.CutSuffix(name, "/json")
+	if !ok || len(id) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// archiveFileImage opens an image archive given by path
+func archiveFileImage(path string, o *options) (*Image, error) {
+	img, err := openArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	if o.platform != nil {
+		configFile, err := img.ConfigFile()
+		if err != nil {
+			img.Close()
+			return nil, fmt.Errorf("failed to get config file: %w", err)
+		}
+		if p := configFile.Platform(); p != nil && !p.Satisfies(*o.platform) {
+			img.Close()
+			return nil, &kindError{kind: ErrNotFound, err: fmt.Errorf("image archive is for %s, not %s", p, o.platform)}
+		}
+	}
+	image, err := createImageFromV1(img, path)
+	if err != nil {
+		img.Close()
+		return nil, err
+	}
+	o.progress(Progress{Stage: StageDone})
+	return image, nil
+}
+
+// legacyRepositories maps the repositories and tags of a legacy image
+// archive to the IDs of their top layers
+const legacyRepositories = "repositories"
+
+// legacyLayer is the json file of a layer of a legacy image archive. The
+// one of the top layer has the config of the image.
+type legacyLayer struct {
+	v1.ConfigFile
+	ID              string `json:"id"`
+	Parent          string `json:"parent"`
+	Comment         string `json:"comment"`
+	ContainerConfig struct {
+		Cmd []string `json:"Cmd"`
+	} `json:"container_config"`
+}
+
+// legacyDescriptor converts an archive saved by Docker before 1.10, which
+// has a directory with json, layer.tar and VERSION for each layer, into a
+// descriptor and a config as Docker does when loading it. The diff IDs are
+// computed by reading the layers, and each layer becomes an entry of the
+// history.
+func legacyDescriptor(archive *tarfs.FS) (tarball.Descriptor, []byte, error) {
+	entries, err := archive.ReadDir(".")
+	if err != nil {
+		return tarball.Descriptor{}, nil, err
+	}
+	layers := make(map[string]*legacyLayer)
+	parents := make(map[string]bool)
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		raw, err := readArchiveFile(archive, path.Join(e.Name(), "json"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return tarball.Descriptor{}, nil, err
+		}
+		var l legacyLayer
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return tarball.Descriptor{}, nil, fmt.Errorf("failed to parse the json of layer %s: %w", e.Name(), err)
+		}
+		if l.ID == "" {
+			l.ID = e.Name()
+		}
+		layers[l.ID] = &l
+		if l.Parent != "" {
+			parents[l.Parent] = true
+		}
+	}
+	if len(layers) == 0 {
+		return tarball.Descriptor{}, nil, errors.New("image archive has neither manifest.json nor legacy layers")
+	}
+
+	top, repoTags, err := legacyTop(archive, layers, parents)
+	if err != nil {
+		return tarball.Descriptor{}, nil, err
+	}
+
+	// Walk the parents from the top layer, then put the base layer first
+	var chain []*legacyLayer
+	for id := top; id != ""; {
+		l, ok := layers[id]
+		if !ok {
+			return tarball.Descriptor{}, nil, fmt.Errorf("layer %s is not in the image archive", id)
+		}
+		if len(chain) == len(layers) {
+			return tarball.Descriptor{}, nil, errors.New("the layers of the image archive have a cycle")
+		}
+		chain = append(chain, l)
+		id = l.Parent
+	}
+
+	config := layers[top].ConfigFile
+	config.History = nil
+	config.RootFS = v1.RootFS{Type: "layers"}
+	descriptor := tarball.Descriptor{RepoTags: repoTags}
+	for i := len(chain) - 1; i >= 0; i-- {
+		l := chain[i]
+		layerPath := path.Join(l.ID, "layer.tar")
+		diffID, err := legacyDiffID(archive, layerPath)
+		if err != nil {
+			return tarball.Descriptor{}, nil, fmt.Errorf("failed to read layer %s: %w", l.ID, err)
+		}
+		descriptor.Layers = append(descriptor.Layers, layerPath)
+		config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+		config.History = append(config.History, v1.History{
+			Author:    l.Author,
+			Created:   l.Created,
+			CreatedBy: strings.Join(l.ContainerConfig.Cmd, " "),
+			Comment:   l.Comment,
+		})
+	}
+	rawConfig, err := json.Marshal(config)
+	if err != nil {
+		return tarball.Descriptor{}, nil, err
+	}
+	return descriptor, rawConfig, nil
+}
+
+// legacyTop returns the ID of the top layer of the single image of the
+// archive and its tags: the layer the repositories file points to, or the
+// only one that is not a parent without the file
+func legacyTop(archive *tarfs.FS, layers map[string]*legacyLayer, parents map[string]bool) (string, []string, error) {
+	raw, err := readArchiveFile(archive, legacyRepositories)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", nil, err
+	}
+
+	var tops []string
+	var repoTags []string
+	if err == nil {
+		var repositories map[string]map[string]string
+		if err := json.Unmarshal(raw, &repositories); err != nil {
+			return "", nil, fmt.Errorf("failed to parse repositories: %w", err)
+		}
+		for repo, tags := range repositories {
+			for tag, id := range tags {
+				repoTags = append(repoTags, repo+":"+tag)
+				if !slices.Contains(tops, id) {
+					tops = append(tops, id)
+				}
+			}
+		}
+	}
+	slices.Sort(repoTags)
+	if len(tops) == 0 {
+		for id := range layers {
+			if !parents[id] {
+				tops = append(tops, id)
+			}
+		}
+	}
+	if len(tops) != 1 {
+		return "", nil, fmt.Errorf("image archive must contain a single image, found %d", len(tops))
+	}
+	return tops[0], repoTags, nil
+}
+
+// legacyDiffID returns the digest of the uncompressed layer
+func legacyDiffID(archive *tarfs.FS, layerPath string) (v1.Hash, error) {
+	f, err := archive.Open(layerPath)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	defer f.Close()
+	rc, err := decompress(f)
+	if err != nil {
+		return v1.Hash{}, err
+	}
+	defer rc.Close()
+	h, _, err := v1.SHA256(rc)
+	return h, err
+}
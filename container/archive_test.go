@@ -0,0 +1,198 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// legacyTestLayer is a layer of a legacy image archive
+type legacyTestLayer struct {
+	id, parent string
+	cmd        []string
+	files      []storageTestFile
+}
+
+// writeLegacyArchive writes an image archive as `docker save` did before
+// Docker 1.10, with a directory for each layer and the repositories file
+// unless repositories is nil. The top layer has the config of the image.
+func writeLegacyArchive(t *testing.T, path string, layers []legacyTestLayer, repositories map[string]map[string]string) {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	writeFile := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Size: int64(len(content)), Mode: 0o644, Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i, l := range layers {
+		if err := tw.WriteHeader(&tar.Header{Name: l.id + "/", Mode: 0o755, Typeflag: tar.TypeDir}); err != nil {
+			t.Fatal(err)
+		}
+		meta := map[string]any{
+			"id":               l.id,
+			"created":          "2015-06-01T00:00:0" + string(rune('0'+i)) + "Z",
+			"container_config": map[string]any{"Cmd": l.cmd},
+		}
+		if l.parent != "" {
+			meta["parent"] = l.parent
+		}
+		if i == len(layers)-1 {
+			meta["architecture"] = "amd64"
+			meta["os"] = "linux"
+			meta["config"] = map[string]any{"Cmd": []string{"/bin/sh"}}
+		}
+		raw, err := json.Marshal(meta)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(l.id+"/VERSION", []byte("1.0"))
+		writeFile(l.id+"/json", raw)
+
+		var layer bytes.Buffer
+		lw := tar.NewWriter(&layer)
+		for _, f := range l.files {
+			if err := lw.WriteHeader(&tar.Header{Name: f.name, Size: int64(len(f.content)), Mode: 0o644, Typeflag: tar.TypeReg}); err != nil {
+				t.Fatal(err)
+			}
+			lw.Write([]byte(f.content))
+		}
+		if err := lw.Close(); err != nil {
+			t.Fatal(err)
+		}
+		writeFile(l.id+"/layer.tar", layer.Bytes())
+	}
+	if repositories != nil {
+		raw, err := json.Marshal(repositories)
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile("repositories", raw)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, buf.Bytes())
+}
+
+// Layers of legacy archives are identified by random hex IDs
+var (
+	legacyBaseID = strings.Repeat("b", 64)
+	legacyTopID  = strings.Repeat("c", 64)
+)
+
+var legacyTestLayers = []legacyTestLayer{
+	{id: legacyBaseID, cmd: []string{"/bin/sh", "-c", "#(nop) ADD file:rootfs in /"}, files: []storageTestFile{{"etc/hostname", "base\n"}, {"etc/os-release", "ID=test\n"}}},
+	{id: legacyTopID, parent: legacyBaseID, cmd: []string{"/bin/sh", "-c", "echo app > /etc/hostname"}, files: []storageTestFile{{"etc/hostname", "app\n"}}},
+}
+
+func TestLegacyArchive(t *testing.T) {
+	tests := []struct {
+		name         string
+		repositories map[string]map[string]string
+	}{
+		{name: "repositories", repositories: map[string]map[string]string{"sou.test/legacy": {"latest": legacyTopID, "1.0": legacyTopID}}},
+		{name: "without repositories"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "legacy.tar")
+			writeLegacyArchive(t, path, legacyTestLayers, tt.repositories)
+			if !IsImageArchive(path) {
+				t.Errorf("IsImageArchive(%q) = false, want true", path)
+			}
+
+			image, err := Open(context.Background(), path, WithCacheDir(t.TempDir()))
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer image.Close()
+			if len(image.Layers) != 2 {
+				t.Fatalf("Expected 2 layers, got %d", len(image.Layers))
+			}
+			// Layers are listed from the newest
+			if got, want := image.Layers[0].Command, "echo app > /etc/hostname"; !strings.Contains(got, want) {
+				t.Errorf("Command = %q, want %q", got, want)
+			}
+
+			merged := MergeLayers(image.Layers...)
+			if err := merged.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+				t.Fatalf("InitializeLayer() error = %v", err)
+			}
+			for file, want := range map[string]string{"etc/hostname": "app\n", "etc/os-release": "ID=test\n"} {
+				content, err := merged.ReadFile(context.Background(), file)
+				if err != nil {
+					t.Fatalf("ReadFile(%q) error = %v", file, err)
+				}
+				if string(content) != want {
+					t.Errorf("ReadFile(%q) = %q, want %q", file, content, want)
+				}
+			}
+		})
+	}
+}
+
+func TestLegacyArchiveErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		layers       []legacyTestLayer
+		repositories map[string]map[string]string
+	}{
+		{
+			name:         "several images",
+			layers:       legacyTestLayers,
+			repositories: map[string]map[string]string{"sou.test/legacy": {"base": legacyBaseID, "top": legacyTopID}},
+		},
+		{
+			name:   "missing parent",
+			layers: []legacyTestLayer{{id: legacyTopID, parent: legacyBaseID}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "legacy.tar")
+			writeLegacyArchive(t, path, tt.layers, tt.repositories)
+			if _, err := openArchive(path); err == nil {
+				t.Error("openArchive() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestIsImageArchive(t *testing.T) {
+	dir := t.TempDir()
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	rootfs := filepath.Join(dir, "rootfs.tar")
+	f, err := os.Create(rootfs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.ReadFrom(rc); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	text := filepath.Join(dir, "notes.txt")
+	writeTestFile(t, text, []byte("not an archive"))
+
+	for _, ref := range []string{rootfs, text, dir, filepath.Join(dir, "missing.tar"), "alpine:latest"} {
+		if IsImageArchive(ref) {
+			t.Errorf("IsImageArchive(%q) = true, want false", ref)
+		}
+	}
+}
@@ -4,76 +4,232 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 )
 
-var (
-	cacheDir     string
-	cacheDirOnce sync.Once
-	cacheMutex   sync.RWMutex
-	layerCache   = make(map[string]string) // DiffID -> cache file path
-)
+// Cache stores extracted layers and images saved from the Docker daemon so
+// that they are only fetched once. Entries are counted by the layers and
+// images using them, as images opened on the same cache share layers, and
+// removed when the last one is closed. A Cache is safe for concurrent use.
+type Cache struct {
+	dir     string
+	temp    bool // dir is created on first use and removed by Cleanup
+	dirOnce sync.Once
+	dirErr  error
+
+	mu     sync.RWMutex
+	layers map[string]string // DiffID -> cache file path
+	memory map[string][]byte // DiffID -> layer content for small layers
+	images map[string]string // Image ID -> saved image archive path
+
+	layerRefs map[string]int // DiffID -> number of layers using it
+	imageRefs map[string]int // Image ID -> number of images using it
+}
+
+// NewCache returns a Cache storing its files in dir. If dir is empty, a
+// temporary directory is created on first use and removed by Cleanup.
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir:    dir,
+		temp:   dir == "",
+		layers: make(map[string]string),
+		memory: make(map[string][]byte),
+		images: make(map[string]string),
+
+		layerRefs: make(map[string]int),
+		imageRefs: make(map[string]int),
+	}
+}
+
+// defaultCache is used by NewImage and CleanupCache
+var defaultCache = NewCache("")
 
-// initCacheDir initializes the cache directory
-func initCacheDir() error {
-	var err error
-	cacheDirOnce.Do(func() {
+// CleanupCache removes all files of the default cache
+func CleanupCache() error {
+	return defaultCache.Cleanup()
+}
+
+// initDir initializes the cache directory
+func (c *Cache) initDir() (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.dirOnce.Do(func() {
+		if !c.temp {
+			if err := os.MkdirAll(c.dir, 0o755); err != nil {
+				c.dirErr = fmt.Errorf("failed to create cache directory: %w", err)
+			}
+			return
+		}
 		// Create a temporary directory for the cache
-		cacheDir, err = os.MkdirTemp("", "sou-cache-*")
+		dir, err := os.MkdirTemp("", "sou-cache-*")
 		if err != nil {
-			err = fmt.Errorf("failed to create cache directory: %w", err)
+			c.dirErr = fmt.Errorf("failed to create cache directory: %w", err)
 			return
 		}
+		c.dir = dir
 	})
-	return err
+	return c.dir, c.dirErr
 }
 
-// getCachedLayer returns the cached layer file path if it exists
-func getCachedLayer(diffID string) string {
-	cacheMutex.RLock()
-	defer cacheMutex.RUnlock()
-	return layerCache[diffID]
+// getLayer returns the cached layer file path if it exists
+func (c *Cache) getLayer(diffID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.layers[diffID]
 }
 
-// cacheLayer caches the layer file
-func cacheLayer(diffID, filePath string) {
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
-	layerCache[diffID] = filePath
+// putLayer caches the layer file
+func (c *Cache) putLayer(diffID, filePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.layers[diffID] = filePath
 }
 
-// CleanupCache removes all cached files and the cache directory
-func CleanupCache() error {
-	if cacheDir == "" {
+// getLayerContent returns the in-memory layer content if it exists
+func (c *Cache) getLayerContent(diffID string) []byte {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.memory[diffID]
+}
+
+// putLayerContent caches the layer content in memory
+func (c *Cache) putLayerContent(diffID string, content []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.memory[diffID] = content
+}
+
+// getImage returns the saved image archive path if it exists
+func (c *Cache) getImage(imageID string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.images[imageID]
+}
+
+// putImage caches the saved image archive
+func (c *Cache) putImage(imageID, filePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.images[imageID] = filePath
+}
+
+// acquireLayer records that a layer uses the cached content of diffID
+func (c *Cache) acquireLayer(diffID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.layerRefs[diffID]++
+}
+
+// releaseLayer records that a layer no longer uses the cached content of
+// diffID, and removes it once no layer does
+func (c *Cache) releaseLayer(diffID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.layerRefs[diffID] > 1 {
+		c.layerRefs[diffID]--
 		return nil
 	}
+	delete(c.layerRefs, diffID)
+	delete(c.memory, diffID)
+	path, ok := c.layers[diffID]
+	if !ok {
+		return nil
+	}
+	delete(c.layers, diffID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached layer: %w", err)
+	}
+	return nil
+}
 
-	// Remove all cached files
-	cacheMutex.Lock()
-	defer cacheMutex.Unlock()
+// acquireImage records that an image uses the saved archive of imageID
+func (c *Cache) acquireImage(imageID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.imageRefs[imageID]++
+}
+
+// releaseImage records that an image no longer uses the saved archive of
+// imageID, and removes it once no image does
+func (c *Cache) releaseImage(imageID string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.imageRefs[imageID] > 1 {
+		c.imageRefs[imageID]--
+		return nil
+	}
+	delete(c.imageRefs, imageID)
+	path, ok := c.images[imageID]
+	if !ok {
+		return nil
+	}
+	delete(c.images, imageID)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove cached image: %w", err)
+	}
+	return nil
+}
+
+// Cleanup removes all cached files. A temporary cache directory is removed as
+// well. The cache can still be used afterwards.
+func (c *Cache) Cleanup() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	for _, path := range layerCache {
-		if err := os.Remove(path); err != nil {
-			// Continue even if there's an error
-			fmt.Fprintf(os.Stderr, "failed to remove cached file %s: %v\n", path, err)
+	// Drop in-memory layers
+	c.memory = make(map[string][]byte)
+
+	// Remove all cached files
+	for _, paths := range []map[string]string{c.layers, c.images} {
+		for _, path := range paths {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				// Continue even if there's an error
+				debug("Failed to remove cached file %s: %v", path, err)
+			}
 		}
 	}
 
-	// Clear the cache map
-	layerCache = make(map[string]string)
+	// Clear the cache maps
+	c.layers = make(map[string]string)
+	c.images = make(map[string]string)
+	c.layerRefs = make(map[string]int)
+	c.imageRefs = make(map[string]int)
+
+	if !c.temp || c.dir == "" {
+		return nil
+	}
 
 	// Remove the cache directory
-	if err := os.RemoveAll(cacheDir); err != nil {
+	if err := os.RemoveAll(c.dir); err != nil {
 		return fmt.Errorf("failed to remove cache directory: %w", err)
 	}
 
+	// Allow the cache directory to be recreated on next use
+	c.dir = ""
+	c.dirErr = nil
+	c.dirOnce = sync.Once{}
+
 	return nil
 }
 
-// getCacheFilePath returns a new cache file path
-func getCacheFilePath() (string, error) {
-	if err := initCacheDir(); err != nil {
+// createLayerFile creates a new cache file for a layer
+func (c *Cache) createLayerFile() (*os.File, error) {
+	dir, err := c.initDir()
+	if err != nil {
+		return nil, err
+	}
+	return os.CreateTemp(dir, "layer-*.tar")
+}
+
+// imageArchivePath returns the cache file path for a saved image archive
+func (c *Cache) imageArchivePath(imageID string) (string, error) {
+	dir, err := c.initDir()
+	if err != nil {
 		return "", err
 	}
-	return filepath.Join(cacheDir, fmt.Sprintf("layer-%d.tar", len(layerCache))), nil
+	return filepath.Join(dir, fmt.Sprintf("image-%s.tar", strings.TrimPrefix(imageID, "sha256:"))), nil
 }
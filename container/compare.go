@@ -0,0 +1,265 @@
+package container
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"maps"
+	"slices"
+	"strings"
+)
+
+// Comparison splits the layers of two images into the base they share and
+// the layers each of them adds on top of it. Only the added layers differ,
+// so they are the only ones worth comparing file by file.
+type Comparison struct {
+	// Shared are the oldest layers both images have in the same order,
+	// from the newest to the oldest as in Image.Layers
+	Shared []Layer
+	// OnlyA and OnlyB are the layers of each image on top of the shared
+	// base, from the newest to the oldest
+	OnlyA []Layer
+	OnlyB []Layer
+}
+
+// CompareLayers finds the base shared by two images. Layers are the same if
+// they have the same DiffID, and a layer is shared only if the layers below
+// it are, as the content of a layer depends on them.
+func CompareLayers(a, b *Image) Comparison {
+	n := 0
+	for n < len(a.Layers) && n < len(b.Layers) &&
+		a.Layers[len(a.Layers)-1-n].DiffID == b.Layers[len(b.Layers)-1-n].DiffID {
+		n++
+	}
+	return Comparison{
+		Shared: a.Layers[len(a.Layers)-n:],
+		OnlyA:  a.Layers[:len(a.Layers)-n],
+		OnlyB:  b.Layers[:len(b.Layers)-n],
+	}
+}
+
+// SharedSize returns the bytes of the shared base, which are stored and
+// pulled once for both images
+func (c Comparison) SharedSize() int64 {
+	return layersSize(c.Shared)
+}
+
+// UniqueSize returns the bytes of the layers only in each image
+func (c Comparison) UniqueSize() (a, b int64) {
+	return layersSize(c.OnlyA), layersSize(c.OnlyB)
+}
+
+// ReadFile reads the file at the slash-separated path p of image b, with
+// its layers stacked. The layers b adds on the shared base are initialized
+// from the newest until one of them has the file or deletes it, so that the
+// shared base is only read when the file is in it. The shared base is read
+// from the layers of image a, which were likely loaded already. A missing
+// file is an fs.ErrNotExist.
+func (c Comparison) ReadFile(ctx context.Context, p string) ([]byte, error) {
+	var layers []*Layer
+	defer func() {
+		for _, l := range layers {
+			l.Close()
+		}
+	}()
+	read := func(upper bool) ([]byte, error) {
+		m, err := Merge(layers...)
+		if err != nil {
+			return nil, err
+		}
+		m.upper = upper
+		return fs.ReadFile(m, cleanPath(p))
+	}
+
+	for _, l := range c.OnlyB {
+		if !l.IsArchive() {
+			continue
+		}
+		layer, err := l.initialized(ctx)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+		content, err := read(true)
+		if !errors.Is(err, errBelow) {
+			return content, err
+		}
+	}
+	for _, l := range c.Shared {
+		if !l.IsArchive() {
+			continue
+		}
+		layer, err := l.initialized(ctx)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return read(false)
+}
+
+func layersSize(layers []Layer) int64 {
+	var size int64
+	for _, l := range layers {
+		size += l.Size
+	}
+	return size
+}
+
+// ChangeKind is how a value of the config differs between two images
+type ChangeKind int
+
+const (
+	ChangeAdded ChangeKind = iota
+	ChangeRemoved
+	ChangeModified
+)
+
+// ConfigChange is a value of the config that differs between two images
+type ConfigChange struct {
+	// Field is the field of the config, as named in the config: Entrypoint,
+	// Cmd, User, WorkingDir, ExposedPorts, Volumes, StopSignal, Env, Labels
+	// or History
+	Field string
+	// Key is the variable, label, port or volume of the fields holding
+	// several of them, and empty otherwise
+	Key  string
+	Kind ChangeKind
+	// Old and New are the values in each image. Commands are in the exec
+	// form of JSON arrays, and History are the commands of the build steps.
+	Old, New string
+}
+
+// CompareConfig returns the changes of the config from image a to image b,
+// field by field in the order of ConfigChange.Field. Variables and labels
+// are in the order of their keys. The build steps of a that b doesn't build
+// on are removed and the ones of b added, in the order they were built.
+func CompareConfig(a, b *Image) ([]ConfigChange, error) {
+	ra, err := a.Runtime()
+	if err != nil {
+		return nil, err
+	}
+	rb, err := b.Runtime()
+	if err != nil {
+		return nil, err
+	}
+	labelsA, err := a.Labels()
+	if err != nil {
+		return nil, err
+	}
+	labelsB, err := b.Labels()
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []ConfigChange
+	changes = appendValueChange(changes, "Entrypoint", execForm(ra.Entrypoint), execForm(rb.Entrypoint))
+	changes = appendValueChange(changes, "Cmd", execForm(ra.Cmd), execForm(rb.Cmd))
+	changes = appendValueChange(changes, "User", ra.User, rb.User)
+	changes = appendValueChange(changes, "WorkingDir", ra.WorkingDir, rb.WorkingDir)
+	changes = appendSetChanges(changes, "ExposedPorts", ra.ExposedPorts, rb.ExposedPorts)
+	changes = appendSetChanges(changes, "Volumes", ra.Volumes, rb.Volumes)
+	changes = appendValueChange(changes, "StopSignal", ra.StopSignal, rb.StopSignal)
+	changes = appendMapChanges(changes, "Env", envMap(ra.Env), envMap(rb.Env))
+	changes = appendMapChanges(changes, "Labels", labelsA, labelsB)
+
+	stepsA, stepsB := historyCommands(a.History), historyCommands(b.History)
+	n := 0
+	for n < len(stepsA) && n < len(stepsB) && stepsA[n] == stepsB[n] {
+		n++
+	}
+	for _, c := range stepsA[n:] {
+		changes = append(changes, ConfigChange{Field: "History", Kind: ChangeRemoved, Old: c})
+	}
+	for _, c := range stepsB[n:] {
+		changes = append(changes, ConfigChange{Field: "History", Kind: ChangeAdded, New: c})
+	}
+	return changes, nil
+}
+
+// appendValueChange appends the change of a field holding one value, which
+// is unset if empty
+func appendValueChange(changes []ConfigChange, field, old, new string) []ConfigChange {
+	switch {
+	case old == new:
+		return changes
+	case old == "":
+		return append(changes, ConfigChange{Field: field, Kind: ChangeAdded, New: new})
+	case new == "":
+		return append(changes, ConfigChange{Field: field, Kind: ChangeRemoved, Old: old})
+	}
+	return append(changes, ConfigChange{Field: field, Kind: ChangeModified, Old: old, New: new})
+}
+
+// appendSetChanges appends the keys only in old as removed, followed by the
+// keys only in new as added
+func appendSetChanges(changes []ConfigChange, field string, old, new []string) []ConfigChange {
+	for _, k := range old {
+		if !slices.Contains(new, k) {
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: ChangeRemoved})
+		}
+	}
+	for _, k := range new {
+		if !slices.Contains(old, k) {
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: ChangeAdded})
+		}
+	}
+	return changes
+}
+
+// appendMapChanges appends the keys added, removed or set to another value,
+// in the order of the keys
+func appendMapChanges(changes []ConfigChange, field string, old, new map[string]string) []ConfigChange {
+	keys := slices.Collect(maps.Keys(old))
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		o, inOld := old[k]
+		n, inNew := new[k]
+		switch {
+		case !inOld:
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: ChangeAdded, New: n})
+		case !inNew:
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: ChangeRemoved, Old: o})
+		case o != n:
+			changes = append(changes, ConfigChange{Field: field, Key: k, Kind: ChangeModified, Old: o, New: n})
+		}
+	}
+	return changes
+}
+
+// envMap returns the values of the variables given as KEY=value. A variable
+// set twice has the last value, as in the container.
+func envMap(env []string) map[string]string {
+	m := make(map[string]string, len(env))
+	for _, e := range env {
+		k, v, _ := strings.Cut(e, "=")
+		m[k] = v
+	}
+	return m
+}
+
+// execForm returns the arguments of a command as a JSON array, or an empty
+// string if there are none
+func execForm(args []string) string {
+	if len(args) == 0 {
+		return ""
+	}
+	b, _ := json.Marshal(args)
+	return string(b)
+}
+
+// historyCommands returns the commands of the build steps from the oldest
+// to the newest
+func historyCommands(history []History) []string {
+	commands := make([]string, len(history))
+	for i, h := range history {
+		commands[len(history)-1-i] = h.Command
+	}
+	return commands
+}
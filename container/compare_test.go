@@ -0,0 +1,221 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+func TestCompareLayers(t *testing.T) {
+	layer := func(diffID string, size int64) Layer {
+		return Layer{DiffID: diffID, Size: size}
+	}
+	diffIDs := func(layers []Layer) []string {
+		var ids []string
+		for _, l := range layers {
+			ids = append(ids, l.DiffID)
+		}
+		return ids
+	}
+
+	tests := []struct {
+		name       string
+		a, b       []Layer
+		shared     []string
+		onlyA      []string
+		onlyB      []string
+		sharedSize int64
+		uniqueA    int64
+		uniqueB    int64
+	}{
+		{
+			name:       "rebuilt on the same base",
+			a:          []Layer{layer("app1", 10), layer("deps", 20), layer("base", 100)},
+			b:          []Layer{layer("app2", 15), layer("deps", 20), layer("base", 100)},
+			shared:     []string{"deps", "base"},
+			onlyA:      []string{"app1"},
+			onlyB:      []string{"app2"},
+			sharedSize: 120,
+			uniqueA:    10,
+			uniqueB:    15,
+		},
+		{
+			name:       "one image is the base of the other",
+			a:          []Layer{layer("base", 100)},
+			b:          []Layer{layer("app", 5), layer("base", 100)},
+			shared:     []string{"base"},
+			onlyB:      []string{"app"},
+			sharedSize: 100,
+			uniqueB:    5,
+		},
+		{
+			// The same layer on top of different bases isn't shared
+			name:    "different bases",
+			a:       []Layer{layer("app", 10), layer("debian", 100)},
+			b:       []Layer{layer("app", 10), layer("alpine", 5)},
+			onlyA:   []string{"app", "debian"},
+			onlyB:   []string{"app", "alpine"},
+			uniqueA: 110,
+			uniqueB: 15,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := CompareLayers(&Image{Layers: tt.a}, &Image{Layers: tt.b})
+			if got := diffIDs(c.Shared); !reflect.DeepEqual(got, tt.shared) {
+				t.Errorf("Shared = %v, want %v", got, tt.shared)
+			}
+			if got := diffIDs(c.OnlyA); !reflect.DeepEqual(got, tt.onlyA) {
+				t.Errorf("OnlyA = %v, want %v", got, tt.onlyA)
+			}
+			if got := diffIDs(c.OnlyB); !reflect.DeepEqual(got, tt.onlyB) {
+				t.Errorf("OnlyB = %v, want %v", got, tt.onlyB)
+			}
+			if got := c.SharedSize(); got != tt.sharedSize {
+				t.Errorf("SharedSize() = %d, want %d", got, tt.sharedSize)
+			}
+			if a, b := c.UniqueSize(); a != tt.uniqueA || b != tt.uniqueB {
+				t.Errorf("UniqueSize() = %d, %d, want %d, %d", a, b, tt.uniqueA, tt.uniqueB)
+			}
+		})
+	}
+}
+
+func TestComparisonReadFile(t *testing.T) {
+	content := writeTestTar(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/os-release", content: "ID=base\n"},
+		testEntry{name: "etc/hostname", content: "base\n"},
+		testEntry{name: "etc/motd", content: "welcome\n"},
+	)
+	opened := 0
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		opened++
+		return io.NopCloser(bytes.NewReader(content)), nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	// The layer is read to compute its digests
+	opened = 0
+	cache := NewCache("")
+	t.Cleanup(func() { cache.Cleanup() })
+	base := Layer{DiffID: "sha256:base", layer: layer, layerCache: cache}
+
+	appA := createMergeLayer(t, testEntry{name: "app", content: "a"})
+	appA.DiffID = "sha256:a"
+	appB := createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/hostname", content: "app\n"},
+		testEntry{name: "etc/.wh.motd"},
+		testEntry{name: "etc/name", link: "hostname"},
+	)
+	appB.DiffID = "sha256:b"
+	c := CompareLayers(&Image{Layers: []Layer{*appA, base}}, &Image{Layers: []Layer{*appB, base}})
+
+	tests := []struct {
+		path       string
+		want       string
+		wantErr    error
+		wantOpened int
+	}{
+		// The files the layers of b decide don't need the shared base
+		{path: "/etc/hostname", want: "app\n"},
+		{path: "/etc/name", want: "app\n"},
+		{path: "/etc/motd", wantErr: fs.ErrNotExist},
+		{path: "/etc/os-release", want: "ID=base\n", wantOpened: 1},
+		{path: "/missing", wantErr: fs.ErrNotExist, wantOpened: 2},
+	}
+	for _, tt := range tests {
+		got, err := c.ReadFile(context.Background(), tt.path)
+		if tt.wantErr != nil {
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("ReadFile(%s) error = %v, want %v", tt.path, err, tt.wantErr)
+			}
+		} else if err != nil {
+			t.Errorf("ReadFile(%s) error = %v", tt.path, err)
+		} else if string(got) != tt.want {
+			t.Errorf("ReadFile(%s) = %q, want %q", tt.path, got, tt.want)
+		}
+		if opened != tt.wantOpened {
+			t.Errorf("ReadFile(%s) opened the shared base %d times, want %d", tt.path, opened, tt.wantOpened)
+		}
+	}
+
+	// The layers initialized to read the file are closed
+	if c.Shared[0].files() != nil || cache.getLayerContent(base.DiffID) != nil {
+		t.Error("Expected the shared base to be left uninitialized")
+	}
+}
+
+func TestCompareConfig(t *testing.T) {
+	image := func(config v1.Config, history ...string) *Image {
+		img, err := mutate.Config(empty.Image, config)
+		if err != nil {
+			t.Fatalf("Failed to create image: %v", err)
+		}
+		i := &Image{img: img}
+		for _, c := range history {
+			// History is from the newest to the oldest
+			i.History = append([]History{{Command: c, Layer: -1}}, i.History...)
+		}
+		return i
+	}
+	a := image(v1.Config{
+		Entrypoint:   []string{"/entrypoint.sh"},
+		Cmd:          []string{"app", "--port", "8080"},
+		Env:          []string{"PATH=/usr/bin", "DEBUG=1", "VERSION=1.0"},
+		User:         "app",
+		ExposedPorts: map[string]struct{}{"8080/tcp": {}},
+		Labels:       map[string]string{"version": "1.0", "team": "web"},
+	}, "ADD rootfs.tar.gz /", "ENV VERSION=1.0", "COPY app /app")
+	b := image(v1.Config{
+		Entrypoint:   []string{"/entrypoint.sh"},
+		Cmd:          []string{"app", "--port", "9090"},
+		Env:          []string{"PATH=/usr/bin", "VERSION=1.1", "LOG=json"},
+		WorkingDir:   "/app",
+		ExposedPorts: map[string]struct{}{"9090/tcp": {}},
+		Labels:       map[string]string{"version": "1.1", "team": "web"},
+	}, "ADD rootfs.tar.gz /", "ENV VERSION=1.1", "COPY app /app")
+
+	got, err := CompareConfig(a, b)
+	if err != nil {
+		t.Fatalf("CompareConfig() error = %v", err)
+	}
+	want := []ConfigChange{
+		{Field: "Cmd", Kind: ChangeModified, Old: `["app","--port","8080"]`, New: `["app","--port","9090"]`},
+		{Field: "User", Kind: ChangeRemoved, Old: "app"},
+		{Field: "WorkingDir", Kind: ChangeAdded, New: "/app"},
+		{Field: "ExposedPorts", Key: "8080/tcp", Kind: ChangeRemoved},
+		{Field: "ExposedPorts", Key: "9090/tcp", Kind: ChangeAdded},
+		{Field: "Env", Key: "DEBUG", Kind: ChangeRemoved, Old: "1"},
+		{Field: "Env", Key: "LOG", Kind: ChangeAdded, New: "json"},
+		{Field: "Env", Key: "VERSION", Kind: ChangeModified, Old: "1.0", New: "1.1"},
+		{Field: "Labels", Key: "version", Kind: ChangeModified, Old: "1.0", New: "1.1"},
+		{Field: "History", Kind: ChangeRemoved, Old: "ENV VERSION=1.0"},
+		{Field: "History", Kind: ChangeRemoved, Old: "COPY app /app"},
+		{Field: "History", Kind: ChangeAdded, New: "ENV VERSION=1.1"},
+		{Field: "History", Kind: ChangeAdded, New: "COPY app /app"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("CompareConfig() = %+v, want %+v", got, want)
+	}
+
+	// The same config has no changes
+	got, err = CompareConfig(a, a)
+	if err != nil {
+		t.Fatalf("CompareConfig() error = %v", err)
+	}
+	if len(got) != 0 {
+		t.Errorf("CompareConfig() = %+v, want no changes", got)
+	}
+}
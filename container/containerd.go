@@ -0,0 +1,297 @@
+package container
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// containerdConfigPath is the config of containerd, whose root is read
+var containerdConfigPath = "/etc/containerd/config.toml"
+
+// containerdRoot returns the root directory of containerd: the root of its
+// config, the directory of rootless containerd for other users than root if
+// it exists, or the default one
+func containerdRoot() string {
+	if values, err := readTOML(containerdConfigPath); err == nil && len(values["root"]) > 0 {
+		return values["root"][0]
+	}
+	if os.Geteuid() > 0 {
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if home, err := os.UserHomeDir(); dataHome == "" && err == nil {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		if dir := filepath.Join(dataHome, "containerd"); dataHome != "" && dirExists(dir) {
+			return dir
+		}
+	}
+	return "/var/lib/containerd"
+}
+
+func dirExists(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.IsDir()
+}
+
+// containerdRecord is an image of the metadata database of containerd
+type containerdRecord struct {
+	name   string
+	target v1.Descriptor
+}
+
+// readContainerdImages returns the images of the metadata database of
+// containerd by namespace. containerd locks the database while it runs, so
+// a copy of it is read.
+func readContainerdImages(root string) (map[string][]containerdRecord, error) {
+	db, err := os.Open(filepath.Join(root, "io.containerd.metadata.v1.bolt", "meta.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the metadata of containerd: %w", err)
+	}
+	defer db.Close()
+	tmp, err := os.CreateTemp("", "sou-meta-*.db")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, db)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to copy the metadata of containerd: %w", err)
+	}
+
+	bdb, err := bolt.Open(tmp.Name(), 0o600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the metadata of containerd: %w", err)
+	}
+	defer bdb.Close()
+
+	images := make(map[string][]containerdRecord)
+	err = bdb.View(func(tx *bolt.Tx) error {
+		version := tx.Bucket([]byte("v1"))
+		if version == nil {
+			return errors.New("unsupported metadata of containerd")
+		}
+		return version.ForEach(func(namespace, v []byte) error {
+			bucket := version.Bucket(namespace)
+			if v != nil || bucket == nil || bucket.Bucket([]byte("images")) == nil {
+				return nil
+			}
+			imagesBucket := bucket.Bucket([]byte("images"))
+			return imagesBucket.ForEach(func(imageName, v []byte) error {
+				image := imagesBucket.Bucket(imageName)
+				if v != nil || image == nil || image.Bucket([]byte("target")) == nil {
+					return nil
+				}
+				target := image.Bucket([]byte("target"))
+				digest, err := v1.NewHash(string(target.Get([]byte("digest"))))
+				if err != nil {
+					debug("Skipping image %s of containerd: %v", imageName, err)
+					return nil
+				}
+				size, _ := binary.Varint(target.Get([]byte("size")))
+				images[string(namespace)] = append(images[string(namespace)], containerdRecord{
+					name: string(imageName),
+					target: v1.Descriptor{
+						MediaType: types.MediaType(target.Get([]byte("mediatype"))),
+						Digest:    digest,
+						Size:      size,
+					},
+				})
+				return nil
+			})
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// findContainerdImage returns the image the reference points to in the
+// namespace. The error names the other namespaces that have it.
+func findContainerdImage(images map[string][]containerdRecord, namespace string, reference name.Reference) (containerdRecord, error) {
+	find := func(records []containerdRecord) (containerdRecord, bool) {
+		for _, r := range records {
+			if refersTo(reference, []string{r.name}, []string{r.target.Digest.String()}) {
+				return r, true
+			}
+		}
+		return containerdRecord{}, false
+	}
+	if r, ok := find(images[namespace]); ok {
+		return r, nil
+	}
+	var others []string
+	for ns, records := range images {
+		if _, ok := find(records); ok && ns != namespace {
+			others = append(others, ns)
+		}
+	}
+	slices.Sort(others)
+	err := fmt.Errorf("image %s not found in containerd namespace %s", reference, namespace)
+	if len(others) > 0 {
+		err = fmt.Errorf("%w, but in %s, see --containerd-namespace", err, strings.Join(others, ", "))
+	}
+	return containerdRecord{}, err
+}
+
+// containerdImage opens the image from the content store of containerd.
+// For a multi-platform image, the platform given is selected, or else the
+// first one whose manifest was pulled.
+func containerdImage(reference name.Reference, o *options) (*archiveImage, error) {
+	root := containerdRoot()
+	images, err := readContainerdImages(root)
+	if err != nil {
+		return nil, err
+	}
+	record, err := findContainerdImage(images, o.namespace, reference)
+	if err != nil {
+		return nil, err
+	}
+	debug("Found image %s in containerd namespace %s at %s", record.name, o.namespace, root)
+
+	store := contentStore(filepath.Join(root, "io.containerd.content.v1.content"))
+	desc := record.target
+	if desc.MediaType.IsIndex() {
+		if desc, err = store.selectManifest(desc, o.platform); err != nil {
+			return nil, err
+		}
+	}
+
+	core := &contentImageCore{store: store, mediaType: desc.MediaType}
+	if core.rawManifest, err = store.read(desc.Digest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if core.manifest, err = v1.ParseManifest(bytes.NewReader(core.rawManifest)); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if core.rawConfig, err = store.read(core.manifest.Config.Digest); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	img := &archiveImage{repoDigest: record.target.Digest.String()}
+	if img.Image, err = partial.CompressedToImage(core); err != nil {
+		return nil, err
+	}
+	if img.layers, err = img.Image.Layers(); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// contentStore is the directory of the blobs of containerd
+type contentStore string
+
+func (s contentStore) path(h v1.Hash) string {
+	return filepath.Join(string(s), "blobs", h.Algorithm, h.Hex)
+}
+
+func (s contentStore) read(h v1.Hash) ([]byte, error) {
+	return os.ReadFile(s.path(h))
+}
+
+// selectManifest returns the descriptor of the image of the index for the
+// platform, or of the first image pulled without one
+func (s contentStore) selectManifest(index v1.Descriptor, platform *v1.Platform) (v1.Descriptor, error) {
+	raw, err := s.read(index.Digest)
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to read index: %w", err)
+	}
+	manifest, err := v1.ParseIndexManifest(bytes.NewReader(raw))
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to parse index: %w", err)
+	}
+	for _, d := range manifest.Manifests {
+		if !d.MediaType.IsImage() {
+			continue
+		}
+		if platform != nil {
+			if d.Platform == nil || d.Platform.Satisfies(*platform) {
+				return d, nil
+			}
+			continue
+		}
+		if _, err := os.Stat(s.path(d.Digest)); err == nil {
+			return d, nil
+		}
+	}
+	if platform != nil {
+		return v1.Descriptor{}, &kindError{kind: ErrNotFound, err: fmt.Errorf("no image for %s in index %s", platform, index.Digest)}
+	}
+	return v1.Descriptor{}, &kindError{kind: ErrNotFound, err: fmt.Errorf("no image of index %s was pulled", index.Digest)}
+}
+
+// contentImageCore provides the minimal methods for partial.CompressedToImage
+type contentImageCore struct {
+	store       contentStore
+	mediaType   types.MediaType
+	rawManifest []byte
+	manifest    *v1.Manifest
+	rawConfig   []byte
+}
+
+func (c *contentImageCore) RawConfigFile() ([]byte, error) {
+	return c.rawConfig, nil
+}
+
+func (c *contentImageCore) MediaType() (types.MediaType, error) {
+	return c.mediaType, nil
+}
+
+func (c *contentImageCore) RawManifest() ([]byte, error) {
+	return c.rawManifest, nil
+}
+
+func (c *contentImageCore) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	for _, desc := range c.manifest.Layers {
+		if desc.Digest == h {
+			return &contentBlob{path: c.store.path(h), desc: desc}, nil
+		}
+	}
+	return nil, fmt.Errorf("blob %s not found in manifest", h)
+}
+
+// contentBlob is a layer blob of the content store of containerd
+type contentBlob struct {
+	path string
+	desc v1.Descriptor
+}
+
+func (b *contentBlob) Digest() (v1.Hash, error) {
+	return b.desc.Digest, nil
+}
+
+func (b *contentBlob) Compressed() (io.ReadCloser, error) {
+	f, err := os.Open(b.path)
+	if errors.Is(err, os.ErrNotExist) {
+		// containerd may discard layers once they are unpacked
+		return nil, fmt.Errorf("layer %s is not in the content store of containerd: %w", b.desc.Digest, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (b *contentBlob) Size() (int64, error) {
+	return b.desc.Size, nil
+}
+
+func (b *contentBlob) MediaType() (types.MediaType, error) {
+	return b.desc.MediaType, nil
+}
@@ -0,0 +1,270 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	bolt "go.etcd.io/bbolt"
+)
+
+// writeContentBlob writes the blob to the content store of containerd
+func writeContentBlob(t *testing.T, root string, content []byte) v1.Hash {
+	t.Helper()
+	digest, _, err := v1.SHA256(bytes.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, "io.containerd.content.v1.content", "blobs", digest.Algorithm, digest.Hex), content)
+	return digest
+}
+
+// writeContentImage writes the manifest, config and layers of the image to
+// the content store and returns its descriptor
+func writeContentImage(t *testing.T, root string, img v1.Image) v1.Descriptor {
+	t.Helper()
+	config, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeContentBlob(t, root, config)
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range layers {
+		rc, err := layer.Compressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeContentBlob(t, root, content)
+	}
+	desc, err := partial.Descriptor(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeContentBlob(t, root, manifest)
+	return *desc
+}
+
+// writeMetadata writes the metadata database of containerd with the images
+// of each namespace
+func writeMetadata(t *testing.T, root string, images map[string]map[string]v1.Descriptor) {
+	t.Helper()
+	path := filepath.Join(root, "io.containerd.metadata.v1.bolt", "meta.db")
+	writeTestFile(t, path, nil)
+	db, err := bolt.Open(path, 0o600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		version, err := tx.CreateBucket([]byte("v1"))
+		if err != nil {
+			return err
+		}
+		for namespace, named := range images {
+			ns, err := version.CreateBucket([]byte(namespace))
+			if err != nil {
+				return err
+			}
+			bucket, err := ns.CreateBucket([]byte("images"))
+			if err != nil {
+				return err
+			}
+			for name, desc := range named {
+				image, err := bucket.CreateBucket([]byte(name))
+				if err != nil {
+					return err
+				}
+				target, err := image.CreateBucket([]byte("target"))
+				if err != nil {
+					return err
+				}
+				size := binary.AppendVarint(nil, desc.Size)
+				for k, v := range map[string][]byte{"digest": []byte(desc.Digest.String()), "mediatype": []byte(desc.MediaType), "size": size} {
+					if err := target.Put([]byte(k), v); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// setupContainerd writes the content store of containerd with
+// docker.io/library/app:1.0 in the k8s.io namespace, the arm64 image of a
+// multi-platform image pulled as ghcr.io/test/multi:1.0 in the default
+// namespace, and returns their descriptors
+func setupContainerd(t *testing.T) (app, arm64 v1.Descriptor) {
+	t.Helper()
+	root := t.TempDir()
+	saved := containerdConfigPath
+	containerdConfigPath = filepath.Join(t.TempDir(), "config.toml")
+	t.Cleanup(func() { containerdConfigPath = saved })
+	writeTestFile(t, containerdConfigPath, []byte("version = 2\nroot = \""+root+"\"\n"))
+
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app = writeContentImage(t, root, img)
+
+	// Only the arm64 image of the index is pulled
+	platformImage := func(arch string) v1.Image {
+		img, err := random.Image(512, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		configFile, err := img.ConfigFile()
+		if err != nil {
+			t.Fatal(err)
+		}
+		configFile = configFile.DeepCopy()
+		configFile.OS, configFile.Architecture = "linux", arch
+		if img, err = mutate.ConfigFile(img, configFile); err != nil {
+			t.Fatal(err)
+		}
+		return img
+	}
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: platformImage("amd64"), Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: platformImage("arm64"), Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64"}}},
+	)
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	arm64 = manifest.Manifests[1]
+	armImage, err := index.Image(arm64.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeContentImage(t, root, armImage)
+	rawIndex, err := index.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest := writeContentBlob(t, root, rawIndex)
+
+	writeMetadata(t, root, map[string]map[string]v1.Descriptor{
+		"k8s.io": {
+			"docker.io/library/app:1.0":                    app,
+			"docker.io/library/app@" + app.Digest.String(): app,
+		},
+		"default": {
+			"ghcr.io/test/multi:1.0": {MediaType: types.OCIImageIndex, Digest: indexDigest, Size: int64(len(rawIndex))},
+		},
+	})
+	return app, arm64
+}
+
+func TestContainerdImage(t *testing.T) {
+	app, arm64 := setupContainerd(t)
+
+	tests := []struct {
+		ref       string
+		namespace string
+		want      v1.Descriptor
+	}{
+		{ref: "app:1.0", namespace: "k8s.io", want: app},
+		{ref: "app@" + app.Digest.String(), namespace: "k8s.io", want: app},
+		{ref: "ghcr.io/test/multi:1.0", namespace: "default", want: arm64},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ref, func(t *testing.T) {
+			opts := []Option{WithSource(SourceContainerd), WithContainerdNamespace(tt.namespace), WithPullPolicy(PullNever), WithCacheDir(t.TempDir())}
+			if !HasLocalImage(context.Background(), tt.ref, opts...) {
+				t.Errorf("HasLocalImage(%q) = false, want true", tt.ref)
+			}
+			image, err := Open(context.Background(), tt.ref, opts...)
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer image.Close()
+			if !image.Local {
+				t.Error("Expected a local image")
+			}
+			digest, err := image.img.Digest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if digest != tt.want.Digest {
+				t.Errorf("Digest() = %s, want %s", digest, tt.want.Digest)
+			}
+			for i := range image.Layers {
+				if image.Layers[i].Digest == "" {
+					t.Errorf("Layer %d has no digest", i)
+				}
+				if err := image.Layers[i].InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+					t.Errorf("InitializeLayer() error = %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestContainerdImageErrors(t *testing.T) {
+	setupContainerd(t)
+
+	tests := []struct {
+		name      string
+		ref       string
+		namespace string
+		opts      []Option
+		want      string
+	}{
+		{
+			name:      "other namespace",
+			ref:       "app:1.0",
+			namespace: "default",
+			want:      "image app:1.0 not found in containerd namespace default, but in k8s.io, see --containerd-namespace",
+		},
+		{
+			name:      "missing",
+			ref:       "app:2.0",
+			namespace: "k8s.io",
+			want:      "image app:2.0 not found in containerd namespace k8s.io",
+		},
+		{
+			name:      "platform not pulled",
+			ref:       "ghcr.io/test/multi:1.0",
+			namespace: "default",
+			opts:      []Option{WithPlatform(v1.Platform{OS: "linux", Architecture: "riscv64"})},
+			want:      "no image for linux/riscv64 in index",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := append([]Option{WithSource(SourceContainerd), WithContainerdNamespace(tt.namespace), WithPullPolicy(PullNever)}, tt.opts...)
+			_, err := Open(context.Background(), tt.ref, opts...)
+			if !errors.Is(err, ErrNotFound) || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Open() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
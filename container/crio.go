@@ -0,0 +1,430 @@
+package container
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+var (
+	// storageConfPaths are the storage.conf files of containers/storage, the
+	// first one existing being read. CONTAINERS_STORAGE_CONF overrides them.
+	storageConfPaths = []string{"/etc/containers/storage.conf", "/usr/share/containers/storage.conf"}
+	// crioConfPath is the config of CRI-O, whose drop-ins are read from the
+	// directory of the same name with ".d" appended
+	crioConfPath = "/etc/crio/crio.conf"
+)
+
+// storageConfig is where containers/storage keeps images
+type storageConfig struct {
+	driver    string
+	graphRoot string
+	// imageStores are read-only stores searched after the graph root
+	imageStores []string
+}
+
+// readStorageConfig reads the storage configuration CRI-O uses: storage.conf,
+// with the root and the driver overridden by crio.conf and its drop-ins
+func readStorageConfig() (*storageConfig, error) {
+	c := &storageConfig{graphRoot: "/var/lib/containers/storage"}
+	paths := storageConfPaths
+	if path := os.Getenv("CONTAINERS_STORAGE_CONF"); path != "" {
+		paths = []string{path}
+	}
+	for _, path := range paths {
+		values, err := readTOML(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		setString(&c.driver, values["storage.driver"])
+		setString(&c.graphRoot, values["storage.graphroot"])
+		// Images are kept apart from the containers in the image store
+		setString(&c.graphRoot, values["storage.imagestore"])
+		c.imageStores = values["storage.options.additionalimagestores"]
+		break
+	}
+
+	dropIns, err := filepath.Glob(filepath.Join(crioConfPath+".d", "*.conf"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(dropIns)
+	for _, path := range append([]string{crioConfPath}, dropIns...) {
+		values, err := readTOML(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		setString(&c.driver, values["crio.storage_driver"])
+		setString(&c.graphRoot, values["crio.root"])
+	}
+	return c, nil
+}
+
+// setString sets s to the last of the values, if any
+func setString(s *string, values []string) {
+	if len(values) > 0 && values[len(values)-1] != "" {
+		*s = values[len(values)-1]
+	}
+}
+
+// readTOML reads the string values of a TOML file, keyed by their table and
+// key, such as storage.graphroot. Arrays have all of their strings. Other
+// values are left out, as are the TOML features storage.conf and crio.conf
+// don't use.
+func readTOML(path string) (map[string][]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[string][]string)
+	table := ""
+	lines := strings.Split(string(content), "\n")
+	for i := 0; i < len(lines); i++ {
+		line := strings.TrimSpace(stripTOMLComment(lines[i]))
+		if strings.HasPrefix(line, "[") {
+			table = strings.Trim(line, "[] \t")
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		// Arrays may span lines
+		for strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") && i+1 < len(lines) {
+			i++
+			value += " " + strings.TrimSpace(stripTOMLComment(lines[i]))
+		}
+		key = strings.Trim(strings.TrimSpace(key), `"'`)
+		if table != "" {
+			key = table + "." + key
+		}
+		if strs := tomlStrings(value); strs != nil {
+			values[key] = strs
+		}
+	}
+	return values, nil
+}
+
+// stripTOMLComment removes the comment at the end of the line
+func stripTOMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote == '"' && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// tomlStrings returns the strings of a string or an array of strings, or nil
+// for other values
+func tomlStrings(value string) []string {
+	strs := []string{}
+	for i := 0; i < len(value); i++ {
+		switch value[i] {
+		case '\'':
+			end := strings.IndexByte(value[i+1:], '\'')
+			if end < 0 {
+				return nil
+			}
+			strs = append(strs, value[i+1:i+1+end])
+			i += end + 1
+		case '"':
+			end := i + 1
+			for end < len(value) && value[end] != '"' {
+				if value[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			s, err := strconv.Unquote(value[i:min(end+1, len(value))])
+			if err != nil {
+				return nil
+			}
+			strs = append(strs, s)
+			i = end
+		case '[', ']', ',', ' ', '\t':
+		default:
+			return nil
+		}
+	}
+	if !strings.HasPrefix(value, "[") && len(strs) != 1 {
+		return nil
+	}
+	return strs
+}
+
+// storageImage is an image of images.json of containers/storage
+type storageImage struct {
+	ID      string   `json:"id"`
+	Digest  string   `json:"digest"`
+	Digests []string `json:"digests"`
+	Names   []string `json:"names"`
+	Layer   string   `json:"layer"` // ID of the top layer
+}
+
+// storageLayer is a layer of layers.json of containers/storage
+type storageLayer struct {
+	ID       string `json:"id"`
+	Parent   string `json:"parent"`
+	DiffSize int64  `json:"diff-size"`
+}
+
+// matches reports whether the image is the one the reference points to: by
+// one of its names, by the digest of its manifest in the repository of one
+// of its names, or by a prefix of its ID of at least 12 characters
+func (i *storageImage) matches(reference name.Reference, ref string) bool {
+	if len(ref) >= 12 && strings.HasPrefix(i.ID, ref) {
+		return true
+	}
+	return refersTo(reference, i.Names, append([]string{i.Digest}, i.Digests...))
+}
+
+// refersTo reports whether the reference points to a local image of the
+// names and manifest digests: by one of the names, or by one of the digests
+// in the repository of one of the names
+func refersTo(reference name.Reference, names, digests []string) bool {
+	digest, isDigest := reference.(name.Digest)
+	for _, n := range names {
+		other, err := name.ParseReference(n)
+		if err != nil {
+			continue
+		}
+		if other.Name() == reference.Name() {
+			return true
+		}
+		if isDigest && other.Context().Name() == reference.Context().Name() && slices.Contains(digests, digest.DigestStr()) {
+			return true
+		}
+	}
+	return false
+}
+
+// findStorageImage returns the image the reference points to and the store
+// it was found in
+func findStorageImage(c *storageConfig, reference name.Reference, ref string) (*storageImage, string, string, error) {
+	var errs []error
+	for _, root := range append([]string{c.graphRoot}, c.imageStores...) {
+		driver := c.driver
+		if driver == "" {
+			// The driver found on the first use is kept by containers/storage
+			driver = "overlay"
+			if _, err := os.Stat(filepath.Join(root, "vfs-images")); err == nil {
+				driver = "vfs"
+			}
+		}
+		var images []storageImage
+		if err := readJSONFile(filepath.Join(root, driver+"-images", "images.json"), &images); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for i := range images {
+			if images[i].matches(reference, ref) {
+				return &images[i], root, driver, nil
+			}
+		}
+	}
+	err := fmt.Errorf("image %s not found in the storage of CRI-O at %s", ref, c.graphRoot)
+	return nil, "", "", errors.Join(append([]error{err}, errs...)...)
+}
+
+// crioImage opens the image from the containers/storage of CRI-O. Layers
+// are read from the files of the storage driver, in the order of the tar
+// archives they were pulled as.
+func crioImage(reference name.Reference, ref string) (*archiveImage, error) {
+	c, err := readStorageConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the storage configuration: %w", err)
+	}
+	image, root, driver, err := findStorageImage(c, reference, ref)
+	if err != nil {
+		return nil, err
+	}
+	debug("Found image %s in %s with the %s driver", image.ID, root, driver)
+
+	layerDir, err := driverLayerDir(root, driver)
+	if err != nil {
+		return nil, err
+	}
+	imageDir := filepath.Join(root, driver+"-images", image.ID)
+	rawManifest, err := os.ReadFile(filepath.Join(imageDir, bigDataName("manifest")))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	manifest, err := v1.ParseManifest(bytes.NewReader(rawManifest))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if manifest.Config.Digest == (v1.Hash{}) {
+		return nil, &kindError{kind: ErrUnsupportedMediaType, err: fmt.Errorf("image %s has a schema 1 manifest", image.ID)}
+	}
+	rawConfig, err := os.ReadFile(filepath.Join(imageDir, bigDataName(manifest.Config.Digest.String())))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	configFile, err := v1.ParseConfigFile(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	var layers []storageLayer
+	layersDir := filepath.Join(root, driver+"-layers")
+	if err := readJSONFile(filepath.Join(layersDir, "layers.json"), &layers); err != nil {
+		return nil, err
+	}
+	chain, err := layerChain(layers, image.Layer)
+	if err != nil {
+		return nil, err
+	}
+	diffIDs := configFile.RootFS.DiffIDs
+	if len(diffIDs) != len(chain) {
+		return nil, fmt.Errorf("config has %d diff IDs but the image has %d layers", len(diffIDs), len(chain))
+	}
+
+	core := &archiveImageCore{
+		rawConfig: rawConfig,
+		blobs:     make(map[v1.Hash]partial.UncompressedLayer),
+	}
+	img := &archiveImage{}
+	for i, l := range chain {
+		blob := &storageBlob{
+			tarSplit: filepath.Join(layersDir, l.ID+".tar-split.gz"),
+			dir:      layerDir(l.ID),
+			diffID:   diffIDs[i],
+		}
+		core.blobs[diffIDs[i]] = blob
+		layer, err := partial.UncompressedToLayer(blob)
+		if err != nil {
+			return nil, err
+		}
+		img.layers = append(img.layers, &archiveLayer{Layer: layer, size: l.DiffSize})
+	}
+	if img.Image, err = partial.UncompressedToImage(core); err != nil {
+		return nil, err
+	}
+	img.repoDigest = repoDigest(image.Names, reference)
+	if img.repoDigest == "" && image.Digest != "" {
+		img.repoDigest = image.Digest
+	}
+	return img, nil
+}
+
+// driverLayerDir returns the function giving the directory of the files of
+// a layer for the storage driver
+func driverLayerDir(root, driver string) (func(id string) string, error) {
+	switch driver {
+	case "overlay":
+		return func(id string) string { return filepath.Join(root, "overlay", id, "diff") }, nil
+	case "vfs":
+		return func(id string) string { return filepath.Join(root, "vfs", "dir", id) }, nil
+	}
+	return nil, fmt.Errorf("unsupported storage driver %q, must be overlay or vfs", driver)
+}
+
+// layerChain returns the layers from the base to the top layer
+func layerChain(layers []storageLayer, top string) ([]storageLayer, error) {
+	byID := make(map[string]storageLayer, len(layers))
+	for _, l := range layers {
+		byID[l.ID] = l
+	}
+	var chain []storageLayer
+	for id := top; id != ""; {
+		l, ok := byID[id]
+		if !ok {
+			return nil, fmt.Errorf("layer %s not found", id)
+		}
+		if len(chain) == len(layers) {
+			return nil, fmt.Errorf("layer %s is its own parent", id)
+		}
+		chain = append(chain, l)
+		id = l.Parent
+	}
+	slices.Reverse(chain)
+	return chain, nil
+}
+
+// bigDataName returns the name of the file containers/storage stores the
+// data of an image under. Keys of other characters than lowercase letters,
+// digits and dots are encoded.
+func bigDataName(key string) string {
+	for _, c := range key {
+		if c != '.' && (c < '0' || c > '9') && (c < 'a' || c > 'z') {
+			return "=" + base64.StdEncoding.EncodeToString([]byte(key))
+		}
+	}
+	return key
+}
+
+func readJSONFile(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(content, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// storageBlob is a layer of containers/storage, whose tar archive is put
+// back together from its tar-split metadata and its files
+type storageBlob struct {
+	tarSplit string
+	dir      string
+	diffID   v1.Hash
+}
+
+func (b *storageBlob) DiffID() (v1.Hash, error) {
+	return b.diffID, nil
+}
+
+func (b *storageBlob) Uncompressed() (io.ReadCloser, error) {
+	f, err := os.Open(b.tarSplit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the tar-split data of the layer: %w", err)
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to read the tar-split data of the layer: %w", err)
+	}
+	rc := asm.NewOutputTarStream(storage.NewPathFileGetter(b.dir), storage.NewJSONUnpacker(gz))
+	return &readCloser{Reader: rc, decoder: rc, source: f}, nil
+}
+
+func (b *storageBlob) MediaType() (types.MediaType, error) {
+	return types.DockerLayer, nil
+}
@@ -0,0 +1,234 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/static"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+)
+
+// storageTestFile is a file of a layer written to a test storage
+type storageTestFile struct {
+	name    string
+	content string
+}
+
+// writeStorageLayer writes the layer as containers/storage does with the
+// overlay driver: its files in the diff directory and the tar-split data
+// of its archive. It returns the archive.
+func writeStorageLayer(t *testing.T, root, id string, files []storageTestFile) []byte {
+	t.Helper()
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	diff := filepath.Join(root, "overlay", id, "diff")
+	for _, f := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0o644, Size: int64(len(f.content)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(f.content))
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(diff, f.name)), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(diff, f.name), []byte(f.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var tarSplit bytes.Buffer
+	gz := gzip.NewWriter(&tarSplit)
+	r, err := asm.NewInputTarStream(bytes.NewReader(archive.Bytes()), storage.NewJSONPacker(gz), storage.NewDiscardFilePutter())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	gz.Close()
+	writeTestFile(t, filepath.Join(root, "overlay-layers", id+".tar-split.gz"), tarSplit.Bytes())
+	return archive.Bytes()
+}
+
+func writeTestFile(t *testing.T, path string, content []byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, content, 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeTestJSON(t *testing.T, path string, v any) {
+	t.Helper()
+	content, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, path, content)
+}
+
+// setupStorage writes a storage of CRI-O with an image of two layers, named
+// quay.io/test/app:1.0, and points the storage configuration to it. It
+// returns the ID and the manifest digest of the image.
+func setupStorage(t *testing.T) (string, v1.Hash) {
+	t.Helper()
+	root := t.TempDir()
+	conf := filepath.Join(t.TempDir(), "storage.conf")
+	writeTestFile(t, conf, []byte("[storage]\ndriver = \"overlay\"\ngraphroot = \""+root+"\"\n"))
+	t.Setenv("CONTAINERS_STORAGE_CONF", conf)
+	saved := crioConfPath
+	crioConfPath = filepath.Join(t.TempDir(), "crio.conf")
+	t.Cleanup(func() { crioConfPath = saved })
+
+	base := writeStorageLayer(t, root, "base", []storageTestFile{{"etc/os-release", "ID=test\n"}, {"etc/hostname", "base\n"}})
+	top := writeStorageLayer(t, root, "top", []storageTestFile{{"etc/hostname", "app\n"}})
+	writeTestJSON(t, filepath.Join(root, "overlay-layers", "layers.json"), []map[string]any{
+		{"id": "top", "parent": "base", "diff-size": len(top)},
+		{"id": "base", "diff-size": len(base)},
+	})
+
+	img := empty.Image
+	for _, archive := range [][]byte{base, top} {
+		var err error
+		if img, err = mutate.AppendLayers(img, static.NewLayer(archive, types.DockerLayer)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rawManifest, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configName, _ := img.ConfigName()
+	digest, _ := img.Digest()
+	id := configName.Hex
+	imageDir := filepath.Join(root, "overlay-images", id)
+	writeTestFile(t, filepath.Join(imageDir, "manifest"), rawManifest)
+	writeTestFile(t, filepath.Join(imageDir, bigDataName(configName.String())), rawConfig)
+	writeTestJSON(t, filepath.Join(root, "overlay-images", "images.json"), []map[string]any{{
+		"id":     id,
+		"digest": digest.String(),
+		"names":  []string{"quay.io/test/app:1.0"},
+		"layer":  "top",
+	}})
+	return id, digest
+}
+
+func TestCRIOImage(t *testing.T) {
+	id, digest := setupStorage(t)
+
+	for _, ref := range []string{"quay.io/test/app:1.0", "quay.io/test/app@" + digest.String(), id[:12]} {
+		t.Run(ref, func(t *testing.T) {
+			if !HasLocalImage(context.Background(), ref, WithSource(SourceCRIO)) {
+				t.Errorf("HasLocalImage(%q) = false, want true", ref)
+			}
+			image, err := Open(context.Background(), ref, WithSource(SourceCRIO), WithPullPolicy(PullNever), WithCacheDir(t.TempDir()))
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer image.Close()
+			if !image.Local {
+				t.Error("Expected a local image")
+			}
+			if got, _ := image.ManifestDigest(); got != digest.String() {
+				t.Errorf("ManifestDigest() = %q, want %q", got, digest)
+			}
+			if len(image.Layers) != 2 {
+				t.Fatalf("Expected 2 layers, got %d", len(image.Layers))
+			}
+
+			// The archives are put back together as they were pulled
+			merged := MergeLayers(image.Layers...)
+			if err := merged.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+				t.Fatalf("InitializeLayer() error = %v", err)
+			}
+			for file, want := range map[string]string{"etc/hostname": "app\n", "etc/os-release": "ID=test\n"} {
+				content, err := merged.ReadFile(context.Background(), file)
+				if err != nil {
+					t.Fatalf("ReadFile(%q) error = %v", file, err)
+				}
+				if string(content) != want {
+					t.Errorf("ReadFile(%q) = %q, want %q", file, content, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCRIOImageNotFound(t *testing.T) {
+	setupStorage(t)
+
+	for _, ref := range []string{"quay.io/test/app:2.0", "quay.io/test/other:1.0", "docker.io/library/alpine:latest"} {
+		if HasLocalImage(context.Background(), ref, WithSource(SourceCRIO)) {
+			t.Errorf("HasLocalImage(%q) = true, want false", ref)
+		}
+		_, err := Open(context.Background(), ref, WithSource(SourceCRIO), WithPullPolicy(PullNever))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Open(%q) error = %v, want %v", ref, err, ErrNotFound)
+		}
+	}
+}
+
+func TestReadStorageConfig(t *testing.T) {
+	dir := t.TempDir()
+	conf := filepath.Join(dir, "storage.conf")
+	writeTestFile(t, conf, []byte(`# storage.conf
+[storage]
+driver = "vfs" # overridden by CRI-O
+graphroot = "/var/lib/containers/storage"
+
+[storage.options]
+additionalimagestores = [
+  "/mnt/images", # read-only
+  '/opt/images',
+]
+`))
+	t.Setenv("CONTAINERS_STORAGE_CONF", conf)
+	saved := crioConfPath
+	crioConfPath = filepath.Join(dir, "crio.conf")
+	t.Cleanup(func() { crioConfPath = saved })
+	writeTestFile(t, crioConfPath, []byte("[crio]\nroot = \"/var/lib/crio\"\nstorage_driver = \"overlay\"\n"))
+	writeTestFile(t, filepath.Join(dir, "crio.conf.d", "10-root.conf"), []byte("[crio]\n  root = \"/data/containers\"\n"))
+
+	c, err := readStorageConfig()
+	if err != nil {
+		t.Fatalf("readStorageConfig() error = %v", err)
+	}
+	want := &storageConfig{driver: "overlay", graphRoot: "/data/containers", imageStores: []string{"/mnt/images", "/opt/images"}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("readStorageConfig() = %+v, want %+v", c, want)
+	}
+}
+
+func TestParseSource(t *testing.T) {
+	for _, source := range []Source{SourceDocker, SourceCRIO, SourceContainerd} {
+		got, err := ParseSource(source.String())
+		if err != nil || got != source {
+			t.Errorf("ParseSource(%q) = %v, %v", source, got, err)
+		}
+	}
+	if _, err := ParseSource("podman"); err == nil {
+		t.Error("ParseSource(podman) succeeded, want an error")
+	}
+}
@@ -0,0 +1,450 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/docker/docker/client"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/knqyf263/sou/tarfs"
+)
+
+// daemonImage exports the image from the Docker daemon into the cache once
+// and serves all layer reads from the saved archive, instead of streaming the
+// whole image over the Docker API again for every layer access.
+func daemonImage(ctx context.Context, ref name.Reference, cache *Cache, progress ProgressFunc) (*archiveImage, error) {
+	cli, err := dockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
+
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, ref.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect image: %w", err)
+	}
+
+	archivePath := cache.getImage(inspect.ID)
+	if archivePath == "" {
+		debug("Saving image %s (%s) from the daemon", ref.Name(), inspect.ID)
+		archivePath, err = cache.imageArchivePath(inspect.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get cache file path: %w", err)
+		}
+		if err := saveDaemonImage(ctx, cli, ref, archivePath, progress); err != nil {
+			return nil, err
+		}
+		cache.putImage(inspect.ID, archivePath)
+	} else {
+		debug("Found cached archive of image %s at %s", inspect.ID, archivePath)
+		progress(Progress{Stage: StageResolving, Blob: inspect.ID, Exists: true})
+	}
+
+	img, err := openArchive(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	img.repoDigest = repoDigest(inspect.RepoDigests, ref)
+	cache.acquireImage(inspect.ID)
+	img.release = func() error {
+		return cache.releaseImage(inspect.ID)
+	}
+	return img, nil
+}
+
+// LocalImageID returns the ID of the image the reference points to in the
+// Docker daemon, which changes when the tag is rebuilt
+func LocalImageID(ctx context.Context, ref string) (string, error) {
+	reference, err := name.ParseReference(ref)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse reference: %w", err)
+	}
+	cli, err := dockerClient()
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, reference.String())
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect image: %w", err)
+	}
+	return inspect.ID, nil
+}
+
+// ContainerImage returns a reference to the image run by a container of the
+// Docker daemon, given the name or ID of the container
+func ContainerImage(ctx context.Context, container string) (string, error) {
+	cli, err := dockerClient()
+	if err != nil {
+		return "", err
+	}
+	defer cli.Close()
+
+	c, err := cli.ContainerInspect(ctx, container)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect container: %w", err)
+	}
+	inspect, _, err := cli.ImageInspectWithRaw(ctx, c.Image)
+	if err != nil {
+		return "", fmt.Errorf("failed to inspect the image of the container: %w", err)
+	}
+	created := ""
+	if c.Config != nil {
+		created = c.Config.Image
+	}
+	ref := containerImageRef(created, inspect.RepoTags, inspect.RepoDigests)
+	if ref == "" {
+		return "", fmt.Errorf("image %s of container %s has no tag or digest, as it was likely rebuilt since the container was created", c.Image, container)
+	}
+	return ref, nil
+}
+
+// containerImageRef returns the reference the container was created with if
+// it still points to the image, which has the tags and digests given, and
+// otherwise a tag or a digest of the image. It is empty if the image has
+// none, as the tag was moved to another image.
+func containerImageRef(created string, repoTags, repoDigests []string) string {
+	if r, err := name.ParseReference(created); err == nil {
+		for _, s := range append(append([]string{}, repoTags...), repoDigests...) {
+			if other, err := name.ParseReference(s); err == nil && other.Name() == r.Name() {
+				return created
+			}
+		}
+	}
+	if len(repoTags) > 0 {
+		return repoTags[0]
+	}
+	if len(repoDigests) > 0 {
+		return repoDigests[0]
+	}
+	return ""
+}
+
+// repoDigest returns the digest of the repository of ref the image was
+// pulled by, or the first one if it was pulled from another repository. It
+// is empty for images built locally.
+func repoDigest(repoDigests []string, ref name.Reference) string {
+	var digests []name.Digest
+	for _, s := range repoDigests {
+		if d, err := name.NewDigest(s); err == nil {
+			digests = append(digests, d)
+		}
+	}
+	for _, d := range digests {
+		if d.Context().Name() == ref.Context().Name() {
+			return d.DigestStr()
+		}
+	}
+	if len(digests) > 0 {
+		return digests[0].DigestStr()
+	}
+	return ""
+}
+
+// saveDaemonImage writes the `docker save` archive of the image to archivePath
+func saveDaemonImage(ctx context.Context, cli *client.Client, ref name.Reference, archivePath string, progress ProgressFunc) error {
+	rc, err := cli.ImageSave(ctx, []string{ref.Name()})
+	if err != nil {
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	defer rc.Close()
+
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to create cache file: %w", err)
+	}
+
+	if err := copyArchive(file, rc, progress); err != nil {
+		file.Close()
+		os.Remove(archivePath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("failed to save image: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(archivePath)
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	return nil
+}
+
+// copyArchive copies a `docker save` archive, reporting the progress of
+// its layers and configs as they go by
+func copyArchive(w io.Writer, r io.Reader, progress ProgressFunc) error {
+	tr := tar.NewReader(io.TeeReader(r, w))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		blob := savedBlob(hdr.Name)
+		if blob == "" || hdr.Typeflag != tar.TypeReg {
+			// Skipping the content still copies it
+			continue
+		}
+		pr := &progressReader{r: tr, progress: progress, stage: StageResolving, blob: blob, total: hdr.Size}
+		pr.report()
+		if _, err := io.Copy(io.Discard, pr); err != nil {
+			return err
+		}
+	}
+	// Copy the padding after the end of the archive
+	_, err := io.Copy(w, r)
+	return err
+}
+
+// savedBlob returns the blob of an entry of a `docker save` archive as
+// reported in the progress, or an empty string for metadata entries: the
+// digest of blobs of the OCI layout and of configs, and the path of layers
+// otherwise
+func savedBlob(name string) string {
+	name = path.Clean(name)
+	if hex, ok := strings.CutPrefix(name, "blobs/sha256/"); ok {
+		return "sha256:" + hex
+	}
+	if path.Base(name) == "layer.tar" {
+		return name
+	}
+	if hex, ok := strings.CutSuffix(name, ".json"); ok && len(hex) == 64 && !strings.Contains(hex, "/") {
+		return "sha256:" + hex
+	}
+	return ""
+}
+
+// openArchive opens a `docker save` archive containing a single image.
+// The archive is indexed once so that layers are read in place.
+func openArchive(archivePath string) (*archiveImage, error) {
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open image archive: %w", err)
+	}
+
+	img, err := newArchiveImage(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return img, nil
+}
+
+// archiveImage implements v1.Image over an indexed `docker save` archive, or
+// over layers read from the disk without an archive
+type archiveImage struct {
+	v1.Image
+	layers  []v1.Layer
+	file    *os.File
+	release func() error // removes the archive from the cache
+	// repoDigest is the digest the image was pulled by, if any
+	repoDigest string
+}
+
+func newArchiveImage(file *os.File) (*archiveImage, error) {
+	archive, err := tarfs.New(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to index image archive: %w", err)
+	}
+
+	descriptor, rawConfig, err := archiveDescriptor(archive)
+	if err != nil {
+		return nil, err
+	}
+	configFile, err := v1.ParseConfigFile(bytes.NewReader(rawConfig))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config: %w", err)
+	}
+
+	diffIDs := configFile.RootFS.DiffIDs
+	if len(diffIDs) != len(descriptor.Layers) {
+		return nil, fmt.Errorf("config has %d diff IDs but the archive has %d layers", len(diffIDs), len(descriptor.Layers))
+	}
+
+	core := &archiveImageCore{
+		rawConfig: rawConfig,
+		blobs:     make(map[v1.Hash]partial.UncompressedLayer),
+	}
+	img := &archiveImage{file: file}
+	for i, layerPath := range descriptor.Layers {
+		size, err := archiveFileSize(archive, layerPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find layer %s: %w", layerPath, err)
+		}
+		blob := &archiveBlob{
+			archive: archive,
+			path:    layerPath,
+			diffID:  diffIDs[i],
+		}
+		core.blobs[diffIDs[i]] = blob
+
+		layer, err := partial.UncompressedToLayer(blob)
+		if err != nil {
+			return nil, err
+		}
+		img.layers = append(img.layers, &archiveLayer{Layer: layer, size: size})
+	}
+
+	img.Image, err = partial.UncompressedToImage(core)
+	if err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// archiveDescriptor returns the descriptor of the single image of the
+// archive and its config. Archives without manifest.json, as saved by Docker
+// before 1.10, are converted.
+func archiveDescriptor(archive *tarfs.FS) (tarball.Descriptor, []byte, error) {
+	manifestFile, err := archive.Open("manifest.json")
+	if errors.Is(err, fs.ErrNotExist) {
+		debug("No manifest.json in the image archive, converting the legacy layout")
+		return legacyDescriptor(archive)
+	}
+	if err != nil {
+		return tarball.Descriptor{}, nil, fmt.Errorf("failed to open manifest.json: %w", err)
+	}
+	defer manifestFile.Close()
+
+	var manifest tarball.Manifest
+	if err := json.NewDecoder(manifestFile).Decode(&manifest); err != nil {
+		return tarball.Descriptor{}, nil, fmt.Errorf("failed to decode manifest.json: %w", err)
+	}
+	if len(manifest) != 1 {
+		return tarball.Descriptor{}, nil, fmt.Errorf("image archive must contain a single image, found %d", len(manifest))
+	}
+
+	rawConfig, err := readArchiveFile(archive, manifest[0].Config)
+	if err != nil {
+		return tarball.Descriptor{}, nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	return manifest[0], rawConfig, nil
+}
+
+// Layers returns layers backed by the archive
+func (i *archiveImage) Layers() ([]v1.Layer, error) {
+	return i.layers, nil
+}
+
+// Close closes the archive, if any, and removes it from the cache
+func (i *archiveImage) Close() error {
+	var err error
+	if i.file != nil {
+		err = i.file.Close()
+	}
+	if i.release != nil {
+		err = errors.Join(err, i.release())
+	}
+	return err
+}
+
+// archiveImageCore provides the minimal methods for partial.UncompressedToImage
+type archiveImageCore struct {
+	rawConfig []byte
+	blobs     map[v1.Hash]partial.UncompressedLayer
+}
+
+func (c *archiveImageCore) RawConfigFile() ([]byte, error) {
+	return c.rawConfig, nil
+}
+
+func (c *archiveImageCore) MediaType() (types.MediaType, error) {
+	return types.DockerManifestSchema2, nil
+}
+
+func (c *archiveImageCore) LayerByDiffID(h v1.Hash) (partial.UncompressedLayer, error) {
+	blob, ok := c.blobs[h]
+	if !ok {
+		return nil, fmt.Errorf("diff ID %s not found in image archive", h)
+	}
+	return blob, nil
+}
+
+// archiveBlob is a layer stored in the archive
+type archiveBlob struct {
+	archive *tarfs.FS
+	path    string
+	diffID  v1.Hash
+}
+
+func (b *archiveBlob) DiffID() (v1.Hash, error) {
+	return b.diffID, nil
+}
+
+func (b *archiveBlob) Uncompressed() (io.ReadCloser, error) {
+	f, err := b.archive.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	// Layers of `docker save` archives are normally uncompressed,
+	// but older archives may contain compressed ones
+	rc, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &readCloser{Reader: rc, decoder: rc, source: f}, nil
+}
+
+func (b *archiveBlob) MediaType() (types.MediaType, error) {
+	return types.DockerLayer, nil
+}
+
+// archiveLayer is a v1.Layer whose stored size is known without compressing it
+type archiveLayer struct {
+	v1.Layer
+	size int64
+}
+
+func (l *archiveLayer) storedSize() int64 {
+	return l.size
+}
+
+// layerSize returns the size of the layer to display. Layers exported from
+// the Docker daemon are stored uncompressed, and computing their compressed
+// size would require compressing the whole layer, so the stored size is used.
+func layerSize(l v1.Layer) (int64, error) {
+	if s, ok := l.(interface{ storedSize() int64 }); ok {
+		return s.storedSize(), nil
+	}
+	return l.Size()
+}
+
+// readArchiveFile reads a whole file from the archive
+func readArchiveFile(archive *tarfs.FS, filePath string) ([]byte, error) {
+	f, err := archive.Open(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// archiveFileSize returns the size of a file in the archive
+func archiveFileSize(archive *tarfs.FS, filePath string) (int64, error) {
+	f, err := archive.Open(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
@@ -0,0 +1,400 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// writeSavedImage writes a `docker save` style archive with uncompressed layers
+func writeSavedImage(t *testing.T, img v1.Image, path string) {
+	t.Helper()
+
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tw := tar.NewWriter(f)
+
+	writeFile := func(name string, content []byte) {
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     name,
+			Size:     int64(len(content)),
+			Mode:     0o644,
+			Typeflag: tar.TypeReg,
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rawConfig, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configName, err := img.ConfigName()
+	if err != nil {
+		t.Fatal(err)
+	}
+	descriptor := tarball.Descriptor{Config: configName.Hex + ".json"}
+	writeFile(descriptor.Config, rawConfig)
+
+	layers, err := img.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		layerPath := diffID.Hex + "/layer.tar"
+		writeFile(layerPath, content)
+		descriptor.Layers = append(descriptor.Layers, layerPath)
+	}
+
+	manifest, err := json.Marshal(tarball.Manifest{descriptor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFile("manifest.json", manifest)
+
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestOpenArchive(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		write func(t *testing.T, path string)
+	}{
+		{
+			name: "uncompressed layers",
+			write: func(t *testing.T, path string) {
+				writeSavedImage(t, img, path)
+			},
+		},
+		{
+			name: "compressed layers",
+			write: func(t *testing.T, path string) {
+				tag, err := name.NewTag("sou.test/archive:latest")
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := tarball.WriteToFile(path, tag, img); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "image.tar")
+			tt.write(t, path)
+
+			archiveImg, err := openArchive(path)
+			if err != nil {
+				t.Fatalf("openArchive() error = %v", err)
+			}
+
+			image, err := createImageFromV1(archiveImg, "sou.test/archive:latest")
+			if err != nil {
+				t.Fatalf("createImageFromV1() error = %v", err)
+			}
+			if len(image.Layers) != 1 {
+				t.Fatalf("Expected 1 layer, got %d", len(image.Layers))
+			}
+
+			wantDiffID, err := layer.DiffID()
+			if err != nil {
+				t.Fatal(err)
+			}
+			l := image.Layers[0]
+			if l.DiffID != wantDiffID.String() {
+				t.Errorf("Expected diff ID %s, got %s", wantDiffID, l.DiffID)
+			}
+			if l.Size <= 0 {
+				t.Errorf("Expected positive layer size, got %d", l.Size)
+			}
+
+			if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+				t.Fatalf("InitializeLayer() error = %v", err)
+			}
+			content, err := l.ReadFile(context.Background(), "testdir/file.txt")
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if string(content) != "directory test content" {
+				t.Errorf("Expected %q, got %q", "directory test content", content)
+			}
+		})
+	}
+}
+
+func TestOpenArchiveStoredSize(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "image.tar")
+	writeSavedImage(t, img, path)
+
+	archiveImg, err := openArchive(path)
+	if err != nil {
+		t.Fatalf("openArchive() error = %v", err)
+	}
+	layers, err := archiveImg.Layers()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rc, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := io.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The stored size is used without compressing the layer
+	size, err := layerSize(layers[0])
+	if err != nil {
+		t.Fatalf("layerSize() error = %v", err)
+	}
+	if size != int64(len(content)) {
+		t.Errorf("Expected size %d, got %d", len(content), size)
+	}
+}
+
+func TestArchiveImageClose(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+
+	cache := NewCache(t.TempDir())
+	path, err := cache.imageArchivePath("sha256:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeSavedImage(t, img, path)
+	cache.putImage("sha256:test", path)
+
+	archiveImg, err := openArchive(path)
+	if err != nil {
+		t.Fatalf("openArchive() error = %v", err)
+	}
+	cache.acquireImage("sha256:test")
+	archiveImg.release = func() error {
+		return cache.releaseImage("sha256:test")
+	}
+
+	image, err := createImageFromV1(archiveImg, "sou.test/archive:latest")
+	if err != nil {
+		t.Fatalf("createImageFromV1() error = %v", err)
+	}
+	image.Layers[0].layerCache = cache
+	if err := image.Layers[0].InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+
+	if err := image.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected image archive to be removed")
+	}
+	if cache.getImage("sha256:test") != "" {
+		t.Error("Expected image archive to be removed from the cache")
+	}
+	if cache.getLayerContent(image.Layers[0].DiffID) != nil {
+		t.Error("Expected layer content to be removed from the cache")
+	}
+}
+
+func TestReleaseImage(t *testing.T) {
+	cache := NewCache(t.TempDir())
+	path, err := cache.imageArchivePath("sha256:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("archive"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cache.putImage("sha256:test", path)
+
+	// The archive of an image opened twice, such as to compare it, is kept
+	// until both are closed
+	cache.acquireImage("sha256:test")
+	cache.acquireImage("sha256:test")
+	if err := cache.releaseImage("sha256:test"); err != nil {
+		t.Fatalf("releaseImage() error = %v", err)
+	}
+	if cache.getImage("sha256:test") != path {
+		t.Error("Expected image archive to stay in the cache")
+	}
+	if err := cache.releaseImage("sha256:test"); err != nil {
+		t.Fatalf("releaseImage() error = %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("Expected image archive to be removed")
+	}
+}
+
+func TestCopyArchive(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatalf("Failed to create test image: %v", err)
+	}
+	archivePath := filepath.Join(t.TempDir(), "image.tar")
+	writeSavedImage(t, img, archivePath)
+	want, err := os.ReadFile(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blobs := map[string]Progress{}
+	var got bytes.Buffer
+	err = copyArchive(&got, bytes.NewReader(want), func(p Progress) {
+		blobs[p.Blob] = p
+	})
+	if err != nil {
+		t.Fatalf("copyArchive() error = %v", err)
+	}
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Error("copyArchive() didn't copy the archive as is")
+	}
+
+	configName, _ := img.ConfigName()
+	diffID, _ := layer.DiffID()
+	for _, blob := range []string{configName.String(), diffID.Hex + "/layer.tar"} {
+		p, ok := blobs[blob]
+		if !ok {
+			t.Errorf("No progress reported for %s, got %v", blob, blobs)
+			continue
+		}
+		if p.Total == 0 || p.Complete != p.Total {
+			t.Errorf("Progress of %s = %d/%d, want complete", blob, p.Complete, p.Total)
+		}
+	}
+	if len(blobs) != 2 {
+		t.Errorf("Expected the config and layer to be reported, got %v", blobs)
+	}
+}
+
+func TestSavedBlob(t *testing.T) {
+	hex := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"blobs/sha256/" + hex, "sha256:" + hex},
+		{hex + ".json", "sha256:" + hex},
+		{hex + "/layer.tar", hex + "/layer.tar"},
+		{"manifest.json", ""},
+		{"index.json", ""},
+		{hex + "/json", ""},
+		{"repositories", ""},
+	}
+	for _, tt := range tests {
+		if got := savedBlob(tt.name); got != tt.want {
+			t.Errorf("savedBlob(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRepoDigest(t *testing.T) {
+	alpine := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	mirror := "sha256:fedcba9876543210fedcba9876543210fedcba9876543210fedcba9876543210"
+	tests := []struct {
+		ref         string
+		repoDigests []string
+		want        string
+	}{
+		{"alpine:3.20", []string{"mirror.example.com/alpine@" + mirror, "alpine@" + alpine}, alpine},
+		{"docker.io/library/alpine:3.20", []string{"alpine@" + alpine}, alpine},
+		{"myimage:latest", []string{"mirror.example.com/alpine@" + mirror, "alpine@" + alpine}, mirror},
+		{"myimage:latest", nil, ""},
+		{"myimage:latest", []string{"invalid"}, ""},
+	}
+	for _, tt := range tests {
+		ref, err := name.ParseReference(tt.ref)
+		if err != nil {
+			t.Fatalf("Failed to parse reference: %v", err)
+		}
+		if got := repoDigest(tt.repoDigests, ref); got != tt.want {
+			t.Errorf("repoDigest(%v, %q) = %q, want %q", tt.repoDigests, tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestContainerImageRef(t *testing.T) {
+	digest := "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		name        string
+		created     string
+		repoTags    []string
+		repoDigests []string
+		want        string
+	}{
+		{"created with a tag", "my-api", []string{"my-api:latest", "registry.example.com/my-api:1.0"}, nil, "my-api"},
+		{"created with a digest", "nginx@" + digest, []string{"nginx:1.27"}, []string{"nginx@" + digest}, "nginx@" + digest},
+		{"tag moved to another image", "my-api:dev", []string{"my-api:1.0"}, nil, "my-api:1.0"},
+		{"created with the image ID", "sha256:" + digest[7:19], nil, []string{"nginx@" + digest}, "nginx@" + digest},
+		{"rebuilt image without tags", "my-api:dev", nil, nil, ""},
+	}
+	for _, tt := range tests {
+		if got := containerImageRef(tt.created, tt.repoTags, tt.repoDigests); got != tt.want {
+			t.Errorf("%s: containerImageRef(%q, %v, %v) = %q, want %q", tt.name, tt.created, tt.repoTags, tt.repoDigests, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+// Package container loads container images from the local Docker daemon or a
+// registry and exposes the files of each layer.
+//
+// Layers are fetched lazily: Open only resolves the manifest and config,
+// and Layer.InitializeLayer downloads and indexes a layer on first use.
+//
+//	image, err := container.Open(ctx, "alpine:3.21",
+//		container.WithCacheDir(dir),
+//		container.WithPullPolicy(container.PullAlways),
+//	)
+//	if err != nil {
+//		return err
+//	}
+//	defer image.Close()
+//
+//	layer := &image.Layers[0]
+//	if err := layer.InitializeLayer(ctx, nil); err != nil {
+//		return err
+//	}
+//	files, err := layer.GetFiles(ctx, ".")
+//
+// The exported API of this package follows semantic versioning.
+package container
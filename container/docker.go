@@ -0,0 +1,129 @@
+package container
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/docker/docker/client"
+)
+
+// defaultDockerSocket is where the Docker daemon listens unless configured
+// otherwise
+var defaultDockerSocket = "/var/run/docker.sock"
+
+// dockerSockets are the sockets of the Docker daemons of Colima, Lima,
+// Rancher Desktop, Docker Desktop, OrbStack and rootless Docker, relative to
+// the home directory unless absolute, in the order they are probed
+func dockerSockets(home string) []string {
+	colima := filepath.Join(home, ".colima")
+	if dir := os.Getenv("COLIMA_HOME"); dir != "" {
+		colima = dir
+	}
+	sockets := []string{
+		filepath.Join(colima, "default", "docker.sock"),
+		filepath.Join(colima, "docker.sock"),
+		filepath.Join(home, ".lima", "docker", "sock", "docker.sock"),
+		filepath.Join(home, ".lima", "default", "sock", "docker.sock"),
+		filepath.Join(home, ".rd", "docker.sock"),
+		filepath.Join(home, ".docker", "run", "docker.sock"),
+		filepath.Join(home, ".orbstack", "run", "docker.sock"),
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		sockets = append(sockets, filepath.Join(dir, "docker.sock"))
+	}
+	return sockets
+}
+
+// dockerClient returns a client of the Docker daemon, found as by the Docker
+// CLI: DOCKER_HOST, or the endpoint of DOCKER_CONTEXT or of the current
+// context. Without any, the default socket is used if it exists and the
+// first of dockerSockets otherwise, as Colima and others don't listen on it.
+func dockerClient() (*client.Client, error) {
+	opts := []client.Opt{client.FromEnv, client.WithAPIVersionNegotiation()}
+	if host := dockerHost(); host != "" {
+		debug("Using the Docker daemon at %s", host)
+		opts = append(opts, client.WithHost(host))
+	}
+	cli, err := client.NewClientWithOpts(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create docker client: %w", err)
+	}
+	return cli, nil
+}
+
+// dockerHost returns the host of the Docker daemon to connect to, or an
+// empty string for the host of the environment or the default one
+func dockerHost() string {
+	if os.Getenv("DOCKER_HOST") != "" {
+		return ""
+	}
+	host, err := contextHost()
+	if err != nil {
+		debug("Failed to read the Docker context: %v", err)
+	}
+	if host != "" {
+		return host
+	}
+	if runtime.GOOS == "windows" {
+		return ""
+	}
+	if _, err := os.Stat(defaultDockerSocket); err == nil {
+		return ""
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	for _, path := range dockerSockets(home) {
+		if info, err := os.Stat(path); err == nil && info.Mode()&fs.ModeSocket != 0 {
+			return "unix://" + path
+		}
+	}
+	return ""
+}
+
+// contextHost returns the Docker endpoint of DOCKER_CONTEXT, or of the
+// current context of the Docker config, or an empty string for the default
+// context
+func contextHost() (string, error) {
+	configDir := os.Getenv("DOCKER_CONFIG")
+	if configDir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		configDir = filepath.Join(home, ".docker")
+	}
+
+	name := os.Getenv("DOCKER_CONTEXT")
+	if name == "" {
+		var config struct {
+			CurrentContext string `json:"currentContext"`
+		}
+		if err := readJSONFile(filepath.Join(configDir, "config.json"), &config); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return "", err
+		}
+		name = config.CurrentContext
+	}
+	if name == "" || name == "default" {
+		return "", nil
+	}
+
+	// The metadata of a context is stored under the digest of its name
+	digest := sha256.Sum256([]byte(name))
+	var meta struct {
+		Endpoints map[string]struct {
+			Host string `json:"Host"`
+		} `json:"Endpoints"`
+	}
+	if err := readJSONFile(filepath.Join(configDir, "contexts", "meta", hex.EncodeToString(digest[:]), "meta.json"), &meta); err != nil {
+		return "", fmt.Errorf("failed to read context %s: %w", name, err)
+	}
+	return meta.Endpoints["docker"].Host, nil
+}
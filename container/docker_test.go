@@ -0,0 +1,143 @@
+package container
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// listenUnix creates a socket at the path
+func listenUnix(t *testing.T, path string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+}
+
+// writeDockerContext writes the metadata of a Docker context as the Docker
+// CLI does
+func writeDockerContext(t *testing.T, configDir, name, host string) {
+	t.Helper()
+	digest := sha256.Sum256([]byte(name))
+	writeTestJSON(t, filepath.Join(configDir, "contexts", "meta", hex.EncodeToString(digest[:]), "meta.json"), map[string]any{
+		"Name":      name,
+		"Endpoints": map[string]any{"docker": map[string]any{"Host": host, "SkipTLSVerify": false}},
+	})
+}
+
+func TestDockerHost(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Docker sockets are not probed on Windows")
+	}
+
+	tests := []struct {
+		name    string
+		env     map[string]string
+		current string // current context of the Docker config
+		socket  string // socket created relative to the home directory
+		want    string // host, or socket relative to the home directory
+	}{
+		{
+			name:   "DOCKER_HOST",
+			env:    map[string]string{"DOCKER_HOST": "tcp://localhost:2375"},
+			socket: ".colima/default/docker.sock",
+		},
+		{
+			name: "DOCKER_CONTEXT",
+			env:  map[string]string{"DOCKER_CONTEXT": "lima"},
+			want: "unix:///lima.sock",
+		},
+		{
+			name:    "current context",
+			current: "colima",
+			want:    "unix:///colima.sock",
+		},
+		{
+			name:    "default context",
+			current: "default",
+			socket:  ".rd/docker.sock",
+			want:    ".rd/docker.sock",
+		},
+		{
+			name:   "Colima",
+			socket: ".colima/default/docker.sock",
+			want:   ".colima/default/docker.sock",
+		},
+		{
+			name:   "Lima",
+			socket: ".lima/docker/sock/docker.sock",
+			want:   ".lima/docker/sock/docker.sock",
+		},
+		{
+			name: "no socket",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Sockets must have short paths
+			home, err := os.MkdirTemp("", "sou")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { os.RemoveAll(home) })
+			configDir := filepath.Join(home, ".docker")
+			for _, name := range []string{"DOCKER_HOST", "DOCKER_CONTEXT", "COLIMA_HOME", "XDG_RUNTIME_DIR"} {
+				t.Setenv(name, "")
+			}
+			t.Setenv("HOME", home)
+			t.Setenv("DOCKER_CONFIG", configDir)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+			saved := defaultDockerSocket
+			defaultDockerSocket = filepath.Join(home, "missing.sock")
+			t.Cleanup(func() { defaultDockerSocket = saved })
+
+			writeDockerContext(t, configDir, "lima", "unix:///lima.sock")
+			writeDockerContext(t, configDir, "colima", "unix:///colima.sock")
+			if tt.current != "" {
+				writeTestJSON(t, filepath.Join(configDir, "config.json"), map[string]any{"currentContext": tt.current})
+			}
+			if tt.socket != "" {
+				listenUnix(t, filepath.Join(home, tt.socket))
+			}
+
+			want := tt.want
+			if want != "" && !strings.HasPrefix(want, "unix://") {
+				want = "unix://" + filepath.Join(home, want)
+			}
+			if got := dockerHost(); got != want {
+				t.Errorf("dockerHost() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestDockerHostDefaultSocket(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("DOCKER_HOST", "")
+	t.Setenv("DOCKER_CONTEXT", "")
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+	saved := defaultDockerSocket
+	defaultDockerSocket = filepath.Join(home, "docker.sock")
+	t.Cleanup(func() { defaultDockerSocket = saved })
+	if err := os.WriteFile(defaultDockerSocket, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The default socket is used as is
+	if got := dockerHost(); got != "" {
+		t.Errorf("dockerHost() = %q, want the default host", got)
+	}
+}
@@ -0,0 +1,102 @@
+package container
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+)
+
+// Errors returned by NewImage and Layer.InitializeLayer for common failures.
+// Use errors.Is to check for them; the original error is kept in the chain.
+var (
+	// ErrUnauthorized means the registry denied access, usually because the
+	// image is private and no valid credentials are configured
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrNotFound means the repository, tag or blob doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrRateLimited means the registry rejected the request because of too many requests
+	ErrRateLimited = errors.New("rate limited")
+	// ErrUnsupportedMediaType means the reference doesn't point to a container image
+	// that can be browsed, such as a schema 1 manifest or an OCI artifact
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+)
+
+// kindError attaches one of the sentinel errors to an error without changing its message
+type kindError struct {
+	kind error
+	err  error
+}
+
+func (e *kindError) Error() string {
+	return e.err.Error()
+}
+
+func (e *kindError) Unwrap() []error {
+	return []error{e.kind, e.err}
+}
+
+// classifyError tags registry errors with the matching sentinel error
+func classifyError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if kind := errorKind(err); kind != nil && !errors.Is(err, kind) {
+		return &kindError{kind: kind, err: err}
+	}
+	return err
+}
+
+func errorKind(err error) error {
+	if errors.Is(err, remote.ErrSchema1) {
+		return ErrUnsupportedMediaType
+	}
+
+	var terr *transport.Error
+	if !errors.As(err, &terr) {
+		return nil
+	}
+
+	for _, d := range terr.Errors {
+		switch d.Code {
+		case transport.UnauthorizedErrorCode, transport.DeniedErrorCode:
+			return ErrUnauthorized
+		case transport.ManifestUnknownErrorCode, transport.NameUnknownErrorCode, transport.BlobUnknownErrorCode:
+			return ErrNotFound
+		case transport.TooManyRequestsErrorCode:
+			return ErrRateLimited
+		case transport.UnsupportedErrorCode:
+			return ErrUnsupportedMediaType
+		}
+	}
+
+	switch terr.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrUnauthorized
+	case http.StatusNotFound:
+		return ErrNotFound
+	case http.StatusTooManyRequests:
+		return ErrRateLimited
+	case http.StatusUnsupportedMediaType:
+		return ErrUnsupportedMediaType
+	}
+	return nil
+}
+
+// checkMediaType returns ErrUnsupportedMediaType if the image config is not
+// a container image config, as with OCI artifacts like Helm charts
+func checkMediaType(img v1.Image) error {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return fmt.Errorf("failed to get manifest: %w", err)
+	}
+	switch manifest.Config.MediaType {
+	case "", types.DockerConfigJSON, types.OCIConfigJSON:
+		return nil
+	}
+	return fmt.Errorf("%w: config of type %s is not a container image", ErrUnsupportedMediaType, manifest.Config.MediaType)
+}
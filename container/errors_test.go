@@ -0,0 +1,126 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{
+			name: "unauthorized code",
+			err:  &transport.Error{StatusCode: http.StatusUnauthorized, Errors: []transport.Diagnostic{{Code: transport.UnauthorizedErrorCode}}},
+			want: ErrUnauthorized,
+		},
+		{
+			name: "forbidden status",
+			err:  &transport.Error{StatusCode: http.StatusForbidden},
+			want: ErrUnauthorized,
+		},
+		{
+			name: "manifest unknown",
+			err:  fmt.Errorf("failed to pull image: %w", &transport.Error{StatusCode: http.StatusNotFound, Errors: []transport.Diagnostic{{Code: transport.ManifestUnknownErrorCode}}}),
+			want: ErrNotFound,
+		},
+		{
+			name: "too many requests",
+			err:  &transport.Error{StatusCode: http.StatusTooManyRequests},
+			want: ErrRateLimited,
+		},
+		{
+			name: "schema 1",
+			err:  fmt.Errorf("unsupported MediaType: %w", remote.ErrSchema1),
+			want: ErrUnsupportedMediaType,
+		},
+		{
+			name: "other",
+			err:  errors.New("boom"),
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := classifyError(tt.err)
+			if got.Error() != tt.err.Error() {
+				t.Errorf("Expected message %q to be kept, got %q", tt.err.Error(), got.Error())
+			}
+			if !errors.Is(got, tt.err) {
+				t.Error("Expected the original error to be kept in the chain")
+			}
+			for _, kind := range []error{ErrUnauthorized, ErrNotFound, ErrRateLimited, ErrUnsupportedMediaType} {
+				if errors.Is(got, kind) != (kind == tt.want) {
+					t.Errorf("errors.Is(%v) = %v", kind, !(kind == tt.want))
+				}
+			}
+		})
+	}
+}
+
+func TestNewImageErrors(t *testing.T) {
+	original := DefaultRetryPolicy
+	DefaultRetryPolicy = fastRetryPolicy
+	t.Cleanup(func() { DefaultRetryPolicy = original })
+
+	handler := registry.New()
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/v2/private/") {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+
+	img, err := setupTestImage(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test image: %v", err)
+	}
+	artifact := mutate.ConfigMediaType(img, "application/vnd.cncf.helm.config.v1+json")
+	artifactRef, err := name.ParseReference(u.Host + "/test/chart:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.Write(artifactRef, artifact); err != nil {
+		t.Fatalf("Failed to push artifact: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		ref  string
+		want error
+	}{
+		{name: "not found", ref: u.Host + "/test/missing:latest", want: ErrNotFound},
+		{name: "unauthorized", ref: u.Host + "/private/image:latest", want: ErrUnauthorized},
+		{name: "artifact", ref: artifactRef.String(), want: ErrUnsupportedMediaType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := NewImage(context.Background(), tt.ref, mockProgressFunc)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
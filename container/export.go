@@ -0,0 +1,101 @@
+package container
+
+import (
+	"archive/tar"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+
+	"github.com/knqyf263/sou/tarfs"
+)
+
+// WriteTar writes the files of the initialized layer to w as a tar archive.
+// For layers stacked by MergeLayers, it is the filesystem a container of the
+// image sees: whiteouts are applied and don't appear in the archive. Hard
+// links are written as copies of the files they link to, as an upper layer
+// may hide or replace their targets. Files keep their owners, modes, times
+// and extended attributes.
+func (l *Layer) WriteTar(ctx context.Context, w io.Writer) error {
+	fsys := l.files()
+	if fsys == nil {
+		return fmt.Errorf("layer not initialized")
+	}
+	links, ok := fsys.(interface {
+		ReadLink(name string) (string, error)
+	})
+	if !ok {
+		return fmt.Errorf("layer doesn't support symbolic links")
+	}
+
+	tw := tar.NewWriter(w)
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeIrregular != 0 {
+			debug("WriteTar: Skipping irregular file %s", p)
+			return nil
+		}
+
+		var target string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if target, err = links.ReadLink(p); err != nil {
+				return err
+			}
+		}
+		hdr, err := tar.FileInfoHeader(info, target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+		hdr.Name = p
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		if h, ok := info.Sys().(*tarfs.Header); ok {
+			hdr.Uid, hdr.Gid = h.Uid(), h.Gid()
+			hdr.Uname, hdr.Gname = h.Uname(), h.Gname()
+			hdr.Devmajor, hdr.Devminor = h.Devmajor(), h.Devminor()
+			for name, value := range h.Xattrs() {
+				if hdr.PAXRecords == nil {
+					hdr.PAXRecords = make(map[string]string)
+				}
+				hdr.PAXRecords["SCHILY.xattr."+name] = value
+			}
+		}
+		if !info.Mode().IsRegular() {
+			return tw.WriteHeader(hdr)
+		}
+
+		// The size of a hard link is the one of its target
+		f, err := fsys.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		stat, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag, hdr.Linkname, hdr.Size = tar.TypeReg, "", stat.Size()
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write the archive: %w", err)
+	}
+	return tw.Close()
+}
@@ -0,0 +1,97 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestWriteTar(t *testing.T) {
+	upper := createMergeLayer(t,
+		testEntry{name: "bin/", dir: true},
+		testEntry{name: "bin/app", content: "new app", uid: 1000, gid: 1000},
+		testEntry{name: "bin/tool", content: "tool"},
+		testEntry{name: "bin/tool-link", hardlink: "bin/tool"},
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/.wh.motd"},
+		testEntry{name: "var/", dir: true},
+		testEntry{name: "var/.wh..wh..opq"},
+		testEntry{name: "var/run", link: "/run"},
+	)
+	lower := createMergeLayer(t,
+		testEntry{name: "bin/", dir: true},
+		testEntry{name: "bin/app", content: "old app"},
+		testEntry{name: "bin/sh", content: "sh"},
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/motd", content: "welcome"},
+		testEntry{name: "etc/hostname", content: "base"},
+		testEntry{name: "lib/", dir: true},
+		testEntry{name: "lib/app", content: "library"},
+		testEntry{name: "var/", dir: true},
+		testEntry{name: "var/cache/", dir: true},
+		testEntry{name: "var/cache/old", content: "stale"},
+	)
+
+	merged := MergeLayers(*upper, *lower)
+	var buf bytes.Buffer
+	if err := merged.WriteTar(context.Background(), &buf); err == nil {
+		t.Error("Expected an error before the layer is initialized")
+	}
+	if err := merged.InitializeLayer(context.Background(), nil); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+	buf.Reset()
+	if err := merged.WriteTar(context.Background(), &buf); err != nil {
+		t.Fatalf("WriteTar() error = %v", err)
+	}
+
+	type entry struct {
+		name     string
+		typeflag byte
+		content  string
+		linkname string
+		uid      int
+	}
+	var got []entry
+	tr := tar.NewReader(&buf)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, entry{hdr.Name, hdr.Typeflag, string(content), hdr.Linkname, hdr.Uid})
+	}
+	want := []entry{
+		{name: "bin/", typeflag: tar.TypeDir},
+		{name: "bin/app", typeflag: tar.TypeReg, content: "new app", uid: 1000},
+		{name: "bin/sh", typeflag: tar.TypeReg, content: "sh"},
+		{name: "bin/tool", typeflag: tar.TypeReg, content: "tool"},
+		{name: "bin/tool-link", typeflag: tar.TypeReg, content: "tool"},
+		{name: "etc/", typeflag: tar.TypeDir},
+		{name: "etc/hostname", typeflag: tar.TypeReg, content: "base"},
+		{name: "lib/", typeflag: tar.TypeDir},
+		{name: "lib/app", typeflag: tar.TypeReg, content: "library"},
+		{name: "var/", typeflag: tar.TypeDir},
+		{name: "var/run", typeflag: tar.TypeSymlink, linkname: "/run"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WriteTar() wrote\n%+v\nwant\n%+v", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := merged.WriteTar(ctx, io.Discard); !errors.Is(err, context.Canceled) {
+		t.Errorf("WriteTar() error = %v, want %v", err, context.Canceled)
+	}
+}
@@ -0,0 +1,146 @@
+package container
+
+import (
+	"cmp"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// FileType is the disk usage of the files of a category, such as shared
+// libraries, or of an extension
+type FileType struct {
+	Name  string
+	Files int
+	Size  int64
+}
+
+// typeDirs are the categories of files by the directories they are in,
+// checked before their extensions
+var typeDirs = []struct {
+	name string
+	dirs []string
+}{
+	{"Documentation", []string{"/usr/share/doc", "/usr/share/man", "/usr/share/info", "/usr/share/gtk-doc", "/usr/local/share/doc", "/usr/local/share/man"}},
+	{"Locales", []string{"/usr/share/locale", "/usr/lib/locale", "/usr/share/i18n", "/usr/local/share/locale"}},
+	{"Package metadata", []string{"/var/lib/apt/lists", "/var/lib/dpkg", "/var/lib/rpm", "/var/lib/dnf", "/var/lib/yum", "/lib/apk/db", "/usr/lib/sysimage/rpm"}},
+	{"Caches", []string{"/var/cache", "/root/.cache", "/tmp"}},
+}
+
+// typeExtensions are the categories of files by their extensions
+var typeExtensions = map[string]string{
+	".a":     "Static libraries",
+	".h":     "C headers",
+	".hpp":   "C headers",
+	".pyc":   "Python bytecode",
+	".pyo":   "Python bytecode",
+	".py":    "Python sources",
+	".jar":   "Java archives",
+	".war":   "Java archives",
+	".class": "Java classes",
+	".js":    "JavaScript",
+	".mjs":   "JavaScript",
+	".cjs":   "JavaScript",
+	".ts":    "TypeScript",
+	".go":    "Go sources",
+	".rb":    "Ruby sources",
+	".gem":   "Ruby gems",
+	".tar":   "Archives",
+	".gz":    "Archives",
+	".tgz":   "Archives",
+	".xz":    "Archives",
+	".bz2":   "Archives",
+	".zst":   "Archives",
+	".zip":   "Archives",
+	".whl":   "Archives",
+	".mo":    "Locales",
+	".md":    "Documentation",
+	".rst":   "Documentation",
+	".html":  "Documentation",
+	".pdf":   "Documentation",
+	".png":   "Images",
+	".jpg":   "Images",
+	".jpeg":  "Images",
+	".gif":   "Images",
+	".svg":   "Images",
+	".ico":   "Images",
+	".ttf":   "Fonts",
+	".otf":   "Fonts",
+	".woff":  "Fonts",
+	".woff2": "Fonts",
+}
+
+// sharedLibrary matches shared libraries, including the versioned ones
+// such as libc.so.6
+var sharedLibrary = regexp.MustCompile(`\.(so(\.[0-9]+)*|dylib|dll)$`)
+
+// Types returns the disk usage of the files under the directory of u, at
+// the slash-separated absolute path dir, by category or extension, the
+// largest first. Files with no known category are grouped by extension,
+// like "*.conf".
+func (u *Usage) Types(dir string) []FileType {
+	types := make(map[string]*FileType)
+	var walk func(u *Usage, p string)
+	walk = func(u *Usage, p string) {
+		for _, c := range u.Children {
+			cp := path.Join(p, c.Name)
+			if c.Dir {
+				walk(c, cp)
+				continue
+			}
+			name := fileCategory(cp)
+			t, ok := types[name]
+			if !ok {
+				t = &FileType{Name: name}
+				types[name] = t
+			}
+			t.Files++
+			t.Size += c.Size
+		}
+	}
+	walk(u, path.Join("/", dir))
+
+	var list []FileType
+	for _, t := range types {
+		list = append(list, *t)
+	}
+	slices.SortFunc(list, func(a, b FileType) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return list
+}
+
+// fileCategory returns the category of the file at the absolute path p
+func fileCategory(p string) string {
+	for _, t := range typeDirs {
+		for _, dir := range t.dirs {
+			if strings.HasPrefix(p, dir+"/") {
+				return t.name
+			}
+		}
+	}
+	if strings.Contains(p, "/__pycache__/") {
+		return "Python bytecode"
+	}
+	if strings.Contains(p, "/node_modules/") {
+		return "Node.js modules"
+	}
+
+	name := path.Base(p)
+	if sharedLibrary.MatchString(name) {
+		return "Shared libraries"
+	}
+	ext := strings.ToLower(path.Ext(name))
+	if name, ok := typeExtensions[ext]; ok {
+		return name
+	}
+	if ext == "" || ext == strings.ToLower(name) {
+		// Hidden files such as .bashrc have no extension either
+		return "No extension"
+	}
+	return "*" + ext
+}
@@ -0,0 +1,65 @@
+package container
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUsageTypes(t *testing.T) {
+	file := func(name string, size int64) *Usage {
+		return &Usage{Name: name, Size: size}
+	}
+	dir := func(name string, children ...*Usage) *Usage {
+		return &Usage{Name: name, Dir: true, Children: children}
+	}
+	root := dir("/",
+		dir("usr",
+			dir("lib",
+				file("libc.so.6", 2000),
+				file("libssl.so", 500),
+				file("libz.a", 100),
+				dir("python3",
+					file("os.py", 40),
+					dir("__pycache__", file("os.cpython-312.pyc", 60)),
+				),
+			),
+			dir("share",
+				dir("doc", dir("bash", file("README.md", 30), file("copyright", 20))),
+				dir("locale", dir("de", dir("LC_MESSAGES", file("bash.mo", 70)))),
+			),
+		),
+		dir("etc", file("nginx.conf", 10), file("app.CONF", 5), file("hostname", 1), file(".profile", 2)),
+		dir("var", dir("cache", dir("apt", file("pkgcache.bin", 300)))),
+		dir("empty"),
+	)
+
+	got := root.Types("/")
+	want := []FileType{
+		{Name: "Shared libraries", Files: 2, Size: 2500},
+		{Name: "Caches", Files: 1, Size: 300},
+		{Name: "Static libraries", Files: 1, Size: 100},
+		{Name: "Locales", Files: 1, Size: 70},
+		{Name: "Python bytecode", Files: 1, Size: 60},
+		{Name: "Documentation", Files: 2, Size: 50},
+		{Name: "Python sources", Files: 1, Size: 40},
+		{Name: "*.conf", Files: 2, Size: 15},
+		{Name: "No extension", Files: 2, Size: 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Types() = %v, want %v", got, want)
+	}
+
+	// The paths of a directory are absolute
+	got = root.Children[0].Children[1].Types("usr/share")
+	want = []FileType{
+		{Name: "Locales", Files: 1, Size: 70},
+		{Name: "Documentation", Files: 2, Size: 50},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Types(usr/share) = %v, want %v", got, want)
+	}
+
+	if got := dir("empty").Types("/empty"); got != nil {
+		t.Errorf("Types() of an empty directory = %v, want nil", got)
+	}
+}
@@ -1,108 +1,351 @@
 package container
 
 import (
+	"bytes"
+	"cmp"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
-	"log/slog"
+	"maps"
 	"os"
-	"path/filepath"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
-	"github.com/google/go-containerregistry/pkg/v1/daemon"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/types"
 	"github.com/knqyf263/sou/tarfs"
 )
 
-func debug(format string, v ...interface{}) {
-	slog.Debug(fmt.Sprintf(format, v...))
-}
-
 // Image represents a container image
 type Image struct {
+	// Reference is the image reference the image was loaded from
 	Reference string
-	Layers    []Layer
-	img       v1.Image
+	// Layers are the layers that add content, from the newest to the oldest
+	Layers []Layer
+	// Local is true if the image was loaded from the local Docker daemon
+	Local bool
+	// History are the build steps of the image from the newest to the
+	// oldest, including the steps changing only the config like ENV or
+	// LABEL. It is empty if the history doesn't match the layers.
+	History []History
+
+	img v1.Image
+	// indexAnnotations are the annotations of the index the image was
+	// resolved from, if any
+	indexAnnotations map[string]string
+	// repoDigest is the manifest digest of local images, if known
+	repoDigest string
+}
+
+// History is a build step of an image
+type History struct {
+	// Command is the command of the step, or "N/A" if unknown
+	Command string
+	// Created is the time of the step in UTC, or zero if unknown
+	Created time.Time
+	// Layer is the index in Image.Layers of the layer created by the step,
+	// or -1 if the step created none
+	Layer int
 }
 
-// Layer represents an image layer
+// Layer represents an image layer. Its files are available after
+// InitializeLayer succeeds.
 type Layer struct {
-	DiffID  string
-	Size    int64
+	// DiffID is the digest of the uncompressed layer content
+	DiffID string
+	// Digest is the digest of the layer blob as stored in the registry,
+	// usually compressed, or empty if unknown. Layers exported from the
+	// Docker daemon are stored uncompressed and have no such digest.
+	Digest string
+	// Size is the size of the layer in bytes as stored by its source
+	Size int64
+	// Command is the command that created the layer, or "N/A" if unknown
 	Command string
-	layer   v1.Layer
-	fs      *tarfs.FS
+	// Created is the creation time of the layer in UTC, or zero if unknown
+	Created time.Time
+	// MediaType is the media type of the layer blob, or empty if unknown
+	MediaType string
+	// Title is the file name given to the blob by its
+	// org.opencontainers.image.title annotation, as with artifacts
+	Title string
+
+	layer      v1.Layer // resolved from img by digest when nil
+	img        v1.Image
+	digest     v1.Hash
+	fs         *tarfs.FS
+	file       *os.File  // backs fs for layers cached on disk
+	parts      []*Layer  // layers stacked by MergeLayers
+	merged     *MergedFS // files of parts once initialized
+	layerCache *Cache
+	cached     bool      // uses the cached content, released by Close
+	accounts   *Accounts // names of file owners
+	ignoreCase bool      // look up paths ignoring case
+	// inMemoryThreshold is the largest content kept in memory, set by Open.
+	// Layers created otherwise are written to the cache directory.
+	inMemoryThreshold int64
+
+	// transfer and retryPolicy are set for layers fetched from a registry
+	transfer    *transfer
+	retryPolicy RetryPolicy
 }
 
 // File represents a file in a layer
 type File struct {
-	Name    string
+	Name    string // base name
 	IsDir   bool
-	Path    string
+	Path    string // path relative to the layer root
 	Size    int64
-	Mode    string
-	ModTime string
+	Mode    string // formatted by fs.FileMode.String
+	ModTime string // formatted as "2006-01-02 15:04:05"
+	// ModifiedAt is the modification time in UTC
+	ModifiedAt time.Time
+	// FileMode is the mode with the type bits. It has fs.ModeSymlink for
+	// links even if IsDir is set because the target is a directory.
+	FileMode fs.FileMode
+	Uid      int
+	Gid      int
+	Owner    string // formatted as "user:group", using IDs when names are unknown
+	// Devmajor and Devminor are the device numbers of character and block devices
+	Devmajor int64
+	Devminor int64
+}
+
+// DefaultInMemoryThreshold is the maximum uncompressed layer size in bytes
+// that is kept in memory instead of being written to the cache directory,
+// unless WithInMemoryThreshold sets another one
+const DefaultInMemoryThreshold int64 = 8 << 20
+
+// NewImage creates a new Image instance from a reference using the default
+// cache, which is removed by CleanupCache. It reports whether the image was
+// loaded from the local Docker daemon.
+// The context is also used for fetching layer contents later on, so it
+// should not be canceled while the image is in use.
+func NewImage(ctx context.Context, ref string, progress ProgressFunc) (*Image, bool, error) {
+	image, err := Open(ctx, ref, WithProgress(progress))
+	if err != nil {
+		return nil, false, err
+	}
+	return image, image.Local, nil
 }
 
-// ProgressFunc is a callback function to report progress
-type ProgressFunc func(float64)
+// NewImageWithCache is like NewImage but stores layers in the given cache.
+// The caller is responsible for calling Cleanup on the cache. progress may be nil.
+//
+// Deprecated: Use Open with WithCache and WithProgress.
+func NewImageWithCache(ctx context.Context, ref string, cache *Cache, progress ProgressFunc) (*Image, bool, error) {
+	image, err := Open(ctx, ref, WithCache(cache), WithProgress(progress))
+	if err != nil {
+		return nil, false, err
+	}
+	return image, image.Local, nil
+}
+
+// Open loads an image from a reference. By default, the image of the local
+// Docker daemon is used if it exists, and it is pulled from the registry
+// otherwise. Layer contents are fetched later by Layer.InitializeLayer.
+// The context is also used for fetching layer contents, so it should not be
+// canceled while the image is in use.
+func Open(ctx context.Context, ref string, opts ...Option) (*Image, error) {
+	o := newOptions(opts)
+	image, err := openImage(ctx, ref, o)
+	if err != nil {
+		return nil, err
+	}
+	for i := range image.Layers {
+		image.Layers[i].layerCache = o.cache
+		image.Layers[i].ignoreCase = o.ignoreCase
+		image.Layers[i].inMemoryThreshold = o.inMemoryThreshold
+	}
+	return image, nil
+}
+
+func openImage(ctx context.Context, ref string, o *options) (*Image, error) {
+	if IsSIF(ref) {
+		return sifImage(ref, o)
+	}
+	if IsImageArchive(ref) {
+		return archiveFileImage(ref, o)
+	}
 
-// NewImage creates a new Image instance from a reference
-func NewImage(ref string, progress ProgressFunc) (*Image, bool, error) {
 	reference, err := name.ParseReference(ref)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to parse reference: %w", err)
+		return nil, fmt.Errorf("failed to parse reference: %w", err)
 	}
 
-	// Try to get the image from the local daemon first
-	img, err := daemon.Image(reference)
-	if err == nil {
-		debug("Found local image")
-		image, err := createImageFromV1(img, ref)
-		if err != nil {
-			debug("Failed to create image from local daemon: %v", err)
-			return nil, false, err
+	if o.pullPolicy != PullAlways {
+		// Try to get the image from the local daemon first
+		image, err := localImage(ctx, reference, ref, o)
+		if err == nil {
+			debug("Successfully loaded local image")
+			return image, nil
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
 		}
-		debug("Successfully loaded local image, returning with isLocalImage=true")
-		return image, true, nil
+		if o.pullPolicy == PullNever {
+			return nil, &kindError{kind: ErrNotFound, err: fmt.Errorf("image not available locally: %w", err)}
+		}
+		debug("Image not found locally: %v", err)
 	}
 
 	// If not found locally, try to pull from remote
-	debug("Image not found locally, pulling from registry")
-	fmt.Printf("Image not found locally. Pulling from registry...\n")
-
-	progressChan := make(chan v1.Update, 100)
-	go func() {
-		var last float64
-		for update := range progressChan {
-			if update.Total > 0 {
-				current := float64(update.Complete) / float64(update.Total)
-				if current > last {
-					progress(current)
-					last = current
-				}
-			}
+	debug("Pulling image from registry")
+	return remoteImage(ctx, reference, ref, o)
+}
+
+// HasLocalImage reports whether the source of local images has the image,
+// the Docker daemon unless WithSource sets another one
+func HasLocalImage(ctx context.Context, ref string, opts ...Option) bool {
+	o := newOptions(opts)
+	reference, err := name.ParseReference(ref)
+	if err != nil {
+		return false
+	}
+	switch o.source {
+	case SourceCRIO:
+		c, err := readStorageConfig()
+		if err != nil {
+			return false
 		}
-	}()
+		_, _, _, err = findStorageImage(c, reference, ref)
+		return err == nil
+	case SourceContainerd:
+		images, err := readContainerdImages(containerdRoot())
+		if err != nil {
+			return false
+		}
+		_, err = findContainerdImage(images, o.namespace, reference)
+		return err == nil
+	}
+	_, err = LocalImageID(ctx, ref)
+	return err == nil
+}
 
-	img, err = remote.Image(reference, remote.WithProgress(progressChan))
+// localImage loads the image from the Docker daemon, or from the storage of
+// CRI-O or containerd
+func localImage(ctx context.Context, reference name.Reference, ref string, o *options) (*Image, error) {
+	var img *archiveImage
+	var err error
+	switch o.source {
+	case SourceCRIO:
+		img, err = crioImage(reference, ref)
+	case SourceContainerd:
+		img, err = containerdImage(reference, o)
+	default:
+		img, err = daemonImage(ctx, reference, o.cache, o.progress)
+	}
 	if err != nil {
-		debug("Failed to pull remote image: %v", err)
-		return nil, false, fmt.Errorf("failed to pull image: %w", err)
+		return nil, err
+	}
+	debug("Found local image")
+
+	if o.platform != nil {
+		configFile, err := img.ConfigFile()
+		if err != nil {
+			img.Close()
+			return nil, fmt.Errorf("failed to get config file: %w", err)
+		}
+		if p := configFile.Platform(); p != nil && !p.Satisfies(*o.platform) {
+			img.Close()
+			return nil, fmt.Errorf("local image is for %s, not %s", p, o.platform)
+		}
 	}
 
-	close(progressChan)
-	progress(1.0) // Ensure we show 100% completion
 	image, err := createImageFromV1(img, ref)
 	if err != nil {
-		debug("Failed to create image from remote: %v", err)
-		return nil, false, err
+		debug("Failed to create image from local daemon: %v", err)
+		img.Close()
+		return nil, err
+	}
+	image.Local = true
+	image.repoDigest = img.repoDigest
+	return image, nil
+}
+
+// remoteImage resolves the image from the registry
+func remoteImage(ctx context.Context, reference name.Reference, ref string, o *options) (*Image, error) {
+	// remote.Image only fetches the manifest, and the config is fetched while
+	// creating the image, so both are retried together
+	opts := append(o.remoteOptions(), remote.WithContext(ctx))
+	var image *Image
+	err := o.retryPolicy.do(ctx, func() error {
+		// The descriptor is fetched first to keep the annotations of the
+		// index, which remote.Image drops
+		desc, err := remote.Get(reference, opts...)
+		if err != nil {
+			debug("Failed to pull remote image: %v", err)
+			return fmt.Errorf("failed to pull image: %w", err)
+		}
+		var indexAnnotations map[string]string
+		if desc.MediaType.IsIndex() {
+			index, err := desc.ImageIndex()
+			if err != nil {
+				return fmt.Errorf("failed to pull image: %w", err)
+			}
+			manifest, err := index.IndexManifest()
+			if err != nil {
+				return fmt.Errorf("failed to pull image: %w", err)
+			}
+			indexAnnotations = manifest.Annotations
+		}
+		img, err := desc.Image()
+		if err != nil {
+			debug("Failed to pull remote image: %v", err)
+			return fmt.Errorf("failed to pull image: %w", err)
+		}
+		if err := checkMediaType(img); err != nil {
+			return err
+		}
+		reportBlobs(img, o.progress, false)
+		image, err = createImageFromV1(img, ref)
+		if err != nil {
+			debug("Failed to create image from remote: %v", err)
+			return err
+		}
+		image.indexAnnotations = indexAnnotations
+		reportBlobs(img, o.progress, true)
+		return nil
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classifyError(err)
+	}
+
+	o.progress(Progress{Stage: StageDone}) // Ensure we show 100% completion
+	for i := range image.Layers {
+		image.Layers[i].transfer = &transfer{}
+		image.Layers[i].retryPolicy = o.retryPolicy
 	}
 	debug("Successfully pulled remote image")
-	return image, false, nil
+	return image, nil
+}
+
+// reportBlobs reports the manifest of the image as fetched, and its config
+// as fetched once configFetched is true
+func reportBlobs(img v1.Image, progress ProgressFunc, configFetched bool) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return
+	}
+	if digest, err := img.Digest(); err == nil && !configFetched {
+		size, _ := img.Size()
+		progress(Progress{Stage: StageResolving, Blob: digest.String(), Complete: size, Total: size})
+	}
+	config := Progress{Stage: StageResolving, Blob: manifest.Config.Digest.String(), Total: manifest.Config.Size}
+	if configFetched {
+		config.Complete = config.Total
+	}
+	progress(config)
 }
 
 // isBuildpacksImage checks if the image is built with Cloud Native Buildpacks
@@ -153,14 +396,14 @@ func shouldProcessLayer(h v1.History, isBuildpacks bool) bool {
 
 // createImageFromV1 creates an Image instance from a v1.Image
 func createImageFromV1(img v1.Image, ref string) (*Image, error) {
-	layers, err := img.Layers()
+	configFile, err := img.ConfigFile()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get layers: %w", err)
+		return nil, fmt.Errorf("failed to get config file: %w", err)
 	}
 
-	configFile, err := img.ConfigFile()
+	layers, err := describeLayers(img, configFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get config file: %w", err)
+		return nil, err
 	}
 
 	var imageLayers []Layer
@@ -170,23 +413,7 @@ func createImageFromV1(img v1.Image, ref string) (*Image, error) {
 		debug("No history information available, creating layers with N/A commands")
 		// Process layers from newest to oldest
 		for i := len(layers) - 1; i >= 0; i-- {
-			layer := layers[i]
-			diffID, err := layer.DiffID()
-			if err != nil {
-				continue
-			}
-
-			size, err := layer.Size()
-			if err != nil {
-				continue
-			}
-
-			imageLayers = append(imageLayers, Layer{
-				DiffID:  diffID.String(),
-				Size:    size,
-				Command: "N/A",
-				layer:   layer,
-			})
+			imageLayers = append(imageLayers, layers[i].newLayer(img, "N/A"))
 		}
 		return &Image{
 			Reference: ref,
@@ -213,23 +440,9 @@ func createImageFromV1(img v1.Image, ref string) (*Image, error) {
 	}
 
 	// Create a map of DiffIDs to their corresponding layers for quick lookup
-	diffIDMap := make(map[string]struct {
-		layer v1.Layer
-		size  int64
-	})
-	for _, layer := range layers {
-		diffID, err := layer.DiffID()
-		if err != nil {
-			continue
-		}
-		size, err := layer.Size()
-		if err != nil {
-			continue
-		}
-		diffIDMap[diffID.String()] = struct {
-			layer v1.Layer
-			size  int64
-		}{layer, size}
+	diffIDMap := make(map[string]layerDescriptor)
+	for _, desc := range layers {
+		diffIDMap[desc.diffID] = desc
 	}
 
 	// Get rootfs DiffIDs which are in the correct order (oldest to newest)
@@ -248,23 +461,7 @@ func createImageFromV1(img v1.Image, ref string) (*Image, error) {
 		debug("Creating layers with available information (non-empty: %d, layers: %d)", nonEmptyCount, len(layers))
 		// Process layers from newest to oldest
 		for i := len(layers) - 1; i >= 0; i-- {
-			layer := layers[i]
-			diffID, err := layer.DiffID()
-			if err != nil {
-				continue
-			}
-
-			size, err := layer.Size()
-			if err != nil {
-				continue
-			}
-
-			imageLayers = append(imageLayers, Layer{
-				DiffID:  diffID.String(),
-				Size:    size,
-				Command: "N/A",
-				layer:   layer,
-			})
+			imageLayers = append(imageLayers, layers[i].newLayer(img, "N/A"))
 		}
 		return &Image{
 			Reference: ref,
@@ -292,24 +489,26 @@ func createImageFromV1(img v1.Image, ref string) (*Image, error) {
 		step = 1
 	}
 
+	var steps []History
 	for i := startIdx; ascending && i >= endIdx || !ascending && i <= endIdx; i += step {
+		command := history[i].CreatedBy
+		if command == "" {
+			command = "N/A"
+		}
+		created := history[i].Created.Time.UTC()
+
 		if shouldProcessLayer(history[i], isBuildpacks) && layerIndex >= 0 {
 			diffID := diffIDs[layerIndex].String()
 			if layerInfo, ok := diffIDMap[diffID]; ok {
-				command := history[i].CreatedBy
-				if command == "" {
-					command = "N/A"
-				}
-
-				imageLayers = append(imageLayers, Layer{
-					DiffID:  diffID,
-					Size:    layerInfo.size,
-					Command: command,
-					layer:   layerInfo.layer,
-				})
+				layer := layerInfo.newLayer(img, command)
+				layer.Created = created
+				steps = append(steps, History{Command: command, Created: created, Layer: len(imageLayers)})
+				imageLayers = append(imageLayers, layer)
 				processedLayers[diffID] = true
 				layerIndex--
 			}
+		} else if history[i].EmptyLayer {
+			steps = append(steps, History{Command: command, Created: created, Layer: -1})
 		}
 	}
 
@@ -318,12 +517,8 @@ func createImageFromV1(img v1.Image, ref string) (*Image, error) {
 		diffID := diffIDs[i].String()
 		if !processedLayers[diffID] {
 			if layerInfo, ok := diffIDMap[diffID]; ok {
-				imageLayers = append(imageLayers, Layer{
-					DiffID:  diffID,
-					Size:    layerInfo.size,
-					Command: "N/A",
-					layer:   layerInfo.layer,
-				})
+				steps = append(steps, History{Command: "N/A", Layer: len(imageLayers)})
+				imageLayers = append(imageLayers, layerInfo.newLayer(img, "N/A"))
 				processedLayers[diffID] = true
 			}
 		}
@@ -332,52 +527,192 @@ func createImageFromV1(img v1.Image, ref string) (*Image, error) {
 	return &Image{
 		Reference: ref,
 		Layers:    imageLayers,
+		History:   steps,
 		img:       img,
 	}, nil
 }
 
-// progressReader wraps an io.Reader to track progress
-type progressReader struct {
-	r          io.Reader
-	total      int64
-	current    int64
-	progress   func(float64)
-	lastUpdate time.Time
-}
-
-func (pr *progressReader) Read(p []byte) (int, error) {
-	n, err := pr.r.Read(p)
-	if n > 0 {
-		pr.current += int64(n)
-		if pr.total > 0 {
-			now := time.Now()
-			// Update progress at most once every 50ms
-			if now.Sub(pr.lastUpdate) >= 50*time.Millisecond {
-				progress := float64(pr.current) / float64(pr.total)
-				if progress > 1.0 {
-					progress = 1.0
-				}
-				// Scale progress to be between 0.0 and 1.0
-				if pr.progress != nil {
-					pr.progress(progress)
-				}
-				pr.lastUpdate = now
+// annotationTitle names the file of a blob, as set by ORAS for artifacts
+const annotationTitle = "org.opencontainers.image.title"
+
+// layerDescriptor describes a layer of an image without reading its content
+type layerDescriptor struct {
+	diffID string
+	size   int64
+	digest v1.Hash  // digest of the layer blob, used to resolve the layer lazily
+	layer  v1.Layer // nil until the layer is opened
+
+	mediaType types.MediaType
+	title     string
+}
+
+// newLayer creates a Layer from the descriptor
+func (d layerDescriptor) newLayer(img v1.Image, command string) Layer {
+	var digest string
+	if d.digest != (v1.Hash{}) {
+		digest = d.digest.String()
+	}
+	return Layer{
+		DiffID:    d.diffID,
+		Digest:    digest,
+		Size:      d.size,
+		Command:   command,
+		MediaType: string(d.mediaType),
+		Title:     d.title,
+		layer:     d.layer,
+		img:       img,
+		digest:    d.digest,
+	}
+}
+
+// describeLayers returns the layers of the image from oldest to newest.
+// Sizes and digests are taken from the manifest and diff IDs from the config
+// so that remote layers are not resolved before they are opened. Layers of
+// saved archives have no manifest to read them from and are described directly.
+func describeLayers(img v1.Image, configFile *v1.ConfigFile) ([]layerDescriptor, error) {
+	if _, ok := img.(*archiveImage); !ok {
+		manifest, err := img.Manifest()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get manifest: %w", err)
+		}
+		diffIDs := configFile.RootFS.DiffIDs
+		if len(manifest.Layers) == len(diffIDs) {
+			descs := make([]layerDescriptor, 0, len(diffIDs))
+			for i, desc := range manifest.Layers {
+				descs = append(descs, layerDescriptor{
+					diffID:    diffIDs[i].String(),
+					size:      desc.Size,
+					digest:    desc.Digest,
+					mediaType: desc.MediaType,
+					title:     desc.Annotations[annotationTitle],
+				})
 			}
+			return descs, nil
 		}
+		debug("Manifest has %d layers but config has %d diff IDs, resolving layers", len(manifest.Layers), len(diffIDs))
 	}
 
-	// Ensure we send the final progress when the read is complete
-	if err == io.EOF && pr.current > 0 && pr.total > 0 && pr.progress != nil {
-		pr.progress(1.0) // Final progress
+	layers, err := img.Layers()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layers: %w", err)
 	}
+	var descs []layerDescriptor
+	for _, layer := range layers {
+		diffID, err := layer.DiffID()
+		if err != nil {
+			continue
+		}
+		size, err := layerSize(layer)
+		if err != nil {
+			continue
+		}
+		desc := layerDescriptor{
+			diffID: diffID.String(),
+			size:   size,
+			layer:  layer,
+		}
+		if mediaType, err := layer.MediaType(); err == nil {
+			desc.mediaType = mediaType
+		}
+		// The digest of a daemon layer would require compressing it
+		if _, ok := layer.(*archiveLayer); !ok {
+			if digest, err := layer.Digest(); err == nil {
+				desc.digest = digest
+			}
+		}
+		descs = append(descs, desc)
+	}
+	return descs, nil
+}
+
+// IsArchive reports whether the layer is a tar archive, possibly compressed,
+// whose files can be listed. Layers of artifacts, such as WebAssembly
+// modules, are opaque blobs instead.
+func (l *Layer) IsArchive() bool {
+	switch mediaType := types.MediaType(l.MediaType); mediaType {
+	case "", types.DockerLayer, types.DockerForeignLayer, types.DockerUncompressedLayer,
+		types.OCILayer, types.OCILayerZStd, types.OCIRestrictedLayer, types.OCIUncompressedLayer,
+		types.OCIUncompressedRestrictedLayer, sifSquashfsLayer:
+		return true
+	default:
+		return strings.HasPrefix(string(mediaType), "application/vnd.oci.image.layer.")
+	}
+}
+
+// OpenBlob opens the layer blob as stored by its source, without
+// decompressing it. Reading stops once the context is canceled.
+func (l *Layer) OpenBlob(ctx context.Context) (io.ReadCloser, error) {
+	layer, err := l.v1Layer()
+	if err != nil {
+		return nil, err
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, classifyError(fmt.Errorf("failed to get layer blob: %w", err))
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{&ctxReader{ctx: ctx, r: rc}, rc}, nil
+}
+
+// v1Layer returns the underlying layer, resolving it from the image on first use
+func (l *Layer) v1Layer() (v1.Layer, error) {
+	if l.layer != nil {
+		return l.layer, nil
+	}
+	if l.img == nil {
+		return nil, fmt.Errorf("layer %s has no image", l.DiffID)
+	}
+	layer, err := l.img.LayerByDigest(l.digest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layer %s: %w", l.digest, err)
+	}
+	l.layer = layer
+	return layer, nil
+}
 
-	return n, err
+// indexLayer builds the file index of the layer content, reporting the bytes indexed
+func (l *Layer) indexLayer(r io.ReadSeeker, size int64, progress ProgressFunc) (*tarfs.FS, error) {
+	progress(Progress{Stage: StageIndexing, Layer: l.DiffID, Total: size})
+	opts := []tarfs.Option{tarfs.WithProgress(func(p tarfs.IndexProgress) {
+		progress(Progress{Stage: StageIndexing, Layer: l.DiffID, Complete: p.Bytes, Total: size})
+	})}
+	if l.ignoreCase {
+		opts = append(opts, tarfs.WithCaseInsensitive())
+	}
+	return tarfs.New(r, opts...)
+}
+
+// reportDone reports that the layer is ready
+func (l *Layer) reportDone(progress ProgressFunc) {
+	progress(Progress{Stage: StageDone, Layer: l.DiffID})
+}
+
+// cache returns the cache of the layer
+func (l *Layer) cache() *Cache {
+	if l.layerCache == nil {
+		return defaultCache
+	}
+	return l.layerCache
 }
 
 // initializeFromCache attempts to initialize the layer from cache
 // Returns true if successful, false if cache miss or error
-func (l *Layer) initializeFromCache(progress func(float64)) (bool, error) {
-	cachedPath := getCachedLayer(l.DiffID)
+func (l *Layer) initializeFromCache(progress ProgressFunc) (bool, error) {
+	if content := l.cache().getLayerContent(l.DiffID); content != nil {
+		debug("InitializeLayer: Found in-memory layer")
+		tfs, err := l.indexLayer(bytes.NewReader(content), int64(len(content)), progress)
+		if err != nil {
+			debug("InitializeLayer: Failed to create tarfs from memory: %v", err)
+			return false, nil // Treat as cache miss
+		}
+		l.fs = tfs
+		l.reportDone(progress)
+		return true, nil
+	}
+
+	cachedPath := l.cache().getLayer(l.DiffID)
 	if cachedPath == "" {
 		return false, nil
 	}
@@ -394,124 +729,301 @@ func (l *Layer) initializeFromCache(progress func(float64)) (bool, error) {
 		}
 	}()
 
-	progress(0.5)
+	var size int64
+	if info, err := file.Stat(); err == nil {
+		size = info.Size()
+	}
 	debug("InitializeLayer: Creating tarfs from cache")
-	tfs, err := tarfs.New(file)
+	tfs, err := l.indexLayer(file, size, progress)
 	if err != nil {
 		debug("InitializeLayer: Failed to create tarfs from cache: %v", err)
 		return false, nil // Treat as cache miss
 	}
 
 	l.fs = tfs
-	progress(1.0)
+	l.file = file
+	l.reportDone(progress)
 	debug("InitializeLayer: Successfully loaded from cache")
 	return true, nil
 }
 
 // createNewLayer creates a new layer from the uncompressed content
-func (l *Layer) createNewLayer(progress func(float64)) error {
-	tmpFile, err := getCacheFilePath()
+func (l *Layer) createNewLayer(ctx context.Context, progress ProgressFunc) error {
+	debug("InitializeLayer: Getting layer content")
+
+	pr, err := l.openContent(progress)
 	if err != nil {
-		return fmt.Errorf("failed to get cache file path: %w", err)
+		return err
 	}
-	debug("InitializeLayer: Created temp file at %s", tmpFile)
+	defer pr.Close()
 
-	file, err := os.Create(tmpFile)
+	// Closing the source unblocks any pending read when the context is canceled
+	stop := context.AfterFunc(ctx, func() {
+		pr.source.Close()
+	})
+	defer stop()
+
+	// Read up to the threshold into memory first. If the whole layer fits,
+	// the tarfs is built over the buffer and no cache file is created.
+	var buf bytes.Buffer
+	n, err := io.CopyN(&buf, pr, l.inMemoryThreshold+1)
+	if err != nil && err != io.EOF {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to copy layer content: %w", err)
+	}
+	if n <= l.inMemoryThreshold {
+		return l.createInMemoryLayer(buf.Bytes(), progress)
+	}
+
+	file, err := l.cache().createLayerFile()
 	if err != nil {
 		return fmt.Errorf("failed to create cache file: %w", err)
 	}
+	tmpFile := file.Name()
+	debug("InitializeLayer: Created temp file at %s", tmpFile)
 	defer func() {
 		if l.fs == nil {
-			file.Close() // Only close if initialization failed
+			// Only clean up if initialization failed
+			file.Close()
+			os.Remove(tmpFile)
 		}
 	}()
 
-	progress(0.2)
-	debug("InitializeLayer: Getting layer content")
+	debug("InitializeLayer: Copying layer content")
+	written, err := io.Copy(file, io.MultiReader(&buf, pr))
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return fmt.Errorf("failed to copy layer content: %w", err)
+	}
 
-	rc, err := l.layer.Uncompressed()
+	debug("InitializeLayer: Content copied successfully")
+
+	if _, err := file.Seek(0, 0); err != nil {
+		return fmt.Errorf("failed to seek cache file: %w", err)
+	}
+
+	debug("InitializeLayer: Creating tarfs")
+	tfs, err := l.indexLayer(file, written, progress)
 	if err != nil {
-		return fmt.Errorf("failed to get layer content: %w", err)
+		return fmt.Errorf("failed to create tarfs: %w", err)
 	}
-	defer rc.Close()
 
-	size, err := l.layer.Size()
+	l.cache().putLayer(l.DiffID, tmpFile)
+	l.fs = tfs
+	l.file = file
+	l.reportDone(progress)
+	debug("InitializeLayer: Layer initialization completed successfully")
+
+	return nil
+}
+
+// openContent returns the uncompressed layer content with progress reporting.
+// Remote layers are read from the compressed blob so that progress matches the
+// bytes actually downloaded.
+func (l *Layer) openContent(progress ProgressFunc) (*readCloser, error) {
+	layer, err := l.v1Layer()
 	if err != nil {
-		return fmt.Errorf("failed to get layer size: %w", err)
+		return nil, err
+	}
+	size, err := layerSize(layer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layer size: %w", err)
 	}
 	debug("InitializeLayer: Layer size: %d bytes", size)
 
 	pr := &progressReader{
-		r:          rc,
-		total:      size,
 		progress:   progress,
+		stage:      StageDecompressing,
+		layer:      l.DiffID,
+		total:      size,
 		lastUpdate: time.Now(),
 	}
 
-	debug("InitializeLayer: Copying layer content")
-	if _, err := io.Copy(file, pr); err != nil {
-		return fmt.Errorf("failed to copy layer content: %w", err)
+	if l.transfer == nil {
+		pr.report()
+		rc, err := layer.Uncompressed()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get layer content: %w", err)
+		}
+		pr.r = rc
+		return &readCloser{Reader: pr, source: rc}, nil
 	}
 
-	progress(0.8)
-	debug("InitializeLayer: Content copied successfully")
+	rc, err := layer.Compressed()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get layer content: %w", err)
+	}
+	l.transfer.start(size)
+	pr.r = rc
+	pr.stage = StageDownloading
+	pr.transfer = l.transfer
+	pr.report()
 
-	if _, err := file.Seek(0, 0); err != nil {
-		return fmt.Errorf("failed to seek cache file: %w", err)
+	ur, err := decompress(pr)
+	if err != nil {
+		rc.Close()
+		return nil, fmt.Errorf("failed to decompress layer content: %w", err)
 	}
+	return &readCloser{Reader: ur, decoder: ur, source: rc}, nil
+}
 
-	debug("InitializeLayer: Creating tarfs")
-	tfs, err := tarfs.New(file)
+// readCloser closes the decoder and the source of a layer stream
+type readCloser struct {
+	io.Reader
+	decoder io.Closer
+	source  io.Closer
+}
+
+func (r *readCloser) Close() error {
+	var errs []error
+	if r.decoder != nil {
+		errs = append(errs, r.decoder.Close())
+	}
+	errs = append(errs, r.source.Close())
+	return errors.Join(errs...)
+}
+
+// createInMemoryLayer builds the layer filesystem over an in-memory copy of the content
+func (l *Layer) createInMemoryLayer(content []byte, progress ProgressFunc) error {
+	debug("InitializeLayer: Creating tarfs from memory (%d bytes)", len(content))
+	tfs, err := l.indexLayer(bytes.NewReader(content), int64(len(content)), progress)
 	if err != nil {
 		return fmt.Errorf("failed to create tarfs: %w", err)
 	}
 
-	cacheLayer(l.DiffID, tmpFile)
+	l.cache().putLayerContent(l.DiffID, content)
 	l.fs = tfs
-	progress(1.0)
+	l.reportDone(progress)
 	debug("InitializeLayer: Layer initialization completed successfully")
 
 	return nil
 }
 
-// InitializeLayer prepares the layer filesystem with progress reporting
-func (l *Layer) InitializeLayer(progress func(float64)) error {
+// InitializeLayer prepares the layer filesystem with progress reporting.
+// Downloading the layer is aborted when the context is canceled.
+// progress may be nil.
+func (l *Layer) InitializeLayer(ctx context.Context, progress ProgressFunc) error {
 	debug("InitializeLayer: Starting initialization for layer %s", l.DiffID)
+	if progress == nil {
+		progress = func(Progress) {}
+	}
+
+	if l.parts != nil {
+		return l.initializeParts(ctx, progress)
+	}
+	if !l.IsArchive() {
+		return fmt.Errorf("%w: layer of type %s is not a tar archive", ErrUnsupportedMediaType, l.MediaType)
+	}
 
 	if l.fs != nil {
 		debug("InitializeLayer: Layer already initialized")
-		progress(1.0)
+		l.reportDone(progress)
 		return nil
 	}
 
-	// Report start of loading
-	progress(0.0)
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
 	debug("InitializeLayer: Checking cache")
 
 	// Try to initialize from cache first
-	if ok, _ := l.initializeFromCache(progress); ok {
-		return nil
+	if ok, _ := l.initializeFromCache(progress); !ok {
+		// If cache initialization failed, create new layer.
+		// Downloads failing halfway are retried from the beginning.
+		err := l.retryPolicy.do(ctx, func() error {
+			return l.createNewLayer(ctx, progress)
+		})
+		if err != nil {
+			return classifyError(err)
+		}
+	}
+	l.cache().acquireLayer(l.DiffID)
+	l.cached = true
+
+	if l.accounts == nil {
+		accounts, err := LoadAccounts(l.fs)
+		if err != nil {
+			// Owners are shown with IDs
+			debug("InitializeLayer: Failed to load accounts: %v", err)
+		}
+		l.accounts = accounts
+	}
+	return nil
+}
+
+// SetAccounts sets the names used for file owners, such as the accounts of
+// the merged image. By default, the layer's own /etc/passwd and /etc/group
+// are used.
+func (l *Layer) SetAccounts(accounts *Accounts) {
+	l.accounts = accounts
+}
+
+// initialized returns the layer if it is initialized, or an initialized copy
+// of it otherwise. Closing the layer returned only closes the copy, so that
+// the layer is left as it was.
+func (l *Layer) initialized(ctx context.Context) (*Layer, error) {
+	if l.files() != nil {
+		return &Layer{DiffID: l.DiffID, fs: l.fs, merged: l.merged, accounts: l.accounts}, nil
+	}
+	initialized := *l
+	if err := initialized.InitializeLayer(ctx, nil); err != nil {
+		return nil, err
 	}
+	return &initialized, nil
+}
+
+// Accounts returns the names used for file owners, or nil if the layer has
+// not been initialized
+func (l *Layer) Accounts() *Accounts {
+	return l.accounts
+}
 
-	// If cache initialization failed, create new layer
-	return l.createNewLayer(progress)
+// Close releases the file handles of the layer and its use of the cached
+// content, which is removed once no other layer uses it. The layer can be
+// initialized again afterwards.
+func (l *Layer) Close() error {
+	var errs []error
+	for _, part := range l.parts {
+		errs = append(errs, part.Close())
+	}
+	l.merged = nil
+	if l.file != nil {
+		errs = append(errs, l.file.Close())
+		l.file = nil
+	}
+	l.fs = nil
+	if l.cached {
+		errs = append(errs, l.cache().releaseLayer(l.DiffID))
+		l.cached = false
+	}
+	return errors.Join(errs...)
 }
 
-// GetFiles returns files in the specified path
-func (l *Layer) GetFiles(path string) ([]File, error) {
-	if l.fs == nil {
+// GetFiles returns files in the directory dir, a slash-separated path
+// relative to the layer root
+func (l *Layer) GetFiles(ctx context.Context, dir string) ([]File, error) {
+	fsys := l.files()
+	if fsys == nil {
 		return nil, fmt.Errorf("layer not initialized")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Open the directory
-	dir, err := l.fs.Open(path)
+	f, err := fsys.Open(cleanPath(dir))
 	if err != nil {
 		return nil, err
 	}
-	defer dir.Close()
+	defer f.Close()
 
 	// Read directory entries
-	dirFile, ok := dir.(fs.ReadDirFile)
+	dirFile, ok := f.(fs.ReadDirFile)
 	if !ok {
 		return nil, fmt.Errorf("not a directory")
 	}
@@ -522,45 +1034,147 @@ func (l *Layer) GetFiles(path string) ([]File, error) {
 	}
 
 	var files []File
-	for _, entry := range entries {
+	for i, entry := range entries {
+		// Huge directories take a while, so check for cancellation periodically
+		if i%1024 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+
 		info, err := entry.Info()
 		if err != nil {
 			continue
 		}
 
-		files = append(files, File{
-			Name:    entry.Name(),
-			IsDir:   entry.IsDir(),
-			Path:    filepath.Join(path, entry.Name()),
-			Size:    info.Size(),
-			Mode:    info.Mode().String(),
-			ModTime: info.ModTime().Format("2006-01-02 15:04:05"),
-		})
+		filePath := path.Join(dir, entry.Name())
+		isDir := entry.IsDir()
+		if entry.Type() == fs.ModeSymlink {
+			// Links to directories, such as /bin -> usr/bin, can be browsed
+			if target, err := fs.Stat(fsys, cleanPath(filePath)); err == nil {
+				isDir = target.IsDir()
+			}
+		}
+
+		files = append(files, l.newFile(filePath, info, isDir))
 	}
 
 	return files, nil
 }
 
-// ReadFile reads the content of a file in the layer
-func (l *Layer) ReadFile(path string) ([]byte, error) {
-	if l.fs == nil {
+// newFile describes the file of the layer at the path
+func (l *Layer) newFile(filePath string, info fs.FileInfo, isDir bool) File {
+	file := File{
+		Name:       info.Name(),
+		IsDir:      isDir,
+		Path:       filePath,
+		Size:       info.Size(),
+		Mode:       info.Mode().String(),
+		ModTime:    info.ModTime().Format("2006-01-02 15:04:05"),
+		ModifiedAt: info.ModTime(),
+		FileMode:   info.Mode(),
+	}
+	if hdr, ok := info.Sys().(*tarfs.Header); ok {
+		file.Uid = hdr.Uid()
+		file.Gid = hdr.Gid()
+		file.Owner = formatOwner(hdr, l.accounts)
+		file.Devmajor = hdr.Devmajor()
+		file.Devminor = hdr.Devminor()
+	}
+	return file
+}
+
+// formatOwner formats the owner of a file as "user:group". Names are looked
+// up in the accounts of the image first, then taken from the archive, and IDs
+// are used when both are unknown.
+func formatOwner(hdr *tarfs.Header, accounts *Accounts) string {
+	user, ok := accounts.User(hdr.Uid())
+	if !ok {
+		user = hdr.Uname()
+	}
+	if user == "" {
+		user = strconv.Itoa(hdr.Uid())
+	}
+	group, ok := accounts.Group(hdr.Gid())
+	if !ok {
+		group = hdr.Gname()
+	}
+	if group == "" {
+		group = strconv.Itoa(hdr.Gid())
+	}
+	return user + ":" + group
+}
+
+// ReadFile reads the content of a file in the layer.
+// Reading is aborted when the context is canceled.
+func (l *Layer) ReadFile(ctx context.Context, path string) ([]byte, error) {
+	return l.readFile(ctx, path, -1)
+}
+
+// ReadFileHead reads the first n bytes of a file in the layer, or the whole
+// file if it is shorter
+func (l *Layer) ReadFileHead(ctx context.Context, path string, n int64) ([]byte, error) {
+	return l.readFile(ctx, path, n)
+}
+
+// readFile reads up to limit bytes of a file, or all of it if limit is
+// negative
+func (l *Layer) readFile(ctx context.Context, path string, limit int64) ([]byte, error) {
+	fsys := l.files()
+	if fsys == nil {
 		return nil, fmt.Errorf("layer not initialized")
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
-	file, err := l.fs.Open(path)
+	file, err := fsys.Open(cleanPath(path))
 	if err != nil {
 		return nil, fmt.Errorf("failed to open file: %w", err)
 	}
 	defer file.Close()
 
-	content, err := io.ReadAll(file)
+	var r io.Reader = &ctxReader{ctx: ctx, r: file}
+	if limit >= 0 {
+		r = io.LimitReader(r, limit)
+	}
+	content, err := io.ReadAll(r)
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
 
 	return content, nil
 }
 
+// ctxReader stops reading once the context is canceled
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// Close closes all layers of the image and releases the files backing it,
+// such as the archive of an image saved from the Docker daemon
+func (i *Image) Close() error {
+	var errs []error
+	for j := range i.Layers {
+		errs = append(errs, i.Layers[j].Close())
+	}
+	if c, ok := i.img.(io.Closer); ok {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
 // GetManifest returns the image manifest
 func (i *Image) GetManifest() ([]byte, error) {
 	return i.GetManifestWithColor(true)
@@ -579,6 +1193,142 @@ func (i *Image) GetManifestWithColor(colored bool) ([]byte, error) {
 	return jsonBytes, nil
 }
 
+// ID returns the image ID, the digest of the image config
+func (i *Image) ID() (string, error) {
+	h, err := i.img.ConfigName()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config digest: %w", err)
+	}
+	return h.String(), nil
+}
+
+// ManifestDigest returns the digest of the image manifest in the registry.
+// Local images have the digest they were pulled by instead, which is the
+// digest of the index for multi-platform images, and none if they were
+// built locally.
+func (i *Image) ManifestDigest() (string, error) {
+	if i.Local {
+		return i.repoDigest, nil
+	}
+	h, err := i.img.Digest()
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest digest: %w", err)
+	}
+	return h.String(), nil
+}
+
+// Annotation is an annotation of the image manifest, or of the index the
+// image was resolved from
+type Annotation struct {
+	// Source is "index" or "manifest"
+	Source string
+	Key    string
+	Value  string
+}
+
+// Annotations returns the annotations of the index the image was resolved
+// from, followed by the ones of the manifest, each sorted by key
+func (i *Image) Annotations() ([]Annotation, error) {
+	manifest, err := i.img.Manifest()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	var annotations []Annotation
+	for _, source := range []struct {
+		name        string
+		annotations map[string]string
+	}{
+		{"index", i.indexAnnotations},
+		{"manifest", manifest.Annotations},
+	} {
+		for _, k := range slices.Sorted(maps.Keys(source.annotations)) {
+			annotations = append(annotations, Annotation{Source: source.name, Key: k, Value: source.annotations[k]})
+		}
+	}
+	return annotations, nil
+}
+
+// Labels returns the labels of the image config
+func (i *Image) Labels() (map[string]string, error) {
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %w", err)
+	}
+	return config.Config.Labels, nil
+}
+
+// Runtime is the configuration the containers of an image run with
+type Runtime struct {
+	Entrypoint []string
+	Cmd        []string
+	// Env are the environment variables as KEY=value
+	Env        []string
+	User       string
+	WorkingDir string
+	// ExposedPorts are the ports like "80/tcp" in numerical order
+	ExposedPorts []string
+	// Volumes are the mount points of the volumes in order
+	Volumes    []string
+	StopSignal string
+	// Healthcheck is nil if the image defines none
+	Healthcheck *Healthcheck
+}
+
+// Healthcheck is the health check of an image. Test is ["NONE"] if the check
+// is disabled, and ["CMD", args...] or ["CMD-SHELL", command] otherwise.
+// Zero durations and retries mean the defaults.
+type Healthcheck struct {
+	Test        []string
+	Interval    time.Duration
+	Timeout     time.Duration
+	StartPeriod time.Duration
+	Retries     int
+}
+
+// Runtime returns the runtime configuration of the image config
+func (i *Image) Runtime() (*Runtime, error) {
+	config, err := i.img.ConfigFile()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config: %w", err)
+	}
+	c := config.Config
+	r := &Runtime{
+		Entrypoint:   c.Entrypoint,
+		Cmd:          c.Cmd,
+		Env:          c.Env,
+		User:         c.User,
+		WorkingDir:   c.WorkingDir,
+		ExposedPorts: slices.SortedFunc(maps.Keys(c.ExposedPorts), comparePorts),
+		Volumes:      slices.Sorted(maps.Keys(c.Volumes)),
+		StopSignal:   c.StopSignal,
+	}
+	if h := c.Healthcheck; h != nil {
+		r.Healthcheck = &Healthcheck{
+			Test:        h.Test,
+			Interval:    h.Interval,
+			Timeout:     h.Timeout,
+			StartPeriod: h.StartPeriod,
+			Retries:     h.Retries,
+		}
+	}
+	return r, nil
+}
+
+// comparePorts orders ports like "80/tcp" by number, then by protocol
+func comparePorts(a, b string) int {
+	portA, protoA, _ := strings.Cut(a, "/")
+	portB, protoB, _ := strings.Cut(b, "/")
+	numA, errA := strconv.Atoi(portA)
+	numB, errB := strconv.Atoi(portB)
+	if errA == nil && errB == nil && numA != numB {
+		return cmp.Compare(numA, numB)
+	}
+	if portA != portB {
+		return strings.Compare(portA, portB)
+	}
+	return strings.Compare(protoA, protoB)
+}
+
 // GetConfig returns the image config
 func (i *Image) GetConfig() ([]byte, error) {
 	return i.GetConfigWithColor(true)
@@ -3,26 +3,37 @@ package container
 import (
 	"archive/tar"
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
 	"github.com/google/go-containerregistry/pkg/v1/random"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/static"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/klauspost/compress/zstd"
 )
 
 // mockProgressFunc is a mock progress function for testing
-func mockProgressFunc(progress float64) {}
+func mockProgressFunc(Progress) {}
 
 // setupTestImage creates a random image for testing
 func setupTestImage(t *testing.T) (v1.Image, error) {
@@ -114,7 +125,7 @@ func TestNewImage(t *testing.T) {
 		}
 
 		// Test with the pushed image
-		image, isLocal, err := NewImage(ref, mockProgressFunc)
+		image, isLocal, err := NewImage(context.Background(), ref, mockProgressFunc)
 		if err != nil {
 			t.Errorf("NewImage() error = %v", err)
 			return
@@ -127,6 +138,41 @@ func TestNewImage(t *testing.T) {
 		if image.Reference != ref {
 			t.Errorf("Expected reference %s, got %s", ref, image.Reference)
 		}
+
+		// Layers carry the digests of their blobs in the registry
+		layers, err := img.Layers()
+		if err != nil {
+			t.Fatalf("Failed to get layers: %v", err)
+		}
+		digests := make(map[string]bool)
+		for _, layer := range layers {
+			digest, err := layer.Digest()
+			if err != nil {
+				t.Fatalf("Failed to get digest: %v", err)
+			}
+			digests[digest.String()] = true
+		}
+		for _, layer := range image.Layers {
+			if !digests[layer.Digest] {
+				t.Errorf("Layer %s has unexpected digest %q", layer.DiffID, layer.Digest)
+			}
+		}
+
+		// The image ID and manifest digest are the ones of the pushed image
+		wantID, err := img.ConfigName()
+		if err != nil {
+			t.Fatalf("Failed to get config digest: %v", err)
+		}
+		if id, err := image.ID(); err != nil || id != wantID.String() {
+			t.Errorf("ID() = %q, %v, want %q", id, err, wantID)
+		}
+		wantDigest, err := img.Digest()
+		if err != nil {
+			t.Fatalf("Failed to get manifest digest: %v", err)
+		}
+		if digest, err := image.ManifestDigest(); err != nil || digest != wantDigest.String() {
+			t.Errorf("ManifestDigest() = %q, %v, want %q", digest, err, wantDigest)
+		}
 	})
 
 	t.Run("local image", func(t *testing.T) {
@@ -147,7 +193,7 @@ func TestNewImage(t *testing.T) {
 			t.Skipf("daemon not available: %v", err)
 		}
 
-		image, isLocal, err := NewImage(ref, mockProgressFunc)
+		image, isLocal, err := NewImage(context.Background(), ref, mockProgressFunc)
 		if err != nil {
 			t.Errorf("NewImage() error = %v", err)
 			return
@@ -163,14 +209,14 @@ func TestNewImage(t *testing.T) {
 	})
 
 	t.Run("invalid reference", func(t *testing.T) {
-		_, _, err := NewImage("invalid:@reference", mockProgressFunc)
+		_, _, err := NewImage(context.Background(), "invalid:@reference", mockProgressFunc)
 		if err == nil {
 			t.Error("Expected error for invalid reference")
 		}
 	})
 
 	t.Run("non-existent image", func(t *testing.T) {
-		_, _, err := NewImage("nonexistent/image:latest", mockProgressFunc)
+		_, _, err := NewImage(context.Background(), "nonexistent/image:latest", mockProgressFunc)
 		if err == nil {
 			t.Error("Expected error for non-existent image")
 		}
@@ -187,7 +233,7 @@ func TestInitializeLayer(t *testing.T) {
 		layer: layer,
 	}
 
-	err = l.InitializeLayer(mockProgressFunc)
+	err = l.InitializeLayer(context.Background(), mockProgressFunc)
 	if err != nil {
 		t.Errorf("InitializeLayer() error = %v", err)
 		return
@@ -198,6 +244,245 @@ func TestInitializeLayer(t *testing.T) {
 	}
 }
 
+func TestInitializeLayerCanceled(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+
+	l := Layer{
+		layer: layer,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err = l.InitializeLayer(ctx, mockProgressFunc)
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if l.fs != nil {
+		t.Error("Expected layer.fs not to be initialized")
+	}
+}
+
+func TestInitializeLayerThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int64
+		inMemory  bool
+	}{
+		{
+			name:      "small layer in memory",
+			threshold: 1 << 20,
+			inMemory:  true,
+		},
+		{
+			name:      "large layer on disk",
+			threshold: 0,
+			inMemory:  false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Cleanup(func() {
+				CleanupCache()
+			})
+
+			layer, err := createTestLayer(t)
+			if err != nil {
+				t.Fatalf("Failed to create test layer: %v", err)
+			}
+
+			l := Layer{
+				DiffID:            "sha256:" + tt.name,
+				layer:             layer,
+				inMemoryThreshold: tt.threshold,
+			}
+
+			if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+				t.Fatalf("InitializeLayer() error = %v", err)
+			}
+
+			if got := defaultCache.getLayerContent(l.DiffID) != nil; got != tt.inMemory {
+				t.Errorf("Expected in-memory = %v, got %v", tt.inMemory, got)
+			}
+			if got := defaultCache.getLayer(l.DiffID) == ""; got != tt.inMemory {
+				t.Errorf("Expected cache file = %v, got %v", !tt.inMemory, !got)
+			}
+
+			content, err := l.ReadFile(context.Background(), "test.txt")
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if string(content) != "test content" {
+				t.Errorf("Expected content 'test content', got '%s'", string(content))
+			}
+		})
+	}
+}
+
+func TestInitializeRemoteLayer(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+
+	img, err := setupTestImage(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test image: %v", err)
+	}
+
+	ref := fmt.Sprintf("%s/test/transfer:latest", registryHost)
+	imgRef, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.Write(imgRef, img); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
+	}
+
+	image, _, err := NewImage(context.Background(), ref, mockProgressFunc)
+	if err != nil {
+		t.Fatalf("NewImage() error = %v", err)
+	}
+	t.Cleanup(func() { CleanupCache() })
+
+	l := image.Layers[0]
+	if _, _, ok := l.Transferred(); !ok {
+		t.Fatal("Expected remote layer to report transfers")
+	}
+
+	if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+
+	complete, total, _ := l.Transferred()
+	if total != l.Size {
+		t.Errorf("Expected total %d, got %d", l.Size, total)
+	}
+	if complete != total {
+		t.Errorf("Expected %d bytes transferred, got %d", total, complete)
+	}
+}
+
+func TestNonArchiveLayer(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	module := []byte("\x00asm\x01\x00\x00\x00")
+	img, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: layer},
+		mutate.Addendum{
+			Layer:       static.NewLayer(module, "application/vnd.wasm.content.layer.v1+wasm"),
+			Annotations: map[string]string{"org.opencontainers.image.title": "app.wasm"},
+		},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref := fmt.Sprintf("%s/test/wasm:latest", registryHost)
+	imgRef, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.Write(imgRef, img); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
+	}
+
+	image, err := Open(context.Background(), ref, WithPullPolicy(PullAlways), WithCacheDir(t.TempDir()))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { image.Close() })
+	if len(image.Layers) != 2 {
+		t.Fatalf("Expected 2 layers, got %d", len(image.Layers))
+	}
+
+	wasm := &image.Layers[0]
+	if wasm.IsArchive() || !image.Layers[1].IsArchive() {
+		t.Errorf("IsArchive() = %v, %v, want false, true", wasm.IsArchive(), image.Layers[1].IsArchive())
+	}
+	if wasm.MediaType != "application/vnd.wasm.content.layer.v1+wasm" || wasm.Title != "app.wasm" {
+		t.Errorf("MediaType, Title = %q, %q", wasm.MediaType, wasm.Title)
+	}
+	if err := wasm.InitializeLayer(context.Background(), mockProgressFunc); !errors.Is(err, ErrUnsupportedMediaType) {
+		t.Errorf("InitializeLayer() error = %v, want %v", err, ErrUnsupportedMediaType)
+	}
+
+	rc, err := wasm.OpenBlob(context.Background())
+	if err != nil {
+		t.Fatalf("OpenBlob() error = %v", err)
+	}
+	defer rc.Close()
+	blob, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(blob, module) {
+		t.Errorf("OpenBlob() = %q, want %q", blob, module)
+	}
+
+	// The module has no files to stack
+	merged := MergeLayers(image.Layers...)
+	if err := merged.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("InitializeLayer() of the merged layers error = %v", err)
+	}
+	if diffIDs := merged.MergedDiffIDs(); !reflect.DeepEqual(diffIDs, []string{image.Layers[1].DiffID}) {
+		t.Errorf("MergedDiffIDs() = %v, want the tar layer only", diffIDs)
+	}
+}
+
+func TestDecompress(t *testing.T) {
+	content := []byte("layer content")
+
+	var gz bytes.Buffer
+	gw := gzip.NewWriter(&gz)
+	if _, err := gw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	gw.Close()
+
+	var zst bytes.Buffer
+	zw, err := zstd.NewWriter(&zst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := zw.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	zw.Close()
+
+	tests := []struct {
+		name  string
+		input []byte
+	}{
+		{name: "gzip", input: gz.Bytes()},
+		{name: "zstd", input: zst.Bytes()},
+		{name: "uncompressed", input: content},
+		{name: "empty", input: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rc, err := decompress(bytes.NewReader(tt.input))
+			if err != nil {
+				t.Fatalf("decompress() error = %v", err)
+			}
+			defer rc.Close()
+
+			got, err := io.ReadAll(rc)
+			if err != nil {
+				t.Fatalf("Failed to read: %v", err)
+			}
+			if tt.input != nil && !bytes.Equal(got, content) {
+				t.Errorf("Expected %q, got %q", content, got)
+			}
+		})
+	}
+}
+
 func TestGetFiles(t *testing.T) {
 	layer, err := createTestLayer(t)
 	if err != nil {
@@ -208,13 +493,13 @@ func TestGetFiles(t *testing.T) {
 		layer: layer,
 	}
 
-	err = l.InitializeLayer(mockProgressFunc)
+	err = l.InitializeLayer(context.Background(), mockProgressFunc)
 	if err != nil {
 		t.Fatalf("Failed to initialize layer: %v", err)
 	}
 
 	// Test root directory
-	files, err := l.GetFiles(".")
+	files, err := l.GetFiles(context.Background(), ".")
 	if err != nil {
 		t.Errorf("GetFiles('.') error = %v", err)
 		return
@@ -233,6 +518,9 @@ func TestGetFiles(t *testing.T) {
 			if f.IsDir {
 				t.Error("Expected test.txt to be a file")
 			}
+			if f.Owner != "0:0" {
+				t.Errorf("Expected owner 0:0, got %s", f.Owner)
+			}
 		case "testdir":
 			foundDir = true
 			if !f.IsDir {
@@ -249,7 +537,7 @@ func TestGetFiles(t *testing.T) {
 	}
 
 	// Test subdirectory
-	files, err = l.GetFiles("testdir")
+	files, err = l.GetFiles(context.Background(), "testdir")
 	if err != nil {
 		t.Errorf("GetFiles('testdir') error = %v", err)
 		return
@@ -264,6 +552,78 @@ func TestGetFiles(t *testing.T) {
 	}
 }
 
+func TestGetFilesSymlink(t *testing.T) {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	content := []byte("#!/bin/sh")
+	for _, hdr := range []*tar.Header{
+		{Name: "usr/bin/", Mode: 0o755, Typeflag: tar.TypeDir},
+		{Name: "usr/bin/sh", Mode: 0o755, Size: int64(len(content)), Typeflag: tar.TypeReg},
+		{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"},
+		{Name: "sh", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/sh"},
+	} {
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := tw.Write(content); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := tarball.LayerFromOpener(func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffID, err := layer.DiffID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	l := Layer{DiffID: diffID.String(), layer: layer}
+	if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("Failed to initialize layer: %v", err)
+	}
+	defer l.Close()
+
+	files, err := l.GetFiles(context.Background(), ".")
+	if err != nil {
+		t.Fatalf("GetFiles('.') error = %v", err)
+	}
+	isDir := make(map[string]bool)
+	for _, f := range files {
+		isDir[f.Name] = f.IsDir
+	}
+	if !isDir["bin"] {
+		t.Error("Expected the link to a directory to be browsable")
+	}
+	if isDir["sh"] {
+		t.Error("Expected the link to a file not to be a directory")
+	}
+
+	files, err = l.GetFiles(context.Background(), "bin")
+	if err != nil {
+		t.Fatalf("GetFiles('bin') error = %v", err)
+	}
+	if len(files) != 1 || files[0].Name != "sh" {
+		t.Errorf("Expected sh in bin, got %v", files)
+	}
+
+	got, err := l.ReadFile(context.Background(), "bin/sh")
+	if err != nil {
+		t.Fatalf("ReadFile('bin/sh') error = %v", err)
+	}
+	if string(got) != string(content) {
+		t.Errorf("Expected %q, got %q", content, got)
+	}
+}
+
 func TestReadFile(t *testing.T) {
 	layer, err := createTestLayer(t)
 	if err != nil {
@@ -274,13 +634,13 @@ func TestReadFile(t *testing.T) {
 		layer: layer,
 	}
 
-	err = l.InitializeLayer(mockProgressFunc)
+	err = l.InitializeLayer(context.Background(), mockProgressFunc)
 	if err != nil {
 		t.Fatalf("Failed to initialize layer: %v", err)
 	}
 
 	// Test reading existing file in root
-	content, err := l.ReadFile("test.txt")
+	content, err := l.ReadFile(context.Background(), "test.txt")
 	if err != nil {
 		t.Errorf("ReadFile('test.txt') error = %v", err)
 		return
@@ -291,7 +651,7 @@ func TestReadFile(t *testing.T) {
 	}
 
 	// Test reading existing file in subdirectory
-	content, err = l.ReadFile(filepath.Join("testdir", "file.txt"))
+	content, err = l.ReadFile(context.Background(), filepath.Join("testdir", "file.txt"))
 	if err != nil {
 		t.Errorf("ReadFile('testdir/file.txt') error = %v", err)
 		return
@@ -301,13 +661,152 @@ func TestReadFile(t *testing.T) {
 		t.Errorf("Expected content 'directory test content', got '%s'", string(content))
 	}
 
+	// Test reading the head of a file, and of a file shorter than the head
+	content, err = l.ReadFileHead(context.Background(), "test.txt", 4)
+	if err != nil {
+		t.Errorf("ReadFileHead('test.txt') error = %v", err)
+	} else if string(content) != "test" {
+		t.Errorf("Expected content 'test', got '%s'", string(content))
+	}
+	content, err = l.ReadFileHead(context.Background(), "test.txt", 1024)
+	if err != nil {
+		t.Errorf("ReadFileHead('test.txt') error = %v", err)
+	} else if string(content) != "test content" {
+		t.Errorf("Expected content 'test content', got '%s'", string(content))
+	}
+
 	// Test reading non-existent file
-	_, err = l.ReadFile("nonexistent")
+	_, err = l.ReadFile(context.Background(), "nonexistent")
 	if err == nil {
 		t.Error("Expected error when reading non-existent file")
 	}
 }
 
+func TestLayerClose(t *testing.T) {
+	// Layers not opened by Open are written to the cache directory
+	cache := NewCache("")
+	t.Cleanup(func() {
+		cache.Cleanup()
+	})
+
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+
+	l := Layer{
+		DiffID:     "sha256:close",
+		layer:      layer,
+		layerCache: cache,
+	}
+	if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("Failed to initialize layer: %v", err)
+	}
+	cachedPath := cache.getLayer(l.DiffID)
+	if cachedPath == "" {
+		t.Fatal("Expected layer to be cached on disk")
+	}
+
+	if err := l.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(cachedPath); !os.IsNotExist(err) {
+		t.Error("Expected cache file to be removed")
+	}
+	if _, err := l.GetFiles(context.Background(), "."); err == nil {
+		t.Error("Expected GetFiles to fail after Close")
+	}
+
+	// Closing twice is fine
+	if err := l.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	// The layer can be initialized again
+	if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("Failed to initialize layer again: %v", err)
+	}
+	if _, err := l.ReadFile(context.Background(), "test.txt"); err != nil {
+		t.Errorf("ReadFile() error = %v", err)
+	}
+}
+
+func TestLayerCloseShared(t *testing.T) {
+	// Layers not opened by Open are written to the cache directory
+	cache := NewCache("")
+	t.Cleanup(func() {
+		cache.Cleanup()
+	})
+
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+
+	// The layer is shared by two images opened on the same cache, such as
+	// the image browsed and the one it is compared with
+	browsed := Layer{DiffID: "sha256:shared", layer: layer, layerCache: cache}
+	compared := Layer{DiffID: "sha256:shared", layer: layer, layerCache: cache}
+	for _, l := range []*Layer{&browsed, &compared} {
+		if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+			t.Fatalf("InitializeLayer() error = %v", err)
+		}
+	}
+	cachedPath := cache.getLayer("sha256:shared")
+	if cachedPath == "" {
+		t.Fatal("Expected layer to be cached on disk")
+	}
+
+	// Closing a layer that was never initialized leaves the cache alone
+	unused := Layer{DiffID: "sha256:shared", layer: layer, layerCache: cache}
+	if err := unused.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if err := compared.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if cache.getLayer("sha256:shared") != cachedPath {
+		t.Fatal("Expected the layer to stay cached while another layer uses it")
+	}
+	if _, err := os.Stat(cachedPath); err != nil {
+		t.Errorf("Expected the cache file to be kept: %v", err)
+	}
+	if _, err := browsed.ReadFile(context.Background(), "test.txt"); err != nil {
+		t.Errorf("ReadFile() error = %v", err)
+	}
+
+	if err := browsed.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if _, err := os.Stat(cachedPath); !os.IsNotExist(err) {
+		t.Error("Expected cache file to be removed with the last layer using it")
+	}
+}
+
+func TestLayerCanceled(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+
+	l := Layer{
+		layer: layer,
+	}
+	if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+		t.Fatalf("Failed to initialize layer: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := l.GetFiles(ctx, "."); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetFiles() expected context.Canceled, got %v", err)
+	}
+	if _, err := l.ReadFile(ctx, "test.txt"); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadFile() expected context.Canceled, got %v", err)
+	}
+}
+
 func TestGetManifest(t *testing.T) {
 	img, err := setupTestImage(t)
 	if err != nil {
@@ -352,62 +851,280 @@ func TestGetConfig(t *testing.T) {
 	}
 }
 
-func TestCleanupCache(t *testing.T) {
-	// Create a temporary cache directory
-	tmpDir, err := os.MkdirTemp("", "image-test-*")
+func TestLabels(t *testing.T) {
+	img, err := mutate.Config(empty.Image, v1.Config{Labels: map[string]string{
+		"org.opencontainers.image.source": "https://github.com/knqyf263/sou",
+		"maintainer":                      "sou",
+	}})
 	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
+		t.Fatalf("Failed to create image: %v", err)
 	}
 
-	// Save original cache directory and restore it after test
-	originalCacheDir := cacheDir
-	t.Cleanup(func() {
-		cacheDir = originalCacheDir
-		os.RemoveAll(tmpDir)
+	labels, err := (&Image{img: img}).Labels()
+	if err != nil {
+		t.Fatalf("Labels() error = %v", err)
+	}
+	want := map[string]string{
+		"org.opencontainers.image.source": "https://github.com/knqyf263/sou",
+		"maintainer":                      "sou",
+	}
+	if !reflect.DeepEqual(labels, want) {
+		t.Errorf("Labels() = %v, want %v", labels, want)
+	}
+}
+
+func TestAnnotations(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+	img, err := setupTestImage(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test image: %v", err)
+	}
+	img = mutate.Annotations(img, map[string]string{
+		"org.opencontainers.image.source":    "https://github.com/knqyf263/sou",
+		"org.opencontainers.image.base.name": "alpine:3.20",
+	}).(v1.Image)
+	index := mutate.AppendManifests(empty.Index, mutate.IndexAddendum{
+		Add:        img,
+		Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}},
+	})
+	index = mutate.Annotations(index, map[string]string{
+		"org.opencontainers.image.created": "2024-01-02T03:04:05Z",
+	}).(v1.ImageIndex)
+
+	ref := fmt.Sprintf("%s/test/index:latest", registryHost)
+	indexRef, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(indexRef, index); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+
+	image, _, err := NewImage(context.Background(), ref, mockProgressFunc)
+	if err != nil {
+		t.Fatalf("NewImage() error = %v", err)
+	}
+	got, err := image.Annotations()
+	if err != nil {
+		t.Fatalf("Annotations() error = %v", err)
+	}
+	want := []Annotation{
+		{Source: "index", Key: "org.opencontainers.image.created", Value: "2024-01-02T03:04:05Z"},
+		{Source: "manifest", Key: "org.opencontainers.image.base.name", Value: "alpine:3.20"},
+		{Source: "manifest", Key: "org.opencontainers.image.source", Value: "https://github.com/knqyf263/sou"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Annotations() = %v, want %v", got, want)
+	}
+}
+
+func TestRuntime(t *testing.T) {
+	img, err := mutate.Config(empty.Image, v1.Config{
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Env:          []string{"PATH=/usr/local/bin:/usr/bin:/bin"},
+		User:         "nginx",
+		WorkingDir:   "/app",
+		ExposedPorts: map[string]struct{}{"443/tcp": {}, "80/udp": {}, "80/tcp": {}},
+		Volumes:      map[string]struct{}{"/var/log": {}, "/data": {}},
+		StopSignal:   "SIGQUIT",
+		Healthcheck: &v1.HealthConfig{
+			Test:     []string{"CMD-SHELL", "curl -f http://localhost/"},
+			Interval: 30 * time.Second,
+			Retries:  3,
+		},
 	})
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+
+	got, err := (&Image{img: img}).Runtime()
+	if err != nil {
+		t.Fatalf("Runtime() error = %v", err)
+	}
+	want := &Runtime{
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Env:          []string{"PATH=/usr/local/bin:/usr/bin:/bin"},
+		User:         "nginx",
+		WorkingDir:   "/app",
+		ExposedPorts: []string{"80/tcp", "80/udp", "443/tcp"},
+		Volumes:      []string{"/data", "/var/log"},
+		StopSignal:   "SIGQUIT",
+		Healthcheck: &Healthcheck{
+			Test:     []string{"CMD-SHELL", "curl -f http://localhost/"},
+			Interval: 30 * time.Second,
+			Retries:  3,
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Runtime() = %+v, want %+v", got, want)
+	}
 
-	cacheDir = tmpDir
+	// Images without a configuration have no ports, volumes or health check
+	got, err = (&Image{img: empty.Image}).Runtime()
+	if err != nil {
+		t.Fatalf("Runtime() error = %v", err)
+	}
+	if len(got.ExposedPorts) != 0 || len(got.Volumes) != 0 || got.Healthcheck != nil {
+		t.Errorf("Runtime() = %+v, want an empty runtime", got)
+	}
+}
 
-	// Create some test files in the cache directory
-	testFiles := []string{
-		"test1.tar",
-		"test2.tar",
-		filepath.Join("subdir", "test3.tar"),
+func TestCleanupCache(t *testing.T) {
+	tests := []struct {
+		name       string
+		dir        func(t *testing.T) string
+		removesDir bool
+	}{
+		{
+			name:       "temporary directory",
+			dir:        func(t *testing.T) string { return "" },
+			removesDir: true,
+		},
+		{
+			name:       "given directory",
+			dir:        func(t *testing.T) string { return t.TempDir() },
+			removesDir: false,
+		},
 	}
 
-	for _, f := range testFiles {
-		path := filepath.Join(tmpDir, f)
-		if filepath.Dir(path) != tmpDir {
-			if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
-				t.Fatalf("Failed to create directory: %v", err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCache(tt.dir(t))
+
+			// Create some test files in the cache directory
+			var files []string
+			for i := 0; i < 3; i++ {
+				file, err := cache.createLayerFile()
+				if err != nil {
+					t.Fatalf("Failed to create cache file: %v", err)
+				}
+				if _, err := file.WriteString("test content"); err != nil {
+					t.Fatalf("Failed to write cache file: %v", err)
+				}
+				file.Close()
+				cache.putLayer(fmt.Sprintf("sha256:test%d", i), file.Name())
+				files = append(files, file.Name())
 			}
-		}
-		if err := os.WriteFile(path, []byte("test content"), 0644); err != nil {
-			t.Fatalf("Failed to create test file: %v", err)
-		}
+			dir := filepath.Dir(files[0])
+
+			// Run cleanup
+			if err := cache.Cleanup(); err != nil {
+				t.Errorf("Cleanup() error = %v", err)
+			}
+
+			for _, f := range files {
+				if _, err := os.Stat(f); !os.IsNotExist(err) {
+					t.Errorf("Expected %s to be removed", f)
+				}
+			}
+			if _, err := os.Stat(dir); os.IsNotExist(err) != tt.removesDir {
+				t.Errorf("Expected directory removed = %v", tt.removesDir)
+			}
+			if cache.getLayer("sha256:test0") != "" {
+				t.Error("Expected cache map to be cleared")
+			}
+
+			// Test cleanup when the cache is empty
+			if err := cache.Cleanup(); err != nil {
+				t.Errorf("Cleanup() error = %v", err)
+			}
+
+			// The cache can be used again after cleanup
+			file, err := cache.createLayerFile()
+			if err != nil {
+				t.Fatalf("Failed to create cache file after cleanup: %v", err)
+			}
+			file.Close()
+			t.Cleanup(func() { cache.Cleanup() })
+		})
 	}
+}
+
+func TestOpenBlobProgress(t *testing.T) {
+	registryHost := setupTestRegistry(t)
 
-	// Add files to the cache map
-	for i, f := range testFiles {
-		cacheLayer(fmt.Sprintf("sha256:test%d", i), filepath.Join(tmpDir, f))
+	img, err := setupTestImage(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test image: %v", err)
+	}
+	ref := fmt.Sprintf("%s/test/blobs:latest", registryHost)
+	imgRef, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.Write(imgRef, img); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
 	}
 
-	// Verify that test files were created
-	entries, err := os.ReadDir(tmpDir)
+	var mu sync.Mutex
+	blobs := map[string]Progress{}
+	image, err := Open(context.Background(), ref, WithPullPolicy(PullAlways), WithProgress(func(p Progress) {
+		if p.Blob == "" {
+			return
+		}
+		mu.Lock()
+		blobs[p.Blob] = p
+		mu.Unlock()
+	}))
 	if err != nil {
-		t.Fatalf("Failed to read cache directory: %v", err)
+		t.Fatalf("Open() error = %v", err)
 	}
-	if len(entries) == 0 {
-		t.Fatal("Expected test files to be created")
+	t.Cleanup(func() { image.Close() })
+
+	digest, _ := img.Digest()
+	configName, _ := img.ConfigName()
+	mu.Lock()
+	defer mu.Unlock()
+	for _, blob := range []string{digest.String(), configName.String()} {
+		p, ok := blobs[blob]
+		if !ok {
+			t.Errorf("No progress reported for %s", blob)
+			continue
+		}
+		if p.Total == 0 || p.Complete != p.Total {
+			t.Errorf("Progress of %s = %d/%d, want complete", blob, p.Complete, p.Total)
+		}
 	}
+}
 
-	// Run cleanup
-	if err := CleanupCache(); err != nil {
-		t.Errorf("CleanupCache() error = %v", err)
+func TestImageHistory(t *testing.T) {
+	base, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	app, err := random.Layer(1024, types.DockerLayer)
+	if err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	at := func(minute int) v1.Time {
+		return v1.Time{Time: time.Date(2024, 1, 2, 3, minute, 0, 0, time.UTC)}
+	}
+	img, err := mutate.Append(empty.Image,
+		mutate.Addendum{Layer: base, History: v1.History{CreatedBy: "ADD rootfs.tar.gz /", Created: at(0)}},
+		mutate.Addendum{History: v1.History{CreatedBy: "ENV PATH=/app/bin", Created: at(1), EmptyLayer: true}},
+		mutate.Addendum{Layer: app, History: v1.History{CreatedBy: "COPY app /app", Created: at(2)}},
+		mutate.Addendum{History: v1.History{CreatedBy: `CMD ["app"]`, Created: at(3), EmptyLayer: true}},
+	)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
 	}
 
-	// Test cleanup when cache directory is empty
-	if err := CleanupCache(); err != nil {
-		t.Errorf("CleanupCache() error = %v", err)
+	image, err := createImageFromV1(img, "sou.test/history:latest")
+	if err != nil {
+		t.Fatalf("createImageFromV1() error = %v", err)
+	}
+	want := []History{
+		{Command: `CMD ["app"]`, Created: at(3).Time, Layer: -1},
+		{Command: "COPY app /app", Created: at(2).Time, Layer: 0},
+		{Command: "ENV PATH=/app/bin", Created: at(1).Time, Layer: -1},
+		{Command: "ADD rootfs.tar.gz /", Created: at(0).Time, Layer: 1},
+	}
+	if !reflect.DeepEqual(image.History, want) {
+		t.Errorf("History = %+v, want %+v", image.History, want)
+	}
+	if len(image.Layers) != 2 || image.Layers[0].Command != "COPY app /app" {
+		t.Errorf("Layers = %+v", image.Layers)
 	}
 }
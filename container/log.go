@@ -0,0 +1,38 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync/atomic"
+)
+
+// discardLogger drops all records. It is the default so that the package
+// doesn't write anywhere unless the caller asks for it.
+var discardLogger = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{
+	Level: slog.LevelError + 1,
+}))
+
+var logger atomic.Pointer[slog.Logger]
+
+func init() {
+	logger.Store(discardLogger)
+}
+
+// SetLogger sets the logger for debug messages of the package.
+// Logging is disabled by default, and a nil logger disables it again.
+func SetLogger(l *slog.Logger) {
+	if l == nil {
+		l = discardLogger
+	}
+	logger.Store(l)
+}
+
+func debug(format string, v ...interface{}) {
+	l := logger.Load()
+	if !l.Enabled(context.Background(), slog.LevelDebug) {
+		return
+	}
+	l.Debug(fmt.Sprintf(format, v...))
+}
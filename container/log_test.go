@@ -0,0 +1,32 @@
+package container
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestSetLogger(t *testing.T) {
+	t.Cleanup(func() { SetLogger(nil) })
+
+	// Nothing is logged by default
+	debug("before %s", "logger")
+
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	debug("hello %s", "world")
+	if !strings.Contains(buf.String(), "hello world") {
+		t.Errorf("Expected debug message to be logged, got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), "before logger") {
+		t.Errorf("Expected message before SetLogger to be dropped, got %q", buf.String())
+	}
+
+	buf.Reset()
+	SetLogger(nil)
+	debug("after %s", "reset")
+	if buf.Len() != 0 {
+		t.Errorf("Expected nothing to be logged after reset, got %q", buf.String())
+	}
+}
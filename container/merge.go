@@ -0,0 +1,388 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+
+	"github.com/knqyf263/sou/tarfs"
+)
+
+const (
+	// whiteoutPrefix marks a file deleting the file of the same name in lower layers
+	whiteoutPrefix = ".wh."
+	// whiteoutOpaque marks a directory whose lower layer contents are hidden
+	whiteoutOpaque = whiteoutPrefix + whiteoutPrefix + ".opq"
+)
+
+// maxSymlinks is the number of symbolic links followed while resolving a path
+// of a MergedFS before it is considered a loop
+const maxSymlinks = 40
+
+// MergedFS is the read-only filesystem of layers stacked as a container
+// runtime does. Whiteout files hide the files of lower layers and are not
+// visible themselves, and opaque directories hide the contents of the same
+// directory in lower layers.
+type MergedFS struct {
+	layers []*tarfs.FS // from the newest to the oldest
+	// upper is set for the upper layers of a stack read without the layers
+	// below them, whose lookups fail with errBelow when they depend on them
+	upper bool
+}
+
+// errBelow is the error of the lookups of the upper layers of a stack that
+// depend on the layers below them
+var errBelow = errors.New("the file is in the lower layers")
+
+// Merge stacks the layers, ordered from the newest to the oldest as in
+// Image.Layers. The layers must be initialized.
+func Merge(layers ...*Layer) (*MergedFS, error) {
+	m := &MergedFS{}
+	for _, l := range layers {
+		if l.fs == nil {
+			return nil, fmt.Errorf("layer %s not initialized", l.DiffID)
+		}
+		m.layers = append(m.layers, l.fs)
+	}
+	return m, nil
+}
+
+// MergeLayers returns a layer whose files are those of the given layers
+// stacked, ordered from the newest to the oldest as in Image.Layers.
+// Initializing it initializes the layers, reporting their progress in turn,
+// and its owners are named after the accounts of the stacked files. It has
+// no DiffID. Layers that aren't tar archives have no files and are left out.
+func MergeLayers(layers ...Layer) *Layer {
+	merged := &Layer{Command: "N/A"}
+	for i := range layers {
+		part := layers[i]
+		if !part.IsArchive() {
+			continue
+		}
+		merged.parts = append(merged.parts, &part)
+		merged.Size += part.Size
+	}
+	return merged
+}
+
+// MergedDiffIDs returns the diff IDs of the layers stacked by MergeLayers,
+// or nil for a layer of the image
+func (l *Layer) MergedDiffIDs() []string {
+	var diffIDs []string
+	for _, part := range l.parts {
+		diffIDs = append(diffIDs, part.DiffID)
+	}
+	return diffIDs
+}
+
+// initializeParts initializes the stacked layers and merges them
+func (l *Layer) initializeParts(ctx context.Context, progress ProgressFunc) error {
+	if l.merged != nil {
+		return nil
+	}
+	for _, part := range l.parts {
+		if err := part.InitializeLayer(ctx, progress); err != nil {
+			return err
+		}
+	}
+	merged, err := Merge(l.parts...)
+	if err != nil {
+		return err
+	}
+	l.merged = merged
+
+	if l.accounts == nil {
+		accounts, err := LoadAccounts(merged)
+		if err != nil {
+			debug("InitializeLayer: Failed to load accounts: %v", err)
+		}
+		l.accounts = accounts
+	}
+	return nil
+}
+
+// files returns the files of the layer, or nil if it is not initialized
+func (l *Layer) files() fs.FS {
+	switch {
+	case l.merged != nil:
+		return l.merged
+	case l.fs != nil:
+		return l.fs
+	}
+	return nil
+}
+
+// mergedEntry is a file of a MergedFS and the layer providing it
+type mergedEntry struct {
+	info  fs.FileInfo
+	layer int
+	path  string // path without symbolic links
+}
+
+// Open implements fs.FS. Symbolic links are followed across layers.
+func (m *MergedFS) Open(name string) (fs.File, error) {
+	e, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !e.info.IsDir() {
+		return m.layers[e.layer].Open(e.path)
+	}
+	entries, err := m.readDir(e.path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &mergedDir{info: e.info, entries: entries}, nil
+}
+
+// Stat implements fs.StatFS. Symbolic links are followed.
+func (m *MergedFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+// Lstat returns the file info of name without following a symbolic link in
+// its last element
+func (m *MergedFS) Lstat(name string) (fs.FileInfo, error) {
+	e, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return e.info, nil
+}
+
+// ReadLink returns the target of the symbolic link name
+func (m *MergedFS) ReadLink(name string) (string, error) {
+	e, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	return m.layers[e.layer].ReadLink(e.path)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (m *MergedFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := m.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !e.info.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}
+	}
+	entries, err := m.readDir(e.path)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	return entries, nil
+}
+
+// lookup finds the file of name, resolving symbolic links in its parent
+// directories across layers. A symbolic link in the last element is followed
+// if follow is set.
+func (m *MergedFS) lookup(op, name string, follow bool) (mergedEntry, error) {
+	if !fs.ValidPath(name) {
+		return mergedEntry{}, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	links := 0
+	dir, rest := ".", name
+	for {
+		var elem string
+		elem, rest, _ = strings.Cut(rest, "/")
+		current := path.Join(dir, elem)
+		last := rest == ""
+
+		e, err := m.entry(current)
+		if err != nil {
+			return mergedEntry{}, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if e.info.Mode()&fs.ModeSymlink == 0 || last && !follow {
+			if last {
+				return e, nil
+			}
+			dir = current
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return mergedEntry{}, &fs.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+		target, err := m.layers[e.layer].ReadLink(current)
+		if err != nil {
+			return mergedEntry{}, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+		if !path.IsAbs(target) {
+			target = path.Join(dir, target)
+		}
+		if !last {
+			target += "/" + rest
+		}
+		dir, rest = ".", cleanPath(target)
+	}
+}
+
+// entry returns the file at p, whose parent directories must not contain
+// symbolic links, from the newest layer having it
+func (m *MergedFS) entry(p string) (mergedEntry, error) {
+	if isWhiteout(path.Base(p)) {
+		return mergedEntry{}, fs.ErrNotExist
+	}
+	for i, layer := range m.layers {
+		if hasFileParent(layer, p) {
+			// A file replaces the directories of lower layers
+			return mergedEntry{}, fs.ErrNotExist
+		}
+		if info, err := layer.Lstat(p); err == nil {
+			return mergedEntry{info: info, layer: i, path: p}, nil
+		}
+		if hides(layer, p) {
+			return mergedEntry{}, fs.ErrNotExist
+		}
+	}
+	if m.upper {
+		return mergedEntry{}, errBelow
+	}
+	return mergedEntry{}, fs.ErrNotExist
+}
+
+// readDir merges the entries of the directory dir from all layers
+func (m *MergedFS) readDir(dir string) ([]fs.DirEntry, error) {
+	seen := make(map[string]bool)
+	var entries []fs.DirEntry
+	for _, layer := range m.layers {
+		if hasFileParent(layer, dir) {
+			break
+		}
+
+		info, err := layer.Lstat(dir)
+		if err == nil && !info.IsDir() {
+			// A directory of an upper layer replaces the file
+			break
+		} else if err == nil {
+			layerEntries, err := fs.ReadDir(layer, dir)
+			if err != nil {
+				return nil, err
+			}
+
+			// Whiteouts only apply to lower layers
+			var whiteouts []string
+			opaque := false
+			for _, entry := range layerEntries {
+				name := entry.Name()
+				switch {
+				case name == whiteoutOpaque:
+					opaque = true
+				case isWhiteout(name):
+					whiteouts = append(whiteouts, strings.TrimPrefix(name, whiteoutPrefix))
+				case !seen[name]:
+					seen[name] = true
+					entries = append(entries, entry)
+				}
+			}
+			for _, name := range whiteouts {
+				seen[name] = true
+			}
+			if opaque {
+				break
+			}
+		}
+
+		if hides(layer, dir) {
+			break
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+// isWhiteout reports whether name is a whiteout file
+func isWhiteout(name string) bool {
+	return strings.HasPrefix(name, whiteoutPrefix)
+}
+
+// hasFileParent reports whether a parent directory of p is not a directory in the layer
+func hasFileParent(layer *tarfs.FS, p string) bool {
+	for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+		if info, err := layer.Lstat(dir); err == nil && !info.IsDir() {
+			return true
+		}
+	}
+	return false
+}
+
+// hides reports whether the layer hides p in lower layers, by a whiteout of p
+// or of one of its parent directories, or by an opaque parent directory
+func hides(layer *tarfs.FS, p string) bool {
+	for current := p; current != "."; current = path.Dir(current) {
+		dir := path.Dir(current)
+		if exists(layer, path.Join(dir, whiteoutPrefix+path.Base(current))) {
+			return true
+		}
+		if exists(layer, path.Join(dir, whiteoutOpaque)) {
+			return true
+		}
+	}
+	return false
+}
+
+// exists reports whether the layer has a file at p
+func exists(layer *tarfs.FS, p string) bool {
+	_, err := layer.Lstat(p)
+	return err == nil
+}
+
+// cleanPath converts a symbolic link target to a path of the filesystem.
+// ".." never leaves the root.
+func cleanPath(p string) string {
+	p = path.Clean("/" + p)
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
+
+// mergedDir is an open directory of a MergedFS
+type mergedDir struct {
+	info    fs.FileInfo
+	entries []fs.DirEntry
+	pos     int
+}
+
+func (d *mergedDir) Stat() (fs.FileInfo, error) {
+	return d.info, nil
+}
+
+func (d *mergedDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.Name(), Err: errors.New("is a directory")}
+}
+
+func (d *mergedDir) Close() error {
+	return nil
+}
+
+func (d *mergedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	remaining := d.entries[d.pos:]
+	if n <= 0 {
+		d.pos = len(d.entries)
+		return remaining, nil
+	}
+	if len(remaining) == 0 {
+		return nil, io.EOF
+	}
+	n = min(n, len(remaining))
+	d.pos += n
+	return remaining[:n], nil
+}
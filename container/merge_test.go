@@ -0,0 +1,352 @@
+package container
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/knqyf263/sou/tarfs"
+)
+
+// testEntry is a file of a test layer. A regular file is written when
+// neither dir, link nor hardlink is set.
+type testEntry struct {
+	name     string
+	content  string
+	dir      bool
+	link     string
+	hardlink string
+	uid      int
+	gid      int
+	modTime  time.Time
+}
+
+// createMergeLayer creates an initialized layer from the entries
+func createMergeLayer(t *testing.T, entries ...testEntry) *Layer {
+	t.Helper()
+
+	tfs, err := tarfs.New(bytes.NewReader(writeTestTar(t, entries...)))
+	if err != nil {
+		t.Fatalf("Failed to create tarfs: %v", err)
+	}
+	return &Layer{fs: tfs}
+}
+
+// writeTestTar writes the entries to a tar archive
+func writeTestTar(t *testing.T, entries ...testEntry) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeReg, Size: int64(len(e.content))}
+		switch {
+		case e.dir:
+			hdr = &tar.Header{Name: e.name, Mode: 0o755, Typeflag: tar.TypeDir}
+		case e.link != "":
+			hdr = &tar.Header{Name: e.name, Mode: 0o777, Typeflag: tar.TypeSymlink, Linkname: e.link}
+		case e.hardlink != "":
+			hdr = &tar.Header{Name: e.name, Mode: 0o644, Typeflag: tar.TypeLink, Linkname: e.hardlink}
+		}
+		hdr.Uid, hdr.Gid, hdr.ModTime = e.uid, e.gid, e.modTime
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatalf("Failed to write header: %v", err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatalf("Failed to write content: %v", err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatalf("Failed to close tar writer: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func readDirNames(t *testing.T, m *MergedFS, name string) []string {
+	t.Helper()
+
+	entries, err := m.ReadDir(name)
+	if err != nil {
+		t.Fatalf("ReadDir(%q) error = %v", name, err)
+	}
+	names := []string{}
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestMerge(t *testing.T) {
+	// Layers are ordered from the newest to the oldest
+	tests := []struct {
+		name    string
+		layers  [][]testEntry
+		dirs    map[string][]string
+		files   map[string]string
+		missing []string
+	}{
+		{
+			name: "upper file overrides lower",
+			layers: [][]testEntry{
+				{{name: "etc/", dir: true}, {name: "etc/hosts", content: "upper"}},
+				{{name: "etc/", dir: true}, {name: "etc/hosts", content: "lower"}, {name: "etc/passwd", content: "root"}},
+			},
+			dirs:  map[string][]string{"etc": {"hosts", "passwd"}},
+			files: map[string]string{"etc/hosts": "upper", "etc/passwd": "root"},
+		},
+		{
+			name: "whiteout file",
+			layers: [][]testEntry{
+				{{name: "etc/", dir: true}, {name: "etc/.wh.hosts"}},
+				{{name: "etc/", dir: true}, {name: "etc/hosts", content: "lower"}, {name: "etc/passwd", content: "root"}},
+			},
+			dirs:    map[string][]string{"etc": {"passwd"}},
+			files:   map[string]string{"etc/passwd": "root"},
+			missing: []string{"etc/hosts", "etc/.wh.hosts"},
+		},
+		{
+			name: "whiteout directory",
+			layers: [][]testEntry{
+				{{name: ".wh.app"}},
+				{{name: "app/", dir: true}, {name: "app/bin/", dir: true}, {name: "app/bin/run", content: "run"}, {name: "keep", content: "keep"}},
+			},
+			dirs:    map[string][]string{".": {"keep"}},
+			missing: []string{"app", "app/bin", "app/bin/run"},
+		},
+		{
+			name: "opaque directory",
+			layers: [][]testEntry{
+				{{name: "app/", dir: true}, {name: "app/.wh..wh..opq"}, {name: "app/new", content: "new"}},
+				{{name: "app/", dir: true}, {name: "app/old", content: "old"}, {name: "app/sub/", dir: true}, {name: "app/sub/deep", content: "deep"}, {name: "other", content: "other"}},
+			},
+			dirs:    map[string][]string{".": {"app", "other"}, "app": {"new"}},
+			files:   map[string]string{"app/new": "new", "other": "other"},
+			missing: []string{"app/old", "app/sub", "app/sub/deep", "app/.wh..wh..opq"},
+		},
+		{
+			name: "opaque directory in a middle layer",
+			layers: [][]testEntry{
+				{{name: "app/", dir: true}, {name: "app/top", content: "top"}},
+				{{name: "app/", dir: true}, {name: "app/.wh..wh..opq"}, {name: "app/middle", content: "middle"}},
+				{{name: "app/", dir: true}, {name: "app/bottom", content: "bottom"}},
+			},
+			dirs:    map[string][]string{"app": {"middle", "top"}},
+			files:   map[string]string{"app/top": "top", "app/middle": "middle"},
+			missing: []string{"app/bottom"},
+		},
+		{
+			name: "file re-added after whiteout",
+			layers: [][]testEntry{
+				{{name: "etc/", dir: true}, {name: "etc/hosts", content: "new"}},
+				{{name: "etc/", dir: true}, {name: "etc/.wh.hosts"}},
+				{{name: "etc/", dir: true}, {name: "etc/hosts", content: "old"}},
+			},
+			dirs:  map[string][]string{"etc": {"hosts"}},
+			files: map[string]string{"etc/hosts": "new"},
+		},
+		{
+			name: "directory re-created after whiteout",
+			layers: [][]testEntry{
+				{{name: "app/", dir: true}, {name: "app/new", content: "new"}},
+				{{name: ".wh.app"}},
+				{{name: "app/", dir: true}, {name: "app/old", content: "old"}},
+			},
+			dirs:    map[string][]string{"app": {"new"}},
+			missing: []string{"app/old"},
+		},
+		{
+			name: "file replaces directory",
+			layers: [][]testEntry{
+				{{name: "app", content: "file"}},
+				{{name: "app/", dir: true}, {name: "app/old", content: "old"}},
+			},
+			files:   map[string]string{"app": "file"},
+			missing: []string{"app/old"},
+		},
+		{
+			name: "directory replaces file",
+			layers: [][]testEntry{
+				{{name: "app/", dir: true}, {name: "app/new", content: "new"}},
+				{{name: "app", content: "file"}},
+			},
+			dirs:  map[string][]string{"app": {"new"}},
+			files: map[string]string{"app/new": "new"},
+		},
+		{
+			name: "symlink in lower layer to directory extended by upper layer",
+			layers: [][]testEntry{
+				{{name: "usr/", dir: true}, {name: "usr/lib/", dir: true}, {name: "usr/lib/new.so", content: "new"}},
+				{{name: "lib", link: "usr/lib"}, {name: "usr/", dir: true}, {name: "usr/lib/", dir: true}, {name: "usr/lib/old.so", content: "old"}},
+			},
+			dirs:  map[string][]string{"lib": {"new.so", "old.so"}},
+			files: map[string]string{"lib/new.so": "new", "lib/old.so": "old"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var layers []*Layer
+			for _, entries := range tt.layers {
+				layers = append(layers, createMergeLayer(t, entries...))
+			}
+			m, err := Merge(layers...)
+			if err != nil {
+				t.Fatalf("Merge() error = %v", err)
+			}
+
+			for dir, want := range tt.dirs {
+				if got := readDirNames(t, m, dir); !reflect.DeepEqual(got, want) {
+					t.Errorf("ReadDir(%q) = %v, want %v", dir, got, want)
+				}
+			}
+			for name, want := range tt.files {
+				got, err := fs.ReadFile(m, name)
+				if err != nil {
+					t.Errorf("ReadFile(%q) error = %v", name, err)
+				} else if string(got) != want {
+					t.Errorf("ReadFile(%q) = %q, want %q", name, got, want)
+				}
+			}
+			for _, name := range tt.missing {
+				if _, err := m.Stat(name); !errors.Is(err, fs.ErrNotExist) {
+					t.Errorf("Stat(%q) error = %v, want %v", name, err, fs.ErrNotExist)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeSymlinkLoop(t *testing.T) {
+	m, err := Merge(
+		createMergeLayer(t, testEntry{name: "a", link: "b"}),
+		createMergeLayer(t, testEntry{name: "b", link: "a"}),
+	)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	if _, err := m.Stat("a"); !errors.Is(err, syscall.ELOOP) {
+		t.Errorf("Stat() error = %v, want %v", err, syscall.ELOOP)
+	}
+	if info, err := m.Lstat("a"); err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Errorf("Lstat() = %v, %v, want a symlink", info, err)
+	}
+	if target, err := m.ReadLink("b"); err != nil || target != "a" {
+		t.Errorf("ReadLink() = %q, %v, want %q", target, err, "a")
+	}
+}
+
+func TestMergeWalk(t *testing.T) {
+	m, err := Merge(
+		createMergeLayer(t, testEntry{name: "app/", dir: true}, testEntry{name: "app/.wh..wh..opq"}, testEntry{name: "app/new", content: "new"}),
+		createMergeLayer(t, testEntry{name: "app/", dir: true}, testEntry{name: "app/old", content: "old"}, testEntry{name: ".wh.gone"}),
+		createMergeLayer(t, testEntry{name: "gone", content: "gone"}, testEntry{name: "keep", content: "keep"}),
+	)
+	if err != nil {
+		t.Fatalf("Merge() error = %v", err)
+	}
+
+	var got []string
+	if err := fs.WalkDir(m, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		got = append(got, p)
+		return nil
+	}); err != nil {
+		t.Fatalf("WalkDir() error = %v", err)
+	}
+	want := []string{".", "app", "app/new", "keep"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("WalkDir() = %v, want %v", got, want)
+	}
+
+	f, err := m.Open("app")
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer f.Close()
+	dir, ok := f.(fs.ReadDirFile)
+	if !ok {
+		t.Fatal("Expected a directory")
+	}
+	if entries, err := dir.ReadDir(1); err != nil || len(entries) != 1 {
+		t.Errorf("ReadDir(1) = %v, %v", entries, err)
+	}
+	if _, err := dir.ReadDir(1); err != io.EOF {
+		t.Errorf("ReadDir(1) error = %v, want %v", err, io.EOF)
+	}
+}
+
+func TestMergeUninitialized(t *testing.T) {
+	if _, err := Merge(&Layer{DiffID: "sha256:uninitialized"}); err == nil {
+		t.Error("Expected an error for an uninitialized layer")
+	}
+}
+
+func TestMergeLayers(t *testing.T) {
+	upper := createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/passwd", content: "root:x:0:0:root:/root:/bin/sh\napp:x:1000:1000::/app:/bin/sh\n"},
+		testEntry{name: "etc/.wh.motd"},
+	)
+	upper.DiffID = "sha256:upper"
+	lower := createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/motd", content: "welcome"},
+		testEntry{name: "app/", dir: true},
+		testEntry{name: "app/run", content: "run", uid: 1000},
+	)
+	lower.DiffID = "sha256:lower"
+
+	merged := MergeLayers(*upper, *lower)
+	if got, want := merged.MergedDiffIDs(), []string{"sha256:upper", "sha256:lower"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("MergedDiffIDs() = %v, want %v", got, want)
+	}
+	if _, err := merged.GetFiles(context.Background(), "/"); err == nil {
+		t.Error("Expected an error before the layer is initialized")
+	}
+	if err := merged.InitializeLayer(context.Background(), nil); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+
+	files, err := merged.GetFiles(context.Background(), "/etc")
+	if err != nil {
+		t.Fatalf("GetFiles() error = %v", err)
+	}
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name)
+	}
+	if want := []string{"passwd"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("GetFiles(/etc) = %v, want %v", names, want)
+	}
+
+	// Owners are named after the accounts of the upper layer
+	files, err = merged.GetFiles(context.Background(), "app")
+	if err != nil {
+		t.Fatalf("GetFiles() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Owner != "app:0" {
+		t.Errorf("GetFiles(app) = %+v, want run owned by app", files)
+	}
+
+	content, err := merged.ReadFile(context.Background(), "/app/run")
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if string(content) != "run" {
+		t.Errorf("ReadFile() = %q, want %q", content, "run")
+	}
+
+	if merged := (&Layer{}).MergedDiffIDs(); merged != nil {
+		t.Errorf("MergedDiffIDs() = %v for an image layer", merged)
+	}
+}
@@ -0,0 +1,245 @@
+package container
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// PullPolicy decides where an image is loaded from
+type PullPolicy int
+
+const (
+	// PullMissing uses the image of the local Docker daemon if it exists and
+	// pulls it from the registry otherwise
+	PullMissing PullPolicy = iota
+	// PullAlways resolves the image from the registry even if a local image exists
+	PullAlways
+	// PullNever only uses the image of the local Docker daemon
+	PullNever
+)
+
+func (p PullPolicy) String() string {
+	switch p {
+	case PullMissing:
+		return "missing"
+	case PullAlways:
+		return "always"
+	case PullNever:
+		return "never"
+	default:
+		return "unknown"
+	}
+}
+
+// ParsePullPolicy parses "always", "missing" or "never" into a PullPolicy
+func ParsePullPolicy(s string) (PullPolicy, error) {
+	for _, p := range []PullPolicy{PullMissing, PullAlways, PullNever} {
+		if s == p.String() {
+			return p, nil
+		}
+	}
+	return PullMissing, fmt.Errorf("invalid pull policy %q, must be always, missing or never", s)
+}
+
+// Source is where local images are read from
+type Source int
+
+const (
+	// SourceDocker reads local images from the Docker daemon
+	SourceDocker Source = iota
+	// SourceCRIO reads local images from the containers/storage of CRI-O,
+	// as on OpenShift and other Kubernetes nodes, without a daemon
+	SourceCRIO
+	// SourceContainerd reads local images from the content store of
+	// containerd, in the namespace set by WithContainerdNamespace, as
+	// nerdctl and Kubernetes nodes pull them
+	SourceContainerd
+)
+
+func (s Source) String() string {
+	switch s {
+	case SourceDocker:
+		return "docker"
+	case SourceCRIO:
+		return "cri-o"
+	case SourceContainerd:
+		return "containerd"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSource parses "docker", "cri-o" or "containerd" into a Source
+func ParseSource(s string) (Source, error) {
+	for _, source := range []Source{SourceDocker, SourceCRIO, SourceContainerd} {
+		if s == source.String() {
+			return source, nil
+		}
+	}
+	return SourceDocker, fmt.Errorf("invalid source %q, must be docker, cri-o or containerd", s)
+}
+
+// Option configures Open
+type Option func(*options)
+
+type options struct {
+	platform    *v1.Platform
+	keychain    authn.Keychain
+	transport   http.RoundTripper
+	cache       *Cache
+	pullPolicy  PullPolicy
+	source      Source
+	namespace   string // containerd namespace
+	progress    ProgressFunc
+	retryPolicy RetryPolicy
+	ignoreCase  bool
+
+	inMemoryThreshold int64
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{
+		keychain:    authn.DefaultKeychain,
+		cache:       defaultCache,
+		pullPolicy:  PullMissing,
+		namespace:   DefaultContainerdNamespace,
+		progress:    func(Progress) {},
+		retryPolicy: DefaultRetryPolicy,
+
+		inMemoryThreshold: DefaultInMemoryThreshold,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithPlatform selects the platform of a multi-platform image. A local image
+// of another platform is ignored.
+func WithPlatform(platform v1.Platform) Option {
+	return func(o *options) {
+		o.platform = &platform
+	}
+}
+
+// WithKeychain sets the credentials for the registry.
+// The default reads the Docker config like `docker login` writes it.
+func WithKeychain(keychain authn.Keychain) Option {
+	return func(o *options) {
+		o.keychain = keychain
+	}
+}
+
+// WithTransport sets the HTTP transport for registry requests.
+// The ResponseTimeout of the retry policy doesn't apply to it.
+func WithTransport(transport http.RoundTripper) Option {
+	return func(o *options) {
+		o.transport = transport
+	}
+}
+
+// WithCache stores layers in the given cache instead of the default one.
+// The caller is responsible for calling Cleanup on the cache.
+func WithCache(cache *Cache) Option {
+	return func(o *options) {
+		o.cache = cache
+	}
+}
+
+// WithCacheDir stores layers in dir instead of a temporary directory.
+// Files are removed once the image and the layers initialized from it are
+// closed, as no Cleanup can be called on the cache.
+func WithCacheDir(dir string) Option {
+	return func(o *options) {
+		o.cache = NewCache(dir)
+	}
+}
+
+// WithPullPolicy sets where the image is loaded from. The default is PullMissing.
+func WithPullPolicy(policy PullPolicy) Option {
+	return func(o *options) {
+		o.pullPolicy = policy
+	}
+}
+
+// WithSource sets where local images are read from. The default is
+// SourceDocker.
+func WithSource(source Source) Option {
+	return func(o *options) {
+		o.source = source
+	}
+}
+
+// DefaultContainerdNamespace is the namespace of containerd nerdctl uses by
+// default. Kubernetes uses k8s.io and BuildKit buildkit.
+const DefaultContainerdNamespace = "default"
+
+// WithContainerdNamespace sets the namespace of containerd images are read
+// from with SourceContainerd. The default is DefaultContainerdNamespace.
+func WithContainerdNamespace(namespace string) Option {
+	return func(o *options) {
+		o.namespace = namespace
+	}
+}
+
+// WithProgress sets the callback receiving the progress of resolving the image
+func WithProgress(progress ProgressFunc) Option {
+	return func(o *options) {
+		if progress != nil {
+			o.progress = progress
+		}
+	}
+}
+
+// WithRetryPolicy sets how transient registry failures are retried.
+// The default is DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(o *options) {
+		o.retryPolicy = policy
+	}
+}
+
+// WithCaseInsensitive makes file paths of layers match files whose paths
+// differ only in case when there is no exact match, as in layers built on
+// Windows
+func WithCaseInsensitive() Option {
+	return func(o *options) {
+		o.ignoreCase = true
+	}
+}
+
+// WithInMemoryThreshold keeps layers of at most n bytes uncompressed in
+// memory instead of writing them to the cache directory. The default is
+// DefaultInMemoryThreshold, and a negative n writes every layer to the cache
+// directory.
+func WithInMemoryThreshold(n int64) Option {
+	return func(o *options) {
+		o.inMemoryThreshold = n
+	}
+}
+
+// remoteOptions returns the options for registry requests
+func (o *options) remoteOptions() []remote.Option {
+	transport := o.transport
+	if transport == nil {
+		transport = o.retryPolicy.transport()
+	}
+	opts := []remote.Option{
+		remote.WithTransport(transport),
+		remote.WithAuthFromKeychain(o.keychain),
+		// Failures are retried by the retry policy, not by go-containerregistry
+		// as well, which would multiply the attempts and the waits. Only
+		// its transport still retries a temporary network error twice
+		// within half a second, which can't be turned off for reads.
+		remote.WithRetryStatusCodes(),
+		remote.WithRetryPredicate(func(error) bool { return false }),
+	}
+	if o.platform != nil {
+		opts = append(opts, remote.WithPlatform(*o.platform))
+	}
+	return opts
+}
@@ -0,0 +1,202 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// countingTransport counts the requests sent through it
+type countingTransport struct {
+	requests atomic.Int32
+	blobs    atomic.Int32
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests.Add(1)
+	if strings.Contains(req.URL.Path, "/blobs/") {
+		t.blobs.Add(1)
+	}
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// pushTestImage pushes a random image to a test registry and returns its reference
+func pushTestImage(t *testing.T) string {
+	t.Helper()
+
+	img, err := setupTestImage(t)
+	if err != nil {
+		t.Fatalf("Failed to setup test image: %v", err)
+	}
+	ref := fmt.Sprintf("%s/test/options:latest", setupTestRegistry(t))
+	imgRef, err := name.ParseReference(ref)
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.Write(imgRef, img); err != nil {
+		t.Fatalf("Failed to push image: %v", err)
+	}
+	return ref
+}
+
+func TestOpen(t *testing.T) {
+	ref := pushTestImage(t)
+
+	t.Run("transport and progress", func(t *testing.T) {
+		transport := &countingTransport{}
+		var stages []Stage
+		image, err := Open(context.Background(), ref,
+			WithPullPolicy(PullAlways),
+			WithTransport(transport),
+			WithProgress(func(p Progress) {
+				stages = append(stages, p.Stage)
+			}),
+		)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer image.Close()
+
+		if image.Local {
+			t.Error("Expected a remote image")
+		}
+		if transport.requests.Load() == 0 {
+			t.Error("Expected requests to go through the transport")
+		}
+		if len(stages) == 0 || stages[len(stages)-1] != StageDone {
+			t.Errorf("Expected progress to end with done, got %v", stages)
+		}
+	})
+
+	t.Run("lazy layers", func(t *testing.T) {
+		transport := &countingTransport{}
+		image, err := Open(context.Background(), ref,
+			WithPullPolicy(PullAlways),
+			WithTransport(transport),
+		)
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer image.Close()
+
+		// Only the config blob is fetched until a layer is opened
+		if got := transport.blobs.Load(); got != 1 {
+			t.Errorf("Expected 1 blob request, got %d", got)
+		}
+		for _, l := range image.Layers {
+			if l.Size <= 0 {
+				t.Errorf("Expected positive size for layer %s, got %d", l.DiffID, l.Size)
+			}
+		}
+
+		l := &image.Layers[0]
+		if err := l.InitializeLayer(context.Background(), nil); err != nil {
+			t.Fatalf("InitializeLayer() error = %v", err)
+		}
+		if got := transport.blobs.Load(); got != 2 {
+			t.Errorf("Expected 2 blob requests after opening a layer, got %d", got)
+		}
+	})
+
+	t.Run("cache dir", func(t *testing.T) {
+		dir := t.TempDir()
+		image, err := Open(context.Background(), ref, WithCacheDir(dir), WithInMemoryThreshold(0))
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		if err := image.Layers[0].InitializeLayer(context.Background(), nil); err != nil {
+			t.Fatalf("InitializeLayer() error = %v", err)
+		}
+
+		files, err := filepath.Glob(filepath.Join(dir, "layer-*.tar"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 1 {
+			t.Fatalf("Expected 1 cached layer in %s, got %d", dir, len(files))
+		}
+
+		if err := image.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+		if _, err := os.Stat(files[0]); !os.IsNotExist(err) {
+			t.Error("Expected cached layer to be removed by Close")
+		}
+	})
+
+	t.Run("case insensitive", func(t *testing.T) {
+		image, err := Open(context.Background(), ref, WithCaseInsensitive())
+		if err != nil {
+			t.Fatalf("Open() error = %v", err)
+		}
+		defer image.Close()
+
+		l := &image.Layers[0]
+		if err := l.InitializeLayer(context.Background(), nil); err != nil {
+			t.Fatalf("InitializeLayer() error = %v", err)
+		}
+		files, err := l.GetFiles(context.Background(), ".")
+		if err != nil || len(files) == 0 {
+			t.Fatalf("GetFiles() = %v, %v", files, err)
+		}
+		var path string
+		for _, f := range files {
+			if !f.IsDir {
+				path = f.Path
+				break
+			}
+		}
+		if path == "" {
+			t.Fatal("Expected a file in the layer")
+		}
+		if _, err := l.ReadFile(context.Background(), strings.ToUpper(path)); err != nil {
+			t.Errorf("ReadFile(%q) error = %v", strings.ToUpper(path), err)
+		}
+	})
+
+	t.Run("pull never", func(t *testing.T) {
+		// The image only exists in the registry
+		_, err := Open(context.Background(), ref, WithPullPolicy(PullNever))
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("Expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestPullPolicyString(t *testing.T) {
+	tests := map[PullPolicy]string{
+		PullMissing:    "missing",
+		PullAlways:     "always",
+		PullNever:      "never",
+		PullPolicy(42): "unknown",
+	}
+	for policy, want := range tests {
+		if got := policy.String(); got != want {
+			t.Errorf("PullPolicy(%d).String() = %q, want %q", int(policy), got, want)
+		}
+	}
+}
+
+func TestParsePullPolicy(t *testing.T) {
+	for _, want := range []PullPolicy{PullMissing, PullAlways, PullNever} {
+		got, err := ParsePullPolicy(want.String())
+		if err != nil {
+			t.Fatalf("ParsePullPolicy(%q) error = %v", want, err)
+		}
+		if got != want {
+			t.Errorf("ParsePullPolicy(%q) = %v, want %v", want, got, want)
+		}
+	}
+	if _, err := ParsePullPolicy("sometimes"); err == nil {
+		t.Error("Expected an error for an invalid pull policy")
+	}
+}
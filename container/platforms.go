@@ -0,0 +1,206 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"slices"
+
+	"github.com/docker/docker/api/types/image"
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// PlatformCost is what pulling one of the platforms of an image transfers
+type PlatformCost struct {
+	Platform v1.Platform
+	// Digest is the digest of the manifest of the platform
+	Digest string
+	// Layers are the compressed layers of the platform, from the newest to
+	// the oldest as in Image.Layers
+	Layers []LayerCost
+}
+
+// LayerCost is a compressed layer of an image to pull
+type LayerCost struct {
+	Digest string
+	DiffID string
+	Size   int64
+	// Local is true if the Docker daemon has the layer on top of the same
+	// layers, so that it isn't pulled
+	Local bool
+}
+
+// Size returns the compressed size of all of the layers
+func (c PlatformCost) Size() int64 {
+	var size int64
+	for _, l := range c.Layers {
+		size += l.Size
+	}
+	return size
+}
+
+// PullSize returns the compressed size and the number of the layers the
+// Docker daemon doesn't have
+func (c PlatformCost) PullSize() (size int64, layers int) {
+	for _, l := range c.Layers {
+		if !l.Local {
+			size += l.Size
+			layers++
+		}
+	}
+	return size, layers
+}
+
+// PullCosts returns the cost of pulling each platform of the image of ref
+// from the registry, in the order of the index, or of the image alone if it
+// has one platform. Attestations of the index are skipped. The layers the
+// Docker daemon has are found if it is running. WithPlatform is ignored.
+func PullCosts(ctx context.Context, ref string, opts ...Option) ([]PlatformCost, error) {
+	o := newOptions(opts)
+	o.platform = nil
+	reference, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse reference: %w", err)
+	}
+	return pullCosts(ctx, reference, o, localChainIDs(ctx))
+}
+
+func pullCosts(ctx context.Context, reference name.Reference, o *options, local map[string]bool) ([]PlatformCost, error) {
+	opts := append(o.remoteOptions(), remote.WithContext(ctx))
+	var costs []PlatformCost
+	err := o.retryPolicy.do(ctx, func() error {
+		costs = nil
+		desc, err := remote.Get(reference, opts...)
+		if err != nil {
+			return fmt.Errorf("failed to get image: %w", err)
+		}
+		if !desc.MediaType.IsIndex() {
+			img, err := desc.Image()
+			if err != nil {
+				return fmt.Errorf("failed to get image: %w", err)
+			}
+			configFile, err := img.ConfigFile()
+			if err != nil {
+				return fmt.Errorf("failed to get config: %w", err)
+			}
+			platform := v1.Platform{}
+			if p := configFile.Platform(); p != nil {
+				platform = *p
+			}
+			cost, err := platformCost(img, platform, desc.Digest.String(), local)
+			if err != nil {
+				return err
+			}
+			costs = append(costs, cost)
+			return nil
+		}
+
+		index, err := desc.ImageIndex()
+		if err != nil {
+			return fmt.Errorf("failed to get index: %w", err)
+		}
+		manifest, err := index.IndexManifest()
+		if err != nil {
+			return fmt.Errorf("failed to get index: %w", err)
+		}
+		for _, d := range manifest.Manifests {
+			// Attestations are listed as the platform unknown/unknown
+			if d.Platform == nil || d.Platform.OS == "unknown" || !d.MediaType.IsImage() {
+				continue
+			}
+			img, err := index.Image(d.Digest)
+			if err != nil {
+				return fmt.Errorf("failed to get image of %s: %w", d.Platform, err)
+			}
+			cost, err := platformCost(img, *d.Platform, d.Digest.String(), local)
+			if err != nil {
+				return fmt.Errorf("%s: %w", d.Platform, err)
+			}
+			costs = append(costs, cost)
+		}
+		return nil
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classifyError(err)
+	}
+	return costs, nil
+}
+
+// platformCost lists the compressed layers of the image. local has the
+// chain IDs of the layers the Docker daemon has.
+func platformCost(img v1.Image, platform v1.Platform, digest string, local map[string]bool) (PlatformCost, error) {
+	manifest, err := img.Manifest()
+	if err != nil {
+		return PlatformCost{}, fmt.Errorf("failed to get manifest: %w", err)
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		return PlatformCost{}, fmt.Errorf("failed to get config: %w", err)
+	}
+	diffIDs := make([]string, len(configFile.RootFS.DiffIDs))
+	for i, d := range configFile.RootFS.DiffIDs {
+		diffIDs[i] = d.String()
+	}
+	chains := chainIDs(diffIDs)
+
+	cost := PlatformCost{Platform: platform, Digest: digest}
+	for i, l := range manifest.Layers {
+		layer := LayerCost{Digest: l.Digest.String(), Size: l.Size}
+		if i < len(diffIDs) {
+			layer.DiffID = diffIDs[i]
+			layer.Local = local[chains[i]]
+		}
+		cost.Layers = append(cost.Layers, layer)
+	}
+	slices.Reverse(cost.Layers)
+	return cost, nil
+}
+
+// chainIDs returns the chain IDs of the layers of an image, from the oldest
+// to the newest. A chain ID identifies a layer with the layers below it, as
+// the Docker daemon stores them.
+func chainIDs(diffIDs []string) []string {
+	chains := make([]string, len(diffIDs))
+	for i, d := range diffIDs {
+		if i == 0 {
+			chains[i] = d
+			continue
+		}
+		chains[i] = fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(chains[i-1]+" "+d)))
+	}
+	return chains
+}
+
+// localChainIDs returns the chain IDs of the layers of the images of the
+// Docker daemon, or none if it isn't running
+func localChainIDs(ctx context.Context) map[string]bool {
+	local := make(map[string]bool)
+	cli, err := dockerClient()
+	if err != nil {
+		debug("No Docker daemon: %v", err)
+		return local
+	}
+	defer cli.Close()
+
+	images, err := cli.ImageList(ctx, image.ListOptions{})
+	if err != nil {
+		debug("Failed to list local images: %v", err)
+		return local
+	}
+	for _, img := range images {
+		inspect, _, err := cli.ImageInspectWithRaw(ctx, img.ID)
+		if err != nil {
+			debug("Failed to inspect local image %s: %v", img.ID, err)
+			continue
+		}
+		for _, c := range chainIDs(inspect.RootFS.Layers) {
+			local[c] = true
+		}
+	}
+	return local
+}
@@ -0,0 +1,107 @@
+package container
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestPullCosts(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+	amd64, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	arm64, err := random.Image(2048, 3)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	attestation, err := random.Image(100, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	index := mutate.AppendManifests(empty.Index,
+		mutate.IndexAddendum{Add: amd64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "amd64"}}},
+		mutate.IndexAddendum{Add: arm64, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}}},
+		mutate.IndexAddendum{Add: attestation, Descriptor: v1.Descriptor{Platform: &v1.Platform{OS: "unknown", Architecture: "unknown"}}},
+	)
+	reference, err := name.ParseReference(fmt.Sprintf("%s/test/multi:latest", registryHost))
+	if err != nil {
+		t.Fatalf("Failed to parse reference: %v", err)
+	}
+	if err := remote.WriteIndex(reference, index); err != nil {
+		t.Fatalf("Failed to push index: %v", err)
+	}
+
+	// The daemon has the oldest layer of arm64
+	configFile, err := arm64.ConfigFile()
+	if err != nil {
+		t.Fatalf("Failed to get config: %v", err)
+	}
+	local := map[string]bool{configFile.RootFS.DiffIDs[0].String(): true}
+
+	costs, err := pullCosts(context.Background(), reference, newOptions(nil), local)
+	if err != nil {
+		t.Fatalf("pullCosts() error = %v", err)
+	}
+	if len(costs) != 2 {
+		t.Fatalf("pullCosts() returned %d platforms, want 2", len(costs))
+	}
+	var platforms []string
+	for _, c := range costs {
+		platforms = append(platforms, c.Platform.String())
+	}
+	if want := []string{"linux/amd64", "linux/arm64/v8"}; !reflect.DeepEqual(platforms, want) {
+		t.Errorf("platforms = %v, want %v", platforms, want)
+	}
+
+	for i, img := range []v1.Image{amd64, arm64} {
+		manifest, err := img.Manifest()
+		if err != nil {
+			t.Fatalf("Failed to get manifest: %v", err)
+		}
+		var want int64
+		for _, l := range manifest.Layers {
+			want += l.Size
+		}
+		if got := costs[i].Size(); got != want {
+			t.Errorf("%s: Size() = %d, want %d", platforms[i], got, want)
+		}
+		if digest, _ := img.Digest(); costs[i].Digest != digest.String() {
+			t.Errorf("%s: Digest = %s, want %s", platforms[i], costs[i].Digest, digest)
+		}
+		// Layers are from the newest to the oldest
+		if got, want := costs[i].Layers[0].Digest, manifest.Layers[len(manifest.Layers)-1].Digest.String(); got != want {
+			t.Errorf("%s: newest layer = %s, want %s", platforms[i], got, want)
+		}
+	}
+
+	if size, layers := costs[0].PullSize(); size != costs[0].Size() || layers != 2 {
+		t.Errorf("amd64: PullSize() = %d, %d, want %d, 2", size, layers, costs[0].Size())
+	}
+	oldest := costs[1].Layers[2]
+	if !oldest.Local {
+		t.Errorf("arm64: oldest layer isn't local")
+	}
+	if size, layers := costs[1].PullSize(); size != costs[1].Size()-oldest.Size || layers != 2 {
+		t.Errorf("arm64: PullSize() = %d, %d, want %d, 2", size, layers, costs[1].Size()-oldest.Size)
+	}
+}
+
+func TestChainIDs(t *testing.T) {
+	got := chainIDs([]string{"sha256:a", "sha256:b", "sha256:c"})
+	second := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte("sha256:a sha256:b")))
+	third := fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(second+" sha256:c")))
+	if want := []string{"sha256:a", second, third}; !reflect.DeepEqual(got, want) {
+		t.Errorf("chainIDs() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,130 @@
+package container
+
+import (
+	"io"
+	"time"
+)
+
+// Stage is a step of loading an image or a layer
+type Stage int
+
+const (
+	// StageResolving fetches the image manifest and config
+	StageResolving Stage = iota
+	// StageDownloading fetches a compressed layer from a registry
+	StageDownloading
+	// StageDecompressing reads the uncompressed content of a local layer
+	StageDecompressing
+	// StageIndexing builds the file index of a layer
+	StageIndexing
+	// StageDone reports that loading has finished
+	StageDone
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageResolving:
+		return "resolving"
+	case StageDownloading:
+		return "downloading"
+	case StageDecompressing:
+		return "decompressing"
+	case StageIndexing:
+		return "indexing"
+	case StageDone:
+		return "done"
+	default:
+		return "unknown"
+	}
+}
+
+// Progress describes how far loading an image or a layer has gone
+type Progress struct {
+	Stage Stage
+	// Layer is the DiffID of the layer being loaded, or empty for the image
+	Layer string
+	// Complete and Total are the bytes processed in the current stage.
+	// Total is zero if it is unknown.
+	Complete int64
+	Total    int64
+	// Blob is the digest of a blob fetched while resolving the image, such as
+	// its manifest and config, or the path of an entry of the archive exported
+	// by the Docker daemon if it has no digest. Complete and Total are then
+	// the bytes of the blob. It is empty for the progress of the image.
+	Blob string
+	// Exists reports that the blob is available locally and isn't fetched
+	Exists bool
+}
+
+// readWeight is the share of reading a layer in its overall progress,
+// the rest being indexing
+const readWeight = 0.8
+
+// Fraction returns the overall progress between 0 and 1
+func (p Progress) Fraction() float64 {
+	var f float64
+	if p.Total > 0 {
+		f = min(float64(p.Complete)/float64(p.Total), 1)
+	}
+	switch p.Stage {
+	case StageDownloading, StageDecompressing:
+		return f * readWeight
+	case StageIndexing:
+		return readWeight + f*(1-readWeight)
+	case StageDone:
+		return 1
+	default:
+		return f
+	}
+}
+
+// ProgressFunc is a callback function to report progress
+type ProgressFunc func(Progress)
+
+// progressInterval is the minimum interval between two progress reports while reading
+const progressInterval = 50 * time.Millisecond
+
+// progressReader wraps an io.Reader to track progress
+type progressReader struct {
+	r          io.Reader
+	progress   ProgressFunc
+	stage      Stage
+	layer      string
+	blob       string
+	total      int64
+	current    int64
+	lastUpdate time.Time
+	transfer   *transfer
+}
+
+func (pr *progressReader) Read(p []byte) (int, error) {
+	n, err := pr.r.Read(p)
+	if n > 0 {
+		pr.current += int64(n)
+		if pr.transfer != nil {
+			pr.transfer.add(int64(n))
+		}
+		// Update progress at most once every progressInterval
+		if now := time.Now(); now.Sub(pr.lastUpdate) >= progressInterval {
+			pr.report()
+			pr.lastUpdate = now
+		}
+	}
+
+	// Ensure we send the final progress when the read is complete
+	if err == io.EOF {
+		pr.report()
+	}
+
+	return n, err
+}
+
+func (pr *progressReader) report() {
+	pr.progress(Progress{
+		Stage:    pr.stage,
+		Layer:    pr.layer,
+		Blob:     pr.blob,
+		Complete: pr.current,
+		Total:    pr.total,
+	})
+}
@@ -0,0 +1,111 @@
+package container
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProgressFraction(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress Progress
+		want     float64
+	}{
+		{
+			name:     "resolving",
+			progress: Progress{Stage: StageResolving, Complete: 1, Total: 4},
+			want:     0.25,
+		},
+		{
+			name:     "unknown total",
+			progress: Progress{Stage: StageDownloading, Complete: 10},
+			want:     0,
+		},
+		{
+			name:     "downloading",
+			progress: Progress{Stage: StageDownloading, Complete: 50, Total: 100},
+			want:     0.4,
+		},
+		{
+			name:     "decompressing beyond total",
+			progress: Progress{Stage: StageDecompressing, Complete: 200, Total: 100},
+			want:     0.8,
+		},
+		{
+			name:     "indexing",
+			progress: Progress{Stage: StageIndexing, Total: 100},
+			want:     0.8,
+		},
+		{
+			name:     "done",
+			progress: Progress{Stage: StageDone},
+			want:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.progress.Fraction(); got < tt.want-1e-9 || got > tt.want+1e-9 {
+				t.Errorf("Fraction() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitializeLayerProgress(t *testing.T) {
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+
+	l := Layer{
+		DiffID: "sha256:progress",
+		layer:  layer,
+	}
+	t.Cleanup(func() { CleanupCache() })
+
+	var reports []Progress
+	if err := l.InitializeLayer(context.Background(), func(p Progress) {
+		reports = append(reports, p)
+	}); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+
+	if len(reports) == 0 {
+		t.Fatal("Expected progress to be reported")
+	}
+	var last float64
+	for _, p := range reports {
+		if p.Layer != l.DiffID {
+			t.Errorf("Expected layer %s, got %s", l.DiffID, p.Layer)
+		}
+		if f := p.Fraction(); f < last {
+			t.Errorf("Expected progress not to go backwards: %v after %v (%s)", f, last, p.Stage)
+		} else {
+			last = f
+		}
+	}
+
+	var stages []Stage
+	for _, p := range reports {
+		if len(stages) == 0 || stages[len(stages)-1] != p.Stage {
+			stages = append(stages, p.Stage)
+		}
+	}
+	want := []Stage{StageDecompressing, StageIndexing, StageDone}
+	if len(stages) != len(want) {
+		t.Fatalf("Expected stages %v, got %v", want, stages)
+	}
+	for i := range want {
+		if stages[i] != want[i] {
+			t.Errorf("Expected stages %v, got %v", want, stages)
+			break
+		}
+	}
+
+	// Indexing reports the bytes indexed until the whole layer is done
+	indexed := reports[len(reports)-2]
+	if indexed.Stage != StageIndexing || indexed.Total == 0 || indexed.Complete != indexed.Total {
+		t.Errorf("Expected indexing to complete, got %+v", indexed)
+	}
+}
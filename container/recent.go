@@ -0,0 +1,50 @@
+package container
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strings"
+)
+
+// RecentFiles returns the files of the initialized layer, directories
+// excluded, the most recently modified first. Files with the same time are
+// sorted by path. Whiteouts of the files deleted by the layer aren't
+// returned.
+func (l *Layer) RecentFiles(ctx context.Context) ([]File, error) {
+	fsys := l.files()
+	if fsys == nil {
+		return nil, fmt.Errorf("layer not initialized")
+	}
+
+	var files []File
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || strings.HasPrefix(d.Name(), whiteoutPrefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, l.newFile(p, info, false))
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files: %w", err)
+	}
+	slices.SortStableFunc(files, func(a, b File) int {
+		if c := b.ModifiedAt.Compare(a.ModifiedAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Path, b.Path)
+	})
+	return files, nil
+}
@@ -0,0 +1,42 @@
+package container
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecentFiles(t *testing.T) {
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	layer := createMergeLayer(t,
+		testEntry{name: "usr/", dir: true, modTime: base.Add(3 * time.Hour)},
+		testEntry{name: "usr/bin/", dir: true, modTime: base.Add(3 * time.Hour)},
+		testEntry{name: "usr/bin/app", content: "app", modTime: base.Add(2 * time.Hour)},
+		testEntry{name: "usr/bin/tool", content: "tool", modTime: base},
+		testEntry{name: "etc/", dir: true, modTime: base},
+		testEntry{name: "etc/app.conf", content: "conf", modTime: base.Add(2 * time.Hour)},
+		testEntry{name: "etc/.wh.motd", modTime: base.Add(4 * time.Hour)},
+		testEntry{name: "etc/current", link: "app.conf", modTime: base.Add(time.Hour)},
+	)
+
+	got, err := layer.RecentFiles(context.Background())
+	if err != nil {
+		t.Fatalf("RecentFiles() error = %v", err)
+	}
+	var paths []string
+	for _, f := range got {
+		paths = append(paths, f.Path)
+	}
+	want := []string{"etc/app.conf", "usr/bin/app", "etc/current", "usr/bin/tool"}
+	if !reflect.DeepEqual(paths, want) {
+		t.Errorf("RecentFiles() = %v, want %v", paths, want)
+	}
+	if f := got[0]; f.Name != "app.conf" || f.Size != 4 || !f.ModifiedAt.Equal(base.Add(2*time.Hour)) {
+		t.Errorf("RecentFiles()[0] = %+v", f)
+	}
+
+	if _, err := (&Layer{}).RecentFiles(context.Background()); err == nil {
+		t.Error("Expected an error before the layer is initialized")
+	}
+}
@@ -0,0 +1,119 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"slices"
+	"syscall"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// RetryPolicy controls how transient registry failures are retried
+type RetryPolicy struct {
+	// Attempts is the maximum number of attempts including the first one.
+	// Values below 1 mean a single attempt.
+	Attempts int
+	// InitialBackoff is the wait before the first retry. It doubles with every
+	// retry up to MaxBackoff, and a random jitter is applied to each wait.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// ResponseTimeout bounds the wait for the response headers of each
+	// request. It doesn't limit how long a layer download may take.
+	// Zero means no timeout.
+	ResponseTimeout time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy used for registry requests
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:        4,
+	InitialBackoff:  500 * time.Millisecond,
+	MaxBackoff:      8 * time.Second,
+	ResponseTimeout: 30 * time.Second,
+}
+
+// retryStatusCodes are the HTTP status codes worth retrying
+var retryStatusCodes = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// backoff returns the wait before the given retry, starting from zero.
+// The wait is picked at random between half and all of the exponential backoff
+// so that clients failing at the same time don't retry in lockstep.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.InitialBackoff
+	for i := 0; i < retry && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if d <= 1 {
+		return d
+	}
+	return d/2 + rand.N(d/2)
+}
+
+// do calls fn until it succeeds, fails with a permanent error or runs out of attempts
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	attempts := max(p.Attempts, 1)
+
+	var err error
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			wait := p.backoff(i - 1)
+			debug("Retrying in %s (attempt %d/%d) after error: %v", wait, i+1, attempts, err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+		}
+		if err = fn(); err == nil || !isTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// transport returns the HTTP transport applying the response timeout
+func (p RetryPolicy) transport() http.RoundTripper {
+	t := remote.DefaultTransport.(*http.Transport).Clone()
+	t.ResponseHeaderTimeout = p.ResponseTimeout
+	return t
+}
+
+// isTransient reports whether err is a failure that may go away on retry
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var terr *transport.Error
+	if errors.As(err, &terr) {
+		return slices.Contains(retryStatusCodes, terr.StatusCode)
+	}
+
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.EPIPE)
+}
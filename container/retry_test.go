@@ -0,0 +1,178 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/google/go-containerregistry/pkg/v1/remote/transport"
+)
+
+// fastRetryPolicy retries without waiting long
+var fastRetryPolicy = RetryPolicy{
+	Attempts:       3,
+	InitialBackoff: time.Millisecond,
+	MaxBackoff:     2 * time.Millisecond,
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	p := RetryPolicy{
+		InitialBackoff: 100 * time.Millisecond,
+		MaxBackoff:     time.Second,
+	}
+
+	tests := []struct {
+		retry int
+		max   time.Duration
+	}{
+		{retry: 0, max: 100 * time.Millisecond},
+		{retry: 1, max: 200 * time.Millisecond},
+		{retry: 2, max: 400 * time.Millisecond},
+		{retry: 10, max: time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.retry), func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				got := p.backoff(tt.retry)
+				if got < tt.max/2 || got > tt.max {
+					t.Fatalf("backoff(%d) = %s, want between %s and %s", tt.retry, got, tt.max/2, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	transient := &transport.Error{StatusCode: http.StatusServiceUnavailable}
+	permanent := &transport.Error{StatusCode: http.StatusNotFound}
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "success",
+			errs:      []error{nil},
+			wantCalls: 1,
+		},
+		{
+			name:      "transient then success",
+			errs:      []error{transient, io.ErrUnexpectedEOF, nil},
+			wantCalls: 3,
+		},
+		{
+			name:      "permanent",
+			errs:      []error{permanent},
+			wantCalls: 1,
+			wantErr:   permanent,
+		},
+		{
+			name:      "out of attempts",
+			errs:      []error{transient, transient, transient, nil},
+			wantCalls: 3,
+			wantErr:   transient,
+		},
+		{
+			name:      "canceled",
+			errs:      []error{context.Canceled},
+			wantCalls: 1,
+			wantErr:   context.Canceled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls int
+			err := fastRetryPolicy.do(context.Background(), func() error {
+				err := tt.errs[calls]
+				calls++
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("Expected %d calls, got %d", tt.wantCalls, calls)
+			}
+		})
+	}
+}
+
+// flakyRegistry fails the first requests matching the path suffix with 503
+func flakyRegistry(t *testing.T, suffix string, failures int32) string {
+	handler := registry.New()
+	var failed atomic.Int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet && strings.Contains(r.URL.Path, suffix) && failed.Load() < failures {
+			failed.Add(1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(s.Close)
+
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse server URL: %v", err)
+	}
+	return u.Host
+}
+
+func TestNewImageRetry(t *testing.T) {
+	original := DefaultRetryPolicy
+	DefaultRetryPolicy = fastRetryPolicy
+	t.Cleanup(func() {
+		DefaultRetryPolicy = original
+		CleanupCache()
+	})
+
+	tests := []struct {
+		name   string
+		suffix string
+	}{
+		{name: "manifest", suffix: "/manifests/"},
+		{name: "blob", suffix: "/blobs/"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registryHost := flakyRegistry(t, tt.suffix, 2)
+
+			img, err := setupTestImage(t)
+			if err != nil {
+				t.Fatalf("Failed to setup test image: %v", err)
+			}
+			ref := fmt.Sprintf("%s/test/retry:latest", registryHost)
+			imgRef, err := name.ParseReference(ref)
+			if err != nil {
+				t.Fatalf("Failed to parse reference: %v", err)
+			}
+			if err := remote.Write(imgRef, img); err != nil {
+				t.Fatalf("Failed to push image: %v", err)
+			}
+
+			image, _, err := NewImage(context.Background(), ref, mockProgressFunc)
+			if err != nil {
+				t.Fatalf("NewImage() error = %v", err)
+			}
+			if err := image.Layers[0].InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+				t.Fatalf("InitializeLayer() error = %v", err)
+			}
+		})
+	}
+}
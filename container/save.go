@@ -0,0 +1,174 @@
+package container
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/daemon"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/match"
+	"github.com/google/go-containerregistry/pkg/v1/tarball"
+)
+
+// annotationRefName is the tag of an image of an OCI layout
+const annotationRefName = "org.opencontainers.image.ref.name"
+
+// SaveKind is the kind of store an image is saved to
+type SaveKind int
+
+const (
+	// SaveDaemon loads the image into the Docker daemon
+	SaveDaemon SaveKind = iota
+	// SaveOCILayout adds the image to an OCI image layout directory
+	SaveOCILayout
+	// SaveDockerArchive writes the image as a `docker save` archive
+	SaveDockerArchive
+)
+
+// String returns the transport of the kind in a destination
+func (k SaveKind) String() string {
+	switch k {
+	case SaveOCILayout:
+		return "oci"
+	case SaveDockerArchive:
+		return "docker-archive"
+	default:
+		return "docker-daemon"
+	}
+}
+
+// SaveDestination is where an image is saved to
+type SaveDestination struct {
+	Kind SaveKind
+	// Path is the directory of the OCI layout or the path of the archive
+	Path string
+	// Ref is the reference the image is saved as, the one it was opened by
+	// if empty
+	Ref string
+}
+
+// ParseSaveDestination parses a destination as written by skopeo:
+// docker-daemon[:<name:tag>], oci:<dir>[:<tag>] or
+// docker-archive:<path>[:<name:tag>]
+func ParseSaveDestination(s string) (SaveDestination, error) {
+	transport, rest, _ := strings.Cut(s, ":")
+	switch transport {
+	case "docker-daemon":
+		return SaveDestination{Kind: SaveDaemon, Ref: rest}, nil
+	case "oci":
+		path, tag := splitSavePath(rest, func(tag string) bool { return !strings.ContainsAny(tag, "/\\") })
+		if path == "" {
+			return SaveDestination{}, fmt.Errorf("invalid destination %q, the OCI layout needs a directory", s)
+		}
+		return SaveDestination{Kind: SaveOCILayout, Path: path, Ref: tag}, nil
+	case "docker-archive":
+		path, ref := splitSavePath(rest, func(ref string) bool {
+			// The tag must be explicit, as the end of a path is a valid name
+			_, err := name.NewTag(ref)
+			return err == nil && strings.Contains(ref[strings.LastIndex(ref, "/")+1:], ":")
+		})
+		if path == "" {
+			return SaveDestination{}, fmt.Errorf("invalid destination %q, the archive needs a path", s)
+		}
+		return SaveDestination{Kind: SaveDockerArchive, Path: path, Ref: ref}, nil
+	}
+	return SaveDestination{}, fmt.Errorf("invalid destination %q, must be docker-daemon[:<name:tag>], oci:<dir>[:<tag>] or docker-archive:<path>[:<name:tag>]", s)
+}
+
+// splitSavePath splits the path and the reference after it. Paths may have
+// colons, so the reference is what follows the colon valid tells apart.
+func splitSavePath(s string, valid func(string) bool) (string, string) {
+	for i := strings.Index(s, ":"); i >= 0; {
+		if valid(s[i+1:]) {
+			return s[:i], s[i+1:]
+		}
+		next := strings.Index(s[i+1:], ":")
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+	return s, ""
+}
+
+// Save copies the image to the destination. Layers of remote images are
+// pulled again unless the registry returns them from a cache.
+func (i *Image) Save(ctx context.Context, dest SaveDestination) error {
+	switch dest.Kind {
+	case SaveOCILayout:
+		return i.saveOCILayout(dest)
+	case SaveDockerArchive:
+		ref, err := i.saveRef(dest.Ref)
+		if err != nil {
+			return err
+		}
+		if err := tarball.WriteToFile(dest.Path, ref, i.img); err != nil {
+			os.Remove(dest.Path)
+			return fmt.Errorf("failed to write the archive: %w", err)
+		}
+		return nil
+	default:
+		ref, err := i.saveRef(dest.Ref)
+		if err != nil {
+			return err
+		}
+		tag, ok := ref.(name.Tag)
+		if !ok {
+			return fmt.Errorf("the Docker daemon needs a tag to load the image as, such as docker-daemon:%s:latest", ref.Context().Name())
+		}
+		cli, err := dockerClient()
+		if err != nil {
+			return err
+		}
+		defer cli.Close()
+		if _, err := daemon.Write(tag, i.img, daemon.WithClient(cli), daemon.WithContext(ctx)); err != nil {
+			return fmt.Errorf("failed to load the image into the Docker daemon: %w", err)
+		}
+		return nil
+	}
+}
+
+// saveRef returns the reference the image is saved as
+func (i *Image) saveRef(ref string) (name.Reference, error) {
+	if ref == "" {
+		ref = i.Reference
+	}
+	r, err := name.ParseReference(ref)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a reference to save the image as, give one in the destination: %w", ref, err)
+	}
+	return r, nil
+}
+
+// saveOCILayout adds the image to the OCI layout, creating it if needed. An
+// image of the layout with the same tag is replaced.
+func (i *Image) saveOCILayout(dest SaveDestination) error {
+	tag := dest.Ref
+	if tag == "" {
+		if r, err := name.NewTag(i.Reference); err == nil {
+			tag = r.TagStr()
+		}
+	}
+	p, err := layout.FromPath(dest.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		p, err = layout.Write(dest.Path, empty.Index)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open the OCI layout: %w", err)
+	}
+	if tag == "" {
+		err = p.AppendImage(i.img)
+	} else {
+		annotations := map[string]string{annotationRefName: tag}
+		err = p.ReplaceImage(i.img, match.Annotation(annotationRefName, tag), layout.WithAnnotations(annotations))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write the image to the OCI layout: %w", err)
+	}
+	return nil
+}
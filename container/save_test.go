@@ -0,0 +1,109 @@
+package container
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/v1/layout"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+)
+
+func TestParseSaveDestination(t *testing.T) {
+	tests := []struct {
+		dest    string
+		want    SaveDestination
+		wantErr bool
+	}{
+		{dest: "docker-daemon", want: SaveDestination{Kind: SaveDaemon}},
+		{dest: "docker-daemon:app:1.0", want: SaveDestination{Kind: SaveDaemon, Ref: "app:1.0"}},
+		{dest: "oci:images", want: SaveDestination{Kind: SaveOCILayout, Path: "images"}},
+		{dest: "oci:/data/images:1.0", want: SaveDestination{Kind: SaveOCILayout, Path: "/data/images", Ref: "1.0"}},
+		{dest: "docker-archive:app.tar", want: SaveDestination{Kind: SaveDockerArchive, Path: "app.tar"}},
+		{dest: "docker-archive:app.tar:ghcr.io/org/app:1.0", want: SaveDestination{Kind: SaveDockerArchive, Path: "app.tar", Ref: "ghcr.io/org/app:1.0"}},
+		{dest: "docker-archive:app.tar:org/app:1.0", want: SaveDestination{Kind: SaveDockerArchive, Path: "app.tar", Ref: "org/app:1.0"}},
+		{dest: "docker-archive:a:b/app.tar", want: SaveDestination{Kind: SaveDockerArchive, Path: "a:b/app.tar"}},
+		{dest: "oci:", wantErr: true},
+		{dest: "docker-archive:", wantErr: true},
+		{dest: "dir:images", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSaveDestination(tt.dest)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSaveDestination(%q) error = %v, wantErr %v", tt.dest, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseSaveDestination(%q) = %+v, want %+v", tt.dest, got, tt.want)
+		}
+	}
+}
+
+func TestSave(t *testing.T) {
+	img, err := random.Image(1024, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, err := createImageFromV1(img, "ghcr.io/org/app:1.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantDigest, err := img.Digest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+
+	// The image replaces the one of the same tag in the layout
+	layoutDir := filepath.Join(dir, "layout")
+	for range 2 {
+		if err := image.Save(context.Background(), SaveDestination{Kind: SaveOCILayout, Path: layoutDir}); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+	p, err := layout.FromPath(layoutDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := p.ImageIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := index.IndexManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Manifests) != 1 {
+		t.Fatalf("Expected 1 image in the layout, got %d", len(manifest.Manifests))
+	}
+	if got := manifest.Manifests[0]; got.Digest != wantDigest || got.Annotations[annotationRefName] != "1.0" {
+		t.Errorf("Layout has %s tagged %q, want %s tagged 1.0", got.Digest, got.Annotations[annotationRefName], wantDigest)
+	}
+
+	archivePath := filepath.Join(dir, "app.tar")
+	if err := image.Save(context.Background(), SaveDestination{Kind: SaveDockerArchive, Path: archivePath}); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if !IsImageArchive(archivePath) {
+		t.Fatal("Expected an image archive")
+	}
+	saved, err := Open(context.Background(), archivePath)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer saved.Close()
+	if len(saved.Layers) != len(image.Layers) {
+		t.Errorf("Saved image has %d layers, want %d", len(saved.Layers), len(image.Layers))
+	}
+	for i := range saved.Layers {
+		if saved.Layers[i].DiffID != image.Layers[i].DiffID {
+			t.Errorf("Layer %d has diff ID %s, want %s", i, saved.Layers[i].DiffID, image.Layers[i].DiffID)
+		}
+	}
+
+	// Images opened by digest need a tag for the Docker daemon
+	image.Reference = "ghcr.io/org/app@" + wantDigest.String()
+	if err := image.Save(context.Background(), SaveDestination{Kind: SaveDaemon}); err == nil {
+		t.Error("Expected an error without a tag")
+	}
+}
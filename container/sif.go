@@ -0,0 +1,348 @@
+package container
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/partial"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/knqyf263/sou/squashfs"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// sifMagic is at sifMagicOffset in SIF files, after the launch script
+var sifMagic = []byte("SIF_MAGIC")
+
+const sifMagicOffset = 32
+
+// sifSquashfsLayer is the media type of the squashfs layers of the OCI-SIF
+// files built by SingularityCE
+const sifSquashfsLayer types.MediaType = "application/vnd.sylabs.image.layer.v1.squashfs"
+
+// IsSIF reports whether ref is the path of a SIF file, as built by
+// Apptainer and Singularity, which Open reads instead of an image of the
+// Docker daemon or of a registry
+func IsSIF(ref string) bool {
+	f, err := os.Open(ref)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	header := make([]byte, len(sifMagic))
+	if _, err := f.ReadAt(header, sifMagicOffset); err != nil {
+		return false
+	}
+	return bytes.Equal(header, sifMagic)
+}
+
+// sifImage opens a SIF file. The image of an OCI-SIF file is read from the
+// OCI blobs it embeds, for the platform if one is given. Otherwise the
+// squashfs partition of the primary system is the only layer, whatever the
+// platform.
+func sifImage(path string, o *options) (*Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SIF file: %w", err)
+	}
+	// The file is closed with the image
+	fimg, err := sif.LoadContainer(file, sif.OptLoadWithCloseOnUnload(false))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to load SIF file: %w", err)
+	}
+
+	var img v1.Image
+	if _, err := fimg.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex)); err == nil {
+		debug("Opening the OCI image of the SIF file")
+		img, err = ociSIFImage(fimg, file, o.platform)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	} else {
+		debug("Opening the system partition of the SIF file")
+		img, err = partitionImage(fimg, file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	image, err := createImageFromV1(img, path)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	o.progress(Progress{Stage: StageDone})
+	return image, nil
+}
+
+// partitionImage returns an image of the squashfs partition of the primary
+// system. The partition has no diff ID of its own, so it is identified by
+// its digest.
+func partitionImage(fimg *sif.FileImage, file *os.File) (*archiveImage, error) {
+	d, err := fimg.GetDescriptor(sif.WithPartitionType(sif.PartPrimSys))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the system partition: %w", err)
+	}
+	fsType, _, arch, err := d.PartitionMetadata()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the system partition: %w", err)
+	}
+	if fsType != sif.FsSquash {
+		return nil, fmt.Errorf("unsupported %s system partition", fsType)
+	}
+
+	section := io.NewSectionReader(file, d.Offset(), d.Size())
+	diffID, _, err := v1.SHA256(section)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the system partition: %w", err)
+	}
+	rawConfig, err := json.Marshal(v1.ConfigFile{
+		Architecture: arch,
+		OS:           "linux",
+		Created:      v1.Time{Time: d.CreatedAt()},
+		RootFS:       v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{diffID}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	blob := &squashfsBlob{r: section, diffID: diffID}
+	layer, err := partial.UncompressedToLayer(blob)
+	if err != nil {
+		return nil, err
+	}
+	core := &archiveImageCore{
+		rawConfig: rawConfig,
+		blobs:     map[v1.Hash]partial.UncompressedLayer{diffID: blob},
+	}
+	img := &archiveImage{
+		file:   file,
+		layers: []v1.Layer{&archiveLayer{Layer: layer, size: d.Size()}},
+	}
+	if img.Image, err = partial.UncompressedToImage(core); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// squashfsBlob is a squashfs file system read as a layer
+type squashfsBlob struct {
+	r      io.ReaderAt
+	diffID v1.Hash
+}
+
+func (b *squashfsBlob) DiffID() (v1.Hash, error) {
+	return b.diffID, nil
+}
+
+func (b *squashfsBlob) Uncompressed() (io.ReadCloser, error) {
+	return squashfsTar(b.r)
+}
+
+func (b *squashfsBlob) MediaType() (types.MediaType, error) {
+	return types.DockerLayer, nil
+}
+
+// squashfsTar streams the files of a squashfs file system as a tar archive.
+// Closing the stream stops the conversion.
+func squashfsTar(r io.ReaderAt) (io.ReadCloser, error) {
+	fsys, err := squashfs.Open(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open squashfs: %w", err)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(fsys.WriteTar(pw))
+	}()
+	return pr, nil
+}
+
+// ociImage is the image of an OCI-SIF file, whose blobs are data objects of
+// the file. Squashfs layers are read as tar archives.
+type ociImage struct {
+	v1.Image
+	core *sifImageCore
+	file *os.File
+}
+
+// ociSIFImage returns the image of the root index of the OCI-SIF file
+func ociSIFImage(fimg *sif.FileImage, file *os.File, platform *v1.Platform) (*ociImage, error) {
+	d, err := fimg.GetDescriptor(sif.WithDataType(sif.DataOCIRootIndex))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the root index: %w", err)
+	}
+	rawIndex, err := d.GetData()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the root index: %w", err)
+	}
+	desc, err := selectManifest(fimg, rawIndex, platform)
+	if err != nil {
+		return nil, err
+	}
+
+	core := &sifImageCore{fimg: fimg, mediaType: desc.MediaType}
+	if core.rawManifest, err = readSIFBlob(fimg, desc.Digest); err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	if core.manifest, err = v1.ParseManifest(bytes.NewReader(core.rawManifest)); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if core.rawConfig, err = readSIFBlob(fimg, core.manifest.Config.Digest); err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	img := &ociImage{core: core, file: file}
+	if img.Image, err = partial.CompressedToImage(core); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
+
+// selectManifest returns the descriptor of the first image of the index for
+// the platform, looking into the nested indexes
+func selectManifest(fimg *sif.FileImage, rawIndex []byte, platform *v1.Platform) (v1.Descriptor, error) {
+	index, err := v1.ParseIndexManifest(bytes.NewReader(rawIndex))
+	if err != nil {
+		return v1.Descriptor{}, fmt.Errorf("failed to parse index: %w", err)
+	}
+	for _, d := range index.Manifests {
+		switch {
+		case d.MediaType.IsIndex():
+			raw, err := readSIFBlob(fimg, d.Digest)
+			if err != nil {
+				return v1.Descriptor{}, fmt.Errorf("failed to read index: %w", err)
+			}
+			if desc, err := selectManifest(fimg, raw, platform); err == nil {
+				return desc, nil
+			}
+		case !d.MediaType.IsImage():
+		case platform == nil || d.Platform == nil || d.Platform.Satisfies(*platform):
+			return d, nil
+		}
+	}
+	if platform != nil {
+		return v1.Descriptor{}, &kindError{kind: ErrNotFound, err: fmt.Errorf("no image for %s in the SIF file", platform)}
+	}
+	return v1.Descriptor{}, &kindError{kind: ErrNotFound, err: errors.New("no image in the SIF file")}
+}
+
+// readSIFBlob reads a whole OCI blob of the file
+func readSIFBlob(fimg *sif.FileImage, digest v1.Hash) ([]byte, error) {
+	d, err := fimg.GetDescriptor(sif.WithOCIBlobDigest(digest))
+	if err != nil {
+		return nil, fmt.Errorf("blob %s not found: %w", digest, err)
+	}
+	return d.GetData()
+}
+
+// LayerByDigest returns the layer of the digest, reading squashfs layers as
+// tar archives
+func (i *ociImage) LayerByDigest(h v1.Hash) (v1.Layer, error) {
+	layer, err := i.Image.LayerByDigest(h)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType, err := layer.MediaType(); err != nil || mediaType != sifSquashfsLayer {
+		return layer, nil
+	}
+	d, err := i.core.fimg.GetDescriptor(sif.WithOCIBlobDigest(h))
+	if err != nil {
+		return nil, fmt.Errorf("blob %s not found: %w", h, err)
+	}
+	return &squashfsLayer{Layer: layer, r: io.NewSectionReader(i.file, d.Offset(), d.Size())}, nil
+}
+
+// Layers returns the layers of the image, reading squashfs layers as tar
+// archives
+func (i *ociImage) Layers() ([]v1.Layer, error) {
+	var layers []v1.Layer
+	for _, desc := range i.core.manifest.Layers {
+		layer, err := i.LayerByDigest(desc.Digest)
+		if err != nil {
+			return nil, err
+		}
+		layers = append(layers, layer)
+	}
+	return layers, nil
+}
+
+// Close closes the SIF file
+func (i *ociImage) Close() error {
+	return i.file.Close()
+}
+
+// squashfsLayer is a squashfs layer of an OCI-SIF file, whose content is
+// read as a tar archive
+type squashfsLayer struct {
+	v1.Layer
+	r io.ReaderAt
+}
+
+func (l *squashfsLayer) Uncompressed() (io.ReadCloser, error) {
+	return squashfsTar(l.r)
+}
+
+// sifImageCore provides the minimal methods for partial.CompressedToImage
+type sifImageCore struct {
+	fimg        *sif.FileImage
+	mediaType   types.MediaType
+	rawManifest []byte
+	manifest    *v1.Manifest
+	rawConfig   []byte
+}
+
+func (c *sifImageCore) RawConfigFile() ([]byte, error) {
+	return c.rawConfig, nil
+}
+
+func (c *sifImageCore) MediaType() (types.MediaType, error) {
+	return c.mediaType, nil
+}
+
+func (c *sifImageCore) RawManifest() ([]byte, error) {
+	return c.rawManifest, nil
+}
+
+func (c *sifImageCore) LayerByDigest(h v1.Hash) (partial.CompressedLayer, error) {
+	d, err := c.fimg.GetDescriptor(sif.WithOCIBlobDigest(h))
+	if err != nil {
+		return nil, fmt.Errorf("blob %s not found: %w", h, err)
+	}
+	mediaType := types.OCILayer
+	for _, l := range c.manifest.Layers {
+		if l.Digest == h {
+			mediaType = l.MediaType
+		}
+	}
+	return &sifBlob{descriptor: d, digest: h, mediaType: mediaType}, nil
+}
+
+// sifBlob is an OCI blob of an OCI-SIF file
+type sifBlob struct {
+	descriptor sif.Descriptor
+	digest     v1.Hash
+	mediaType  types.MediaType
+}
+
+func (b *sifBlob) Digest() (v1.Hash, error) {
+	return b.digest, nil
+}
+
+func (b *sifBlob) Compressed() (io.ReadCloser, error) {
+	return io.NopCloser(b.descriptor.GetReader()), nil
+}
+
+func (b *sifBlob) Size() (int64, error) {
+	return b.descriptor.Size(), nil
+}
+
+func (b *sifBlob) MediaType() (types.MediaType, error) {
+	return b.mediaType, nil
+}
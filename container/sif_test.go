@@ -0,0 +1,295 @@
+package container
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/google/go-containerregistry/pkg/v1/empty"
+	"github.com/google/go-containerregistry/pkg/v1/mutate"
+	"github.com/google/go-containerregistry/pkg/v1/types"
+	"github.com/sylabs/sif/v2/pkg/sif"
+)
+
+// readTestSquashfs reads a squashfs image with /etc/hostname and a /bin/sh
+// link
+func readTestSquashfs(t *testing.T) []byte {
+	t.Helper()
+	squash, err := os.ReadFile(filepath.Join("testdata", "rootfs.squashfs"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return squash
+}
+
+// writeSIF writes a SIF file with the data objects
+func writeSIF(t *testing.T, path string, inputs ...sif.DescriptorInput) {
+	t.Helper()
+	f, err := sif.CreateContainerAtPath(path, sif.OptCreateWithDescriptors(inputs...), sif.OptCreateDeterministic())
+	if err != nil {
+		t.Fatalf("Failed to create SIF file: %v", err)
+	}
+	if err := f.UnloadContainer(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func descriptorInput(t *testing.T, dataType sif.DataType, data []byte, opts ...sif.DescriptorInputOpt) sif.DescriptorInput {
+	t.Helper()
+	di, err := sif.NewDescriptorInput(dataType, bytes.NewReader(data), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return di
+}
+
+// ociSIFInputs returns the blobs of the image and a root index listing it
+// for the platform
+func ociSIFInputs(t *testing.T, manifest, config []byte, layers [][]byte, platform v1.Platform) []sif.DescriptorInput {
+	t.Helper()
+	digest, size, err := v1.SHA256(bytes.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := json.Marshal(v1.IndexManifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIImageIndex,
+		Manifests: []v1.Descriptor{
+			{MediaType: types.OCIManifestSchema1, Digest: digest, Size: size, Platform: &platform},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inputs := []sif.DescriptorInput{
+		descriptorInput(t, sif.DataOCIRootIndex, index),
+		descriptorInput(t, sif.DataOCIBlob, manifest),
+		descriptorInput(t, sif.DataOCIBlob, config),
+	}
+	for _, layer := range layers {
+		inputs = append(inputs, descriptorInput(t, sif.DataOCIBlob, layer))
+	}
+	return inputs
+}
+
+// tarOCISIFInputs returns the data objects of an OCI-SIF file of an image
+// with a tar layer
+func tarOCISIFInputs(t *testing.T, platform v1.Platform) []sif.DescriptorInput {
+	t.Helper()
+	layer, err := createTestLayer(t)
+	if err != nil {
+		t.Fatalf("Failed to create test layer: %v", err)
+	}
+	img, err := mutate.AppendLayers(empty.Image, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile, err := img.ConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	configFile = configFile.DeepCopy()
+	configFile.OS, configFile.Architecture = platform.OS, platform.Architecture
+	if img, err = mutate.ConfigFile(img, configFile); err != nil {
+		t.Fatal(err)
+	}
+	img = mutate.MediaType(img, types.OCIManifestSchema1)
+	manifest, err := img.RawManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := img.RawConfigFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, err := layer.Compressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	blob, err := io.ReadAll(rc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ociSIFInputs(t, manifest, config, [][]byte{blob}, platform)
+}
+
+// squashfsOCISIFInputs returns the data objects of an OCI-SIF file of an
+// image with a squashfs layer, as SingularityCE builds them
+func squashfsOCISIFInputs(t *testing.T, squash []byte) []sif.DescriptorInput {
+	t.Helper()
+	digest, size, err := v1.SHA256(bytes.NewReader(squash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(v1.ConfigFile{
+		Architecture: "amd64",
+		OS:           "linux",
+		RootFS:       v1.RootFS{Type: "layers", DiffIDs: []v1.Hash{digest}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDigest, configSize, err := v1.SHA256(bytes.NewReader(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest, err := json.Marshal(v1.Manifest{
+		SchemaVersion: 2,
+		MediaType:     types.OCIManifestSchema1,
+		Config:        v1.Descriptor{MediaType: types.OCIConfigJSON, Digest: configDigest, Size: configSize},
+		Layers:        []v1.Descriptor{{MediaType: sifSquashfsLayer, Digest: digest, Size: size}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ociSIFInputs(t, manifest, config, [][]byte{squash}, v1.Platform{OS: "linux", Architecture: "amd64"})
+}
+
+func TestIsSIF(t *testing.T) {
+	dir := t.TempDir()
+	sifPath := filepath.Join(dir, "image.sif")
+	writeSIF(t, sifPath, descriptorInput(t, sif.DataGeneric, []byte("data")))
+	squashfsPath := filepath.Join(dir, "rootfs.squashfs")
+	if err := os.WriteFile(squashfsPath, readTestSquashfs(t), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ref  string
+		want bool
+	}{
+		{ref: sifPath, want: true},
+		{ref: squashfsPath, want: false},
+		{ref: filepath.Join(dir, "missing.sif"), want: false},
+		{ref: "alpine:latest", want: false},
+	}
+	for _, tt := range tests {
+		if got := IsSIF(tt.ref); got != tt.want {
+			t.Errorf("IsSIF(%q) = %v, want %v", tt.ref, got, tt.want)
+		}
+	}
+}
+
+func TestSIFImage(t *testing.T) {
+	squash := readTestSquashfs(t)
+	amd64 := v1.Platform{OS: "linux", Architecture: "amd64"}
+
+	tests := []struct {
+		name    string
+		inputs  []sif.DescriptorInput
+		file    string
+		content string
+	}{
+		{
+			name: "system partition",
+			inputs: []sif.DescriptorInput{
+				descriptorInput(t, sif.DataDeffile, []byte("Bootstrap: docker\nFrom: alpine\n")),
+				descriptorInput(t, sif.DataPartition, squash, sif.OptPartitionMetadata(sif.FsSquash, sif.PartPrimSys, "amd64")),
+			},
+			file:    "etc/hostname",
+			content: "sif\n",
+		},
+		{
+			name:    "OCI-SIF with a tar layer",
+			inputs:  tarOCISIFInputs(t, amd64),
+			file:    "testdir/file.txt",
+			content: "directory test content",
+		},
+		{
+			name:    "OCI-SIF with a squashfs layer",
+			inputs:  squashfsOCISIFInputs(t, squash),
+			file:    "etc/hostname",
+			content: "sif\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "image.sif")
+			writeSIF(t, path, tt.inputs...)
+
+			image, err := Open(context.Background(), path, WithCacheDir(t.TempDir()))
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			defer image.Close()
+			if image.Reference != path || image.Local {
+				t.Errorf("Open() = %q, local %v, want %q, not local", image.Reference, image.Local, path)
+			}
+			if len(image.Layers) != 1 {
+				t.Fatalf("Expected 1 layer, got %d", len(image.Layers))
+			}
+
+			l := &image.Layers[0]
+			if l.Size <= 0 {
+				t.Errorf("Expected positive layer size, got %d", l.Size)
+			}
+			if err := l.InitializeLayer(context.Background(), mockProgressFunc); err != nil {
+				t.Fatalf("InitializeLayer() error = %v", err)
+			}
+			content, err := l.ReadFile(context.Background(), tt.file)
+			if err != nil {
+				t.Fatalf("ReadFile() error = %v", err)
+			}
+			if string(content) != tt.content {
+				t.Errorf("ReadFile() = %q, want %q", content, tt.content)
+			}
+
+			configFile, err := image.img.ConfigFile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if configFile.Architecture != "amd64" {
+				t.Errorf("Architecture = %q, want amd64", configFile.Architecture)
+			}
+		})
+	}
+}
+
+func TestSIFImageErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		inputs []sif.DescriptorInput
+		opts   []Option
+		want   error
+	}{
+		{
+			name:   "no platform",
+			inputs: tarOCISIFInputs(t, v1.Platform{OS: "linux", Architecture: "amd64"}),
+			opts:   []Option{WithPlatform(v1.Platform{OS: "linux", Architecture: "arm64"})},
+			want:   ErrNotFound,
+		},
+		{
+			name:   "no system partition",
+			inputs: []sif.DescriptorInput{descriptorInput(t, sif.DataGeneric, []byte("data"))},
+		},
+		{
+			name: "ext3 partition",
+			inputs: []sif.DescriptorInput{
+				descriptorInput(t, sif.DataPartition, []byte("ext3"), sif.OptPartitionMetadata(sif.FsExt3, sif.PartPrimSys, "amd64")),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "image.sif")
+			writeSIF(t, path, tt.inputs...)
+
+			_, err := Open(context.Background(), path, tt.opts...)
+			if err == nil {
+				t.Fatal("Open() succeeded, want an error")
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("Open() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
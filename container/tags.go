@@ -0,0 +1,131 @@
+package container
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+// ListTags returns the tags of the repository in the registry, all of the
+// pages of them, sorted by SortTags
+func ListTags(ctx context.Context, repo string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	repository, err := name.NewRepository(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repository: %w", err)
+	}
+	var tags []string
+	err = o.retryPolicy.do(ctx, func() error {
+		tags, err = remote.List(repository, append(o.remoteOptions(), remote.WithContext(ctx))...)
+		if err != nil {
+			return fmt.Errorf("failed to list tags: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classifyError(err)
+	}
+	SortTags(tags)
+	return tags, nil
+}
+
+// ListRepositories returns the repositories of the registry, all of the
+// pages of them in alphabetical order, from the catalog API that not all of
+// the registries support. A path after the registry, like "org" of
+// "ghcr.io/org", keeps the repositories under it.
+func ListRepositories(ctx context.Context, registry string, opts ...Option) ([]string, error) {
+	o := newOptions(opts)
+	host, prefix, _ := strings.Cut(strings.TrimSuffix(registry, "/"), "/")
+	reg, err := name.NewRegistry(host)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse registry: %w", err)
+	}
+	var repos []string
+	err = o.retryPolicy.do(ctx, func() error {
+		repos, err = remote.Catalog(ctx, reg, o.remoteOptions()...)
+		if err != nil {
+			return fmt.Errorf("failed to list repositories: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, classifyError(err)
+	}
+	if prefix != "" {
+		repos = slices.DeleteFunc(repos, func(r string) bool {
+			return !strings.HasPrefix(r, prefix+"/")
+		})
+	}
+	slices.Sort(repos)
+	return repos, nil
+}
+
+// SortTags sorts the tags that are versions, like "1.27", "v2.0.1" or
+// "3.20-alpine", from the newest to the oldest, followed by the other tags
+// in alphabetical order. A version with a suffix comes after the version
+// without it.
+func SortTags(tags []string) {
+	slices.SortStableFunc(tags, func(a, b string) int {
+		va, oka := parseVersion(a)
+		vb, okb := parseVersion(b)
+		switch {
+		case oka && okb:
+			if c := slices.Compare(vb.numbers, va.numbers); c != 0 {
+				return c
+			}
+			if (va.suffix == "") != (vb.suffix == "") {
+				// A release comes before its suffixed variants
+				if va.suffix == "" {
+					return -1
+				}
+				return 1
+			}
+			return cmp.Compare(va.suffix, vb.suffix)
+		case oka:
+			return -1
+		case okb:
+			return 1
+		}
+		return strings.Compare(a, b)
+	})
+}
+
+type version struct {
+	numbers []int
+	suffix  string
+}
+
+// parseVersion parses a tag like "v1.2.3-rc1" into its numbers and suffix
+func parseVersion(tag string) (version, bool) {
+	s := strings.TrimPrefix(tag, "v")
+	s, suffix, _ := strings.Cut(s, "-")
+	var v version
+	for _, part := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return version{}, false
+		}
+		v.numbers = append(v.numbers, n)
+	}
+	// Versions with fewer numbers come first, as "1.27" is newer than any
+	// "1.27.x" it points to
+	v.numbers = append(v.numbers, maxVersionNumber)
+	v.suffix = suffix
+	return v, true
+}
+
+// maxVersionNumber ends the numbers of a version, so that a version sorts
+// after the versions it is a prefix of
+const maxVersionNumber = int(^uint(0) >> 1)
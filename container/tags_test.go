@@ -0,0 +1,85 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+)
+
+func TestSortTags(t *testing.T) {
+	tags := []string{"latest", "1.9", "v1.10.0", "1.10", "1.10-alpine", "1.10.0-rc1", "main", "1", "2.0.0", "sha-abc123"}
+	SortTags(tags)
+	want := []string{"2.0.0", "1", "1.10", "1.10-alpine", "v1.10.0", "1.10.0-rc1", "1.9", "latest", "main", "sha-abc123"}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("SortTags() = %v, want %v", tags, want)
+	}
+}
+
+func TestListTags(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	repo := fmt.Sprintf("%s/test/app", registryHost)
+	for _, tag := range []string{"latest", "1.0", "1.1"} {
+		ref, err := name.ParseReference(repo + ":" + tag)
+		if err != nil {
+			t.Fatalf("Failed to parse reference: %v", err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatalf("Failed to push image: %v", err)
+		}
+	}
+
+	got, err := ListTags(context.Background(), repo)
+	if err != nil {
+		t.Fatalf("ListTags() error = %v", err)
+	}
+	if want := []string{"1.1", "1.0", "latest"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ListTags() = %v, want %v", got, want)
+	}
+}
+
+func TestListRepositories(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+	img, err := random.Image(1024, 1)
+	if err != nil {
+		t.Fatalf("Failed to create image: %v", err)
+	}
+	for _, repo := range []string{"org/web", "org/api", "other/app"} {
+		ref, err := name.ParseReference(fmt.Sprintf("%s/%s:latest", registryHost, repo))
+		if err != nil {
+			t.Fatalf("Failed to parse reference: %v", err)
+		}
+		if err := remote.Write(ref, img); err != nil {
+			t.Fatalf("Failed to push image: %v", err)
+		}
+	}
+
+	tests := []struct {
+		registry string
+		want     []string
+	}{
+		{registryHost, []string{"org/api", "org/web", "other/app"}},
+		{registryHost + "/org", []string{"org/api", "org/web"}},
+		{registryHost + "/org/", []string{"org/api", "org/web"}},
+		{registryHost + "/missing", nil},
+	}
+	for _, tt := range tests {
+		got, err := ListRepositories(context.Background(), tt.registry)
+		if err != nil {
+			t.Fatalf("ListRepositories(%q) error = %v", tt.registry, err)
+		}
+		if len(got) != 0 || len(tt.want) != 0 {
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ListRepositories(%q) = %v, want %v", tt.registry, got, tt.want)
+			}
+		}
+	}
+}
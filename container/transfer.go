@@ -0,0 +1,66 @@
+package container
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// transfer tracks the compressed bytes fetched for a remote layer
+type transfer struct {
+	complete atomic.Int64
+	total    atomic.Int64
+}
+
+func (t *transfer) start(total int64) {
+	t.complete.Store(0)
+	t.total.Store(total)
+}
+
+func (t *transfer) add(n int64) {
+	t.complete.Add(n)
+}
+
+// Transferred returns the number of compressed bytes fetched so far and the
+// compressed size of the layer. ok is false if the layer is not fetched from a
+// registry, in which case there is no download to report.
+func (l *Layer) Transferred() (complete, total int64, ok bool) {
+	if l.transfer == nil {
+		return 0, 0, false
+	}
+	return l.transfer.complete.Load(), l.transfer.total.Load(), true
+}
+
+// decompress returns a reader of the uncompressed content of r, detecting
+// gzip and zstd streams by their magic bytes
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read compression header: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(header, zstdMagic):
+		dec, err := zstd.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	default:
+		// Uncompressed layer
+		return io.NopCloser(br), nil
+	}
+}
@@ -0,0 +1,105 @@
+package container
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+)
+
+// Usage is the disk usage of a file or directory of a layer
+type Usage struct {
+	Name string
+	// Size is the size of the file, or the total size of the files under
+	// the directory
+	Size int64
+	Dir  bool
+	// Files is the number of files under the directory, itself excluded
+	Files int
+	// Children are the files of the directory, the largest first
+	Children []*Usage
+}
+
+// DiskUsage returns the disk usage of the files of the initialized layer.
+// Hard links and symbolic links take no space, so that files linked to are
+// counted once. For layers stacked by MergeLayers, it is the usage of the
+// filesystem a container of the image sees.
+func (l *Layer) DiskUsage(ctx context.Context) (*Usage, error) {
+	fsys := l.files()
+	if fsys == nil {
+		return nil, fmt.Errorf("layer not initialized")
+	}
+
+	root := &Usage{Name: "/", Dir: true}
+	dirs := map[string]*Usage{".": root}
+	err := fs.WalkDir(fsys, ".", func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == "." {
+			return nil
+		}
+		u := &Usage{Name: d.Name(), Dir: d.IsDir()}
+		if !d.IsDir() && d.Type()&fs.ModeSymlink == 0 {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			u.Size = info.Size()
+		}
+		if d.IsDir() {
+			dirs[p] = u
+		}
+		parent := dirs[path.Dir(p)]
+		parent.Children = append(parent.Children, u)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to compute the disk usage: %w", err)
+	}
+	root.total()
+	return root, nil
+}
+
+// total sums up the sizes and files under the directory and sorts its
+// files by size
+func (u *Usage) total() {
+	for _, c := range u.Children {
+		if c.Dir {
+			c.total()
+			u.Files += c.Files
+		}
+		u.Size += c.Size
+		u.Files++
+	}
+	slices.SortStableFunc(u.Children, func(a, b *Usage) int {
+		if c := cmp.Compare(b.Size, a.Size); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+}
+
+// Find returns the usage of the directory at the slash-separated path
+// relative to u, or nil if there is none
+func (u *Usage) Find(p string) *Usage {
+	if p == "." || p == "" {
+		return u
+	}
+	dir, name := path.Split(p)
+	parent := u.Find(path.Clean(dir))
+	if parent == nil {
+		return nil
+	}
+	for _, c := range parent.Children {
+		if c.Name == name && c.Dir {
+			return c
+		}
+	}
+	return nil
+}
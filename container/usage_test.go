@@ -0,0 +1,91 @@
+package container
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestDiskUsage(t *testing.T) {
+	upper := createMergeLayer(t,
+		testEntry{name: "usr/", dir: true},
+		testEntry{name: "usr/bin/", dir: true},
+		testEntry{name: "usr/bin/app", content: "0123456789"},
+		testEntry{name: "usr/bin/app-link", hardlink: "usr/bin/app"},
+		testEntry{name: "usr/bin/sh", link: "app"},
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/.wh.motd"},
+	)
+	lower := createMergeLayer(t,
+		testEntry{name: "etc/", dir: true},
+		testEntry{name: "etc/motd", content: "welcome to the image"},
+		testEntry{name: "etc/hostname", content: "base"},
+		testEntry{name: "usr/", dir: true},
+		testEntry{name: "usr/lib/", dir: true},
+		testEntry{name: "usr/lib/libc.so", content: "library"},
+	)
+
+	merged := MergeLayers(*upper, *lower)
+	if _, err := merged.DiskUsage(context.Background()); err == nil {
+		t.Error("Expected an error before the layer is initialized")
+	}
+	if err := merged.InitializeLayer(context.Background(), nil); err != nil {
+		t.Fatalf("InitializeLayer() error = %v", err)
+	}
+	got, err := merged.DiskUsage(context.Background())
+	if err != nil {
+		t.Fatalf("DiskUsage() error = %v", err)
+	}
+
+	want := &Usage{Name: "/", Size: 21, Dir: true, Files: 9, Children: []*Usage{
+		{Name: "usr", Size: 17, Dir: true, Files: 6, Children: []*Usage{
+			{Name: "bin", Size: 10, Dir: true, Files: 3, Children: []*Usage{
+				{Name: "app", Size: 10},
+				{Name: "app-link"},
+				{Name: "sh"},
+			}},
+			{Name: "lib", Size: 7, Dir: true, Files: 1, Children: []*Usage{
+				{Name: "libc.so", Size: 7},
+			}},
+		}},
+		{Name: "etc", Size: 4, Dir: true, Files: 1, Children: []*Usage{
+			{Name: "hostname", Size: 4},
+		}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiskUsage() = %s, want %s", formatUsage(got), formatUsage(want))
+	}
+
+	if u := got.Find("usr/lib"); u == nil || u.Name != "lib" {
+		t.Errorf("Find(usr/lib) = %v, want lib", u)
+	}
+	for _, p := range []string{"usr/bin/app", "opt", "usr/share"} {
+		if u := got.Find(p); u != nil {
+			t.Errorf("Find(%s) = %v, want nil", p, u)
+		}
+	}
+	if u := got.Find("."); u != got {
+		t.Errorf("Find(.) = %v, want the root", u)
+	}
+}
+
+// formatUsage describes a usage tree in a line for the test failures
+func formatUsage(u *Usage) string {
+	s := u.Name
+	if u.Dir {
+		s += "/"
+	}
+	s += fmt.Sprintf("(%d)", u.Size)
+	if len(u.Children) > 0 {
+		s += "["
+		for i, c := range u.Children {
+			if i > 0 {
+				s += " "
+			}
+			s += formatUsage(c)
+		}
+		s += "]"
+	}
+	return s
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// maxCrashMessages is how many of the last messages a crash report lists
+const maxCrashMessages = 20
+
+// crash is a panic recovered from the model or its commands
+type crash struct {
+	value any
+	stack []byte
+}
+
+type crashMsg struct {
+	crash crash
+}
+
+// crashGuard wraps a model to recover from its panics and those of its
+// commands. The program quits on a panic so that the terminal is restored
+// before the crash is reported.
+type crashGuard struct {
+	model    tea.Model
+	ref      string
+	quit     func()
+	messages []string
+	crash    *crash
+}
+
+func newCrashGuard(model tea.Model, ref string) *crashGuard {
+	return &crashGuard{model: model, ref: ref}
+}
+
+func (g *crashGuard) Init() (cmd tea.Cmd) {
+	defer g.recover(func() { cmd = tea.Quit })
+	return g.guard(g.model.Init())
+}
+
+func (g *crashGuard) Update(msg tea.Msg) (_ tea.Model, cmd tea.Cmd) {
+	if msg, ok := msg.(crashMsg); ok {
+		g.crashed(msg.crash)
+		return g, tea.Quit
+	}
+	if g.crash != nil {
+		return g, nil
+	}
+	g.record(msg)
+	defer g.recover(func() { cmd = tea.Quit })
+	var model tea.Model
+	model, cmd = g.model.Update(msg)
+	g.model = model
+	return g, g.guard(cmd)
+}
+
+func (g *crashGuard) View() (view string) {
+	if g.crash != nil {
+		return ""
+	}
+	defer g.recover(func() {
+		view = ""
+		// The program can't be sent a message while it renders
+		if g.quit != nil {
+			go g.quit()
+		}
+	})
+	return g.model.View()
+}
+
+// guard recovers from the panics of a command, and of the commands of a
+// batch it returns
+func (g *crashGuard) guard(cmd tea.Cmd) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	return func() (msg tea.Msg) {
+		defer func() {
+			if r := recover(); r != nil {
+				msg = crashMsg{crash: crash{value: r, stack: debug.Stack()}}
+			}
+		}()
+		msg = cmd()
+		if batch, ok := msg.(tea.BatchMsg); ok {
+			guarded := make(tea.BatchMsg, len(batch))
+			for i, c := range batch {
+				guarded[i] = g.guard(c)
+			}
+			return guarded
+		}
+		return msg
+	}
+}
+
+// recover records a panic of the model and calls then
+func (g *crashGuard) recover(then func()) {
+	if r := recover(); r != nil {
+		g.crashed(crash{value: r, stack: debug.Stack()})
+		then()
+	}
+}
+
+// crashed records the first crash
+func (g *crashGuard) crashed(c crash) {
+	if g.crash == nil {
+		g.crash = &c
+	}
+}
+
+// record keeps the type of the message, and the key of a key press
+func (g *crashGuard) record(msg tea.Msg) {
+	entry := fmt.Sprintf("%T", msg)
+	if key, ok := msg.(tea.KeyMsg); ok {
+		entry += " " + key.String()
+	}
+	g.messages = append(g.messages, entry)
+	if len(g.messages) > maxCrashMessages {
+		g.messages = g.messages[1:]
+	}
+}
+
+// report returns the crash report
+func (g *crashGuard) report() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sou crashed at %s\n\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&b, "Version: %s\n", version)
+	fmt.Fprintf(&b, "Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+	fmt.Fprintf(&b, "Image: %s\n\n", g.ref)
+	fmt.Fprintf(&b, "Panic: %v\n\n%s\n", g.crash.value, g.crash.stack)
+	b.WriteString("Last messages:\n")
+	for _, m := range g.messages {
+		fmt.Fprintf(&b, "  %s\n", m)
+	}
+	return b.String()
+}
+
+// writeCrashReport writes the report to the sou directory of the user cache
+// directory and returns its path
+func writeCrashReport(report string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	dir = filepath.Join(dir, "sou")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create crash report directory: %w", err)
+	}
+	path := filepath.Join(dir, fmt.Sprintf("crash-%s.txt", time.Now().Format("20060102-150405")))
+	if err := os.WriteFile(path, []byte(report), 0o600); err != nil {
+		return "", fmt.Errorf("failed to write crash report: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// panicModel is a model panicking on the messages or views it is told to
+type panicModel struct {
+	panicOn string
+	view    bool
+	cmd     tea.Cmd
+}
+
+func (m *panicModel) Init() tea.Cmd { return nil }
+
+func (m *panicModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	if msg, ok := msg.(tea.KeyMsg); ok && msg.String() == m.panicOn {
+		panic("update " + m.panicOn)
+	}
+	return m, m.cmd
+}
+
+func (m *panicModel) View() string {
+	if m.view {
+		panic("view")
+	}
+	return "view"
+}
+
+// isQuit reports whether the command quits the program
+func isQuit(cmd tea.Cmd) bool {
+	if cmd == nil {
+		return false
+	}
+	_, ok := cmd().(tea.QuitMsg)
+	return ok
+}
+
+func keyMsg(s string) tea.KeyMsg {
+	return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+func TestCrashGuardUpdate(t *testing.T) {
+	g := newCrashGuard(&panicModel{panicOn: "x"}, "alpine:3.20")
+	if _, cmd := g.Update(keyMsg("a")); cmd != nil || g.crash != nil {
+		t.Fatalf("Update(a) = %v, crash %v, want no command and no crash", cmd, g.crash)
+	}
+	_, cmd := g.Update(keyMsg("x"))
+	if g.crash == nil || g.crash.value != "update x" {
+		t.Fatalf("crash = %v, want update x", g.crash)
+	}
+	if !isQuit(cmd) {
+		t.Error("Update(x) doesn't quit")
+	}
+
+	// Nothing reaches the model after a crash, nor is its view rendered
+	if _, cmd := g.Update(keyMsg("x")); cmd != nil {
+		t.Error("Update() after a crash returns a command")
+	}
+	if view := g.View(); view != "" {
+		t.Errorf("View() after a crash = %q, want empty", view)
+	}
+}
+
+func TestCrashGuardView(t *testing.T) {
+	quit := make(chan struct{})
+	g := newCrashGuard(&panicModel{view: true}, "alpine:3.20")
+	g.quit = func() { close(quit) }
+	if view := g.View(); view != "" {
+		t.Errorf("View() = %q, want empty", view)
+	}
+	if g.crash == nil || g.crash.value != "view" {
+		t.Fatalf("crash = %v, want view", g.crash)
+	}
+	<-quit
+}
+
+func TestCrashGuardCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  tea.Cmd
+	}{
+		{
+			name: "command",
+			cmd:  func() tea.Msg { panic("command") },
+		},
+		{
+			name: "batch",
+			cmd: tea.Batch(
+				func() tea.Msg { return nil },
+				func() tea.Msg { panic("command") },
+			),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newCrashGuard(&panicModel{cmd: tt.cmd}, "alpine:3.20")
+			_, cmd := g.Update(keyMsg("a"))
+			if cmd == nil {
+				t.Fatal("Update() returns no command")
+			}
+
+			// The commands of a batch are guarded when it is run
+			msgs := []tea.Msg{cmd()}
+			if batch, ok := msgs[0].(tea.BatchMsg); ok {
+				msgs = nil
+				for _, c := range batch {
+					msgs = append(msgs, c())
+				}
+			}
+			var crashed *crashMsg
+			for _, msg := range msgs {
+				if msg, ok := msg.(crashMsg); ok {
+					crashed = &msg
+				}
+			}
+			if crashed == nil {
+				t.Fatalf("messages = %v, want a crash", msgs)
+			}
+
+			_, cmd = g.Update(*crashed)
+			if g.crash == nil || g.crash.value != "command" {
+				t.Fatalf("crash = %v, want command", g.crash)
+			}
+			if !isQuit(cmd) {
+				t.Error("Update(crashMsg) doesn't quit")
+			}
+		})
+	}
+}
+
+func TestCrashGuardReport(t *testing.T) {
+	g := newCrashGuard(&panicModel{panicOn: "x"}, "alpine:3.20")
+	for i := range maxCrashMessages + 5 {
+		g.Update(keyMsg(fmt.Sprint(i)))
+	}
+	g.Update(tea.WindowSizeMsg{Width: 80, Height: 24})
+	if len(g.messages) != maxCrashMessages {
+		t.Fatalf("len(messages) = %d, want %d", len(g.messages), maxCrashMessages)
+	}
+	// The oldest messages are dropped
+	if got, want := g.messages[0], "tea.KeyMsg 6"; got != want {
+		t.Errorf("messages[0] = %q, want %q", got, want)
+	}
+	if got, want := g.messages[len(g.messages)-1], "tea.WindowSizeMsg"; got != want {
+		t.Errorf("last message = %q, want %q", got, want)
+	}
+
+	g.Update(keyMsg("x"))
+	report := g.report()
+	for _, want := range []string{
+		"Version: " + version,
+		"Image: alpine:3.20\n",
+		"Panic: update x\n",
+		"Last messages:\n",
+		"  tea.KeyMsg x\n",
+		"crash_test.go",
+	} {
+		if !strings.Contains(report, want) {
+			t.Errorf("report() doesn't contain %q:\n%s", want, report)
+		}
+	}
+	if strings.Contains(report, "  tea.KeyMsg 5\n") {
+		t.Errorf("report() contains a dropped message:\n%s", report)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/knqyf263/sou/ui"
+)
+
+// envName returns the environment variable setting a flag, such as
+// SOU_LOG_LEVEL for --log-level
+func envName(flagName string) string {
+	return "SOU_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// setFlagsFromEnv sets the flags that aren't on the command line from their
+// environment variables, except the skipped ones. Flags take precedence over
+// the environment. The config file only sets the theme, which --theme and
+// SOU_THEME override (see loadTheme).
+func setFlagsFromEnv(fs *flag.FlagSet, skip ...string) error {
+	onCommandLine := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		onCommandLine[f.Name] = true
+	})
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || onCommandLine[f.Name] || slices.Contains(skip, f.Name) {
+			return
+		}
+		value := os.Getenv(envName(f.Name))
+		if value == "" {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid %s %q: %w", envName(f.Name), value, setErr)
+		}
+	})
+	return err
+}
+
+// isFlagSet reports whether the flag was set on the command line or by the
+// environment
+func isFlagSet(fs *flag.FlagSet, name string) bool {
+	set := false
+	fs.Visit(func(f *flag.Flag) {
+		set = set || f.Name == name
+	})
+	return set
+}
+
+// loadTheme returns the theme named by --theme or SOU_THEME, or else by the
+// config file at configPath, the default one if configPath is empty
+func loadTheme(configPath, themeName string) (ui.Theme, error) {
+	if configPath == "" {
+		var err error
+		if configPath, err = ui.DefaultConfigPath(); err != nil {
+			return ui.Theme{}, err
+		}
+	}
+	config, err := ui.LoadConfig(configPath)
+	if err != nil {
+		return ui.Theme{}, err
+	}
+	return config.LoadTheme(themeName)
+}
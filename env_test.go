@@ -0,0 +1,167 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/knqyf263/sou/ui"
+)
+
+func TestSetFlagsFromEnv(t *testing.T) {
+	tests := []struct {
+		name      string
+		args      []string
+		env       map[string]string
+		skip      []string
+		wantLevel string
+		wantLines int
+		wantSet   bool
+		wantErr   string
+	}{
+		{
+			name:      "no environment",
+			wantLevel: "info",
+			wantLines: 10,
+		},
+		{
+			name:      "environment",
+			env:       map[string]string{"SOU_LOG_LEVEL": "debug", "SOU_MAX_LINES": "20"},
+			wantLevel: "debug",
+			wantLines: 20,
+			wantSet:   true,
+		},
+		{
+			name:      "command line first",
+			args:      []string{"--log-level", "warn"},
+			env:       map[string]string{"SOU_LOG_LEVEL": "debug", "SOU_MAX_LINES": "20"},
+			wantLevel: "warn",
+			wantLines: 20,
+			wantSet:   true,
+		},
+		{
+			name:      "empty variable",
+			env:       map[string]string{"SOU_LOG_LEVEL": ""},
+			wantLevel: "info",
+			wantLines: 10,
+		},
+		{
+			name:      "skipped",
+			env:       map[string]string{"SOU_LOG_LEVEL": "debug", "SOU_MAX_LINES": "20"},
+			skip:      []string{"log-level"},
+			wantLevel: "info",
+			wantLines: 20,
+		},
+		{
+			name:    "invalid",
+			env:     map[string]string{"SOU_MAX_LINES": "many"},
+			wantErr: `invalid SOU_MAX_LINES "many": parse error`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+			fs := flag.NewFlagSet("sou", flag.ContinueOnError)
+			level := fs.String("log-level", "info", "")
+			lines := fs.Int("max-lines", 10, "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+
+			err := setFlagsFromEnv(fs, tt.skip...)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("setFlagsFromEnv() error = %v, want %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("setFlagsFromEnv() error = %v", err)
+			}
+			if *level != tt.wantLevel {
+				t.Errorf("log-level = %q, want %q", *level, tt.wantLevel)
+			}
+			if *lines != tt.wantLines {
+				t.Errorf("max-lines = %d, want %d", *lines, tt.wantLines)
+			}
+			if got := isFlagSet(fs, "log-level"); got != tt.wantSet {
+				t.Errorf("isFlagSet(log-level) = %v, want %v", got, tt.wantSet)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	if got, want := envName("containerd-namespace"), "SOU_CONTAINERD_NAMESPACE"; got != want {
+		t.Errorf("envName() = %q, want %q", got, want)
+	}
+}
+
+func TestLoadThemePrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	config := `{"theme": "config", "themes": {"config": {"selected": "1"}, "env": {"selected": "2"}, "flag": {"selected": "3"}}}`
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		env  string
+		want string
+	}{
+		{
+			name: "config file",
+			want: "1",
+		},
+		{
+			name: "environment over config file",
+			env:  "env",
+			want: "2",
+		},
+		{
+			name: "command line over environment",
+			args: []string{"--theme", "flag"},
+			env:  "env",
+			want: "3",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SOU_THEME", tt.env)
+			fs := flag.NewFlagSet("sou", flag.ContinueOnError)
+			themeName := fs.String("theme", "", "")
+			if err := fs.Parse(tt.args); err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if err := setFlagsFromEnv(fs); err != nil {
+				t.Fatalf("setFlagsFromEnv() error = %v", err)
+			}
+
+			theme, err := loadTheme(path, *themeName)
+			if err != nil {
+				t.Fatalf("loadTheme() error = %v", err)
+			}
+			if theme.Selected != tt.want {
+				t.Errorf("Selected = %q, want %q", theme.Selected, tt.want)
+			}
+		})
+	}
+
+	// Without a theme anywhere, the default one is used
+	theme, err := loadTheme(filepath.Join(t.TempDir(), "missing.json"), "")
+	if err != nil {
+		t.Fatalf("loadTheme() error = %v", err)
+	}
+	want, err := ui.Config{}.LoadTheme(ui.DefaultTheme)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(theme, want) {
+		t.Errorf("loadTheme() = %+v, want the default theme", theme)
+	}
+}
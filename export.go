@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui"
+)
+
+const exportUsage = "usage: sou [flags] export --squash <image-name> -o <path>|-"
+
+// exportArgs are the arguments of sou export
+type exportArgs struct {
+	image  string
+	squash bool
+	output string // - for the standard output
+}
+
+// parseExportArgs parses the arguments following sou export. Flags may come
+// before or after the image name.
+func parseExportArgs(args []string) (exportArgs, error) {
+	var a exportArgs
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.BoolVar(&a.squash, "squash", false, "write the filesystem of all of the layers stacked, with whiteouts applied")
+	fs.StringVar(&a.output, "o", "", "path of the tar archive written, or - for the standard output")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "%s\n\nFlags:\n", exportUsage)
+		fs.PrintDefaults()
+	}
+	var images []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return exportArgs{}, err
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		images = append(images, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(images) != 1 {
+		return exportArgs{}, errors.New(exportUsage)
+	}
+	if !a.squash {
+		return exportArgs{}, errors.New("sou export needs --squash, as only the squashed filesystem can be exported")
+	}
+	if a.output == "" {
+		return exportArgs{}, errors.New("sou export needs -o, the path of the archive or - for the standard output")
+	}
+	a.image = images[0]
+	return a, nil
+}
+
+// exportImage writes the squashed filesystem of the image as a tar archive.
+// The pull and the export are written to events, which may be nil.
+func exportImage(ctx context.Context, a exportArgs, pullPolicy container.PullPolicy, opts []container.Option, events *ui.EventWriter) (err error) {
+	defer func() {
+		if err != nil {
+			events.ExportFailed(err)
+		}
+	}()
+	if a.output == "-" {
+		if info, err := os.Stdout.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+			return errors.New("refusing to write the archive to a terminal, redirect it or use -o <path>")
+		}
+	}
+
+	image, err := openImage(ctx, a.image, pullPolicy, opts, events)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	merged := container.MergeLayers(image.Layers...)
+	if len(merged.MergedDiffIDs()) == 0 {
+		return fmt.Errorf("image %s has no layers with files", a.image)
+	}
+	if err := merged.InitializeLayer(ctx, events.Progress); err != nil {
+		return err
+	}
+	defer merged.Close()
+
+	if a.output == "-" {
+		if err := merged.WriteTar(ctx, os.Stdout); err != nil {
+			return err
+		}
+		events.ExportFinished(a.output)
+		return nil
+	}
+	f, err := os.Create(a.output)
+	if err != nil {
+		return err
+	}
+	if err := merged.WriteTar(ctx, f); err != nil {
+		f.Close()
+		os.Remove(a.output)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(a.output)
+		return err
+	}
+	events.ExportFinished(a.output)
+	fmt.Fprintf(os.Stderr, "Wrote the filesystem of %s to %s\n", a.image, a.output)
+	return nil
+}
+
+// openImage opens the image like the UI does, writing the progress of the
+// pull to events
+func openImage(ctx context.Context, ref string, pullPolicy container.PullPolicy, opts []container.Option, events *ui.EventWriter) (*container.Image, error) {
+	events.PullStarted(ref)
+	opts = append(opts, container.WithPullPolicy(pullPolicy), container.WithProgress(events.Progress))
+	image, err := container.Open(ctx, ref, opts...)
+	if err != nil {
+		events.PullFailed(ref, err)
+		return nil, err
+	}
+	events.PullFinished(ref, len(image.Layers))
+	return image, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// logLevels are the levels of --log-level besides "off"
+var logLevels = map[string]slog.Level{
+	"debug": slog.LevelDebug,
+	"info":  slog.LevelInfo,
+	"warn":  slog.LevelWarn,
+	"error": slog.LevelError,
+}
+
+// defaultLogPath returns the log file in the sou directory of the user cache
+// directory
+func defaultLogPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get cache directory: %w", err)
+	}
+	return filepath.Join(dir, "sou", "debug.log"), nil
+}
+
+// openLog returns a logger appending to the file at path the messages of the
+// level and above, as text or json, and a function closing the file. The file
+// is rotated when it would exceed maxSize bytes, keeping the given number of
+// old files. No file is opened if the level is "off".
+func openLog(path, level, format string, maxSize int64, keep int) (*slog.Logger, func() error, error) {
+	level = strings.ToLower(level)
+	if level == "off" {
+		discard := slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.LevelError + 1}))
+		return discard, func() error { return nil }, nil
+	}
+	lvl, ok := logLevels[level]
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid log level %q, must be debug, info, warn, error or off", level)
+	}
+	if format != "text" && format != "json" {
+		return nil, nil, fmt.Errorf("invalid log format %q, must be text or json", format)
+	}
+
+	if path == "" {
+		var err error
+		if path, err = defaultLogPath(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	if maxSize < 0 || keep < 0 {
+		return nil, nil, fmt.Errorf("invalid log rotation, the size and the number of files must not be negative")
+	}
+	f, err := openRotatingFile(path, maxSize, keep)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(f, opts)), f.Close, nil
+	}
+	return slog.New(slog.NewTextHandler(f, opts)), f.Close, nil
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is a log file that is renamed to path.1 when it would grow
+// beyond maxSize, path.1 to path.2 and so on, keeping the given number of
+// old files
+type rotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// openRotatingFile opens the log file for appending, rotating it first if
+// it is already full. A maxSize of 0 disables the rotation.
+func openRotatingFile(path string, maxSize int64, keep int) (*rotatingFile, error) {
+	r := &rotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	if r.full(0) {
+		if err := r.rotate(); err != nil {
+			r.file.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	r.file, r.size = f, info.Size()
+	return nil
+}
+
+// full reports whether writing n more bytes would exceed the maximum size.
+// A single write is never split, so a file with nothing in it isn't full.
+func (r *rotatingFile) full(n int) bool {
+	return r.maxSize > 0 && r.size > 0 && r.size+int64(n) > r.maxSize
+}
+
+// rotate shifts the old files, dropping the oldest, and starts a new file
+func (r *rotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	if r.keep > 0 {
+		for i := r.keep - 1; i > 0; i-- {
+			old := fmt.Sprintf("%s.%d", r.path, i)
+			if err := os.Rename(old, fmt.Sprintf("%s.%d", r.path, i+1)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to rotate log file: %w", err)
+			}
+		}
+		if err := os.Rename(r.path, r.path+".1"); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	} else if err := os.Remove(r.path); err != nil {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	return r.open()
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.full(len(p)) {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *rotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// readLogs returns the contents of the log file and its old files, the
+// missing ones as ""
+func readLogs(t *testing.T, path string, n int) []string {
+	t.Helper()
+	var logs []string
+	for i := 0; i <= n; i++ {
+		p := path
+		if i > 0 {
+			p = fmt.Sprintf("%s.%d", path, i)
+		}
+		b, err := os.ReadFile(p)
+		if err != nil && !os.IsNotExist(err) {
+			t.Fatalf("ReadFile(%s) error = %v", p, err)
+		}
+		logs = append(logs, string(b))
+	}
+	return logs
+}
+
+func write(t *testing.T, r *rotatingFile, lines ...string) {
+	t.Helper()
+	for _, line := range lines {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) error = %v", line, err)
+		}
+	}
+}
+
+func TestRotatingFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int64
+		keep    int
+		lines   []string
+		want    []string
+	}{
+		{
+			name:    "no rotation",
+			maxSize: 10,
+			keep:    2,
+			lines:   []string{"aaaa\n", "bbbb\n"},
+			want:    []string{"aaaa\nbbbb\n", "", "", ""},
+		},
+		{
+			name:    "shift",
+			maxSize: 10,
+			keep:    2,
+			lines:   []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n", "eeee\n"},
+			want:    []string{"eeee\n", "cccc\ndddd\n", "aaaa\nbbbb\n", ""},
+		},
+		{
+			name:    "drop the oldest",
+			maxSize: 5,
+			keep:    2,
+			lines:   []string{"aaaa\n", "bbbb\n", "cccc\n", "dddd\n"},
+			want:    []string{"dddd\n", "cccc\n", "bbbb\n", ""},
+		},
+		{
+			name:    "keep none",
+			maxSize: 5,
+			keep:    0,
+			lines:   []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:    []string{"cccc\n", "", "", ""},
+		},
+		{
+			name:    "large write",
+			maxSize: 5,
+			keep:    1,
+			lines:   []string{"aaaaaaaa\n", "bb\n"},
+			want:    []string{"bb\n", "aaaaaaaa\n", "", ""},
+		},
+		{
+			name:    "no maximum size",
+			maxSize: 0,
+			keep:    1,
+			lines:   []string{"aaaa\n", "bbbb\n", "cccc\n"},
+			want:    []string{"aaaa\nbbbb\ncccc\n", "", "", ""},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "sou.log")
+			r, err := openRotatingFile(path, tt.maxSize, tt.keep)
+			if err != nil {
+				t.Fatalf("openRotatingFile() error = %v", err)
+			}
+			write(t, r, tt.lines...)
+			if err := r.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			if got := readLogs(t, path, 3); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOpenRotatingFileFull(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sou.log")
+	if err := os.WriteFile(path, []byte("old log\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".1", []byte("older log\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := openRotatingFile(path, 8, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile() error = %v", err)
+	}
+	write(t, r, "new\n")
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	want := []string{"new\n", "old log\n", "older log\n", ""}
+	if got := readLogs(t, path, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("logs = %q, want %q", got, want)
+	}
+}
+
+func TestOpenRotatingFileAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sou.log")
+	if err := os.WriteFile(path, []byte("old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// A file that isn't full is appended to
+	r, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("openRotatingFile() error = %v", err)
+	}
+	write(t, r, "new\n", "next\n")
+	if err := r.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	want := []string{"next\n", "old\nnew\n", "", ""}
+	if got := readLogs(t, path, 3); !reflect.DeepEqual(got, want) {
+		t.Errorf("logs = %q, want %q", got, want)
+	}
+}
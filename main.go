@@ -1,16 +1,22 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
+	v1 "github.com/google/go-containerregistry/pkg/v1"
 	"github.com/knqyf263/sou/container"
 	"github.com/knqyf263/sou/ui"
+	"github.com/knqyf263/sou/ui/filepicker"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -19,6 +25,8 @@ var (
 	version = "dev"
 )
 
+const usage = "usage: sou [--pull always|missing|never] [--source docker|cri-o|containerd] [--containerd-namespace <name>] [--platform <os/arch>] [--layer <n|digest>] [--path <path>] [--watch <interval>] [--cache-dir <path>] [--size iec|si|bytes] [--time absolute|relative|iso] [--time-zone utc|local|<name>] [--ignore-case] [--theme <name>] [--config <path>] [--no-color] [--ascii] [--show-hidden=false] [--icons] [--accessible] [--log-level <level>] [--log-file <path>] [--log-format text|json] [--log-max-size <MB>] [--log-max-files <n>] [--pprof <addr>] [--progress json] [--platforms] <image-name> | --container <name>\n       sou [flags] browse <registry>[/<path>]\n       sou [flags] export --squash <image-name> -o <path>|-\n       sou [flags] save <image-name> docker-daemon[:<name:tag>]|oci:<dir>[:<tag>]|docker-archive:<path>[:<name:tag>]"
+
 func main() {
 	if err := run(); err != nil {
 		fmt.Fprintf(os.Stderr, "error: %v\n", err)
@@ -27,41 +35,121 @@ func main() {
 }
 
 func run() error {
-	// Initialize slog
-	cacheDir, err := os.UserCacheDir()
-	if err != nil {
-		return fmt.Errorf("failed to get cache directory: %w", err)
+	var showVersion, showPlatforms, ignoreCase, noColor, ascii, accessible, icons, showHidden bool
+	var logMaxSize, logMaxFiles int
+	var watch time.Duration
+	var containerName, pull, source, namespace, platform, cacheDir, startLayer, startPath, sizeFormat, timeFormat, timeZone, configPath, themeName, logLevel, logFile, logFormat, pprofAddr, progress string
+	flag.BoolVar(&showVersion, "version", false, "show version")
+	flag.StringVar(&containerName, "container", "", "open the image of a container of the Docker daemon, by its name or ID, instead of an image name")
+	flag.StringVar(&pull, "pull", container.PullMissing.String(), "where to load the image from: always (registry), missing (local image if it exists) or never (local image only)")
+	flag.StringVar(&source, "source", container.SourceDocker.String(), "where local images are read from: docker (the Docker daemon), cri-o (the containers/storage of CRI-O, as configured by storage.conf and crio.conf) or containerd (the content store of containerd, as nerdctl and Kubernetes pull images)")
+	flag.StringVar(&namespace, "containerd-namespace", cmp.Or(os.Getenv("CONTAINERD_NAMESPACE"), container.DefaultContainerdNamespace), "namespace of containerd images are read from, such as k8s.io for Kubernetes or buildkit, which implies --source containerd")
+	flag.StringVar(&platform, "platform", "", "platform of a multi-platform image, such as linux/arm64 (default: linux/amd64, or the platform of a local image)")
+	flag.DurationVar(&watch, "watch", 0, "check at an interval such as 2s whether the tag of a local image was rebuilt, and offer to reload it")
+	flag.BoolVar(&showPlatforms, "platforms", false, "list the platforms of the image with the compressed size of their layers, and of the layers the Docker daemon doesn't have, and exit")
+	flag.StringVar(&cacheDir, "cache-dir", "", "directory the layers are extracted to (default: a temporary directory)")
+	flag.StringVar(&startLayer, "layer", "", "open a layer once the image is loaded, by its position from 1 or a prefix of its digest")
+	flag.StringVar(&startPath, "path", "", "show a directory, or select a file, in the layer of --layer or in all of the layers together")
+	flag.StringVar(&sizeFormat, "size", filepicker.SizeIEC.String(), "how sizes are displayed: iec (1.5 MiB), si (1.6 MB) or bytes (1572864 B)")
+	flag.StringVar(&timeFormat, "time", filepicker.TimeAbsolute.String(), "how times are displayed: absolute (2006-01-02 15:04), relative (3 days ago) or iso (RFC 3339)")
+	flag.StringVar(&timeZone, "time-zone", "utc", "time zone times are displayed in: utc, local or a name such as Asia/Tokyo")
+	flag.BoolVar(&ignoreCase, "ignore-case", false, "match file paths and filters ignoring case, as for layers built on Windows")
+	flag.StringVar(&configPath, "config", "", "path of the config file (default: config.json in the sou directory of the user config directory)")
+	flag.StringVar(&themeName, "theme", "", "color theme: "+strings.Join(ui.ThemeNames(), ", ")+" or a theme defined in the config file (default: the theme of the config file or "+ui.DefaultTheme+")")
+	flag.BoolVar(&noColor, "no-color", os.Getenv("NO_COLOR") != "", "disable colors (default: true if NO_COLOR is set)")
+	flag.BoolVar(&ascii, "ascii", false, "replace emoji and unicode glyphs with plain characters")
+	flag.BoolVar(&showHidden, "show-hidden", true, "show the files whose names start with a dot, which . toggles")
+	flag.BoolVar(&icons, "icons", false, "show icons of the file types, which need a Nerd Font (https://www.nerdfonts.com)")
+	flag.StringVar(&logLevel, "log-level", "warn", "level of the messages logged: debug, info, warn, error or off")
+	flag.StringVar(&logFile, "log-file", "", "path of the log file (default: debug.log in the sou directory of the user cache directory)")
+	flag.StringVar(&logFormat, "log-format", "json", "format of the log: text or json")
+	flag.IntVar(&logMaxSize, "log-max-size", 10, "size in MB the log file is rotated at, 0 to never rotate it")
+	flag.IntVar(&logMaxFiles, "log-max-files", 3, "number of rotated log files kept, such as debug.log.1, 0 to remove the log file when it is full")
+	flag.StringVar(&pprofAddr, "pprof", "", "serve the profiles of pprof at an address such as localhost:6060, at /debug/pprof/")
+	flag.StringVar(&progress, "progress", "", "write the progress as events to stderr: json, one object per line")
+	flag.BoolVar(&accessible, "accessible", false, "simplified output for screen readers, without colors, boxes or animations (implies --ascii and --no-color)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "%s\n\nFlags:\n", usage)
+		flag.PrintDefaults()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nFlags can also be set by environment variables, such as SOU_LOG_LEVEL for --log-level.\nFlags take precedence over the environment, and both over the theme of the config file.\n")
+	}
+	flag.Parse()
+	if err := setFlagsFromEnv(flag.CommandLine, "version"); err != nil {
+		return err
+	}
+
+	if showVersion {
+		fmt.Printf("sou version %s\n", version)
+		return nil
 	}
 
-	// Create sou directory in cache
-	souCacheDir := filepath.Join(cacheDir, "sou")
-	if err := os.MkdirAll(souCacheDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create cache directory: %w", err)
+	// sou browse ghcr.io/org lists the repositories of the registry
+	browse := containerName == "" && !showPlatforms && flag.NArg() == 2 && flag.Arg(0) == "browse"
+	// sou export --squash alpine -o rootfs.tar writes the filesystem of the image
+	export := containerName == "" && !showPlatforms && flag.NArg() > 0 && flag.Arg(0) == "export"
+	// sou save alpine oci:images copies the image to another store
+	save := containerName == "" && !showPlatforms && flag.NArg() == 3 && flag.Arg(0) == "save"
+	if !browse && !export && !save && (containerName != "" && flag.NArg() != 0 || containerName == "" && flag.NArg() != 1) {
+		return errors.New(usage)
+	}
+	var exported exportArgs
+	if export {
+		var err error
+		if exported, err = parseExportArgs(flag.Args()[1:]); err != nil {
+			return err
+		}
 	}
 
-	logFile, err := os.OpenFile(filepath.Join(souCacheDir, "debug.log"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	logger, closeLog, err := openLog(logFile, logLevel, logFormat, int64(logMaxSize)<<20, logMaxFiles)
 	if err != nil {
-		return fmt.Errorf("failed to open log file: %w", err)
+		return err
 	}
-	defer logFile.Close()
-
-	// Configure slog to write to the file
-	logger := slog.New(slog.NewJSONHandler(logFile, &slog.HandlerOptions{
-		Level: slog.LevelDebug,
-	}))
+	defer closeLog()
 	slog.SetDefault(logger)
+	container.SetLogger(logger)
 
-	var showVersion bool
-	flag.BoolVar(&showVersion, "version", false, "show version")
-	flag.Parse()
+	if pprofAddr != "" {
+		stopPprof, err := servePprof(pprofAddr)
+		if err != nil {
+			return err
+		}
+		defer stopPprof()
+	}
 
-	if showVersion {
-		fmt.Printf("sou version %s\n", version)
-		return nil
+	if progress != "" && progress != "json" {
+		return fmt.Errorf("invalid progress %q, must be json", progress)
 	}
 
-	if flag.NArg() != 1 {
-		return fmt.Errorf("usage: sou <image-name>")
+	pullPolicy, err := container.ParsePullPolicy(pull)
+	if err != nil {
+		return err
+	}
+	localSource, err := container.ParseSource(source)
+	if err != nil {
+		return err
+	}
+	if isFlagSet(flag.CommandLine, "containerd-namespace") && !isFlagSet(flag.CommandLine, "source") {
+		localSource = container.SourceContainerd
+	}
+	if localSource != container.SourceDocker && (containerName != "" || watch > 0) {
+		return errors.New("--container and --watch need the Docker daemon, not --source " + localSource.String())
+	}
+	sizes, err := filepicker.ParseSizeFormat(sizeFormat)
+	if err != nil {
+		return err
+	}
+	format, err := filepicker.ParseTimeFormat(timeFormat)
+	if err != nil {
+		return err
+	}
+	location, err := filepicker.ParseTimeLocation(timeZone)
+	if err != nil {
+		return err
+	}
+
+	theme, err := loadTheme(configPath, themeName)
+	if err != nil {
+		return err
 	}
 
 	// Setup signal handling for cleanup
@@ -72,29 +160,121 @@ func run() error {
 	defer cleanup()
 
 	imageName := flag.Arg(0)
+	if browse {
+		imageName = flag.Arg(1)
+	}
+	if containerName != "" {
+		// The image of the container is in the daemon
+		if imageName, err = container.ContainerImage(context.Background(), containerName); err != nil {
+			return err
+		}
+		slog.Info("opening the image of the container", "container", containerName, "image", imageName)
+		pullPolicy = container.PullNever
+	}
 
 	// Create and run program with initial model
-	model, cmd := ui.NewModel(imageName)
+	opts := []container.Option{container.WithSource(localSource), container.WithContainerdNamespace(namespace)}
+	if platform != "" {
+		p, err := v1.ParsePlatform(platform)
+		if err != nil {
+			return fmt.Errorf("invalid platform %q: %w", platform, err)
+		}
+		opts = append(opts, container.WithPlatform(*p))
+	}
+	if cacheDir != "" {
+		opts = append(opts, container.WithCacheDir(cacheDir))
+	}
+	if ignoreCase {
+		opts = append(opts, container.WithCaseInsensitive())
+	}
+	// The commands without the UI write their events themselves
+	var events *ui.EventWriter
+	if progress == "json" {
+		events = ui.NewEventWriter(os.Stderr)
+	}
+	if save {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		return saveImage(ctx, flag.Arg(1), flag.Arg(2), pullPolicy, opts, events)
+	}
+	if export {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		return exportImage(ctx, exported, pullPolicy, opts, events)
+	}
+	if showPlatforms {
+		ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+		return showPullCosts(ctx, imageName, opts, sizes, events)
+	}
+	var model ui.Model
+	var cmd tea.Cmd
+	if browse {
+		model, cmd = ui.NewBrowseModel(imageName, pullPolicy, opts...)
+	} else {
+		model, cmd = ui.NewModel(imageName, pullPolicy, opts...)
+	}
+	model.SetTimeFormat(format, location)
+	model.SetSizeFormat(sizes)
+	model.SetIgnoreCase(ignoreCase)
+	model.SetTheme(theme)
+	model.SetNoColor(noColor)
+	model.SetASCII(ascii)
+	model.SetIcons(icons)
+	model.SetShowHidden(showHidden)
+	model.SetStart(startLayer, startPath)
+	model.SetWatch(watch)
+	model.SetAccessible(accessible)
+	if progress == "json" {
+		model.SetEventWriter(os.Stderr)
+	}
+	if logger.Enabled(context.Background(), slog.LevelError) {
+		if logFile == "" {
+			// openLog succeeded with the default path
+			logFile, _ = defaultLogPath()
+		}
+		model.SetLogFile(logFile)
+	}
+	// Panics quit the program, restoring the terminal, and are reported
+	// after it
+	guard := newCrashGuard(&model, imageName)
 	p := tea.NewProgram(
-		&model,
+		guard,
 		tea.WithAltScreen(),
 	)
+	guard.quit = p.Quit
 
 	// Run the initial command
-	if cmd != nil {
+	if cmd := guard.guard(cmd); cmd != nil {
 		go func() {
 			p.Send(cmd())
 		}()
 	}
 
-	// Handle signals
+	// Handle signals. The program returns the model, whose image is closed
+	// before the cache is cleaned up.
 	go func() {
 		<-sigChan
-		cleanup()
 		p.Kill()
 	}()
 
-	if _, err := p.Run(); err != nil {
+	_, err = p.Run()
+	if m, ok := guard.model.(*ui.Model); ok {
+		if err := m.Close(); err != nil {
+			slog.Error("failed to close image", "error", err)
+		}
+	}
+	if guard.crash != nil {
+		report := guard.report()
+		slog.Error("sou crashed", "panic", fmt.Sprint(guard.crash.value), "stack", string(guard.crash.stack))
+		path, werr := writeCrashReport(report)
+		if werr != nil {
+			fmt.Fprint(os.Stderr, report)
+			return fmt.Errorf("sou crashed: %v (%w)", guard.crash.value, werr)
+		}
+		return fmt.Errorf("sou crashed: %v\nA crash report was written to %s, please attach it to a bug report at https://github.com/knqyf263/sou/issues", guard.crash.value, path)
+	}
+	if err != nil {
 		return fmt.Errorf("error running program: %w", err)
 	}
 
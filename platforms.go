@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// showPullCosts prints the pull costs of the platforms of the image. Their
+// manifests are pulled, which is written to events, which may be nil.
+func showPullCosts(ctx context.Context, ref string, opts []container.Option, sizes filepicker.SizeFormat, events *ui.EventWriter) error {
+	events.PullStarted(ref)
+	costs, err := container.PullCosts(ctx, ref, append(opts, container.WithProgress(events.Progress))...)
+	if err != nil {
+		events.PullFailed(ref, err)
+		return err
+	}
+	events.PullFinished(ref, 0)
+	return printPullCosts(os.Stdout, costs, sizes)
+}
+
+// printPullCosts writes a table of the platforms of the image with the
+// compressed size of their layers, and the part of it the Docker daemon
+// doesn't have yet
+func printPullCosts(w io.Writer, costs []container.PlatformCost, sizes filepicker.SizeFormat) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLATFORM\tDIGEST\tSIZE\tTO PULL")
+	for _, c := range costs {
+		platform := c.Platform.String()
+		if platform == "" {
+			platform = "unknown"
+		}
+		digest := c.Digest
+		if len(digest) > len("sha256:")+12 {
+			digest = digest[:len("sha256:")+12]
+		}
+		size, layers := c.PullSize()
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s (%d of %d layers)\n", platform, digest,
+			filepicker.FormatSize(c.Size(), sizes), filepicker.FormatSize(size, sizes), layers, len(c.Layers))
+	}
+	return tw.Flush()
+}
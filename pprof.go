@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"time"
+)
+
+// servePprof serves the profiles of net/http/pprof at addr, such as
+// localhost:6060, and returns a function stopping the server. Importing
+// net/http/pprof registers the profiles on http.DefaultServeMux too, so the
+// server uses a mux of its own and no other server may serve the default one.
+func servePprof(addr string) (func() error, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for pprof: %w", err)
+	}
+
+	// Only the handlers registered here are served
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		if err := server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			slog.Error("pprof server failed", "error", err)
+		}
+	}()
+	slog.Info("serving pprof", "url", fmt.Sprintf("http://%s/debug/pprof/", ln.Addr()))
+	return server.Close, nil
+}
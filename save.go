@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui"
+)
+
+// saveImage copies the image to the destination, such as the Docker daemon
+// after it was pulled. The pull and the save are written to events, which may
+// be nil, the save as an export to the destination.
+func saveImage(ctx context.Context, ref, destination string, pullPolicy container.PullPolicy, opts []container.Option, events *ui.EventWriter) (err error) {
+	defer func() {
+		if err != nil {
+			events.ExportFailed(err)
+		}
+	}()
+	dest, err := container.ParseSaveDestination(destination)
+	if err != nil {
+		return err
+	}
+	image, err := openImage(ctx, ref, pullPolicy, opts, events)
+	if err != nil {
+		return err
+	}
+	defer image.Close()
+	if err := image.Save(ctx, dest); err != nil {
+		return err
+	}
+	events.ExportFinished(destination)
+	fmt.Fprintf(os.Stderr, "Saved %s to %s\n", ref, destination)
+	return nil
+}
@@ -0,0 +1,581 @@
+// Package squashfs reads squashfs images, such as the file systems of SIF
+// images built by Apptainer and Singularity. An image is converted to a tar
+// archive by WriteTar so that it is browsed like the layers of other images.
+package squashfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// magic is "hsqs" read as a little-endian number
+	magic = 0x73717368
+	// metadataBlockSize is the uncompressed size of the blocks of the inode,
+	// directory, fragment and ID tables
+	metadataBlockSize = 8192
+	// noFragment is the fragment index of the files whose end isn't stored
+	// in a fragment block
+	noFragment = 0xffffffff
+	// uncompressedBlock is set in the size of the data blocks stored as is
+	uncompressedBlock = 1 << 24
+	// uncompressedMetadata is set in the header of the metadata blocks
+	// stored as is
+	uncompressedMetadata = 0x8000
+)
+
+// Compressors of the blocks
+const (
+	compressionGzip = 1
+	compressionZstd = 6
+)
+
+var compressionNames = map[uint16]string{
+	2: "lzma",
+	3: "lzo",
+	4: "xz",
+	5: "lz4",
+}
+
+// Types of the inodes. The extended types follow the basic ones in the
+// same order.
+const (
+	typeDir = iota + 1
+	typeFile
+	typeSymlink
+	typeBlockDev
+	typeCharDev
+	typeFifo
+	typeSocket
+	extendedTypes = typeSocket
+)
+
+// zstdDecoder decompresses blocks with DecodeAll, which is safe to call
+// concurrently
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+
+type superblock struct {
+	Magic               uint32
+	InodeCount          uint32
+	ModTime             uint32
+	BlockSize           uint32
+	FragmentCount       uint32
+	Compression         uint16
+	BlockLog            uint16
+	Flags               uint16
+	IDCount             uint16
+	VersionMajor        uint16
+	VersionMinor        uint16
+	RootInode           uint64
+	BytesUsed           uint64
+	IDTableStart        uint64
+	XattrTableStart     uint64
+	InodeTableStart     uint64
+	DirectoryTableStart uint64
+	FragmentTableStart  uint64
+	ExportTableStart    uint64
+}
+
+// Reader reads the files of a squashfs image
+type Reader struct {
+	r         io.ReaderAt
+	sb        superblock
+	ids       []uint32
+	fragments []fragment
+	// metadata are the uncompressed metadata blocks read so far, by their
+	// position in the image
+	metadata map[int64]metadataBlock
+	// fragmentData is the last fragment block read, which the next files
+	// often end in
+	fragmentData  []byte
+	fragmentIndex uint32
+}
+
+type fragment struct {
+	Start  uint64
+	Size   uint32
+	Unused uint32
+}
+
+type metadataBlock struct {
+	data []byte
+	next int64 // position of the following block
+}
+
+// Open reads the superblock and the tables of the image. Only the gzip and
+// zstd compressors are supported.
+func Open(r io.ReaderAt) (*Reader, error) {
+	sr := &Reader{r: r, metadata: make(map[int64]metadataBlock), fragmentIndex: noFragment}
+	if err := binary.Read(io.NewSectionReader(r, 0, 96), binary.LittleEndian, &sr.sb); err != nil {
+		return nil, fmt.Errorf("failed to read superblock: %w", err)
+	}
+	switch {
+	case sr.sb.Magic != magic:
+		return nil, errors.New("not a squashfs image")
+	case sr.sb.VersionMajor != 4:
+		return nil, fmt.Errorf("unsupported squashfs version %d.%d", sr.sb.VersionMajor, sr.sb.VersionMinor)
+	case sr.sb.Compression != compressionGzip && sr.sb.Compression != compressionZstd:
+		name, ok := compressionNames[sr.sb.Compression]
+		if !ok {
+			name = fmt.Sprintf("%d", sr.sb.Compression)
+		}
+		return nil, fmt.Errorf("unsupported squashfs compression %s", name)
+	case sr.sb.BlockSize == 0 || sr.sb.BlockSize > 1<<20:
+		return nil, fmt.Errorf("invalid block size %d", sr.sb.BlockSize)
+	}
+
+	var err error
+	if sr.ids, err = readTable[uint32](sr, sr.sb.IDTableStart, int(sr.sb.IDCount)); err != nil {
+		return nil, fmt.Errorf("failed to read ID table: %w", err)
+	}
+	if sr.sb.FragmentCount > 0 {
+		if sr.fragments, err = readTable[fragment](sr, sr.sb.FragmentTableStart, int(sr.sb.FragmentCount)); err != nil {
+			return nil, fmt.Errorf("failed to read fragment table: %w", err)
+		}
+	}
+	return sr, nil
+}
+
+// readTable reads the entries of a table, which are stored in metadata
+// blocks listed at start
+func readTable[T any](r *Reader, start uint64, count int) ([]T, error) {
+	perBlock := metadataBlockSize / binary.Size(new(T))
+	blocks := make([]uint64, (count+perBlock-1)/perBlock)
+	if err := binary.Read(io.NewSectionReader(r.r, int64(start), int64(8*len(blocks))), binary.LittleEndian, blocks); err != nil {
+		return nil, err
+	}
+	entries := make([]T, count)
+	for i, block := range blocks {
+		m, err := r.metadataReader(int64(block), 0)
+		if err != nil {
+			return nil, err
+		}
+		n := min(perBlock, count-i*perBlock)
+		if err := binary.Read(m, binary.LittleEndian, entries[i*perBlock:i*perBlock+n]); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// decompress decompresses a block of at most size bytes
+func (r *Reader) decompress(data []byte, size int) ([]byte, error) {
+	if r.sb.Compression == compressionZstd {
+		return zstdDecoder.DecodeAll(data, make([]byte, 0, size))
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	out, err := io.ReadAll(io.LimitReader(zr, int64(size)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > size {
+		return nil, fmt.Errorf("block is larger than %d bytes", size)
+	}
+	return out, nil
+}
+
+// readMetadataBlock reads the metadata block at pos
+func (r *Reader) readMetadataBlock(pos int64) (metadataBlock, error) {
+	if block, ok := r.metadata[pos]; ok {
+		return block, nil
+	}
+	var header [2]byte
+	if _, err := r.r.ReadAt(header[:], pos); err != nil {
+		return metadataBlock{}, fmt.Errorf("failed to read metadata block at %d: %w", pos, err)
+	}
+	h := binary.LittleEndian.Uint16(header[:])
+	size := int64(h &^ uncompressedMetadata)
+	data := make([]byte, size)
+	if _, err := r.r.ReadAt(data, pos+2); err != nil {
+		return metadataBlock{}, fmt.Errorf("failed to read metadata block at %d: %w", pos, err)
+	}
+	if h&uncompressedMetadata == 0 {
+		var err error
+		if data, err = r.decompress(data, metadataBlockSize); err != nil {
+			return metadataBlock{}, fmt.Errorf("failed to decompress metadata block at %d: %w", pos, err)
+		}
+	}
+	block := metadataBlock{data: data, next: pos + 2 + size}
+	r.metadata[pos] = block
+	return block, nil
+}
+
+// metadata reads the metadata from the offset in the uncompressed block at
+// pos, continuing with the following blocks
+type metadata struct {
+	r    *Reader
+	buf  []byte
+	next int64
+}
+
+func (r *Reader) metadataReader(pos int64, offset int) (*metadata, error) {
+	block, err := r.readMetadataBlock(pos)
+	if err != nil {
+		return nil, err
+	}
+	if offset > len(block.data) {
+		return nil, fmt.Errorf("offset %d is out of the metadata block at %d", offset, pos)
+	}
+	return &metadata{r: r, buf: block.data[offset:], next: block.next}, nil
+}
+
+func (m *metadata) Read(p []byte) (int, error) {
+	for len(m.buf) == 0 {
+		block, err := m.r.readMetadataBlock(m.next)
+		if err != nil {
+			return 0, err
+		}
+		m.buf, m.next = block.data, block.next
+	}
+	n := copy(p, m.buf)
+	m.buf = m.buf[n:]
+	return n, nil
+}
+
+// inode is a file of the image
+type inode struct {
+	typ    uint16 // basic type, also for extended inodes
+	mode   uint16
+	uid    uint32
+	gid    uint32
+	mtime  uint32
+	number uint32
+	nlink  uint32
+	size   uint64
+
+	// Directories
+	dirBlock  uint32
+	dirOffset uint16
+
+	// Regular files
+	blocksStart    uint64
+	fragment       uint32
+	fragmentOffset uint32
+	blockSizes     []uint32
+
+	target string // symbolic links
+	dev    uint32 // devices
+}
+
+// readInode reads the inode a reference points to, the position of its
+// metadata block in the inode table shifted by 16 bits with the offset in it
+func (r *Reader) readInode(ref uint64) (*inode, error) {
+	m, err := r.metadataReader(int64(r.sb.InodeTableStart+ref>>16), int(ref&0xffff))
+	if err != nil {
+		return nil, err
+	}
+	var header struct {
+		Type, Mode, UID, GID uint16
+		MTime, Number        uint32
+	}
+	if err := binary.Read(m, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("failed to read inode: %w", err)
+	}
+	in := &inode{typ: header.Type, mode: header.Mode, mtime: header.MTime, number: header.Number}
+	if int(header.UID) >= len(r.ids) || int(header.GID) >= len(r.ids) {
+		return nil, fmt.Errorf("inode %d has an invalid owner", header.Number)
+	}
+	in.uid, in.gid = r.ids[header.UID], r.ids[header.GID]
+	extended := in.typ > extendedTypes
+	if extended {
+		in.typ -= extendedTypes
+	}
+
+	read := func(fields ...any) error {
+		for _, f := range fields {
+			if err := binary.Read(m, binary.LittleEndian, f); err != nil {
+				return fmt.Errorf("failed to read inode %d: %w", in.number, err)
+			}
+		}
+		return nil
+	}
+	var xattr, parent uint32
+	switch {
+	case in.typ == typeDir && !extended:
+		var size uint16
+		err = read(&in.dirBlock, &in.nlink, &size, &in.dirOffset, &parent)
+		in.size = uint64(size)
+	case in.typ == typeDir:
+		var size uint32
+		var indexCount uint16
+		err = read(&in.nlink, &size, &in.dirBlock, &parent, &indexCount, &in.dirOffset, &xattr)
+		in.size = uint64(size)
+	case in.typ == typeFile && !extended:
+		var start, size uint32
+		err = read(&start, &in.fragment, &in.fragmentOffset, &size)
+		in.blocksStart, in.size, in.nlink = uint64(start), uint64(size), 1
+	case in.typ == typeFile:
+		var sparse uint64
+		err = read(&in.blocksStart, &in.size, &sparse, &in.nlink, &in.fragment, &in.fragmentOffset, &xattr)
+	case in.typ == typeSymlink:
+		var size uint32
+		if err = read(&in.nlink, &size); err == nil {
+			target := make([]byte, size)
+			err = read(target)
+			in.target = string(target)
+		}
+	case in.typ == typeBlockDev || in.typ == typeCharDev:
+		err = read(&in.nlink, &in.dev)
+	case in.typ == typeFifo || in.typ == typeSocket:
+		err = read(&in.nlink)
+	default:
+		return nil, fmt.Errorf("inode %d has an unknown type %d", in.number, header.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if in.typ == typeFile {
+		blocks := in.size / uint64(r.sb.BlockSize)
+		if in.fragment == noFragment && in.size%uint64(r.sb.BlockSize) != 0 {
+			blocks++
+		}
+		if blocks > uint64(r.sb.BytesUsed) {
+			return nil, fmt.Errorf("inode %d has an invalid size", in.number)
+		}
+		in.blockSizes = make([]uint32, blocks)
+		if err := read(in.blockSizes); err != nil {
+			return nil, err
+		}
+	}
+	return in, nil
+}
+
+type dirEntry struct {
+	name  string
+	inode uint64 // reference of the inode
+}
+
+// readDir lists the entries of a directory, sorted by name
+func (r *Reader) readDir(dir *inode) ([]dirEntry, error) {
+	// The size counts the . and .. entries, which aren't stored
+	if dir.size <= 3 {
+		return nil, nil
+	}
+	m, err := r.metadataReader(int64(r.sb.DirectoryTableStart)+int64(dir.dirBlock), int(dir.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+	lr := io.LimitReader(m, int64(dir.size-3))
+
+	var entries []dirEntry
+	for {
+		var header struct {
+			Count, Start, Number uint32
+		}
+		if err := binary.Read(lr, binary.LittleEndian, &header); err == io.EOF {
+			return entries, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read directory: %w", err)
+		}
+		for range header.Count + 1 {
+			var entry struct {
+				Offset      uint16
+				InodeOffset int16
+				Type        uint16
+				NameSize    uint16
+			}
+			if err := binary.Read(lr, binary.LittleEndian, &entry); err != nil {
+				return nil, fmt.Errorf("failed to read directory: %w", err)
+			}
+			name := make([]byte, int(entry.NameSize)+1)
+			if _, err := io.ReadFull(lr, name); err != nil {
+				return nil, fmt.Errorf("failed to read directory: %w", err)
+			}
+			entries = append(entries, dirEntry{
+				name:  string(name),
+				inode: uint64(header.Start)<<16 | uint64(entry.Offset),
+			})
+		}
+	}
+}
+
+// writeFile writes the content of a regular file, from its data blocks and
+// the fragment block its end is in
+func (r *Reader) writeFile(w io.Writer, file *inode) error {
+	blockSize := uint64(r.sb.BlockSize)
+	remaining := file.size
+	pos := int64(file.blocksStart)
+	var buf []byte
+	for _, size := range file.blockSizes {
+		n := min(remaining, blockSize)
+		stored := int64(size &^ uncompressedBlock)
+		var data []byte
+		switch {
+		case stored == 0:
+			// Sparse block
+			data = make([]byte, n)
+		default:
+			if int64(cap(buf)) < stored {
+				buf = make([]byte, stored)
+			}
+			data = buf[:stored]
+			if _, err := r.r.ReadAt(data, pos); err != nil {
+				return fmt.Errorf("failed to read data block at %d: %w", pos, err)
+			}
+			if size&uncompressedBlock == 0 {
+				var err error
+				if data, err = r.decompress(data, int(blockSize)); err != nil {
+					return fmt.Errorf("failed to decompress data block at %d: %w", pos, err)
+				}
+			}
+		}
+		if uint64(len(data)) != n {
+			return fmt.Errorf("data block at %d has %d bytes instead of %d", pos, len(data), n)
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		pos += stored
+		remaining -= n
+	}
+	if remaining == 0 {
+		return nil
+	}
+	if file.fragment == noFragment {
+		return fmt.Errorf("inode %d is missing %d bytes", file.number, remaining)
+	}
+
+	data, err := r.readFragment(file.fragment)
+	if err != nil {
+		return err
+	}
+	end := uint64(file.fragmentOffset) + remaining
+	if end > uint64(len(data)) {
+		return fmt.Errorf("inode %d ends out of fragment %d", file.number, file.fragment)
+	}
+	_, err = w.Write(data[file.fragmentOffset:end])
+	return err
+}
+
+// readFragment reads the fragment block of the index, where the ends of
+// several files are stored together
+func (r *Reader) readFragment(index uint32) ([]byte, error) {
+	if index == r.fragmentIndex {
+		return r.fragmentData, nil
+	}
+	if int(index) >= len(r.fragments) {
+		return nil, fmt.Errorf("fragment %d is out of the fragment table", index)
+	}
+	f := r.fragments[index]
+	data := make([]byte, f.Size&^uncompressedBlock)
+	if _, err := r.r.ReadAt(data, int64(f.Start)); err != nil {
+		return nil, fmt.Errorf("failed to read fragment %d: %w", index, err)
+	}
+	if f.Size&uncompressedBlock == 0 {
+		var err error
+		if data, err = r.decompress(data, int(r.sb.BlockSize)); err != nil {
+			return nil, fmt.Errorf("failed to decompress fragment %d: %w", index, err)
+		}
+	}
+	r.fragmentData, r.fragmentIndex = data, index
+	return data, nil
+}
+
+// WriteTar writes the files of the image as a tar archive, from the root
+// directory down. Names are relative to the root, as in the layers of
+// container images. Files linked several times are written once, and then
+// as hard links to it. Sockets, which tar can't hold, are skipped.
+func (r *Reader) WriteTar(w io.Writer) error {
+	root, err := r.readInode(r.sb.RootInode)
+	if err != nil {
+		return err
+	}
+	if root.typ != typeDir {
+		return errors.New("root inode isn't a directory")
+	}
+	tw := tar.NewWriter(w)
+	visited := map[uint32]bool{root.number: true}
+	if err := r.writeDir(tw, root, "", make(map[uint32]string), visited); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// writeDir writes the entries of the directory and of its subdirectories.
+// links has the names the files linked several times were written with,
+// and visited the directories written, which an invalid image could loop to.
+func (r *Reader) writeDir(tw *tar.Writer, dir *inode, name string, links map[uint32]string, visited map[uint32]bool) error {
+	entries, err := r.readDir(dir)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for _, e := range entries {
+		entryName := path.Join(name, e.name)
+		in, err := r.readInode(e.inode)
+		if err != nil {
+			return fmt.Errorf("%s: %w", entryName, err)
+		}
+		hdr := &tar.Header{
+			Name:    entryName,
+			Mode:    int64(in.mode & 0o7777),
+			Uid:     int(in.uid),
+			Gid:     int(in.gid),
+			ModTime: time.Unix(int64(in.mtime), 0),
+		}
+		switch in.typ {
+		case typeDir:
+			if visited[in.number] {
+				return fmt.Errorf("%s: directory loop", entryName)
+			}
+			visited[in.number] = true
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case typeFile:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(in.size)
+			if in.nlink > 1 {
+				if target, ok := links[in.number]; ok {
+					hdr.Typeflag = tar.TypeLink
+					hdr.Linkname = target
+					hdr.Size = 0
+				} else {
+					links[in.number] = entryName
+				}
+			}
+		case typeSymlink:
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = in.target
+		case typeBlockDev, typeCharDev:
+			hdr.Typeflag = tar.TypeChar
+			if in.typ == typeBlockDev {
+				hdr.Typeflag = tar.TypeBlock
+			}
+			// Linux encodes the minor number around the major one
+			hdr.Devmajor = int64(in.dev&0xfff00) >> 8
+			hdr.Devminor = int64(in.dev&0xff | in.dev>>12&0xfff00)
+		case typeFifo:
+			hdr.Typeflag = tar.TypeFifo
+		case typeSocket:
+			continue
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		switch {
+		case hdr.Typeflag == tar.TypeReg:
+			if err := r.writeFile(tw, in); err != nil {
+				return fmt.Errorf("%s: %w", entryName, err)
+			}
+		case hdr.Typeflag == tar.TypeDir:
+			if err := r.writeDir(tw, in, entryName, links, visited); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,331 @@
+package squashfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const testBlockSize = 4096
+
+// testNode is a file of the image built by buildImage
+type testNode struct {
+	name     string
+	typ      uint16 // basic type
+	extended bool
+	mode     uint16
+	uid      uint16 // index in the ID table
+	content  []byte
+	sparse   bool // the first block is a hole
+	target   string
+	dev      uint32
+	children []*testNode
+	link     *testNode // another name of the file
+
+	ref    uint64
+	number uint32
+}
+
+// imageBuilder writes a squashfs image with the inode table in a single
+// compressed metadata block and the directory table in an uncompressed one
+type imageBuilder struct {
+	compression uint16
+	data        bytes.Buffer // data blocks, following the superblock
+	fragment    bytes.Buffer // the only fragment block
+	inodes      bytes.Buffer
+	dirs        bytes.Buffer
+	next        uint32 // next inode number
+}
+
+func (b *imageBuilder) compress(t *testing.T, data []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if b.compression == compressionZstd {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return enc.EncodeAll(data, nil)
+	}
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
+
+func (b *imageBuilder) write(w *bytes.Buffer, fields ...any) {
+	for _, f := range fields {
+		binary.Write(w, binary.LittleEndian, f)
+	}
+}
+
+// writeNode writes the inode of the node, after the inodes and the listings
+// of its children
+func (b *imageBuilder) writeNode(t *testing.T, n *testNode) {
+	if n.link != nil {
+		n.ref, n.number = n.link.ref, n.link.number
+		return
+	}
+	for _, c := range n.children {
+		b.writeNode(t, c)
+	}
+	b.next++
+	n.number = b.next
+	n.ref = uint64(b.inodes.Len())
+	typ := n.typ
+	if n.extended {
+		typ += extendedTypes
+	}
+	b.write(&b.inodes, typ, n.mode, n.uid, uint16(0), uint32(1700000000), n.number)
+
+	switch n.typ {
+	case typeDir:
+		offset := uint16(b.dirs.Len())
+		var listing bytes.Buffer
+		if len(n.children) > 0 {
+			base := n.children[0].number
+			b.write(&listing, uint32(len(n.children)-1), uint32(0), base)
+			for _, c := range n.children {
+				typ := c.typ
+				if c.link != nil {
+					typ = c.link.typ
+				}
+				b.write(&listing, uint16(c.ref), int16(c.number-base), typ, uint16(len(c.name)-1))
+				listing.WriteString(c.name)
+			}
+		}
+		b.dirs.Write(listing.Bytes())
+		size := uint32(listing.Len() + 3)
+		if n.extended {
+			b.write(&b.inodes, uint32(2), size, uint32(0), uint32(0), uint16(0), offset, uint32(0xffffffff))
+		} else {
+			b.write(&b.inodes, uint32(0), uint32(2), uint16(size), offset, uint32(0))
+		}
+	case typeFile:
+		start := uint64(96 + b.data.Len())
+		var sizes []uint32
+		content := n.content
+		for len(content) >= testBlockSize {
+			block := content[:testBlockSize]
+			content = content[testBlockSize:]
+			switch {
+			case n.sparse && len(sizes) == 0:
+				sizes = append(sizes, 0)
+			case len(sizes)%2 == 0:
+				compressed := b.compress(t, block)
+				b.data.Write(compressed)
+				sizes = append(sizes, uint32(len(compressed)))
+			default:
+				b.data.Write(block)
+				sizes = append(sizes, uncompressedBlock|testBlockSize)
+			}
+		}
+		fragment, offset := uint32(noFragment), uint32(0)
+		if len(content) > 0 {
+			fragment, offset = 0, uint32(b.fragment.Len())
+			b.fragment.Write(content)
+		}
+		// Extended files are linked twice, to test hard links
+		if n.extended {
+			b.write(&b.inodes, start, uint64(len(n.content)), uint64(0), uint32(2), fragment, offset, uint32(0xffffffff))
+		} else {
+			b.write(&b.inodes, uint32(start), fragment, offset, uint32(len(n.content)))
+		}
+		b.write(&b.inodes, sizes)
+	case typeSymlink:
+		b.write(&b.inodes, uint32(1), uint32(len(n.target)))
+		b.inodes.WriteString(n.target)
+	case typeCharDev, typeBlockDev:
+		b.write(&b.inodes, uint32(1), n.dev)
+	case typeFifo, typeSocket:
+		b.write(&b.inodes, uint32(1))
+	}
+}
+
+// buildImage builds a squashfs image of the root directory
+func buildImage(t *testing.T, compression uint16, root *testNode) []byte {
+	t.Helper()
+	b := &imageBuilder{compression: compression}
+	b.writeNode(t, root)
+
+	var image bytes.Buffer
+	image.Write(make([]byte, 96))
+	image.Write(b.data.Bytes())
+	fragmentStart := image.Len()
+	image.Write(b.fragment.Bytes())
+
+	inodeTable := image.Len()
+	inodes := b.compress(t, b.inodes.Bytes())
+	b.write(&image, uint16(len(inodes)))
+	image.Write(inodes)
+
+	dirTable := image.Len()
+	b.write(&image, uint16(uncompressedMetadata|b.dirs.Len()))
+	image.Write(b.dirs.Bytes())
+
+	fragmentBlock := image.Len()
+	b.write(&image, uint16(uncompressedMetadata|16), uint64(fragmentStart), uint32(uncompressedBlock|b.fragment.Len()), uint32(0))
+	fragmentTable := image.Len()
+	b.write(&image, uint64(fragmentBlock))
+
+	idBlock := image.Len()
+	b.write(&image, uint16(uncompressedMetadata|8), uint32(0), uint32(1000))
+	idTable := image.Len()
+	b.write(&image, uint64(idBlock))
+
+	sb := superblock{
+		Magic:               magic,
+		InodeCount:          b.next,
+		BlockSize:           testBlockSize,
+		FragmentCount:       1,
+		Compression:         compression,
+		BlockLog:            12,
+		IDCount:             2,
+		VersionMajor:        4,
+		RootInode:           root.ref,
+		BytesUsed:           uint64(image.Len()),
+		IDTableStart:        uint64(idTable),
+		XattrTableStart:     0xffffffffffffffff,
+		InodeTableStart:     uint64(inodeTable),
+		DirectoryTableStart: uint64(dirTable),
+		FragmentTableStart:  uint64(fragmentTable),
+		ExportTableStart:    0xffffffffffffffff,
+	}
+	raw := image.Bytes()
+	var header bytes.Buffer
+	b.write(&header, sb)
+	copy(raw, header.Bytes())
+	return raw
+}
+
+func testTree() *testNode {
+	big := bytes.Repeat([]byte("0123456789abcdef"), testBlockSize*5/2/16)
+	hosts := &testNode{name: "hosts", typ: typeFile, extended: true, mode: 0o644, content: []byte("127.0.0.1 localhost\n")}
+	return &testNode{typ: typeDir, mode: 0o755, children: []*testNode{
+		{name: "bin", typ: typeDir, extended: true, mode: 0o755, children: []*testNode{
+			{name: "big", typ: typeFile, extended: true, mode: 0o755, content: big},
+			{name: "sh", typ: typeSymlink, mode: 0o777, target: "busybox"},
+		}},
+		{name: "dev", typ: typeDir, mode: 0o755, children: []*testNode{
+			{name: "fifo", typ: typeFifo, mode: 0o600},
+			{name: "null", typ: typeCharDev, mode: 0o666, dev: 1<<8 | 3},
+			{name: "socket", typ: typeSocket, mode: 0o755},
+			{name: "sda", typ: typeBlockDev, mode: 0o660, dev: 8<<8 | 0x12 | 0x300<<12},
+		}},
+		{name: "empty", typ: typeDir, mode: 0o700},
+		{name: "etc", typ: typeDir, mode: 0o755, children: []*testNode{
+			hosts,
+			{name: "hosts.bak", link: hosts},
+			{name: "secret", typ: typeFile, mode: 0o4600, uid: 1, content: []byte("s3cr3t")},
+		}},
+		{name: "sparse", typ: typeFile, mode: 0o644, sparse: true, content: make([]byte, testBlockSize)},
+	}}
+}
+
+type tarEntry struct {
+	Name     string
+	Typeflag byte
+	Mode     int64
+	Uid      int
+	Linkname string
+	Devmajor int64
+	Devminor int64
+	Content  string
+}
+
+func TestWriteTar(t *testing.T) {
+	big := strings.Repeat("0123456789abcdef", testBlockSize*5/2/16)
+	want := []tarEntry{
+		{Name: "bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "bin/big", Typeflag: tar.TypeReg, Mode: 0o755, Content: big},
+		{Name: "bin/sh", Typeflag: tar.TypeSymlink, Mode: 0o777, Linkname: "busybox"},
+		{Name: "dev/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "dev/fifo", Typeflag: tar.TypeFifo, Mode: 0o600},
+		{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3},
+		{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0o660, Devmajor: 8, Devminor: 0x312},
+		{Name: "empty/", Typeflag: tar.TypeDir, Mode: 0o700},
+		{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "etc/hosts", Typeflag: tar.TypeReg, Mode: 0o644, Content: "127.0.0.1 localhost\n"},
+		{Name: "etc/hosts.bak", Typeflag: tar.TypeLink, Mode: 0o644, Linkname: "etc/hosts"},
+		{Name: "etc/secret", Typeflag: tar.TypeReg, Mode: 0o4600, Uid: 1000, Content: "s3cr3t"},
+		{Name: "sparse", Typeflag: tar.TypeReg, Mode: 0o644, Content: string(make([]byte, testBlockSize))},
+	}
+
+	for _, compression := range []uint16{compressionGzip, compressionZstd} {
+		image := buildImage(t, compression, testTree())
+		r, err := Open(bytes.NewReader(image))
+		if err != nil {
+			t.Fatalf("Open() with compression %d error = %v", compression, err)
+		}
+		var buf bytes.Buffer
+		if err := r.WriteTar(&buf); err != nil {
+			t.Fatalf("WriteTar() with compression %d error = %v", compression, err)
+		}
+
+		var got []tarEntry
+		tr := tar.NewReader(&buf)
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.ModTime.Unix() != 1700000000 {
+				t.Errorf("%s: ModTime = %v", hdr.Name, hdr.ModTime)
+			}
+			got = append(got, tarEntry{
+				Name:     hdr.Name,
+				Typeflag: hdr.Typeflag,
+				Mode:     hdr.Mode,
+				Uid:      hdr.Uid,
+				Linkname: hdr.Linkname,
+				Devmajor: hdr.Devmajor,
+				Devminor: hdr.Devminor,
+				Content:  string(content),
+			})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("WriteTar() with compression %d =\n%+v\nwant\n%+v", compression, got, want)
+		}
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	image := buildImage(t, compressionGzip, testTree())
+
+	notSquashfs := bytes.Clone(image)
+	copy(notSquashfs, "sqsh")
+	xz := bytes.Clone(image)
+	binary.LittleEndian.PutUint16(xz[20:], 4)
+
+	tests := []struct {
+		name  string
+		image []byte
+		want  string
+	}{
+		{name: "not squashfs", image: notSquashfs, want: "not a squashfs image"},
+		{name: "unsupported compression", image: xz, want: "unsupported squashfs compression xz"},
+		{name: "truncated", image: image[:50], want: "failed to read superblock"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Open(bytes.NewReader(tt.image))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Open() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package tarfs
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const blockSize = 512
+
+// PAX records of GNU sparse files
+const (
+	paxGNUSparseMajor = "GNU.sparse.major"
+	paxGNUSparseMinor = "GNU.sparse.minor"
+	paxGNUSparseMap   = "GNU.sparse.map" // also holds the 0.0 offset and numbytes records
+)
+
+// Layout of old GNU sparse headers
+const (
+	gnuSparseOffset       = 386 // 4 entries in the header
+	gnuSparseHeaderExtend = 482
+	gnuSparseExtEntries   = 21 // entries in an extension block
+	gnuSparseExtExtend    = 504
+	gnuSparseEntrySize    = 24 // 12 bytes of offset and 12 bytes of length
+)
+
+var errSparseHeader = errors.New("tarfs: invalid sparse header")
+
+// fragment is a part of a sparse file that is stored in the archive.
+// The rest of the file is a hole reading as zeros.
+type fragment struct {
+	offset int64 // offset in the file
+	length int64
+	pos    int64 // offset from the start of the file data in the archive
+}
+
+// sparseMap returns the fragments of a GNU sparse file whose data starts at
+// dataOffset. ok is false for other files. tar.Reader has already validated
+// the map and consumed the parts of it stored before the data, so the map is
+// read back from the archive.
+func sparseMap(r io.ReaderAt, hdr *tar.Header, dataOffset int64) (fragments []fragment, ok bool, err error) {
+	var raw []int64
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		raw, err = readOldGNUSparseMap(r, dataOffset)
+	} else {
+		major, minor := hdr.PAXRecords[paxGNUSparseMajor], hdr.PAXRecords[paxGNUSparseMinor]
+		switch {
+		case major == "1" && minor == "0":
+			raw, err = readGNUSparseMap1x0(r, dataOffset)
+		case major == "0" && (minor == "0" || minor == "1"), major == "" && minor == "":
+			records, found := hdr.PAXRecords[paxGNUSparseMap]
+			if !found {
+				return nil, false, nil
+			}
+			raw, err = parseNumbers(strings.Split(records, ","))
+		default:
+			// tar.Reader doesn't support other versions either
+			return nil, false, nil
+		}
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read sparse map of %s: %w", hdr.Name, err)
+	}
+
+	if len(raw)%2 != 0 {
+		return nil, false, errSparseHeader
+	}
+	fragments = make([]fragment, 0, len(raw)/2)
+	var pos, end int64
+	for i := 0; i < len(raw); i += 2 {
+		f := fragment{offset: raw[i], length: raw[i+1], pos: pos}
+		if f.offset < end || f.length < 0 || f.offset+f.length > hdr.Size {
+			return nil, false, errSparseHeader
+		}
+		fragments = append(fragments, f)
+		pos += f.length
+		end = f.offset + f.length
+	}
+	return fragments, true, nil
+}
+
+// readOldGNUSparseMap reads the map stored in the header of a GNU sparse file
+// and the extension blocks following it, which end at dataOffset
+func readOldGNUSparseMap(r io.ReaderAt, dataOffset int64) ([]int64, error) {
+	// Find the header before the extension blocks
+	block := make([]byte, blockSize)
+	headerOffset := dataOffset - blockSize
+	for ; ; headerOffset -= blockSize {
+		if headerOffset < 0 {
+			return nil, errSparseHeader
+		}
+		if _, err := r.ReadAt(block, headerOffset); err != nil {
+			return nil, err
+		}
+		if isGNUSparseHeader(block) {
+			break
+		}
+	}
+
+	var raw []int64
+	entries, extended := block[gnuSparseOffset:gnuSparseHeaderExtend], block[gnuSparseHeaderExtend]
+	for offset := headerOffset + blockSize; ; offset += blockSize {
+		for ; len(entries) >= gnuSparseEntrySize; entries = entries[gnuSparseEntrySize:] {
+			if entries[0] == 0 {
+				break
+			}
+			off, err := parseNumeric(entries[:12])
+			if err != nil {
+				return nil, err
+			}
+			length, err := parseNumeric(entries[12:gnuSparseEntrySize])
+			if err != nil {
+				return nil, err
+			}
+			raw = append(raw, off, length)
+		}
+		if extended == 0 {
+			if offset != dataOffset {
+				return nil, errSparseHeader
+			}
+			return raw, nil
+		}
+
+		if offset >= dataOffset {
+			return nil, errSparseHeader
+		}
+		if _, err := r.ReadAt(block, offset); err != nil {
+			return nil, err
+		}
+		entries, extended = block[:gnuSparseExtEntries*gnuSparseEntrySize], block[gnuSparseExtExtend]
+	}
+}
+
+// isGNUSparseHeader reports whether the block is the header of an old GNU sparse file
+func isGNUSparseHeader(block []byte) bool {
+	if block[156] != tar.TypeGNUSparse || string(block[257:265]) != "ustar  \x00" {
+		return false
+	}
+	chksum, err := parseNumeric(block[148:156])
+	if err != nil {
+		return false
+	}
+	var sum int64
+	for i, b := range block {
+		if i >= 148 && i < 156 {
+			b = ' '
+		}
+		sum += int64(b)
+	}
+	return sum == chksum
+}
+
+// readGNUSparseMap1x0 reads the map of a PAX 1.0 sparse file stored in the
+// blocks before dataOffset. The map only has digits and newlines padded with
+// NULs, which distinguishes it from the header before it.
+func readGNUSparseMap1x0(r io.ReaderAt, dataOffset int64) ([]int64, error) {
+	block := make([]byte, blockSize)
+	start := dataOffset
+	for start >= blockSize {
+		if _, err := r.ReadAt(block, start-blockSize); err != nil {
+			return nil, err
+		}
+		if bytes.IndexFunc(block, func(c rune) bool {
+			return (c < '0' || c > '9') && c != '\n' && c != 0
+		}) >= 0 {
+			break
+		}
+		start -= blockSize
+	}
+	if start == dataOffset {
+		return nil, errSparseHeader
+	}
+
+	data := make([]byte, dataOffset-start)
+	if _, err := r.ReadAt(data, start); err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(bytes.TrimRight(data, "\x00")), "\n")
+	count, err := strconv.ParseInt(lines[0], 10, 64)
+	if err != nil || count < 0 || int64(len(lines)) < 1+2*count {
+		return nil, errSparseHeader
+	}
+	return parseNumbers(lines[1 : 1+2*count])
+}
+
+func parseNumbers(fields []string) ([]int64, error) {
+	numbers := make([]int64, len(fields))
+	for i, field := range fields {
+		n, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, errSparseHeader
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+// parseNumeric parses an octal or base-256 number of a tar header field
+func parseNumeric(field []byte) (int64, error) {
+	if len(field) > 0 && field[0]&0x80 != 0 {
+		if field[0]&0x40 != 0 {
+			return 0, errSparseHeader // negative
+		}
+		n := int64(field[0] & 0x3f)
+		for _, b := range field[1:] {
+			if n > (1<<63-1)>>8 {
+				return 0, errSparseHeader
+			}
+			n = n<<8 | int64(b)
+		}
+		return n, nil
+	}
+
+	s := strings.Trim(string(field), " \x00")
+	if s == "" {
+		return 0, nil
+	}
+	n, err := strconv.ParseInt(s, 8, 64)
+	if err != nil {
+		return 0, errSparseHeader
+	}
+	return n, nil
+}
+
+// sparseReader reads a sparse file whose fragments are stored from offset in
+// the archive, filling holes with zeros
+type sparseReader struct {
+	r         io.ReaderAt
+	offset    int64
+	size      int64
+	fragments []fragment
+}
+
+func (s *sparseReader) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("tarfs: negative offset")
+	}
+
+	n := 0
+	for n < len(p) && off < s.size {
+		// The first fragment not ending before off
+		i := sort.Search(len(s.fragments), func(i int) bool {
+			f := s.fragments[i]
+			return f.offset+f.length > off
+		})
+
+		chunk := p[n:]
+		if i == len(s.fragments) || s.fragments[i].offset > off {
+			// Fill the hole until the next fragment
+			end := s.size
+			if i < len(s.fragments) {
+				end = s.fragments[i].offset
+			}
+			if int64(len(chunk)) > end-off {
+				chunk = chunk[:end-off]
+			}
+			clear(chunk)
+		} else {
+			f := s.fragments[i]
+			if int64(len(chunk)) > f.offset+f.length-off {
+				chunk = chunk[:f.offset+f.length-off]
+			}
+			m, err := s.r.ReadAt(chunk, s.offset+f.pos+off-f.offset)
+			if m < len(chunk) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return n + m, err
+			}
+		}
+		n += len(chunk)
+		off += int64(len(chunk))
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
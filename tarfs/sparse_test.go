@@ -0,0 +1,212 @@
+package tarfs_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/knqyf263/sou/tarfs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// sparseFragments is the data of the sparse test file. It takes more than
+// the 4 entries of an old GNU header.
+var sparseFragments = []struct {
+	offset int64
+	data   string
+}{
+	{0, "a"}, {1000, "b"}, {2000, "c"}, {3000, "d"}, {4000, "e"}, {5000, "fg"},
+}
+
+const sparseSize = 6000
+
+func sparseContent() []byte {
+	content := make([]byte, sparseSize)
+	for _, f := range sparseFragments {
+		copy(content[f.offset:], f.data)
+	}
+	return content
+}
+
+func sparseData() string {
+	var data strings.Builder
+	for _, f := range sparseFragments {
+		data.WriteString(f.data)
+	}
+	return data.String()
+}
+
+// rawHeader creates a header block. Archives with sparse files are written
+// by hand because tar.Writer doesn't support them.
+func rawHeader(name string, typeflag byte, size int64, magic string) []byte {
+	b := make([]byte, 512)
+	copy(b[0:], name)
+	copy(b[100:], "0000644\x00")
+	copy(b[108:], "0000000\x00")
+	copy(b[116:], "0000000\x00")
+	copy(b[124:], fmt.Sprintf("%011o\x00", size))
+	copy(b[136:], fmt.Sprintf("%011o\x00", 0))
+	b[156] = typeflag
+	copy(b[257:], magic)
+	return b
+}
+
+func setChecksum(b []byte) {
+	copy(b[148:156], "        ")
+	var sum int
+	for _, c := range b {
+		sum += int(c)
+	}
+	copy(b[148:], fmt.Sprintf("%06o\x00 ", sum))
+}
+
+func padBlock(data []byte) []byte {
+	if n := len(data) % 512; n != 0 {
+		data = append(data, make([]byte, 512-n)...)
+	}
+	return data
+}
+
+func paxHeader(records [][2]string) []byte {
+	var data []byte
+	for _, r := range records {
+		record := " " + r[0] + "=" + r[1] + "\n"
+		size := len(record)
+		size += len(strconv.Itoa(size + len(strconv.Itoa(size))))
+		data = append(data, strconv.Itoa(size)+record...)
+	}
+	hdr := rawHeader("PaxHeaders/db", 'x', int64(len(data)), "ustar\x0000")
+	setChecksum(hdr)
+	return append(hdr, padBlock(data)...)
+}
+
+// finishSparseTar appends a regular file and the end of the archive
+func finishSparseTar(archive []byte) []byte {
+	hdr := rawHeader("after.txt", '0', 5, "ustar\x0000")
+	setChecksum(hdr)
+	archive = append(archive, hdr...)
+	archive = append(archive, padBlock([]byte("after"))...)
+	return append(archive, make([]byte, 1024)...)
+}
+
+func oldGNUSparseTar() []byte {
+	numeric := func(b []byte, n int64) {
+		copy(b, fmt.Sprintf("%011o\x00", n))
+	}
+
+	data := sparseData()
+	hdr := rawHeader("db", 'S', int64(len(data)), "ustar  \x00")
+	ext := make([]byte, 512)
+	for i, f := range sparseFragments {
+		entry := hdr[386+i*24:]
+		if i >= 4 {
+			entry = ext[(i-4)*24:]
+		}
+		numeric(entry[:12], f.offset)
+		numeric(entry[12:24], int64(len(f.data)))
+	}
+	hdr[482] = 1 // extended
+	numeric(hdr[483:495], sparseSize)
+	setChecksum(hdr)
+
+	archive := append(hdr, ext...)
+	archive = append(archive, padBlock([]byte(data))...)
+	return finishSparseTar(archive)
+}
+
+func paxSparseTar(version string) []byte {
+	data := sparseData()
+	var sparseMap []string
+	for _, f := range sparseFragments {
+		sparseMap = append(sparseMap, strconv.FormatInt(f.offset, 10), strconv.Itoa(len(f.data)))
+	}
+
+	var records [][2]string
+	content := []byte(data)
+	switch version {
+	case "0.0":
+		records = append(records, [2]string{"GNU.sparse.size", strconv.Itoa(sparseSize)})
+		records = append(records, [2]string{"GNU.sparse.numblocks", strconv.Itoa(len(sparseFragments))})
+		for i := 0; i < len(sparseMap); i += 2 {
+			records = append(records, [2]string{"GNU.sparse.offset", sparseMap[i]}, [2]string{"GNU.sparse.numbytes", sparseMap[i+1]})
+		}
+	case "0.1":
+		records = [][2]string{
+			{"GNU.sparse.major", "0"},
+			{"GNU.sparse.minor", "1"},
+			{"GNU.sparse.name", "db"},
+			{"GNU.sparse.size", strconv.Itoa(sparseSize)},
+			{"GNU.sparse.numblocks", strconv.Itoa(len(sparseFragments))},
+			{"GNU.sparse.map", strings.Join(sparseMap, ",")},
+		}
+	case "1.0":
+		records = [][2]string{
+			{"GNU.sparse.major", "1"},
+			{"GNU.sparse.minor", "0"},
+			{"GNU.sparse.name", "db"},
+			{"GNU.sparse.realsize", strconv.Itoa(sparseSize)},
+		}
+		text := strconv.Itoa(len(sparseFragments)) + "\n" + strings.Join(sparseMap, "\n") + "\n"
+		content = append(padBlock([]byte(text)), data...)
+	}
+
+	name := "GNUSparseFile.0/db"
+	if version == "0.0" {
+		name = "db"
+	}
+	hdr := rawHeader(name, '0', int64(len(content)), "ustar\x0000")
+	setChecksum(hdr)
+
+	archive := append(paxHeader(records), hdr...)
+	archive = append(archive, padBlock(content)...)
+	return finishSparseTar(archive)
+}
+
+func TestSparseFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		archive []byte
+	}{
+		{name: "old GNU", archive: oldGNUSparseTar()},
+		{name: "PAX 0.0", archive: paxSparseTar("0.0")},
+		{name: "PAX 0.1", archive: paxSparseTar("0.1")},
+		{name: "PAX 1.0", archive: paxSparseTar("1.0")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tfs, err := tarfs.New(bytes.NewReader(tt.archive))
+			require.NoError(t, err)
+
+			info, err := tfs.Stat("db")
+			require.NoError(t, err)
+			assert.Equal(t, int64(sparseSize), info.Size())
+			assert.True(t, info.Mode().IsRegular(), "mode %v", info.Mode())
+
+			content, err := fs.ReadFile(tfs, "db")
+			require.NoError(t, err)
+			assert.Equal(t, sparseContent(), content)
+
+			// Reads across holes and fragments
+			f, err := tfs.Open("db")
+			require.NoError(t, err)
+			defer f.Close()
+			_, err = f.(io.Seeker).Seek(4999, io.SeekStart)
+			require.NoError(t, err)
+			buf := make([]byte, 4)
+			n, err := io.ReadFull(f, buf)
+			require.NoError(t, err)
+			assert.Equal(t, "\x00fg\x00", string(buf[:n]))
+
+			// The entries after the sparse file are indexed
+			after, err := fs.ReadFile(tfs, "after.txt")
+			require.NoError(t, err)
+			assert.Equal(t, "after", string(after))
+		})
+	}
+}
@@ -1,3 +1,6 @@
+// Package tarfs provides a read-only fs.FS over a tar archive. The archive
+// is indexed once by New, and file contents are read in place without
+// extracting them.
 package tarfs
 
 import (
@@ -6,26 +9,49 @@ import (
 	"io"
 	"io/fs"
 	"path"
+	"sort"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 )
 
+// maxSymlinks is the number of symbolic links followed while resolving a
+// path before it is considered a loop, as in Linux
+const maxSymlinks = 40
+
+// FS is a read-only fs.FS backed by a tar archive
 type FS struct {
-	reader  io.ReadSeeker
-	fileMap map[string]*Entry
+	reader   io.ReaderAt
+	fileMap  map[string]*Entry
+	shadowed map[string][]*Entry // earlier entries replaced by a later one with the same path
+	folded   map[string]*Entry   // entries by lowercase path, nil unless case-insensitive
 }
 
+// Header describes a file in the archive. It implements fs.FileInfo.
 type Header struct {
 	typeflag byte
 	name     string
+	base     string // last element of name, sliced from name to avoid extra allocations
 	linkname string
 	size     int64
 	mode     fs.FileMode
 	modTime  time.Time
+	uid      int
+	gid      int
+	uname    string
+	gname    string
+	xattrs   map[string]string
+	devmajor int64
+	devminor int64
+	implicit bool // directory missing from the archive
 }
 
 func (h *Header) Name() string {
-	return path.Base(h.name)
+	if h.base == "" {
+		return path.Base(h.name)
+	}
+	return h.base
 }
 
 func (h *Header) Size() int64 {
@@ -33,7 +59,7 @@ func (h *Header) Size() int64 {
 }
 
 func (h *Header) Mode() fs.FileMode {
-	return h.mode
+	return h.mode | h.Type()
 }
 
 func (h *Header) ModTime() time.Time {
@@ -71,14 +97,77 @@ func (h *Header) Sys() any {
 	return h
 }
 
+// Info implements fs.DirEntry so that Walk doesn't allocate an entry per file
+func (h *Header) Info() (fs.FileInfo, error) {
+	return h, nil
+}
+
+// Uid returns the user ID of the owner
+func (h *Header) Uid() int {
+	return h.uid
+}
+
+// Gid returns the group ID of the owner
+func (h *Header) Gid() int {
+	return h.gid
+}
+
+// Uname returns the user name of the owner, or "" if the archive doesn't have it
+func (h *Header) Uname() string {
+	return h.uname
+}
+
+// Gname returns the group name of the owner, or "" if the archive doesn't have it
+func (h *Header) Gname() string {
+	return h.gname
+}
+
+// Xattrs returns the extended attributes stored in PAX records, such as
+// security.capability. The values are raw bytes. The map must not be modified.
+func (h *Header) Xattrs() map[string]string {
+	return h.xattrs
+}
+
+// Devmajor returns the major device number of a character or block device
+func (h *Header) Devmajor() int64 {
+	return h.devmajor
+}
+
+// Devminor returns the minor device number of a character or block device
+func (h *Header) Devminor() int64 {
+	return h.devminor
+}
+
+// paxXattrPrefix is the prefix of PAX records holding extended attributes
+const paxXattrPrefix = "SCHILY.xattr."
+
+// xattrs extracts the extended attributes from PAX records
+func xattrs(records map[string]string) map[string]string {
+	var attrs map[string]string
+	for key, value := range records {
+		name, ok := strings.CutPrefix(key, paxXattrPrefix)
+		if !ok {
+			continue
+		}
+		if attrs == nil {
+			attrs = make(map[string]string)
+		}
+		attrs[name] = value
+	}
+	return attrs
+}
+
+// Entry is a file in the archive index
 type Entry struct {
 	Header   *Header
-	Offset   int64
-	Size     int64
+	Offset   int64 // offset of the data in the archive
+	Size     int64 // size of the file, which is larger than the data for sparse files
 	Children []*Entry
+	sparse   []fragment // data of sparse files, nil for other files
 }
 
-// readerAtWrapper wraps an io.ReadSeeker to implement io.ReaderAt
+// readerAtWrapper wraps an io.ReadSeeker to implement io.ReaderAt for readers
+// that don't implement it. Reads are serialized as they share the offset.
 type readerAtWrapper struct {
 	r  io.ReadSeeker
 	mu sync.Mutex // protects concurrent ReadAt calls
@@ -112,78 +201,329 @@ func (w *readerAtWrapper) ReadAt(p []byte, off int64) (n int, err error) {
 	return n, err
 }
 
-func New(reader io.ReadSeeker) (*FS, error) {
+// offsetReader tracks the current offset of the underlying reader so that
+// entry offsets can be recorded without a Seek syscall per header.
+type offsetReader struct {
+	r   io.ReadSeeker
+	pos int64
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	o.pos += int64(n)
+	return n, err
+}
+
+func (o *offsetReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := o.r.Seek(offset, whence)
+	if err == nil {
+		o.pos = pos
+	}
+	return pos, err
+}
+
+// node keeps an Entry and its Header in a single allocation
+type node struct {
+	entry  Entry
+	header Header
+}
+
+// nodeSlabSize is the number of nodes allocated at once while indexing
+const nodeSlabSize = 1024
+
+// IndexProgress is the progress of indexing an archive
+type IndexProgress struct {
+	Entries int   // number of entries indexed
+	Bytes   int64 // number of bytes of the archive read
+}
+
+// progressEntries is the number of entries indexed between two progress reports
+const progressEntries = 1024
+
+// Option configures New
+type Option func(*options)
+
+type options struct {
+	progress        func(IndexProgress)
+	partial         bool
+	caseInsensitive bool
+}
+
+// WithProgress sets a callback receiving the progress while the archive is
+// indexed. It is called periodically and once indexing has finished.
+func WithProgress(progress func(IndexProgress)) Option {
+	return func(o *options) {
+		if progress != nil {
+			o.progress = progress
+		}
+	}
+}
+
+// WithPartial makes New return the FS of the entries indexed so far along
+// with the error when the archive can't be read to the end, such as when it
+// is truncated.
+func WithPartial() Option {
+	return func(o *options) {
+		o.partial = true
+	}
+}
+
+// WithCaseInsensitive makes paths that don't exist as written match files
+// whose paths differ only in case, as in layers built on Windows. When
+// several files match, the first one in lexical order is used.
+func WithCaseInsensitive() Option {
+	return func(o *options) {
+		o.caseInsensitive = true
+	}
+}
+
+// New indexes the tar archive read from reader. The reader must stay open
+// while the FS is in use. If reader implements io.ReaderAt, as *os.File and
+// *bytes.Reader do, files are read concurrently without locking.
+func New(reader io.ReadSeeker, opts ...Option) (*FS, error) {
+	o := &options{progress: func(IndexProgress) {}}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	readerAt, ok := reader.(io.ReaderAt)
+	if !ok {
+		readerAt = &readerAtWrapper{r: reader}
+	}
+
 	tarfs := &FS{
-		reader: reader,
+		reader: readerAt,
 		fileMap: map[string]*Entry{
 			// pseudo root
 			".": {
 				Header: &Header{
 					typeflag: tar.TypeDir,
+					name:     ".",
 					mode:     fs.ModeDir | fs.ModePerm,
+					implicit: true,
 				},
 			},
 		},
 	}
 
-	tr := tar.NewReader(reader)
-
+	start, err := reader.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	or := &offsetReader{r: reader, pos: start}
+	tr := tar.NewReader(or)
+
+	var (
+		slab    []node
+		entries int
+		readErr error
+	)
 	for {
 		hdr, err := tr.Next()
 		if err == io.EOF {
 			break
 		} else if err != nil {
-			return nil, err
+			if !o.partial {
+				return nil, err
+			}
+			readErr = err
+			break
 		}
 
-		// Get the current position
-		pos, err := reader.Seek(0, io.SeekCurrent)
+		switch hdr.Typeflag {
+		case tar.TypeXGlobalHeader, tar.TypeXHeader, tar.TypeGNULongName, tar.TypeGNULongLink:
+			// PAX and GNU records are merged into the next header by tar.Reader,
+			// except for global headers, which are not files
+			continue
+		}
+
+		entries++
+		if entries%progressEntries == 0 {
+			o.progress(IndexProgress{Entries: entries, Bytes: or.pos - start})
+		}
+
+		if len(slab) == 0 {
+			slab = make([]node, nodeSlabSize)
+		}
+		n := &slab[0]
+		slab = slab[1:]
+
+		filePath := cleanPath(hdr.Name)
+		linkname := hdr.Linkname
+		if hdr.Typeflag == tar.TypeLink {
+			// Hard link targets are paths in the archive, while symlink
+			// targets are kept as written for ReadLink
+			linkname = cleanPath(linkname)
+		}
+		typeflag := hdr.Typeflag
+		fragments, sparse, err := sparseMap(readerAt, hdr, or.pos)
 		if err != nil {
-			return nil, err
-		}
-
-		filePath := path.Clean(hdr.Name)
-		entry := &Entry{
-			Header: &Header{
-				typeflag: hdr.Typeflag,
-				name:     filePath,
-				linkname: hdr.Linkname,
-				size:     hdr.Size,
-				mode:     fs.FileMode(uint32(hdr.Mode)),
-				modTime:  hdr.ModTime.UTC(),
-			},
-			Offset: pos,
-			Size:   hdr.Size,
+			if !o.partial {
+				return nil, err
+			}
+			readErr = err
+			break
+		}
+		if sparse && typeflag == tar.TypeGNUSparse {
+			typeflag = tar.TypeReg
 		}
 
+		n.header = Header{
+			typeflag: typeflag,
+			name:     filePath,
+			base:     path.Base(filePath),
+			linkname: linkname,
+			size:     hdr.Size,
+			mode:     hdr.FileInfo().Mode(), // converts setuid, setgid and sticky bits
+			modTime:  hdr.ModTime.UTC(),
+			uid:      hdr.Uid,
+			gid:      hdr.Gid,
+			uname:    hdr.Uname,
+			gname:    hdr.Gname,
+			xattrs:   xattrs(hdr.PAXRecords),
+			devmajor: hdr.Devmajor,
+			devminor: hdr.Devminor,
+		}
+		n.entry = Entry{
+			Header: &n.header,
+			Offset: or.pos,
+			Size:   hdr.Size,
+			sparse: fragments,
+		}
+		entry := &n.entry
+
+		if existing, ok := tarfs.fileMap[filePath]; ok {
+			// The last entry wins as when the archive is extracted. It takes
+			// the place of the earlier one, which is kept for Shadowed.
+			n.header, *existing.Header = *existing.Header, n.header
+			n.entry.Offset, existing.Offset = existing.Offset, n.entry.Offset
+			n.entry.Size, existing.Size = existing.Size, n.entry.Size
+			n.entry.sparse, existing.sparse = existing.sparse, n.entry.sparse
+			if !existing.Header.IsDir() {
+				existing.Children = nil
+			}
+			if !n.header.implicit {
+				if tarfs.shadowed == nil {
+					tarfs.shadowed = make(map[string][]*Entry)
+				}
+				tarfs.shadowed[filePath] = append(tarfs.shadowed[filePath], entry)
+			}
+			continue
+		}
 		tarfs.fileMap[filePath] = entry
 
-		parentDir := path.Dir(filePath)
-		if parentEntry, exists := tarfs.fileMap[parentDir]; exists {
-			parentEntry.Children = append(parentEntry.Children, entry)
+		parentEntry := tarfs.dir(path.Dir(filePath))
+		parentEntry.Children = append(parentEntry.Children, entry)
+	}
+
+	o.progress(IndexProgress{Entries: entries, Bytes: or.pos - start})
+
+	// Sort children once so that directory listings don't need to sort on
+	// every ReadDir
+	for _, entry := range tarfs.fileMap {
+		if len(entry.Children) > 1 {
+			sortEntries(entry.Children)
 		}
 	}
+	if o.caseInsensitive {
+		tarfs.foldIndex()
+	}
+
+	return tarfs, readErr
+}
+
+// dir returns the entry of the directory name. Archives may omit parent
+// directories, so missing ones are added as implicit directories, which are
+// replaced if the archive has an entry for them later.
+func (tfs *FS) dir(name string) *Entry {
+	if entry, ok := tfs.fileMap[name]; ok {
+		return entry
+	}
+	entry := &Entry{
+		Header: &Header{
+			typeflag: tar.TypeDir,
+			name:     name,
+			base:     path.Base(name),
+			mode:     fs.ModeDir | 0o755,
+			implicit: true,
+		},
+	}
+	tfs.fileMap[name] = entry
+	parent := tfs.dir(path.Dir(name))
+	parent.Children = append(parent.Children, entry)
+	return entry
+}
+
+// foldIndex builds the index of entries by lowercase path
+func (tfs *FS) foldIndex() {
+	tfs.folded = make(map[string]*Entry, len(tfs.fileMap))
+	for name, entry := range tfs.fileMap {
+		key := strings.ToLower(name)
+		if existing, ok := tfs.folded[key]; !ok || name < existing.Header.name {
+			tfs.folded[key] = entry
+		}
+	}
+}
+
+// entry returns the entry of the cleaned path name. Without an exact match,
+// case-insensitive FSes look it up ignoring case.
+func (tfs *FS) entry(name string) (*Entry, bool) {
+	if entry, ok := tfs.fileMap[name]; ok {
+		return entry, true
+	}
+	if tfs.folded == nil {
+		return nil, false
+	}
+	entry, ok := tfs.folded[strings.ToLower(name)]
+	return entry, ok
+}
+
+// cleanPath converts a name in the archive to a path of the FS. Leading
+// slashes are dropped and ".." never leaves the root.
+func cleanPath(name string) string {
+	p := path.Clean("/" + name)
+	if p == "/" {
+		return "."
+	}
+	return p[1:]
+}
 
-	return tarfs, nil
+// sortEntries sorts entries by name
+func sortEntries(entries []*Entry) {
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Header.name < entries[j].Header.name
+	})
 }
 
+// Open implements fs.FS. Hard links are resolved to their targets, and
+// symbolic links are followed within the archive.
 func (tfs *FS) Open(name string) (fs.File, error) {
-	entry, ok := tfs.fileMap[name]
-	if !ok {
-		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	entry, err := tfs.lookup("open", name, true)
+	if err != nil {
+		return nil, err
 	}
 
 	if entry.Header.typeflag == tar.TypeLink {
 		// Resolve hard link to target file
 		linkname := entry.Header.linkname
-		targetEntry, ok := tfs.fileMap[linkname]
-		if !ok {
+		targetEntry, err := tfs.lookup("open", linkname, true)
+		if err != nil {
 			return nil, &fs.PathError{Op: "open", Path: name, Err: fmt.Errorf("target file %s not found", linkname)}
 		}
 		entry = targetEntry // Update entry to point to the target file
 	}
 
-	sr := io.NewSectionReader(&readerAtWrapper{r: tfs.reader}, entry.Offset, entry.Size)
+	var sr *io.SectionReader
+	if entry.sparse != nil {
+		sr = io.NewSectionReader(&sparseReader{
+			r:         tfs.reader,
+			offset:    entry.Offset,
+			size:      entry.Size,
+			fragments: entry.sparse,
+		}, 0, entry.Size)
+	} else {
+		sr = io.NewSectionReader(tfs.reader, entry.Offset, entry.Size)
+	}
 
 	return &File{
 		Header:   entry.Header,
@@ -192,6 +532,140 @@ func (tfs *FS) Open(name string) (fs.File, error) {
 	}, nil
 }
 
+// Stat implements fs.StatFS. Symbolic links are followed.
+func (tfs *FS) Stat(name string) (fs.FileInfo, error) {
+	entry, err := tfs.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Header, nil
+}
+
+// Lstat returns the file info of name without following a symbolic link
+// in its last element
+func (tfs *FS) Lstat(name string) (fs.FileInfo, error) {
+	entry, err := tfs.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return entry.Header, nil
+}
+
+// ReadLink returns the target of the symbolic link name as stored in the archive
+func (tfs *FS) ReadLink(name string) (string, error) {
+	entry, err := tfs.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if entry.Header.typeflag != tar.TypeSymlink {
+		return "", &fs.PathError{Op: "readlink", Path: name, Err: fs.ErrInvalid}
+	}
+	return entry.Header.linkname, nil
+}
+
+// lookup finds the entry of name, resolving symbolic links in its parent
+// directories. A symbolic link in the last element is followed if follow is
+// set. Absolute link targets are resolved from the root of the archive.
+func (tfs *FS) lookup(op, name string, follow bool) (*Entry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	// Fast path for paths without symbolic links
+	if entry, ok := tfs.entry(name); ok && (!follow || entry.Header.typeflag != tar.TypeSymlink) {
+		return entry, nil
+	}
+
+	links := 0
+	dir, rest := ".", name
+	for {
+		var elem string
+		elem, rest, _ = strings.Cut(rest, "/")
+		entry, ok := tfs.entry(path.Join(dir, elem))
+		if !ok {
+			return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+		}
+		current := entry.Header.name // as written in the archive if matched ignoring case
+
+		last := rest == ""
+		if entry.Header.typeflag != tar.TypeSymlink || last && !follow {
+			if last {
+				return entry, nil
+			}
+			dir = current
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return nil, &fs.PathError{Op: op, Path: name, Err: syscall.ELOOP}
+		}
+
+		// Continue with the rest of the path from the link target
+		target := entry.Header.linkname
+		if !path.IsAbs(target) {
+			target = path.Join(dir, target)
+		}
+		if !last {
+			target += "/" + rest
+		}
+		dir, rest = ".", cleanPath(target)
+	}
+}
+
+// Shadowed returns the earlier entries of name that were replaced by a later
+// entry with the same path, oldest first
+func (tfs *FS) Shadowed(name string) []*Entry {
+	return tfs.shadowed[name]
+}
+
+// Walk walks the file tree rooted at root like fs.WalkDir, but iterates the
+// index directly instead of opening and reading every directory. Entries are
+// visited in lexical order, and symbolic links are not followed.
+func (tfs *FS) Walk(root string, fn fs.WalkDirFunc) error {
+	entry, err := tfs.lookup("walk", root, true)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = tfs.walk(root, entry, fn)
+	}
+	if err == fs.SkipDir || err == fs.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// walk recursively walks the entry, as fs.WalkDir does
+func (tfs *FS) walk(name string, entry *Entry, fn fs.WalkDirFunc) error {
+	if err := fn(name, entry.Header, nil); err != nil || !entry.Header.IsDir() {
+		if err == fs.SkipDir && entry.Header.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	for _, child := range entry.Children {
+		if err := tfs.walk(path.Join(name, child.Header.Name()), child, fn); err != nil {
+			if err == fs.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFS. Entries are already sorted by name, so
+// this avoids the extra sort done by fs.ReadDir.
+func (tfs *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	f, err := tfs.Open(name)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+	return f.(*File).ReadDir(-1)
+}
+
+// File is an open file or directory of an FS
 type File struct {
 	*Header  // Implement fs.FileInfo
 	r        *io.SectionReader
@@ -236,14 +710,17 @@ func (f *File) ReadDir(n int) ([]fs.DirEntry, error) {
 	}
 
 	entries := make([]fs.DirEntry, n)
+	dirEntries := make([]DirEntry, n)
 	for i := 0; i < n; i++ {
-		entries[i] = &DirEntry{Header: f.children[f.readPos+i].Header}
+		dirEntries[i].Header = f.children[f.readPos+i].Header
+		entries[i] = &dirEntries[i]
 	}
 	f.readPos += n
 
 	return entries, nil
 }
 
+// DirEntry is a directory entry returned by ReadDir
 type DirEntry struct {
 	*Header
 }
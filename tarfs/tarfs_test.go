@@ -3,8 +3,12 @@ package tarfs_test
 import (
 	"archive/tar"
 	"bytes"
+	"fmt"
 	"io"
 	"io/fs"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"testing/fstest"
 	"time"
@@ -197,6 +201,532 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+// writeTar writes the given headers to a tar, using the content as the body of regular files
+func writeTar(t *testing.T, headers []*tar.Header, contents map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, hdr := range headers {
+		content := contents[hdr.Name]
+		hdr.Size = int64(len(content))
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func TestSymlink(t *testing.T) {
+	tarData := writeTar(t, []*tar.Header{
+		{Name: "usr/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "usr/bin/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "usr/bin/sh", Typeflag: tar.TypeReg, Mode: 0o755},
+		{Name: "bin", Typeflag: tar.TypeSymlink, Linkname: "usr/bin"},
+		{Name: "usr/bin/abs", Typeflag: tar.TypeSymlink, Linkname: "/usr/bin/sh"},
+		{Name: "usr/bin/up", Typeflag: tar.TypeSymlink, Linkname: "../../../bin/sh"},
+		{Name: "loop1", Typeflag: tar.TypeSymlink, Linkname: "loop2"},
+		{Name: "loop2", Typeflag: tar.TypeSymlink, Linkname: "loop1"},
+		{Name: "dangling", Typeflag: tar.TypeSymlink, Linkname: "nonexistent"},
+	}, map[string]string{
+		"usr/bin/sh": "#!/bin/sh",
+	})
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(t, err)
+
+	t.Run("open", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			path    string
+			want    string
+			wantErr error
+		}{
+			{name: "relative link in parent", path: "bin/sh", want: "#!/bin/sh"},
+			{name: "absolute link", path: "usr/bin/abs", want: "#!/bin/sh"},
+			{name: "link through a linked directory", path: "bin/abs", want: "#!/bin/sh"},
+			{name: "link escaping the root", path: "usr/bin/up", want: "#!/bin/sh"},
+			{name: "loop", path: "loop1", wantErr: syscall.ELOOP},
+			{name: "loop in parent", path: "loop1/sh", wantErr: syscall.ELOOP},
+			{name: "dangling link", path: "dangling", wantErr: fs.ErrNotExist},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				content, err := fs.ReadFile(tarFS, tt.path)
+				if tt.wantErr != nil {
+					require.ErrorIs(t, err, tt.wantErr)
+					return
+				}
+				require.NoError(t, err)
+				assert.Equal(t, tt.want, string(content))
+			})
+		}
+	})
+
+	t.Run("linked directory", func(t *testing.T) {
+		info, err := fs.Stat(tarFS, "bin")
+		require.NoError(t, err)
+		assert.True(t, info.IsDir())
+
+		entries, err := fs.ReadDir(tarFS, "bin")
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		assert.Equal(t, []string{"abs", "sh", "up"}, names)
+	})
+
+	t.Run("lstat", func(t *testing.T) {
+		info, err := tarFS.Lstat("bin")
+		require.NoError(t, err)
+		assert.Equal(t, fs.ModeSymlink, info.Mode().Type())
+
+		info, err = tarFS.Lstat("bin/abs")
+		require.NoError(t, err)
+		assert.Equal(t, fs.ModeSymlink, info.Mode().Type())
+
+		_, err = tarFS.Lstat("dangling")
+		require.NoError(t, err)
+	})
+
+	t.Run("readlink", func(t *testing.T) {
+		target, err := tarFS.ReadLink("bin")
+		require.NoError(t, err)
+		assert.Equal(t, "usr/bin", target)
+
+		target, err = tarFS.ReadLink("bin/abs")
+		require.NoError(t, err)
+		assert.Equal(t, "/usr/bin/sh", target)
+
+		_, err = tarFS.ReadLink("usr/bin/sh")
+		require.ErrorIs(t, err, fs.ErrInvalid)
+	})
+}
+
+func TestCaseInsensitive(t *testing.T) {
+	tarData := writeTar(t, []*tar.Header{
+		{Name: "Windows/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "Windows/System32/", Typeflag: tar.TypeDir, Mode: 0o755},
+		{Name: "Windows/System32/Drivers.TXT", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "Sys", Typeflag: tar.TypeSymlink, Linkname: "Windows/System32"},
+		{Name: "README", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "readme", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{
+		"Windows/System32/Drivers.TXT": "drivers",
+		"README":                       "upper",
+		"readme":                       "lower",
+	})
+
+	// Lookups are exact by default
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(t, err)
+	_, err = tarFS.Stat("windows/system32/drivers.txt")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+
+	tarFS, err = tarfs.New(bytes.NewReader(tarData), tarfs.WithCaseInsensitive())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{name: "exact", path: "Windows/System32/Drivers.TXT", want: "drivers"},
+		{name: "different case", path: "windows/SYSTEM32/drivers.txt", want: "drivers"},
+		{name: "link with different case", path: "sys/drivers.txt", want: "drivers"},
+		{name: "exact match wins", path: "readme", want: "lower"},
+		{name: "first match in lexical order", path: "ReadMe", want: "upper"},
+		{name: "missing", path: "windows/system", wantErr: fs.ErrNotExist},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			content, err := fs.ReadFile(tarFS, tt.path)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(content))
+		})
+	}
+
+	// Listings keep the names as written
+	entries, err := fs.ReadDir(tarFS, "windows/system32")
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "Drivers.TXT", entries[0].Name())
+}
+
+func TestDuplicateEntries(t *testing.T) {
+	oldTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newTime := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range []struct {
+		hdr     *tar.Header
+		content string
+	}{
+		{&tar.Header{Name: "file.txt", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: oldTime}, "old"},
+		{&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o755, ModTime: oldTime}, ""},
+		{&tar.Header{Name: "dir/file.txt", Typeflag: tar.TypeReg, Mode: 0o644, ModTime: oldTime}, "in dir"},
+		{&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0o700, ModTime: newTime}, ""},
+		{&tar.Header{Name: "file.txt", Typeflag: tar.TypeReg, Mode: 0o600, ModTime: newTime}, "new content"},
+	} {
+		f.hdr.Size = int64(len(f.content))
+		require.NoError(t, tw.WriteHeader(f.hdr))
+		_, err := tw.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+
+	tarFS, err := tarfs.New(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+
+	// The last entry is used
+	content, err := fs.ReadFile(tarFS, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "new content", string(content))
+
+	info, err := fs.Stat(tarFS, "file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, fs.FileMode(0o600), info.Mode())
+	assert.Equal(t, newTime, info.ModTime())
+
+	info, err = fs.Stat(tarFS, "dir")
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeDir|0o700, info.Mode())
+
+	// Paths are listed once, and a replaced directory keeps its children
+	entries, err := fs.ReadDir(tarFS, ".")
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "dir", entries[0].Name())
+	assert.Equal(t, "file.txt", entries[1].Name())
+
+	content, err = fs.ReadFile(tarFS, "dir/file.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "in dir", string(content))
+
+	// Earlier entries are kept
+	shadowed := tarFS.Shadowed("file.txt")
+	require.Len(t, shadowed, 1)
+	assert.Equal(t, int64(3), shadowed[0].Size)
+	assert.Equal(t, fs.FileMode(0o644), shadowed[0].Header.Mode())
+	assert.Equal(t, oldTime, shadowed[0].Header.ModTime())
+	assert.Empty(t, tarFS.Shadowed("dir/file.txt"))
+}
+
+func TestLongNames(t *testing.T) {
+	longDir := strings.Repeat("d", 120)
+	longName := longDir + "/" + strings.Repeat("f", 150) + ".txt"
+
+	tests := []struct {
+		name   string
+		format tar.Format
+	}{
+		{name: "PAX", format: tar.FormatPAX},
+		{name: "GNU", format: tar.FormatGNU},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			tw := tar.NewWriter(&buf)
+			if tt.format == tar.FormatPAX {
+				require.NoError(t, tw.WriteHeader(&tar.Header{
+					Typeflag:   tar.TypeXGlobalHeader,
+					Name:       "pax_global_header",
+					PAXRecords: map[string]string{"comment": "global"},
+					Format:     tar.FormatPAX,
+				}))
+			}
+			content := "long"
+			for _, hdr := range []*tar.Header{
+				{Name: longDir + "/", Typeflag: tar.TypeDir, Mode: 0o755, Format: tt.format},
+				{Name: longName, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content)), Format: tt.format},
+				{Name: "link", Typeflag: tar.TypeSymlink, Linkname: longName, Format: tt.format},
+			} {
+				require.NoError(t, tw.WriteHeader(hdr))
+				if hdr.Typeflag == tar.TypeReg {
+					_, err := tw.Write([]byte(content))
+					require.NoError(t, err)
+				}
+			}
+			require.NoError(t, tw.Close())
+
+			tarFS, err := tarfs.New(bytes.NewReader(buf.Bytes()))
+			require.NoError(t, err)
+
+			entries, err := fs.ReadDir(tarFS, ".")
+			require.NoError(t, err)
+			var names []string
+			for _, entry := range entries {
+				names = append(names, entry.Name())
+			}
+			assert.Equal(t, []string{longDir, "link"}, names)
+
+			got, err := fs.ReadFile(tarFS, longName)
+			require.NoError(t, err)
+			assert.Equal(t, content, string(got))
+
+			target, err := tarFS.ReadLink("link")
+			require.NoError(t, err)
+			assert.Equal(t, longName, target)
+		})
+	}
+}
+
+func TestOwnership(t *testing.T) {
+	tarData := writeTar(t, []*tar.Header{
+		{
+			Name:     "usr/bin/ping",
+			Typeflag: tar.TypeReg,
+			Mode:     0o755,
+			Uid:      0,
+			Gid:      0,
+			Uname:    "root",
+			Gname:    "root",
+			PAXRecords: map[string]string{
+				"SCHILY.xattr.security.capability": "\x01\x00\x00\x02",
+				"comment":                          "not an xattr",
+			},
+		},
+		{Name: "home/app/data", Typeflag: tar.TypeReg, Mode: 0o600, Uid: 1000, Gid: 1001},
+	}, nil)
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(t, err)
+
+	info, err := fs.Stat(tarFS, "usr/bin/ping")
+	require.NoError(t, err)
+	hdr, ok := info.Sys().(*tarfs.Header)
+	require.True(t, ok, "Sys() does not return *tarfs.Header")
+	assert.Equal(t, 0, hdr.Uid())
+	assert.Equal(t, 0, hdr.Gid())
+	assert.Equal(t, "root", hdr.Uname())
+	assert.Equal(t, "root", hdr.Gname())
+	assert.Equal(t, map[string]string{"security.capability": "\x01\x00\x00\x02"}, hdr.Xattrs())
+
+	info, err = fs.Stat(tarFS, "home/app/data")
+	require.NoError(t, err)
+	hdr = info.Sys().(*tarfs.Header)
+	assert.Equal(t, 1000, hdr.Uid())
+	assert.Equal(t, 1001, hdr.Gid())
+	assert.Empty(t, hdr.Uname())
+	assert.Nil(t, hdr.Xattrs())
+}
+
+func TestWalk(t *testing.T) {
+	tarData := createTestTar(t)
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(t, err)
+
+	walk := func(root string, skip string) ([]string, error) {
+		var paths []string
+		err := tarFS.Walk(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			paths = append(paths, p)
+			if p == skip {
+				if d.IsDir() {
+					return fs.SkipDir
+				}
+				return fs.SkipAll
+			}
+			return nil
+		})
+		return paths, err
+	}
+
+	tests := []struct {
+		name    string
+		root    string
+		skip    string
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "root",
+			root: ".",
+			want: []string{".", "dir1", "dir1/dir2", "dir1/dir2/file3.txt", "dir1/file2.txt", "file1.txt"},
+		},
+		{
+			name: "subdirectory",
+			root: "dir1",
+			want: []string{"dir1", "dir1/dir2", "dir1/dir2/file3.txt", "dir1/file2.txt"},
+		},
+		{
+			name: "skip dir",
+			root: ".",
+			skip: "dir1/dir2",
+			want: []string{".", "dir1", "dir1/dir2", "dir1/file2.txt", "file1.txt"},
+		},
+		{
+			name: "skip all",
+			root: ".",
+			skip: "dir1/dir2/file3.txt",
+			want: []string{".", "dir1", "dir1/dir2", "dir1/dir2/file3.txt"},
+		},
+		{
+			name:    "non-existent root",
+			root:    "nonexistent",
+			wantErr: fs.ErrNotExist,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := walk(tt.root, tt.skip)
+			if tt.wantErr != nil {
+				require.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+
+			// The result matches fs.WalkDir
+			var want []string
+			err = fs.WalkDir(tarFS, tt.root, func(p string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				want = append(want, p)
+				if p == tt.skip {
+					if d.IsDir() {
+						return fs.SkipDir
+					}
+					return fs.SkipAll
+				}
+				return nil
+			})
+			require.NoError(t, err)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestNewProgress(t *testing.T) {
+	var headers []*tar.Header
+	for i := 0; i < 2500; i++ {
+		headers = append(headers, &tar.Header{Name: fmt.Sprintf("file%05d", i), Typeflag: tar.TypeReg, Mode: 0o644})
+	}
+	tarData := writeTar(t, headers, nil)
+
+	var reports []tarfs.IndexProgress
+	_, err := tarfs.New(bytes.NewReader(tarData), tarfs.WithProgress(func(p tarfs.IndexProgress) {
+		reports = append(reports, p)
+	}))
+	require.NoError(t, err)
+
+	require.Len(t, reports, 3)
+	assert.Equal(t, 1024, reports[0].Entries)
+	assert.Equal(t, 2048, reports[1].Entries)
+	assert.Equal(t, tarfs.IndexProgress{Entries: 2500, Bytes: int64(len(tarData))}, reports[2])
+	for i := 1; i < len(reports); i++ {
+		assert.Greater(t, reports[i].Bytes, reports[i-1].Bytes)
+	}
+}
+
+func TestNewPartial(t *testing.T) {
+	tarData := createTestTar(t)
+	// Cut the archive in the middle of the last file
+	truncated := tarData[:len(tarData)-8]
+
+	_, err := tarfs.New(bytes.NewReader(truncated))
+	require.Error(t, err)
+
+	tarFS, err := tarfs.New(bytes.NewReader(truncated), tarfs.WithPartial())
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.NotNil(t, tarFS)
+
+	// Entries before the truncation can be used
+	content, err := fs.ReadFile(tarFS, "dir1/file2.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "Hello from dir1!", string(content))
+}
+
+func TestImplicitDirectories(t *testing.T) {
+	tarData := writeTar(t, []*tar.Header{
+		{Name: "z.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "a/b/c.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "a/", Typeflag: tar.TypeDir, Mode: 0o700},
+		{Name: "a/a.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+		{Name: "m.txt", Typeflag: tar.TypeReg, Mode: 0o644},
+	}, map[string]string{
+		"a/b/c.txt": "nested",
+	})
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(t, err)
+
+	listing := func(name string) []string {
+		entries, err := fs.ReadDir(tarFS, name)
+		require.NoError(t, err)
+		var names []string
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		return names
+	}
+
+	// Listings are sorted and contain every path once
+	assert.Equal(t, []string{"a", "m.txt", "z.txt"}, listing("."))
+	assert.Equal(t, []string{"a.txt", "b"}, listing("a"))
+	assert.Equal(t, []string{"c.txt"}, listing("a/b"))
+
+	content, err := fs.ReadFile(tarFS, "a/b/c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "nested", string(content))
+
+	// An entry for an implicit directory replaces it
+	info, err := fs.Stat(tarFS, "a")
+	require.NoError(t, err)
+	assert.Equal(t, fs.ModeDir|0o700, info.Mode())
+	assert.Empty(t, tarFS.Shadowed("a"))
+
+	info, err = fs.Stat(tarFS, "a/b")
+	require.NoError(t, err)
+	assert.True(t, info.IsDir())
+
+	require.NoError(t, fstest.TestFS(tarFS, "a/a.txt", "a/b/c.txt", "m.txt", "z.txt"))
+}
+
+func TestSpecialFiles(t *testing.T) {
+	tarData := writeTar(t, []*tar.Header{
+		{Name: "dev/null", Typeflag: tar.TypeChar, Mode: 0o666, Devmajor: 1, Devminor: 3},
+		{Name: "dev/sda", Typeflag: tar.TypeBlock, Mode: 0o660, Devmajor: 8, Devminor: 0},
+		{Name: "run/fifo", Typeflag: tar.TypeFifo, Mode: 0o600},
+		{Name: "usr/bin/su", Typeflag: tar.TypeReg, Mode: 0o4755},
+	}, nil)
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(t, err)
+
+	tests := []struct {
+		path      string
+		wantMode  fs.FileMode
+		wantMajor int64
+		wantMinor int64
+	}{
+		{"dev/null", fs.ModeDevice | fs.ModeCharDevice | 0o666, 1, 3},
+		{"dev/sda", fs.ModeDevice | 0o660, 8, 0},
+		{"run/fifo", fs.ModeNamedPipe | 0o600, 0, 0},
+		{"usr/bin/su", fs.ModeSetuid | 0o755, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			info, err := tarFS.Lstat(tt.path)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, info.Mode())
+
+			hdr := info.Sys().(*tarfs.Header)
+			assert.Equal(t, tt.wantMajor, hdr.Devmajor())
+			assert.Equal(t, tt.wantMinor, hdr.Devminor())
+		})
+	}
+}
+
 func TestFileRead(t *testing.T) {
 	content := "Hello, World!"
 
@@ -250,6 +780,59 @@ func TestFileReadDir(t *testing.T) {
 	}
 }
 
+// readSeeker hides the io.ReaderAt implementation of the underlying reader
+type readSeeker struct {
+	io.ReadSeeker
+}
+
+func TestConcurrentRead(t *testing.T) {
+	tarData := createTestTar(t)
+	want := map[string]string{
+		"file1.txt":           "Hello, World!",
+		"dir1/file2.txt":      "Hello from dir1!",
+		"dir1/dir2/file3.txt": "Hello from dir2!",
+	}
+
+	tests := []struct {
+		name   string
+		reader io.ReadSeeker
+	}{
+		{name: "ReaderAt", reader: bytes.NewReader(tarData)},
+		{name: "ReadSeeker", reader: readSeeker{bytes.NewReader(tarData)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tarFS, err := tarfs.New(tt.reader)
+			require.NoError(t, err)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 100*len(want))
+			for i := 0; i < 100; i++ {
+				for name, content := range want {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						got, err := fs.ReadFile(tarFS, name)
+						if err != nil {
+							errs <- err
+							return
+						}
+						if string(got) != content {
+							errs <- fmt.Errorf("%s: expected %q, got %q", name, content, got)
+						}
+					}()
+				}
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				t.Error(err)
+			}
+		})
+	}
+}
+
 func TestHeaderMethods(t *testing.T) {
 	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
 
@@ -297,3 +880,104 @@ func TestFSInterface(t *testing.T) {
 	)
 	require.NoError(t, err)
 }
+
+// createLargeTar creates a tar with the given number of directories, each holding filesPerDir files
+func createLargeTar(b *testing.B, dirs, filesPerDir int) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	defer tw.Close()
+
+	modTime := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for d := 0; d < dirs; d++ {
+		dir := fmt.Sprintf("dir%05d", d)
+		if err := tw.WriteHeader(&tar.Header{
+			Name:     dir,
+			Mode:     0o755,
+			ModTime:  modTime,
+			Typeflag: tar.TypeDir,
+		}); err != nil {
+			b.Fatal(err)
+		}
+		for f := 0; f < filesPerDir; f++ {
+			if err := tw.WriteHeader(&tar.Header{
+				Name:     fmt.Sprintf("%s/file%05d", dir, filesPerDir-f),
+				Mode:     0o644,
+				ModTime:  modTime,
+				Typeflag: tar.TypeReg,
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		b.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func BenchmarkNew(b *testing.B) {
+	tarData := createLargeTar(b, 100, 1000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := tarfs.New(bytes.NewReader(tarData)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkOpenDir(b *testing.B) {
+	tarData := createLargeTar(b, 100, 1000)
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dir, err := tarFS.Open(fmt.Sprintf("dir%05d", i%100))
+		if err != nil {
+			b.Fatal(err)
+		}
+		dir.Close()
+	}
+}
+
+func BenchmarkReadDir(b *testing.B) {
+	tarData := createLargeTar(b, 10, 10000)
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		entries, err := fs.ReadDir(tarFS, "dir00000")
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(entries) != 10000 {
+			b.Fatalf("unexpected number of entries: %d", len(entries))
+		}
+	}
+}
+
+func BenchmarkWalk(b *testing.B) {
+	tarData := createLargeTar(b, 100, 1000)
+	tarFS, err := tarfs.New(bytes.NewReader(tarData))
+	require.NoError(b, err)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var n int
+		err := tarFS.Walk(".", func(string, fs.DirEntry, error) error {
+			n++
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != 100*1000+100+1 {
+			b.Fatalf("unexpected number of entries: %d", n)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package ui
+
+import (
+	"fmt"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// SetAccessible simplifies the output for screen readers. It implies the
+// ASCII mode and no colors, draws no boxes or animations, marks the
+// selection with characters and describes the changes of views in the
+// status bar.
+func (m *Model) SetAccessible(accessible bool) {
+	m.accessible = accessible
+	if !accessible {
+		return
+	}
+	m.SetASCII(true)
+	m.SetNoColor(true)
+	m.markSelection()
+}
+
+// markSelection marks the selected layer with ">" in the accessible mode,
+// where it can't be told by its color
+func (m *Model) markSelection() {
+	if !m.accessible {
+		return
+	}
+	d := newListDelegate(m.theme)
+	marker, blank := lipgloss.Border{Left: ">"}, lipgloss.Border{Left: " "}
+	d.Styles.SelectedTitle = d.Styles.SelectedTitle.BorderStyle(marker)
+	d.Styles.SelectedDesc = d.Styles.SelectedDesc.BorderStyle(blank)
+	d.Styles.NormalTitle = d.Styles.NormalTitle.BorderStyle(blank)
+	d.Styles.NormalDesc = d.Styles.NormalDesc.BorderStyle(blank)
+	m.list.SetDelegate(d)
+}
+
+// boxBorder is the border of the help and popups, made of spaces in the
+// accessible mode
+func (m *Model) boxBorder() lipgloss.Border {
+	if m.accessible {
+		return lipgloss.HiddenBorder()
+	}
+	return lipgloss.RoundedBorder()
+}
+
+// tabLabel returns the label of a tab, with the active tab in brackets in
+// the accessible mode
+func (m *Model) tabLabel(i int) string {
+	if m.accessible && i == m.activeTab {
+		return "[" + m.tabs[i] + "]"
+	}
+	return m.tabs[i]
+}
+
+// percentView describes the progress of the layer loading in steps of 10%
+// so that screen readers don't announce every change
+func percentView(fraction float64) string {
+	return fmt.Sprintf("%d%%", int(fraction*10)*10)
+}
+
+// announce describes a change of view in the status bar in the accessible
+// mode, unless another message is shown
+func (m *Model) announce(format string, args ...any) tea.Cmd {
+	if !m.accessible || m.message != "" {
+		return nil
+	}
+	m.message = fmt.Sprintf(format, args...)
+	return hideMessageAfter(3 * time.Second)
+}
@@ -0,0 +1,45 @@
+package ui
+
+import (
+	"context"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+)
+
+type accountsMsg struct {
+	layer    *container.Layer
+	accounts *container.Accounts
+	err      error
+}
+
+// loadAccounts looks up the owners of the files of the layer opened in the
+// /etc/passwd and /etc/group of the layers below it, when the layer doesn't
+// have both files itself. Owners are shown with IDs until they are loaded.
+func (m *Model) loadAccounts() tea.Cmd {
+	n, _ := m.statusLayer()
+	layer := m.currentLayer
+	if n <= 1 || layer == nil || layer.MergedDiffIDs() != nil || layer.Accounts().Complete() {
+		return nil
+	}
+	image, top := m.image, layer.Accounts()
+	return func() tea.Msg {
+		accounts, err := image.StackedAccounts(context.Background(), n-1, top)
+		return accountsMsg{layer: layer, accounts: accounts, err: err}
+	}
+}
+
+// setAccounts shows the owners of the files with the names loaded, unless
+// another layer has been opened since
+func (m *Model) setAccounts(msg accountsMsg) tea.Cmd {
+	if msg.layer != m.currentLayer {
+		return nil
+	}
+	if msg.err != nil {
+		// The owners are still shown with the names of the layer itself
+		debug("Failed to load the accounts of the lower layers: %v", msg.err)
+		return nil
+	}
+	m.currentLayer.SetAccounts(msg.accounts)
+	return m.filepicker.Init()
+}
@@ -0,0 +1,53 @@
+package ui
+
+import "strings"
+
+// asciiGlyphs replaces the emoji and unicode glyphs of the UI, including the
+// ones drawn by the list, progress bar and spinner, with plain characters
+var asciiGlyphs = strings.NewReplacer(
+	// Tabs and messages
+	"📦 ", "",
+	"📄 ", "",
+	"⚙️  ", "",
+	"🚀 ", "",
+	"🏷️  ", "",
+	"📝 ", "",
+	"⏳ ", "",
+	"📋 ", "",
+	"❌ ", "",
+	"✓", "*",
+	// Key help
+	"↑/k", "k",
+	"↓/j", "j",
+	"←/h", "h",
+	"→/l", "l",
+	"enter/l/→", "enter/l",
+	"h/esc/←", "h/esc",
+	" • ", " | ",
+	"→", "->",
+	"←", "<-",
+	"↑", "^",
+	"↓", "v",
+	"•", "*",
+	"…", "...",
+	// Borders, progress bar and spinner
+	"│", "|",
+	"─", "-",
+	"╭", "+",
+	"╮", "+",
+	"╰", "+",
+	"╯", "+",
+	"█", "#",
+	"░", "-",
+	"∙", ".",
+	"●", "o",
+)
+
+// chrome returns the text of the UI itself, as opposed to the contents of
+// files, in plain characters in the ASCII mode
+func (m *Model) chrome(s string) string {
+	if !m.ascii {
+		return s
+	}
+	return asciiGlyphs.Replace(s)
+}
@@ -0,0 +1,123 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// blobHeadBytes is how much of a blob that isn't a tar archive is dumped
+const blobHeadBytes = 4 << 10
+
+type blobHeadMsg struct {
+	diffID string
+	head   []byte
+	err    error
+}
+
+// openBlob shows the layer that isn't a tar archive, such as a WebAssembly
+// module, by its type, size and digests with a hex dump of its first bytes
+// instead of listing its files
+func (m *Model) openBlob(layer container.Layer) tea.Cmd {
+	m.blob = &layer
+	m.blobHead = nil
+	m.blobErr = nil
+	m.mode = BlobMode
+	m.viewport = viewport.New(m.width-4, m.height-6)
+	m.viewport.SetContent(m.blobView())
+	return tea.Batch(readBlobHead(layer), m.announce("Showing the blob of the layer"))
+}
+
+// readBlobHead reads the first bytes of the blob in the background
+func readBlobHead(layer container.Layer) tea.Cmd {
+	return func() tea.Msg {
+		rc, err := layer.OpenBlob(context.Background())
+		if err != nil {
+			return blobHeadMsg{diffID: layer.DiffID, err: err}
+		}
+		defer rc.Close()
+		head, err := io.ReadAll(io.LimitReader(rc, blobHeadBytes))
+		return blobHeadMsg{diffID: layer.DiffID, head: head, err: err}
+	}
+}
+
+// showBlobHead adds the hex dump to the blob shown, unless another layer
+// has been opened since
+func (m *Model) showBlobHead(msg blobHeadMsg) {
+	if m.mode != BlobMode || m.blob == nil || m.blob.DiffID != msg.diffID {
+		return
+	}
+	m.blobHead, m.blobErr = msg.head, msg.err
+	m.viewport.SetContent(m.blobView())
+}
+
+// blobView renders the description of the blob shown in BlobMode
+func (m *Model) blobView() string {
+	l := m.blob
+	var b strings.Builder
+	fmt.Fprintf(&b, "Type:   %s\n", filepicker.SanitizeName(l.MediaType))
+	if l.Title != "" {
+		fmt.Fprintf(&b, "Title:  %s\n", filepicker.SanitizeName(l.Title))
+	}
+	fmt.Fprintf(&b, "Size:   %s\n", filepicker.FormatSize(l.Size, m.sizeFormat))
+	if l.Digest != "" {
+		fmt.Fprintf(&b, "Digest: %s\n", l.Digest)
+	}
+	fmt.Fprintf(&b, "DiffID: %s\n\n", l.DiffID)
+	b.WriteString("This layer is not a tar archive. Press x to export the blob.\n\n")
+	switch {
+	case m.blobErr != nil:
+		fmt.Fprintf(&b, "Failed to read the blob: %v\n", m.blobErr)
+	case m.blobHead == nil:
+		b.WriteString("Reading the blob...\n")
+	default:
+		b.WriteString(strings.Join(hexPreview(m.blobHead, m.width-4, len(m.blobHead)), "\n"))
+		if l.Size > int64(len(m.blobHead)) {
+			fmt.Fprintf(&b, "\n... first %s shown", filepicker.FormatSize(int64(len(m.blobHead)), m.sizeFormat))
+		}
+	}
+	return b.String()
+}
+
+// blobFileName returns the name of the exported blob: its title if it is a
+// valid file name on this system, or its digest otherwise
+func blobFileName(layer *container.Layer) string {
+	if name, err := localFileName(layer.Title); layer.Title != "" && err == nil {
+		return name
+	}
+	digest := layer.Digest
+	if digest == "" {
+		digest = layer.DiffID
+	}
+	return strings.ReplaceAll(digest, ":", "-")
+}
+
+// exportBlob writes the blob as stored by its source to the current
+// directory
+func exportBlob(ctx context.Context, layer container.Layer) tea.Cmd {
+	return func() tea.Msg {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return exportFileMsg{err: fmt.Errorf("failed to get current directory: %w", err)}
+		}
+		rc, err := layer.OpenBlob(ctx)
+		if err != nil {
+			return exportFileMsg{err: err}
+		}
+		defer rc.Close()
+
+		outputPath := filepath.Join(cwd, blobFileName(&layer))
+		if err := writeFileFrom(ctx, outputPath, rc); err != nil {
+			return exportFileMsg{err: fmt.Errorf("failed to write file: %w", err)}
+		}
+		return exportFileMsg{path: outputPath}
+	}
+}
@@ -0,0 +1,107 @@
+package ui
+
+import (
+	"context"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+type reposMsg struct {
+	repos []string
+	err   error
+}
+
+// NewBrowseModel creates the model listing the repositories of a registry,
+// or of a path of it like "ghcr.io/org", to pick one of their tags and open
+// the image. The registry must support the catalog API. opts are passed to
+// container.Open along with the pull policy.
+func NewBrowseModel(registry string, pullPolicy container.PullPolicy, opts ...container.Option) (Model, tea.Cmd) {
+	m := newModel(registry, pullPolicy, opts)
+	m.browse = strings.TrimSuffix(registry, "/")
+	return m, m.listRepos()
+}
+
+// listRepos lists the repositories of the registry browsed
+func (m *Model) listRepos() tea.Cmd {
+	m.mode = ReposMode
+	m.ref = m.browse
+	m.repos = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	registry, opts := m.browse, m.openOptions
+	return tea.Batch(func() tea.Msg {
+		repos, err := container.ListRepositories(ctx, registry, opts...)
+		return reposMsg{repos: repos, err: err}
+	}, m.spinner.Tick)
+}
+
+// openRepos shows the repositories to pick from, or why they couldn't be
+// listed
+func (m *Model) openRepos(msg reposMsg) tea.Cmd {
+	if m.mode != ReposMode {
+		return nil
+	}
+	if msg.err != nil {
+		_, retryable := describeError(msg.err, m.ref)
+		m.err = msg.err
+		m.mode = ErrorMode
+		m.retry = nil
+		if retryable {
+			m.retry = m.listRepos
+		}
+		return nil
+	}
+	// nil is kept for the repositories being listed
+	m.repos = append([]string{}, msg.repos...)
+	m.showRepos("")
+	return m.announce("%d repositories in %s, pick one to list its tags", len(msg.repos), filepicker.SanitizeName(m.browse))
+}
+
+// showRepos shows the list of repositories with the given one selected
+func (m *Model) showRepos(selected string) {
+	items := make([]labelItem, len(m.repos))
+	for i, repo := range m.repos {
+		items[i] = labelItem{key: repo}
+	}
+	m.table = newLabelList(items, "repository", "repositories", m.width-4, m.height-6, m.theme)
+	m.table.Styles.NoItems = m.table.Styles.NoItems.SetString("The registry has no repositories")
+	for i, repo := range m.repos {
+		if repo == selected {
+			m.table.Select(i)
+		}
+	}
+}
+
+// pickRepo lists the tags of the repository
+func (m *Model) pickRepo(repo string) tea.Cmd {
+	host, _, _ := strings.Cut(m.browse, "/")
+	m.ref = host + "/" + repo
+	return m.listTags()
+}
+
+// backToRepos goes back from the tags of a repository to the repositories
+func (m *Model) backToRepos() tea.Cmd {
+	if m.cancel != nil {
+		// The tags may still be listed
+		m.cancel()
+	}
+	host, _, _ := strings.Cut(m.browse, "/")
+	repo := strings.TrimPrefix(m.ref, host+"/")
+	m.mode = ReposMode
+	m.ref = m.browse
+	m.tags = nil
+	m.showRepos(repo)
+	return nil
+}
+
+// pickerBack returns how to go back from the list of tags, which is to the
+// repositories when a registry is browsed
+func (m *Model) pickerBack() func() tea.Cmd {
+	if m.browse == "" {
+		return nil
+	}
+	return m.backToRepos
+}
@@ -0,0 +1,292 @@
+package ui
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/go-containerregistry/pkg/name"
+)
+
+// chordTimeout is how long the completions of a key sequence wait for the
+// next key
+const chordTimeout = 2 * time.Second
+
+type chordTimeoutMsg struct {
+	seq int
+}
+
+// chord is a key sequence such as yy. The sequence is the only key of the
+// binding.
+type chord struct {
+	binding key.Binding
+	run     func(m *Model) tea.Cmd
+}
+
+// chords returns the key sequences of the mode that start with prefix and
+// are longer than it
+func (m *Model) chords(prefix string) []chord {
+	var all []chord
+	switch m.mode {
+	case LayerMode:
+		all = []chord{
+			{m.keys.copyDiffID, (*Model).copySelectedDiffID},
+			{m.keys.copyDigest, (*Model).copySelectedDigest},
+			{m.keys.copyCommand, (*Model).copySelectedCommand},
+		}
+	case FileMode:
+		if m.currentLayer != nil && m.currentLayer.MergedDiffIDs() != nil {
+			// Layers viewed together have no diff ID, digest or command
+			all = []chord{
+				{m.keys.copyPath, (*Model).copySelectedPath},
+				{m.keys.copyFullPath, (*Model).copySelectedFullPath},
+			}
+			break
+		}
+		all = []chord{
+			{m.keys.copyDiffID, (*Model).copyLayerDiffID},
+			{m.keys.copyDigest, (*Model).copyLayerDigest},
+			{m.keys.copyCommand, (*Model).copyLayerCommand},
+			{m.keys.copyPath, (*Model).copySelectedPath},
+			{m.keys.copyFullPath, (*Model).copySelectedFullPath},
+		}
+	case CommandMode:
+		all = []chord{{m.keys.copyCommand, (*Model).copyShownCommand}}
+	case ManifestMode, ConfigMode:
+		all = []chord{
+			{m.keys.copyPath, (*Model).copyJSONPath},
+			{m.keys.copyValue, (*Model).copyJSONValue},
+			{m.keys.copyImageID, (*Model).copyImageID},
+			{m.keys.copyManifestDigest, (*Model).copyManifestDigest},
+		}
+	case RuntimeMode:
+		all = []chord{
+			{m.keys.copyImageID, (*Model).copyImageID},
+			{m.keys.copyManifestDigest, (*Model).copyManifestDigest},
+		}
+	case LabelsMode, AnnotationsMode:
+		all = []chord{
+			{m.keys.copyValue, (*Model).copyLabelValue},
+			{m.keys.copyLabel, (*Model).copyLabel},
+		}
+	}
+
+	if m.image != nil && m.mode != ErrorMode {
+		all = append(all,
+			chord{m.keys.copyPullCommand, (*Model).copyPullCommand},
+			chord{m.keys.copyRunCommand, (*Model).copyRunCommand},
+		)
+	}
+
+	var chords []chord
+	for _, c := range all {
+		if seq := c.binding.Keys()[0]; len(seq) > len(prefix) && strings.HasPrefix(seq, prefix) {
+			chords = append(chords, c)
+		}
+	}
+	return chords
+}
+
+// copySelectedDiffID copies the diff ID of the selected layer
+func (m *Model) copySelectedDiffID() tea.Cmd {
+	item, ok := m.list.SelectedItem().(layerItem)
+	if !ok {
+		return nil
+	}
+	return copyToClipboard("diff ID", item.diffID)
+}
+
+// copyLayerDiffID copies the diff ID of the layer being browsed
+func (m *Model) copyLayerDiffID() tea.Cmd {
+	if m.currentLayer == nil {
+		return nil
+	}
+	return copyToClipboard("diff ID", m.currentLayer.DiffID)
+}
+
+// copySelectedDigest copies the blob digest of the selected layer
+func (m *Model) copySelectedDigest() tea.Cmd {
+	item, ok := m.list.SelectedItem().(layerItem)
+	if !ok {
+		return nil
+	}
+	return m.copyDigest(item.digest)
+}
+
+// copyLayerDigest copies the blob digest of the layer being browsed
+func (m *Model) copyLayerDigest() tea.Cmd {
+	if m.currentLayer == nil {
+		return nil
+	}
+	return m.copyDigest(m.currentLayer.Digest)
+}
+
+// copyDigest copies a blob digest, which is unknown for layers exported from
+// the Docker daemon
+func (m *Model) copyDigest(digest string) tea.Cmd {
+	if digest == "" {
+		m.message = "The digest of the layer is unknown for local images"
+		return hideMessageAfter(3 * time.Second)
+	}
+	return copyToClipboard("digest", digest)
+}
+
+// copySelectedCommand copies the command that created the selected layer
+func (m *Model) copySelectedCommand() tea.Cmd {
+	command, ok := m.selectedCommand()
+	if !ok {
+		return nil
+	}
+	return copyToClipboard("command", command)
+}
+
+// copyLayerCommand copies the command that created the layer being browsed
+func (m *Model) copyLayerCommand() tea.Cmd {
+	if m.currentLayer == nil {
+		return nil
+	}
+	return copyToClipboard("command", m.currentLayer.Command)
+}
+
+// copyImageID copies the image ID, the digest of the config
+func (m *Model) copyImageID() tea.Cmd {
+	id, err := m.image.ID()
+	if err != nil {
+		m.message = fmt.Sprintf("Failed to get image ID: %v", err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	return copyToClipboard("image ID", id)
+}
+
+// copyManifestDigest copies the digest of the image manifest
+func (m *Model) copyManifestDigest() tea.Cmd {
+	digest, err := m.image.ManifestDigest()
+	if err != nil {
+		m.message = fmt.Sprintf("Failed to get manifest digest: %v", err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	if digest == "" {
+		m.message = "The manifest digest is unknown for images built locally"
+		return hideMessageAfter(3 * time.Second)
+	}
+	return copyToClipboard("manifest digest", digest)
+}
+
+// pinnedReference returns the reference of the image pinned to the digest
+// of its manifest, like alpine@sha256:...
+func (m *Model) pinnedReference() (string, error) {
+	digest, err := m.image.ManifestDigest()
+	if err != nil {
+		return "", fmt.Errorf("failed to get manifest digest: %w", err)
+	}
+	if digest == "" {
+		return "", errors.New("the manifest digest is unknown for images built locally")
+	}
+	// The repository is kept as written, like alpine for docker.io/library/alpine
+	repo := m.ref
+	if ref, err := name.ParseReference(m.ref); err == nil {
+		switch r := ref.(type) {
+		case name.Tag:
+			repo = strings.TrimSuffix(m.ref, ":"+r.TagStr())
+		case name.Digest:
+			repo, _, _ = strings.Cut(m.ref, "@")
+		}
+	}
+	return repo + "@" + digest, nil
+}
+
+// copyPullCommand copies the docker pull command of the image pinned to its
+// digest
+func (m *Model) copyPullCommand() tea.Cmd {
+	ref, err := m.pinnedReference()
+	if err != nil {
+		m.message = fmt.Sprintf("Failed to pin the image: %v", err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	return copyToClipboard("pull command", "docker pull "+ref)
+}
+
+// copyRunCommand copies the docker run command starting a shell in the
+// image, pinned to its digest if known. The shell replaces the entrypoint.
+func (m *Model) copyRunCommand() tea.Cmd {
+	ref, err := m.pinnedReference()
+	if err != nil {
+		ref = m.ref
+	}
+	command := "docker run -it --rm " + ref + " sh"
+	if r, err := m.image.Runtime(); err == nil && len(r.Entrypoint) > 0 {
+		command = "docker run -it --rm --entrypoint sh " + ref
+	}
+	return copyToClipboard("run command", command)
+}
+
+// copyShownCommand copies the command shown in full
+func (m *Model) copyShownCommand() tea.Cmd {
+	return copyToClipboard("command", m.command)
+}
+
+// copySelectedPath copies the path of the selected file in the layer
+func (m *Model) copySelectedPath() tea.Cmd {
+	p, ok := m.filepicker.SelectedPath()
+	if !ok {
+		return nil
+	}
+	return copyToClipboard("path", p)
+}
+
+// copySelectedFullPath copies the absolute path of the selected file after
+// the image and layer it is in, like
+// "nginx:1.25 [layer 4 sha256:0123456789ab] /etc/nginx/nginx.conf", to tell
+// in a ticket which file it is
+func (m *Model) copySelectedFullPath() tea.Cmd {
+	p, ok := m.filepicker.SelectedPath()
+	if !ok {
+		return nil
+	}
+	layer := m.mergedLayers()
+	if n, l := m.statusLayer(); l != nil {
+		layer = fmt.Sprintf("layer %d %s", n, shortDigest(l.DiffID))
+	}
+	return copyToClipboard("path", fmt.Sprintf("%s [%s] %s", m.ref, layer, p))
+}
+
+// copyJSONPath copies the jq path of the selected value of the manifest or
+// config
+func (m *Model) copyJSONPath() tea.Cmd {
+	n := m.tree.Selected()
+	if n == nil {
+		return nil
+	}
+	return copyToClipboard("path", n.path)
+}
+
+// copyJSONValue copies the selected value of the manifest or config
+func (m *Model) copyJSONValue() tea.Cmd {
+	n := m.tree.Selected()
+	if n == nil {
+		return nil
+	}
+	return copyToClipboard("value", n.value())
+}
+
+// chordHints renders the completions of the pending key sequence, like
+// "y  copy diff ID" and "p  copy path" after y
+func (m *Model) chordHints() string {
+	keyStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	descStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Normal))
+
+	lines := []string{lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed)).Render(m.pendingKey + "…")}
+	for _, c := range m.chords(m.pendingKey) {
+		next := strings.TrimPrefix(c.binding.Keys()[0], m.pendingKey)
+		lines = append(lines, keyStyle.Render(next)+"  "+descStyle.Render(c.binding.Help().Desc))
+	}
+	return lipgloss.NewStyle().
+		Border(m.boxBorder()).
+		BorderForeground(lipgloss.Color(m.theme.Dimmed)).
+		Padding(0, 1).
+		Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,178 @@
+package ui
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// formatCommand turns the created_by string of a layer back into the
+// Dockerfile instructions it comes from. BuildKit records RUN instructions
+// as "RUN /bin/sh -c apk add curl # buildkit" and prefixes them with the
+// build arguments they use, as in "RUN |2 A=1 B=2 /bin/sh -c make". The
+// legacy builder records the other instructions as
+// "/bin/sh -c #(nop)  CMD [\"sh\"]". Chains of commands are broken at && to
+// make long RUN instructions readable.
+func formatCommand(createdBy string) string {
+	s := strings.TrimSpace(sanitizeCommand(createdBy))
+	s = strings.TrimSpace(strings.TrimSuffix(s, "# buildkit"))
+	if s == "" || s == "N/A" {
+		return s
+	}
+
+	instruction := "RUN"
+	if rest, ok := strings.CutPrefix(s, "RUN "); ok {
+		s = rest
+	}
+
+	// Build arguments, like "|2 A=1 B=2 /bin/sh -c make"
+	var args []string
+	if rest, ok := strings.CutPrefix(s, "|"); ok {
+		fields := strings.SplitN(rest, " ", 2)
+		if n, err := strconv.Atoi(fields[0]); err == nil && len(fields) == 2 {
+			s = fields[1]
+			for i := 0; i < n; i++ {
+				arg, rest, _ := strings.Cut(s, " ")
+				args = append(args, "ARG "+arg)
+				s = rest
+			}
+		}
+	}
+
+	if rest, ok := strings.CutPrefix(s, "/bin/sh -c "); ok {
+		s = strings.TrimSpace(rest)
+		if nop, ok := strings.CutPrefix(s, "#(nop)"); ok {
+			// Instructions that don't run a command, like CMD or ENV
+			instruction, s = "", strings.TrimSpace(nop)
+		}
+	} else if !strings.HasPrefix(createdBy, "RUN ") {
+		// Neither a shell command nor a recorded instruction, like the
+		// entries of images built by other tools
+		instruction = ""
+	}
+
+	if instruction != "" {
+		// Heredocs are kept as they are
+		if !strings.Contains(s, "\n") {
+			s = strings.ReplaceAll(s, " && ", " \\\n    && ")
+		}
+		s = instruction + " " + s
+	}
+	return strings.Join(append(args, s), "\n")
+}
+
+// sanitizeCommand replaces the control characters of a command other than
+// newlines so that they cannot corrupt the terminal
+func sanitizeCommand(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n':
+			return r
+		case r == '\t':
+			return ' '
+		case !unicode.IsPrint(r):
+			return unicode.ReplacementChar
+		}
+		return r
+	}, strings.ToValidUTF8(s, string(unicode.ReplacementChar)))
+}
+
+// colorizeCommand adds ANSI color codes to the instructions returned by
+// formatCommand, in the colors of the JSON viewer: instructions as keys,
+// quoted strings as strings, variables as numbers, comments as literals and
+// shell operators as delimiters
+func colorizeCommand(command string, colors jsonColors) string {
+	var out strings.Builder
+	paint := func(color, text string) {
+		if color == "" {
+			out.WriteString(text)
+			return
+		}
+		out.WriteString(color + text + colors.reset)
+	}
+
+	for i, line := range strings.Split(command, "\n") {
+		if i > 0 {
+			out.WriteString("\n")
+		}
+
+		// The instruction starting the line, if any
+		if word, _, _ := strings.Cut(line, " "); isInstruction(word) {
+			paint(colors.key, word)
+			line = line[len(word):]
+		}
+
+		for j := 0; j < len(line); {
+			c := line[j]
+			switch {
+			case c == '"' || c == '\'':
+				end := j + 1
+				for end < len(line) && line[end] != c {
+					if c == '"' && line[end] == '\\' {
+						end++
+					}
+					end++
+				}
+				end = min(end+1, len(line))
+				paint(colors.str, line[j:end])
+				j = end
+			case c == '$':
+				end := j + 1
+				if end < len(line) && line[end] == '{' {
+					if k := strings.IndexByte(line[end:], '}'); k >= 0 {
+						end += k + 1
+					} else {
+						end = len(line)
+					}
+				} else {
+					for end < len(line) && (line[end] == '_' || isAlnum(line[end])) {
+						end++
+					}
+				}
+				paint(colors.number, line[j:end])
+				j = end
+			case c == '#' && (j == 0 || line[j-1] == ' '):
+				paint(colors.literal, line[j:])
+				j = len(line)
+			case strings.IndexByte("&|;\\", c) >= 0:
+				end := j + 1
+				if c != '\\' && end < len(line) && line[end] == c {
+					end++
+				}
+				paint(colors.delim, line[j:end])
+				j = end
+			default:
+				end := j + 1
+				for end < len(line) && strings.IndexByte("\"'$#&|;\\", line[end]) < 0 {
+					end++
+				}
+				out.WriteString(line[j:end])
+				j = end
+			}
+		}
+	}
+	return out.String()
+}
+
+// isInstruction reports whether word is a Dockerfile instruction
+func isInstruction(word string) bool {
+	switch word {
+	case "ADD", "ARG", "CMD", "COPY", "ENTRYPOINT", "ENV", "EXPOSE", "HEALTHCHECK", "LABEL",
+		"MAINTAINER", "ONBUILD", "RUN", "SHELL", "STOPSIGNAL", "USER", "VOLUME", "WORKDIR":
+		return true
+	}
+	return false
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+// commandView renders the full command of the selected layer, wrapped to the
+// width of the viewer
+func (m *Model) commandView() string {
+	text := colorizeCommand(formatCommand(m.command), m.jsonColors())
+	return lipgloss.NewStyle().Width(max(m.width-4, 20)).Render(text)
+}
@@ -0,0 +1,44 @@
+package ui
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// Config is the configuration file of sou, config.json in the sou directory
+// of the user config directory, such as ~/.config/sou/config.json
+type Config struct {
+	// Theme is the name of the theme, built-in or defined in Themes
+	Theme string `json:"theme"`
+	// Themes are user-defined themes by name. A theme has the fields of
+	// Theme and a "base" built-in theme providing the colors it doesn't set.
+	Themes map[string]json.RawMessage `json:"themes"`
+}
+
+// DefaultConfigPath returns the path of the config file
+func DefaultConfigPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config directory: %w", err)
+	}
+	return filepath.Join(dir, "sou", "config.json"), nil
+}
+
+// LoadConfig reads the config file at path. A missing file is an empty config.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return config, nil
+	} else if err != nil {
+		return config, fmt.Errorf("failed to read config file: %w", err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return config, nil
+}
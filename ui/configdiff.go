@@ -0,0 +1,131 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+type configDiffMsg struct {
+	ref     string
+	layers  container.Comparison
+	changes []container.ConfigChange
+	err     error
+}
+
+// configFieldNames are the names of the fields of the config in the
+// changelog, as in the runtime summary
+var configFieldNames = map[string]string{
+	"Entrypoint":   "Entrypoint",
+	"Cmd":          "Command",
+	"User":         "User",
+	"WorkingDir":   "Working dir",
+	"ExposedPorts": "Exposed",
+	"Volumes":      "Volumes",
+	"StopSignal":   "Stop signal",
+	"Env":          "Environment",
+	"Labels":       "Labels",
+	"History":      "History",
+}
+
+// compareConfig loads the image of ref and compares the config and the
+// layers of image with it
+func compareConfig(image *container.Image, ref string, opts []container.Option) tea.Cmd {
+	return func() tea.Msg {
+		other, err := container.Open(context.Background(), ref, opts...)
+		if err != nil {
+			return configDiffMsg{ref: ref, err: err}
+		}
+		defer other.Close()
+		changes, err := container.CompareConfig(image, other)
+		return configDiffMsg{ref: ref, layers: container.CompareLayers(image, other), changes: changes, err: err}
+	}
+}
+
+// openConfigDiff shows the changes of the config, if the runtime config is
+// still shown
+func (m *Model) openConfigDiff(msg configDiffMsg) tea.Cmd {
+	if m.mode != RuntimeMode {
+		return nil
+	}
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Failed to compare: %v", msg.err)
+		return hideMessageAfter(5 * time.Second)
+	}
+	m.message = ""
+	m.showDiff(m.formatConfigChanges(m.ref, msg.ref, msg.layers, msg.changes))
+	return m.announce("Comparing the config with %s", filepicker.SanitizeName(msg.ref))
+}
+
+// formatConfigChanges renders the changes as a changelog listing under each
+// field the values added with +, removed with - and changed with ~. The
+// layers come first, with the size of the base the images share and of the
+// layers each of them adds on it.
+func (m *Model) formatConfigChanges(from, to string, layers container.Comparison, changes []container.ConfigChange) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Changes of the config from %s to %s\n", filepicker.SanitizeName(from), filepicker.SanitizeName(to))
+
+	field := lipgloss.NewStyle().Bold(true)
+	added := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.JSONString))
+	removed := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Error))
+	modified := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	if m.noColor {
+		field, added, removed, modified = lipgloss.NewStyle(), lipgloss.NewStyle(), lipgloss.NewStyle(), lipgloss.NewStyle()
+	}
+	arrow := " → "
+	if m.ascii {
+		arrow = " -> "
+	}
+
+	if len(layers.Shared)+len(layers.OnlyA)+len(layers.OnlyB) > 0 {
+		uniqueA, uniqueB := layers.UniqueSize()
+		b.WriteString("\n" + field.Render("Layers") + "\n")
+		fmt.Fprintf(&b, "  = %s shared, %s\n", layerCount(len(layers.Shared)), m.formatSize(layers.SharedSize()))
+		b.WriteString(removed.Render(fmt.Sprintf("  - %s only in %s, %s", layerCount(len(layers.OnlyA)),
+			filepicker.SanitizeName(from), m.formatSize(uniqueA))) + "\n")
+		b.WriteString(added.Render(fmt.Sprintf("  + %s only in %s, %s", layerCount(len(layers.OnlyB)),
+			filepicker.SanitizeName(to), m.formatSize(uniqueB))) + "\n")
+	}
+	if len(changes) == 0 {
+		b.WriteString("\nThe configs are identical")
+		return b.String()
+	}
+
+	for i, c := range changes {
+		if i == 0 || changes[i-1].Field != c.Field {
+			b.WriteString("\n" + field.Render(configFieldNames[c.Field]) + "\n")
+		}
+		// Variables and labels are shown as key=value
+		prefix := ""
+		if c.Key != "" {
+			prefix = sanitizeValue(c.Key)
+			if c.Field == "Env" || c.Field == "Labels" {
+				prefix += "="
+			}
+		}
+		old, new := sanitizeValue(c.Old), sanitizeValue(c.New)
+		switch c.Kind {
+		case container.ChangeAdded:
+			b.WriteString(added.Render("  + "+prefix+new) + "\n")
+		case container.ChangeRemoved:
+			b.WriteString(removed.Render("  - "+prefix+old) + "\n")
+		case container.ChangeModified:
+			b.WriteString(modified.Render("  ~ "+prefix+old+arrow+new) + "\n")
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// layerCount returns the number of layers, such as "1 layer" or "2 layers"
+func layerCount(n int) string {
+	if n == 1 {
+		return "1 layer"
+	}
+	return fmt.Sprintf("%d layers", n)
+}
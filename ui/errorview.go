@@ -0,0 +1,130 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// errorCause guesses why the image couldn't be loaded
+func errorCause(err error) string {
+	var badName *name.ErrBadName
+	switch {
+	case errors.As(err, &badName):
+		return "typo, the image reference is not valid"
+	case errors.Is(err, container.ErrUnauthorized):
+		return "authentication, the registry denied access to the image"
+	case errors.Is(err, container.ErrNotFound):
+		return "typo, the image or tag doesn't exist"
+	case errors.Is(err, container.ErrRateLimited):
+		return "rate limit, the registry received too many requests"
+	case errors.Is(err, container.ErrUnsupportedMediaType):
+		return "the reference points to something else than a container image"
+	case isNetworkError(err):
+		return "network, the registry couldn't be reached"
+	case errors.Is(err, context.Canceled):
+		return "canceled, the pull was stopped before it finished"
+	default:
+		return "unknown"
+	}
+}
+
+// isNetworkError reports whether the request didn't reach the registry or
+// got no response, as opposed to being answered with an error
+func isNetworkError(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// updateError handles the keys of the error screen
+func (m *Model) updateError(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.editingRef {
+		switch msg.Type {
+		case tea.KeyCtrlC:
+			return m.quit()
+		case tea.KeyEsc:
+			m.editingRef = false
+			m.refInput.Blur()
+			return m, nil
+		case tea.KeyEnter:
+			ref := strings.TrimSpace(m.refInput.Value())
+			if ref == "" {
+				return m, nil
+			}
+			m.editingRef = false
+			m.refInput.Blur()
+			m.ref = ref
+			m.err = nil
+			m.retry = nil
+			return m, m.pullImage()
+		}
+		var cmd tea.Cmd
+		m.refInput, cmd = m.refInput.Update(msg)
+		return m, cmd
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.quit):
+		return m.quit()
+	case key.Matches(msg, m.keys.retry) && m.retry != nil:
+		m.err = nil
+		return m, m.retryCmd()
+	case key.Matches(msg, m.keys.editRef):
+		m.refInput = textinput.New()
+		m.refInput.Prompt = "Reference: "
+		m.refInput.PromptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+		m.refInput.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+		m.refInput.SetValue(m.ref)
+		m.editingRef = true
+		return m, m.refInput.Focus()
+	}
+	return m, nil
+}
+
+// errorView shows why the image couldn't be loaded and what to do about it
+func (m *Model) errorView() string {
+	if m.err == nil {
+		return ""
+	}
+	title := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.theme.Error)).
+		Render("Failed to load " + filepicker.SanitizeName(m.ref))
+	text := lipgloss.NewStyle().Width(max(m.width-padding*2, 20)).
+		Render(filepicker.SanitizeName(m.err.Error()))
+	label := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed))
+	hint, _ := describeError(m.err, m.ref)
+
+	lines := []string{
+		"",
+		title,
+		"",
+		text,
+		"",
+		label.Render("Likely cause: ") + errorCause(m.err),
+		label.Render("What to do:   ") + hint,
+	}
+	if m.editingRef {
+		lines = append(lines, "", m.refInput.View())
+	}
+	return lipgloss.NewStyle().PaddingLeft(padding).Render(strings.Join(lines, "\n"))
+}
+
+// errorHelp returns the keys of the error screen
+func (m *Model) errorHelp() string {
+	if m.editingRef {
+		return "enter load • esc cancel"
+	}
+	var keys []string
+	if m.retry != nil {
+		keys = append(keys, "r retry")
+	}
+	return strings.Join(append(keys, "e edit reference", "f12 log", "q quit"), " • ")
+}
@@ -0,0 +1,148 @@
+package ui
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/knqyf263/sou/container"
+)
+
+// event is a line of the machine-readable progress written by --progress json
+type event struct {
+	Time   time.Time `json:"time"`
+	Event  string    `json:"event"`
+	Image  string    `json:"image,omitempty"`
+	Layer  string    `json:"layer,omitempty"`
+	Blob   string    `json:"blob,omitempty"`
+	Path   string    `json:"path,omitempty"`
+	Bytes  int64     `json:"bytes,omitempty"`
+	Layers int       `json:"layers,omitempty"`
+	Exists bool      `json:"exists,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+// Events written to the event stream
+const (
+	eventPullStarted  = "pull_started"
+	eventBlobFetched  = "blob_fetched"
+	eventPullFinished = "pull_finished"
+	eventPullFailed   = "pull_failed"
+	eventLayerStarted = "layer_started"
+	eventLayerFetched = "layer_fetched"
+	eventIndexingDone = "indexing_done"
+	eventLayerFailed  = "layer_failed"
+	eventExportDone   = "export_finished"
+	eventExportFailed = "export_failed"
+)
+
+// EventWriter writes events as JSON lines. It is safe for concurrent use, as
+// progress is reported by the background jobs, and a nil EventWriter
+// discards the events.
+type EventWriter struct {
+	mu   sync.Mutex
+	enc  *json.Encoder
+	seen map[string]bool // blobs and layer stages already reported
+}
+
+// NewEventWriter returns an EventWriter writing to w, for the commands that
+// pull or export without the UI
+func NewEventWriter(w io.Writer) *EventWriter {
+	return &EventWriter{enc: json.NewEncoder(w), seen: make(map[string]bool)}
+}
+
+// SetEventWriter writes the progress of pulls, layer loads and exports to w
+// as JSON lines, for wrappers driving their own progress UI. It is called
+// before the pull started by NewModel runs.
+func (m *Model) SetEventWriter(w io.Writer) {
+	m.events = NewEventWriter(w)
+	if m.mode == PullingMode && m.reporter != nil {
+		m.reporter.events = m.events
+		m.events.PullStarted(m.ref)
+	}
+}
+
+// emit writes the event with the current time
+func (e *EventWriter) emit(ev event) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	ev.Time = time.Now()
+	_ = e.enc.Encode(ev)
+}
+
+// emitError writes the event with the error
+func (e *EventWriter) emitError(ev event, err error) {
+	ev.Error = err.Error()
+	e.emit(ev)
+}
+
+// PullStarted writes that the image is being pulled
+func (e *EventWriter) PullStarted(ref string) {
+	e.emit(event{Event: eventPullStarted, Image: ref})
+}
+
+// PullFinished writes that the image has been pulled, with its number of
+// layers
+func (e *EventWriter) PullFinished(ref string, layers int) {
+	e.emit(event{Event: eventPullFinished, Image: ref, Layers: layers})
+}
+
+// PullFailed writes that the image couldn't be pulled
+func (e *EventWriter) PullFailed(ref string, err error) {
+	e.emitError(event{Event: eventPullFailed, Image: ref}, err)
+}
+
+// ExportFinished writes that a file, archive or image has been written to
+// the path or destination
+func (e *EventWriter) ExportFinished(path string) {
+	e.emit(event{Event: eventExportDone, Path: path})
+}
+
+// ExportFailed writes that an export failed
+func (e *EventWriter) ExportFailed(err error) {
+	e.emitError(event{Event: eventExportFailed}, err)
+}
+
+// layerStarted writes that the layer is being loaded, which reports its
+// stages again
+func (e *EventWriter) layerStarted(layer string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	delete(e.seen, eventLayerFetched+layer)
+	delete(e.seen, eventIndexingDone+layer)
+	e.mu.Unlock()
+	e.emit(event{Event: eventLayerStarted, Layer: layer})
+}
+
+// Progress writes the events of the blobs fetched and the layer stages
+// finished, once each. It is a container.ProgressFunc.
+func (e *EventWriter) Progress(p container.Progress) {
+	if e == nil {
+		return
+	}
+	var ev event
+	switch {
+	case p.Blob != "" && (p.Exists || p.Total > 0 && p.Complete >= p.Total):
+		ev = event{Event: eventBlobFetched, Blob: p.Blob, Bytes: p.Total, Exists: p.Exists}
+	case p.Layer != "" && p.Stage == container.StageIndexing:
+		ev = event{Event: eventLayerFetched, Layer: p.Layer, Bytes: p.Total}
+	case p.Layer != "" && p.Stage == container.StageDone:
+		ev = event{Event: eventIndexingDone, Layer: p.Layer}
+	default:
+		return
+	}
+	key := ev.Event + ev.Blob + ev.Layer
+	e.mu.Lock()
+	seen := e.seen[key]
+	e.seen[key] = true
+	e.mu.Unlock()
+	if !seen {
+		e.emit(ev)
+	}
+}
@@ -0,0 +1,173 @@
+package ui
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of unchanged lines around the changes of a diff
+const diffContext = 3
+
+type fileDiffMsg struct {
+	path string
+	diff string
+	err  error
+}
+
+// startCompare opens the box asking for the image the viewed file or the
+// config is compared with, such as another tag of the image
+func (m *Model) startCompare() tea.Cmd {
+	m.compareInput = textinput.New()
+	m.compareInput.Prompt = "Compare with: "
+	m.compareInput.PromptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	m.compareInput.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	m.compareInput.SetValue(m.compareRef)
+	if m.compareRef == "" {
+		m.compareInput.SetValue(m.ref)
+	}
+	m.editingCompare = true
+	return m.compareInput.Focus()
+}
+
+// updateCompare handles the keys typed in the compare box. Enter loads the
+// image and compares the file with the same path in it, or its config in
+// RuntimeMode.
+func (m *Model) updateCompare(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.editingCompare = false
+		m.compareInput.Blur()
+		return m, nil
+	case tea.KeyEnter:
+		ref := strings.TrimSpace(m.compareInput.Value())
+		if ref == "" || m.mode == ViewMode && m.currentFile == nil {
+			return m, nil
+		}
+		m.editingCompare = false
+		m.compareInput.Blur()
+		m.compareRef = ref
+		opts := append([]container.Option{container.WithPullPolicy(m.pullPolicy)}, m.openOptions...)
+		if m.mode == RuntimeMode {
+			m.message = fmt.Sprintf("Loading %s to compare the config...", filepicker.SanitizeName(ref))
+			return m, compareConfig(m.image, ref, opts)
+		}
+		m.message = fmt.Sprintf("Loading %s to compare %s...", filepicker.SanitizeName(ref), m.statusPath())
+		return m, compareFile(m.image, m.ref, m.fileContent, ref, m.currentFile.Path, opts)
+	}
+	var cmd tea.Cmd
+	m.compareInput, cmd = m.compareInput.Update(msg)
+	return m, cmd
+}
+
+// compareFile loads the image of ref and diffs the file at path, with all of
+// its layers stacked, with the content of the file viewed in image, of
+// fromRef. The base the images share is only read if the layers the image
+// of ref adds on it don't have the file.
+func compareFile(image *container.Image, fromRef string, from []byte, ref, path string, opts []container.Option) tea.Cmd {
+	return func() tea.Msg {
+		ctx := context.Background()
+		other, err := container.Open(ctx, ref, opts...)
+		if err != nil {
+			return fileDiffMsg{path: path, err: err}
+		}
+		defer other.Close()
+
+		to, err := container.CompareLayers(image, other).ReadFile(ctx, path)
+		toName := ref + ":" + path
+		if errors.Is(err, fs.ErrNotExist) {
+			toName += " (missing)"
+		} else if err != nil {
+			return fileDiffMsg{path: path, err: err}
+		}
+		diff, err := unifiedDiff(from, to, fromRef+":"+path, toName)
+		return fileDiffMsg{path: path, diff: diff, err: err}
+	}
+}
+
+// unifiedDiff returns the diff of two files as diff -u shows it, or a note if
+// they are the same or binary
+func unifiedDiff(a, b []byte, aName, bName string) (string, error) {
+	switch {
+	case string(a) == string(b):
+		return "The files are identical", nil
+	case isBinary(a) || isBinary(b):
+		return fmt.Sprintf("The binary files %s and %s differ", aName, bName), nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        splitLines(a),
+		B:        splitLines(b),
+		FromFile: aName,
+		ToFile:   bName,
+		Context:  diffContext,
+	})
+}
+
+// splitLines splits content into lines keeping their newlines. Unlike
+// difflib.SplitLines, a final newline adds no empty line.
+func splitLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// openDiff shows the diff of the viewed file, if it is still viewed
+func (m *Model) openDiff(msg fileDiffMsg) tea.Cmd {
+	if m.mode != ViewMode || m.currentFile == nil || m.currentFile.Path != msg.path {
+		return nil
+	}
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Failed to compare: %v", msg.err)
+		return hideMessageAfter(5 * time.Second)
+	}
+	m.message = ""
+	m.showDiff(m.colorDiff(sanitizeCommand(msg.diff)))
+	return m.announce("Comparing %s with %s", m.statusPath(), filepicker.SanitizeName(m.compareRef))
+}
+
+// colorDiff colors the added and removed lines of a diff, and the headers of
+// its hunks
+func (m *Model) colorDiff(diff string) string {
+	if m.noColor {
+		return diff
+	}
+	added := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.JSONString))
+	removed := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Error))
+	hunk := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	lines := strings.Split(diff, "\n")
+	for i, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+			lines[i] = lipgloss.NewStyle().Bold(true).Render(line)
+		case strings.HasPrefix(line, "+"):
+			lines[i] = added.Render(line)
+		case strings.HasPrefix(line, "-"):
+			lines[i] = removed.Render(line)
+		case strings.HasPrefix(line, "@@"):
+			lines[i] = hunk.Render(line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// showDiff shows a diff in DiffMode, which goes back to the current mode
+func (m *Model) showDiff(content string) {
+	m.diffReturn = m.mode
+	m.diffViewport = m.viewport
+	m.mode = DiffMode
+	m.viewport = viewport.New(m.width-4, m.height-6)
+	m.viewport.SetContent(content)
+}
@@ -3,19 +3,34 @@
 // github.com/charmbracelet/bubbles/filepicker/
 // The original implementation has been modified to work with fs.FS interface
 // instead of the local filesystem.
+//
+// The file picker is a Bubble Tea model configured with options:
+//
+//	fp := filepicker.New(os.DirFS("/"), filepicker.WithHeight(20), filepicker.WithShowHidden(true))
+//
+// Its Init command loads the files of the current directory, and the host
+// model forwards the messages to Update. A FileSelectedMsg is sent when a
+// file is selected.
 
 package filepicker
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
 	"io/fs"
 	"log/slog"
+	"maps"
+	"os"
 	"os/exec"
-	"path/filepath"
+	"path"
 	"runtime"
-	"sort"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 
 	"github.com/charmbracelet/bubbles/key"
 	tea "github.com/charmbracelet/bubbletea"
@@ -23,17 +38,23 @@ import (
 	"github.com/dustin/go-humanize"
 )
 
-func debug(format string, v ...interface{}) {
-	slog.Debug(fmt.Sprintf(format, v...))
+// debug logs to the logger of the file picker, if any
+func (m *Model) debug(format string, v ...interface{}) {
+	if m.logger != nil {
+		m.logger.Debug(fmt.Sprintf(format, v...))
+	}
 }
 
 const (
-	marginBottom  = 5
 	fileSizeWidth = 7
+	modTimeWidth  = 16
+	isoTimeWidth  = 25 // with a time zone offset
 	paddingLeft   = 2
 )
 
-type keyMap struct {
+// KeyMap is the key bindings of the file picker. Keys are rebound with
+// key.Binding.SetKeys and disabled with key.Binding.SetEnabled(false).
+type KeyMap struct {
 	Up       key.Binding
 	Down     key.Binding
 	Left     key.Binding
@@ -48,11 +69,17 @@ type keyMap struct {
 	Toggle   key.Binding
 	Filter   key.Binding
 	Help     key.Binding
-	CopyPath key.Binding
+	Time     key.Binding
+	Sort     key.Binding
+	Reverse  key.Binding
+	Mark     key.Binding
+	Icons    key.Binding
+	Kind     key.Binding
 }
 
-func defaultKeyMap() keyMap {
-	return keyMap{
+// DefaultKeyMap returns the default key bindings, based on vim
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
 		Up: key.NewBinding(
 			key.WithKeys("up", "k"),
 			key.WithHelp("↑/k", "up"),
@@ -109,17 +136,38 @@ func defaultKeyMap() keyMap {
 			key.WithKeys("?"),
 			key.WithHelp("?", "toggle help"),
 		),
-		CopyPath: key.NewBinding(
-			key.WithKeys("y", "p"),
-			key.WithHelp("yp", "copy path"),
+		Time: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "toggle relative time"),
+		),
+		Sort: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "sort by name, size or time"),
+		),
+		Reverse: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "reverse sort order"),
+		),
+		Mark: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "mark file"),
+		),
+		Icons: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "toggle icons"),
+		),
+		Kind: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "show only a kind of files"),
 		),
 	}
 }
 
 type Model struct {
 	fs              fs.FS
-	keys            keyMap
+	keys            KeyMap
 	selectedIndex   int
+	offset          int // index of the first visible file shown
 	height          int
 	currentPath     string
 	files           []fs.DirEntry
@@ -131,12 +179,21 @@ type Model struct {
 	selectedAbsPath string
 	showPermissions bool
 	showSize        bool
+	showModTime     bool
+	showIcons       bool // icons of Nerd Fonts before the names
+	timeFormat      TimeFormat
+	absoluteFormat  TimeFormat // restored when relative times are toggled off
+	timeLocation    *time.Location
+	sizeFormat      SizeFormat
+	sortBy          sortKey
+	sortReverse     bool
+	marked          map[string]bool // absolute paths of the marked files
 	filterStr       string
 	filterMode      bool
+	kind            Kind // kind of the files shown besides directories
+	ignoreCase      bool
 	showHelp        bool
-	lastMessage     string
-	messageTimer    int
-	pendingKey      string
+	logger          *slog.Logger
 }
 
 type Styles struct {
@@ -148,11 +205,14 @@ type Styles struct {
 	Symlink        lipgloss.Style
 	Permission     lipgloss.Style
 	FileSize       lipgloss.Style
+	ModTime        lipgloss.Style
 	DisabledFile   lipgloss.Style
 	DisabledCursor lipgloss.Style
 	EmptyDirectory lipgloss.Style
 	Cursor         lipgloss.Style
 	Help           lipgloss.Style
+	FilterMatch    lipgloss.Style // part of the names matching the filter
+	Marked         lipgloss.Style // indicator of the marked files
 }
 
 func DefaultStyles() Styles {
@@ -165,27 +225,37 @@ func DefaultStyles() Styles {
 		Symlink:        lipgloss.NewStyle().Foreground(lipgloss.Color("36")),
 		Permission:     lipgloss.NewStyle().Foreground(lipgloss.Color("244")),
 		FileSize:       lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Width(fileSizeWidth).Align(lipgloss.Right),
+		ModTime:        lipgloss.NewStyle().Foreground(lipgloss.Color("240")).Width(modTimeWidth).Align(lipgloss.Right),
 		DisabledFile:   lipgloss.NewStyle().Foreground(lipgloss.Color("243")),
 		DisabledCursor: lipgloss.NewStyle().Foreground(lipgloss.Color("247")),
 		EmptyDirectory: lipgloss.NewStyle().Foreground(lipgloss.Color("240")).PaddingLeft(paddingLeft).SetString("No files found"),
 		Cursor:         lipgloss.NewStyle().Foreground(lipgloss.Color("212")),
 		Help:           lipgloss.NewStyle().Foreground(lipgloss.Color("240")),
+		FilterMatch:    lipgloss.NewStyle().Underline(true).Bold(true),
+		Marked:         lipgloss.NewStyle().Foreground(lipgloss.Color("212")).Bold(true),
 	}
 }
 
-func New(fsys fs.FS) Model {
-	return Model{
+// New returns a file picker of the files of fsys, starting in its root
+// directory
+func New(fsys fs.FS, opts ...Option) Model {
+	m := Model{
 		fs:              fsys,
-		keys:            defaultKeyMap(),
+		keys:            DefaultKeyMap(),
 		currentPath:     ".",
 		styles:          DefaultStyles(),
 		FileAllowed:     true,
 		DirAllowed:      true,
 		showPermissions: true,
 		showSize:        true,
+		showModTime:     true,
+		timeLocation:    time.UTC,
 		showHelp:        false,
-		pendingKey:      "",
 	}
+	for _, opt := range opts {
+		opt(&m)
+	}
+	return m
 }
 
 func (m *Model) Init() tea.Cmd {
@@ -196,6 +266,13 @@ func (m *Model) Init() tea.Cmd {
 
 type errMsg error
 
+// FileSelectedMsg is sent when a file is selected with the Select or Right
+// key
+type FileSelectedMsg struct {
+	Name string
+	Path string // absolute path, such as "/etc/passwd"
+}
+
 type filesLoadedMsg struct {
 	files     []fs.DirEntry
 	err       error
@@ -203,12 +280,12 @@ type filesLoadedMsg struct {
 }
 
 func (m *Model) loadFiles(focusPath string) tea.Msg {
-	debug("===== Loading Files Start =====")
-	debug("Loading files for path: %s", m.currentPath)
-	debug("Focus path: %s", focusPath)
-	debug("Current state:")
-	debug("- Selected index: %d", m.selectedIndex)
-	debug("- Show hidden: %v", m.showHidden)
+	m.debug("===== Loading Files Start =====")
+	m.debug("Loading files for path: %s", m.currentPath)
+	m.debug("Focus path: %s", focusPath)
+	m.debug("Current state:")
+	m.debug("- Selected index: %d", m.selectedIndex)
+	m.debug("- Show hidden: %v", m.showHidden)
 
 	if m.fs == nil {
 		return filesLoadedMsg{
@@ -218,7 +295,7 @@ func (m *Model) loadFiles(focusPath string) tea.Msg {
 
 	entries, err := fs.ReadDir(m.fs, m.currentPath)
 	if err != nil {
-		debug("Error reading directory: %v", err)
+		m.debug("Error reading directory: %v", err)
 		return filesLoadedMsg{
 			err: fmt.Errorf("failed to read directory: %w", err),
 		}
@@ -228,38 +305,28 @@ func (m *Model) loadFiles(focusPath string) tea.Msg {
 	for _, entry := range entries {
 		name := entry.Name()
 		if !m.showHidden && strings.HasPrefix(name, ".") {
-			debug("Skipping hidden file: %s", name)
+			m.debug("Skipping hidden file: %s", name)
 			continue
 		}
 		if entry.IsDir() && !m.DirAllowed {
-			debug("Skipping directory (not allowed): %s", name)
+			m.debug("Skipping directory (not allowed): %s", name)
 			continue
 		}
 		if !entry.IsDir() && !m.FileAllowed {
-			debug("Skipping file (not allowed): %s", name)
+			m.debug("Skipping file (not allowed): %s", name)
 			continue
 		}
 		files = append(files, entry)
 	}
 
-	sort.Slice(files, func(i, j int) bool {
-		// Directories come first
-		if files[i].IsDir() && !files[j].IsDir() {
-			return true
-		}
-		if !files[i].IsDir() && files[j].IsDir() {
-			return false
-		}
-		// Then sort by name
-		return files[i].Name() < files[j].Name()
-	})
+	m.sortFiles(files)
 
-	debug("Files loaded and sorted:")
-	debug("Total files found: %d", len(files))
+	m.debug("Files loaded and sorted:")
+	m.debug("Total files found: %d", len(files))
 	for i, file := range files {
-		debug("[%d] %s (isDir: %v)", i, file.Name(), file.IsDir())
+		m.debug("[%d] %s (isDir: %v)", i, file.Name(), file.IsDir())
 	}
-	debug("===== Loading Files End =====")
+	m.debug("===== Loading Files End =====")
 
 	return filesLoadedMsg{
 		files:     files,
@@ -267,33 +334,192 @@ func (m *Model) loadFiles(focusPath string) tea.Msg {
 	}
 }
 
+// sortKey decides what the files are sorted by
+type sortKey int
+
+const (
+	sortByName sortKey = iota // A to Z
+	sortBySize                // largest first
+	sortByTime                // newest first
+	numSortKeys
+)
+
+// sortFiles sorts directories first and then by the sort key. The order of
+// names is natural, so that file2 comes before file10.
+func (m *Model) sortFiles(files []fs.DirEntry) {
+	type entry struct {
+		file    fs.DirEntry
+		size    int64
+		modTime time.Time
+	}
+	entries := make([]entry, len(files))
+	for i, file := range files {
+		entries[i].file = file
+		if info, err := file.Info(); err == nil {
+			entries[i].size, entries[i].modTime = info.Size(), info.ModTime()
+		}
+	}
+
+	slices.SortStableFunc(entries, func(a, b entry) int {
+		if a.file.IsDir() != b.file.IsDir() {
+			if a.file.IsDir() {
+				return -1
+			}
+			return 1
+		}
+		var c int
+		switch m.sortBy {
+		case sortBySize:
+			c = cmp.Compare(b.size, a.size)
+		case sortByTime:
+			c = b.modTime.Compare(a.modTime)
+		}
+		if c == 0 {
+			c = compareNatural(a.file.Name(), b.file.Name())
+		}
+		if m.sortReverse {
+			return -c
+		}
+		return c
+	})
+	for i, e := range entries {
+		files[i] = e.file
+	}
+}
+
+// compareNatural compares names with the runs of digits in them compared as
+// numbers. Names equal as numbers, such as "01" and "1", are compared byte by
+// byte.
+func compareNatural(a, b string) int {
+	x, y := a, b
+	for x != "" && y != "" {
+		if isDigit(x[0]) && isDigit(y[0]) {
+			i, j := digits(x), digits(y)
+			nx, ny := strings.TrimLeft(x[:i], "0"), strings.TrimLeft(y[:j], "0")
+			if c := cmp.Compare(len(nx), len(ny)); c != 0 {
+				return c
+			}
+			if c := strings.Compare(nx, ny); c != 0 {
+				return c
+			}
+			x, y = x[i:], y[j:]
+			continue
+		}
+		if x[0] != y[0] {
+			return cmp.Compare(x[0], y[0])
+		}
+		x, y = x[1:], y[1:]
+	}
+	if c := cmp.Compare(len(x), len(y)); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+// digits returns the length of the run of digits at the start of s
+func digits(s string) int {
+	i := 0
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+// resort sorts the files again, keeping the selected file under the cursor
+func (m *Model) resort() {
+	var selected string
+	if visible := m.getVisibleFiles(); m.selectedIndex < len(visible) {
+		selected = visible[m.selectedIndex].Name()
+	}
+	m.files = slices.Clone(m.files)
+	m.sortFiles(m.files)
+	for i, file := range m.getVisibleFiles() {
+		if file.Name() == selected {
+			m.selectedIndex = i
+			break
+		}
+	}
+}
+
+// sortDescription describes the sort order, or returns "" for the default
+// order by name
+func (m Model) sortDescription() string {
+	orders := map[sortKey][2]string{
+		sortByName: {"", "name, Z to A"},
+		sortBySize: {"size, largest first", "size, smallest first"},
+		sortByTime: {"time, newest first", "time, oldest first"},
+	}
+	if m.sortReverse {
+		return orders[m.sortBy][1]
+	}
+	return orders[m.sortBy][0]
+}
+
 func (m *Model) getVisibleFiles() []fs.DirEntry {
-	if m.filterStr == "" || m.filterStr == "/" {
+	if !m.filtered() && m.kind == KindAll {
 		return m.files
 	}
-	filter := strings.ToLower(strings.TrimPrefix(m.filterStr, "/"))
 	var filtered []fs.DirEntry
 	for _, file := range m.files {
-		if strings.Contains(strings.ToLower(file.Name()), filter) {
+		if _, _, ok := m.filterMatch(file.Name()); ok && m.kind.match(file) {
 			filtered = append(filtered, file)
 		}
 	}
 	return filtered
 }
 
-func (m Model) getVisibleFilesLength() int {
-	return len(m.getVisibleFiles())
+// filtered reports whether a filter is applied
+func (m Model) filtered() bool {
+	return m.filterStr != "" && m.filterStr != "/"
+}
+
+// filterMatch returns the byte range of the first match of the filter in
+// name. Filters with uppercase letters are case-sensitive unless case is
+// ignored.
+func (m Model) filterMatch(name string) (start, end int, ok bool) {
+	filter := strings.TrimPrefix(m.filterStr, "/")
+	if m.ignoreCase || strings.ToLower(filter) == filter {
+		filter = strings.ToLower(filter)
+		if lower := strings.ToLower(name); len(lower) == len(name) {
+			name = lower
+		} else {
+			// The match can't be located in the original name
+			return 0, 0, strings.Contains(lower, filter)
+		}
+	}
+	i := strings.Index(name, filter)
+	if i < 0 {
+		return 0, 0, false
+	}
+	return i, i + len(filter), true
 }
 
-type tickMsg time.Time
+// FilterMatches returns the number of files matching the filter and the
+// kind of files shown, and of files in the directory. ok is false if all of
+// the files are shown.
+func (m Model) FilterMatches() (matches, total int, ok bool) {
+	if !m.filtered() && m.kind == KindAll {
+		return 0, 0, false
+	}
+	return len(m.getVisibleFiles()), len(m.files), true
+}
 
-func tick() tea.Cmd {
-	return tea.Tick(100*time.Millisecond, func(t time.Time) tea.Msg {
-		return tickMsg(t)
-	})
+func (m Model) getVisibleFilesLength() int {
+	return len(m.getVisibleFiles())
 }
 
 func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
+	m, cmd := m.update(msg)
+	// The window follows the cursor from where it was
+	m.offset, _ = m.window(m.getVisibleFilesLength())
+	return m, cmd
+}
+
+func (m Model) update(msg tea.Msg) (Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
@@ -331,45 +557,6 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			}
 		}
 
-		// Handle key sequences
-		if m.pendingKey != "" {
-			switch m.pendingKey {
-			case "y":
-				switch msg.String() {
-				case "p":
-					// Handle yp command
-					visibleFiles := m.getVisibleFiles()
-					if len(visibleFiles) == 0 {
-						m.pendingKey = ""
-						return m, nil
-					}
-					selected := visibleFiles[m.selectedIndex]
-					path := filepath.Join(m.currentPath, selected.Name())
-					if err := copyToClipboard(path); err != nil {
-						m.lastMessage = fmt.Sprintf("❌ Failed to copy path: %v", err)
-					} else {
-						m.lastMessage = "📋 Path copied to clipboard"
-					}
-					m.messageTimer = 30
-					m.pendingKey = ""
-					return m, tick()
-				default:
-					m.pendingKey = ""
-					return m, nil
-				}
-			default:
-				m.pendingKey = ""
-				return m, nil
-			}
-		}
-
-		// Handle initial key press
-		switch msg.String() {
-		case "y":
-			m.pendingKey = "y"
-			return m, nil
-		}
-
 		// Handle normal mode keys
 		switch {
 		case key.Matches(msg, m.keys.Help):
@@ -402,11 +589,19 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			if m.selectedIndex >= visibleLen {
 				m.selectedIndex = visibleLen - 1
 			}
+		case key.Matches(msg, m.keys.Back) && m.filtered():
+			// Esc clears the filter before going back
+			m.filterStr = ""
+			return m, nil
+		case key.Matches(msg, m.keys.Back) && m.kind != KindAll:
+			// and then shows all of the files
+			m.SetKind(KindAll)
+			return m, nil
 		case key.Matches(msg, m.keys.Left), key.Matches(msg, m.keys.Back):
 			if m.currentPath != "." {
 				// Get the current directory name before going up
-				currentBase := filepath.Base(m.currentPath)
-				parentPath := filepath.Dir(m.currentPath)
+				currentBase := path.Base(m.currentPath)
+				parentPath := path.Dir(m.currentPath)
 
 				// Normalize paths
 				if strings.HasPrefix(parentPath, "./") {
@@ -432,7 +627,7 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 			}
 			selected := visibleFiles[m.selectedIndex]
 			if selected.IsDir() {
-				newPath := filepath.Join(m.currentPath, selected.Name())
+				newPath := path.Join(m.currentPath, selected.Name())
 				m.currentPath = newPath
 				m.selectedIndex = 0
 				m.selectedFile = ""
@@ -443,9 +638,32 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 				}
 			} else if m.FileAllowed {
 				m.selectedFile = selected.Name()
-				m.selectedAbsPath = filepath.Join(m.currentPath, selected.Name())
-				return m, nil
+				m.selectedAbsPath = path.Join(m.currentPath, selected.Name())
+				msg := FileSelectedMsg{Name: m.selectedFile, Path: "/" + m.selectedAbsPath}
+				return m, func() tea.Msg { return msg }
 			}
+		case key.Matches(msg, m.keys.Time):
+			m.SetRelativeTime(m.timeFormat != TimeRelative)
+			return m, nil
+		case key.Matches(msg, m.keys.Icons):
+			m.showIcons = !m.showIcons
+			return m, nil
+		case key.Matches(msg, m.keys.Mark):
+			m.ToggleMark()
+			return m, nil
+		case key.Matches(msg, m.keys.Kind):
+			m.SetKind((m.kind + 1) % numKinds)
+			return m, nil
+		case key.Matches(msg, m.keys.Sort):
+			// Each key starts in the order that brings up the interesting files
+			m.sortBy = (m.sortBy + 1) % numSortKeys
+			m.sortReverse = false
+			m.resort()
+			return m, nil
+		case key.Matches(msg, m.keys.Reverse):
+			m.sortReverse = !m.sortReverse
+			m.resort()
+			return m, nil
 		case key.Matches(msg, m.keys.Toggle):
 			m.showHidden = !m.showHidden
 			return m, func() tea.Msg {
@@ -462,25 +680,25 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 
 	case filesLoadedMsg:
 		if msg.err != nil {
-			debug("Error in filesLoadedMsg: %v", msg.err)
+			m.debug("Error in filesLoadedMsg: %v", msg.err)
 			return m, nil
 		}
 
 		m.files = msg.files
 
-		debug("===== Files Loaded Message Processing Start =====")
-		debug("Current state:")
-		debug("- Current path: %s", m.currentPath)
-		debug("- Number of files: %d", len(m.files))
-		debug("- Current selected index: %d", m.selectedIndex)
-		debug("- Focus path: %s", msg.focusPath)
+		m.debug("===== Files Loaded Message Processing Start =====")
+		m.debug("Current state:")
+		m.debug("- Current path: %s", m.currentPath)
+		m.debug("- Number of files: %d", len(m.files))
+		m.debug("- Current selected index: %d", m.selectedIndex)
+		m.debug("- Focus path: %s", msg.focusPath)
 
 		// If focusPath is specified, try to find and focus on that directory
 		if msg.focusPath != "" {
 			for i, file := range m.files {
 				if file.Name() == msg.focusPath {
 					m.selectedIndex = i
-					debug("Found focus path at index: %d", i)
+					m.debug("Found focus path at index: %d", i)
 					break
 				}
 			}
@@ -489,34 +707,24 @@ func (m Model) Update(msg tea.Msg) (Model, tea.Cmd) {
 		// Ensure selected index is within bounds
 		if m.selectedIndex >= len(m.files) {
 			m.selectedIndex = len(m.files) - 1
-			debug("- Adjusted to last item: %d", m.selectedIndex)
+			m.debug("- Adjusted to last item: %d", m.selectedIndex)
 		}
 		if m.selectedIndex < 0 {
 			m.selectedIndex = 0
-			debug("- Adjusted to first item: %d", m.selectedIndex)
+			m.debug("- Adjusted to first item: %d", m.selectedIndex)
 		}
 
-		debug("Final state:")
-		debug("- Selected index: %d", m.selectedIndex)
+		m.debug("Final state:")
+		m.debug("- Selected index: %d", m.selectedIndex)
 		if m.selectedIndex < len(m.files) {
-			debug("- Selected file: %s", m.files[m.selectedIndex].Name())
+			m.debug("- Selected file: %s", m.files[m.selectedIndex].Name())
 		}
-		debug("===== Files Loaded Message Processing End =====")
+		m.debug("===== Files Loaded Message Processing End =====")
 
 		return m, nil
 
 	case errMsg:
 		return m, nil
-
-	case tickMsg:
-		if m.messageTimer > 0 {
-			m.messageTimer--
-			if m.messageTimer == 0 {
-				m.lastMessage = ""
-				return m, nil
-			}
-			return m, tick()
-		}
 	}
 
 	return m, tea.Batch(cmds...)
@@ -527,48 +735,71 @@ func (m Model) View() string {
 	var s strings.Builder
 
 	// Show current path and filter
-	s.WriteString(m.styles.Directory.Render(fmt.Sprintf("Directory: %s", m.currentPath)))
+	s.WriteString(m.styles.Directory.Render(fmt.Sprintf("Directory: %s", SanitizeName(m.currentPath))))
+	if order := m.sortDescription(); order != "" {
+		s.WriteString(m.styles.Help.Render(fmt.Sprintf("  (sorted by %s)", order)))
+	}
+	if m.kind != KindAll {
+		s.WriteString(m.styles.Help.Render(fmt.Sprintf("  (only %s)", m.kind)))
+	}
 	if m.filterStr != "" {
 		s.WriteString("\n")
-		s.WriteString(m.styles.File.Render(fmt.Sprintf("Filter: %s", m.filterStr)))
+		s.WriteString(m.styles.File.Render(fmt.Sprintf("Filter: %s", SanitizeName(m.filterStr))))
+		if matches, total, ok := m.FilterMatches(); ok {
+			s.WriteString(m.styles.Help.Render(fmt.Sprintf("  %d/%d matches", matches, total)))
+		}
 	}
 	s.WriteString("\n\n")
 
 	if len(visibleFiles) == 0 {
-		s.WriteString(m.styles.EmptyDirectory.String())
+		empty := m.styles.EmptyDirectory
+		if m.filtered() && len(m.files) > 0 {
+			// Tell an empty directory from a filter matching nothing
+			filter := SanitizeName(strings.TrimPrefix(m.filterStr, "/"))
+			empty = empty.SetString(fmt.Sprintf("No matches for '%s' (esc to clear)", filter))
+		} else if m.kind != KindAll && len(m.files) > 0 {
+			empty = empty.SetString(fmt.Sprintf("No %s here (esc to show all files)", m.kind))
+		}
+		s.WriteString(empty.String())
 		// Add padding for help text
 		s.WriteString(strings.Repeat("\n", m.height-6))
-		if m.lastMessage != "" {
-			s.WriteString("\n")
-			s.WriteString(m.styles.File.Render(m.lastMessage))
-		}
 		return s.String()
 	}
 
-	// Show files
-	for i, file := range visibleFiles {
-		if i >= m.selectedIndex-m.height+marginBottom && i <= m.selectedIndex+m.height-marginBottom {
-			s.WriteString(m.renderFile(file, i))
-			s.WriteString("\n")
-		}
+	// Only the files in the window are rendered, however many there are
+	start, end := m.window(len(visibleFiles))
+	for i := start; i < end; i++ {
+		s.WriteString(m.renderFile(visibleFiles[i], i))
+		s.WriteString("\n")
 	}
 
-	// Add a few blank lines after the file list
-	s.WriteString("\n")
+	// Pad the list to the height of the window, followed by a blank line
+	s.WriteString(strings.Repeat("\n", m.listHeight()-(end-start)+1))
 
-	// Show message if exists
-	if m.lastMessage != "" {
-		s.WriteString(m.styles.File.Render(m.lastMessage))
-		s.WriteString("\n")
-	}
+	return s.String()
+}
 
-	// Add remaining padding for help text
-	remainingLines := m.height - len(visibleFiles) - 6 // 6 for header, margins, and extra blank lines
-	if remainingLines > 0 {
-		s.WriteString(strings.Repeat("\n", remainingLines))
+// listHeight returns the number of files shown below the header and above
+// the blank line closing the list
+func (m Model) listHeight() int {
+	header := 2 // the directory and a blank line
+	if m.filterStr != "" {
+		header++
 	}
+	return max(m.height-header-1, 1)
+}
 
-	return s.String()
+// window returns the range of the visible files shown. It starts at the
+// offset and scrolls just enough to show the cursor, without leaving blank
+// lines at the bottom.
+func (m Model) window(total int) (start, end int) {
+	rows := m.listHeight()
+	start = min(m.offset, m.selectedIndex)
+	if m.selectedIndex >= start+rows {
+		start = m.selectedIndex - rows + 1
+	}
+	start = max(min(start, total-rows), 0)
+	return start, min(start+rows, total)
 }
 
 func (m Model) renderFile(file fs.DirEntry, index int) string {
@@ -577,7 +808,6 @@ func (m Model) renderFile(file fs.DirEntry, index int) string {
 		return ""
 	}
 
-	name := file.Name()
 	style := m.styles.Unselected
 	cursor := " "
 
@@ -594,18 +824,25 @@ func (m Model) renderFile(file fs.DirEntry, index int) string {
 
 	// Add permissions if enabled
 	if m.showPermissions {
-		line.WriteString(m.styles.Permission.Render(info.Mode().String()) + " ")
+		line.WriteString(m.styles.Permission.Render(formatMode(info.Mode())) + " ")
 	}
 
 	// Add size if enabled
 	if m.showSize {
-		size := humanize.Bytes(uint64(info.Size()))
-		line.WriteString(m.styles.FileSize.Render(size) + " ")
+		line.WriteString(m.styles.FileSize.Render(formatSize(info, m.sizeFormat)) + " ")
+	}
+
+	// Add modification time if enabled
+	if m.showModTime {
+		style := m.styles.ModTime
+		if m.timeFormat == TimeISO {
+			style = style.Width(isoTimeWidth)
+		}
+		line.WriteString(style.Render(m.formatModTime(info.ModTime())) + " ")
 	}
 
 	// Add name with appropriate style
 	if file.IsDir() {
-		name += "/"
 		if index == m.selectedIndex {
 			style = style.Inherit(m.styles.Directory)
 		} else {
@@ -619,7 +856,16 @@ func (m Model) renderFile(file fs.DirEntry, index int) string {
 		}
 	}
 
-	line.WriteString(style.Render(name))
+	if m.IsMarked("/" + path.Join(m.currentPath, file.Name())) {
+		line.WriteString(m.styles.Marked.Render("✓") + " ")
+	}
+	if m.showIcons {
+		line.WriteString(style.Render(fileIcon(file.Name(), info.Mode())) + " ")
+	}
+	line.WriteString(m.renderName(file.Name(), style))
+	if file.IsDir() {
+		line.WriteString(style.Render("/"))
+	}
 
 	// Add symlink indicator if it's a symlink
 	if info.Mode()&fs.ModeSymlink != 0 {
@@ -629,6 +875,123 @@ func (m Model) renderFile(file fs.DirEntry, index int) string {
 	return line.String()
 }
 
+// renderName renders a file name, highlighting the part matching the filter
+func (m Model) renderName(name string, style lipgloss.Style) string {
+	start, end, ok := m.filterMatch(name)
+	if !m.filtered() || !ok || start == end {
+		return style.Render(SanitizeName(name))
+	}
+	match := m.styles.FilterMatch.Inherit(style)
+	return style.Render(SanitizeName(name[:start])) +
+		match.Render(SanitizeName(name[start:end])) +
+		style.Render(SanitizeName(name[end:]))
+}
+
+// deviceInfo is implemented by file infos that have device numbers
+type deviceInfo interface {
+	Devmajor() int64
+	Devminor() int64
+}
+
+// ownerInfo is implemented by file infos that know their owners
+type ownerInfo interface {
+	Uid() int
+}
+
+// formatSize formats the size column. Devices show their major and minor
+// numbers, and other special files have no size.
+func formatSize(info fs.FileInfo, format SizeFormat) string {
+	mode := info.Mode()
+	switch {
+	case mode&fs.ModeDevice != 0:
+		if dev, ok := info.(deviceInfo); ok {
+			return fmt.Sprintf("%d, %d", dev.Devmajor(), dev.Devminor())
+		}
+		return "-"
+	case mode&(fs.ModeNamedPipe|fs.ModeSocket|fs.ModeIrregular) != 0:
+		return "-"
+	default:
+		return FormatSize(info.Size(), format)
+	}
+}
+
+// formatMode formats the mode like ls does, with a single character for the file type
+func formatMode(mode fs.FileMode) string {
+	b := []byte("----------")
+	switch {
+	case mode&fs.ModeDir != 0:
+		b[0] = 'd'
+	case mode&fs.ModeSymlink != 0:
+		b[0] = 'l'
+	case mode&fs.ModeCharDevice != 0:
+		b[0] = 'c'
+	case mode&fs.ModeDevice != 0:
+		b[0] = 'b'
+	case mode&fs.ModeNamedPipe != 0:
+		b[0] = 'p'
+	case mode&fs.ModeSocket != 0:
+		b[0] = 's'
+	}
+
+	const rwx = "rwxrwxrwx"
+	for i := 0; i < 9; i++ {
+		if mode&(1<<uint(8-i)) != 0 {
+			b[i+1] = rwx[i]
+		}
+	}
+
+	special := func(i int, set bool, c byte) {
+		if !set {
+			return
+		}
+		if b[i] == 'x' {
+			b[i] = c
+		} else {
+			b[i] = c - 'a' + 'A'
+		}
+	}
+	special(3, mode&fs.ModeSetuid != 0, 's')
+	special(6, mode&fs.ModeSetgid != 0, 's')
+	special(9, mode&fs.ModeSticky != 0, 't')
+
+	return string(b)
+}
+
+// SanitizeName escapes control characters, non-printable characters and
+// invalid UTF-8 in a file name so that it cannot corrupt the terminal.
+// Backslashes are escaped as well to keep the result unambiguous.
+func SanitizeName(name string) string {
+	safe := true
+	for _, r := range name {
+		if r == utf8.RuneError || r == '\\' || !unicode.IsPrint(r) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return name
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		switch {
+		case r == utf8.RuneError && size == 1:
+			fmt.Fprintf(&b, "\\x%02x", name[i])
+		case r == '\\':
+			b.WriteString(`\\`)
+		case unicode.IsPrint(r):
+			b.WriteRune(r)
+		default:
+			// e.g. \x1b, \n or \u202e
+			quoted := strconv.QuoteRuneToASCII(r)
+			b.WriteString(quoted[1 : len(quoted)-1])
+		}
+		i += size
+	}
+	return b.String()
+}
+
 func (m *Model) SetHeight(height int) {
 	m.height = height
 }
@@ -643,14 +1006,90 @@ func (m *Model) SelectedFile() (name string, absPath string, ok bool) {
 		return "", "", false
 	}
 	name = selected.Name()
-	absPath = filepath.Join(m.currentPath, name)
+	absPath = path.Join(m.currentPath, name)
 	return name, absPath, true
 }
 
+// Position returns the 1-based index of the selected file and the number of
+// visible files
+func (m *Model) Position() (int, int) {
+	total := m.getVisibleFilesLength()
+	if total == 0 {
+		return 0, 0
+	}
+	return m.selectedIndex + 1, total
+}
+
+// SelectedPath returns the absolute path of the selected file or directory
+func (m *Model) SelectedPath() (string, bool) {
+	visibleFiles := m.getVisibleFiles()
+	if len(visibleFiles) == 0 || m.selectedIndex >= len(visibleFiles) {
+		return "", false
+	}
+	return "/" + path.Join(m.currentPath, visibleFiles[m.selectedIndex].Name()), true
+}
+
+// Mark marks the file at the absolute path, such as "/etc/passwd"
+func (m *Model) Mark(p string) {
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	m.marked[p] = true
+}
+
+// Unmark unmarks the file at the absolute path
+func (m *Model) Unmark(p string) {
+	delete(m.marked, p)
+}
+
+// IsMarked reports whether the file at the absolute path is marked
+func (m *Model) IsMarked(p string) bool {
+	return m.marked[p]
+}
+
+// ToggleMark marks the selected file, or unmarks it if it is marked.
+// Directories can't be marked.
+func (m *Model) ToggleMark() {
+	_, p, ok := m.SelectedFile()
+	if !ok {
+		return
+	}
+	if p = "/" + p; m.IsMarked(p) {
+		m.Unmark(p)
+	} else {
+		m.Mark(p)
+	}
+}
+
+// ClearMarks unmarks all files
+func (m *Model) ClearMarks() {
+	m.marked = nil
+}
+
+// MarkedFiles returns the absolute paths of the marked files in any
+// directory, sorted
+func (m *Model) MarkedFiles() []string {
+	return slices.Sorted(maps.Keys(m.marked))
+}
+
 func (m *Model) CurrentPath() string {
 	return m.currentPath
 }
 
+// SetKind shows only the files of the kind, besides the directories
+func (m *Model) SetKind(kind Kind) {
+	m.kind = kind
+	// The cursor stays on the list
+	if visibleLen := m.getVisibleFilesLength(); m.selectedIndex >= visibleLen {
+		m.selectedIndex = max(visibleLen-1, 0)
+	}
+}
+
+// Kind returns the kind of the files shown
+func (m *Model) Kind() Kind {
+	return m.kind
+}
+
 func (m *Model) SetShowHidden(show bool) {
 	m.showHidden = show
 }
@@ -659,6 +1098,26 @@ func (m *Model) ShowHidden() bool {
 	return m.showHidden
 }
 
+// SetKeyMap replaces the key bindings of the file picker
+func (m *Model) SetKeyMap(keys KeyMap) {
+	m.keys = keys
+}
+
+// KeyMap returns the key bindings of the file picker
+func (m *Model) KeyMap() KeyMap {
+	return m.keys
+}
+
+// SetStyles sets the styles of the file picker
+func (m *Model) SetStyles(styles Styles) {
+	m.styles = styles
+}
+
+// SetIgnoreCase makes filters ignore case even if they have uppercase letters
+func (m *Model) SetIgnoreCase(ignore bool) {
+	m.ignoreCase = ignore
+}
+
 func (m *Model) SetShowPermissions(show bool) {
 	m.showPermissions = show
 }
@@ -667,32 +1126,273 @@ func (m *Model) SetShowSize(show bool) {
 	m.showSize = show
 }
 
+func (m *Model) SetShowModTime(show bool) {
+	m.showModTime = show
+}
+
+// SetShowIcons shows icons of the file types before the names. The icons
+// are glyphs of Nerd Fonts, https://www.nerdfonts.com.
+func (m *Model) SetShowIcons(show bool) {
+	m.showIcons = show
+}
+
+func (m *Model) ShowIcons() bool {
+	return m.showIcons
+}
+
+// TimeFormat decides how modification times are displayed
+type TimeFormat int
+
+const (
+	// TimeAbsolute displays times as "2006-01-02 15:04"
+	TimeAbsolute TimeFormat = iota
+	// TimeRelative displays times relative to now, such as "3 days ago"
+	TimeRelative
+	// TimeISO displays times in RFC 3339
+	TimeISO
+)
+
+func (f TimeFormat) String() string {
+	switch f {
+	case TimeAbsolute:
+		return "absolute"
+	case TimeRelative:
+		return "relative"
+	case TimeISO:
+		return "iso"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseTimeFormat parses "absolute", "relative" or "iso" into a TimeFormat
+func ParseTimeFormat(s string) (TimeFormat, error) {
+	for _, f := range []TimeFormat{TimeAbsolute, TimeRelative, TimeISO} {
+		if s == f.String() {
+			return f, nil
+		}
+	}
+	return TimeAbsolute, fmt.Errorf("invalid time format %q, must be absolute, relative or iso", s)
+}
+
+// ParseTimeLocation parses "utc", "local" or an IANA time zone name such as
+// "Asia/Tokyo" into the location times are displayed in
+func ParseTimeLocation(s string) (*time.Location, error) {
+	switch strings.ToLower(s) {
+	case "utc":
+		return time.UTC, nil
+	case "local":
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid time zone %q: %w", s, err)
+	}
+	return loc, nil
+}
+
+// FormatTime formats a time in the given format and location. Zero times are
+// unknown and displayed as "-".
+func FormatTime(t time.Time, format TimeFormat, loc *time.Location) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if loc != nil {
+		t = t.In(loc)
+	}
+	switch format {
+	case TimeRelative:
+		return humanize.Time(t)
+	case TimeISO:
+		return t.Format(time.RFC3339)
+	default:
+		return t.Format("2006-01-02 15:04")
+	}
+}
+
+// SizeFormat decides how sizes are displayed
+type SizeFormat int
+
+const (
+	// SizeIEC displays sizes in powers of 1024, such as "1.5 MiB"
+	SizeIEC SizeFormat = iota
+	// SizeSI displays sizes in powers of 1000, such as "1.6 MB"
+	SizeSI
+	// SizeBytes displays sizes in bytes, such as "1572864 B"
+	SizeBytes
+)
+
+func (f SizeFormat) String() string {
+	switch f {
+	case SizeIEC:
+		return "iec"
+	case SizeSI:
+		return "si"
+	case SizeBytes:
+		return "bytes"
+	default:
+		return "unknown"
+	}
+}
+
+// ParseSizeFormat parses "iec", "si" or "bytes" into a SizeFormat
+func ParseSizeFormat(s string) (SizeFormat, error) {
+	for _, f := range []SizeFormat{SizeIEC, SizeSI, SizeBytes} {
+		if s == f.String() {
+			return f, nil
+		}
+	}
+	return SizeIEC, fmt.Errorf("invalid size format %q, must be iec, si or bytes", s)
+}
+
+// FormatSize formats a size in bytes in the given format, with one decimal
+// above a kilobyte
+func FormatSize(size int64, format SizeFormat) string {
+	unit, prefixes, suffix := int64(1024), "KMGTPE", "iB"
+	if format == SizeSI {
+		unit, prefixes, suffix = 1000, "kMGTPE", "B"
+	}
+	if format == SizeBytes || size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := unit, 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %c%s", float64(size)/float64(div), prefixes[exp], suffix)
+}
+
+// SetSizeFormat sets how file sizes are displayed
+func (m *Model) SetSizeFormat(format SizeFormat) {
+	m.sizeFormat = format
+}
+
+func (m *Model) SizeFormat() SizeFormat {
+	return m.sizeFormat
+}
+
+// SetTimeFormat sets how modification times are displayed
+func (m *Model) SetTimeFormat(format TimeFormat) {
+	m.timeFormat = format
+	if format != TimeRelative {
+		m.absoluteFormat = format
+	}
+}
+
+func (m *Model) TimeFormat() TimeFormat {
+	return m.timeFormat
+}
+
+// SetTimeLocation sets the time zone modification times are displayed in
+func (m *Model) SetTimeLocation(loc *time.Location) {
+	m.timeLocation = loc
+}
+
+// SetRelativeTime shows modification times relative to now, such as "3 days
+// ago", or switches back to the absolute format
+func (m *Model) SetRelativeTime(relative bool) {
+	if relative {
+		m.timeFormat = TimeRelative
+	} else {
+		m.timeFormat = m.absoluteFormat
+	}
+}
+
+func (m *Model) RelativeTime() bool {
+	return m.timeFormat == TimeRelative
+}
+
+// formatModTime formats the modification time of a file for the listing
+func (m Model) formatModTime(t time.Time) string {
+	return FormatTime(t, m.timeFormat, m.timeLocation)
+}
+
 func (m *Model) SetPath(path string) {
 	m.currentPath = path
 	m.selectedIndex = 0
+	m.offset = 0
 	m.selectedFile = ""
 	m.selectedAbsPath = ""
 }
 
+// Reveal shows the directory at path, or the directory of the file at path
+// with the file selected. The path is relative to the root of the file
+// system, as CurrentPath.
+func (m *Model) Reveal(p string) (tea.Cmd, error) {
+	if p == "." {
+		m.SetPath(p)
+		return m.Init(), nil
+	}
+	// The entry is looked up in its directory, as file systems listing
+	// directories may not open files
+	dir, name := path.Dir(p), path.Base(p)
+	entries, err := fs.ReadDir(m.fs, dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if e.Name() != name {
+			continue
+		}
+		if e.IsDir() {
+			m.SetPath(p)
+			return m.Init(), nil
+		}
+		m.SetPath(dir)
+		return func() tea.Msg {
+			return m.loadFiles(name)
+		}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: p, Err: fs.ErrNotExist}
+}
+
 func (m Model) InFilterMode() bool {
 	return m.filterMode
 }
 
-func copyToClipboard(text string) error {
-	switch runtime.GOOS {
+// CopyToClipboard copies text to the system clipboard with the clipboard
+// command of the platform
+func CopyToClipboard(text string) error {
+	name, args, err := clipboardCommand(runtime.GOOS, os.Getenv, exec.LookPath)
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(text)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		if msg := strings.TrimSpace(string(out)); msg != "" {
+			return fmt.Errorf("%s failed: %w: %s", name, err, msg)
+		}
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
+// clipboardCommand returns the command reading the clipboard content from
+// stdin. On Linux, the first available of wl-copy on Wayland, xclip and xsel
+// is used.
+func clipboardCommand(goos string, getenv func(string) string, lookPath func(string) (string, error)) (string, []string, error) {
+	switch goos {
 	case "darwin":
-		cmd := exec.Command("pbcopy")
-		cmd.Stdin = strings.NewReader(text)
-		return cmd.Run()
-	case "linux":
-		cmd := exec.Command("xclip", "-selection", "clipboard")
-		cmd.Stdin = strings.NewReader(text)
-		return cmd.Run()
+		return "pbcopy", nil, nil
 	case "windows":
-		cmd := exec.Command("clip")
-		cmd.Stdin = strings.NewReader(text)
-		return cmd.Run()
+		return "clip", nil, nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		candidates := [][]string{
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+		if getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append([][]string{{"wl-copy"}}, candidates...)
+		}
+		for _, c := range candidates {
+			if _, err := lookPath(c[0]); err == nil {
+				return c[0], c[1:], nil
+			}
+		}
+		return "", nil, errors.New("no clipboard command found, install xclip, xsel or wl-clipboard")
 	default:
-		return fmt.Errorf("unsupported platform")
+		return "", nil, fmt.Errorf("clipboard is not supported on %s", goos)
 	}
 }
@@ -1,12 +1,18 @@
 package filepicker
 
 import (
+	"bytes"
+	"fmt"
 	"io/fs"
+	"log/slog"
+	"os/exec"
+	"strings"
 	"testing"
 	"testing/fstest"
 	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -67,7 +73,56 @@ func TestNewModel(t *testing.T) {
 	assert.True(t, m.showPermissions)
 	assert.True(t, m.showSize)
 	assert.False(t, m.showHelp)
-	assert.Equal(t, "", m.pendingKey)
+}
+
+func TestOptions(t *testing.T) {
+	var logs bytes.Buffer
+	keys := DefaultKeyMap()
+	keys.Select.SetKeys("o")
+	styles := DefaultStyles()
+	styles.Selected = styles.Selected.Underline(true)
+	m := New(setupTestFS(),
+		WithHeight(10),
+		WithStyles(styles),
+		WithKeyMap(keys),
+		WithShowHidden(true),
+		WithColumns(false, true, false),
+		WithTimeFormat(TimeISO, time.Local),
+		WithIgnoreCase(true),
+		WithShowIcons(true),
+		WithLogger(slog.New(slog.NewTextHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug}))),
+	)
+	assert.Equal(t, 10, m.height)
+	assert.True(t, m.styles.Selected.GetUnderline())
+	assert.Equal(t, []string{"o"}, m.KeyMap().Select.Keys())
+	assert.True(t, m.ShowHidden())
+	assert.False(t, m.showPermissions)
+	assert.True(t, m.showSize)
+	assert.False(t, m.showModTime)
+	assert.Equal(t, TimeISO, m.TimeFormat())
+	assert.Equal(t, time.Local, m.timeLocation)
+	assert.True(t, m.ignoreCase)
+	assert.True(t, m.ShowIcons())
+
+	m, _ = m.Update(m.Init()())
+	assert.Contains(t, logs.String(), "Loading files for path: .")
+	assert.Len(t, m.getVisibleFiles(), 6)
+}
+
+func TestFileSelectedMsg(t *testing.T) {
+	m := New(setupTestFS(), WithHeight(20))
+	m, _ = m.Update(m.Init()())
+
+	// Entering a directory sends no selection
+	m, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	m, _ = m.Update(cmd())
+	assert.Equal(t, "testdir", m.CurrentPath())
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Equal(t, FileSelectedMsg{Name: "file4.txt", Path: "/testdir/file4.txt"}, cmd())
 }
 
 func TestModelInitialFileLoad(t *testing.T) {
@@ -158,6 +213,7 @@ func TestModelFilter(t *testing.T) {
 	tests := []struct {
 		name          string
 		filterStr     string
+		ignoreCase    bool
 		expectedFiles int
 		expectedIndex int
 	}{
@@ -185,11 +241,25 @@ func TestModelFilter(t *testing.T) {
 			expectedFiles: 0,
 			expectedIndex: 0,
 		},
+		{
+			name:          "uppercase filter is case-sensitive",
+			filterStr:     "/File",
+			expectedFiles: 0,
+			expectedIndex: 0,
+		},
+		{
+			name:          "ignore case",
+			filterStr:     "/File",
+			ignoreCase:    true,
+			expectedFiles: 3,
+			expectedIndex: 0,
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			m.filterStr = tt.filterStr
+			m.SetIgnoreCase(tt.ignoreCase)
 			visibleFiles := m.getVisibleFiles()
 			assert.Equal(t, tt.expectedFiles, len(visibleFiles))
 			assert.Equal(t, tt.expectedIndex, m.selectedIndex)
@@ -197,6 +267,68 @@ func TestModelFilter(t *testing.T) {
 	}
 }
 
+func TestFilterNoMatches(t *testing.T) {
+	m := New(setupTestFS())
+	m.SetHeight(20)
+	m, _ = m.Update(m.Init()())
+	press := func(keyType tea.KeyType, r ...rune) {
+		m, _ = m.Update(tea.KeyMsg{Type: keyType, Runes: r})
+	}
+
+	press(tea.KeyRunes, '/')
+	press(tea.KeyRunes, 'f', 'i', 'l', 'e')
+	assert.Contains(t, m.View(), "Filter: /file  3/4 matches")
+
+	press(tea.KeyRunes, 'x')
+	view := m.View()
+	assert.Contains(t, view, "0/4 matches")
+	assert.Contains(t, view, "No matches for 'filex' (esc to clear)")
+	assert.NotContains(t, view, "No files found")
+
+	// Esc clears the applied filter before going back
+	press(tea.KeyEnter)
+	assert.False(t, m.InFilterMode())
+	press(tea.KeyEsc)
+	assert.NotContains(t, m.View(), "Filter:")
+	assert.Len(t, m.getVisibleFiles(), 4)
+
+	// Empty directories are told apart
+	empty := New(newMockFS())
+	empty.SetHeight(20)
+	empty, _ = empty.Update(empty.Init()())
+	empty.filterStr = "/x"
+	assert.Contains(t, empty.View(), "No files found")
+}
+
+func TestFilterMatch(t *testing.T) {
+	m := New(setupTestFS())
+	m.files = m.Init()().(filesLoadedMsg).files
+	m.styles.FilterMatch = lipgloss.NewStyle().Transform(strings.ToUpper)
+
+	_, _, ok := m.FilterMatches()
+	assert.False(t, ok)
+
+	m.filterStr = "/le2"
+	matches, total, ok := m.FilterMatches()
+	assert.True(t, ok)
+	assert.Equal(t, 1, matches)
+	assert.Equal(t, 4, total)
+
+	start, end, ok := m.filterMatch("file2.txt")
+	assert.True(t, ok)
+	assert.Equal(t, "le2", "file2.txt"[start:end])
+
+	// The match is rendered in its own style
+	plain := lipgloss.NewStyle()
+	assert.Equal(t, "fiLE2.txt", m.renderName("file2.txt", plain))
+	assert.Equal(t, "file1.txt", m.renderName("file1.txt", plain))
+
+	// Case is ignored in lowercase filters
+	start, end, ok = m.filterMatch("FILE2.TXT")
+	assert.True(t, ok)
+	assert.Equal(t, "LE2", "FILE2.TXT"[start:end])
+}
+
 func TestToggleHidden(t *testing.T) {
 	fs := setupTestFS()
 	m := New(fs)
@@ -224,6 +356,322 @@ func TestToggleHidden(t *testing.T) {
 	assert.Equal(t, 6, len(visibleFiles), "Expected 6 files (3 files + 2 dirs + 1 hidden file) in root")
 }
 
+// ownedEntry is a directory entry whose info knows its owner
+type ownedEntry struct {
+	fs.DirEntry
+	uid int
+}
+
+func (e ownedEntry) Info() (fs.FileInfo, error) {
+	info, err := e.DirEntry.Info()
+	return ownedInfo{info, e.uid}, err
+}
+
+type ownedInfo struct {
+	fs.FileInfo
+	uid int
+}
+
+func (i ownedInfo) Uid() int { return i.uid }
+
+func TestKind(t *testing.T) {
+	fsys := newMockFS()
+	fsys.addDir("bin")
+	fsys.addFile("app", []byte("app"), 0o755)
+	fsys.addFile("su", []byte("su"), fs.ModeSetuid|0o4755)
+	fsys.addFile("wall", []byte("wall"), fs.ModeSetgid|0o2755)
+	fsys.addFile("config", []byte("config"), 0o644)
+	fsys.addFile("link", []byte("app"), fs.ModeSymlink|0o777)
+	m := New(fsys)
+	m.SetHeight(20)
+	loaded := m.Init()().(filesLoadedMsg)
+	require.NoError(t, loaded.err)
+	for _, e := range loaded.files {
+		uid := 0
+		if e.Name() == "config" || e.Name() == "wall" {
+			uid = 1000
+		}
+		m.files = append(m.files, ownedEntry{e, uid})
+	}
+
+	names := func() []string {
+		var names []string
+		for _, f := range m.getVisibleFiles() {
+			names = append(names, f.Name())
+		}
+		return names
+	}
+	assert.Equal(t, []string{"bin", "app", "config", "link", "su", "wall"}, names())
+	_, _, ok := m.FilterMatches()
+	assert.False(t, ok)
+
+	// f cycles through the kinds, always showing the directories
+	want := []struct {
+		kind  Kind
+		names []string
+	}{
+		{KindExecutable, []string{"bin", "app", "su", "wall"}},
+		{KindSetID, []string{"bin", "su", "wall"}},
+		{KindSymlink, []string{"bin", "link"}},
+		{KindRoot, []string{"bin", "app", "link", "su"}},
+		{KindAll, []string{"bin", "app", "config", "link", "su", "wall"}},
+	}
+	for _, w := range want {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+		assert.Equal(t, w.kind, m.Kind())
+		assert.Equal(t, w.names, names(), "kind %s", w.kind)
+	}
+
+	// The kind is composed with the name filter
+	m.SetKind(KindSetID)
+	assert.Contains(t, m.View(), "(only setuid/setgid files)")
+	matches, total, ok := m.FilterMatches()
+	assert.True(t, ok)
+	assert.Equal(t, 3, matches)
+	assert.Equal(t, 6, total)
+	m.filterStr = "/s"
+	assert.Equal(t, []string{"su"}, names())
+	m.filterStr = "/x"
+	assert.Contains(t, m.View(), "No matches for 'x'")
+
+	// esc clears the filter, then shows all of the files
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, KindSetID, m.Kind())
+	assert.Equal(t, []string{"bin", "su", "wall"}, names())
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, KindAll, m.Kind())
+
+	// The cursor stays on the list
+	m.selectedIndex = 5
+	m.SetKind(KindSymlink)
+	assert.Equal(t, 1, m.selectedIndex)
+	assert.Contains(t, m.View(), "Directory: .  (only symbolic links)")
+
+	// A directory with no files of the kind says so
+	m.files = []fs.DirEntry{ownedEntry{loaded.files[1], 0}}
+	m.SetKind(KindSetID)
+	assert.Contains(t, m.View(), "No setuid/setgid files here (esc to show all files)")
+}
+
+func TestModTime(t *testing.T) {
+	fsys := newMockFS()
+	fsys.MapFS["old.txt"] = &fstest.MapFile{
+		Data:    []byte("old"),
+		Mode:    0o644,
+		ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	m := New(fsys)
+	m.SetHeight(20)
+	msg := m.Init()().(filesLoadedMsg)
+	require.NoError(t, msg.err)
+	m.files = msg.files
+
+	assert.Contains(t, m.View(), "2024-01-02 03:04")
+
+	// Toggle relative time
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'t'}})
+	assert.True(t, m.RelativeTime())
+	assert.Contains(t, m.View(), "years ago")
+	assert.NotContains(t, m.View(), "2024-01-02 03:04")
+
+	assert.Equal(t, "-", m.formatModTime(time.Time{}))
+}
+
+func TestTimeFormat(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	tokyo := time.FixedZone("JST", 9*60*60)
+
+	assert.Equal(t, "2024-01-02 03:04", FormatTime(modTime, TimeAbsolute, time.UTC))
+	assert.Equal(t, "2024-01-02 12:04", FormatTime(modTime, TimeAbsolute, tokyo))
+	assert.Equal(t, "2024-01-02T03:04:05Z", FormatTime(modTime, TimeISO, time.UTC))
+	assert.Equal(t, "2024-01-02T12:04:05+09:00", FormatTime(modTime, TimeISO, tokyo))
+	assert.Contains(t, FormatTime(modTime, TimeRelative, tokyo), "ago")
+	assert.Equal(t, "-", FormatTime(time.Time{}, TimeISO, time.UTC))
+
+	for _, f := range []TimeFormat{TimeAbsolute, TimeRelative, TimeISO} {
+		parsed, err := ParseTimeFormat(f.String())
+		require.NoError(t, err)
+		assert.Equal(t, f, parsed)
+	}
+	_, err := ParseTimeFormat("unix")
+	assert.Error(t, err)
+
+	loc, err := ParseTimeLocation("UTC")
+	require.NoError(t, err)
+	assert.Equal(t, time.UTC, loc)
+	loc, err = ParseTimeLocation("local")
+	require.NoError(t, err)
+	assert.Equal(t, time.Local, loc)
+	_, err = ParseTimeLocation("Nowhere/Invalid")
+	assert.Error(t, err)
+
+	// Toggling relative times restores the configured format
+	fsys := newMockFS()
+	fsys.MapFS["old.txt"] = &fstest.MapFile{Data: []byte("old"), Mode: 0o644, ModTime: modTime}
+	m := New(fsys)
+	m.SetHeight(20)
+	m.SetTimeFormat(TimeISO)
+	m.SetTimeLocation(tokyo)
+	msg := m.Init()().(filesLoadedMsg)
+	require.NoError(t, msg.err)
+	m.files = msg.files
+	assert.Contains(t, m.View(), "2024-01-02T12:04:05+09:00")
+
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'t'}})
+	assert.Equal(t, TimeRelative, m.TimeFormat())
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'t'}})
+	assert.Equal(t, TimeISO, m.TimeFormat())
+}
+
+func TestSort(t *testing.T) {
+	fsys := newMockFS()
+	fsys.addDir("dir")
+	fsys.MapFS["file10.txt"] = &fstest.MapFile{
+		Data:    []byte("middle"),
+		ModTime: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	fsys.MapFS["file2.txt"] = &fstest.MapFile{
+		Data:    []byte("the largest"),
+		ModTime: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	fsys.MapFS["file1.txt"] = &fstest.MapFile{
+		Data:    []byte("tiny"),
+		ModTime: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	m := New(fsys)
+	m.SetHeight(20)
+	msg := m.Init()().(filesLoadedMsg)
+	require.NoError(t, msg.err)
+	m.files = msg.files
+
+	names := func() []string {
+		var names []string
+		for _, f := range m.getVisibleFiles() {
+			names = append(names, f.Name())
+		}
+		return names
+	}
+	press := func(r rune) {
+		m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}})
+	}
+
+	// Natural order by default
+	assert.Equal(t, []string{"dir", "file1.txt", "file2.txt", "file10.txt"}, names())
+	assert.NotContains(t, m.View(), "sorted by")
+
+	// The cursor stays on the selected file
+	m.selectedIndex = 3
+	press('s')
+	assert.Equal(t, []string{"dir", "file2.txt", "file10.txt", "file1.txt"}, names())
+	assert.Equal(t, 2, m.selectedIndex)
+	assert.Contains(t, m.View(), "sorted by size, largest first")
+
+	press('S')
+	assert.Equal(t, []string{"dir", "file1.txt", "file10.txt", "file2.txt"}, names())
+	assert.Contains(t, m.View(), "sorted by size, smallest first")
+
+	press('s')
+	assert.Equal(t, []string{"dir", "file1.txt", "file10.txt", "file2.txt"}, names())
+	assert.Contains(t, m.View(), "sorted by time, newest first")
+
+	press('s')
+	assert.Equal(t, []string{"dir", "file1.txt", "file2.txt", "file10.txt"}, names())
+	// Directories stay first in reverse
+	press('S')
+	assert.Equal(t, []string{"dir", "file10.txt", "file2.txt", "file1.txt"}, names())
+	assert.Contains(t, m.View(), "sorted by name, Z to A")
+}
+
+func TestMarks(t *testing.T) {
+	m := New(setupTestFS())
+	m.SetHeight(20)
+	m, _ = m.Update(m.Init()())
+	press := func(keyType tea.KeyType, r ...rune) {
+		m, _ = m.Update(tea.KeyMsg{Type: keyType, Runes: r})
+	}
+
+	// Directories can't be marked
+	press(tea.KeySpace, ' ')
+	assert.Empty(t, m.MarkedFiles())
+
+	press(tea.KeyDown)
+	press(tea.KeySpace, ' ')
+	assert.Equal(t, []string{"/file1.txt"}, m.MarkedFiles())
+	assert.Contains(t, m.View(), "✓ file1.txt")
+	assert.NotContains(t, m.View(), "✓ file2.txt")
+
+	// Marks are kept in other directories
+	m.Mark("/testdir/file4.txt")
+	press(tea.KeyRunes, 'g')
+	press(tea.KeyEnter)
+	m, _ = m.Update(m.loadFiles(""))
+	assert.Contains(t, m.View(), "✓ file4.txt")
+	assert.Equal(t, []string{"/file1.txt", "/testdir/file4.txt"}, m.MarkedFiles())
+
+	// Marking again unmarks
+	press(tea.KeyDown)
+	press(tea.KeySpace, ' ')
+	assert.Equal(t, []string{"/file1.txt"}, m.MarkedFiles())
+	assert.True(t, m.IsMarked("/file1.txt"))
+	m.Unmark("/file1.txt")
+	assert.Empty(t, m.MarkedFiles())
+
+	m.Mark("/file2.txt")
+	m.ClearMarks()
+	assert.Empty(t, m.MarkedFiles())
+}
+
+func TestFileIcon(t *testing.T) {
+	tests := []struct {
+		name string
+		mode fs.FileMode
+		want string
+	}{
+		{"etc", fs.ModeDir | 0o755, iconDirectory},
+		{"sh", fs.ModeSymlink | 0o777, iconSymlink},
+		{"null", fs.ModeDevice | fs.ModeCharDevice | 0o666, iconDevice},
+		{"busybox", 0o755, iconExecutable},
+		{"passwd", 0o644, iconFile},
+		{"rootfs.tar.gz", 0o644, iconArchive},
+		{"main.go", 0o644, iconGo},
+		{"APP.PY", 0o644, iconPython},
+		{"Dockerfile", 0o644, iconDocker},
+		{"libc.so.6", 0o755, iconBinary},
+		{"entrypoint.sh", 0o755, iconExecutable},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, fileIcon(tt.name, tt.mode), tt.name)
+	}
+
+	m := New(setupTestFS())
+	m.SetHeight(20)
+	m, _ = m.Update(m.Init()())
+	assert.NotContains(t, m.View(), iconDirectory)
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+	assert.True(t, m.ShowIcons())
+	assert.Contains(t, m.View(), iconDirectory+" testdir/")
+	assert.Contains(t, m.View(), iconText+" file1.txt")
+}
+
+func TestCompareNatural(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"file2", "file10", -1},
+		{"file10", "file2", 1},
+		{"a", "b", -1},
+		{"file", "file1", -1},
+		{"v1.10.0", "v1.9.3", 1},
+		{"file01", "file1", -1},
+		{"file1", "file1", 0},
+		{"99", "100", -1},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, compareNatural(tt.a, tt.b), "%s <=> %s", tt.a, tt.b)
+	}
+}
+
 func TestFileSelection(t *testing.T) {
 	fs := setupTestFS()
 	m := New(fs)
@@ -329,6 +777,28 @@ func TestKeyboardShortcuts(t *testing.T) {
 	}
 }
 
+func TestSetKeyMap(t *testing.T) {
+	m := New(setupTestFS())
+	m.SetHeight(20)
+	m, _ = m.Update(m.Init()())
+
+	keys := DefaultKeyMap()
+	keys.Down.SetKeys("n")
+	keys.Mark.SetEnabled(false)
+	m.SetKeyMap(keys)
+	assert.Equal(t, []string{"n"}, m.KeyMap().Down.Keys())
+
+	// The default key no longer moves the cursor
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'j'}})
+	assert.Equal(t, 0, m.selectedIndex)
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'n'}})
+	assert.Equal(t, 1, m.selectedIndex)
+
+	// Disabled keys do nothing
+	m, _ = m.Update(tea.KeyMsg{Type: tea.KeySpace, Runes: []rune{' '}})
+	assert.Empty(t, m.MarkedFiles())
+}
+
 func TestPagination(t *testing.T) {
 	fs := setupTestFS()
 	m := New(fs)
@@ -380,6 +850,55 @@ func TestPagination(t *testing.T) {
 	}
 }
 
+func TestHugeDirectory(t *testing.T) {
+	fsys := newMockFS()
+	for i := range 50000 {
+		fsys.addFile(fmt.Sprintf("file%d", i), nil, 0o644)
+	}
+	m := New(fsys)
+	m.SetHeight(20)
+	msg := m.Init()().(filesLoadedMsg)
+	require.NoError(t, msg.err)
+	m, _ = m.Update(msg)
+
+	// Only the window is rendered
+	lines := strings.Split(m.View(), "\n")
+	assert.Len(t, lines, 21)
+	assert.Contains(t, lines[2], "> ")
+	assert.Contains(t, lines[2], "file0")
+	assert.Contains(t, lines[18], "file16")
+
+	press := func(keyType tea.KeyType, r ...rune) {
+		m, _ = m.Update(tea.KeyMsg{Type: keyType, Runes: r})
+	}
+
+	// The window scrolls when the cursor leaves it
+	for range 20 {
+		press(tea.KeyDown)
+	}
+	lines = strings.Split(m.View(), "\n")
+	assert.Contains(t, lines[2], "file4")
+	assert.Contains(t, lines[18], "> ")
+	assert.Contains(t, lines[18], "file20")
+
+	// and stays while the cursor moves inside it
+	press(tea.KeyUp)
+	lines = strings.Split(m.View(), "\n")
+	assert.Contains(t, lines[2], "file4")
+	assert.Contains(t, lines[17], "> ")
+
+	press(tea.KeyRunes, 'G')
+	lines = strings.Split(m.View(), "\n")
+	assert.Len(t, lines, 21)
+	assert.Contains(t, lines[18], "> ")
+	assert.Contains(t, lines[18], "file49999")
+
+	press(tea.KeyRunes, 'g')
+	lines = strings.Split(m.View(), "\n")
+	assert.Contains(t, lines[2], "> ")
+	assert.Contains(t, lines[2], "file0")
+}
+
 func TestPathOperations(t *testing.T) {
 	fs := setupTestFS()
 	m := New(fs)
@@ -484,3 +1003,152 @@ func TestFilterMode(t *testing.T) {
 	assert.False(t, m.InFilterMode())
 	assert.Equal(t, "", m.filterStr)
 }
+
+// deviceFileInfo is a file info of a device with device numbers
+type deviceFileInfo struct {
+	fs.FileInfo
+	mode         fs.FileMode
+	major, minor int64
+}
+
+func (i deviceFileInfo) Mode() fs.FileMode { return i.mode }
+func (i deviceFileInfo) Devmajor() int64   { return i.major }
+func (i deviceFileInfo) Devminor() int64   { return i.minor }
+
+func TestFormatMode(t *testing.T) {
+	tests := []struct {
+		mode fs.FileMode
+		want string
+	}{
+		{0o644, "-rw-r--r--"},
+		{fs.ModeDir | 0o755, "drwxr-xr-x"},
+		{fs.ModeSymlink | 0o777, "lrwxrwxrwx"},
+		{fs.ModeDevice | fs.ModeCharDevice | 0o666, "crw-rw-rw-"},
+		{fs.ModeDevice | 0o660, "brw-rw----"},
+		{fs.ModeNamedPipe | 0o600, "prw-------"},
+		{fs.ModeSocket | 0o755, "srwxr-xr-x"},
+		{fs.ModeSetuid | 0o755, "-rwsr-xr-x"},
+		{fs.ModeSetgid | 0o644, "-rw-r-Sr--"},
+		{fs.ModeDir | fs.ModeSticky | 0o777, "drwxrwxrwt"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, formatMode(tt.mode), "mode %v", tt.mode)
+	}
+}
+
+func TestFormatSize(t *testing.T) {
+	fsys := newMockFS()
+	fsys.addFile("file.txt", make([]byte, 2048), 0o644)
+	info, err := fs.Stat(fsys, "file.txt")
+	require.NoError(t, err)
+
+	assert.Equal(t, "2.0 KiB", formatSize(info, SizeIEC))
+	assert.Equal(t, "2.0 kB", formatSize(info, SizeSI))
+	assert.Equal(t, "2048 B", formatSize(info, SizeBytes))
+	assert.Equal(t, "1, 3", formatSize(deviceFileInfo{FileInfo: info, mode: fs.ModeDevice | fs.ModeCharDevice, major: 1, minor: 3}, SizeIEC))
+	assert.Equal(t, "-", formatSize(deviceFileInfo{FileInfo: info, mode: fs.ModeNamedPipe}, SizeIEC))
+
+	tests := []struct {
+		size   int64
+		format SizeFormat
+		want   string
+	}{
+		{size: 0, format: SizeIEC, want: "0 B"},
+		{size: 1023, format: SizeIEC, want: "1023 B"},
+		{size: 1536, format: SizeIEC, want: "1.5 KiB"},
+		{size: 3 << 29, format: SizeIEC, want: "1.5 GiB"},
+		{size: 999, format: SizeSI, want: "999 B"},
+		{size: 1_500_000, format: SizeSI, want: "1.5 MB"},
+		{size: 3 << 29, format: SizeBytes, want: "1610612736 B"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, FormatSize(tt.size, tt.format), "%d %s", tt.size, tt.format)
+	}
+	_, err = ParseSizeFormat("binary")
+	assert.Error(t, err)
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "file.txt", want: "file.txt"},
+		{name: "日本語 ファイル", want: "日本語 ファイル"},
+		{name: "evil\x1b[2J.txt", want: `evil\x1b[2J.txt`},
+		{name: "line\nbreak\ttab\r", want: `line\nbreak\ttab\r`},
+		{name: "bell\a\x7f", want: `bell\a\x7f`},
+		{name: "invalid\xff\xfe", want: `invalid\xff\xfe`},
+		{name: "rtl\u202etxt.exe", want: `rtl\u202etxt.exe`},
+		{name: `back\slash`, want: `back\\slash`},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, SanitizeName(tt.name), "name %q", tt.name)
+	}
+}
+
+func TestHostileNames(t *testing.T) {
+	hostile := "evil\x1b[31m\nname\xff"
+	fsys := newMockFS()
+	fsys.addFile(hostile, []byte("content"), 0o644)
+	m := New(fsys)
+	m.SetHeight(20)
+	msg := m.Init()().(filesLoadedMsg)
+	require.NoError(t, msg.err)
+	m.files = msg.files
+
+	view := m.View()
+	assert.NotContains(t, view, "\x1b[31m")
+	assert.Contains(t, view, `evil\x1b[31m\nname\xff`)
+
+	// The original name is kept for exporting
+	name, absPath, ok := m.SelectedFile()
+	require.True(t, ok)
+	assert.Equal(t, hostile, name)
+	assert.Equal(t, hostile, absPath)
+}
+
+func TestClipboardCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		goos      string
+		wayland   bool
+		installed []string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "macOS", goos: "darwin", want: []string{"pbcopy"}},
+		{name: "Windows", goos: "windows", want: []string{"clip"}},
+		{name: "xclip", goos: "linux", installed: []string{"xclip", "xsel"}, want: []string{"xclip", "-selection", "clipboard"}},
+		{name: "xsel", goos: "linux", installed: []string{"xsel"}, want: []string{"xsel", "--clipboard", "--input"}},
+		{name: "Wayland", goos: "linux", wayland: true, installed: []string{"xclip", "wl-copy"}, want: []string{"wl-copy"}},
+		{name: "wl-copy without Wayland", goos: "linux", installed: []string{"wl-copy"}, wantErr: true},
+		{name: "unsupported", goos: "plan9", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			getenv := func(key string) string {
+				if key == "WAYLAND_DISPLAY" && tt.wayland {
+					return "wayland-0"
+				}
+				return ""
+			}
+			lookPath := func(file string) (string, error) {
+				for _, name := range tt.installed {
+					if name == file {
+						return "/usr/bin/" + file, nil
+					}
+				}
+				return "", exec.ErrNotFound
+			}
+
+			name, args, err := clipboardCommand(tt.goos, getenv, lookPath)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, append([]string{name}, args...))
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package filepicker
+
+import (
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// Icons of Nerd Fonts, https://www.nerdfonts.com/cheat-sheet
+const (
+	iconDirectory  = "\uf07b" // nf-fa-folder
+	iconSymlink    = "\uf0c1" // nf-fa-link
+	iconDevice     = "\uf2db" // nf-fa-microchip
+	iconPipe       = "\uf1e6" // nf-fa-plug
+	iconExecutable = "\uf489" // nf-oct-terminal
+	iconFile       = "\uf15b" // nf-fa-file
+	iconText       = "\uf15c" // nf-fa-file_text
+	iconArchive    = "\uf410" // nf-oct-file_zip
+	iconImage      = "\uf1c5" // nf-fa-file_image_o
+	iconPDF        = "\uf1c1" // nf-fa-file_pdf_o
+	iconBinary     = "\uf471" // nf-oct-file_binary
+	iconKey        = "\uf084" // nf-fa-key
+	iconLock       = "\uf023" // nf-fa-lock
+	iconDatabase   = "\uf1c0" // nf-fa-database
+	iconConfig     = "\ue615" // nf-seti-config
+	iconJSON       = "\ue60b" // nf-seti-json
+	iconMarkdown   = "\ue609" // nf-seti-markdown
+	iconHTML       = "\ue736" // nf-dev-html5
+	iconCSS        = "\ue749" // nf-dev-css3
+	iconDocker     = "\uf308" // nf-linux-docker
+	iconGit        = "\uf1d3" // nf-fa-git
+	iconGo         = "\ue627" // nf-seti-go
+	iconPython     = "\ue606" // nf-seti-python
+	iconJavaScript = "\ue74e" // nf-dev-javascript
+	iconTypeScript = "\ue628" // nf-seti-typescript
+	iconRuby       = "\ue739" // nf-dev-ruby
+	iconRust       = "\ue7a8" // nf-dev-rust
+	iconJava       = "\ue738" // nf-dev-java
+	iconC          = "\ue61e" // nf-custom-c
+	iconCPP        = "\ue61d" // nf-custom-cpp
+	iconPHP        = "\ue73d" // nf-dev-php
+	iconLua        = "\ue620" // nf-seti-lua
+)
+
+// nameIcons are the icons of well-known file names
+var nameIcons = map[string]string{
+	"dockerfile":    iconDocker,
+	"containerfile": iconDocker,
+	".dockerignore": iconDocker,
+	".gitignore":    iconGit,
+	".gitconfig":    iconGit,
+	"license":       iconText,
+	"readme":        iconText,
+}
+
+// extensionIcons are the icons of file extensions, without the dot
+var extensionIcons = map[string]string{
+	// Archives and packages
+	"tar": iconArchive, "gz": iconArchive, "tgz": iconArchive, "bz2": iconArchive,
+	"xz": iconArchive, "zst": iconArchive, "zip": iconArchive, "7z": iconArchive,
+	"rar": iconArchive, "jar": iconArchive, "whl": iconArchive, "deb": iconArchive,
+	"rpm": iconArchive, "apk": iconArchive,
+	// Images and documents
+	"png": iconImage, "jpg": iconImage, "jpeg": iconImage, "gif": iconImage,
+	"svg": iconImage, "ico": iconImage, "webp": iconImage, "pdf": iconPDF,
+	"txt": iconText, "log": iconText, "rst": iconText, "md": iconMarkdown,
+	// Source code
+	"go": iconGo, "py": iconPython, "js": iconJavaScript, "mjs": iconJavaScript,
+	"ts": iconTypeScript, "rb": iconRuby, "rs": iconRust, "java": iconJava,
+	"c": iconC, "h": iconC, "cpp": iconCPP, "cc": iconCPP, "hpp": iconCPP,
+	"php": iconPHP, "lua": iconLua, "html": iconHTML, "css": iconCSS,
+	"sh": iconExecutable, "bash": iconExecutable, "zsh": iconExecutable,
+	// Data and configuration
+	"json": iconJSON, "yaml": iconConfig, "yml": iconConfig, "toml": iconConfig,
+	"ini": iconConfig, "conf": iconConfig, "cfg": iconConfig, "xml": iconConfig,
+	"lock": iconLock, "sum": iconLock,
+	"pem": iconKey, "crt": iconKey, "cer": iconKey, "key": iconKey, "pub": iconKey,
+	"db": iconDatabase, "sqlite": iconDatabase, "sql": iconDatabase,
+	// Compiled files
+	"so": iconBinary, "a": iconBinary, "o": iconBinary, "pyc": iconBinary,
+	"class": iconBinary, "wasm": iconBinary,
+}
+
+// fileIcon returns the icon of a file from its type, name and extension.
+// Executables without a known extension have the icon of a terminal.
+func fileIcon(name string, mode fs.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return iconDirectory
+	case mode&fs.ModeSymlink != 0:
+		return iconSymlink
+	case mode&fs.ModeDevice != 0:
+		return iconDevice
+	case mode&(fs.ModeNamedPipe|fs.ModeSocket) != 0:
+		return iconPipe
+	}
+	lower := strings.ToLower(name)
+	if icon, ok := nameIcons[lower]; ok {
+		return icon
+	}
+	if icon, ok := extensionIcons[strings.TrimPrefix(path.Ext(lower), ".")]; ok {
+		return icon
+	}
+	// Shared libraries are named like libc.so.6
+	if strings.Contains(lower, ".so.") {
+		return iconBinary
+	}
+	if mode&0o111 != 0 {
+		return iconExecutable
+	}
+	return iconFile
+}
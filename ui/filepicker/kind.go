@@ -0,0 +1,55 @@
+package filepicker
+
+import "io/fs"
+
+// Kind is a kind of files to audit, shown without the other files. The
+// directories are shown whatever their kind, so that they can be browsed.
+type Kind int
+
+const (
+	KindAll        Kind = iota
+	KindExecutable      // regular files executable by anyone
+	KindSetID           // setuid or setgid files
+	KindSymlink         // symbolic links
+	KindRoot            // files owned by root
+	numKinds
+)
+
+// String describes the files of the kind
+func (k Kind) String() string {
+	switch k {
+	case KindExecutable:
+		return "executables"
+	case KindSetID:
+		return "setuid/setgid files"
+	case KindSymlink:
+		return "symbolic links"
+	case KindRoot:
+		return "files owned by root"
+	default:
+		return "all files"
+	}
+}
+
+// match reports whether the file is shown with the kind
+func (k Kind) match(file fs.DirEntry) bool {
+	if k == KindAll || file.IsDir() {
+		return true
+	}
+	info, err := file.Info()
+	if err != nil {
+		return false
+	}
+	mode := info.Mode()
+	switch k {
+	case KindExecutable:
+		return mode.IsRegular() && mode.Perm()&0o111 != 0
+	case KindSetID:
+		return mode&(fs.ModeSetuid|fs.ModeSetgid) != 0
+	case KindSymlink:
+		return mode&fs.ModeSymlink != 0
+	default:
+		owner, ok := info.(ownerInfo)
+		return ok && owner.Uid() == 0
+	}
+}
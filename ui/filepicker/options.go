@@ -0,0 +1,84 @@
+package filepicker
+
+import (
+	"log/slog"
+	"time"
+)
+
+// Option configures a file picker created by New
+type Option func(*Model)
+
+// WithHeight sets the height of the file picker in lines
+func WithHeight(height int) Option {
+	return func(m *Model) {
+		m.height = height
+	}
+}
+
+// WithStyles sets the styles of the file picker
+func WithStyles(styles Styles) Option {
+	return func(m *Model) {
+		m.styles = styles
+	}
+}
+
+// WithKeyMap sets the key bindings of the file picker
+func WithKeyMap(keys KeyMap) Option {
+	return func(m *Model) {
+		m.keys = keys
+	}
+}
+
+// WithShowHidden shows the files whose names start with a dot
+func WithShowHidden(show bool) Option {
+	return func(m *Model) {
+		m.showHidden = show
+	}
+}
+
+// WithColumns decides which of the permission, size and modification time
+// columns are shown
+func WithColumns(permissions, size, modTime bool) Option {
+	return func(m *Model) {
+		m.showPermissions, m.showSize, m.showModTime = permissions, size, modTime
+	}
+}
+
+// WithTimeFormat sets how modification times are displayed, and the time
+// zone they are displayed in
+func WithTimeFormat(format TimeFormat, loc *time.Location) Option {
+	return func(m *Model) {
+		m.SetTimeFormat(format)
+		m.timeLocation = loc
+	}
+}
+
+// WithSizeFormat sets how file sizes are displayed
+func WithSizeFormat(format SizeFormat) Option {
+	return func(m *Model) {
+		m.sizeFormat = format
+	}
+}
+
+// WithIgnoreCase makes filters ignore case even if they have uppercase
+// letters
+func WithIgnoreCase(ignore bool) Option {
+	return func(m *Model) {
+		m.ignoreCase = ignore
+	}
+}
+
+// WithShowIcons shows icons of the file types, which need a Nerd Font
+func WithShowIcons(show bool) Option {
+	return func(m *Model) {
+		m.showIcons = show
+	}
+}
+
+// WithLogger logs the loading of directories at the debug level. Nothing is
+// logged by default.
+func WithLogger(logger *slog.Logger) Option {
+	return func(m *Model) {
+		m.logger = logger
+	}
+}
@@ -0,0 +1,143 @@
+package ui
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// helpSection is a titled group of key bindings in the help
+type helpSection struct {
+	title    string
+	bindings []key.Binding
+}
+
+// helpSections returns the key bindings available in the mode, followed by
+// the ones of the image available in all of the modes
+func (k keyMap) helpSections(mode Mode) []helpSection {
+	sections := k.modeHelpSections(mode)
+	if sections == nil {
+		return nil
+	}
+	return append(sections, helpSection{"Image", []key.Binding{k.copyPullCommand, k.copyRunCommand, k.reload, k.refresh}})
+}
+
+// modeHelpSections returns the key bindings of the mode
+func (k keyMap) modeHelpSections(mode Mode) []helpSection {
+	switch mode {
+	case LayerMode:
+		enter := k.enter
+		enter.SetHelp(enter.Help().Key, "view layer")
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, enter, k.first, k.last, k.pageUp, k.pageDown, k.nextTab, k.prevTab}},
+			{"Actions", []key.Binding{k.mark, k.command, k.toggleHistory, k.toggleDigest, k.copyDiffID, k.copyDigest, k.copyCommand, k.filter, k.help, k.quit}},
+		}
+	case FileMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.enter, k.back, k.first, k.last, k.pageUp, k.pageDown, k.nextTab, k.prevTab}},
+			{"Actions", []key.Binding{k.toggleHidden, k.toggleTime, k.toggleIcons, k.togglePreview, k.sort, k.reverseSort, k.markFile, k.diskUsage, k.recent, k.export, k.copyDiffID, k.copyDigest, k.copyCommand, k.copyPath, k.copyFullPath, k.kind, k.filter, k.help, k.quit}},
+		}
+	case ViewMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{k.compare, k.help, k.quit}},
+		}
+	case DiffMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{k.help, k.quit}},
+		}
+	case LogMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{k.help, k.quit}},
+		}
+	case CommandMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{k.copyCommand, k.help, k.quit}},
+		}
+	case BlobMode:
+		export := k.export
+		export.SetHelp(export.Help().Key, "export blob to current directory")
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{export, k.help, k.quit}},
+		}
+	case UsageMode:
+		enter := k.enter
+		enter.SetHelp(enter.Help().Key, "open directory")
+		back := k.back
+		back.SetHelp(back.Help().Key, "parent directory, esc to the files")
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, enter, back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{k.fileTypes, k.diskUsage, k.help, k.quit}},
+		}
+	case RecentMode:
+		enter := k.enter
+		enter.SetHelp(enter.Help().Key, "show in the file list")
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, enter, k.back, k.first, k.last, k.pageUp, k.pageDown}},
+			{"Actions", []key.Binding{k.filter, k.help, k.quit}},
+		}
+	case RuntimeMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown, k.nextTab, k.prevTab}},
+			{"Actions", []key.Binding{k.compare, k.copyImageID, k.copyManifestDigest, k.help, k.quit}},
+		}
+	case LabelsMode, AnnotationsMode:
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown, k.nextTab, k.prevTab}},
+			{"Actions", []key.Binding{k.copyValue, k.copyLabel, k.filter, k.help, k.quit}},
+		}
+	case ManifestMode, ConfigMode:
+		export := k.export
+		export.SetHelp(export.Help().Key, "export JSON to current directory")
+		toggle := k.enter
+		toggle.SetHelp(toggle.Help().Key, "collapse/expand")
+		query := k.filter
+		query.SetHelp(query.Help().Key, "query with jq")
+		return []helpSection{
+			{"Navigation", []key.Binding{k.up, k.down, k.back, k.first, k.last, k.pageUp, k.pageDown, k.nextTab, k.prevTab}},
+			{"Actions", []key.Binding{toggle, query, k.copyPath, k.copyValue, k.copyImageID, k.copyManifestDigest, export, k.help, k.quit}},
+		}
+	}
+	return nil
+}
+
+// helpOverlay renders the help of the current mode in a box centered in an
+// area of the given height
+func (m *Model) helpOverlay(height int) string {
+	sections := m.keys.helpSections(m.mode)
+	keyWidth := 0
+	for _, section := range sections {
+		for _, b := range section.bindings {
+			keyWidth = max(keyWidth, lipgloss.Width(m.chrome(b.Help().Key)))
+		}
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.theme.Selected))
+	keyStyle := lipgloss.NewStyle().Width(keyWidth).Foreground(lipgloss.Color(m.theme.Highlight))
+	descStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Normal))
+
+	var b strings.Builder
+	for i, section := range sections {
+		if i > 0 {
+			b.WriteString("\n\n")
+		}
+		b.WriteString(titleStyle.Render(section.title))
+		for _, binding := range section.bindings {
+			help := binding.Help()
+			b.WriteString("\n" + keyStyle.Render(m.chrome(help.Key)) + "  " + descStyle.Render(help.Desc))
+		}
+	}
+	b.WriteString("\n\n" + lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Help)).Render("? or esc to close"))
+
+	box := lipgloss.NewStyle().
+		Border(m.boxBorder()).
+		BorderForeground(lipgloss.Color(m.theme.Dimmed)).
+		Padding(0, 2).
+		Render(b.String())
+	return lipgloss.Place(m.width, height, lipgloss.Center, lipgloss.Center, box)
+}
@@ -0,0 +1,435 @@
+package ui
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// jsonKind is the kind of a JSON value
+type jsonKind int
+
+const (
+	jsonScalar jsonKind = iota
+	jsonObject
+	jsonArray
+)
+
+// jsonNode is a value of a JSON document
+type jsonNode struct {
+	kind jsonKind
+	// key is the raw key of object members, empty otherwise
+	key string
+	// path is the jq path of the value, like .config.Env[3]
+	path string
+	// raw is the text of strings, numbers and literals as in the input
+	raw      string
+	children []*jsonNode
+
+	collapsed bool // children are hidden
+	expanded  bool // a long scalar is shown in full
+}
+
+// jsonRow is a line of the tree: a value, or the closing bracket of an
+// object or array shown with its children
+type jsonRow struct {
+	node    *jsonNode
+	depth   int
+	closing bool
+	last    bool // the last value of its parent, without a comma
+}
+
+// identifierPattern matches the object keys written as .key in jq paths
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseJSON reads a JSON document into a tree. Strings and numbers keep the
+// text of the input.
+func parseJSON(input []byte) (*jsonNode, error) {
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	p := &jsonParser{input: input, dec: dec}
+	root, err := p.value(".")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("invalid JSON: data after the top-level value")
+	}
+	return root, nil
+}
+
+type jsonParser struct {
+	input  []byte
+	dec    *json.Decoder
+	offset int64
+}
+
+// token returns the next token and its raw text
+func (p *jsonParser) token() (json.Token, string, error) {
+	tok, err := p.dec.Token()
+	if err != nil {
+		return nil, "", err
+	}
+	// Raw text of the token without preceding whitespace and separators
+	raw := strings.TrimLeft(string(p.input[p.offset:p.dec.InputOffset()]), " \t\r\n,:")
+	p.offset = p.dec.InputOffset()
+	return tok, raw, nil
+}
+
+func (p *jsonParser) value(path string) (*jsonNode, error) {
+	tok, raw, err := p.token()
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	d, ok := tok.(json.Delim)
+	if !ok {
+		return &jsonNode{kind: jsonScalar, path: path, raw: raw}, nil
+	}
+
+	n := &jsonNode{kind: jsonArray, path: path}
+	if d == '{' {
+		n.kind = jsonObject
+	}
+	for p.dec.More() {
+		childPath := elementPath(path, len(n.children))
+		var key string
+		if n.kind == jsonObject {
+			tok, raw, err := p.token()
+			if err != nil {
+				return nil, fmt.Errorf("invalid JSON: %w", err)
+			}
+			key = raw
+			childPath = memberPath(path, tok.(string))
+		}
+		child, err := p.value(childPath)
+		if err != nil {
+			return nil, err
+		}
+		child.key = key
+		n.children = append(n.children, child)
+	}
+	// The closing bracket
+	if _, _, err := p.token(); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return n, nil
+}
+
+// memberPath returns the jq path of the member of an object
+func memberPath(parent, key string) string {
+	if identifierPattern.MatchString(key) {
+		return strings.TrimSuffix(parent, ".") + "." + key
+	}
+	quoted, _ := json.Marshal(key)
+	return parent + "[" + string(quoted) + "]"
+}
+
+// elementPath returns the jq path of the element of an array
+func elementPath(parent string, index int) string {
+	return fmt.Sprintf("%s[%d]", parent, index)
+}
+
+// value returns the value of the node as copied to the clipboard: the
+// content of strings, the text of other scalars and indented JSON for
+// objects and arrays
+func (n *jsonNode) value() string {
+	if n.kind == jsonScalar {
+		var s string
+		if err := json.Unmarshal([]byte(n.raw), &s); err == nil {
+			return s
+		}
+		return n.raw
+	}
+	var b strings.Builder
+	n.writeJSON(&b, "")
+	return b.String()
+}
+
+func (n *jsonNode) writeJSON(b *strings.Builder, indent string) {
+	if n.kind == jsonScalar {
+		b.WriteString(n.raw)
+		return
+	}
+	open, close := "[", "]"
+	if n.kind == jsonObject {
+		open, close = "{", "}"
+	}
+	b.WriteString(open)
+	for i, child := range n.children {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		b.WriteString("\n" + indent + "  ")
+		if n.kind == jsonObject {
+			b.WriteString(child.key + ": ")
+		}
+		child.writeJSON(b, indent+"  ")
+	}
+	if len(n.children) > 0 {
+		b.WriteString("\n" + indent)
+	}
+	b.WriteString(close)
+}
+
+// jsonTree is a viewer of JSON documents whose objects and arrays can be
+// collapsed, and whose long values are cut to the width until expanded
+type jsonTree struct {
+	root   *jsonNode
+	rows   []jsonRow
+	lines  []string // rendered rows, several for expanded long values
+	start  []int    // index in lines of the first line of each row
+	cursor int      // selected row
+	offset int      // first line shown
+	width  int
+	height int
+	colors jsonColors
+	ascii  bool // markers and ellipses in plain characters
+	keys   keyMap
+}
+
+// newJSONTree creates a viewer of a JSON document with all of its objects
+// and arrays expanded
+func newJSONTree(input []byte, width, height int, colors jsonColors, ascii bool, keys keyMap) (jsonTree, error) {
+	root, err := parseJSON(input)
+	if err != nil {
+		return jsonTree{}, err
+	}
+	t := jsonTree{root: root, width: width, height: height, colors: colors, ascii: ascii, keys: keys}
+	t.refresh()
+	return t, nil
+}
+
+// SetSize sets the size of the viewer
+func (t *jsonTree) SetSize(width, height int) {
+	t.width, t.height = width, height
+	t.refresh()
+}
+
+// Selected returns the selected value
+func (t jsonTree) Selected() *jsonNode {
+	if t.cursor >= len(t.rows) {
+		return nil
+	}
+	return t.rows[t.cursor].node
+}
+
+// Position returns the 1-based position of the selected row and the number
+// of rows
+func (t jsonTree) Position() (int, int) {
+	if len(t.rows) == 0 {
+		return 0, 0
+	}
+	return t.cursor + 1, len(t.rows)
+}
+
+func (t jsonTree) Update(msg tea.Msg) (jsonTree, tea.Cmd) {
+	keyMsg, ok := msg.(tea.KeyMsg)
+	if !ok || len(t.rows) == 0 {
+		return t, nil
+	}
+	switch {
+	case key.Matches(keyMsg, t.keys.up):
+		t.cursor = max(t.cursor-1, 0)
+	case key.Matches(keyMsg, t.keys.down):
+		t.cursor = min(t.cursor+1, len(t.rows)-1)
+	case key.Matches(keyMsg, t.keys.first):
+		t.cursor = 0
+	case key.Matches(keyMsg, t.keys.last):
+		t.cursor = len(t.rows) - 1
+	case key.Matches(keyMsg, t.keys.pageUp):
+		t.cursor = max(t.cursor-t.height, 0)
+	case key.Matches(keyMsg, t.keys.pageDown):
+		t.cursor = min(t.cursor+t.height, len(t.rows)-1)
+	case key.Matches(keyMsg, t.keys.enter):
+		t.toggle()
+	}
+	t.scroll()
+	return t, nil
+}
+
+// toggle collapses or expands the selected object or array, or shows the
+// selected long value in full or cut
+func (t *jsonTree) toggle() {
+	row := t.rows[t.cursor]
+	n := row.node
+	if n.kind == jsonScalar {
+		n.expanded = !n.expanded
+	} else if len(n.children) > 0 {
+		n.collapsed = !n.collapsed
+	}
+	t.refresh()
+	// Keep the cursor on the value when its closing bracket is hidden
+	for i, r := range t.rows {
+		if r.node == n && !r.closing {
+			t.cursor = i
+			break
+		}
+	}
+}
+
+// refresh lists the visible rows and renders them
+func (t *jsonTree) refresh() {
+	t.rows = t.rows[:0]
+	t.addRows(t.root, 0, true)
+	t.cursor = min(t.cursor, max(len(t.rows)-1, 0))
+
+	t.lines, t.start = t.lines[:0], t.start[:0]
+	for i, row := range t.rows {
+		t.start = append(t.start, len(t.lines))
+		t.lines = append(t.lines, t.renderRow(row, i == t.cursor)...)
+	}
+	t.scroll()
+}
+
+func (t *jsonTree) addRows(n *jsonNode, depth int, last bool) {
+	t.rows = append(t.rows, jsonRow{node: n, depth: depth, last: last})
+	if n.kind == jsonScalar || n.collapsed || len(n.children) == 0 {
+		return
+	}
+	for i, child := range n.children {
+		t.addRows(child, depth+1, i == len(n.children)-1)
+	}
+	t.rows = append(t.rows, jsonRow{node: n, depth: depth, closing: true, last: last})
+}
+
+// renderRow renders a row, on several lines if it is an expanded long value
+func (t *jsonTree) renderRow(row jsonRow, selected bool) []string {
+	paint := func(color, text string) string {
+		if color == "" {
+			return text
+		}
+		return color + text + t.colors.reset
+	}
+	n := row.node
+
+	cursor := "  "
+	if selected {
+		cursor = "> "
+	}
+	prefix := cursor + strings.Repeat("  ", row.depth)
+	comma := ""
+	if !row.last {
+		comma = paint(t.colors.delim, ",")
+	}
+	if row.closing {
+		return []string{prefix + "  " + paint(t.colors.delim, closingBracket(n)) + comma}
+	}
+
+	expanded, collapsed, ellipsis := "▾ ", "▸ ", "…"
+	if t.ascii {
+		expanded, collapsed, ellipsis = "- ", "+ ", "..."
+	}
+	marker := "  "
+	if n.kind != jsonScalar && len(n.children) > 0 {
+		marker = expanded
+		if n.collapsed {
+			marker = collapsed
+		}
+	}
+	line := prefix + marker
+	if n.key != "" {
+		line += paint(t.colors.key, sanitizeValue(n.key)) + paint(t.colors.delim, ":") + " "
+	}
+
+	switch {
+	case n.kind != jsonScalar && len(n.children) == 0:
+		return []string{line + paint(t.colors.delim, openingBracket(n)+closingBracket(n)) + comma}
+	case n.kind != jsonScalar && n.collapsed:
+		return []string{line + paint(t.colors.delim, openingBracket(n)+ellipsis+closingBracket(n)) + comma}
+	case n.kind != jsonScalar:
+		return []string{line + paint(t.colors.delim, openingBracket(n))}
+	}
+
+	color := t.colors.literal
+	switch {
+	case strings.HasPrefix(n.raw, `"`):
+		color = t.colors.str
+	case n.raw != "true" && n.raw != "false" && n.raw != "null":
+		color = t.colors.number
+	}
+	value := sanitizeValue(n.raw)
+	// The comma is kept on the screen as well
+	room := max(t.width-lipgloss.Width(line)-1, 10)
+	if lipgloss.Width(value) <= room {
+		return []string{line + paint(color, value) + comma}
+	}
+	if !n.expanded {
+		return []string{line + paint(color, truncate(value, room-lipgloss.Width(ellipsis))) + paint(t.colors.delim, ellipsis)}
+	}
+
+	// Long values are wrapped under the key
+	wrapped := strings.Split(lipgloss.NewStyle().Width(room).Render(value), "\n")
+	indent := strings.Repeat(" ", lipgloss.Width(line))
+	lines := make([]string, len(wrapped))
+	for i, w := range wrapped {
+		w = strings.TrimRight(w, " ")
+		if i == 0 {
+			lines[i] = line + paint(color, w)
+		} else {
+			lines[i] = indent + paint(color, w)
+		}
+	}
+	lines[len(lines)-1] += comma
+	return lines
+}
+
+func openingBracket(n *jsonNode) string {
+	if n.kind == jsonObject {
+		return "{"
+	}
+	return "["
+}
+
+func closingBracket(n *jsonNode) string {
+	if n.kind == jsonObject {
+		return "}"
+	}
+	return "]"
+}
+
+// scroll keeps the lines of the selected row on the screen and marks it
+func (t *jsonTree) scroll() {
+	if len(t.rows) == 0 {
+		return
+	}
+	// Only the rows whose cursor changes are rendered again
+	for i, row := range t.rows {
+		if i < len(t.start) && strings.HasPrefix(t.lines[t.start[i]], "> ") != (i == t.cursor) {
+			t.replaceRow(i, t.renderRow(row, i == t.cursor))
+		}
+	}
+
+	first := t.start[t.cursor]
+	end := len(t.lines)
+	if t.cursor+1 < len(t.start) {
+		end = t.start[t.cursor+1]
+	}
+	if first < t.offset {
+		t.offset = first
+	} else if end > t.offset+t.height {
+		t.offset = max(min(end-t.height, first), 0)
+	}
+	t.offset = max(min(t.offset, len(t.lines)-t.height), 0)
+}
+
+// replaceRow replaces the lines of the row with lines of the same number
+func (t *jsonTree) replaceRow(i int, lines []string) {
+	copy(t.lines[t.start[i]:], lines)
+}
+
+func (t jsonTree) View() string {
+	end := min(t.offset+t.height, len(t.lines))
+	lines := t.lines[t.offset:end]
+	for len(lines) < t.height {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, "\n")
+}
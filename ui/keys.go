@@ -3,23 +3,95 @@ package ui
 import "github.com/charmbracelet/bubbles/key"
 
 type keyMap struct {
-	quit         key.Binding
-	enter        key.Binding
-	back         key.Binding
-	toggleHidden key.Binding
-	export       key.Binding
-	nextTab      key.Binding
-	prevTab      key.Binding
-	copyDiffID   key.Binding
-	copyPath     key.Binding
+	up                 key.Binding
+	down               key.Binding
+	first              key.Binding
+	last               key.Binding
+	pageUp             key.Binding
+	pageDown           key.Binding
+	quit               key.Binding
+	cancel             key.Binding
+	retry              key.Binding
+	enter              key.Binding
+	back               key.Binding
+	toggleHidden       key.Binding
+	export             key.Binding
+	nextTab            key.Binding
+	prevTab            key.Binding
+	copyDiffID         key.Binding
+	copyPath           key.Binding
+	copyFullPath       key.Binding
+	copyDigest         key.Binding
+	copyCommand        key.Binding
+	copyLabel          key.Binding
+	copyImageID        key.Binding
+	copyManifestDigest key.Binding
+	copyPullCommand    key.Binding
+	copyRunCommand     key.Binding
+	copyValue          key.Binding
+	toggleDigest       key.Binding
+	toggleHistory      key.Binding
+	toggleTime         key.Binding
+	togglePreview      key.Binding
+	toggleIcons        key.Binding
+	showLog            key.Binding
+	compare            key.Binding
+	reload             key.Binding
+	refresh            key.Binding
+	diskUsage          key.Binding
+	fileTypes          key.Binding
+	recent             key.Binding
+	kind               key.Binding
+	sort               key.Binding
+	reverseSort        key.Binding
+	command            key.Binding
+	mark               key.Binding
+	markFile           key.Binding
+	filter             key.Binding
+	help               key.Binding
+	editRef            key.Binding
 }
 
 func newKeyMap() keyMap {
 	return keyMap{
+		// Navigation is handled by the list, file picker and viewport. The
+		// bindings describe it in the help.
+		up: key.NewBinding(
+			key.WithKeys("up", "k"),
+			key.WithHelp("↑/k", "up"),
+		),
+		down: key.NewBinding(
+			key.WithKeys("down", "j"),
+			key.WithHelp("↓/j", "down"),
+		),
+		first: key.NewBinding(
+			key.WithKeys("g"),
+			key.WithHelp("g", "first"),
+		),
+		last: key.NewBinding(
+			key.WithKeys("G"),
+			key.WithHelp("G", "last"),
+		),
+		pageUp: key.NewBinding(
+			key.WithKeys("K", "pgup"),
+			key.WithHelp("K/pgup", "page up"),
+		),
+		pageDown: key.NewBinding(
+			key.WithKeys("J", "pgdown"),
+			key.WithHelp("J/pgdown", "page down"),
+		),
 		quit: key.NewBinding(
 			key.WithKeys("q", "ctrl+c"),
 			key.WithHelp("q", "quit"),
 		),
+		cancel: key.NewBinding(
+			key.WithKeys("esc", "ctrl+c"),
+			key.WithHelp("esc", "cancel loading"),
+		),
+		retry: key.NewBinding(
+			key.WithKeys("r"),
+			key.WithHelp("r", "retry"),
+		),
 		enter: key.NewBinding(
 			key.WithKeys("enter", "l", "right"),
 			key.WithHelp("enter/l/→", "view/open"),
@@ -45,13 +117,133 @@ func newKeyMap() keyMap {
 			key.WithHelp("shift+tab", "previous tab"),
 		),
 		copyDiffID: key.NewBinding(
-			key.WithKeys("y y"),
+			key.WithKeys("yy"),
 			key.WithHelp("yy", "copy diff ID"),
 		),
 		copyPath: key.NewBinding(
-			key.WithKeys("y", "p"),
+			key.WithKeys("yp"),
 			key.WithHelp("yp", "copy path"),
 		),
+		copyFullPath: key.NewBinding(
+			key.WithKeys("yf"),
+			key.WithHelp("yf", "copy path with image and layer"),
+		),
+		copyDigest: key.NewBinding(
+			key.WithKeys("yd"),
+			key.WithHelp("yd", "copy digest"),
+		),
+		copyCommand: key.NewBinding(
+			key.WithKeys("yc"),
+			key.WithHelp("yc", "copy command"),
+		),
+		copyLabel: key.NewBinding(
+			key.WithKeys("yl"),
+			key.WithHelp("yl", "copy as key=value"),
+		),
+		copyImageID: key.NewBinding(
+			key.WithKeys("yi"),
+			key.WithHelp("yi", "copy image ID"),
+		),
+		copyManifestDigest: key.NewBinding(
+			key.WithKeys("ym"),
+			key.WithHelp("ym", "copy manifest digest"),
+		),
+		copyPullCommand: key.NewBinding(
+			key.WithKeys("yP"),
+			key.WithHelp("yP", "copy docker pull command"),
+		),
+		copyRunCommand: key.NewBinding(
+			key.WithKeys("yR"),
+			key.WithHelp("yR", "copy docker run command"),
+		),
+		copyValue: key.NewBinding(
+			key.WithKeys("yv"),
+			key.WithHelp("yv", "copy value"),
+		),
+		toggleHistory: key.NewBinding(
+			key.WithKeys("H"),
+			key.WithHelp("H", "toggle build steps without a layer"),
+		),
+		toggleDigest: key.NewBinding(
+			key.WithKeys("D"),
+			key.WithHelp("D", "toggle diff ID/digest"),
+		),
+		mark: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "mark layer to view together"),
+		),
+		markFile: key.NewBinding(
+			key.WithKeys(" "),
+			key.WithHelp("space", "mark file"),
+		),
+		command: key.NewBinding(
+			key.WithKeys("c"),
+			key.WithHelp("c", "show full command"),
+		),
+		toggleTime: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "toggle relative time"),
+		),
+		togglePreview: key.NewBinding(
+			key.WithKeys("p"),
+			key.WithHelp("p", "toggle preview"),
+		),
+		toggleIcons: key.NewBinding(
+			key.WithKeys("i"),
+			key.WithHelp("i", "toggle icons"),
+		),
+		compare: key.NewBinding(
+			key.WithKeys("d"),
+			key.WithHelp("d", "diff with another image"),
+		),
+		reload: key.NewBinding(
+			key.WithKeys("R"),
+			key.WithHelp("R", "reload the image once rebuilt (--watch)"),
+		),
+		refresh: key.NewBinding(
+			key.WithKeys("U"),
+			key.WithHelp("U", "pull the local image again from the registry"),
+		),
+		diskUsage: key.NewBinding(
+			key.WithKeys("u"),
+			key.WithHelp("u", "show disk usage"),
+		),
+		fileTypes: key.NewBinding(
+			key.WithKeys("t"),
+			key.WithHelp("t", "toggle files and file types"),
+		),
+		recent: key.NewBinding(
+			key.WithKeys("m"),
+			key.WithHelp("m", "list recently modified files"),
+		),
+		kind: key.NewBinding(
+			key.WithKeys("f"),
+			key.WithHelp("f", "only executables, setuid/setgid, symlinks or root's"),
+		),
+		showLog: key.NewBinding(
+			key.WithKeys("f12"),
+			key.WithHelp("f12", "show the log"),
+		),
+		sort: key.NewBinding(
+			key.WithKeys("s"),
+			key.WithHelp("s", "sort by name, size or time"),
+		),
+		reverseSort: key.NewBinding(
+			key.WithKeys("S"),
+			key.WithHelp("S", "reverse sort order"),
+		),
+		filter: key.NewBinding(
+			key.WithKeys("/"),
+			key.WithHelp("/", "filter"),
+		),
+		help: key.NewBinding(
+			key.WithKeys("?"),
+			key.WithHelp("?", "toggle help"),
+		),
+		editRef: key.NewBinding(
+			key.WithKeys("e"),
+			key.WithHelp("e", "edit reference"),
+		),
 	}
 }
 
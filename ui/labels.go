@@ -0,0 +1,164 @@
+package ui
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+type labelsMsg struct {
+	labels map[string]string
+	err    error
+}
+
+type annotationsMsg struct {
+	annotations []container.Annotation
+	err         error
+}
+
+// labelItem is a label of the image config, or an annotation of the
+// manifest or index
+type labelItem struct {
+	source     string // "index" or "manifest" for annotations
+	key, value string
+}
+
+func (i labelItem) FilterValue() string {
+	return strings.TrimSpace(i.source + " " + i.key + " " + i.value)
+}
+
+// labelDelegate renders the labels and annotations as a table of keys and
+// values, after their sources if any
+type labelDelegate struct {
+	sourceWidth int
+	keyWidth    int
+	source      lipgloss.Style
+	key         lipgloss.Style
+	value       lipgloss.Style
+	selected    lipgloss.Style
+}
+
+func (d labelDelegate) Height() int                         { return 1 }
+func (d labelDelegate) Spacing() int                        { return 0 }
+func (d labelDelegate) Update(tea.Msg, *list.Model) tea.Cmd { return nil }
+func (d labelDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	label, ok := item.(labelItem)
+	if !ok {
+		return
+	}
+	cursor, source, key, value := "  ", d.source, d.key, d.value
+	if index == m.Index() {
+		cursor, source, key, value = "> ", d.selected, d.selected, d.selected
+	}
+	if d.sourceWidth > 0 {
+		cursor += source.Width(d.sourceWidth).Render(label.source) + "  "
+	}
+	// Keys and values are cut to keep the table on one line per label
+	keyText := truncate(filepicker.SanitizeName(label.key), d.keyWidth)
+	valueText := truncate(filepicker.SanitizeName(label.value), max(m.Width()-lipgloss.Width(cursor)-d.keyWidth-2, 10))
+	fmt.Fprint(w, cursor+key.Width(d.keyWidth).Render(keyText)+"  "+value.Render(valueText))
+}
+
+// truncate cuts s to width cells
+func truncate(s string, width int) string {
+	return lipgloss.NewStyle().MaxWidth(width).Render(s)
+}
+
+// newLabelList creates the table of labels or annotations. The names of the
+// items, like "label" and "labels", are shown in the status bar of the list.
+func newLabelList(labels []labelItem, singular, plural string, width, height int, theme Theme) list.Model {
+	items := make([]list.Item, 0, len(labels))
+	d := labelDelegate{
+		source:   lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Dimmed)),
+		key:      lipgloss.NewStyle().Foreground(lipgloss.Color(theme.JSONKey)),
+		value:    lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Normal)),
+		selected: lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Selected)).Bold(true),
+	}
+	for _, label := range labels {
+		items = append(items, label)
+		d.sourceWidth = max(d.sourceWidth, lipgloss.Width(label.source))
+		d.keyWidth = max(d.keyWidth, lipgloss.Width(filepicker.SanitizeName(label.key)))
+	}
+	// Long keys don't push the values out of the screen
+	d.keyWidth = min(d.keyWidth, max(width/2, 10))
+
+	l := list.New(items, d, width, height)
+	l.SetShowTitle(false)
+	l.SetFilteringEnabled(true)
+	l.DisableQuitKeybindings()
+	l.SetShowHelp(false)
+	l.SetStatusBarItemName(singular, plural)
+	l.Styles.FilterPrompt = l.Styles.FilterPrompt.Foreground(lipgloss.Color(theme.Highlight))
+	l.Styles.FilterCursor = l.Styles.FilterCursor.Foreground(lipgloss.Color(theme.Highlight))
+	l.Styles.NoItems = l.Styles.NoItems.Foreground(lipgloss.Color(theme.Dimmed)).SetString("The image has no " + plural)
+	return l
+}
+
+// labelItems returns the labels sorted by key
+func labelItems(labels map[string]string) []labelItem {
+	items := make([]labelItem, 0, len(labels))
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		items = append(items, labelItem{key: k, value: labels[k]})
+	}
+	return items
+}
+
+// annotationItems returns the annotations in their order
+func annotationItems(annotations []container.Annotation) []labelItem {
+	items := make([]labelItem, 0, len(annotations))
+	for _, a := range annotations {
+		items = append(items, labelItem{source: a.Source, key: a.Key, value: a.Value})
+	}
+	return items
+}
+
+// loadLabels reads the labels of the image
+func loadLabels(image *container.Image) tea.Cmd {
+	return func() tea.Msg {
+		labels, err := image.Labels()
+		return labelsMsg{labels: labels, err: err}
+	}
+}
+
+// loadAnnotations reads the annotations of the manifest and index of the
+// image
+func loadAnnotations(image *container.Image) tea.Cmd {
+	return func() tea.Msg {
+		annotations, err := image.Annotations()
+		return annotationsMsg{annotations: annotations, err: err}
+	}
+}
+
+// copyLabelValue copies the value of the selected label or annotation
+func (m *Model) copyLabelValue() tea.Cmd {
+	item, ok := m.table.SelectedItem().(labelItem)
+	if !ok {
+		return nil
+	}
+	return copyToClipboard(m.tableItemName()+" value", item.value)
+}
+
+// copyLabel copies the selected label or annotation as key=value
+func (m *Model) copyLabel() tea.Cmd {
+	item, ok := m.table.SelectedItem().(labelItem)
+	if !ok {
+		return nil
+	}
+	return copyToClipboard(m.tableItemName(), item.key+"="+item.value)
+}
+
+// tableItemName returns what the rows of the table are
+func (m *Model) tableItemName() string {
+	if m.mode == AnnotationsMode {
+		return "annotation"
+	}
+	return "label"
+}
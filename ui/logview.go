@@ -0,0 +1,93 @@
+package ui
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/charmbracelet/bubbles/viewport"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// logTailBytes is how much of the end of the log is shown
+const logTailBytes = 256 << 10
+
+type logMsg struct {
+	content string
+	err     error
+}
+
+// SetLogFile sets the log file of sou shown by the log key. An empty path
+// means that logging is off.
+func (m *Model) SetLogFile(path string) {
+	m.logFile = path
+}
+
+// showLog loads the end of the log, unless logging is off
+func (m *Model) showLog() tea.Cmd {
+	if m.logFile == "" {
+		m.message = "Logging is off, see --log-level"
+		return hideMessageAfter(3 * time.Second)
+	}
+	path := m.logFile
+	return func() tea.Msg {
+		content, err := readTail(path, logTailBytes)
+		if err != nil {
+			return logMsg{err: err}
+		}
+		return logMsg{content: sanitizeCommand(string(content))}
+	}
+}
+
+// openLog shows the end of the log in the viewport, scrolled to the last
+// line. Going back returns to the current mode.
+func (m *Model) openLog(msg logMsg) tea.Cmd {
+	if m.mode == LoadingMode || m.mode == PullingMode {
+		return nil
+	}
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Failed to read the log: %v", msg.err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	content := msg.content
+	if content == "" {
+		content = "The log is empty"
+	}
+	if m.mode != LogMode {
+		m.logReturn = m.mode
+		m.logViewport = m.viewport
+	}
+	m.mode = LogMode
+	m.viewport = viewport.New(m.width-4, m.height-6)
+	m.viewport.SetContent(content)
+	m.viewport.GotoBottom()
+	return m.announce("Showing the end of %s", filepicker.SanitizeName(m.logFile))
+}
+
+// readTail reads the last n bytes of a file, starting at a line
+func readTail(path string, n int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := max(info.Size()-n, 0)
+	content, err := io.ReadAll(io.NewSectionReader(f, offset, info.Size()-offset))
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		// Drop the line cut in the middle
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			content = content[i+1:]
+		}
+	}
+	return content, nil
+}
@@ -1,28 +1,34 @@
 package ui
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/fs"
 	"log/slog"
 	"os"
-	"os/exec"
+	"path"
 	"path/filepath"
-	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/progress"
 	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/google/go-containerregistry/pkg/name"
-	"github.com/google/go-containerregistry/pkg/v1/daemon"
 	"github.com/knqyf263/sou/container"
 	"github.com/knqyf263/sou/ui/filepicker"
+	"github.com/muesli/termenv"
 )
 
 func debug(format string, v ...interface{}) {
@@ -39,8 +45,20 @@ const (
 	ManifestMode
 	ConfigMode
 	PullingMode
-	padding  = 2
-	maxWidth = 100
+	ErrorMode   // the image couldn't be loaded
+	CommandMode // full command of a layer
+	LabelsMode
+	AnnotationsMode // annotations of the manifest and index
+	RuntimeMode     // summary of the runtime configuration
+	LogMode         // end of the log of sou
+	DiffMode        // diff of the viewed file with the file of another image
+	TagsMode        // tags of the repository to pick one from, for a reference without a tag
+	ReposMode       // repositories of the registry browsed
+	BlobMode        // blob of a layer that isn't a tar archive
+	UsageMode       // disk usage of the files of a layer, largest first
+	RecentMode      // files of a layer, the most recently modified first
+	padding         = 2
+	maxWidth        = 100
 )
 
 type errMsg struct {
@@ -52,52 +70,101 @@ type imageLoadedMsg struct {
 	isLocalImage bool
 }
 
-type progressMsg float64
-
 type layerItem struct {
-	diffID  string
-	size    int64
-	command string
+	diffID     string
+	digest     string // blob digest, empty if unknown
+	size       int64
+	sizeFormat filepicker.SizeFormat
+	imageSize  int64 // total size of the layers
+	command    string
+	created    string // formatted creation time, empty if unknown
+	showDigest bool   // describe the layer by its blob digest
+	marked     bool   // marked to be viewed with other layers
+	mediaType  string // media type of a layer that isn't a tar archive
 }
 
 func (i layerItem) Title() string {
+	if i.marked {
+		return "✓ " + i.command
+	}
 	return i.command
 }
 
-func formatSize(size int64) string {
-	const unit = 1024
-	if size < unit {
-		return fmt.Sprintf("%d B", size)
+func (i layerItem) Description() string {
+	id := "DiffID: " + i.diffID
+	if i.showDigest {
+		digest := i.digest
+		if digest == "" {
+			digest = "unknown"
+		}
+		id = "Digest: " + digest
+	}
+	size := filepicker.FormatSize(i.size, i.sizeFormat)
+	if i.imageSize > 0 {
+		size += " (" + formatShare(i.size, i.imageSize) + ")"
+	}
+	desc := fmt.Sprintf("%s  Size: %s", id, size)
+	if i.mediaType != "" {
+		desc += "  Type: " + filepicker.SanitizeName(i.mediaType)
 	}
-	div, exp := int64(unit), 0
-	for n := size / unit; n >= unit; n /= unit {
-		div *= unit
-		exp++
+	if i.created != "" {
+		desc += "  Created: " + i.created
 	}
-	return fmt.Sprintf("%.1f %cB", float64(size)/float64(div), "KMGTPE"[exp])
+	return desc
 }
 
-func (i layerItem) Description() string {
-	return fmt.Sprintf("DiffID: %s  Size: %s", i.diffID, formatSize(i.size))
+// formatShare formats the share of size in total as a percentage, like
+// "62%". Shares rounding to zero are shown as "<1%" unless they are empty.
+func formatShare(size, total int64) string {
+	percent := (size*100 + total/2) / total
+	if percent == 0 && size > 0 {
+		return "<1%"
+	}
+	return fmt.Sprintf("%d%%", percent)
 }
 
+// FilterValue starts with the title so that the matches are highlighted in
+// it
 func (i layerItem) FilterValue() string {
-	return i.command + " " + i.diffID
+	return i.Title() + " " + i.diffID + " " + i.digest
+}
+
+// historyItem is a build step that created no layer, like ENV or LABEL
+type historyItem struct {
+	command string
+	created string // formatted creation time, empty if unknown
+}
+
+func (i historyItem) Title() string {
+	return i.command
+}
+
+func (i historyItem) Description() string {
+	if i.created == "" {
+		return "No layer"
+	}
+	return "No layer  Created: " + i.created
+}
+
+func (i historyItem) FilterValue() string {
+	return i.command
 }
 
 type fileItem struct {
-	file container.File
+	file    container.File
+	size    string // formatted size
+	modTime string // formatted modification time
 }
 
 func (i fileItem) Title() string {
 	if i.file.IsDir {
-		return i.file.Name + "/"
+		return filepicker.SanitizeName(i.file.Name) + "/"
 	}
-	return i.file.Name
+	return filepicker.SanitizeName(i.file.Name)
 }
 
 func (i fileItem) Description() string {
-	return fmt.Sprintf("%s  %s  %s", i.file.Mode, formatSize(i.file.Size), i.file.ModTime)
+	return fmt.Sprintf("%s  %s  %s  %s", i.file.Mode, i.file.Owner, i.size, i.modTime)
 }
 
 func (i fileItem) FilterValue() string {
@@ -106,16 +173,36 @@ func (i fileItem) FilterValue() string {
 
 type Model struct {
 	list           list.Model
+	table          list.Model // labels, annotations or tags in LabelsMode, AnnotationsMode and TagsMode
+	tags           []string   // tags to pick from in TagsMode, nil while they are listed
+	repos          []string   // repositories to pick from in ReposMode, nil while they are listed
+	browse         string     // registry browsed, if any
+	tree           jsonTree   // manifest or config in ManifestMode and ConfigMode
+	document       []byte     // JSON of the manifest or config shown
+	queryInput     textinput.Model
+	editingQuery   bool
+	compareInput   textinput.Model
+	editingCompare bool
+	compareRef     string // image the viewed file or config was last compared with
+	fileContent    []byte // content of the file in ViewMode
+	query          string // jq query whose result is shown in the tree
+	queryResult    []byte
 	viewport       viewport.Model
 	filepicker     filepicker.Model
 	keys           keyMap
 	mode           Mode
+	ref            string
 	ready          bool
 	width          int
 	height         int
 	image          *container.Image
 	currentLayer   *container.Layer
 	pendingLayer   *container.Layer
+	loadingLayer   *container.Layer
+	staleLayers    []*container.Layer // layers left while exports read them
+	rate           transferRate
+	blobs          []container.Progress     // blobs fetched by the pull
+	blobRates      map[string]*transferRate // speed of the blobs being downloaded, by blob
 	currentPath    string
 	currentFile    *container.File
 	message        string
@@ -123,12 +210,67 @@ type Model struct {
 	activeTab      int
 	tabStyle       lipgloss.Style
 	activeTabStyle lipgloss.Style
-	progress       float64
+	progress       container.Progress
+	reporter       *progressReporter
+	cancel         context.CancelFunc
+	retry          func() tea.Cmd
 	loadingBar     progress.Model
 	spinner        spinner.Model
 	isLocalImage   bool
+	pullPolicy     container.PullPolicy
+	openOptions    []container.Option
+	events         *EventWriter // progress written by --progress json, nil if off
+	ignoreCase     bool
+	theme          Theme
+	noColor        bool
+	ascii          bool
+	accessible     bool
+	timeFormat     filepicker.TimeFormat
+	sizeFormat     filepicker.SizeFormat
+	timeLocation   *time.Location
 	showHelp       bool
-	pendingKey     string
+	command        string             // command of the layer shown in CommandMode
+	blob           *container.Layer   // layer shown in BlobMode
+	blobHead       []byte             // first bytes of the blob, nil until read
+	blobErr        error              // why the blob couldn't be read
+	usage          *container.Usage   // disk usage of usageLayer, nil while computed
+	usageLayer     *container.Layer   // layer whose disk usage is shown
+	usageDirs      []*container.Usage // directories of the disk usage opened, the root first
+	usageIndex     int                // file selected in the disk usage
+	usageOffset    int                // first file of the disk usage shown
+	showTypes      bool               // show the file types of the directory of the disk usage
+	fileTypes      []container.FileType
+	recent         []container.File // files listed in RecentMode, nil while listed
+	recentLayer    *container.Layer // layer whose files are listed in RecentMode
+	showDigest     bool             // describe layers by their blob digests
+	showHistory    bool             // show the build steps without a layer
+	showPreview    bool             // preview the selected file next to the list
+	preview        filePreview      // preview of the selected file
+	showIcons      bool             // icons of the file types in the file list
+	showHidden     bool             // hidden files in the file list of every layer
+	startLayer     string           // layer opened once the image is loaded
+	startPath      string           // path shown in the layer opened at start
+	marked         map[string]bool  // diff IDs of the layers to view together
+	pendingKey     string           // first key of a key sequence
+	chordSeq       int              // ignores the timeouts of earlier sequences
+	exports        int              // exports in progress
+	exportCtx      context.Context  // canceled to abort the exports on quit
+	cancelExports  context.CancelFunc
+	err            error // why the image couldn't be loaded in ErrorMode
+	refInput       textinput.Model
+	editingRef     bool
+	confirmQuit    bool           // quitting waits for confirmation
+	logFile        string         // log of sou, empty if logging is off
+	logReturn      Mode           // mode to go back to from LogMode
+	logViewport    viewport.Model // viewport of the mode to go back to
+	diffReturn     Mode           // mode to go back to from DiffMode
+	diffViewport   viewport.Model // viewport of the mode to go back to
+	watchInterval  time.Duration  // how often the local image is checked, 0 not to
+	watchGen       int            // generation of the checks of the loaded image
+	watchID        string         // ID of the loaded image in the Docker daemon
+	rebuilt        bool           // the tag was rebuilt since the image was loaded
+	resume         *resumePoint   // where to browse the image being reloaded
+	quitting       bool           // waiting for the exports to be canceled
 }
 
 type loadingLayerMsg struct {
@@ -142,7 +284,8 @@ type viewFileMsg struct {
 }
 
 type exportFileMsg struct {
-	err error
+	path string // written file
+	err  error
 }
 
 type hideMessageMsg struct{}
@@ -183,7 +326,7 @@ func (d *containerDir) Close() error {
 }
 
 func (d *containerDir) Stat() (fs.FileInfo, error) {
-	return containerFileInfo{isDir: true}, nil
+	return containerFileInfo{isDir: true, mode: fs.ModeDir | 0o755}, nil
 }
 
 func (d *containerDir) ReadDir(n int) ([]fs.DirEntry, error) {
@@ -196,7 +339,7 @@ func (d *containerDir) ReadDir(n int) ([]fs.DirEntry, error) {
 			tarfsPath = tarfsPath[1:]
 		}
 
-		files, err := d.layer.GetFiles(tarfsPath)
+		files, err := d.layer.GetFiles(context.Background(), tarfsPath)
 		if err != nil {
 			return nil, err
 		}
@@ -239,23 +382,31 @@ func (e containerDirEntry) IsDir() bool {
 }
 
 func (e containerDirEntry) Type() fs.FileMode {
-	return fs.FileMode(0)
+	return e.file.FileMode.Type()
 }
 
 func (e containerDirEntry) Info() (fs.FileInfo, error) {
 	return containerFileInfo{
 		name:    e.file.Name,
 		size:    e.file.Size,
+		mode:    e.file.FileMode,
 		isDir:   e.file.IsDir,
-		modTime: time.Now(),
+		modTime: e.file.ModifiedAt,
+		major:   e.file.Devmajor,
+		minor:   e.file.Devminor,
+		uid:     e.file.Uid,
 	}, nil
 }
 
 type containerFileInfo struct {
 	name    string
 	size    int64
+	mode    fs.FileMode
 	isDir   bool
 	modTime time.Time
+	major   int64
+	minor   int64
+	uid     int
 }
 
 func (i containerFileInfo) Name() string {
@@ -267,7 +418,7 @@ func (i containerFileInfo) Size() int64 {
 }
 
 func (i containerFileInfo) Mode() fs.FileMode {
-	return fs.FileMode(0o644)
+	return i.mode
 }
 
 func (i containerFileInfo) ModTime() time.Time {
@@ -282,60 +433,61 @@ func (i containerFileInfo) Sys() interface{} {
 	return nil
 }
 
-// Global channel for progress updates
-var progressChan chan float64
+func (i containerFileInfo) Devmajor() int64 {
+	return i.major
+}
 
-type copyToClipboardMsg struct {
-	err error
+func (i containerFileInfo) Devminor() int64 {
+	return i.minor
 }
 
-// Add this function to get the appropriate clipboard command
-func getClipboardCmd() (cmd string, args []string) {
-	switch runtime.GOOS {
-	case "darwin":
-		return "pbcopy", nil
-	case "linux":
-		return "xclip", []string{"-selection", "clipboard"}
-	default:
-		return "", nil
-	}
+func (i containerFileInfo) Uid() int {
+	return i.uid
+}
+
+type copyToClipboardMsg struct {
+	what string // what has been copied, like "diff ID"
+	text string
+	err  error
 }
 
-func copyToClipboard(text string) tea.Cmd {
+func copyToClipboard(what, text string) tea.Cmd {
 	return func() tea.Msg {
 		debug("Attempting to copy text to clipboard: %s", text)
-
-		cmd, args := getClipboardCmd()
-		if cmd == "" {
-			err := fmt.Errorf("clipboard command not supported on this OS")
-			debug("Clipboard error: %v", err)
-			return copyToClipboardMsg{err: err}
-		}
-
-		debug("Using clipboard command: %s with args: %v", cmd, args)
-		clipCmd := exec.Command(cmd, args...)
-		clipCmd.Stdin = strings.NewReader(text)
-
-		if err := clipCmd.Run(); err != nil {
+		if err := filepicker.CopyToClipboard(text); err != nil {
 			debug("Failed to copy to clipboard: %v", err)
-			return copyToClipboardMsg{err: fmt.Errorf("failed to copy to clipboard: %w", err)}
+			return copyToClipboardMsg{what: what, text: text, err: fmt.Errorf("failed to copy to clipboard: %w", err)}
 		}
-
 		debug("Successfully copied to clipboard")
-		return copyToClipboardMsg{err: nil}
+		return copyToClipboardMsg{what: what, text: text}
 	}
 }
 
-// Custom colors for the application
-var (
-	selectedColor  = lipgloss.Color("#61AFEF") // A calm blue for selected items
-	normalColor    = lipgloss.Color("#ABB2BF") // A soft white for normal items
-	dimmedColor    = lipgloss.Color("#636D83") // A muted color for less important text
-	highlightColor = lipgloss.Color("#FFB86C") // A soft orange for highlights (filter, etc)
-)
+// layerDelegate renders the layers of the list, and the build steps without
+// a layer dimmed
+type layerDelegate struct {
+	list.DefaultDelegate
+	dimmed lipgloss.Color
+}
 
-// newCustomList creates a new list with custom styling
-func newCustomList(items []list.Item, width, height int) list.Model {
+func (d layerDelegate) Render(w io.Writer, m list.Model, index int, item list.Item) {
+	if _, ok := item.(historyItem); !ok {
+		d.DefaultDelegate.Render(w, m, index, item)
+		return
+	}
+	dimmed := d.DefaultDelegate
+	for _, style := range []*lipgloss.Style{
+		&dimmed.Styles.NormalTitle, &dimmed.Styles.NormalDesc,
+		&dimmed.Styles.SelectedTitle, &dimmed.Styles.SelectedDesc,
+	} {
+		*style = style.Foreground(d.dimmed)
+	}
+	dimmed.Render(w, m, index, item)
+}
+
+// newListDelegate creates the delegate rendering the layers in the theme
+func newListDelegate(theme Theme) layerDelegate {
+	selectedColor := lipgloss.Color(theme.Selected)
 	delegate := list.NewDefaultDelegate()
 
 	// Custom styles for the delegate
@@ -347,23 +499,33 @@ func newCustomList(items []list.Item, width, height int) list.Model {
 		Bold(true)
 
 	delegate.Styles.SelectedDesc = delegate.Styles.SelectedDesc.
-		Foreground(lipgloss.Color("#4B5669")). // Darker and more muted color for selected description
+		Foreground(lipgloss.Color(theme.SelectedDesc)).
 		Background(lipgloss.NoColor{}).
 		BorderLeft(true).
 		BorderLeftForeground(selectedColor)
 
 	delegate.Styles.NormalTitle = delegate.Styles.NormalTitle.
-		Foreground(normalColor).
+		Foreground(lipgloss.Color(theme.Normal)).
 		BorderLeft(true).
 		BorderLeftForeground(lipgloss.NoColor{})
 
 	delegate.Styles.NormalDesc = delegate.Styles.NormalDesc.
-		Foreground(lipgloss.Color("#3E4551")). // Even darker color for normal description
+		Foreground(lipgloss.Color(theme.NormalDesc)).
 		BorderLeft(true).
 		BorderLeftForeground(lipgloss.NoColor{})
 
+	delegate.Styles.FilterMatch = delegate.Styles.FilterMatch.Bold(true)
+
+	return layerDelegate{DefaultDelegate: delegate, dimmed: lipgloss.Color(theme.Dimmed)}
+}
+
+// newCustomList creates a new list styled with the theme
+func newCustomList(items []list.Item, width, height int, theme Theme) list.Model {
+	selectedColor := lipgloss.Color(theme.Selected)
+	highlightColor := lipgloss.Color(theme.Highlight)
+
 	// Create the list
-	l := list.New(items, delegate, width, height)
+	l := list.New(items, newListDelegate(theme), width, height)
 	l.SetShowTitle(false)
 	l.SetFilteringEnabled(true)
 	l.DisableQuitKeybindings()
@@ -382,33 +544,47 @@ func newCustomList(items []list.Item, width, height int) list.Model {
 		Foreground(highlightColor)
 
 	l.Styles.NoItems = l.Styles.NoItems.
-		Foreground(dimmedColor)
+		Foreground(lipgloss.Color(theme.Dimmed))
 
 	return l
 }
 
-func NewModel(ref string) (Model, tea.Cmd) {
-	// Check if image exists locally first
-	reference, err := name.ParseReference(ref)
-	if err != nil {
+// NewModel creates the model and the command loading the image according to
+// the pull policy. opts are passed to container.Open along with it.
+func NewModel(ref string, pullPolicy container.PullPolicy, opts ...container.Option) (Model, tea.Cmd) {
+	// SIF files and image archives are read from the disk like local images
+	if container.IsSIF(ref) || container.IsImageArchive(ref) {
+		m := newModel(ref, pullPolicy, opts)
+		m.isLocalImage = true
+		return m, m.pullImage()
+	}
+
+	if _, err := name.ParseReference(ref); err != nil {
 		return Model{}, func() tea.Msg {
 			return errMsg{fmt.Errorf("failed to parse reference: %w", err)}
 		}
 	}
 
-	isLocalImage := false
-	if _, err := daemon.Image(reference); err == nil {
-		debug("Found local image during initial check")
-		isLocalImage = true
-	} else {
-		debug("Image not found locally during initial check")
-	}
+	// Check if image exists locally first
+	isLocalImage := hasLocalImage(ref, pullPolicy, opts)
+	debug("Creating new model with isLocalImage=%v", isLocalImage)
+	m := newModel(ref, pullPolicy, opts)
+	m.isLocalImage = isLocalImage
 
-	// Create a new channel for progress updates
-	progressChan = make(chan float64, 100)
+	// A reference without a tag lets the user pick one rather than open
+	// latest
+	if pullPolicy != container.PullNever && tagOmitted(ref) {
+		return m, m.listTags()
+	}
+	cmd := m.pullImage()
+	return m, cmd
+}
 
+// newModel creates the model in its initial state, before anything is loaded
+func newModel(ref string, pullPolicy container.PullPolicy, opts []container.Option) Model {
 	// Create an initial empty list with custom styling
-	l := newCustomList([]list.Item{}, 0, 0)
+	theme := themes[DefaultTheme]
+	l := newCustomList([]list.Item{}, 0, 0, theme)
 	l.Title = "Loading..."
 
 	// Initialize loading bar
@@ -420,45 +596,283 @@ func NewModel(ref string) (Model, tea.Cmd) {
 	// Initialize spinner
 	s := spinner.New()
 	s.Spinner = spinner.Points
-	s.Style = lipgloss.NewStyle().Foreground(selectedColor)
 
-	debug("Creating new model with isLocalImage=%v", isLocalImage)
 	m := Model{
 		list:           l,
-		tabs:           []string{"📦 Layers", "📄 Manifest", "⚙️  Config"},
+		tabs:           []string{"📦 Layers", "📄 Manifest", "⚙️  Config", "🚀 Runtime", "🏷️  Labels", "📝 Annotations"},
 		activeTab:      0,
-		tabStyle:       lipgloss.NewStyle().Padding(0, 2).Foreground(dimmedColor),
-		activeTabStyle: lipgloss.NewStyle().Padding(0, 2).Foreground(selectedColor).Bold(true),
+		tabStyle:       lipgloss.NewStyle().Padding(0, 2),
+		activeTabStyle: lipgloss.NewStyle().Padding(0, 2).Bold(true),
 		mode:           PullingMode,
+		ref:            ref,
 		keys:           newKeyMap(),
 		currentPath:    "/",
 		filepicker:     filepicker.New(&containerFS{}),
 		loadingBar:     loadingBar,
 		spinner:        s,
-		isLocalImage:   isLocalImage,
+		pullPolicy:     pullPolicy,
+		openOptions:    opts,
+		timeLocation:   time.UTC,
+		showHidden:     true,
+	}
+	m.SetTheme(theme)
+	return m
+}
+
+// hasLocalImage reports whether the Docker daemon, or the source of local
+// images of opts, has the image, which is then loaded from it unless it is
+// always pulled
+func hasLocalImage(ref string, pullPolicy container.PullPolicy, opts []container.Option) bool {
+	if pullPolicy == container.PullAlways {
+		debug("Skipping local image check as the image is always pulled")
+		return false
+	}
+	if !container.HasLocalImage(context.Background(), ref, opts...) {
+		debug("Image not found locally during initial check")
+		return false
 	}
+	debug("Found local image during initial check")
+	return true
+}
+
+// SetTimeFormat sets how layer creation and file modification times are
+// displayed in all views
+func (m *Model) SetTimeFormat(format filepicker.TimeFormat, loc *time.Location) {
+	m.timeFormat = format
+	m.timeLocation = loc
+}
+
+// SetSizeFormat sets how the sizes of layers, files and downloads are
+// displayed in all views
+func (m *Model) SetSizeFormat(format filepicker.SizeFormat) {
+	m.sizeFormat = format
+}
+
+// Close releases the files of the image. Cached files can't be removed on
+// Windows while they are open, so the image must be closed before the cache
+// is cleaned up.
+func (m *Model) Close() error {
+	if m.cancel != nil {
+		m.cancel()
+	}
+	if m.cancelExports != nil {
+		m.cancelExports()
+	}
+	m.closeLayer()
+	m.closeStaleLayers()
+	if m.image == nil {
+		return nil
+	}
+	return m.image.Close()
+}
+
+// closeLayer releases the files of the layer browsed, a copy of a layer of
+// the image initialized by loadLayer. Exports may still read it, so it is
+// only closed once they are done.
+func (m *Model) closeLayer() {
+	if m.currentLayer == nil {
+		return
+	}
+	m.staleLayers = append(m.staleLayers, m.currentLayer)
+	m.currentLayer = nil
+	if m.exports == 0 {
+		m.closeStaleLayers()
+	}
+}
+
+// closeStaleLayers closes the layers no longer browsed
+func (m *Model) closeStaleLayers() {
+	for _, layer := range m.staleLayers {
+		if err := layer.Close(); err != nil {
+			debug("Failed to close layer %s: %v", layer.DiffID, err)
+		}
+	}
+	m.staleLayers = nil
+}
+
+// SetTheme sets the colors of all views
+func (m *Model) SetTheme(theme Theme) {
+	m.theme = theme
+	m.tabStyle = m.tabStyle.Foreground(lipgloss.Color(theme.Dimmed))
+	m.activeTabStyle = m.activeTabStyle.Foreground(lipgloss.Color(theme.Selected))
+	m.spinner.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(theme.Selected))
+	title, filter := m.list.Title, m.list.Filter
+	m.list = newCustomList(m.list.Items(), m.list.Width(), m.list.Height(), theme)
+	m.list.Title, m.list.Filter = title, filter
+	m.markSelection()
+	m.filepicker.SetStyles(theme.filepickerStyles())
+}
+
+// SetNoColor disables the colors of all views
+func (m *Model) SetNoColor(noColor bool) {
+	m.noColor = noColor
+	if noColor {
+		lipgloss.SetColorProfile(termenv.Ascii)
+	}
+}
+
+// SetASCII replaces emoji and unicode glyphs of the UI with plain characters
+// for limited terminals, screen readers and captured output
+func (m *Model) SetASCII(ascii bool) {
+	m.ascii = ascii
+}
+
+// jsonColors returns the colors of the manifest and config
+func (m *Model) jsonColors() jsonColors {
+	if m.noColor {
+		return jsonColors{}
+	}
+	return m.theme.jsonColors()
+}
+
+// SetIcons shows icons of the file types in the file list, which need a
+// Nerd Font. They are not shown in the ASCII mode.
+func (m *Model) SetIcons(icons bool) {
+	m.showIcons = icons
+}
+
+// SetShowHidden sets whether the file list shows the files whose names start
+// with a dot. Toggling them changes it for the layers opened afterwards.
+func (m *Model) SetShowHidden(show bool) {
+	m.showHidden = show
+}
+
+// SetIgnoreCase makes file filters ignore case even if they have uppercase
+// letters
+func (m *Model) SetIgnoreCase(ignore bool) {
+	m.ignoreCase = ignore
+}
+
+func (m Model) formatTime(t time.Time) string {
+	return filepicker.FormatTime(t, m.timeFormat, m.timeLocation)
+}
+
+func (m Model) formatSize(size int64) string {
+	return filepicker.FormatSize(size, m.sizeFormat)
+}
+
+// layerItems returns the list items of the image layers, interleaved with
+// the build steps without a layer if they are shown
+func (m Model) layerItems() []list.Item {
+	var items []list.Item
+	var imageSize int64
+	for _, layer := range m.image.Layers {
+		imageSize += layer.Size
+	}
+	if m.showHistory && len(m.image.History) > 0 {
+		for _, step := range m.image.History {
+			if step.Layer >= 0 {
+				items = append(items, m.layerItem(m.image.Layers[step.Layer], imageSize))
+				continue
+			}
+			item := historyItem{command: step.Command}
+			if !step.Created.IsZero() {
+				item.created = m.formatTime(step.Created)
+			}
+			items = append(items, item)
+		}
+		return items
+	}
+	for _, layer := range m.image.Layers {
+		items = append(items, m.layerItem(layer, imageSize))
+	}
+	return items
+}
+
+func (m Model) layerItem(layer container.Layer, imageSize int64) layerItem {
+	item := layerItem{
+		diffID:     layer.DiffID,
+		digest:     layer.Digest,
+		size:       layer.Size,
+		sizeFormat: m.sizeFormat,
+		imageSize:  imageSize,
+		command:    layer.Command,
+		showDigest: m.showDigest,
+		marked:     m.marked[layer.DiffID],
+	}
+	if !layer.IsArchive() {
+		item.mediaType = layer.MediaType
+	}
+	if !layer.Created.IsZero() {
+		item.created = m.formatTime(layer.Created)
+	}
+	return item
+}
+
+// setLayerItems shows the layers in the list
+func (m *Model) setLayerItems() tea.Cmd {
+	items := m.layerItems()
+	m.list.Filter = filterLayers(items)
+	return m.list.SetItems(items)
+}
+
+// pullImage loads the image in the background
+func (m *Model) pullImage() tea.Cmd {
+	m.mode = PullingMode
+	m.reporter = newProgressReporter()
+	m.reporter.events = m.events
+	m.blobs = nil
+	m.blobRates = make(map[string]*transferRate)
+	m.events.PullStarted(m.ref)
+
+	// The pull can be canceled, but the context must outlive it as it is
+	// also used to fetch the layers of the image
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
 
 	// Create a command that will load the image
+	ref, reporter := m.ref, m.reporter
+	opts := append([]container.Option{
+		container.WithPullPolicy(m.pullPolicy),
+		container.WithProgress(reporter.report),
+	}, m.openOptions...)
 	loadCmd := func() tea.Msg {
-		image, isLocal, err := container.NewImage(ref, func(progress float64) {
-			debug("Progress callback: %.2f", progress)
-			select {
-			case progressChan <- progress:
-				debug("Progress sent to channel: %.2f", progress)
-			default:
-				debug("Progress channel full: %.2f", progress)
-			}
-		})
+		defer reporter.close()
+		image, err := container.Open(ctx, ref, opts...)
 		if err != nil {
-			close(progressChan)
 			return errMsg{err}
 		}
-		close(progressChan)
-		debug("Image loaded, returning imageLoadedMsg with isLocalImage=%v", isLocal)
-		return imageLoadedMsg{image: image, isLocalImage: isLocal}
+		debug("Image loaded, returning imageLoadedMsg with isLocalImage=%v", image.Local)
+		return imageLoadedMsg{image: image, isLocalImage: image.Local}
+	}
+
+	return tea.Batch(loadCmd, reporter.wait(), m.spinner.Tick)
+}
+
+// loadLayer initializes a copy of the layer in the background. Layers that
+// aren't tar archives are shown as blobs instead.
+func (m *Model) loadLayer(layer container.Layer) tea.Cmd {
+	if !layer.IsArchive() {
+		return m.openBlob(layer)
+	}
+	m.mode = LoadingMode
+	m.progress = container.Progress{Layer: layer.DiffID}
+	m.loadingBar = progress.New(
+		progress.WithDefaultGradient(),
+		progress.WithoutPercentage(),
+	)
+	progressWidth := m.width - padding*2 - 4
+	if progressWidth > maxWidth {
+		progressWidth = maxWidth
 	}
+	m.loadingBar.Width = progressWidth
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.reporter = newProgressReporter()
+	m.reporter.events = m.events
+	m.loadingLayer = &layer
+	m.events.layerStarted(layer.DiffID)
+	m.rate = transferRate{}
+	return initializeLayer(ctx, &layer, m.reporter)
+}
 
-	return m, tea.Batch(tickCmd(), loadCmd, s.Tick)
+// retryCmd runs the failed operation again
+func (m *Model) retryCmd() tea.Cmd {
+	retry := m.retry
+	m.retry = nil
+	m.message = ""
+	return retry()
 }
 
 func (m *Model) Init() tea.Cmd {
@@ -466,18 +880,15 @@ func (m *Model) Init() tea.Cmd {
 }
 
 type manifestMsg struct {
-	content string
+	content []byte
 	err     error
 }
 
 type configMsg struct {
-	content string
+	content []byte
 	err     error
 }
 
-// Add tickMsg type
-type tickMsg time.Time
-
 func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmd tea.Cmd
 	var cmds []tea.Cmd
@@ -495,11 +906,20 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.loadingBar.Width = contentWidth
 		}
 
-		if m.mode == ViewMode || m.mode == ManifestMode || m.mode == ConfigMode {
+		if m.mode == ViewMode || m.mode == RuntimeMode || m.mode == CommandMode || m.mode == LogMode || m.mode == DiffMode || m.mode == BlobMode {
 			m.viewport.Width = contentWidth
 			m.viewport.Height = msg.Height - 6
+			if m.mode == CommandMode {
+				m.viewport.SetContent(m.commandView())
+			} else if m.mode == BlobMode {
+				m.viewport.SetContent(m.blobView())
+			}
 		} else if m.mode == FileMode {
 			m.filepicker.SetHeight(m.height - 6)
+		} else if m.mode == ManifestMode || m.mode == ConfigMode {
+			m.resizeJSON()
+		} else if m.mode == LabelsMode || m.mode == AnnotationsMode || m.mode == TagsMode || m.mode == ReposMode || m.mode == RecentMode {
+			m.table.SetSize(contentWidth, msg.Height-6)
 		} else {
 			m.list.SetSize(contentWidth, msg.Height-6)
 		}
@@ -507,7 +927,8 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case spinner.TickMsg:
-		if m.mode == PullingMode {
+		// The spinner isn't animated in the accessible mode
+		if (m.mode == PullingMode || m.mode == TagsMode && m.tags == nil || m.mode == ReposMode && m.repos == nil || m.mode == UsageMode && m.usage == nil || m.mode == RecentMode && m.recent == nil) && !m.accessible {
 			var cmd tea.Cmd
 			newModel := m
 			newModel.spinner, cmd = m.spinner.Update(msg)
@@ -516,80 +937,121 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case errMsg:
-		m.message = fmt.Sprintf("Error: %v", msg.err)
-		m.mode = LayerMode
-		return m, hideMessageAfter(3 * time.Second)
-
-	case tickMsg:
-		var cmds []tea.Cmd
-
-		// Always queue up the next tick
-		cmds = append(cmds, tickCmd())
-
-		// Check for progress updates if channel exists
-		if progressChan != nil {
-			select {
-			case progressUpdate, ok := <-progressChan:
-				if ok {
-					debug("Progress received in tick: %.2f", progressUpdate)
-					newModel := m
-					newModel.progress = progressUpdate
-					return newModel, tea.Batch(cmds...)
-				}
-			default:
-			}
+		if errors.Is(msg.err, context.Canceled) && m.mode != PullingMode {
+			// The pull has been canceled by the user
+			return m, nil
+		}
+		if m.mode == PullingMode {
+			m.events.PullFailed(m.ref, msg.err)
 		}
+		_, retryable := describeError(msg.err, m.ref)
+		m.err = msg.err
+		m.message = ""
+		m.mode = ErrorMode
+		m.retry = nil
+		if retryable {
+			m.retry = m.pullImage
+		}
+		return m, nil
 
-		// Update progress bars
+	case progressMsg:
+		if msg.reporter != m.reporter {
+			// Progress of a job that has been superseded
+			return m, nil
+		}
+		debug("Progress message received: %s %.2f (done: %v)", msg.progress.Stage, msg.progress.Fraction(), msg.done)
+		m.progress = msg.progress
+		m.blobs = msg.blobs
+		m.sampleBlobs(time.Now())
 		if m.mode == LoadingMode {
-			if m.loadingBar.Percent() == 1.0 {
-				// If we have a pending layer and progress is complete, trigger transition
-				if m.pendingLayer != nil {
-					return m, tea.Tick(time.Millisecond*200, func(t time.Time) tea.Msg {
-						return transitionMsg{}
-					})
-				}
-				return m, nil
+			cmds = append(cmds, m.loadingBar.SetPercent(msg.progress.Fraction()))
+			if msg.progress.Stage == container.StageDownloading {
+				m.rate.sample(msg.progress.Complete, time.Now())
 			}
-			newModel := m
-			cmd := newModel.loadingBar.SetPercent(m.progress)
-			cmds = append(cmds, cmd)
-			return newModel, tea.Batch(cmds...)
+		}
+		if !msg.done {
+			cmds = append(cmds, m.reporter.wait())
 		}
 		return m, tea.Batch(cmds...)
 
-	case progressMsg:
-		debug("Progress message received: %.2f", float64(msg))
-		newModel := m
-		newModel.progress = float64(msg)
-		return newModel, nil
-
 	case imageLoadedMsg:
 		debug("Image loaded message received: isLocalImage=%v", msg.isLocalImage)
+		m.events.PullFinished(m.ref, len(msg.image.Layers))
 		newModel := m
 		newModel.image = msg.image
 		newModel.isLocalImage = msg.isLocalImage
 		newModel.mode = LayerMode
+		newModel.retry = nil
+		newModel.message = ""
 		debug("Model updated: isLocalImage=%v, mode=%v", newModel.isLocalImage, newModel.mode)
 
-		var items []list.Item
-		for _, layer := range msg.image.Layers {
-			items = append(items, layerItem{
-				diffID:  layer.DiffID,
-				size:    layer.Size,
-				command: layer.Command,
-			})
-		}
-
-		l := newCustomList(items, m.width-4, m.height-6)
+		items := newModel.layerItems()
+		l := newCustomList(items, m.width-4, m.height-6, m.theme)
+		l.Filter = filterLayers(items)
 		newModel.list = l
+		newModel.markSelection()
+		if newModel.resume != nil {
+			newModel.resumeAt(newModel.resume)
+			newModel.resume = nil
+		}
 		debug("Returning new model: isLocalImage=%v, mode=%v", newModel.isLocalImage, newModel.mode)
-		return newModel, nil
+		return newModel, tea.Batch(newModel.announce("Image loaded with %d layers", len(msg.image.Layers)), newModel.openStart(), newModel.startWatch())
+
+	case watchMsg:
+		if msg.gen != m.watchGen {
+			return m, nil
+		}
+		return m, checkImageID(m.ref, msg.gen)
+
+	case imageIDMsg:
+		return m, m.updateWatch(msg)
 
 	case tea.KeyMsg:
-		// Handle quit key (Ctrl-C) in any mode
+		if m.quitting {
+			return m, nil
+		}
+		if m.confirmQuit {
+			m.confirmQuit = false
+			m.message = ""
+			if key.Matches(msg, m.keys.quit) || msg.String() == "y" {
+				return m.quit()
+			}
+			return m, nil
+		}
+		// Show the log in any mode but while loading
+		if key.Matches(msg, m.keys.showLog) && m.mode != LoadingMode && m.mode != PullingMode && !m.editingRef {
+			return m, m.showLog()
+		}
+		if m.mode == ErrorMode {
+			return m.updateError(msg)
+		}
+		if m.mode == TagsMode {
+			return m.updatePicker(msg, m.tags != nil, m.pickTag, m.pickerBack())
+		}
+		if m.mode == ReposMode {
+			return m.updatePicker(msg, m.repos != nil, m.pickRepo, nil)
+		}
+		if m.editingQuery && (m.mode == ManifestMode || m.mode == ConfigMode) {
+			return m.updateQuery(msg)
+		}
+		if m.editingCompare && (m.mode == ViewMode || m.mode == RuntimeMode) {
+			return m.updateCompare(msg)
+		}
+
+		// Cancel the pull or layer load in progress
+		if (m.mode == LoadingMode || m.mode == PullingMode) && key.Matches(msg, m.keys.cancel) {
+			return m.cancelLoading()
+		}
+
+		// Handle quit key (Ctrl-C) in any mode. Quitting with q asks for
+		// confirmation while downloads or exports are running.
 		if key.Matches(msg, m.keys.quit) {
-			return m, tea.Quit
+			if work := m.workInProgress(); work != "" && msg.String() == "q" {
+				m.confirmQuit = true
+				m.message = fmt.Sprintf("%s in progress: press q or y to cancel it and quit, any other key to continue", work)
+				return m, nil
+			}
+			return m.quit()
 		}
 
 		// Skip other key handling during loading or pulling
@@ -597,34 +1059,44 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 		}
 
-		// Handle help toggle
-		if msg.String() == "?" {
-			newModel := m
-			newModel.showHelp = !m.showHelp
-			return newModel, nil
-		}
-
-		// Handle 'y' key in LayerMode
-		if m.mode == LayerMode && msg.String() == "y" {
-			if m.pendingKey == "y" {
-				// Second 'y' press - copy diff ID
-				if item, ok := m.list.SelectedItem().(layerItem); ok {
-					m.pendingKey = ""
-					m.message = "📋 Diff ID copied to clipboard"
-					return m, tea.Batch(
-						copyToClipboard(item.diffID),
-						hideMessageAfter(3*time.Second),
-					)
-				}
-			} else {
-				// First 'y' press
-				m.pendingKey = "y"
+		// The help is modal, closed by the help key or esc
+		if m.showHelp {
+			if key.Matches(msg, m.keys.help) || msg.Type == tea.KeyEsc {
+				m.showHelp = false
 			}
 			return m, nil
 		}
-		// Reset pending key if any other key is pressed
+		if key.Matches(msg, m.keys.help) && !m.inFilter() {
+			m.showHelp = true
+			return m, nil
+		}
+		if m.mode == UsageMode {
+			return m.updateUsage(msg)
+		}
+		if m.mode == RecentMode {
+			return m.updateRecent(msg)
+		}
+
+		// Key sequences such as yy show their completions until the next
+		// key. Keys that complete none of them are handled as usual.
 		if m.pendingKey != "" {
+			prefix := m.pendingKey
 			m.pendingKey = ""
+			for _, c := range m.chords(prefix) {
+				if c.binding.Keys()[0] == prefix+msg.String() {
+					return m, c.run(m)
+				}
+			}
+			if msg.Type == tea.KeyEsc {
+				return m, nil
+			}
+		} else if !m.inFilter() && len(m.chords(msg.String())) > 0 {
+			m.pendingKey = msg.String()
+			m.chordSeq++
+			seq := m.chordSeq
+			return m, tea.Tick(chordTimeout, func(time.Time) tea.Msg {
+				return chordTimeoutMsg{seq: seq}
+			})
 		}
 
 		// Check if in filter mode
@@ -632,81 +1104,103 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.list, cmd = m.list.Update(msg)
 			return m, cmd
 		}
+		if (m.mode == LabelsMode || m.mode == AnnotationsMode) && m.table.FilterState() == list.Filtering {
+			m.table, cmd = m.table.Update(msg)
+			return m, cmd
+		}
+
+		// Retry the failed pull or layer load
+		if m.mode == LayerMode && m.retry != nil && key.Matches(msg, m.keys.retry) {
+			return m, m.retryCmd()
+		}
+		// The layers and tabs need an image
+		if m.mode == LayerMode && m.image == nil {
+			return m, nil
+		}
+		// Reload the image rebuilt since it was loaded
+		if m.rebuilt && key.Matches(msg, m.keys.reload) {
+			return m, m.reload()
+		}
 		if m.mode == FileMode && m.filepicker.InFilterMode() {
 			m.filepicker, cmd = m.filepicker.Update(msg)
-			return m, cmd
+			return m, tea.Batch(cmd, m.updatePreview())
+		}
+		// Pull the local image again, as its tag may be outdated
+		if m.image != nil && key.Matches(msg, m.keys.refresh) {
+			return m, m.refresh()
 		}
 
 		switch {
 		case key.Matches(msg, m.keys.nextTab):
-			if m.mode != ViewMode {
-				m.activeTab = (m.activeTab + 1) % len(m.tabs)
-				switch m.activeTab {
-				case 0: // Layers
-					if m.mode == FileMode {
-						// Keep the current file mode state
-						return m, nil
-					}
-					m.mode = LayerMode
-				case 1: // Manifest
-					m.mode = ManifestMode
-					return m, func() tea.Msg {
-						content, err := m.image.GetManifestWithColor(false)
-						if err != nil {
-							return manifestMsg{err: err}
-						}
-						return manifestMsg{content: string(colorizeJSON(content))}
-					}
-				case 2: // Config
-					m.mode = ConfigMode
-					return m, func() tea.Msg {
-						content, err := m.image.GetConfigWithColor(false)
-						if err != nil {
-							return configMsg{err: err}
-						}
-						return configMsg{content: string(colorizeJSON(content))}
-					}
-				}
+			if m.mode != ViewMode && m.mode != LogMode && m.mode != DiffMode {
+				return m, m.showTab((m.activeTab + 1) % len(m.tabs))
 			}
 			return m, nil
 		case key.Matches(msg, m.keys.prevTab):
-			if m.mode != ViewMode {
-				m.activeTab = (m.activeTab - 1 + len(m.tabs)) % len(m.tabs)
-				switch m.activeTab {
-				case 0: // Layers
-					if m.mode == FileMode {
-						// Keep the current file mode state
-						return m, nil
-					}
-					m.mode = LayerMode
-				case 1: // Manifest
-					m.mode = ManifestMode
-					return m, func() tea.Msg {
-						content, err := m.image.GetManifestWithColor(false)
-						if err != nil {
-							return manifestMsg{err: err}
-						}
-						return manifestMsg{content: string(colorizeJSON(content))}
-					}
-				case 2: // Config
-					m.mode = ConfigMode
-					return m, func() tea.Msg {
-						content, err := m.image.GetConfigWithColor(false)
-						if err != nil {
-							return configMsg{err: err}
-						}
-						return configMsg{content: string(colorizeJSON(content))}
-					}
+			if m.mode != ViewMode && m.mode != LogMode && m.mode != DiffMode {
+				return m, m.showTab((m.activeTab - 1 + len(m.tabs)) % len(m.tabs))
+			}
+			return m, nil
+		case m.queryKey(msg):
+			return m, m.startQuery()
+		case key.Matches(msg, m.keys.command) && m.mode == LayerMode:
+			if command, ok := m.selectedCommand(); ok {
+				m.command = command
+				m.mode = CommandMode
+				m.viewport = viewport.New(m.width-4, m.height-6)
+				m.viewport.SetContent(m.commandView())
+				return m, m.announce("Showing the command of the layer")
+			}
+			return m, nil
+		case key.Matches(msg, m.keys.mark) && m.mode == LayerMode:
+			if item, ok := m.list.SelectedItem().(layerItem); ok {
+				if m.marked == nil {
+					m.marked = make(map[string]bool)
+				}
+				if m.marked[item.diffID] {
+					delete(m.marked, item.diffID)
+				} else {
+					m.marked[item.diffID] = true
 				}
+				return m, m.setLayerItems()
 			}
 			return m, nil
-		case key.Matches(msg, m.keys.toggleHidden) && m.mode == FileMode:
-			m.filepicker.SetShowHidden(!m.filepicker.ShowHidden())
+		case key.Matches(msg, m.keys.toggleHistory) && m.mode == LayerMode:
+			m.showHistory = !m.showHistory
+			return m, m.setLayerItems()
+		case key.Matches(msg, m.keys.toggleDigest) && m.mode == LayerMode:
+			m.showDigest = !m.showDigest
+			return m, m.setLayerItems()
+		case key.Matches(msg, m.keys.toggleIcons) && m.mode == FileMode:
+			// The icons aren't plain characters
+			if !m.ascii {
+				m.showIcons = !m.showIcons
+				m.filepicker.SetShowIcons(m.showIcons)
+			}
 			return m, nil
+		case key.Matches(msg, m.keys.compare) && (m.mode == ViewMode || m.mode == RuntimeMode):
+			return m, m.startCompare()
+		case key.Matches(msg, m.keys.diskUsage) && m.mode == FileMode:
+			return m, m.showUsage()
+		case key.Matches(msg, m.keys.recent) && m.mode == FileMode:
+			return m, m.showRecent()
+		case key.Matches(msg, m.keys.togglePreview) && m.mode == FileMode:
+			m.showPreview = !m.showPreview
+			m.preview = filePreview{}
+			return m, m.updatePreview()
+		case key.Matches(msg, m.keys.toggleHidden) && m.mode == FileMode:
+			m.showHidden = !m.showHidden
+			m.filepicker.SetShowHidden(m.showHidden)
+			return m, m.filepicker.Init()
 		case key.Matches(msg, m.keys.export):
 			switch m.mode {
+			case BlobMode:
+				return m, tea.Batch(
+					exportBlob(m.startExport(), *m.blob),
+					hideMessageAfter(3*time.Second),
+				)
 			case FileMode:
-				files, err := m.currentLayer.GetFiles(m.filepicker.CurrentPath())
+				files, err := m.currentLayer.GetFiles(context.Background(), m.filepicker.CurrentPath())
 				if err != nil {
 					m.message = fmt.Sprintf("Failed to get files: %v", err)
 					return m, hideMessageAfter(3 * time.Second)
@@ -717,47 +1211,53 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if file.Name == fileName {
 							if !file.IsDir {
 								return m, tea.Batch(
-									exportFile(m.currentLayer, file),
+									exportFile(m.startExport(), m.currentLayer, file),
 									hideMessageAfter(3*time.Second),
 								)
 							}
 						}
 					}
 				}
-			case ManifestMode:
-				return m, tea.Batch(
-					exportManifest(m.image),
-					hideMessageAfter(3*time.Second),
-				)
-			case ConfigMode:
+			case ManifestMode, ConfigMode:
+				if m.query != "" {
+					name := "manifest"
+					if m.mode == ConfigMode {
+						name = "config"
+					}
+					return m, tea.Batch(
+						exportQueryResult(m.startExport(), name, m.queryResult),
+						hideMessageAfter(3*time.Second),
+					)
+				}
+				if m.mode == ManifestMode {
+					return m, tea.Batch(
+						exportManifest(m.startExport(), m.image),
+						hideMessageAfter(3*time.Second),
+					)
+				}
 				return m, tea.Batch(
-					exportConfig(m.image),
+					exportConfig(m.startExport(), m.image),
 					hideMessageAfter(3*time.Second),
 				)
 			}
 		case key.Matches(msg, m.keys.enter):
 			if m.mode == LayerMode {
+				if layers := m.markedLayers(); len(layers) > 0 {
+					m.retry = nil
+					m.message = ""
+					return m, m.loadLayer(*container.MergeLayers(layers...))
+				}
 				if item, ok := m.list.SelectedItem().(layerItem); ok {
 					for i := range m.image.Layers {
 						if m.image.Layers[i].DiffID == item.diffID {
-							layerCopy := m.image.Layers[i]
-							m.mode = LoadingMode
-							m.progress = 0.0
-							m.loadingBar = progress.New(
-								progress.WithDefaultGradient(),
-								progress.WithoutPercentage(),
-							)
-							progressWidth := m.width - padding*2 - 4
-							if progressWidth > maxWidth {
-								progressWidth = maxWidth
-							}
-							m.loadingBar.Width = progressWidth
-							return m, initializeLayer(&layerCopy)
+							m.retry = nil
+							m.message = ""
+							return m, m.loadLayer(m.image.Layers[i])
 						}
 					}
 				}
 			} else if m.mode == FileMode {
-				files, err := m.currentLayer.GetFiles(m.filepicker.CurrentPath())
+				files, err := m.currentLayer.GetFiles(context.Background(), m.filepicker.CurrentPath())
 				if err != nil {
 					m.message = fmt.Sprintf("Failed to get files: %v", err)
 					return m, hideMessageAfter(3 * time.Second)
@@ -768,13 +1268,15 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 						if file.Name == fileName {
 							if file.IsDir {
 								m.currentPath = file.Path
-								newPath := filepath.Join(m.filepicker.CurrentPath(), fileName)
+								newPath := path.Join(m.filepicker.CurrentPath(), fileName)
 								m.filepicker.SetPath(newPath)
 								return m, m.filepicker.Init()
 							} else {
 								m.currentFile = &file
 								m.mode = LoadingMode
-								return m, viewFile(m.currentLayer, file.Path)
+								ctx, cancel := context.WithCancel(context.Background())
+								m.cancel = cancel
+								return m, viewFile(ctx, m.currentLayer, file.Path)
 							}
 						}
 					}
@@ -791,17 +1293,9 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.filepicker.CurrentPath() == "." && msg.String() == "h" {
 					// If we're at the root of the filepicker and 'h' was pressed, go back to layer mode
 					m.mode = LayerMode
-					m.currentLayer = nil
+					m.closeLayer()
 					m.currentPath = "/"
-					var items []list.Item
-					for _, layer := range m.image.Layers {
-						items = append(items, layerItem{
-							diffID:  layer.DiffID,
-							size:    layer.Size,
-							command: layer.Command,
-						})
-					}
-					m.list.SetItems(items)
+					m.setLayerItems()
 					m.updateTitle()
 					m.list.Select(0)
 					return m, nil
@@ -813,14 +1307,38 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.mode = FileMode
 				m.updateTitle()
 				return m, nil
-			} else if m.mode == ManifestMode || m.mode == ConfigMode {
-				if m.currentLayer != nil {
-					// If we came from file mode, go back to file mode
-					m.mode = FileMode
-					m.activeTab = 0
-					m.updateTitle()
-					return m, nil
-				}
+			} else if m.mode == CommandMode {
+				m.mode = LayerMode
+				return m, nil
+			} else if m.mode == BlobMode {
+				m.mode = LayerMode
+				m.blob = nil
+				return m, nil
+			} else if m.mode == LogMode {
+				m.mode = m.logReturn
+				m.viewport = m.logViewport
+				return m, nil
+			} else if m.mode == DiffMode {
+				m.mode = m.diffReturn
+				m.viewport = m.diffViewport
+				return m, nil
+			} else if m.mode == ManifestMode || m.mode == ConfigMode || m.mode == RuntimeMode || m.mode == LabelsMode || m.mode == AnnotationsMode {
+				if (m.mode == LabelsMode || m.mode == AnnotationsMode) && m.table.FilterState() != list.Unfiltered {
+					// esc clears the filter first
+					m.table, cmd = m.table.Update(msg)
+					return m, cmd
+				}
+				if m.query != "" {
+					// and the query
+					return m, m.clearQuery()
+				}
+				if m.currentLayer != nil {
+					// If we came from file mode, go back to file mode
+					m.mode = FileMode
+					m.activeTab = 0
+					m.updateTitle()
+					return m, nil
+				}
 				// Otherwise go back to layer mode
 				m.mode = LayerMode
 				m.activeTab = 0
@@ -834,45 +1352,104 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.message = fmt.Sprintf("Failed to get manifest: %v", msg.err)
 			return m, hideMessageAfter(3 * time.Second)
 		}
-		m.viewport = viewport.New(m.width-4, m.height-6)
-		m.viewport.SetContent(msg.content)
-		return m, nil
+		m.document, m.query, m.queryResult, m.editingQuery = msg.content, "", nil, false
+		if err := m.showJSON(msg.content); err != nil {
+			m.message = fmt.Sprintf("Failed to parse manifest: %v", err)
+			return m, hideMessageAfter(3 * time.Second)
+		}
+		return m, m.announce("Showing the manifest")
 
 	case configMsg:
 		if msg.err != nil {
 			m.message = fmt.Sprintf("Failed to get config: %v", msg.err)
 			return m, hideMessageAfter(3 * time.Second)
 		}
+		m.document, m.query, m.queryResult, m.editingQuery = msg.content, "", nil, false
+		if err := m.showJSON(msg.content); err != nil {
+			m.message = fmt.Sprintf("Failed to parse config: %v", err)
+			return m, hideMessageAfter(3 * time.Second)
+		}
+		return m, m.announce("Showing the config")
+
+	case queryMsg:
+		if m.mode != ManifestMode && m.mode != ConfigMode {
+			// The tab has been left while the query was running
+			return m, nil
+		}
+		return m, m.showQueryResult(msg)
+
+	case runtimeMsg:
+		if msg.err != nil {
+			m.message = fmt.Sprintf("Failed to get runtime config: %v", msg.err)
+			return m, hideMessageAfter(3 * time.Second)
+		}
 		m.viewport = viewport.New(m.width-4, m.height-6)
 		m.viewport.SetContent(msg.content)
-		return m, nil
+		return m, m.announce("Showing the runtime config")
+
+	case labelsMsg:
+		if msg.err != nil {
+			m.message = fmt.Sprintf("Failed to get labels: %v", msg.err)
+			return m, hideMessageAfter(3 * time.Second)
+		}
+		m.table = newLabelList(labelItems(msg.labels), "label", "labels", m.width-4, m.height-6, m.theme)
+		return m, m.announce("Showing %d labels", len(msg.labels))
+
+	case annotationsMsg:
+		if msg.err != nil {
+			m.message = fmt.Sprintf("Failed to get annotations: %v", msg.err)
+			return m, hideMessageAfter(3 * time.Second)
+		}
+		m.table = newLabelList(annotationItems(msg.annotations), "annotation", "annotations", m.width-4, m.height-6, m.theme)
+		return m, m.announce("Showing %d annotations", len(msg.annotations))
 
 	case loadingLayerMsg:
+		if m.mode != LoadingMode || (m.loadingLayer != nil && msg.layer != m.loadingLayer) {
+			// The layer load has been canceled or superseded, maybe after
+			// the layer was initialized
+			if msg.err == nil && msg.layer != nil {
+				if err := msg.layer.Close(); err != nil {
+					debug("Failed to close layer %s: %v", msg.layer.DiffID, err)
+				}
+			}
+			return m, nil
+		}
 		if msg.err != nil {
+			ev := event{Event: eventLayerFailed}
+			if msg.layer != nil {
+				ev.Layer = msg.layer.DiffID
+			}
+			m.events.emitError(ev, msg.err)
+			m.startPath = ""
 			m.mode = LayerMode
-			m.message = fmt.Sprintf("Failed to load layer: %v", msg.err)
 			m.updateTitle()
-			return m, hideMessageAfter(3 * time.Second)
+			if msg.layer == nil {
+				m.message = fmt.Sprintf("Failed to load layer: %v", msg.err)
+				return m, hideMessageAfter(3 * time.Second)
+			}
+			layer := *msg.layer
+			hint, retryable := describeError(msg.err, m.ref)
+			m.message = fmt.Sprintf("Failed to load layer: %v (%s)", msg.err, hint)
+			if !retryable {
+				return m, hideMessageAfter(5 * time.Second)
+			}
+			m.retry = func() tea.Cmd {
+				return m.loadLayer(layer)
+			}
+			return m, nil
 		}
 
-		debug("Received loadingLayerMsg, layer: %v, progress: %.2f", msg.layer != nil, m.progress)
+		debug("Received loadingLayerMsg, layer: %v, progress: %.2f", msg.layer != nil, m.progress.Fraction())
 
 		// Set progress to 100% before transitioning
 		if m.mode == LoadingMode {
 			// First update to 100%
-			m.progress = 1.0
-			progressModel, cmd := m.loadingBar.Update(msg)
-			m.loadingBar = progressModel.(progress.Model)
-			m.loadingBar.SetPercent(1.0)
+			m.progress.Stage = container.StageDone
+			cmd := m.loadingBar.SetPercent(1.0)
 
 			// Store the layer for transition
 			m.pendingLayer = msg.layer
 
-			// Wait for the progress bar to reach 100% before transitioning
-			if m.loadingBar.Percent() < 1.0 {
-				return m, cmd
-			}
-
 			// Wait a bit to show 100% progress, then transition
 			return m, tea.Sequence(
 				cmd,
@@ -884,36 +1461,108 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		return m, nil
 
+	case blobHeadMsg:
+		m.showBlobHead(msg)
+		return m, nil
+
 	case viewFileMsg:
+		if m.mode != LoadingMode || errors.Is(msg.err, context.Canceled) {
+			// The read was canceled
+			return m, nil
+		}
+		m.cancel = nil
 		if msg.err != nil {
+			m.mode = FileMode
 			m.message = fmt.Sprintf("Failed to read file: %v", msg.err)
 			return m, hideMessageAfter(3 * time.Second)
 		}
 		m.viewport = viewport.New(m.width-4, m.height-6)
 		m.viewport.SetContent(msg.content)
+		m.fileContent = []byte(msg.content)
 		m.mode = ViewMode
+		if m.currentFile != nil {
+			return m, m.announce("Viewing %s", m.statusPath())
+		}
+		return m, nil
+
+	case logMsg:
+		return m, m.openLog(msg)
+
+	case fileDiffMsg:
+		return m, m.openDiff(msg)
+
+	case configDiffMsg:
+		return m, m.openConfigDiff(msg)
+
+	case tagsMsg:
+		return m, m.openTags(msg)
+
+	case reposMsg:
+		return m, m.openRepos(msg)
+
+	case previewMsg:
+		// Previews of files no longer selected are dropped
+		if msg.preview.path == m.preview.path {
+			m.preview = msg.preview
+		}
 		return m, nil
 
 	case exportFileMsg:
+		m.exports--
+		if m.exports <= 0 && m.cancelExports != nil {
+			m.exports = 0
+			m.cancelExports()
+			m.cancelExports = nil
+			m.closeStaleLayers()
+		}
+		if m.quitting {
+			if m.exports == 0 {
+				return m, tea.Quit
+			}
+			return m, nil
+		}
 		if msg.err != nil {
+			m.events.ExportFailed(msg.err)
 			m.message = fmt.Sprintf("Failed to export file: %v", msg.err)
 		} else {
+			m.events.ExportFinished(msg.path)
 			m.message = "File exported successfully"
 		}
 		return m, hideMessageAfter(3 * time.Second)
 
 	case hideMessageMsg:
+		if m.retry != nil || m.confirmQuit || m.quitting {
+			// Keep the error visible until it is retried, and the
+			// question until it is answered
+			return m, nil
+		}
 		m.message = ""
 		return m, nil
 
 	case transitionMsg:
+		m.loadingLayer = nil
+		if m.currentLayer != m.pendingLayer {
+			m.closeLayer()
+		}
 		m.currentLayer = m.pendingLayer
 		m.mode = FileMode
 		m.currentPath = "/"
-		m.filepicker = filepicker.New(&containerFS{layer: m.pendingLayer})
-		m.filepicker.SetHeight(m.height - 6)
-		m.filepicker.SetShowHidden(true)
-		return m, m.filepicker.Init()
+		m.preview = filePreview{}
+		m.filepicker = filepicker.New(&containerFS{layer: m.pendingLayer},
+			filepicker.WithHeight(m.height-6),
+			filepicker.WithShowHidden(m.showHidden),
+			filepicker.WithTimeFormat(m.timeFormat, m.timeLocation),
+			filepicker.WithSizeFormat(m.sizeFormat),
+			filepicker.WithIgnoreCase(m.ignoreCase),
+			filepicker.WithStyles(m.theme.filepickerStyles()),
+			filepicker.WithShowIcons(m.showIcons && !m.ascii),
+			filepicker.WithLogger(slog.Default()),
+		)
+		if merged := m.currentLayer.MergedDiffIDs(); merged != nil {
+			return m, tea.Batch(m.revealStart(), m.announce("%d layers opened together", len(merged)))
+		}
+		n, _ := m.statusLayer()
+		return m, tea.Batch(m.revealStart(), m.loadAccounts(), m.announce("Layer %d of %d opened", n, len(m.image.Layers)))
 
 	case progress.FrameMsg:
 		if m.mode == LoadingMode {
@@ -928,22 +1577,41 @@ func (m *Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			m.message = fmt.Sprintf("Error: %v", msg.err)
 			debug("Clipboard error message displayed: %v", msg.err)
 		} else {
-			if item, ok := m.list.SelectedItem().(layerItem); ok {
-				m.message = fmt.Sprintf("Copied diff ID to clipboard: %s", item.diffID)
-				debug("Copied diff ID to clipboard: %s", item.diffID)
-			}
+			m.message = fmt.Sprintf("Copied %s to clipboard: %s", msg.what, msg.text)
+			debug("Copied %s to clipboard: %s", msg.what, msg.text)
 		}
 		return m, hideMessageAfter(3 * time.Second)
+
+	case usageMsg:
+		return m, m.setUsage(msg)
+
+	case recentMsg:
+		return m, m.setRecent(msg)
+
+	case accountsMsg:
+		return m, m.setAccounts(msg)
+
+	case chordTimeoutMsg:
+		if msg.seq == m.chordSeq {
+			m.pendingKey = ""
+		}
+		return m, nil
 	}
 
 	switch m.mode {
-	case ViewMode, ManifestMode, ConfigMode:
+	case ViewMode, RuntimeMode, CommandMode, LogMode, DiffMode, BlobMode:
 		m.viewport, cmd = m.viewport.Update(msg)
 		cmds = append(cmds, cmd)
+	case ManifestMode, ConfigMode:
+		m.tree, cmd = m.tree.Update(msg)
+		cmds = append(cmds, cmd)
 	case FileMode:
 		var pickerCmd tea.Cmd
 		m.filepicker, pickerCmd = m.filepicker.Update(msg)
-		cmds = append(cmds, pickerCmd)
+		cmds = append(cmds, pickerCmd, m.updatePreview())
+	case LabelsMode, AnnotationsMode:
+		m.table, cmd = m.table.Update(msg)
+		cmds = append(cmds, cmd)
 	default:
 		m.list, cmd = m.list.Update(msg)
 		cmds = append(cmds, cmd)
@@ -957,247 +1625,655 @@ func (m *Model) View() string {
 		return "\n  Loading..."
 	}
 
-	var view string
+	// The file viewer, manifest and config are kept as is in the ASCII mode
+	var body, help string
+	plain := false
 	switch m.mode {
 	case LayerMode:
-		baseView := m.list.View()
-
-		// Split the view into content and padding
-		parts := strings.Split(baseView, "\n")
-
-		// Find where the actual content ends (before padding)
-		contentEnd := 0
-		for i := len(parts) - 1; i >= 0; i-- {
-			if strings.TrimSpace(parts[i]) != "" {
-				contentEnd = i + 1
-				break
-			}
-		}
-
-		// Reconstruct the view with message and help
-		var finalView strings.Builder
-		finalView.WriteString(strings.Join(parts[:contentEnd], "\n"))
-
-		// Add message if exists
-		if m.message != "" {
-			finalView.WriteString("\n\n  💡 ")
-			finalView.WriteString(m.message)
-			finalView.WriteString("\n")
-		}
-
-		// Calculate space needed for help text
-		helpHeight := 1 // Simple help
-		if m.showHelp {
-			helpHeight = 14 // Detailed help
-		}
-
-		// Calculate remaining space
-		usedLines := contentEnd
-		if m.message != "" {
-			usedLines += 3 // 2 for spacing + 1 for message
+		body = m.list.View()
+		help = m.shortHelp("↑/k up • ↓/j down • →/l view layer • / filter • q quit • ? more")
+	case ViewMode, CommandMode, LogMode, DiffMode, BlobMode:
+		body = m.viewport.View()
+		plain = true
+		help = m.shortHelp("↑/k up • ↓/j down • ←/h back • q quit • ? more")
+		if m.mode == BlobMode {
+			help = m.shortHelp("↑/k up • ↓/j down • x export • ←/h back • q quit • ? more")
+		}
+		if m.editingCompare {
+			body += "\n" + m.compareInput.View()
+			help = m.shortHelp("enter compare • esc cancel")
 		}
-		remainingLines := m.height - usedLines - helpHeight - 5 // Subtract 5 for bottom padding (including help text)
-
-		// Add remaining space
-		if remainingLines > 0 {
-			finalView.WriteString(strings.Repeat("\n", remainingLines))
-		}
-
-		// Add help text
-		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-		if m.showHelp {
-			finalView.WriteString("\n" +
-				"Navigation:\n" +
-				"  ↑/k: up\n" +
-				"  ↓/j: down\n" +
-				"  →/l: view layer\n" +
-				"  g: first\n" +
-				"  G: last\n" +
-				"  K/pgup: page up\n" +
-				"  J/pgdown: page down\n" +
-				"\nActions:\n" +
-				"  yy: copy diff ID\n" +
-				"  /: filter layers\n" +
-				"  ?: toggle help\n" +
-				"  q: quit\n\n\n\n\n")
-		} else {
-			finalView.WriteString("\n" + helpStyle.Render("↑/k up • ↓/j down • →/l view layer • / filter • q quit • ? more") + "\n\n\n\n\n")
-		}
-
-		view = finalView.String()
-	case ViewMode:
-		view = m.viewport.View()
 	case LoadingMode:
 		progressWidth := m.width - padding*2 - 4
 		if progressWidth > maxWidth {
 			progressWidth = maxWidth
 		}
 		m.loadingBar.Width = progressWidth
-		view = fmt.Sprintf("\n\n  ⏳ Loading layer...\n%s", lipgloss.NewStyle().PaddingLeft(padding).Render(m.loadingBar.View()))
+		if m.accessible {
+			body = fmt.Sprintf("\n\n  %s %s", loadingStageView(m.progress.Stage), percentView(m.progress.Fraction()))
+			break
+		}
+		body = fmt.Sprintf("\n\n  ⏳ %s\n%s", loadingStageView(m.progress.Stage), lipgloss.NewStyle().PaddingLeft(padding).Render(m.loadingBar.View()))
+		if transfer := m.transferView(); transfer != "" {
+			body += "\n\n" + lipgloss.NewStyle().PaddingLeft(padding).Foreground(lipgloss.Color(m.theme.Dimmed)).Render(transfer)
+		}
 	case PullingMode:
-		if m.isLocalImage {
+		if m.accessible {
+			body = "\n\n  Loading image..."
+		} else if m.isLocalImage {
 			debug("View: Showing local image message with spinner")
-			view = fmt.Sprintf("\n\n  %s Loading local image...", m.spinner.View())
+			body = fmt.Sprintf("\n\n  %s Loading local image...", m.spinner.View())
 		} else {
 			debug("View: Showing remote image message with spinner")
-			view = fmt.Sprintf("\n\n  %s Pulling image from registry...", m.spinner.View())
+			body = fmt.Sprintf("\n\n  %s Pulling image from registry...", m.spinner.View())
+		}
+		if blobs := m.blobsView(); blobs != "" {
+			body += "\n\n" + blobs
 		}
 	case FileMode:
-		baseView := m.filepicker.View()
+		body = m.filepicker.View()
+		if m.showPreview {
+			// The preview of the file is kept as is in the ASCII mode
+			body = m.withPreview(m.chrome(body))
+			plain = true
+		}
+		help = m.shortHelp("↑/k up • ↓/j down • →/l view/open • ←/h back • tab switch • / filter • q quit • ? more")
+	case ManifestMode, ConfigMode:
+		body = m.tree.View()
+		if query := m.queryView(); query != "" {
+			body = query + "\n" + body
+		}
+		plain = true
+		help = m.shortHelp("↑/k up • ↓/j down • enter collapse/expand • / query • yp copy path • x export • q quit • ? more")
+		if m.editingQuery {
+			help = m.shortHelp("enter run • esc cancel")
+		}
+	case RuntimeMode:
+		body = m.viewport.View()
+		plain = true
+		help = m.shortHelp("↑/k up • ↓/j down • tab switch • q quit • ? more")
+		if m.editingCompare {
+			body += "\n" + m.compareInput.View()
+			help = m.shortHelp("enter compare • esc cancel")
+		}
+	case LabelsMode, AnnotationsMode:
+		body = m.table.View()
+		help = m.shortHelp("↑/k up • ↓/j down • / filter • yv copy value • q quit • ? more")
+	case ErrorMode:
+		body = m.errorView()
+		help = m.shortHelp(m.errorHelp())
+	case TagsMode:
+		body = m.pickerView(m.tags != nil, "tags")
+		help = m.pickerHelp(m.tags != nil, m.browse != "")
+	case ReposMode:
+		body = m.pickerView(m.repos != nil, "repositories")
+		help = m.pickerHelp(m.repos != nil, false)
+	case RecentMode:
+		body = m.recentView()
+		help = m.shortHelp("↑/k up • ↓/j down • enter show • / filter • esc back • q quit • ? more")
+	case UsageMode:
+		body = m.usageView()
+		help = m.shortHelp("↑/k up • ↓/j down • →/l open • ←/h up • t types • esc back • q quit • ? more")
+		if m.showTypes {
+			help = m.shortHelp("↑/k up • ↓/j down • ←/h/t files • q quit • ? more")
+		}
+	default:
+		body = m.list.View()
+	}
+	if !plain {
+		body = m.chrome(body)
+	}
 
-		// Define help style
-		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
+	// Render tabs
+	var tabViews []string
+	for i := range m.tabs {
+		style := m.tabStyle
+		if i == m.activeTab {
+			style = m.activeTabStyle
+		}
+		tabViews = append(tabViews, style.Render(m.tabLabel(i)))
+	}
+	tabs := lipgloss.JoinHorizontal(lipgloss.Top, tabViews...)
+	tabs = m.chrome(lipgloss.NewStyle().BorderBottom(true).Render(tabs))
 
-		// Split the view into content and padding
-		parts := strings.Split(baseView, "\n")
+	// The help and status bar stick to the bottom of the screen. The body
+	// is cut when it doesn't fit so that the tabs stay visible.
+	footer := m.chrome(m.statusBar())
+	if help != "" {
+		footer = m.chrome(help) + "\n\n" + footer
+	}
+	space := m.height - lipgloss.Height(tabs) - lipgloss.Height(footer) - 1
+	if space < 0 {
+		space = 0
+	}
+	if m.showHelp && help != "" {
+		body = m.chrome(m.helpOverlay(space))
+	}
+	lines := trimBlankLines(strings.Split(body, "\n"))
+	if len(lines) > space {
+		lines = lines[:space]
+	}
+	for len(lines) < space {
+		lines = append(lines, "")
+	}
 
-		// Find where the actual content ends (before padding)
-		contentEnd := 0
-		for i := len(parts) - 1; i >= 0; i-- {
-			if parts[i] != "" {
-				contentEnd = i + 1
-				break
+	// The completions of a key sequence pop up at the bottom right
+	if m.pendingKey != "" {
+		hints := strings.Split(m.chrome(m.chordHints()), "\n")
+		if len(hints) <= len(lines) {
+			for i, hint := range hints {
+				lines[len(lines)-len(hints)+i] = lipgloss.PlaceHorizontal(m.width-padding, lipgloss.Right, hint)
 			}
 		}
+	}
 
-		// Reconstruct the view with message and help in correct positions
-		var finalView strings.Builder
+	return strings.Join(append(append([]string{tabs}, lines...), "", footer), "\n")
+}
 
-		// Add content (including the original padding)
-		finalView.WriteString(strings.Join(parts[:contentEnd], "\n"))
+// shortHelp renders the one-line help at the bottom of the screen
+func (m *Model) shortHelp(help string) string {
+	return lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Help)).Render(help)
+}
 
-		// Add message if exists
-		if m.message != "" {
-			finalView.WriteString("\n\n  💡 ")
-			finalView.WriteString(m.message)
-			finalView.WriteString("\n")
-		}
+// trimBlankLines removes the padding lines at the end of a view
+func trimBlankLines(lines []string) []string {
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
 
-		// Calculate space needed for help text
-		helpHeight := 1 // Simple help
-		if m.showHelp {
-			helpHeight = 16 // Detailed help: 14 lines for content + 1 for initial newline + 1 for extra newline before Actions
+// statusBar describes where the user is, like
+// "alpine:3.20 │ layer 2/5 sha256:0123456789ab │ /etc │ 3/18", followed by
+// the transient message if any
+func (m *Model) statusBar() string {
+	parts := []string{filepicker.SanitizeName(m.ref)}
+	if n, layer := m.statusLayer(); layer != nil {
+		parts = append(parts, fmt.Sprintf("layer %d/%d %s", n, len(m.image.Layers), shortDigest(layer.DiffID)))
+	} else if layers := m.mergedLayers(); layers != "" {
+		parts = append(parts, layers)
+	}
+	if m.mode == LayerMode && len(m.marked) > 0 {
+		parts = append(parts, fmt.Sprintf("%d marked", len(m.marked)))
+	}
+	if marked := m.filepicker.MarkedFiles(); m.mode == FileMode && len(marked) > 0 {
+		parts = append(parts, fmt.Sprintf("%d marked", len(marked)))
+	}
+	if p := m.statusPath(); p != "" {
+		parts = append(parts, filepicker.SanitizeName(p))
+	}
+	if n, total := m.position(); total > 0 {
+		parts = append(parts, fmt.Sprintf("%d/%d", n, total))
+	}
+	if matches, total, ok := m.filterMatches(); ok {
+		parts = append(parts, fmt.Sprintf("%d/%d matches", matches, total))
+	}
+	if m.rebuilt {
+		parts = append(parts, "rebuilt, R to reload")
+	}
+	status := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed)).Render(strings.Join(parts, " │ "))
+	if m.message != "" {
+		status += "  " + lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight)).Render(printable(m.message))
+	}
+	return lipgloss.NewStyle().MaxWidth(m.width).Render(status)
+}
+
+// printable drops the control characters of a message, which may contain
+// names from the image, so that they can't drive the terminal. Unlike
+// filepicker.SanitizeName, backslashes are kept as they are, as the names
+// inserted in messages are sanitized already and Windows paths in errors
+// would be escaped twice.
+func printable(s string) string {
+	return strings.Map(func(r rune) rune {
+		if !unicode.IsPrint(r) {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// statusLayer returns the layer being browsed, or the selected one in the
+// list of layers, with its 1-based index
+func (m *Model) statusLayer() (int, *container.Layer) {
+	if m.image == nil {
+		return 0, nil
+	}
+	diffID := ""
+	switch {
+	case m.mode == LayerMode || m.mode == CommandMode:
+		item, ok := m.list.SelectedItem().(layerItem)
+		if !ok {
+			return 0, nil
+		}
+		diffID = item.diffID
+	case m.mode == BlobMode && m.blob != nil:
+		diffID = m.blob.DiffID
+	case m.loadingLayer != nil:
+		diffID = m.loadingLayer.DiffID
+	case m.currentLayer != nil:
+		diffID = m.currentLayer.DiffID
+	default:
+		return 0, nil
+	}
+	for i := range m.image.Layers {
+		if m.image.Layers[i].DiffID == diffID {
+			return i + 1, &m.image.Layers[i]
 		}
+	}
+	return 0, nil
+}
+
+// showJSON shows a manifest or config in the JSON tree
+func (m *Model) showJSON(content []byte) error {
+	tree, err := newJSONTree(content, m.width-4, m.height-6, m.jsonColors(), m.ascii, m.keys)
+	if err != nil {
+		return err
+	}
+	m.tree = tree
+	m.resizeJSON()
+	return nil
+}
 
-		// Calculate remaining space
-		usedLines := contentEnd
-		if m.message != "" {
-			usedLines += 3 // 2 for spacing + 1 for message
+// showTab switches to the tab of the given index
+func (m *Model) showTab(tab int) tea.Cmd {
+	if m.image == nil {
+		return nil
+	}
+	m.activeTab = tab
+	switch tab {
+	case 0: // Layers
+		if m.mode != FileMode {
+			// The files of a layer are kept as they are
+			m.mode = LayerMode
 		}
-		remainingLines := m.height - usedLines - helpHeight - 4 // Subtract 4 for bottom padding
-		if remainingLines > 0 {
-			finalView.WriteString(strings.Repeat("\n", remainingLines))
+	case 1: // Manifest
+		m.mode = ManifestMode
+		return func() tea.Msg {
+			content, err := m.image.GetManifestWithColor(false)
+			if err != nil {
+				return manifestMsg{err: err}
+			}
+			return manifestMsg{content: content}
+		}
+	case 2: // Config
+		m.mode = ConfigMode
+		return func() tea.Msg {
+			content, err := m.image.GetConfigWithColor(false)
+			if err != nil {
+				return configMsg{err: err}
+			}
+			return configMsg{content: content}
+		}
+	case 3: // Runtime
+		m.mode = RuntimeMode
+		return loadRuntime(m.image, m.jsonColors())
+	case 4: // Labels
+		m.mode = LabelsMode
+		return loadLabels(m.image)
+	case 5: // Annotations
+		m.mode = AnnotationsMode
+		return loadAnnotations(m.image)
+	}
+	return nil
+}
+
+// selectedCommand returns the command of the selected layer or build step
+func (m *Model) selectedCommand() (string, bool) {
+	switch item := m.list.SelectedItem().(type) {
+	case layerItem:
+		return item.command, true
+	case historyItem:
+		return item.command, true
+	}
+	return "", false
+}
+
+// markedLayers returns the marked layers, from the newest to the oldest
+func (m *Model) markedLayers() []container.Layer {
+	if m.image == nil {
+		return nil
+	}
+	var layers []container.Layer
+	for _, layer := range m.image.Layers {
+		if m.marked[layer.DiffID] {
+			layers = append(layers, layer)
 		}
+	}
+	return layers
+}
 
-		// Add help text
-		if m.showHelp {
-			finalView.WriteString("Navigation:\n" +
-				"  ↑/k: up\n" +
-				"  ↓/j: down\n" +
-				"  ←/h: back\n" +
-				"  →/l: view/open\n" +
-				"  g: first\n" +
-				"  G: last\n" +
-				"  K/pgup: page up\n" +
-				"  J/pgdown: page down\n" +
-				"  tab: next tab\n" +
-				"  shift+tab: previous tab\n" +
-				"\nActions:\n" +
-				"  .: toggle hidden\n" +
-				"  x: export file\n" +
-				"  /: filter files\n" +
-				"  ?: toggle help\n" +
-				"  q: quit\n\n\n\n") // Add 4 newlines after help text
-		} else {
-			finalView.WriteString(helpStyle.Render("↑/k up • ↓/j down • →/l view/open • ←/h back • tab switch • / filter • q quit • ? more") + "\n\n\n\n") // Add 4 newlines after help text
+// mergedLayers describes the layers being viewed together, like
+// "layers 1+3+4", or returns an empty string
+func (m *Model) mergedLayers() string {
+	layer := m.currentLayer
+	if m.loadingLayer != nil {
+		layer = m.loadingLayer
+	}
+	if layer == nil || m.image == nil {
+		return ""
+	}
+	merged := layer.MergedDiffIDs()
+	if merged == nil {
+		return ""
+	}
+	var numbers []string
+	for i := range m.image.Layers {
+		if slices.Contains(merged, m.image.Layers[i].DiffID) {
+			numbers = append(numbers, strconv.Itoa(i+1))
 		}
+	}
+	return "layers " + strings.Join(numbers, "+")
+}
 
-		view = finalView.String()
+// statusPath returns the directory or file being browsed
+func (m *Model) statusPath() string {
+	switch m.mode {
+	case FileMode:
+		return "/" + strings.TrimPrefix(m.filepicker.CurrentPath(), ".")
+	case ViewMode, DiffMode:
+		// The config compared in DiffMode has no path
+		if m.currentFile != nil && (m.mode == ViewMode || m.diffReturn == ViewMode) {
+			return "/" + strings.TrimPrefix(m.currentFile.Path, "/")
+		}
 	case ManifestMode, ConfigMode:
-		baseView := m.viewport.View()
+		if n := m.tree.Selected(); n != nil {
+			return n.path
+		}
+	case LogMode:
+		return m.logFile
+	}
+	return ""
+}
 
-		// Split the view into content and padding
-		parts := strings.Split(baseView, "\n")
+// position returns the 1-based position of the selected item, or of the
+// first visible line in the viewers, and the number of them
+func (m *Model) position() (int, int) {
+	switch m.mode {
+	case LayerMode:
+		items := m.list.VisibleItems()
+		if len(items) == 0 {
+			return 0, 0
+		}
+		return m.list.Index() + 1, len(items)
+	case FileMode:
+		return m.filepicker.Position()
+	case LabelsMode, AnnotationsMode, TagsMode, ReposMode, RecentMode:
+		items := m.table.VisibleItems()
+		if len(items) == 0 {
+			return 0, 0
+		}
+		return m.table.Index() + 1, len(items)
+	case ManifestMode, ConfigMode:
+		return m.tree.Position()
+	case UsageMode:
+		if m.showTypes && len(m.fileTypes) > 0 {
+			return m.usageIndex + 1, len(m.fileTypes)
+		}
+		if dir := m.usageDir(); !m.showTypes && dir != nil && len(dir.Children) > 0 {
+			return m.usageIndex + 1, len(dir.Children)
+		}
+	case ViewMode, RuntimeMode, CommandMode, LogMode, DiffMode, BlobMode:
+		total := m.viewport.TotalLineCount()
+		if total == 0 {
+			return 0, 0
+		}
+		return m.viewport.YOffset + 1, total
+	}
+	return 0, 0
+}
 
-		// Find where the actual content ends (before padding)
-		contentEnd := 0
-		for i := len(parts) - 1; i >= 0; i-- {
-			if strings.TrimSpace(parts[i]) != "" {
-				contentEnd = i + 1
-				break
-			}
+// filterMatches returns the number of layers or files matching the filter
+// and the number of them. ok is false if no filter is applied.
+func (m *Model) filterMatches() (matches, total int, ok bool) {
+	switch m.mode {
+	case LayerMode:
+		if m.list.FilterState() == list.Unfiltered || m.list.FilterValue() == "" {
+			return 0, 0, false
+		}
+		return len(m.list.VisibleItems()), len(m.list.Items()), true
+	case FileMode:
+		return m.filepicker.FilterMatches()
+	case LabelsMode, AnnotationsMode, TagsMode, ReposMode, RecentMode:
+		if m.table.FilterState() == list.Unfiltered || m.table.FilterValue() == "" {
+			return 0, 0, false
 		}
+		return len(m.table.VisibleItems()), len(m.table.Items()), true
+	}
+	return 0, 0, false
+}
 
-		// Reconstruct the view with message and help
-		var finalView strings.Builder
+// workInProgress describes the work that quitting would abort, or returns an
+// empty string if there is none
+func (m *Model) workInProgress() string {
+	switch {
+	case m.exports > 0:
+		return "Export"
+	case m.mode == PullingMode:
+		return "Pull"
+	case m.mode == LoadingMode && m.loadingLayer != nil:
+		return "Layer download"
+	}
+	return ""
+}
 
-		// Add content
-		finalView.WriteString(strings.Join(parts[:contentEnd], "\n"))
+// startExport returns the context of a new export
+func (m *Model) startExport() context.Context {
+	if m.exports == 0 {
+		m.exportCtx, m.cancelExports = context.WithCancel(context.Background())
+	}
+	m.exports++
+	return m.exportCtx
+}
 
-		// Calculate space needed for help text
-		helpHeight := 2 // Simple help (1 for help text + 1 for initial newline)
-		if m.showHelp {
-			helpHeight = 14 // Detailed help: 12 lines for content + 1 for initial newline + 1 for extra newline before Actions
-		}
+// quit cancels the work in progress and quits once the exports have removed
+// their temporary files
+func (m *Model) quit() (tea.Model, tea.Cmd) {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	m.reporter = nil
+	if m.exports == 0 {
+		return m, tea.Quit
+	}
+	m.cancelExports()
+	m.quitting = true
+	m.message = "Canceling exports..."
+	return m, nil
+}
 
-		// Calculate remaining space
-		usedLines := contentEnd
-		if m.message != "" {
-			usedLines += 3 // 2 for spacing + 1 for message
-		}
-		remainingLines := m.height - usedLines - helpHeight - 4 // Subtract 4 for bottom padding
+// cancelLoading aborts the pull or layer load in progress and returns to a usable mode
+func (m *Model) cancelLoading() (tea.Model, tea.Cmd) {
+	if m.cancel != nil {
+		m.cancel()
+		m.cancel = nil
+	}
+	// Ignore progress of the canceled job
+	m.reporter = nil
+
+	switch {
+	case m.mode == PullingMode && m.image == nil:
+		// Nothing has been loaded yet: the pull can be retried or another
+		// reference pulled instead
+		debug("Pull canceled")
+		m.err = fmt.Errorf("pull of %s: %w", m.ref, context.Canceled)
+		m.message = ""
+		m.mode = ErrorMode
+		m.retry = m.pullImage
+		return m, nil
+	case m.mode == PullingMode:
+		debug("Pull canceled")
+		m.mode = LayerMode
+		m.message = "Pull canceled"
+	case m.loadingLayer != nil:
+		debug("Layer loading canceled")
+		m.loadingLayer = nil
+		m.mode = LayerMode
+		m.message = "Layer loading canceled"
+	default:
+		// Reading a file for the viewer
+		m.mode = FileMode
+		return m, nil
+	}
+	m.updateTitle()
+	return m, hideMessageAfter(3 * time.Second)
+}
 
-		// Add message if exists
-		if m.message != "" {
-			finalView.WriteString("\n\n  💡 ")
-			finalView.WriteString(m.message)
-			finalView.WriteString("\n") // Add newline after message
-		}
+// transferView describes the download of the layer being loaded, like
+// "sha256:0123456789ab  12.3 MB / 45.6 MB  3.2 MB/s  ETA 12s"
+func (m *Model) transferView() string {
+	if m.loadingLayer == nil {
+		return ""
+	}
+	complete, total, ok := m.loadingLayer.Transferred()
+	if !ok || total == 0 {
+		return ""
+	}
+
+	parts := []string{shortDigest(m.loadingLayer.DiffID)}
+	parts = append(parts, m.rateParts(&m.rate, complete, total)...)
+	return strings.Join(parts, "  ")
+}
+
+// rateParts describes the bytes of a download, followed by its speed and
+// remaining time once they are known
+func (m *Model) rateParts(rate *transferRate, complete, total int64) []string {
+	parts := []string{fmt.Sprintf("%s / %s", m.formatSize(complete), m.formatSize(total))}
+	if rate == nil {
+		return parts
+	}
+	if rate.speed > 0 {
+		parts = append(parts, m.formatSize(int64(rate.speed))+"/s")
+	}
+	if eta := rate.eta(complete, total); eta > 0 {
+		parts = append(parts, "ETA "+eta.String())
+	}
+	return parts
+}
 
-		// Add remaining space
-		if remainingLines > 0 {
-			finalView.WriteString(strings.Repeat("\n", remainingLines))
+// sampleBlobs records the bytes transferred so far of the blobs being
+// downloaded
+func (m *Model) sampleBlobs(now time.Time) {
+	if m.blobRates == nil {
+		return
+	}
+	for _, blob := range m.blobs {
+		if blob.Exists || blob.Total == 0 || blob.Complete >= blob.Total {
+			delete(m.blobRates, blob.Blob)
+			continue
+		}
+		rate, ok := m.blobRates[blob.Blob]
+		if !ok {
+			rate = &transferRate{}
+			m.blobRates[blob.Blob] = rate
 		}
+		rate.sample(blob.Complete, now)
+	}
+}
 
-		// Add help text
-		helpStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("240"))
-		if m.showHelp {
-			finalView.WriteString("\n" +
-				"Navigation:\n" +
-				"  ↑/k: up\n" +
-				"  ↓/j: down\n" +
-				"  ←/h: back\n" +
-				"  g: first\n" +
-				"  G: last\n" +
-				"  K/pgup: page up\n" +
-				"  J/pgdown: page down\n" +
-				"\nActions:\n" +
-				"  x: export JSON\n" +
-				"  ?: toggle help\n" +
-				"  q: quit\n\n\n\n") // Add 4 newlines after help text
-		} else {
-			finalView.WriteString("\n" + helpStyle.Render("↑/k up • ↓/j down • x export • q quit • ? more") + "\n\n\n\n") // Add 4 newlines after help text
+// blobsView lists the blobs fetched by the pull with their status, like
+// "sha256:0123456789ab  Downloading 40%  1.2 MiB / 3.0 MiB  800 KiB/s  ETA 2s"
+func (m *Model) blobsView() string {
+	var rows [][]string
+	for _, blob := range m.blobs {
+		label := shortDigest(blob.Blob)
+		if dir, ok := strings.CutSuffix(blob.Blob, "/layer.tar"); ok && len(dir) > 12 {
+			label = dir[:12] + "/layer.tar"
 		}
+		switch {
+		case blob.Exists:
+			rows = append(rows, []string{label, "Exists", ""})
+		case blob.Total > 0 && blob.Complete >= blob.Total:
+			rows = append(rows, []string{label, "Done", m.formatSize(blob.Total)})
+		case blob.Total > 0:
+			percent := fmt.Sprintf("%d%%", blob.Complete*100/blob.Total)
+			if m.accessible {
+				percent = percentView(float64(blob.Complete) / float64(blob.Total))
+			}
+			rows = append(rows, []string{label, "Downloading " + percent, strings.Join(m.rateParts(m.blobRates[blob.Blob], blob.Complete, blob.Total), "  ")})
+		default:
+			rows = append(rows, []string{label, "Downloading", ""})
+		}
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+
+	var widths [2]int
+	for _, row := range rows {
+		widths[0] = max(widths[0], len(row[0]))
+		widths[1] = max(widths[1], len(row[1]))
+	}
+	lines := make([]string, len(rows))
+	for i, row := range rows {
+		lines[i] = strings.TrimRight(fmt.Sprintf("%-*s  %-*s  %s", widths[0], row[0], widths[1], row[1], row[2]), " ")
+	}
+	return lipgloss.NewStyle().PaddingLeft(padding).Foreground(lipgloss.Color(m.theme.Dimmed)).Render(strings.Join(lines, "\n"))
+}
 
-		view = finalView.String()
+// describeError returns what the user can do about a failed pull or layer
+// load, and whether retrying may help
+func describeError(err error, ref string) (string, bool) {
+	switch {
+	case errors.Is(err, container.ErrUnauthorized):
+		registry := "the registry"
+		if reference, err := name.ParseReference(ref); err == nil {
+			registry = reference.Context().RegistryStr()
+		}
+		return fmt.Sprintf("run `docker login %s`, then r: retry", registry), true
+	case errors.Is(err, container.ErrRateLimited):
+		return "rate limited by the registry, wait a moment, then r: retry", true
+	case errors.Is(err, container.ErrNotFound):
+		return "check the image name and tag", false
+	case errors.Is(err, container.ErrUnsupportedMediaType):
+		return "not a container image", false
+	case errors.As(err, new(*name.ErrBadName)):
+		return "check the image reference", false
+	case isNetworkError(err):
+		return "check your network connection and proxy settings, then r: retry", true
 	default:
-		view = m.list.View()
+		return "r: retry", true
 	}
+}
 
-	// Render tabs
-	var tabViews []string
-	for i, tab := range m.tabs {
-		style := m.tabStyle
-		if i == m.activeTab {
-			style = m.activeTabStyle
-		}
-		tabViews = append(tabViews, style.Render(tab))
+// loadingStageView describes what the layer loading is doing
+func loadingStageView(stage container.Stage) string {
+	switch stage {
+	case container.StageDownloading:
+		return "Downloading layer..."
+	case container.StageDecompressing:
+		return "Extracting layer..."
+	case container.StageIndexing:
+		return "Indexing files..."
+	default:
+		return "Loading layer..."
 	}
-	tabs := lipgloss.JoinHorizontal(lipgloss.Top, tabViews...)
-	tabs = lipgloss.NewStyle().BorderBottom(true).Render(tabs)
+}
 
-	view = strings.TrimRight(view, "\n")
-	return fmt.Sprintf("%s\n%s", tabs, view)
+// shortDigest shortens a digest to its algorithm and the first 12 hex characters
+func shortDigest(digest string) string {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || len(hex) <= 12 {
+		return digest
+	}
+	return algorithm + ":" + hex[:12]
+}
+
+// inFilter reports whether the user is typing a filter
+func (m *Model) inFilter() bool {
+	switch m.mode {
+	case LayerMode:
+		return m.list.FilterState() == list.Filtering
+	case FileMode:
+		return m.filepicker.InFilterMode()
+	case LabelsMode, AnnotationsMode, TagsMode, ReposMode, RecentMode:
+		return m.table.FilterState() == list.Filtering
+	case ManifestMode, ConfigMode:
+		return m.editingQuery
+	case ViewMode, RuntimeMode:
+		return m.editingCompare
+	}
+	return false
 }
 
 func (m *Model) updateTitle() {
@@ -1220,14 +2296,18 @@ func (m *Model) showFiles(layer *container.Layer, path string) error {
 		tarfsPath = path[1:]
 	}
 
-	files, err := layer.GetFiles(tarfsPath)
+	files, err := layer.GetFiles(context.Background(), tarfsPath)
 	if err != nil {
 		return fmt.Errorf("failed to get files: %w", err)
 	}
 
 	var items []list.Item
 	for _, file := range files {
-		items = append(items, fileItem{file: file})
+		items = append(items, fileItem{
+			file:    file,
+			size:    m.formatSize(file.Size),
+			modTime: m.formatTime(file.ModifiedAt),
+		})
 	}
 
 	m.list.SetItems(items)
@@ -1240,50 +2320,33 @@ func (m *Model) showFiles(layer *container.Layer, path string) error {
 	return nil
 }
 
-func tickCmd() tea.Cmd {
-	return tea.Tick(time.Millisecond*50, func(t time.Time) tea.Msg {
-		return tickMsg(t)
-	})
-}
-
-func initializeLayer(layer *container.Layer) tea.Cmd {
-	// Create a new channel for progress updates
-	progressChan = make(chan float64, 100)
-
+func initializeLayer(ctx context.Context, layer *container.Layer, reporter *progressReporter) tea.Cmd {
 	debug("Starting layer initialization")
 
 	// Create a command that will initialize the layer
 	loadCmd := func() tea.Msg {
+		defer reporter.close()
+
 		if layer == nil {
 			debug("Layer is nil, returning error")
-			close(progressChan)
 			return loadingLayerMsg{layer: nil, err: fmt.Errorf("invalid layer")}
 		}
 
 		debug("Starting layer initialization process")
-		err := layer.InitializeLayer(func(progress float64) {
-			select {
-			case progressChan <- progress:
-				debug("Progress sent to channel: %.2f", progress)
-			default:
-				debug("Progress channel full: %.2f", progress)
-			}
-		})
-
+		err := layer.InitializeLayer(ctx, reporter.report)
 		debug("Layer initialization completed with error: %v", err)
-		close(progressChan)
 
 		if err != nil {
-			return loadingLayerMsg{layer: nil, err: fmt.Errorf("failed to initialize layer: %w", err)}
+			return loadingLayerMsg{layer: layer, err: fmt.Errorf("failed to initialize layer: %w", err)}
 		}
 
 		return loadingLayerMsg{layer: layer}
 	}
 
-	return tea.Batch(tickCmd(), loadCmd)
+	return tea.Batch(loadCmd, reporter.wait())
 }
 
-func viewFile(layer *container.Layer, path string) tea.Cmd {
+func viewFile(ctx context.Context, layer *container.Layer, path string) tea.Cmd {
 	return func() tea.Msg {
 		if layer == nil {
 			return viewFileMsg{err: fmt.Errorf("layer is nil")}
@@ -1295,7 +2358,7 @@ func viewFile(layer *container.Layer, path string) tea.Cmd {
 			tarfsPath = path[1:]
 		}
 
-		content, err := layer.ReadFile(tarfsPath)
+		content, err := layer.ReadFile(ctx, tarfsPath)
 		if err != nil {
 			return viewFileMsg{err: fmt.Errorf("failed to read file: %w", err)}
 		}
@@ -1304,7 +2367,7 @@ func viewFile(layer *container.Layer, path string) tea.Cmd {
 	}
 }
 
-func exportFile(layer *container.Layer, file container.File) tea.Cmd {
+func exportFile(ctx context.Context, layer *container.Layer, file container.File) tea.Cmd {
 	return func() tea.Msg {
 		if layer == nil {
 			return exportFileMsg{err: fmt.Errorf("layer is nil")}
@@ -1316,7 +2379,7 @@ func exportFile(layer *container.Layer, file container.File) tea.Cmd {
 			tarfsPath = tarfsPath[1:]
 		}
 
-		content, err := layer.ReadFile(tarfsPath)
+		content, err := layer.ReadFile(ctx, tarfsPath)
 		if err != nil {
 			return exportFileMsg{err: fmt.Errorf("failed to read file: %w", err)}
 		}
@@ -1328,15 +2391,29 @@ func exportFile(layer *container.Layer, file container.File) tea.Cmd {
 		}
 
 		// Create output file in current directory
-		outputPath := filepath.Join(cwd, file.Name)
-		if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		name, err := localFileName(file.Name)
+		if err != nil {
+			return exportFileMsg{err: err}
+		}
+		outputPath := filepath.Join(cwd, name)
+		if err := writeFile(ctx, outputPath, content); err != nil {
 			return exportFileMsg{err: fmt.Errorf("failed to write file: %w", err)}
 		}
 
-		return exportFileMsg{err: nil}
+		return exportFileMsg{path: outputPath}
 	}
 }
 
+// localFileName checks that the name of a file in a layer can be used as a
+// file name on this system. Names valid in Linux layers may contain path
+// separators or reserved names on Windows, such as "a\b" or "CON".
+func localFileName(name string) (string, error) {
+	if !filepath.IsLocal(name) || filepath.Base(name) != name {
+		return "", fmt.Errorf("cannot export %q: not a valid file name on this system", filepicker.SanitizeName(name))
+	}
+	return name, nil
+}
+
 func hideMessageAfter(d time.Duration) tea.Cmd {
 	return tea.Tick(d, func(time.Time) tea.Msg {
 		return hideMessageMsg{}
@@ -1354,7 +2431,7 @@ func exportFileToPath(layer *container.Layer, file container.File, outputPath st
 		tarfsPath = tarfsPath[1:]
 	}
 
-	content, err := layer.ReadFile(tarfsPath)
+	content, err := layer.ReadFile(context.Background(), tarfsPath)
 	if err != nil {
 		return fmt.Errorf("failed to read file: %w", err)
 	}
@@ -1362,21 +2439,58 @@ func exportFileToPath(layer *container.Layer, file container.File, outputPath st
 	// If outputPath is a directory, append the filename
 	fi, err := os.Stat(outputPath)
 	if err == nil && fi.IsDir() {
-		outputPath = filepath.Join(outputPath, file.Name)
+		name, err := localFileName(file.Name)
+		if err != nil {
+			return err
+		}
+		outputPath = filepath.Join(outputPath, name)
 	}
 
-	if err := os.WriteFile(outputPath, content, 0644); err != nil {
+	if err := writeFile(context.Background(), outputPath, content); err != nil {
 		return fmt.Errorf("failed to write file: %w", err)
 	}
 
 	return nil
 }
 
+// writeFile writes the file under a temporary name and renames it when it is
+// complete, so that failed or canceled exports don't leave partial files
+func writeFile(ctx context.Context, name string, content []byte) error {
+	return writeFileFrom(ctx, name, bytes.NewReader(content))
+}
+
+// writeFileFrom is writeFile with the content read from r
+func writeFileFrom(ctx context.Context, name string, r io.Reader) error {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = ctx.Err()
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
 // Add a new message type for transition
 type transitionMsg struct{}
 
 // Add new export functions
-func exportManifest(image *container.Image) tea.Cmd {
+func exportManifest(ctx context.Context, image *container.Image) tea.Cmd {
 	return func() tea.Msg {
 		if image == nil {
 			return exportFileMsg{err: fmt.Errorf("image is nil")}
@@ -1395,15 +2509,15 @@ func exportManifest(image *container.Image) tea.Cmd {
 
 		// Create output file in current directory
 		outputPath := filepath.Join(cwd, "manifest.json")
-		if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		if err := writeFile(ctx, outputPath, content); err != nil {
 			return exportFileMsg{err: fmt.Errorf("failed to write file: %w", err)}
 		}
 
-		return exportFileMsg{err: nil}
+		return exportFileMsg{path: outputPath}
 	}
 }
 
-func exportConfig(image *container.Image) tea.Cmd {
+func exportConfig(ctx context.Context, image *container.Image) tea.Cmd {
 	return func() tea.Msg {
 		if image == nil {
 			return exportFileMsg{err: fmt.Errorf("image is nil")}
@@ -1422,72 +2536,10 @@ func exportConfig(image *container.Image) tea.Cmd {
 
 		// Create output file in current directory
 		outputPath := filepath.Join(cwd, "config.json")
-		if err := os.WriteFile(outputPath, content, 0644); err != nil {
+		if err := writeFile(ctx, outputPath, content); err != nil {
 			return exportFileMsg{err: fmt.Errorf("failed to write file: %w", err)}
 		}
 
-		return exportFileMsg{err: nil}
+		return exportFileMsg{path: outputPath}
 	}
 }
-
-// colorizeJSON adds ANSI color codes to JSON string
-func colorizeJSON(input []byte) []byte {
-	var out strings.Builder
-	content := string(input)
-	lines := strings.Split(content, "\n")
-
-	for _, line := range lines {
-		// Find the position of the first non-whitespace character
-		firstChar := len(line) - len(strings.TrimLeft(line, " "))
-
-		// Extract key and value
-		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
-
-		if len(parts) == 2 {
-			// Line contains both key and value
-			keyStr := strings.Trim(parts[0], `" ,`)
-			value := strings.TrimSpace(parts[1])
-
-			// Add colors
-			coloredKey := fmt.Sprintf("\x1b[36m%s\x1b[0m", keyStr) // Cyan for keys
-			coloredValue := value
-
-			// Color different types of values
-			switch {
-			case strings.HasPrefix(value, `"`):
-				// String values in green
-				coloredValue = fmt.Sprintf("\x1b[32m%s\x1b[0m", value)
-			case strings.HasPrefix(value, "{") || strings.HasPrefix(value, "["):
-				// Objects and arrays in yellow
-				coloredValue = fmt.Sprintf("\x1b[33m%s\x1b[0m", value)
-			case value == "true" || value == "false":
-				// Booleans in magenta
-				coloredValue = fmt.Sprintf("\x1b[35m%s\x1b[0m", value)
-			case strings.ContainsAny(value, "0123456789"):
-				// Numbers in blue
-				coloredValue = fmt.Sprintf("\x1b[34m%s\x1b[0m", value)
-			}
-
-			// Reconstruct the line with proper indentation
-			out.WriteString(strings.Repeat(" ", firstChar))
-			out.WriteString(`"`)
-			out.WriteString(coloredKey)
-			out.WriteString(`": `)
-			out.WriteString(coloredValue)
-			out.WriteString("\n")
-		} else {
-			// Line contains only structural elements (braces, brackets, etc.)
-			trimmed := strings.TrimSpace(line)
-			if trimmed != "" {
-				// Structural elements in yellow
-				out.WriteString(strings.Repeat(" ", firstChar))
-				out.WriteString(fmt.Sprintf("\x1b[33m%s\x1b[0m", trimmed))
-				out.WriteString("\n")
-			} else {
-				out.WriteString("\n")
-			}
-		}
-	}
-
-	return []byte(out.String())
-}
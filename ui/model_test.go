@@ -3,15 +3,29 @@ package ui
 import (
 	"archive/tar"
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"net"
 	"net/http/httptest"
 	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
+	"unicode"
 
 	"github.com/charmbracelet/bubbles/list"
+	"github.com/charmbracelet/bubbles/spinner"
+	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/registry"
 	v1 "github.com/google/go-containerregistry/pkg/v1"
@@ -20,6 +34,7 @@ import (
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/google/go-containerregistry/pkg/v1/tarball"
 	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -109,7 +124,7 @@ func setupTestImage(t *testing.T) (*container.Image, error) {
 	}
 
 	// Load the image using container.NewImage
-	image, _, err := container.NewImage(ref, func(float64) {})
+	image, _, err := container.NewImage(context.Background(), ref, func(container.Progress) {})
 	if err != nil {
 		return nil, err
 	}
@@ -132,15 +147,28 @@ func TestNewModel(t *testing.T) {
 	require.NoError(t, err)
 
 	tests := []struct {
-		name    string
-		ref     string
-		wantErr bool
+		name       string
+		ref        string
+		pullPolicy container.PullPolicy
+		wantErr    bool
 	}{
 		{
 			name:    "valid reference",
 			ref:     ref,
 			wantErr: false,
 		},
+		{
+			name:       "pull always",
+			ref:        ref,
+			pullPolicy: container.PullAlways,
+			wantErr:    false,
+		},
+		{
+			name:       "pull never without local image",
+			ref:        ref,
+			pullPolicy: container.PullNever,
+			wantErr:    true,
+		},
 		{
 			name:    "invalid reference",
 			ref:     "invalid:@reference",
@@ -155,7 +183,7 @@ func TestNewModel(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			model, cmd := NewModel(tt.ref)
+			model, cmd := NewModel(tt.ref, tt.pullPolicy)
 			if tt.wantErr {
 				assert.NotNil(t, cmd)
 				msg := cmd()
@@ -244,6 +272,165 @@ func TestModelUpdate(t *testing.T) {
 	}
 }
 
+func TestCancelLoading(t *testing.T) {
+	tests := []struct {
+		name        string
+		initialMode Mode
+		image       *container.Image
+		layer       *container.Layer
+		wantMode    Mode
+		wantMessage string
+	}{
+		{
+			name:        "cancel pull",
+			initialMode: PullingMode,
+			image:       &container.Image{},
+			wantMode:    LayerMode,
+			wantMessage: "Pull canceled",
+		},
+		{
+			name:        "cancel first pull",
+			initialMode: PullingMode,
+			wantMode:    ErrorMode,
+		},
+		{
+			name:        "cancel layer loading",
+			initialMode: LoadingMode,
+			layer:       &container.Layer{},
+			wantMode:    LayerMode,
+			wantMessage: "Layer loading canceled",
+		},
+		{
+			name:        "cancel file loading",
+			initialMode: LoadingMode,
+			wantMode:    FileMode,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			model := Model{
+				mode:         tt.initialMode,
+				list:         list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0),
+				keys:         newKeyMap(),
+				cancel:       cancel,
+				image:        tt.image,
+				loadingLayer: tt.layer,
+				reporter:     newProgressReporter(),
+			}
+			updatedModel, _ := model.Update(tea.KeyMsg{Type: tea.KeyEsc})
+			m := updatedModel.(*Model)
+			assert.Equal(t, tt.wantMode, m.mode)
+			assert.Equal(t, tt.wantMessage, m.message)
+			assert.ErrorIs(t, ctx.Err(), context.Canceled)
+			assert.Nil(t, m.reporter)
+
+			// A late result of the canceled job is ignored
+			updatedModel, _ = m.Update(loadingLayerMsg{layer: tt.layer, err: context.Canceled})
+			assert.Equal(t, tt.wantMode, updatedModel.(*Model).mode)
+			updatedModel, _ = m.Update(viewFileMsg{content: "late"})
+			assert.Equal(t, tt.wantMode, updatedModel.(*Model).mode)
+		})
+	}
+}
+
+func TestCancelFirstPull(t *testing.T) {
+	_, cancel := context.WithCancel(context.Background())
+	model := &Model{
+		mode:     PullingMode,
+		ref:      "example.com/test/image:latest",
+		list:     list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0),
+		keys:     newKeyMap(),
+		tabs:     []string{"Layers", "Manifest"},
+		cancel:   cancel,
+		reporter: newProgressReporter(),
+	}
+
+	// Without an image, the pull can be retried or another reference pulled
+	updatedModel, _ := model.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m := updatedModel.(*Model)
+	assert.Equal(t, ErrorMode, m.mode)
+	assert.ErrorIs(t, m.err, context.Canceled)
+	assert.Contains(t, m.errorView(), "canceled")
+	assert.Contains(t, m.errorHelp(), "r retry")
+	assert.Contains(t, m.errorHelp(), "e edit reference")
+
+	// The late error of the canceled pull doesn't replace it
+	updatedModel, _ = m.Update(errMsg{err: context.Canceled})
+	m = updatedModel.(*Model)
+	assert.Equal(t, ErrorMode, m.mode)
+
+	// Neither the layers nor the tabs can be opened without an image
+	for _, mode := range []Mode{ErrorMode, LayerMode} {
+		m.mode = mode
+		for _, msg := range []tea.KeyMsg{{Type: tea.KeyEnter}, {Type: tea.KeyTab}, {Type: tea.KeyShiftTab}} {
+			assert.NotPanics(t, func() {
+				updatedModel, cmd := m.Update(msg)
+				if cmd != nil {
+					cmd()
+				}
+				assert.Equal(t, mode, updatedModel.(*Model).mode)
+			}, "%s in mode %d", msg, mode)
+		}
+	}
+	assert.Nil(t, m.markedLayers())
+	assert.Nil(t, m.showTab(1))
+
+	// Pressing r pulls the image again
+	m.mode = ErrorMode
+	updatedModel, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = updatedModel.(*Model)
+	assert.NotNil(t, cmd)
+	assert.Equal(t, PullingMode, m.mode)
+	m.cancel()
+}
+
+func TestRetry(t *testing.T) {
+	model := &Model{
+		mode: PullingMode,
+		ref:  "example.com/test/image:latest",
+		list: list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0),
+		keys: newKeyMap(),
+	}
+
+	// A failed pull shows the error screen with a retry action
+	updatedModel, _ := model.Update(errMsg{err: assert.AnError})
+	m := updatedModel.(*Model)
+	assert.Equal(t, ErrorMode, m.mode)
+	assert.Contains(t, m.errorHelp(), "r retry")
+	require.NotNil(t, m.retry)
+
+	updatedModel, _ = m.Update(hideMessageMsg{})
+	m = updatedModel.(*Model)
+	assert.Equal(t, ErrorMode, m.mode)
+
+	// Pressing r starts the pull again
+	updatedModel, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = updatedModel.(*Model)
+	assert.NotNil(t, cmd)
+	assert.Equal(t, PullingMode, m.mode)
+	assert.Empty(t, m.message)
+	assert.Nil(t, m.retry)
+	m.cancel()
+
+	// A failed layer load is retried with the same layer
+	m.mode = LoadingMode
+	layer := &container.Layer{DiffID: "sha256:test"}
+	m.loadingLayer = layer
+	updatedModel, _ = m.Update(loadingLayerMsg{layer: layer, err: assert.AnError})
+	m = updatedModel.(*Model)
+	assert.Equal(t, LayerMode, m.mode)
+	require.NotNil(t, m.retry)
+
+	updatedModel, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'r'}})
+	m = updatedModel.(*Model)
+	assert.NotNil(t, cmd)
+	assert.Equal(t, LoadingMode, m.mode)
+	assert.Equal(t, "sha256:test", m.loadingLayer.DiffID)
+	m.cancel()
+}
+
 func TestModelView(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -291,12 +478,50 @@ func TestModelView(t *testing.T) {
 	}
 }
 
+func TestModelViewASCII(t *testing.T) {
+	m := Model{spinner: spinner.New(spinner.WithSpinner(spinner.Points)), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetASCII(true)
+	m.image = &container.Image{Layers: []container.Layer{{DiffID: "sha256:test", Command: "RUN echo \u2192"}}}
+	m.list = newCustomList(m.layerItems(), 100, 40, m.theme)
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 50
+	m.message = "📋 Diff ID copied to clipboard"
+
+	isASCII := func(s string) bool {
+		for _, r := range s {
+			if r > unicode.MaxASCII {
+				return false
+			}
+		}
+		return true
+	}
+	for _, mode := range []Mode{LayerMode, LoadingMode, PullingMode} {
+		m.mode = mode
+		view := m.View()
+		assert.True(t, isASCII(view), "mode %v: %q", mode, view)
+	}
+	m.mode = LayerMode
+	assert.Contains(t, m.View(), "Layers")
+	assert.Contains(t, m.View(), "k up | j down")
+
+	// File contents are kept as is
+	m.mode = ViewMode
+	m.viewport = viewport.New(100, 10)
+	m.viewport.SetContent("a → b")
+	assert.Contains(t, m.View(), "a → b")
+
+	// JSON isn't colored without colors
+	tree, err := newJSONTree([]byte(`{"a":1}`), 100, 10, jsonColors{}, true, newKeyMap())
+	require.NoError(t, err)
+	assert.Equal(t, "> - {\n      \"a\": 1\n    }", strings.TrimRight(tree.View(), "\n"))
+}
+
 func TestShowFiles(t *testing.T) {
 	img, err := setupTestImage(t)
 	require.NoError(t, err)
 
 	// Initialize the layer
-	err = img.Layers[0].InitializeLayer(func(float64) {})
+	err = img.Layers[0].InitializeLayer(context.Background(), func(container.Progress) {})
 	require.NoError(t, err)
 
 	tests := []struct {
@@ -338,41 +563,2083 @@ func TestShowFiles(t *testing.T) {
 	}
 }
 
-func TestColorizeJSON(t *testing.T) {
+func TestPreview(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = img
+	m.ready, m.mode, m.width, m.height = true, FileMode, 100, 30
+	m.currentLayer = layer
+	m.filepicker = filepicker.New(&containerFS{layer: layer})
+	m.filepicker.SetHeight(m.height - 6)
+	model, _ := m.Update(m.filepicker.Init()())
+	m = model.(*Model)
+	assert.NotContains(t, m.View(), "test content")
+
+	// The preview is loaded when it is shown
+	model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	m = model.(*Model)
+	require.NotNil(t, cmd)
+	assert.Contains(t, m.View(), "Loading...")
+	model, _ = m.Update(cmd())
+	m = model.(*Model)
+	view := m.View()
+	assert.Contains(t, view, "/test.txt")
+	assert.Contains(t, view, "│ test content")
+
+	// Previews of files no longer selected are dropped
+	model, _ = m.Update(previewMsg{preview: filePreview{path: "/other.txt", loaded: true, content: []byte("other")}})
+	m = model.(*Model)
+	assert.NotContains(t, m.View(), "other")
+
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'p'}})
+	m = model.(*Model)
+	assert.NotContains(t, m.View(), "test content")
+}
+
+func TestToggleIcons(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap()}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, FileMode, 100, 30
+	m.SetIcons(true)
+	press := func() {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'i'}})
+		m = model.(*Model)
+	}
+
+	press()
+	assert.False(t, m.filepicker.ShowIcons())
+	press()
+	assert.True(t, m.filepicker.ShowIcons())
+
+	// Icons can't be shown in the ASCII mode
+	press()
+	m.SetASCII(true)
+	press()
+	assert.False(t, m.filepicker.ShowIcons())
+}
+
+func TestPreviewContent(t *testing.T) {
+	assert.False(t, isBinary([]byte("plain text\n")))
+	assert.False(t, isBinary([]byte("cut in the middle of \xe3\x81")))
+	assert.True(t, isBinary([]byte("ELF\x00\x01")))
+	assert.True(t, isBinary([]byte("\xff\xfe invalid UTF-8 \xff")))
+
+	assert.Equal(t, []string{"a    b", "c"}, textPreview([]byte("a\tb\r\nc\nd\n"), 2))
+	assert.Equal(t, []string{"bell\ufffd"}, textPreview([]byte("bell\a"), 2))
+
+	assert.Equal(t, []string{
+		"00000000  7f 45 4c 46 00 01 02 03 04 05 06 07 08 09 0a 0b  |.ELF............|",
+		"00000010  41 42                                            |AB|",
+	}, hexPreview([]byte("\x7fELF\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0bAB"), 80, 10))
+	// Fewer bytes per line in a narrow pane
+	assert.Equal(t, []string{"00000000  7f 45 4c 46 00 01 02 03  |.ELF....|"}, hexPreview([]byte("\x7fELF\x00\x01\x02\x03"), 50, 10))
+
+	entries := []container.File{{Name: "bin", IsDir: true}, {Name: "a"}, {Name: "b"}, {Name: "c"}}
+	assert.Equal(t, []string{"bin/", "a", "b", "c"}, previewEntries(entries, 4))
+	assert.Equal(t, []string{"bin/", "a", "and 2 more"}, previewEntries(entries, 3))
+}
+
+func TestContainerDirEntry(t *testing.T) {
 	tests := []struct {
-		name  string
-		input string
-		want  string
+		name     string
+		file     container.File
+		wantType fs.FileMode
+		wantMode fs.FileMode
 	}{
 		{
-			name:  "empty input",
-			input: "",
-			want:  "\n",
+			name:     "regular file",
+			file:     container.File{Name: "run.sh", FileMode: 0o755, ModifiedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+			wantType: 0,
+			wantMode: 0o755,
 		},
 		{
-			name: "simple json",
-			input: `{
-  "key": "value"
-}`,
-			want: "\x1b[33m{\x1b[0m\n  \"\x1b[36mkey\x1b[0m\": \x1b[32m\"value\"\x1b[0m\n\x1b[33m}\x1b[0m\n",
+			name:     "directory",
+			file:     container.File{Name: "etc", IsDir: true, FileMode: fs.ModeDir | 0o700},
+			wantType: fs.ModeDir,
+			wantMode: fs.ModeDir | 0o700,
 		},
 		{
-			name: "complex json",
-			input: `{
+			name:     "symlink to a directory",
+			file:     container.File{Name: "bin", IsDir: true, FileMode: fs.ModeSymlink | 0o777},
+			wantType: fs.ModeSymlink,
+			wantMode: fs.ModeSymlink | 0o777,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			entry := containerDirEntry{file: tt.file}
+			assert.Equal(t, tt.wantType, entry.Type())
+			assert.Equal(t, tt.file.IsDir, entry.IsDir())
+
+			info, err := entry.Info()
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantMode, info.Mode())
+			assert.Equal(t, tt.file.ModifiedAt, info.ModTime())
+		})
+	}
+}
+
+func TestFileItemTitle(t *testing.T) {
+	assert.Equal(t, "file.txt", fileItem{file: container.File{Name: "file.txt"}}.Title())
+	assert.Equal(t, "etc/", fileItem{file: container.File{Name: "etc", IsDir: true}}.Title())
+	assert.Equal(t, `evil\x1b[2J\n`, fileItem{file: container.File{Name: "evil\x1b[2J\n"}}.Title())
+}
+
+func TestStatusBarMessage(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), width: 200}
+	m.SetNoColor(true)
+
+	// Names are sanitized where they are inserted, and only once
+	m.message = fmt.Sprintf("Failed to open %s: open C:\\Users\\me\\sou.log: denied", filepicker.SanitizeName("a\\b"))
+	assert.Contains(t, m.statusBar(), `Failed to open a\\b: open C:\Users\me\sou.log: denied`)
+
+	// Control characters left in errors are dropped
+	m.message = "Error: evil\x1b[2J\n"
+	assert.Contains(t, m.statusBar(), "Error: evil[2J")
+	assert.NotContains(t, m.statusBar(), "\x1b[2J")
+}
+
+func TestLayerItemsTimeFormat(t *testing.T) {
+	m := Model{image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:new", Size: 2048, Command: "RUN make", Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{DiffID: "sha256:old", Size: 10, Command: "N/A"},
+	}}}
+	m.SetTimeFormat(filepicker.TimeISO, time.FixedZone("JST", 9*60*60))
+
+	items := m.layerItems()
+	require.Len(t, items, 2)
+	assert.Equal(t, "DiffID: sha256:new  Size: 2.0 KiB (100%)  Created: 2024-01-02T12:04:05+09:00", items[0].(layerItem).Description())
+	assert.Equal(t, "DiffID: sha256:old  Size: 10 B (<1%)", items[1].(layerItem).Description())
+
+	m.SetSizeFormat(filepicker.SizeSI)
+	items = m.layerItems()
+	assert.Equal(t, "DiffID: sha256:new  Size: 2.0 kB (100%)  Created: 2024-01-02T12:04:05+09:00", items[0].(layerItem).Description())
+}
+
+func TestToggleDigest(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LayerMode, image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:remote", Digest: "sha256:blob", Size: 10, Command: "RUN make"},
+		{DiffID: "sha256:local", Size: 10, Command: "N/A"},
+	}}}
+	m.SetTheme(themes[DefaultTheme])
+	m.list = newCustomList(m.layerItems(), 100, 24, m.theme)
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("D")})
+	items := m.list.Items()
+	require.Len(t, items, 2)
+	assert.Equal(t, "Digest: sha256:blob  Size: 10 B (50%)", items[0].(layerItem).Description())
+	assert.Equal(t, "Digest: unknown  Size: 10 B (50%)", items[1].(layerItem).Description())
+
+	// Layers without a digest have nothing to copy
+	m.list.Select(1)
+	cmd := m.copySelectedDigest()
+	assert.NotNil(t, cmd)
+	assert.Contains(t, m.message, "unknown")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("D")})
+	assert.Equal(t, "DiffID: sha256:remote  Size: 10 B (50%)", m.list.Items()[0].(layerItem).Description())
+}
+
+func TestLocalFileName(t *testing.T) {
+	for _, name := range []string{"file.txt", ".bashrc", "a:b"} {
+		if runtime.GOOS == "windows" && name == "a:b" {
+			continue
+		}
+		got, err := localFileName(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, name, got)
+	}
+
+	invalid := []string{"", "..", "a/b"}
+	if runtime.GOOS == "windows" {
+		invalid = append(invalid, `..\evil`, "CON", "a:b")
+	}
+	for _, name := range invalid {
+		_, err := localFileName(name)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestJSONTree(t *testing.T) {
+	input := `{
   "string": "value",
-  "number": 123,
-  "bool": true,
+  "number": 1.5e3,
+  "null": null,
   "object": {},
-  "array": []
-}`,
-			want: "\x1b[33m{\x1b[0m\n  \"\x1b[36mstring\x1b[0m\": \x1b[32m\"value\",\x1b[0m\n  \"\x1b[36mnumber\x1b[0m\": \x1b[34m123,\x1b[0m\n  \"\x1b[36mbool\x1b[0m\": true,\n  \"\x1b[36mobject\x1b[0m\": \x1b[33m{},\x1b[0m\n  \"\x1b[36marray\x1b[0m\": \x1b[33m[]\x1b[0m\n\x1b[33m}\x1b[0m\n",
+  "org.opencontainers.image.source": "a\nb: \"c\" \u003c",
+  "layers": [
+    {"digest": "sha256:0123", "size": 100},
+    {"digest": "sha256:4567", "size": 200}
+  ]
+}`
+	tree, err := newJSONTree([]byte(input), 80, 20, jsonColors{}, false, newKeyMap())
+	require.NoError(t, err)
+	want := `> ▾ {
+      "string": "value",
+      "number": 1.5e3,
+      "null": null,
+      "object": {},
+      "org.opencontainers.image.source": "a\nb: \"c\" \u003c",
+    ▾ "layers": [
+      ▾ {
+          "digest": "sha256:0123",
+          "size": 100
+        },
+      ▾ {
+          "digest": "sha256:4567",
+          "size": 200
+        }
+      ]
+    }`
+	assert.Equal(t, want, strings.TrimRight(tree.View(), "\n"))
+
+	press := func(keys ...string) {
+		for _, k := range keys {
+			msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)}
+			if k == "enter" {
+				msg = tea.KeyMsg{Type: tea.KeyEnter}
+			}
+			tree, _ = tree.Update(msg)
+		}
+	}
+
+	// Paths are written as in jq
+	press("j", "j", "j", "j", "j")
+	assert.Equal(t, `.["org.opencontainers.image.source"]`, tree.Selected().path)
+	assert.Equal(t, "a\nb: \"c\" <", tree.Selected().value())
+	press("j", "j", "j")
+	assert.Equal(t, ".layers[0].digest", tree.Selected().path)
+	assert.Equal(t, "sha256:0123", tree.Selected().value())
+
+	// Objects and arrays are collapsed and expanded
+	press("k", "enter")
+	assert.Equal(t, ".layers[0]", tree.Selected().path)
+	assert.Contains(t, tree.View(), ">     ▸ {…},\n")
+	assert.NotContains(t, tree.View(), "sha256:0123")
+	assert.Equal(t, "{\n  \"digest\": \"sha256:0123\",\n  \"size\": 100\n}", tree.Selected().value())
+	press("enter")
+	assert.Contains(t, tree.View(), "sha256:0123")
+
+	// The closing bracket collapses its object as well
+	press("G", "enter")
+	assert.Equal(t, ".", tree.Selected().path)
+	assert.Equal(t, "> ▸ {…}", strings.TrimRight(tree.View(), "\n"))
+
+	// Invalid JSON is an error
+	for _, input := range []string{``, `{"key": }`, `{"key": [1`, `{} {}`} {
+		_, err := newJSONTree([]byte(input), 80, 20, jsonColors{}, false, newKeyMap())
+		assert.Error(t, err, input)
+	}
+}
+
+func TestJSONTreeLongValues(t *testing.T) {
+	long := strings.Repeat("abcdefghij ", 6)
+	input := `{"Env": ["PATH=/usr/bin", "` + long + `"], "n": 1}`
+	tree, err := newJSONTree([]byte(input), 40, 10, themes[DefaultTheme].jsonColors(), true, newKeyMap())
+	require.NoError(t, err)
+	tree, _ = tree.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("G")})
+	tree, _ = tree.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k")})
+	tree, _ = tree.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k")})
+	tree, _ = tree.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("k")})
+	assert.Equal(t, ".Env[1]", tree.Selected().path)
+
+	// Long values are cut to the width until expanded
+	lines := strings.Split(tree.View(), "\n")
+	assert.Contains(t, lines[3], "...")
+	assert.LessOrEqual(t, lipgloss.Width(lines[3]), 40)
+	assert.Contains(t, lines[3], "\x1b[32m", "the value is colored")
+
+	tree, _ = tree.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	view := tree.View()
+	assert.NotContains(t, view, "...")
+	assert.Contains(t, view, "abcdefghij")
+	for _, line := range strings.Split(view, "\n") {
+		assert.LessOrEqual(t, lipgloss.Width(line), 40, line)
+	}
+	assert.Contains(t, view, `"n"`, "the values below are shown")
+}
+
+func TestCopyJSONPath(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: ConfigMode, width: 80, height: 24, ready: true}
+	m.SetTheme(themes[DefaultTheme])
+	_, _ = m.Update(configMsg{content: []byte(`{"config": {"Env": ["A=1", "B=2"]}}`)})
+	for _, k := range []string{"j", "j", "j", "j"} {
+		_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(k)})
+	}
+	assert.Contains(t, m.statusBar(), ".config.Env[1]")
+
+	copied := map[string]string{}
+	for _, c := range m.chords("y") {
+		if seq := c.binding.Keys()[0]; seq == "yp" || seq == "yv" {
+			msg, ok := c.run(m)().(copyToClipboardMsg)
+			require.True(t, ok)
+			copied[seq] = msg.text
+		}
+	}
+	assert.Equal(t, map[string]string{"yp": ".config.Env[1]", "yv": "B=2"}, copied)
+}
+
+func TestCopyImageID(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	id, err := img.ID()
+	require.NoError(t, err)
+	digest, err := img.ManifestDigest()
+	require.NoError(t, err)
+
+	m := &Model{keys: newKeyMap(), image: img}
+	for _, mode := range []Mode{ManifestMode, ConfigMode, RuntimeMode} {
+		m.mode = mode
+		copied := map[string]copyToClipboardMsg{}
+		for _, c := range m.chords("y") {
+			if seq := c.binding.Keys()[0]; seq == "yi" || seq == "ym" {
+				msg, ok := c.run(m)().(copyToClipboardMsg)
+				require.True(t, ok)
+				copied[seq] = msg
+			}
+		}
+		assert.Equal(t, "image ID", copied["yi"].what)
+		assert.Equal(t, id, copied["yi"].text)
+		assert.Equal(t, "manifest digest", copied["ym"].what)
+		assert.Equal(t, digest, copied["ym"].text)
+	}
+
+	// Images built locally have no manifest digest
+	m.image = &container.Image{Local: true}
+	cmd := m.copyManifestDigest()
+	require.NotNil(t, cmd)
+	assert.Contains(t, m.message, "unknown")
+}
+
+func TestRunQuery(t *testing.T) {
+	input := []byte(`{"history": [{"created_by": "ADD file"}, {"created_by": "CMD [\"sh\"]"}], "size": 12345678901}`)
+	tests := []struct {
+		query   string
+		want    string
+		count   int
+		wantErr string
+	}{
+		{query: ".size", want: `12345678901`, count: 1},
+		{query: ".history[0]", want: `{"created_by":"ADD file"}`, count: 1},
+		{query: ".history[].created_by", want: `["ADD file","CMD [\"sh\"]"]`, count: 2},
+		{query: "empty", want: `[]`, count: 0},
+		{query: ".history[", wantErr: "invalid query"},
+		{query: ".size | ascii_downcase", wantErr: "cannot be"},
+		{query: "range(infinite)", wantErr: "more than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, count, err := runQuery(context.Background(), input, tt.query)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+			assert.Equal(t, tt.count, count)
+		})
+	}
+}
+
+func TestQueryJSON(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: ConfigMode, width: 80, height: 24, ready: true}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	_, _ = m.Update(configMsg{content: []byte(`{"history": [{"created_by": "ADD file"}, {"created_by": "CMD sh"}]}`)})
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")})
+	require.True(t, m.editingQuery)
+	assert.True(t, m.inFilter(), "keys are typed in the query box")
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(".history[].created_by")})
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	_, _ = m.Update(cmd())
+
+	assert.False(t, m.editingQuery)
+	assert.Equal(t, ".history[].created_by", m.query)
+	view := m.View()
+	assert.Contains(t, view, "jq .history[].created_by")
+	assert.Contains(t, view, `"ADD file",`)
+	assert.Contains(t, view, `"CMD sh"`)
+	assert.NotContains(t, view, "created_by\"")
+
+	// Errors keep the query box open
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("/")})
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("[")})
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	_, _ = m.Update(cmd())
+	assert.True(t, m.editingQuery)
+	assert.Contains(t, m.message, "Query failed")
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.False(t, m.editingQuery)
+	assert.Equal(t, ".history[].created_by", m.query)
+
+	// esc shows the whole document again, then leaves the tab
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, ConfigMode, m.mode)
+	assert.Empty(t, m.query)
+	assert.Contains(t, m.View(), `"created_by": "ADD file"`)
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, LayerMode, m.mode)
+}
+
+func TestDescribeError(t *testing.T) {
+	_, badNameErr := name.ParseReference("ghcr.io/knqyf263/SOU")
+	require.Error(t, badNameErr)
+
+	tests := []struct {
+		name          string
+		err           error
+		wantHint      string
+		wantCause     string
+		wantRetryable bool
+	}{
+		{
+			name:          "unauthorized",
+			err:           fmt.Errorf("failed to pull image: %w", container.ErrUnauthorized),
+			wantHint:      "run `docker login ghcr.io`, then r: retry",
+			wantCause:     "authentication",
+			wantRetryable: true,
+		},
+		{
+			name:          "rate limited",
+			err:           container.ErrRateLimited,
+			wantHint:      "rate limited by the registry, wait a moment, then r: retry",
+			wantCause:     "rate limit",
+			wantRetryable: true,
+		},
+		{
+			name:      "not found",
+			err:       container.ErrNotFound,
+			wantHint:  "check the image name and tag",
+			wantCause: "typo",
+		},
+		{
+			name:     "unsupported media type",
+			err:      container.ErrUnsupportedMediaType,
+			wantHint: "not a container image",
+		},
+		{
+			name:      "invalid reference",
+			err:       fmt.Errorf("failed to parse reference: %w", badNameErr),
+			wantHint:  "check the image reference",
+			wantCause: "typo",
+		},
+		{
+			name:          "network",
+			err:           fmt.Errorf("failed to pull image: %w", &net.DNSError{Err: "no such host", Name: "ghcr.io", IsNotFound: true}),
+			wantHint:      "check your network connection and proxy settings, then r: retry",
+			wantCause:     "network",
+			wantRetryable: true,
+		},
+		{
+			name:          "other",
+			err:           assert.AnError,
+			wantHint:      "r: retry",
+			wantCause:     "unknown",
+			wantRetryable: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got := string(colorizeJSON([]byte(tt.input)))
-			assert.Equal(t, tt.want, got)
+			hint, retryable := describeError(tt.err, "ghcr.io/knqyf263/sou:latest")
+			assert.Equal(t, tt.wantHint, hint)
+			assert.Equal(t, tt.wantRetryable, retryable)
+
+			model := &Model{
+				mode: PullingMode,
+				ref:  "ghcr.io/knqyf263/sou:latest",
+				list: list.New([]list.Item{}, list.NewDefaultDelegate(), 0, 0),
+				keys: newKeyMap(),
+			}
+			updatedModel, _ := model.Update(errMsg{err: tt.err})
+			m := updatedModel.(*Model)
+			assert.Equal(t, ErrorMode, m.mode)
+			assert.Contains(t, m.errorView(), tt.wantHint)
+			assert.Contains(t, m.errorView(), tt.wantCause)
+			assert.Equal(t, tt.wantRetryable, m.retry != nil)
+		})
+	}
+}
+
+func TestModelViewStatusBar(t *testing.T) {
+	m := Model{ref: "alpine:3.20", tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123456789abcdef", Command: "RUN apk add curl"},
+		{DiffID: "sha256:fedcba9876543210", Command: "ADD rootfs.tar.gz /"},
+	}}
+	m.list = newCustomList(m.layerItems(), 100, 24, m.theme)
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	m.list.Select(1)
+	m.message = "Diff ID copied to clipboard"
+
+	view := m.View()
+	lines := strings.Split(view, "\n")
+	assert.Len(t, lines, m.height)
+	assert.Equal(t, "alpine:3.20 │ layer 2/2 sha256:fedcba987654 │ 2/2  Diff ID copied to clipboard", lines[len(lines)-1])
+
+	// The status bar stays at the bottom when the body doesn't fit
+	m.showHelp = true
+	m.height = 10
+	lines = strings.Split(m.View(), "\n")
+	assert.Len(t, lines, m.height)
+	assert.Contains(t, lines[0], "Layers")
+	assert.Contains(t, lines[len(lines)-1], "alpine:3.20")
+
+	// Files
+	m.showHelp = false
+	m.height = 30
+	m.mode = FileMode
+	m.filepicker.SetHeight(m.height - 6)
+	m.currentLayer = &m.image.Layers[0]
+	m.message = ""
+	lines = strings.Split(m.View(), "\n")
+	assert.Equal(t, "alpine:3.20 │ layer 1/2 sha256:0123456789ab │ /", lines[len(lines)-1])
+}
+
+func TestModelHelpOverlay(t *testing.T) {
+	m := Model{ref: "alpine:3.20", keys: newKeyMap(), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = &container.Image{Layers: []container.Layer{{DiffID: "sha256:0123456789abcdef", Command: "RUN apk add curl"}}}
+	m.list = newCustomList(m.layerItems(), 100, 34, m.theme)
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 40
+
+	press := func(k tea.KeyMsg) {
+		model, _ := m.Update(k)
+		m = *model.(*Model)
+	}
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("?")})
+	require.True(t, m.showHelp)
+
+	view := m.View()
+	assert.Len(t, strings.Split(view, "\n"), m.height)
+	assert.Contains(t, view, "yy  ")
+	assert.Contains(t, view, "copy diff ID")
+	assert.NotContains(t, view, "RUN apk add curl", "the help hides the content")
+
+	// Keys other than the help and esc are ignored while the help is shown
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("l")})
+	assert.True(t, m.showHelp)
+	assert.Equal(t, LayerMode, m.mode)
+
+	press(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.False(t, m.showHelp)
+	assert.Contains(t, m.View(), "RUN apk add curl")
+
+	// Each mode has its own bindings
+	m.mode = ManifestMode
+	m.viewport = viewport.New(96, 34)
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("?")})
+	assert.Contains(t, m.View(), "export JSON")
+	assert.NotContains(t, m.View(), "copy diff ID")
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("?")})
+	assert.False(t, m.showHelp)
+}
+
+func TestModelQuitConfirmation(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: FileMode}
+	press := func(s string) tea.Cmd {
+		model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)})
+		m = model.(*Model)
+		return cmd
+	}
+	isQuit := func(cmd tea.Cmd) bool {
+		if cmd == nil {
+			return false
+		}
+		_, ok := cmd().(tea.QuitMsg)
+		return ok
+	}
+
+	ctx := m.startExport()
+	assert.False(t, isQuit(press("q")))
+	assert.True(t, m.confirmQuit)
+	assert.Contains(t, m.message, "Export in progress")
+
+	// Any other key continues
+	assert.False(t, isQuit(press("n")))
+	assert.False(t, m.confirmQuit)
+	assert.Empty(t, m.message)
+	require.NoError(t, ctx.Err())
+
+	// Confirming cancels the export and quits once it is done
+	press("q")
+	assert.False(t, isQuit(press("y")))
+	assert.True(t, m.quitting)
+	assert.Error(t, ctx.Err())
+	model, cmd := m.Update(exportFileMsg{err: context.Canceled})
+	m = model.(*Model)
+	assert.True(t, isQuit(cmd))
+
+	// Nothing to wait for
+	m = &Model{keys: newKeyMap(), mode: FileMode}
+	assert.True(t, isQuit(press("q")))
+}
+
+func TestWriteFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "file.txt")
+
+	require.NoError(t, writeFile(context.Background(), name, []byte("hello")))
+	got, err := os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	// Canceled exports leave the existing file alone and no partial file
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	require.ErrorIs(t, writeFile(ctx, name, []byte("partial")), context.Canceled)
+	got, err = os.ReadFile(name)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(got))
+
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestErrorEditReference(t *testing.T) {
+	m := &Model{ref: "alpine:3.2O", keys: newKeyMap(), width: 80, height: 30}
+	press := func(k tea.KeyMsg) {
+		model, _ := m.Update(k)
+		m = model.(*Model)
+	}
+	model, _ := m.Update(errMsg{err: fmt.Errorf("failed to pull image: %w", container.ErrNotFound)})
+	m = model.(*Model)
+	require.Equal(t, ErrorMode, m.mode)
+	assert.Contains(t, m.errorView(), "Failed to load alpine:3.2O")
+	assert.NotContains(t, m.errorHelp(), "retry")
+
+	// While editing, q is typed instead of quitting
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	require.True(t, m.editingRef)
+	press(tea.KeyMsg{Type: tea.KeyBackspace})
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("q")})
+	assert.Contains(t, m.errorView(), "alpine:3.2q")
+
+	// esc keeps the reference
+	press(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.False(t, m.editingRef)
+	assert.Equal(t, "alpine:3.2O", m.ref)
+
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("e")})
+	press(tea.KeyMsg{Type: tea.KeyBackspace})
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("0")})
+	press(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, "alpine:3.20", m.ref)
+	assert.Equal(t, PullingMode, m.mode)
+	assert.Nil(t, m.err)
+	m.cancel()
+}
+
+func TestModelChordHints(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = &container.Image{Layers: []container.Layer{{DiffID: "sha256:0123456789abcdef", Command: "RUN apk add curl"}}}
+	m.list = newCustomList(m.layerItems(), 100, 24, m.theme)
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	press := func(s string) tea.Cmd {
+		model, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)})
+		m = model.(*Model)
+		return cmd
+	}
+
+	// The completions are shown after the first key
+	press("y")
+	assert.Equal(t, "y", m.pendingKey)
+	assert.Contains(t, m.View(), "y  copy diff ID")
+	assert.NotContains(t, m.View(), "copy path")
+	assert.Contains(t, m.View(), "c  copy command")
+	assert.NotNil(t, press("y"))
+	assert.Empty(t, m.pendingKey)
+	assert.NotContains(t, m.View(), "copy diff ID")
+
+	// They disappear after the timeout, unless another sequence started
+	press("y")
+	model, _ := m.Update(chordTimeoutMsg{seq: m.chordSeq - 1})
+	m = model.(*Model)
+	assert.Equal(t, "y", m.pendingKey)
+	model, _ = m.Update(chordTimeoutMsg{seq: m.chordSeq})
+	m = model.(*Model)
+	assert.Empty(t, m.pendingKey)
+
+	// Files have more completions
+	m.mode = FileMode
+	m.filepicker.SetHeight(m.height - 6)
+	m.currentLayer = &m.image.Layers[0]
+	press("y")
+	view := m.View()
+	assert.Contains(t, view, "y  copy diff ID")
+	assert.Contains(t, view, "p  copy path")
+	model, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = model.(*Model)
+	assert.Empty(t, m.pendingKey)
+	assert.Equal(t, FileMode, m.mode)
+}
+
+func TestModelViewAccessible(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), spinner: spinner.New(spinner.WithSpinner(spinner.Points)), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetAccessible(true)
+	assert.True(t, m.ascii)
+	assert.True(t, m.noColor)
+	m.width, m.height, m.ready, m.mode = 100, 30, true, PullingMode
+
+	// No animations
+	assert.Contains(t, m.View(), "Loading image...")
+	_, cmd := m.Update(m.spinner.Tick())
+	assert.Nil(t, cmd)
+
+	// The selection and active tab are marked with characters
+	model, _ := m.Update(imageLoadedMsg{image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123456789abcdef", Command: "RUN apk add curl"},
+		{DiffID: "sha256:fedcba9876543210", Command: "ADD rootfs.tar.gz /"},
+	}}})
+	m = model.(*Model)
+	view := m.View()
+	assert.Contains(t, view, "[Layers]")
+	assert.Contains(t, view, "> RUN apk add curl")
+	assert.Contains(t, view, "  ADD rootfs.tar.gz /")
+	assert.Contains(t, view, "Image loaded with 2 layers")
+
+	// The help has no box
+	m.showHelp = true
+	view = m.View()
+	assert.Contains(t, view, "Navigation")
+	assert.NotContains(t, view, "+-")
+	assert.NotContains(t, view, "|  ")
+
+	// Progress is described in steps
+	m.showHelp = false
+	m.mode = LoadingMode
+	m.progress = container.Progress{Stage: container.StageDownloading, Complete: 45, Total: 100}
+	assert.Contains(t, m.View(), "Downloading layer... "+percentView(m.progress.Fraction()))
+	assert.Equal(t, "40%", percentView(0.45))
+}
+
+func TestModelViewPullBlobs(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), spinner: spinner.New(spinner.WithSpinner(spinner.Points))}
+	m.SetTheme(themes[DefaultTheme])
+	m.width, m.height, m.ready, m.mode = 100, 30, true, PullingMode
+	// The downloading blob has been sampled a second ago with nothing transferred
+	m.blobRates = map[string]*transferRate{
+		"sha256:00112233445566778899": {lastTime: time.Now().Add(-time.Second)},
+		"sha256:fedcba9876543210fedc": {lastTime: time.Now().Add(-time.Second)},
+	}
+
+	model, _ := m.Update(progressMsg{blobs: []container.Progress{
+		{Blob: "sha256:0123456789abcdef0123", Exists: true},
+		{Blob: "sha256:fedcba9876543210fedc", Complete: 2048, Total: 2048},
+		{Blob: "sha256:00112233445566778899", Complete: 512, Total: 2048},
+		{Blob: "0123456789abcdef0123456789abcdef/layer.tar"},
+	}})
+	m = model.(*Model)
+	view := m.View()
+	assert.Contains(t, view, "Pulling image from registry...")
+	assert.Regexp(t, `sha256:0123456789ab\s+Exists`, view)
+	assert.Regexp(t, `sha256:fedcba987654\s+Done\s+2\.0 KiB`, view)
+	assert.Regexp(t, `sha256:001122334455\s+Downloading 25%\s+512 B / 2\.0 KiB\s+\d+ B/s\s+ETA \d+s`, view)
+	assert.Regexp(t, `0123456789ab/layer\.tar\s+Downloading`, view)
+	// The rates of the finished blobs are dropped
+	assert.NotContains(t, m.blobRates, "sha256:fedcba9876543210fedc")
+	assert.Contains(t, m.blobRates, "sha256:00112233445566778899")
+}
+
+func TestFormatCommand(t *testing.T) {
+	tests := []struct {
+		name      string
+		createdBy string
+		want      string
+	}{
+		{
+			name:      "buildkit run",
+			createdBy: "RUN /bin/sh -c apk add --no-cache curl && rm -rf /tmp/* # buildkit",
+			want:      "RUN apk add --no-cache curl \\\n    && rm -rf /tmp/*",
+		},
+		{
+			name:      "build arguments",
+			createdBy: "RUN |2 VERSION=1.2 ARCH=amd64 /bin/sh -c make # buildkit",
+			want:      "ARG VERSION=1.2\nARG ARCH=amd64\nRUN make",
+		},
+		{
+			name:      "heredoc",
+			createdBy: "RUN /bin/sh -c <<EOF\napk add curl && true\nEOF # buildkit",
+			want:      "RUN <<EOF\napk add curl && true\nEOF",
+		},
+		{
+			name:      "legacy instruction",
+			createdBy: `/bin/sh -c #(nop)  CMD ["sh"]`,
+			want:      `CMD ["sh"]`,
+		},
+		{
+			name:      "legacy run",
+			createdBy: "/bin/sh -c apt-get update",
+			want:      "RUN apt-get update",
+		},
+		{
+			name:      "buildkit instruction",
+			createdBy: `ENTRYPOINT ["/docker-entrypoint.sh"]`,
+			want:      `ENTRYPOINT ["/docker-entrypoint.sh"]`,
+		},
+		{
+			name:      "control characters",
+			createdBy: "RUN /bin/sh -c echo \x1b[31m\tred",
+			want:      "RUN echo �[31m red",
+		},
+		{
+			name:      "unknown",
+			createdBy: "N/A",
+			want:      "N/A",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, formatCommand(tt.createdBy))
 		})
 	}
 }
+
+func TestColorizeCommand(t *testing.T) {
+	colors := jsonColors{key: "<k>", str: "<s>", number: "<n>", literal: "<l>", delim: "<d>", reset: "</>"}
+	got := colorizeCommand("RUN echo \"$HOME\" 'a b' ${USER} && ls | wc # count", colors)
+	assert.Equal(t, "<k>RUN</> echo <s>\"$HOME\"</> <s>'a b'</> <n>${USER}</> <d>&&</> ls <d>|</> wc <l># count</>", got)
+
+	// Nothing is added without colors
+	command := "RUN echo $HOME && ls"
+	assert.Equal(t, command, colorizeCommand(command, jsonColors{}))
+}
+
+func TestCommandMode(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LayerMode, width: 40, height: 24, ready: true, image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123456789abcdef", Command: "RUN /bin/sh -c apk add curl && rm -rf /var/cache/apk/* /tmp/* /root/.cache # buildkit"},
+	}}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.list = newCustomList(m.layerItems(), 36, 18, m.theme)
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	assert.Equal(t, CommandMode, m.mode)
+	view := m.View()
+	assert.Contains(t, view, "RUN apk add curl \\")
+	assert.Contains(t, view, "    && rm -rf /var/cache/apk/*")
+	assert.NotContains(t, view, "# buildkit")
+	assert.Contains(t, view, "layer 1/1")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, LayerMode, m.mode)
+}
+
+func TestBlobMode(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LayerMode, width: 80, height: 24, ready: true, image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123", Digest: "sha256:4567", Size: 8, Command: "N/A", MediaType: "application/vnd.wasm.content.layer.v1+wasm", Title: "app.wasm"},
+	}}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.list = newCustomList(m.layerItems(), 76, 18, m.theme)
+	assert.Contains(t, m.list.SelectedItem().(layerItem).Description(), "Type: application/vnd.wasm.content.layer.v1+wasm")
+
+	// The layer isn't indexed
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, BlobMode, m.mode)
+	assert.NotNil(t, cmd)
+	view := m.View()
+	assert.Contains(t, view, "Type:   application/vnd.wasm.content.layer.v1+wasm")
+	assert.Contains(t, view, "Title:  app.wasm")
+	assert.Contains(t, view, "Digest: sha256:4567")
+	assert.Contains(t, view, "Reading the blob...")
+	assert.Contains(t, view, "layer 1/1")
+
+	// The head of another layer is ignored
+	_, _ = m.Update(blobHeadMsg{diffID: "sha256:89ab", head: []byte("other")})
+	assert.Contains(t, m.View(), "Reading the blob...")
+	_, _ = m.Update(blobHeadMsg{diffID: "sha256:0123", head: []byte("\x00asm\x01\x00\x00\x00")})
+	assert.Contains(t, m.View(), "00 61 73 6d 01 00 00 00")
+	assert.Contains(t, m.View(), ".asm....")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, LayerMode, m.mode)
+	assert.Nil(t, m.blob)
+}
+
+func TestBlobFileName(t *testing.T) {
+	tests := []struct {
+		layer container.Layer
+		want  string
+	}{
+		{layer: container.Layer{DiffID: "sha256:0123", Digest: "sha256:4567", Title: "app.wasm"}, want: "app.wasm"},
+		{layer: container.Layer{DiffID: "sha256:0123", Digest: "sha256:4567", Title: "../app.wasm"}, want: "sha256-4567"},
+		{layer: container.Layer{DiffID: "sha256:0123", Digest: "sha256:4567"}, want: "sha256-4567"},
+		{layer: container.Layer{DiffID: "sha256:0123"}, want: "sha256-0123"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, blobFileName(&tt.layer))
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LayerMode, image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123456789abcdef", Command: "RUN /bin/sh -c apk add curl && rm -rf /tmp/* # buildkit"},
+	}}}
+	m.SetTheme(themes[DefaultTheme])
+	m.list = newCustomList(m.layerItems(), 100, 24, m.theme)
+
+	// The command is copied as recorded in the config
+	for _, mode := range []Mode{LayerMode, CommandMode} {
+		m.mode = mode
+		m.command = m.image.Layers[0].Command
+		c := m.chords("y")
+		require.NotEmpty(t, c)
+		var cmd tea.Cmd
+		for _, chord := range c {
+			if chord.binding.Keys()[0] == "yc" {
+				cmd = chord.run(m)
+			}
+		}
+		require.NotNil(t, cmd, "mode %d", mode)
+		msg, ok := cmd().(copyToClipboardMsg)
+		require.True(t, ok)
+		assert.Equal(t, "command", msg.what)
+		assert.Equal(t, m.image.Layers[0].Command, msg.text)
+	}
+}
+
+func TestParseSizeFilter(t *testing.T) {
+	tests := []struct {
+		term   string
+		want   sizeFilter
+		wantOK bool
+	}{
+		{term: ">50MB", want: sizeFilter{op: ">", size: 50_000_000}, wantOK: true},
+		{term: ">50MiB", want: sizeFilter{op: ">", size: 50 << 20}, wantOK: true},
+		{term: "<= 1.5 GB", want: sizeFilter{op: "<=", size: 1_500_000_000}, wantOK: true},
+		{term: "<= 1.5 GiB", want: sizeFilter{op: "<=", size: 3 << 29}, wantOK: true},
+		{term: ">100k", want: sizeFilter{op: ">", size: 100_000}, wantOK: true},
+		{term: ">100ki", want: sizeFilter{op: ">", size: 100 << 10}, wantOK: true},
+		{term: ">=10", want: sizeFilter{op: ">=", size: 10}, wantOK: true},
+		{term: "<2b", want: sizeFilter{op: "<", size: 2}, wantOK: true},
+		{term: "50MB"},
+		{term: ">MB"},
+		{term: ">50XB"},
+		{term: ">50iB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.term, func(t *testing.T) {
+			got, ok := parseSizeFilter(tt.term)
+			assert.Equal(t, tt.wantOK, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFilterLayers(t *testing.T) {
+	layers := []container.Layer{
+		{DiffID: "sha256:big", Size: 80 << 20, Command: "RUN apt-get install"},
+		{DiffID: "sha256:small", Size: 2 << 10, Command: "COPY app /app"},
+		{DiffID: "sha256:medium", Size: 50 << 20, Command: "RUN make"},
+	}
+	m := &Model{image: &container.Image{Layers: layers}}
+	var targets []string
+	for _, item := range m.layerItems() {
+		targets = append(targets, item.FilterValue())
+	}
+	indexes := func(ranks []list.Rank) []int {
+		var i []int
+		for _, r := range ranks {
+			i = append(i, r.Index)
+		}
+		return i
+	}
+
+	filter := filterLayers(m.layerItems())
+	assert.Equal(t, []int{0}, indexes(filter(">50MiB", targets)))
+	assert.Equal(t, []int{0, 2}, indexes(filter(">=50MiB", targets)))
+	assert.Equal(t, []int{0, 2}, indexes(filter(">50MB", targets)))
+	assert.Equal(t, []int{1}, indexes(filter("<1m", targets)))
+	assert.Empty(t, filter(">1GB", targets))
+
+	// Other terms are matched against the commands
+	assert.Equal(t, []int{1}, indexes(filter("COPY", targets)))
+}
+
+func TestMarkLayers(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), mode: LayerMode, width: 100, height: 30, ready: true, image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123456789abcdef", Command: "COPY app /app"},
+		{DiffID: "sha256:1123456789abcdef", Command: "RUN make"},
+		{DiffID: "sha256:2123456789abcdef", Command: "ADD rootfs.tar.gz /"},
+	}}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+	press := func(msg tea.KeyMsg) {
+		model, _ := m.Update(msg)
+		m = model.(*Model)
+	}
+	space := tea.KeyMsg{Type: tea.KeySpace, Runes: []rune(" ")}
+
+	// Layers are marked and unmarked with space
+	press(space)
+	press(tea.KeyMsg{Type: tea.KeyDown})
+	press(space)
+	press(space)
+	press(tea.KeyMsg{Type: tea.KeyDown})
+	press(space)
+	view := m.View()
+	assert.Contains(t, view, "✓ COPY app /app")
+	assert.Contains(t, view, "  RUN make")
+	assert.Contains(t, view, "✓ ADD rootfs.tar.gz /")
+	assert.Contains(t, view, "2 marked")
+
+	// Enter opens the marked layers together, from the newest to the oldest
+	press(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, m.loadingLayer)
+	defer m.cancel()
+	assert.Equal(t, LoadingMode, m.mode)
+	assert.Equal(t, []string{"sha256:0123456789abcdef", "sha256:2123456789abcdef"}, m.loadingLayer.MergedDiffIDs())
+	assert.Contains(t, m.View(), "layers 1+3")
+}
+
+func TestToggleHistory(t *testing.T) {
+	m := &Model{ref: "app:latest", keys: newKeyMap(), mode: LayerMode, width: 100, height: 30, ready: true, image: &container.Image{
+		Layers: []container.Layer{
+			{DiffID: "sha256:0123456789abcdef", Size: 2048, Command: "COPY app /app"},
+			{DiffID: "sha256:1123456789abcdef", Size: 4096, Command: "ADD rootfs.tar.gz /"},
+		},
+		History: []container.History{
+			{Command: `CMD ["app"]`, Layer: -1},
+			{Command: "COPY app /app", Layer: 0},
+			{Command: "ENV PATH=/app/bin", Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), Layer: -1},
+			{Command: "ADD rootfs.tar.gz /", Layer: 1},
+		},
+	}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.SetTimeFormat(filepicker.TimeISO, time.UTC)
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+	press := func(msg tea.KeyMsg) {
+		model, _ := m.Update(msg)
+		m = model.(*Model)
+	}
+
+	assert.NotContains(t, m.View(), "ENV PATH")
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("H")})
+	items := m.list.Items()
+	require.Len(t, items, 4)
+	assert.Equal(t, historyItem{command: `CMD ["app"]`}, items[0])
+	assert.Equal(t, "No layer  Created: 2024-01-02T03:04:05Z", items[2].(historyItem).Description())
+	assert.Contains(t, m.View(), "ENV PATH=/app/bin")
+
+	// Build steps without a layer can't be opened, but their command can
+	press(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, LayerMode, m.mode)
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("c")})
+	assert.Equal(t, CommandMode, m.mode)
+	assert.Equal(t, `CMD ["app"]`, m.command)
+	press(tea.KeyMsg{Type: tea.KeyEsc})
+
+	// They have no size
+	var targets []string
+	for _, item := range items {
+		targets = append(targets, item.FilterValue())
+	}
+	assert.Len(t, m.list.Filter(">=0", targets), 2)
+
+	press(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("H")})
+	assert.Len(t, m.list.Items(), 2)
+}
+
+func TestFormatShare(t *testing.T) {
+	assert.Equal(t, "62%", formatShare(62, 100))
+	assert.Equal(t, "63%", formatShare(625, 1000))
+	assert.Equal(t, "100%", formatShare(999, 1000))
+	assert.Equal(t, "<1%", formatShare(1, 1000))
+	assert.Equal(t, "0%", formatShare(0, 1000))
+}
+
+func TestFilterMatches(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), mode: FileMode, width: 100, height: 30, ready: true}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.filepicker = filepicker.New(fstest.MapFS{
+		"file1.txt": &fstest.MapFile{Data: []byte("1")},
+		"file2.txt": &fstest.MapFile{Data: []byte("2")},
+		"other":     &fstest.MapFile{Data: []byte("3")},
+	})
+	m.filepicker.SetHeight(m.height - 6)
+	m.filepicker, _ = m.filepicker.Update(m.filepicker.Init()())
+	press := func(s string) {
+		model, _ := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)})
+		m = model.(*Model)
+	}
+
+	assert.NotContains(t, m.View(), "matches")
+	for _, s := range []string{"/", "f", "i", "l", "e"} {
+		press(s)
+	}
+	assert.Contains(t, m.View(), "2/3 matches")
+
+	// Matches are highlighted in the titles of layers
+	item := layerItem{diffID: "sha256:0123", command: "RUN make", marked: true}
+	assert.True(t, strings.HasPrefix(item.FilterValue(), item.Title()))
+}
+
+func TestLabelsMode(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LabelsMode, activeTab: 4, width: 80, height: 24, ready: true, image: &container.Image{}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.tabs = []string{"📦 Layers", "📄 Manifest", "⚙️  Config", "🚀 Runtime", "🏷️  Labels"}
+
+	_, _ = m.Update(labelsMsg{labels: map[string]string{
+		"org.opencontainers.image.source":  "https://github.com/knqyf263/sou",
+		"org.opencontainers.image.version": "1.0.0",
+		"maintainer":                       "knqyf263",
+	}})
+	view := m.View()
+	assert.Contains(t, view, "maintainer")
+	assert.Contains(t, view, "https://github.com/knqyf263/sou")
+	assert.Contains(t, view, "1/3")
+
+	// Labels are sorted by key
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	var cmd tea.Cmd
+	for _, c := range m.chords("y") {
+		if c.binding.Keys()[0] == "yl" {
+			cmd = c.run(m)
+		}
+	}
+	require.NotNil(t, cmd)
+	msg, ok := cmd().(copyToClipboardMsg)
+	require.True(t, ok)
+	assert.Equal(t, "org.opencontainers.image.source=https://github.com/knqyf263/sou", msg.text)
+
+	// Both keys and values are searched
+	var run func(tea.Cmd)
+	run = func(cmd tea.Cmd) {
+		if cmd == nil {
+			return
+		}
+		switch msg := cmd().(type) {
+		case tea.BatchMsg:
+			for _, c := range msg {
+				run(c)
+			}
+		case list.FilterMatchesMsg:
+			_, _ = m.Update(msg)
+		}
+	}
+	for _, s := range []string{"/", "1", ".", "0"} {
+		_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)})
+	}
+	run(cmd)
+	assert.Contains(t, m.View(), "1/3 matches")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, LayerMode, m.mode)
+	assert.Equal(t, 0, m.activeTab)
+}
+
+func TestLabelsModeNoLabels(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LabelsMode, width: 80, height: 24, ready: true, image: &container.Image{}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	_, _ = m.Update(labelsMsg{})
+	assert.Contains(t, m.View(), "The image has no labels")
+}
+
+func TestFormatRuntime(t *testing.T) {
+	r := &container.Runtime{
+		Entrypoint:   []string{"/docker-entrypoint.sh"},
+		Cmd:          []string{"nginx", "-g", "daemon off;"},
+		Env:          []string{"PATH=/usr/local/bin:/usr/bin", "NGINX_VERSION=1.27.0"},
+		ExposedPorts: []string{"80/tcp"},
+		Healthcheck: &container.Healthcheck{
+			Test:     []string{"CMD-SHELL", "curl -f http://localhost/ || exit 1"},
+			Interval: 30 * time.Second,
+			Retries:  3,
+		},
+	}
+	want := `Entrypoint   /docker-entrypoint.sh
+Command      nginx -g 'daemon off;'
+Runs         /docker-entrypoint.sh nginx -g 'daemon off;'
+User         root (default)
+Working dir  / (default)
+Exposed      80/tcp
+Volumes      none
+Stop signal  SIGTERM (default)
+Healthcheck  curl -f http://localhost/ || exit 1
+             every 30s, 3 retries
+Environment  PATH=/usr/local/bin:/usr/bin
+             NGINX_VERSION=1.27.0`
+	assert.Equal(t, want, formatRuntime(r, jsonColors{}))
+
+	r = &container.Runtime{
+		Cmd:         []string{"sh", "-c", "echo 'hi'"},
+		User:        "1000:1000",
+		Healthcheck: &container.Healthcheck{Test: []string{"NONE"}},
+	}
+	got := formatRuntime(r, jsonColors{})
+	assert.Contains(t, got, `Command      sh -c 'echo '\''hi'\'''`)
+	assert.NotContains(t, got, "Runs")
+	assert.Contains(t, got, "User         1000:1000\n")
+	assert.Contains(t, got, "Healthcheck  disabled\n")
+	assert.Contains(t, got, "Environment  none")
+}
+
+func TestAnnotationsMode(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: AnnotationsMode, width: 100, height: 24, ready: true, image: &container.Image{}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	_, _ = m.Update(annotationsMsg{annotations: []container.Annotation{
+		{Source: "index", Key: "org.opencontainers.image.created", Value: "2024-01-02T03:04:05Z"},
+		{Source: "manifest", Key: "org.opencontainers.image.source", Value: "https://github.com/knqyf263/sou"},
+	}})
+	view := m.View()
+	assert.Contains(t, view, "index     org.opencontainers.image.created  2024-01-02T03:04:05Z")
+	assert.Contains(t, view, "manifest  org.opencontainers.image.source   https://github.com/knqyf263/sou")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	var cmd tea.Cmd
+	for _, c := range m.chords("y") {
+		if c.binding.Keys()[0] == "yv" {
+			cmd = c.run(m)
+		}
+	}
+	require.NotNil(t, cmd)
+	msg, ok := cmd().(copyToClipboardMsg)
+	require.True(t, ok)
+	assert.Equal(t, "annotation value", msg.what)
+	assert.Equal(t, "https://github.com/knqyf263/sou", msg.text)
+
+	_, _ = m.Update(annotationsMsg{})
+	assert.Contains(t, m.View(), "The image has no annotations")
+}
+
+func TestCopyPullCommand(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	digest, err := img.ManifestDigest()
+	require.NoError(t, err)
+
+	m := &Model{keys: newKeyMap(), mode: LayerMode, image: img}
+	tests := []struct {
+		ref  string
+		want string
+	}{
+		{ref: "alpine", want: "alpine@" + digest},
+		{ref: "alpine:3.20", want: "alpine@" + digest},
+		{ref: "localhost:5000/app:v1", want: "localhost:5000/app@" + digest},
+		{ref: "alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef", want: "alpine@" + digest},
+	}
+	for _, tt := range tests {
+		m.ref = tt.ref
+		got, err := m.pinnedReference()
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, tt.ref)
+	}
+
+	m.ref = "alpine:3.20"
+	copied := map[string]string{}
+	for _, c := range m.chords("y") {
+		if seq := c.binding.Keys()[0]; seq == "yP" || seq == "yR" {
+			msg, ok := c.run(m)().(copyToClipboardMsg)
+			require.True(t, ok)
+			copied[seq] = msg.text
+		}
+	}
+	assert.Equal(t, map[string]string{
+		"yP": "docker pull alpine@" + digest,
+		"yR": "docker run -it --rm alpine@" + digest + " sh",
+	}, copied)
+
+	// Images built locally can't be pinned
+	m.image = &container.Image{Local: true}
+	_, err = m.pinnedReference()
+	assert.Error(t, err)
+}
+
+func TestLogView(t *testing.T) {
+	m := &Model{keys: newKeyMap(), mode: LayerMode, width: 60, height: 24, ready: true, image: &container.Image{Layers: []container.Layer{
+		{DiffID: "sha256:0123456789abcdef"},
+	}}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.list = newCustomList(m.layerItems(), 56, 18, m.theme)
+
+	// Logging is off
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyF12})
+	require.NotNil(t, cmd)
+	assert.Equal(t, LayerMode, m.mode)
+	assert.Contains(t, m.message, "Logging is off")
+
+	path := filepath.Join(t.TempDir(), "debug.log")
+	var lines []string
+	for i := range 100 {
+		lines = append(lines, fmt.Sprintf("level=WARN msg=\"line %d\"", i))
+	}
+	require.NoError(t, os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600))
+	m.SetLogFile(path)
+
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyF12})
+	require.NotNil(t, cmd)
+	_, _ = m.Update(cmd())
+	assert.Equal(t, LogMode, m.mode)
+	view := m.View()
+	assert.Contains(t, view, `msg="line 99"`)
+	assert.NotContains(t, view, `msg="line 0"`)
+	assert.Contains(t, m.statusPath(), "debug.log")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, LayerMode, m.mode)
+
+	// Only the end of a long log is read, from the start of a line
+	content, err := readTail(path, 30)
+	require.NoError(t, err)
+	assert.Equal(t, "level=WARN msg=\"line 99\"\n", string(content))
+}
+
+func TestEventWriter(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Model{keys: newKeyMap(), mode: FileMode, ref: "alpine:3.20"}
+	m.SetEventWriter(&buf)
+
+	// The progress of each blob and layer stage is written once
+	reporter := newProgressReporter()
+	reporter.events = m.events
+	for _, p := range []container.Progress{
+		{Stage: container.StageResolving, Blob: "sha256:config", Complete: 10, Total: 100},
+		{Stage: container.StageResolving, Blob: "sha256:config", Complete: 100, Total: 100},
+		{Stage: container.StageResolving, Blob: "sha256:config", Complete: 100, Total: 100},
+		{Stage: container.StageDownloading, Layer: "sha256:layer", Complete: 50, Total: 200},
+		{Stage: container.StageIndexing, Layer: "sha256:layer", Total: 300},
+		{Stage: container.StageIndexing, Layer: "sha256:layer", Complete: 100, Total: 300},
+		{Stage: container.StageDone, Layer: "sha256:layer"},
+	} {
+		reporter.report(p)
+	}
+	_, _ = m.Update(exportFileMsg{path: "/tmp/passwd"})
+	_, _ = m.Update(exportFileMsg{err: errors.New("disk full")})
+
+	var events []event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e event
+		require.NoError(t, dec.Decode(&e))
+		assert.False(t, e.Time.IsZero())
+		e.Time = time.Time{}
+		events = append(events, e)
+	}
+	assert.Equal(t, []event{
+		{Event: eventBlobFetched, Blob: "sha256:config", Bytes: 100},
+		{Event: eventLayerFetched, Layer: "sha256:layer", Bytes: 300},
+		{Event: eventIndexingDone, Layer: "sha256:layer"},
+		{Event: eventExportDone, Path: "/tmp/passwd"},
+		{Event: eventExportFailed, Error: "disk full"},
+	}, events)
+
+	// Without a writer, nothing is written
+	var none *EventWriter
+	none.emit(event{Event: eventPullStarted})
+	none.Progress(container.Progress{Stage: container.StageDone, Layer: "sha256:layer"})
+	none.PullFailed("alpine:3.20", errors.New("not found"))
+	none.ExportFinished("rootfs.tar")
+}
+
+func TestEventWriterCommands(t *testing.T) {
+	// The commands without the UI write the pull and the export
+	var buf bytes.Buffer
+	events := NewEventWriter(&buf)
+	events.PullStarted("alpine:3.20")
+	events.PullFinished("alpine:3.20", 3)
+	events.ExportFinished("rootfs.tar")
+	events.PullFailed("alpine:3.21", errors.New("not found"))
+	events.ExportFailed(errors.New("not found"))
+
+	var got []event
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var e event
+		require.NoError(t, dec.Decode(&e))
+		e.Time = time.Time{}
+		got = append(got, e)
+	}
+	assert.Equal(t, []event{
+		{Event: eventPullStarted, Image: "alpine:3.20"},
+		{Event: eventPullFinished, Image: "alpine:3.20", Layers: 3},
+		{Event: eventExportDone, Path: "rootfs.tar"},
+		{Event: eventPullFailed, Image: "alpine:3.21", Error: "not found"},
+		{Event: eventExportFailed, Error: "not found"},
+	}, got)
+}
+
+func TestShowHidden(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), image: img, showHidden: true}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, LoadingMode, 100, 30
+	openLayer := func() {
+		m.mode = LoadingMode
+		m.pendingLayer = layer
+		_, _ = m.Update(transitionMsg{})
+		require.Equal(t, FileMode, m.mode)
+	}
+
+	m.SetShowHidden(false)
+	openLayer()
+	assert.False(t, m.filepicker.ShowHidden())
+
+	// The choice is kept for the layers opened afterwards
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{'.'}})
+	require.NotNil(t, cmd)
+	assert.True(t, m.filepicker.ShowHidden())
+	m.mode = LayerMode
+	openLayer()
+	assert.True(t, m.filepicker.ShowHidden())
+}
+
+func TestFindLayer(t *testing.T) {
+	layers := []container.Layer{
+		{DiffID: "sha256:aaa111", Digest: "sha256:ddd111"},
+		{DiffID: "sha256:aaa222", Digest: "sha256:ddd222"},
+	}
+	tests := []struct {
+		s       string
+		want    string
+		wantErr string
+	}{
+		{s: "2", want: "sha256:aaa222"},
+		{s: "sha256:aaa1", want: "sha256:aaa111"},
+		{s: "ddd2", want: "sha256:aaa222"},
+		{s: "3", wantErr: "no layer 3, the image has 2 layers"},
+		{s: "aaa", wantErr: "matches 2 layers"},
+		{s: "fff", wantErr: "no layer with the digest fff"},
+	}
+	for _, tt := range tests {
+		got, err := findLayer(layers, tt.s)
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.s)
+			continue
+		}
+		require.NoError(t, err, tt.s)
+		assert.Equal(t, tt.want, got.DiffID, tt.s)
+	}
+}
+
+func TestStart(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), image: img, showHidden: true}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+	m.SetStart("1", "/test.txt")
+
+	require.NotNil(t, m.openStart())
+	assert.Equal(t, LoadingMode, m.mode)
+	assert.Equal(t, layer.DiffID, m.loadingLayer.DiffID)
+
+	// The file is selected once the layer is loaded
+	m.pendingLayer = layer
+	_, cmd := m.Update(transitionMsg{})
+	require.NotNil(t, cmd)
+	_, _ = m.Update(cmd())
+	name, _, ok := m.filepicker.SelectedFile()
+	require.True(t, ok)
+	assert.Equal(t, "test.txt", name)
+
+	// A missing layer is reported
+	m.mode = LayerMode
+	m.SetStart("9", "/etc")
+	_ = m.openStart()
+	assert.Equal(t, LayerMode, m.mode)
+	assert.Contains(t, m.message, "no layer 9")
+	assert.Empty(t, m.startPath)
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	diff, err := unifiedDiff([]byte("a\nb\nc\n"), []byte("a\nB\nc\n"), "app:staging:/config.yaml", "app:prod:/config.yaml")
+	require.NoError(t, err)
+	assert.Equal(t, "--- app:staging:/config.yaml\n+++ app:prod:/config.yaml\n@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n", diff)
+
+	diff, err = unifiedDiff([]byte("same"), []byte("same"), "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "The files are identical", diff)
+
+	diff, err = unifiedDiff([]byte("\x00\x01"), []byte("\x00\x02"), "a", "b")
+	require.NoError(t, err)
+	assert.Equal(t, "The binary files a and b differ", diff)
+}
+
+func TestCompareFile(t *testing.T) {
+	m := &Model{ref: "app:staging", keys: newKeyMap(), ready: true, width: 80, height: 24}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.mode = LoadingMode
+	m.currentFile = &container.File{Name: "config.yaml", Path: "/app/config.yaml"}
+	_, _ = m.Update(viewFileMsg{content: "replicas: 1\n"})
+	require.Equal(t, ViewMode, m.mode)
+
+	// The reference is typed in a box under the file
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	require.NotNil(t, cmd)
+	assert.Contains(t, m.View(), "Compare with: app:staging")
+	m.compareInput.SetValue("app:prod")
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Contains(t, m.message, "Loading app:prod")
+
+	// Diffs of files no longer viewed are dropped
+	_, _ = m.Update(fileDiffMsg{path: "/other", diff: "-a\n+b\n"})
+	assert.Equal(t, ViewMode, m.mode)
+
+	_, _ = m.Update(fileDiffMsg{path: "/app/config.yaml", diff: "@@ -1 +1 @@\n-replicas: 1\n+replicas: 3\n"})
+	assert.Equal(t, DiffMode, m.mode)
+	assert.Contains(t, m.View(), "+replicas: 3")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, ViewMode, m.mode)
+	assert.Contains(t, m.View(), "replicas: 1")
+	assert.NotContains(t, m.View(), "replicas: 3")
+}
+
+func TestCompareFileImage(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+
+	// The file is read from the layers of the image compared with
+	msg, ok := compareFile(img, "app:staging", []byte("test content"), img.Reference, "/test.txt", nil)().(fileDiffMsg)
+	require.True(t, ok)
+	require.NoError(t, msg.err)
+	assert.Equal(t, "The files are identical", msg.diff)
+
+	msg, ok = compareFile(img, "app:staging", []byte("test content"), img.Reference, "/missing", nil)().(fileDiffMsg)
+	require.True(t, ok)
+	require.NoError(t, msg.err)
+	assert.Contains(t, msg.diff, img.Reference+":/missing (missing)")
+}
+
+func TestCompareConfig(t *testing.T) {
+	m := &Model{ref: "app:staging", keys: newKeyMap(), ready: true, width: 80, height: 24, mode: RuntimeMode}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	_, _ = m.Update(runtimeMsg{content: "User         app"})
+
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("d")})
+	require.NotNil(t, cmd)
+	assert.Contains(t, m.View(), "Compare with: app:staging")
+	m.compareInput.SetValue("app:prod")
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Contains(t, m.message, "Loading app:prod to compare the config")
+
+	changes := []container.ConfigChange{
+		{Field: "User", Kind: container.ChangeRemoved, Old: "app"},
+		{Field: "Env", Key: "LOG", Kind: container.ChangeAdded, New: "json"},
+		{Field: "Env", Key: "VERSION", Kind: container.ChangeModified, Old: "1.0", New: "1.1"},
+		{Field: "History", Kind: container.ChangeAdded, New: "ENV VERSION=1.1"},
+	}
+	layers := container.CompareLayers(
+		&container.Image{Layers: []container.Layer{{DiffID: "app1", Size: 10}, {DiffID: "base", Size: 2048}}},
+		&container.Image{Layers: []container.Layer{{DiffID: "app2", Size: 15}, {DiffID: "config", Size: 1}, {DiffID: "base", Size: 2048}}},
+	)
+	_, _ = m.Update(configDiffMsg{ref: "app:prod", layers: layers, changes: changes})
+	assert.Equal(t, DiffMode, m.mode)
+	assert.Contains(t, m.View(), "Changes of the config from app:staging to app:prod")
+	assert.Equal(t, "Changes of the config from app:staging to app:prod\n"+
+		"\nLayers\n  = 1 layer shared, 2.0 KiB\n  - 1 layer only in app:staging, 10 B\n  + 2 layers only in app:prod, 16 B\n"+
+		"\nUser\n  - app\n"+
+		"\nEnvironment\n  + LOG=json\n  ~ VERSION=1.0 → 1.1\n"+
+		"\nHistory\n  + ENV VERSION=1.1", m.formatConfigChanges("app:staging", "app:prod", layers, changes))
+
+	// Going back shows the runtime config again
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, RuntimeMode, m.mode)
+	assert.Contains(t, m.View(), "User         app")
+
+	assert.Contains(t, m.formatConfigChanges("a", "b", container.Comparison{}, nil), "The configs are identical")
+}
+
+func TestWatch(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "myapp:dev", keys: newKeyMap(), image: img, isLocalImage: true, showHidden: true}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+	m.SetWatch(time.Second)
+	require.NotNil(t, m.startWatch())
+
+	// The first ID is the one of the loaded image
+	_, cmd := m.Update(imageIDMsg{gen: m.watchGen, id: "sha256:1"})
+	require.NotNil(t, cmd)
+	assert.False(t, m.rebuilt)
+	_, cmd = m.Update(imageIDMsg{gen: m.watchGen, id: "sha256:1"})
+	require.NotNil(t, cmd)
+	assert.False(t, m.rebuilt)
+
+	// Checks of an image reloaded since are dropped
+	_, cmd = m.Update(imageIDMsg{gen: m.watchGen - 1, id: "sha256:2"})
+	assert.Nil(t, cmd)
+	assert.False(t, m.rebuilt)
+
+	_, _ = m.Update(imageIDMsg{gen: m.watchGen, id: "sha256:2"})
+	assert.True(t, m.rebuilt)
+	assert.Contains(t, m.statusBar(), "rebuilt, R to reload")
+
+	// The layer and the directory browsed are opened again
+	m.mode = FileMode
+	m.currentLayer = layer
+	m.filepicker = filepicker.New(&containerFS{layer: layer})
+	r := m.resumePoint()
+	require.NotNil(t, r)
+	assert.Equal(t, resumePoint{diffID: layer.DiffID, fromBottom: len(img.Layers) - 1, path: "/", open: true}, *r)
+
+	// A rebuilt layer is the one at the same position from the base
+	rebuilt := *img
+	rebuilt.Layers = append([]container.Layer{{DiffID: "sha256:rebuilt"}}, img.Layers[1:]...)
+	m.image = &rebuilt
+	m.resumeAt(r)
+	assert.Equal(t, "1", m.startLayer)
+	assert.Equal(t, "/", m.startPath)
+	m.image = img
+
+	// Without a watch, local images aren't checked
+	m.SetWatch(0)
+	assert.Nil(t, m.startWatch())
+	assert.False(t, m.rebuilt)
+}
+
+func TestWatchRemoteImage(t *testing.T) {
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap()}
+	m.SetWatch(time.Second)
+	assert.Nil(t, m.startWatch())
+}
+
+func TestCloseLayers(t *testing.T) {
+	registryHost := setupTestRegistry(t)
+	img, err := random.Image(1024, 1)
+	require.NoError(t, err)
+	ref := fmt.Sprintf("%s/test/close:latest", registryHost)
+	imgRef, err := name.ParseReference(ref)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(imgRef, img))
+
+	dir := t.TempDir()
+	image, err := container.Open(context.Background(), ref, container.WithCacheDir(dir), container.WithInMemoryThreshold(0))
+	require.NoError(t, err)
+
+	m := &Model{ref: ref, keys: newKeyMap(), image: image}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+	cached := func() []string {
+		files, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		var names []string
+		for _, f := range files {
+			names = append(names, f.Name())
+		}
+		return names
+	}
+	open := func() {
+		m.loadLayer(image.Layers[0])
+		m.cancel()
+		require.NoError(t, m.loadingLayer.InitializeLayer(context.Background(), nil))
+		m.Update(loadingLayerMsg{layer: m.loadingLayer})
+		m.Update(transitionMsg{})
+		require.Equal(t, FileMode, m.mode)
+		assert.Len(t, cached(), 1)
+	}
+
+	// The layer is closed when going back to the layers
+	open()
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("h")})
+	assert.Equal(t, LayerMode, m.mode)
+	assert.Nil(t, m.currentLayer)
+	assert.Empty(t, cached())
+
+	// and when another layer replaces it
+	open()
+	previous := m.currentLayer
+	open()
+	_, err = previous.GetFiles(context.Background(), ".")
+	assert.Error(t, err)
+
+	// and once exports reading it are done
+	m.exports, m.cancelExports = 1, func() {}
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("h")})
+	assert.Len(t, cached(), 1)
+	m.Update(exportFileMsg{})
+	assert.Empty(t, cached())
+
+	// and when the model is closed
+	open()
+	require.NoError(t, m.Close())
+	assert.Empty(t, cached())
+}
+
+func TestRefresh(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+
+	m := &Model{ref: "myapp:dev", keys: newKeyMap(), image: img, isLocalImage: true, pullPolicy: container.PullMissing}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+
+	// The local image is pulled again from the registry, and from then on
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("U")})
+	require.NotNil(t, cmd)
+	assert.Equal(t, PullingMode, m.mode)
+	assert.Equal(t, container.PullAlways, m.pullPolicy)
+	assert.Nil(t, m.image)
+	require.NotNil(t, m.resume)
+	m.Close()
+
+	// An image pulled from the registry is already up to date
+	m = &Model{ref: "alpine:3.20", keys: newKeyMap(), image: img, pullPolicy: container.PullMissing}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, LayerMode, 100, 30
+	m.list = newCustomList(m.layerItems(), 96, 24, m.theme)
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("U")})
+	assert.Equal(t, LayerMode, m.mode)
+	assert.Equal(t, container.PullMissing, m.pullPolicy)
+	assert.Contains(t, m.message, "not loaded from a local image")
+}
+
+func TestTagOmitted(t *testing.T) {
+	assert.True(t, tagOmitted("ghcr.io/org/app"))
+	assert.True(t, tagOmitted("localhost:5000/app"))
+	assert.False(t, tagOmitted("ghcr.io/org/app:latest"))
+	assert.False(t, tagOmitted("alpine:3.20"))
+	assert.False(t, tagOmitted("alpine@sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"))
+	assert.False(t, tagOmitted("Invalid Reference"))
+}
+
+func TestPickTag(t *testing.T) {
+	m := &Model{ref: "ghcr.io/org/app", keys: newKeyMap(), ready: true, width: 80, height: 24, pullPolicy: container.PullAlways}
+	m.SetTheme(themes[DefaultTheme])
+	m.mode = TagsMode
+	assert.Contains(t, m.View(), "Listing the tags of ghcr.io/org/app")
+
+	_, _ = m.Update(tagsMsg{ref: "ghcr.io/org/app", tags: []string{"1.1", "1.0", "latest"}})
+	view := m.View()
+	assert.Contains(t, view, "1.1")
+	assert.Contains(t, view, "latest")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Equal(t, "ghcr.io/org/app:1.0", m.ref)
+	assert.Equal(t, PullingMode, m.mode)
+
+	// The image is opened as latest if the tags can't be listed
+	m.ref, m.mode = "ghcr.io/org/app", TagsMode
+	_, cmd = m.Update(tagsMsg{ref: "ghcr.io/org/app", err: errors.New("unauthorized")})
+	require.NotNil(t, cmd)
+	assert.Equal(t, "ghcr.io/org/app:latest", m.ref)
+	assert.Equal(t, PullingMode, m.mode)
+	assert.Contains(t, m.message, "Failed to list the tags, opening latest: unauthorized")
+}
+
+func TestBrowse(t *testing.T) {
+	m := newModel("registry.example.com/org", container.PullAlways, nil)
+	m.browse = "registry.example.com/org"
+	m.ready, m.width, m.height = true, 80, 24
+	m.mode = ReposMode
+	assert.Contains(t, m.View(), "Listing the repositories of registry.example.com/org")
+
+	_, _ = m.Update(reposMsg{repos: []string{"org/api", "org/web"}})
+	assert.Contains(t, m.View(), "org/web")
+
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyDown})
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Equal(t, TagsMode, m.mode)
+	assert.Equal(t, "registry.example.com/org/web", m.ref)
+
+	// Tags of another repository are dropped
+	_, _ = m.Update(tagsMsg{ref: "registry.example.com/org/api", tags: []string{"9.9"}})
+	assert.Nil(t, m.tags)
+	_, _ = m.Update(tagsMsg{ref: "registry.example.com/org/web", tags: []string{"1.0"}})
+	view := m.View()
+	assert.Contains(t, view, "1.0")
+	assert.Contains(t, view, "esc back")
+
+	// esc goes back to the repositories
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, ReposMode, m.mode)
+	assert.Equal(t, "registry.example.com/org", m.ref)
+	item, ok := m.table.SelectedItem().(labelItem)
+	require.True(t, ok)
+	assert.Equal(t, "org/web", item.key)
+
+	// Registries without the catalog API are reported
+	m.repos = nil
+	_, _ = m.Update(reposMsg{err: fmt.Errorf("failed to list repositories: %w", container.ErrUnauthorized)})
+	assert.Equal(t, ErrorMode, m.mode)
+	assert.NotNil(t, m.retry)
+}
+
+func TestUsageMode(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}, accessible: true}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = img
+	m.ready, m.mode, m.width, m.height = true, FileMode, 100, 30
+	m.currentLayer = layer
+	m.filepicker = filepicker.New(&containerFS{layer: layer})
+	m.filepicker.SetHeight(m.height - 6)
+
+	// The disk usage is computed in the background
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("u")})
+	require.NotNil(t, cmd)
+	assert.Equal(t, UsageMode, m.mode)
+	assert.Contains(t, m.View(), "Computing the disk usage...")
+	usage, err := layer.DiskUsage(context.Background())
+	require.NoError(t, err)
+	_, _ = m.Update(usageMsg{layer: layer, usage: usage})
+	view := m.View()
+	assert.Contains(t, view, "/  12 B in 1 file")
+	assert.Contains(t, view, "12 B "+strings.Repeat("█", 25)+" 100.0%  test.txt")
+
+	// Usages of another layer are dropped
+	_, _ = m.Update(usageMsg{layer: &img.Layers[1], usage: &container.Usage{Name: "/", Dir: true}})
+	assert.Contains(t, m.View(), "test.txt")
+
+	// Directories are opened and left with the keyboard
+	m.usage = &container.Usage{Name: "/", Size: 400, Dir: true, Files: 4, Children: []*container.Usage{
+		{Name: "usr", Size: 300, Dir: true, Files: 2, Children: []*container.Usage{
+			{Name: "app", Size: 200},
+			{Name: "libc.so.6", Size: 100},
+		}},
+		{Name: "etc", Size: 100, Dir: true},
+	}}
+	m.openUsage(".")
+	assert.Contains(t, m.View(), "300 B "+strings.Repeat("█", 19)+strings.Repeat("░", 6)+"  75.0%  usr/")
+	assert.Contains(t, m.statusBar(), "1/2")
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	view = m.View()
+	assert.Contains(t, view, "/usr  300 B in 2 files")
+	assert.Contains(t, view, "> ")
+	assert.Contains(t, view, "200 B "+strings.Repeat("█", 17)+strings.Repeat("░", 8)+"  66.7%  app")
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")})
+	assert.Equal(t, 1, m.usageIndex)
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("j")})
+	assert.Equal(t, 1, m.usageIndex)
+
+	// Files and empty directories aren't opened
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, "/usr", m.usagePath())
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("h")})
+	assert.Equal(t, "/", m.usagePath())
+	assert.Equal(t, 0, m.usageIndex)
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("G")})
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, "/", m.usagePath())
+
+	// The file types of the directory are shown instead of its files
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("t")})
+	require.True(t, m.showTypes)
+	view = m.View()
+	assert.Contains(t, view, "/  400 B in 4 files by type")
+	assert.Contains(t, view, "> ")
+	assert.Contains(t, view, "200 B "+strings.Repeat("█", 13)+strings.Repeat("░", 12)+"  50.0%  No extension  1 file")
+	assert.Contains(t, view, "100 B "+strings.Repeat("█", 6)+strings.Repeat("░", 19)+"  25.0%  Shared libraries  1 file")
+	assert.Contains(t, m.statusBar(), "1/2")
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	assert.Equal(t, "/", m.usagePath())
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("h")})
+	assert.False(t, m.showTypes)
+	assert.Equal(t, UsageMode, m.mode)
+	assert.Contains(t, m.View(), "usr/")
+
+	// The usage opens at the directory browsed, and is computed once
+	m.usageLayer = layer
+	m.mode = FileMode
+	m.filepicker.SetPath("usr")
+	assert.Nil(t, m.showUsage())
+	assert.Equal(t, "/usr", m.usagePath())
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, FileMode, m.mode)
+}
+
+func TestRecentMode(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}, accessible: true, timeLocation: time.UTC}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = img
+	m.ready, m.mode, m.width, m.height = true, FileMode, 100, 30
+	m.currentLayer = layer
+	m.filepicker = filepicker.New(&containerFS{layer: layer})
+	m.filepicker.SetHeight(m.height - 6)
+
+	// The files are listed in the background
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	require.NotNil(t, cmd)
+	assert.Equal(t, RecentMode, m.mode)
+	assert.Contains(t, m.View(), "Listing the files of the layer...")
+	files, err := layer.RecentFiles(context.Background())
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	// Files of another layer are dropped
+	_, _ = m.Update(recentMsg{layer: &img.Layers[1], files: []container.File{{Path: "other.txt"}}})
+	assert.Contains(t, m.View(), "Listing the files of the layer...")
+	_, _ = m.Update(recentMsg{layer: layer, files: files})
+	view := m.View()
+	assert.Contains(t, view, m.formatTime(files[0].ModifiedAt)+"  12 B  /test.txt")
+	assert.Contains(t, m.statusBar(), "1/1")
+
+	// The selected file is shown in the file list
+	_, cmd = m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	require.NotNil(t, cmd)
+	assert.Equal(t, FileMode, m.mode)
+	assert.Equal(t, "/", m.currentPath)
+
+	// esc goes back to the file list, and an empty layer lists no files
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("m")})
+	_, _ = m.Update(recentMsg{layer: layer})
+	assert.Contains(t, m.View(), "The layer has no files")
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, FileMode, m.mode)
+}
+
+func TestFileKind(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), tabs: []string{"📦 Layers", "📄 Manifest", "⚙️  Config"}}
+	m.SetTheme(themes[DefaultTheme])
+	m.SetNoColor(true)
+	m.image = img
+	m.ready, m.mode, m.width, m.height = true, FileMode, 100, 30
+	m.currentLayer = layer
+	m.filepicker = filepicker.New(&containerFS{layer: layer})
+	m.filepicker.SetHeight(m.height - 6)
+	model, _ := m.Update(m.filepicker.Init()())
+	m = model.(*Model)
+	assert.Contains(t, m.View(), "test.txt")
+
+	// The file isn't executable, but is owned by root
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("f")})
+	assert.Equal(t, filepicker.KindExecutable, m.filepicker.Kind())
+	view := m.View()
+	assert.NotContains(t, view, "test.txt")
+	assert.Contains(t, view, "No executables here")
+	assert.Contains(t, m.statusBar(), "0/1 matches")
+	m.filepicker.SetKind(filepicker.KindRoot)
+	assert.Contains(t, m.View(), "test.txt")
+
+	// esc shows all of the files before going back
+	_, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	assert.Equal(t, filepicker.KindAll, m.filepicker.Kind())
+	assert.Equal(t, FileMode, m.mode)
+}
+
+func TestCopyFullPath(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	layer := &img.Layers[0]
+	require.NoError(t, layer.InitializeLayer(context.Background(), func(container.Progress) {}))
+
+	m := &Model{ref: "myapp:dev", keys: newKeyMap(), image: img}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.mode, m.width, m.height = true, FileMode, 100, 30
+	copyFullPath := func() string {
+		m.filepicker = filepicker.New(&containerFS{layer: m.currentLayer})
+		model, _ := m.Update(m.filepicker.Init()())
+		m = model.(*Model)
+		for _, c := range m.chords("y") {
+			if c.binding.Keys()[0] == "yf" {
+				msg, ok := c.run(m)().(copyToClipboardMsg)
+				require.True(t, ok)
+				return msg.text
+			}
+		}
+		return ""
+	}
+
+	// The layer is told by its number and diff ID
+	m.currentLayer = layer
+	assert.Equal(t, "myapp:dev [layer 1 "+shortDigest(layer.DiffID)+"] /test.txt", copyFullPath())
+
+	// and layers viewed together by their numbers
+	merged := container.MergeLayers(img.Layers...)
+	require.NoError(t, merged.InitializeLayer(context.Background(), func(container.Progress) {}))
+	m.currentLayer = merged
+	assert.Contains(t, copyFullPath(), "myapp:dev [layers 1+2] /")
+}
+
+func TestLoadAccounts(t *testing.T) {
+	img, err := setupTestImage(t)
+	require.NoError(t, err)
+	require.Len(t, img.Layers, 2)
+
+	m := &Model{ref: "alpine:3.20", keys: newKeyMap(), image: img}
+	m.SetTheme(themes[DefaultTheme])
+	m.ready, m.width, m.height = true, 100, 30
+	openLayer := func(i int) *container.Layer {
+		layer := img.Layers[i]
+		require.NoError(t, layer.InitializeLayer(context.Background(), nil))
+		m.mode, m.pendingLayer = LoadingMode, &layer
+		_, _ = m.Update(transitionMsg{})
+		require.Equal(t, FileMode, m.mode)
+		return &layer
+	}
+
+	// The first layer has nothing below it
+	openLayer(0)
+	assert.Nil(t, m.loadAccounts())
+
+	// The names of the upper layer are looked up in the layers below it
+	upper := openLayer(1)
+	cmd := m.loadAccounts()
+	require.NotNil(t, cmd)
+	msg, ok := cmd().(accountsMsg)
+	require.True(t, ok)
+	require.NoError(t, msg.err)
+	assert.Same(t, upper, msg.layer)
+	require.NotNil(t, msg.accounts)
+	assert.NotNil(t, m.setAccounts(msg))
+	assert.Same(t, msg.accounts, upper.Accounts())
+
+	// The names loaded for a layer no longer opened are dropped
+	openLayer(0)
+	assert.Nil(t, m.setAccounts(msg))
+	assert.NotSame(t, msg.accounts, m.currentLayer.Accounts())
+}
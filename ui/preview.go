@@ -0,0 +1,190 @@
+package ui
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// previewBytes is how much of a file is read for its preview
+const previewBytes = 16 << 10
+
+// filePreview is the beginning of the selected file, or the entries of the
+// selected directory
+type filePreview struct {
+	path    string
+	dir     bool
+	content []byte
+	entries []container.File
+	loaded  bool
+	err     error
+}
+
+type previewMsg struct {
+	preview filePreview
+}
+
+// updatePreview loads the preview when another file is selected
+func (m *Model) updatePreview() tea.Cmd {
+	if !m.showPreview || m.mode != FileMode || m.currentLayer == nil {
+		return nil
+	}
+	p, ok := m.filepicker.SelectedPath()
+	if !ok {
+		m.preview = filePreview{}
+		return nil
+	}
+	if p == m.preview.path {
+		return nil
+	}
+	_, _, isFile := m.filepicker.SelectedFile()
+	m.preview = filePreview{path: p, dir: !isFile}
+	return loadPreview(m.currentLayer, m.preview)
+}
+
+// loadPreview reads the beginning of the file, or lists the directory
+func loadPreview(layer *container.Layer, preview filePreview) tea.Cmd {
+	return func() tea.Msg {
+		tarfsPath := strings.TrimPrefix(preview.path, "/")
+		if preview.dir {
+			preview.entries, preview.err = layer.GetFiles(context.Background(), tarfsPath)
+		} else {
+			preview.content, preview.err = layer.ReadFileHead(context.Background(), tarfsPath, previewBytes)
+		}
+		preview.loaded = true
+		return previewMsg{preview: preview}
+	}
+}
+
+// withPreview puts the preview of the selected file on the right of the
+// file list
+func (m *Model) withPreview(list string) string {
+	width := m.width - 4
+	listWidth := width / 2
+	previewWidth := width - listWidth - 3
+	height := m.height - 6
+	if previewWidth < 10 || height < 2 {
+		return list
+	}
+
+	lines := strings.Split(list, "\n")
+	for i, line := range lines {
+		lines[i] = lipgloss.NewStyle().Width(listWidth).Render(truncate(line, listWidth))
+	}
+	left := strings.Join(lines, "\n")
+	dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed))
+	separator := strings.TrimSuffix(strings.Repeat(dimmed.Render(m.chrome(" │ "))+"\n", height), "\n")
+	return lipgloss.JoinHorizontal(lipgloss.Top, left, separator, m.previewView(previewWidth, height))
+}
+
+// previewView renders the name of the selected file followed by its preview
+func (m *Model) previewView(width, height int) string {
+	p := m.preview
+	if p.path == "" {
+		return ""
+	}
+	dimmed := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed))
+	title := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight)).Render(truncate(filepicker.SanitizeName(p.path), width))
+
+	var lines []string
+	switch {
+	case !p.loaded:
+		lines = []string{dimmed.Render("Loading...")}
+	case p.err != nil:
+		lines = []string{truncate(fmt.Sprintf("Failed to preview: %v", p.err), width)}
+	case p.dir:
+		lines = previewEntries(p.entries, height-1)
+	case len(p.content) == 0:
+		lines = []string{dimmed.Render("Empty file")}
+	case isBinary(p.content):
+		lines = hexPreview(p.content, width, height-1)
+	default:
+		lines = textPreview(p.content, height-1)
+	}
+	for i, line := range lines {
+		lines[i] = truncate(line, width)
+	}
+	return strings.Join(append([]string{title}, lines...), "\n")
+}
+
+// previewEntries lists the names of a directory, with a slash after the
+// directories
+func previewEntries(entries []container.File, height int) []string {
+	if len(entries) == 0 {
+		return []string{"Empty directory"}
+	}
+	var lines []string
+	for i, e := range entries {
+		if len(lines) == height-1 && i < len(entries)-1 {
+			lines = append(lines, fmt.Sprintf("and %d more", len(entries)-i))
+			break
+		}
+		name := filepicker.SanitizeName(e.Name)
+		if e.IsDir {
+			name += "/"
+		}
+		lines = append(lines, name)
+	}
+	return lines
+}
+
+// textPreview returns the first lines of a text file
+func textPreview(content []byte, height int) []string {
+	lines := strings.Split(string(content), "\n")
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	for i, line := range lines {
+		line = strings.TrimSuffix(line, "\r")
+		lines[i] = sanitizeCommand(strings.ReplaceAll(line, "\t", "    "))
+	}
+	return lines
+}
+
+// hexPreview dumps the first bytes of a binary file as hexdump -C does, with
+// fewer bytes per line if the pane is narrow
+func hexPreview(content []byte, width, height int) []string {
+	perLine := 16
+	for perLine > 4 && 13+perLine*4 > width {
+		perLine /= 2
+	}
+	var lines []string
+	for offset := 0; offset < len(content) && len(lines) < height; offset += perLine {
+		chunk := content[offset:min(offset+perLine, len(content))]
+		var hex, text strings.Builder
+		for i := range perLine {
+			if i < len(chunk) {
+				fmt.Fprintf(&hex, "%02x ", chunk[i])
+			} else {
+				hex.WriteString("   ")
+			}
+		}
+		for _, c := range chunk {
+			if c < 0x20 || c > 0x7e {
+				c = '.'
+			}
+			text.WriteByte(c)
+		}
+		lines = append(lines, fmt.Sprintf("%08x  %s |%s|", offset, hex.String(), text.String()))
+	}
+	return lines
+}
+
+// isBinary reports whether content has NUL bytes or isn't valid UTF-8. The
+// content may be cut in the middle of a character.
+func isBinary(content []byte) bool {
+	if bytes.IndexByte(content, 0) >= 0 {
+		return true
+	}
+	for i := 0; i < utf8.UTFMax-1 && len(content) > 0 && !utf8.Valid(content); i++ {
+		content = content[:len(content)-1]
+	}
+	return !utf8.Valid(content)
+}
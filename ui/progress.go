@@ -0,0 +1,147 @@
+package ui
+
+import (
+	"slices"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+)
+
+// progressInterval is the minimum interval between two progress messages.
+// Updates arriving in between are coalesced into the latest value.
+const progressInterval = 50 * time.Millisecond
+
+// progressMsg carries the latest progress of a reporter
+type progressMsg struct {
+	reporter *progressReporter
+	progress container.Progress
+	blobs    []container.Progress
+	done     bool
+}
+
+// progressReporter coalesces progress updates from a background job.
+// Only the latest value is kept, so fast producers never block or flood
+// the UI and slow ones don't need a ticking loop to be observed.
+type progressReporter struct {
+	mu       sync.Mutex
+	progress container.Progress
+	blobs    []container.Progress // latest progress of each blob, in order of appearance
+	closed   bool
+	events   *EventWriter // also writes the progress as events
+	notify   chan struct{}
+	done     chan struct{}
+}
+
+func newProgressReporter() *progressReporter {
+	return &progressReporter{
+		notify: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+// report records a new progress value. It never blocks.
+func (p *progressReporter) report(progress container.Progress) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return
+	}
+	p.events.Progress(progress)
+	if progress.Blob != "" {
+		p.reportBlob(progress)
+	} else {
+		p.progress = progress
+	}
+	p.mu.Unlock()
+
+	select {
+	case p.notify <- struct{}{}:
+	default:
+		// An update is already pending; it will pick up the latest value
+	}
+}
+
+// reportBlob records the progress of a blob, keeping the others
+func (p *progressReporter) reportBlob(progress container.Progress) {
+	for i := range p.blobs {
+		if p.blobs[i].Blob == progress.Blob {
+			p.blobs[i] = progress
+			return
+		}
+	}
+	p.blobs = append(p.blobs, progress)
+}
+
+// close marks the job as finished. The final value is still delivered.
+func (p *progressReporter) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+	p.closed = true
+	close(p.done)
+}
+
+func (p *progressReporter) load() (container.Progress, []container.Progress, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.progress, slices.Clone(p.blobs), p.closed
+}
+
+// wait returns a command that blocks until there is a new progress value
+// or the job finishes
+func (p *progressReporter) wait() tea.Cmd {
+	return func() tea.Msg {
+		select {
+		case <-p.notify:
+			// Give the producer a moment so that bursts are coalesced
+			select {
+			case <-time.After(progressInterval):
+			case <-p.done:
+			}
+		case <-p.done:
+		}
+		progress, blobs, done := p.load()
+		return progressMsg{reporter: p, progress: progress, blobs: blobs, done: done}
+	}
+}
+
+// rateSmoothing is the weight of the latest sample in the transfer speed
+const rateSmoothing = 0.3
+
+// transferRate estimates the speed of a download from periodic samples
+type transferRate struct {
+	lastBytes int64
+	lastTime  time.Time
+	speed     float64 // bytes per second, exponentially smoothed
+}
+
+// sample records the number of bytes transferred so far
+func (r *transferRate) sample(complete int64, now time.Time) {
+	if r.lastTime.IsZero() || complete < r.lastBytes {
+		r.lastBytes, r.lastTime, r.speed = complete, now, 0
+		return
+	}
+	elapsed := now.Sub(r.lastTime).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+	current := float64(complete-r.lastBytes) / elapsed
+	if r.speed == 0 {
+		r.speed = current
+	} else {
+		r.speed = rateSmoothing*current + (1-rateSmoothing)*r.speed
+	}
+	r.lastBytes, r.lastTime = complete, now
+}
+
+// eta returns the estimated remaining time, or zero if it is unknown
+func (r *transferRate) eta(complete, total int64) time.Duration {
+	if r.speed <= 0 || total <= complete {
+		return 0
+	}
+	return time.Duration(float64(total-complete) / r.speed * float64(time.Second)).Round(time.Second)
+}
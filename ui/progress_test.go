@@ -0,0 +1,82 @@
+package ui
+
+import (
+	"testing"
+	"time"
+
+	"github.com/knqyf263/sou/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressReporter(t *testing.T) {
+	reporter := newProgressReporter()
+	downloading := func(complete int64) container.Progress {
+		return container.Progress{Stage: container.StageDownloading, Complete: complete, Total: 10}
+	}
+
+	// Bursts of updates are coalesced into the latest value
+	reporter.report(downloading(1))
+	reporter.report(downloading(2))
+	reporter.report(downloading(3))
+
+	msg, ok := reporter.wait()().(progressMsg)
+	require.True(t, ok)
+	assert.Equal(t, reporter, msg.reporter)
+	assert.Equal(t, downloading(3), msg.progress)
+	assert.False(t, msg.done)
+
+	// The final value is delivered even if nobody is waiting when the job ends
+	done := container.Progress{Stage: container.StageDone}
+	reporter.report(done)
+	reporter.close()
+
+	msg, ok = reporter.wait()().(progressMsg)
+	require.True(t, ok)
+	assert.Equal(t, done, msg.progress)
+	assert.True(t, msg.done)
+
+	// Updates after close are ignored
+	reporter.report(downloading(5))
+	msg = reporter.wait()().(progressMsg)
+	assert.Equal(t, done, msg.progress)
+}
+
+func TestTransferRate(t *testing.T) {
+	var rate transferRate
+	start := time.Now()
+
+	rate.sample(0, start)
+	assert.Zero(t, rate.eta(0, 100))
+
+	rate.sample(1000, start.Add(time.Second))
+	assert.Equal(t, 1000.0, rate.speed)
+	assert.Equal(t, 9*time.Second, rate.eta(1000, 10000))
+
+	// A restarted transfer resets the estimation
+	rate.sample(0, start.Add(2*time.Second))
+	assert.Zero(t, rate.speed)
+}
+
+func TestShortDigest(t *testing.T) {
+	assert.Equal(t, "sha256:0123456789ab", shortDigest("sha256:0123456789abcdef0123456789abcdef"))
+	assert.Equal(t, "sha256:abc", shortDigest("sha256:abc"))
+	assert.Equal(t, "N/A", shortDigest("N/A"))
+}
+
+func TestProgressReporterBlobs(t *testing.T) {
+	reporter := newProgressReporter()
+	blob := func(digest string, complete int64) container.Progress {
+		return container.Progress{Stage: container.StageResolving, Blob: digest, Complete: complete, Total: 10}
+	}
+
+	// The latest progress of every blob is kept, in order of appearance
+	reporter.report(blob("sha256:b", 1))
+	reporter.report(blob("sha256:a", 10))
+	reporter.report(blob("sha256:b", 5))
+	reporter.close()
+
+	msg := reporter.wait()().(progressMsg)
+	assert.Equal(t, []container.Progress{blob("sha256:b", 5), blob("sha256:a", 10)}, msg.blobs)
+	assert.Equal(t, container.Progress{}, msg.progress)
+}
@@ -0,0 +1,210 @@
+package ui
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/itchyny/gojq"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+const (
+	// queryTimeout stops queries that never end, like repeat(.)
+	queryTimeout = 5 * time.Second
+	// maxQueryResults stops queries with endless results, like range(infinite)
+	maxQueryResults = 10000
+)
+
+type queryMsg struct {
+	query  string
+	result []byte // JSON of the only result, or an array of all of them
+	count  int
+	err    error
+}
+
+// runQuery runs a jq query on a JSON document. A query with several results
+// returns them in an array.
+func runQuery(ctx context.Context, input []byte, query string) ([]byte, int, error) {
+	q, err := gojq.Parse(query)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid query: %w", err)
+	}
+	code, err := gojq.Compile(q)
+	if err != nil {
+		return nil, 0, fmt.Errorf("invalid query: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return nil, 0, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+	var results []any
+	iter := code.RunWithContext(ctx, v)
+	for {
+		r, ok := iter.Next()
+		if !ok {
+			break
+		}
+		if err, ok := r.(error); ok {
+			var halt *gojq.HaltError
+			if errors.As(err, &halt) && halt.Value() == nil {
+				break
+			}
+			if errors.Is(err, context.DeadlineExceeded) {
+				return nil, 0, fmt.Errorf("query timed out after %s", queryTimeout)
+			}
+			return nil, 0, err
+		}
+		if len(results) == maxQueryResults {
+			return nil, 0, fmt.Errorf("query returned more than %d results", maxQueryResults)
+		}
+		results = append(results, r)
+	}
+
+	var out []byte
+	if len(results) == 1 {
+		out, err = gojq.Marshal(results[0])
+	} else {
+		out, err = gojq.Marshal(results)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return out, len(results), nil
+}
+
+// startQuery opens the query box of the manifest or config
+func (m *Model) startQuery() tea.Cmd {
+	m.queryInput = textinput.New()
+	m.queryInput.Prompt = "jq "
+	m.queryInput.Placeholder = ".history[].created_by"
+	m.queryInput.PromptStyle = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	m.queryInput.Cursor.Style = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	m.queryInput.SetValue(m.query)
+	m.editingQuery = true
+	m.resizeJSON()
+	return m.queryInput.Focus()
+}
+
+// updateQuery handles the keys typed in the query box. Enter runs the query,
+// and an empty query shows the whole document again.
+func (m *Model) updateQuery(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc:
+		m.editingQuery = false
+		m.queryInput.Blur()
+		m.resizeJSON()
+		return m, nil
+	case tea.KeyEnter:
+		query := strings.TrimSpace(m.queryInput.Value())
+		if query == "" || query == "." {
+			m.editingQuery = false
+			m.queryInput.Blur()
+			return m, m.clearQuery()
+		}
+		input := m.document
+		return m, func() tea.Msg {
+			result, count, err := runQuery(context.Background(), input, query)
+			return queryMsg{query: query, result: result, count: count, err: err}
+		}
+	}
+	var cmd tea.Cmd
+	m.queryInput, cmd = m.queryInput.Update(msg)
+	return m, cmd
+}
+
+// showQueryResult shows the result of a query in the JSON tree. The query
+// box stays open on errors so that the query can be fixed.
+func (m *Model) showQueryResult(msg queryMsg) tea.Cmd {
+	if msg.err != nil {
+		m.message = fmt.Sprintf("Query failed: %v", msg.err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	m.editingQuery = false
+	m.queryInput.Blur()
+	m.query = msg.query
+	m.queryResult = msg.result
+	if err := m.showJSON(msg.result); err != nil {
+		m.message = fmt.Sprintf("Query failed: %v", err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	if msg.count == 1 {
+		return m.announce("1 result")
+	}
+	return m.announce("%d results", msg.count)
+}
+
+// clearQuery shows the whole manifest or config again
+func (m *Model) clearQuery() tea.Cmd {
+	m.query, m.queryResult = "", nil
+	if err := m.showJSON(m.document); err != nil {
+		m.message = fmt.Sprintf("Failed to parse JSON: %v", err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	return m.announce("Showing the whole document")
+}
+
+// queryView renders the query box, or the query whose result is shown
+func (m *Model) queryView() string {
+	if m.editingQuery {
+		return m.queryInput.View()
+	}
+	if m.query == "" {
+		return ""
+	}
+	label := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight)).Render("jq ")
+	return label + filepicker.SanitizeName(m.query) +
+		lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed)).Render("  (esc clears)")
+}
+
+// resizeJSON fits the JSON tree below the query box, if any
+func (m *Model) resizeJSON() {
+	height := m.height - 6
+	if m.editingQuery || m.query != "" {
+		height--
+	}
+	m.tree.SetSize(m.width-4, height)
+}
+
+// exportQueryResult exports the result of the query to the current
+// directory, as name-query.json
+func exportQueryResult(ctx context.Context, name string, result []byte) tea.Cmd {
+	return func() tea.Msg {
+		var indented bytes.Buffer
+		if err := json.Indent(&indented, result, "", "  "); err != nil {
+			return exportFileMsg{err: fmt.Errorf("failed to format result: %w", err)}
+		}
+		indented.WriteString("\n")
+
+		cwd, err := os.Getwd()
+		if err != nil {
+			return exportFileMsg{err: fmt.Errorf("failed to get current directory: %w", err)}
+		}
+		outputPath := filepath.Join(cwd, name+"-query.json")
+		if err := writeFile(ctx, outputPath, indented.Bytes()); err != nil {
+			return exportFileMsg{err: fmt.Errorf("failed to write file: %w", err)}
+		}
+		return exportFileMsg{path: outputPath}
+	}
+}
+
+// queryKey reports whether msg opens the query box
+func (m *Model) queryKey(msg tea.KeyMsg) bool {
+	return (m.mode == ManifestMode || m.mode == ConfigMode) && key.Matches(msg, m.keys.filter)
+}
@@ -0,0 +1,105 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+type recentMsg struct {
+	layer *container.Layer
+	files []container.File
+	err   error
+}
+
+// showRecent lists the files of the layer or layers viewed, the most
+// recently modified first, which tells what a build step changed when its
+// command doesn't
+func (m *Model) showRecent() tea.Cmd {
+	if m.currentLayer == nil {
+		return nil
+	}
+	m.mode = RecentMode
+	m.recent, m.recentLayer = nil, m.currentLayer
+	layer := m.currentLayer
+	return tea.Batch(func() tea.Msg {
+		files, err := layer.RecentFiles(context.Background())
+		return recentMsg{layer: layer, files: files, err: err}
+	}, m.spinner.Tick)
+}
+
+// setRecent shows the files listed, unless another layer has been opened
+// since or the list has been left
+func (m *Model) setRecent(msg recentMsg) tea.Cmd {
+	if m.mode != RecentMode || msg.layer != m.recentLayer {
+		return nil
+	}
+	if msg.err != nil {
+		m.mode = FileMode
+		m.message = fmt.Sprintf("Error: %v", msg.err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	// The list is loaded even if the layer has no files
+	m.recent = append([]container.File{}, msg.files...)
+	items := make([]labelItem, 0, len(msg.files))
+	for _, f := range msg.files {
+		items = append(items, labelItem{source: m.formatTime(f.ModifiedAt), key: m.formatSize(f.Size), value: "/" + f.Path})
+	}
+	m.table = newLabelList(items, "file", "files", m.width-4, m.height-6, m.theme)
+	m.table.Styles.NoItems = m.table.Styles.NoItems.SetString("The layer has no files")
+	return m.announce("Showing %d files, the most recently modified first", len(msg.files))
+}
+
+// updateRecent handles the keys of the recently modified files. enter shows
+// the selected file in the file list, and esc goes back to it unless it
+// clears the filter.
+func (m *Model) updateRecent(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch {
+	case m.recent == nil:
+		if key.Matches(msg, m.keys.back) {
+			m.mode = FileMode
+		}
+		return m, nil
+	case m.table.FilterState() == list.Filtering:
+		m.table, cmd = m.table.Update(msg)
+		return m, cmd
+	case key.Matches(msg, m.keys.back) && (m.table.FilterState() == list.Unfiltered || msg.Type != tea.KeyEsc):
+		m.mode = FileMode
+		return m, nil
+	case key.Matches(msg, m.keys.enter):
+		item, ok := m.table.SelectedItem().(labelItem)
+		if !ok {
+			return m, nil
+		}
+		p := item.value[1:]
+		cmd, err := m.filepicker.Reveal(p)
+		m.mode = FileMode
+		if err != nil {
+			m.message = fmt.Sprintf("Failed to open %s: %v", filepicker.SanitizeName(item.value), err)
+			return m, hideMessageAfter(3 * time.Second)
+		}
+		m.currentPath = path.Join("/", m.filepicker.CurrentPath())
+		return m, tea.Batch(cmd, m.announce("Showing %s", path.Base(p)))
+	}
+	m.table, cmd = m.table.Update(msg)
+	return m, cmd
+}
+
+// recentView shows the recently modified files, or that they are listed
+func (m *Model) recentView() string {
+	if m.recent != nil {
+		return m.table.View()
+	}
+	if m.accessible {
+		return "\n\n  Listing the files of the layer..."
+	}
+	return fmt.Sprintf("\n\n  %s Listing the files of the layer...", m.spinner.View())
+}
@@ -0,0 +1,157 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+)
+
+type runtimeMsg struct {
+	content string
+	err     error
+}
+
+// runtimeFieldWidth is the width of the names of the fields in the summary
+const runtimeFieldWidth = 13
+
+// loadRuntime summarizes the runtime configuration of the image
+func loadRuntime(image *container.Image, colors jsonColors) tea.Cmd {
+	return func() tea.Msg {
+		r, err := image.Runtime()
+		if err != nil {
+			return runtimeMsg{err: err}
+		}
+		return runtimeMsg{content: formatRuntime(r, colors)}
+	}
+}
+
+// formatRuntime renders the runtime configuration as one field per line,
+// with the values of lists on their own lines. Fields left unset are shown
+// with the defaults Docker applies.
+func formatRuntime(r *container.Runtime, colors jsonColors) string {
+	var b strings.Builder
+	paint := func(color, text string) string {
+		if color == "" {
+			return text
+		}
+		return color + text + colors.reset
+	}
+	field := func(name string, values ...string) {
+		if len(values) == 0 {
+			values = []string{paint(colors.literal, "none")}
+		}
+		for i, v := range values {
+			if i == 0 {
+				b.WriteString(paint(colors.key, fmt.Sprintf("%-*s", runtimeFieldWidth, name)))
+			} else {
+				b.WriteString(strings.Repeat(" ", runtimeFieldWidth))
+			}
+			b.WriteString(v + "\n")
+		}
+	}
+	orDefault := func(value, def string) string {
+		if value == "" {
+			return def + " " + paint(colors.literal, "(default)")
+		}
+		return sanitizeValue(value)
+	}
+
+	field("Entrypoint", joinArgs(r.Entrypoint)...)
+	field("Command", joinArgs(r.Cmd)...)
+	if len(r.Entrypoint) > 0 && len(r.Cmd) > 0 {
+		// The command is passed to the entrypoint as its arguments
+		field("Runs", joinArgs(append(append([]string{}, r.Entrypoint...), r.Cmd...))...)
+	}
+	field("User", orDefault(r.User, "root"))
+	field("Working dir", orDefault(r.WorkingDir, "/"))
+	field("Exposed", sanitizeValues(r.ExposedPorts)...)
+	field("Volumes", sanitizeValues(r.Volumes)...)
+	field("Stop signal", orDefault(r.StopSignal, "SIGTERM"))
+	field("Healthcheck", formatHealthcheck(r.Healthcheck)...)
+
+	env := make([]string, 0, len(r.Env))
+	for _, e := range r.Env {
+		name, value, _ := strings.Cut(sanitizeValue(e), "=")
+		env = append(env, paint(colors.str, name)+"="+value)
+	}
+	field("Environment", env...)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// formatHealthcheck returns the command of the health check followed by its
+// options, if any
+func formatHealthcheck(h *container.Healthcheck) []string {
+	if h == nil || len(h.Test) == 0 {
+		return nil
+	}
+	var lines []string
+	switch h.Test[0] {
+	case "NONE":
+		return []string{"disabled"}
+	case "CMD-SHELL":
+		lines = []string{sanitizeValue(strings.Join(h.Test[1:], " "))}
+	case "CMD":
+		lines = joinArgs(h.Test[1:])
+	default:
+		lines = joinArgs(h.Test)
+	}
+
+	var opts []string
+	if h.Interval > 0 {
+		opts = append(opts, "every "+h.Interval.String())
+	}
+	if h.Timeout > 0 {
+		opts = append(opts, "timeout "+h.Timeout.String())
+	}
+	if h.StartPeriod > 0 {
+		opts = append(opts, "start period "+h.StartPeriod.String())
+	}
+	if h.Retries > 0 {
+		opts = append(opts, fmt.Sprintf("%d retries", h.Retries))
+	}
+	if len(opts) > 0 {
+		lines = append(lines, strings.Join(opts, ", "))
+	}
+	return lines
+}
+
+// joinArgs joins the arguments of an exec form command as a shell would
+// read them, or returns nil if there are none
+func joinArgs(args []string) []string {
+	if len(args) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = shellQuote(sanitizeValue(arg))
+	}
+	return []string{strings.Join(quoted, " ")}
+}
+
+// shellQuote puts s in single quotes unless it consists of characters that
+// have no meaning to the shell
+func shellQuote(s string) string {
+	special := func(r rune) bool {
+		return r < 0x80 && !isAlnum(byte(r)) && !strings.ContainsRune("-_./:=@%+,", r)
+	}
+	if s != "" && strings.IndexFunc(s, special) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// sanitizeValue keeps a value of the config on one line without control
+// characters
+func sanitizeValue(s string) string {
+	return strings.ReplaceAll(sanitizeCommand(s), "\n", " ")
+}
+
+func sanitizeValues(values []string) []string {
+	sanitized := make([]string, len(values))
+	for i, v := range values {
+		sanitized[i] = sanitizeValue(v)
+	}
+	return sanitized
+}
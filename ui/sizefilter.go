@@ -0,0 +1,73 @@
+package ui
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/list"
+)
+
+// sizeFilterPattern matches size filters like ">50MB", "<=1.5 GiB" or ">100k"
+var sizeFilterPattern = regexp.MustCompile(`^([<>]=?)\s*(\d+(?:\.\d+)?)\s*(?:([kmgt])(i?))?b?$`)
+
+// sizeFilter is a filter keeping the layers larger or smaller than a size
+type sizeFilter struct {
+	op   string // one of <, <=, > and >=
+	size int64
+}
+
+// parseSizeFilter parses a size filter. Units mean what they mean in the
+// list of layers: KB, MB and GB are powers of 1000 as with --size si, and
+// KiB, MiB and GiB powers of 1024.
+func parseSizeFilter(term string) (sizeFilter, bool) {
+	match := sizeFilterPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(term)))
+	if match == nil {
+		return sizeFilter{}, false
+	}
+	n, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return sizeFilter{}, false
+	}
+	if match[3] != "" {
+		base := 1000.0
+		if match[4] != "" {
+			base = 1024
+		}
+		n *= math.Pow(base, float64(strings.Index("kmgt", match[3])+1))
+	}
+	return sizeFilter{op: match[1], size: int64(n)}, true
+}
+
+func (f sizeFilter) match(size int64) bool {
+	switch f.op {
+	case "<":
+		return size < f.size
+	case "<=":
+		return size <= f.size
+	case ">":
+		return size > f.size
+	default:
+		return size >= f.size
+	}
+}
+
+// filterLayers filters the layers by size for terms like ">50MB", and by
+// their commands and digests otherwise. The targets are the filter values of
+// the items in the same order. Build steps without a layer have no size.
+func filterLayers(items []list.Item) list.FilterFunc {
+	return func(term string, targets []string) []list.Rank {
+		f, ok := parseSizeFilter(term)
+		if !ok || len(targets) != len(items) {
+			return list.DefaultFilter(term, targets)
+		}
+		var ranks []list.Rank
+		for i, item := range items {
+			if layer, ok := item.(layerItem); ok && f.match(layer.size) {
+				ranks = append(ranks, list.Rank{Index: i})
+			}
+		}
+		return ranks
+	}
+}
@@ -0,0 +1,90 @@
+package ui
+
+import (
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// SetStart opens a layer and a path in it once the image is loaded, to share
+// how to get to a file. The layer is its 1-based position or a prefix of its
+// DiffID or digest. The path is a directory, or a file that is selected. A
+// path without a layer is opened in all of the layers together.
+func (m *Model) SetStart(layer, path string) {
+	m.startLayer = layer
+	m.startPath = path
+}
+
+// findLayer returns the layer at the 1-based position or with the DiffID or
+// digest starting with the given one, with or without "sha256:"
+func findLayer(layers []container.Layer, s string) (container.Layer, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 1 || n > len(layers) {
+			return container.Layer{}, fmt.Errorf("no layer %d, the image has %d layers", n, len(layers))
+		}
+		return layers[n-1], nil
+	}
+	prefix := strings.TrimPrefix(s, "sha256:")
+	var found []container.Layer
+	for _, l := range layers {
+		if strings.HasPrefix(strings.TrimPrefix(l.DiffID, "sha256:"), prefix) ||
+			strings.HasPrefix(strings.TrimPrefix(l.Digest, "sha256:"), prefix) {
+			found = append(found, l)
+		}
+	}
+	switch {
+	case prefix == "" || len(found) == 0:
+		return container.Layer{}, fmt.Errorf("no layer with the digest %s", filepicker.SanitizeName(s))
+	case len(found) > 1:
+		return container.Layer{}, fmt.Errorf("digest %s matches %d layers", filepicker.SanitizeName(s), len(found))
+	}
+	return found[0], nil
+}
+
+// openStart opens the layer given to SetStart, or all of the layers together
+// for a path alone
+func (m *Model) openStart() tea.Cmd {
+	if m.startLayer == "" && m.startPath == "" {
+		return nil
+	}
+	if m.startLayer == "" {
+		return m.loadLayer(*container.MergeLayers(m.image.Layers...))
+	}
+
+	layer, err := findLayer(m.image.Layers, m.startLayer)
+	m.startLayer = ""
+	if err != nil {
+		m.startPath = ""
+		m.message = fmt.Sprintf("Failed to open the layer: %v", err)
+		return hideMessageAfter(5 * time.Second)
+	}
+	for i, item := range m.list.Items() {
+		if item, ok := item.(layerItem); ok && item.diffID == layer.DiffID {
+			m.list.Select(i)
+		}
+	}
+	return m.loadLayer(layer)
+}
+
+// revealStart shows the path given to SetStart in the file list, and
+// otherwise the root of the layer
+func (m *Model) revealStart() tea.Cmd {
+	p := strings.TrimPrefix(path.Clean("/"+m.startPath), "/")
+	m.startPath = ""
+	if p == "" {
+		return m.filepicker.Init()
+	}
+	cmd, err := m.filepicker.Reveal(p)
+	if err != nil {
+		m.message = fmt.Sprintf("Failed to open /%s: %v", filepicker.SanitizeName(p), err)
+		return tea.Batch(m.filepicker.Init(), hideMessageAfter(5*time.Second))
+	}
+	m.currentPath = "/" + m.filepicker.CurrentPath()
+	return cmd
+}
@@ -0,0 +1,135 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/list"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+type tagsMsg struct {
+	ref  string // repository the tags are of
+	tags []string
+	err  error
+}
+
+// tagOmitted reports whether the reference has neither a tag nor a digest,
+// which name.ParseReference completes with latest
+func tagOmitted(ref string) bool {
+	reference, err := name.ParseReference(ref)
+	if err != nil {
+		return false
+	}
+	tag, ok := reference.(name.Tag)
+	return ok && !strings.HasSuffix(ref, ":"+tag.TagStr())
+}
+
+// listTags lists the tags of the repository of the reference, to pick the
+// one to open
+func (m *Model) listTags() tea.Cmd {
+	m.mode = TagsMode
+	m.tags = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	repo := m.ref
+	opts := m.openOptions
+	return tea.Batch(func() tea.Msg {
+		tags, err := container.ListTags(ctx, repo, opts...)
+		return tagsMsg{ref: repo, tags: tags, err: err}
+	}, m.spinner.Tick)
+}
+
+// openTags shows the tags to pick from. The image is opened as latest if
+// they can't be listed, as for a local image.
+func (m *Model) openTags(msg tagsMsg) tea.Cmd {
+	if m.mode != TagsMode || msg.ref != m.ref {
+		return nil
+	}
+	switch {
+	case msg.err != nil:
+		cmd := m.pickTag("latest")
+		m.message = fmt.Sprintf("Failed to list the tags, opening latest: %v", msg.err)
+		return tea.Batch(cmd, hideMessageAfter(5*time.Second))
+	case len(msg.tags) == 0:
+		cmd := m.pickTag("latest")
+		m.message = "The repository has no tags, opening latest"
+		return tea.Batch(cmd, hideMessageAfter(5*time.Second))
+	}
+	m.tags = msg.tags
+	items := make([]labelItem, len(msg.tags))
+	for i, tag := range msg.tags {
+		items[i] = labelItem{key: tag}
+	}
+	m.table = newLabelList(items, "tag", "tags", m.width-4, m.height-6, m.theme)
+	return m.announce("%d tags of %s, pick one to open", len(msg.tags), filepicker.SanitizeName(m.ref))
+}
+
+// pickTag opens the image of the tag
+func (m *Model) pickTag(tag string) tea.Cmd {
+	m.ref += ":" + tag
+	m.tags = nil
+	m.isLocalImage = hasLocalImage(m.ref, m.pullPolicy, m.openOptions)
+	return m.pullImage()
+}
+
+// updatePicker handles the keys of the list of repositories or tags to pick
+// from, which is loaded once they are listed. esc goes back, if there is
+// somewhere to go back to, unless it clears the filter.
+func (m *Model) updatePicker(msg tea.KeyMsg, loaded bool, pick func(string) tea.Cmd, back func() tea.Cmd) (tea.Model, tea.Cmd) {
+	var cmd tea.Cmd
+	switch {
+	case msg.Type == tea.KeyCtrlC:
+		return m.quit()
+	case msg.Type == tea.KeyEsc && back != nil && (!loaded || m.table.FilterState() == list.Unfiltered):
+		return m, back()
+	case !loaded:
+		if key.Matches(msg, m.keys.quit) {
+			return m.quit()
+		}
+		return m, nil
+	case m.table.FilterState() == list.Filtering:
+		m.table, cmd = m.table.Update(msg)
+		return m, cmd
+	case key.Matches(msg, m.keys.quit):
+		return m.quit()
+	case key.Matches(msg, m.keys.enter):
+		if item, ok := m.table.SelectedItem().(labelItem); ok {
+			return m, pick(item.key)
+		}
+		return m, nil
+	}
+	m.table, cmd = m.table.Update(msg)
+	return m, cmd
+}
+
+// pickerView shows the repositories or tags to pick from, or what is being
+// listed
+func (m *Model) pickerView(loaded bool, what string) string {
+	if loaded {
+		return m.table.View()
+	}
+	if m.accessible {
+		return fmt.Sprintf("\n\n  Listing the %s of %s...", what, filepicker.SanitizeName(m.ref))
+	}
+	return fmt.Sprintf("\n\n  %s Listing the %s of %s...", m.spinner.View(), what, filepicker.SanitizeName(m.ref))
+}
+
+// pickerHelp returns the keys of the list of repositories or tags
+func (m *Model) pickerHelp(loaded, back bool) string {
+	switch {
+	case loaded && back:
+		return m.shortHelp("↑/k up • ↓/j down • enter open • / filter • esc back • q quit")
+	case loaded:
+		return m.shortHelp("↑/k up • ↓/j down • enter open • / filter • q quit")
+	case back:
+		return m.shortHelp("esc back • q quit")
+	}
+	return ""
+}
@@ -0,0 +1,264 @@
+package ui
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// Theme is the set of colors of the UI. Colors are ANSI color numbers from
+// 0 to 255 or hex colors such as "#61AFEF".
+type Theme struct {
+	// List of layers
+	Selected     string `json:"selected"`     // selected item, titles and the active tab
+	Normal       string `json:"normal"`       // other items
+	SelectedDesc string `json:"selectedDesc"` // description of the selected item
+	NormalDesc   string `json:"normalDesc"`   // description of other items
+	Dimmed       string `json:"dimmed"`       // less important text such as inactive tabs
+	Highlight    string `json:"highlight"`    // filter prompt and cursor
+	Help         string `json:"help"`
+
+	// File picker
+	FileSelected string `json:"fileSelected"`
+	Cursor       string `json:"cursor"`
+	Directory    string `json:"directory"`
+	File         string `json:"file"`
+	Symlink      string `json:"symlink"`
+	Error        string `json:"error"`
+	Permission   string `json:"permission"`
+	Metadata     string `json:"metadata"` // size and modification time
+	Disabled     string `json:"disabled"`
+
+	// JSON of the manifest and config
+	JSONKey     string `json:"jsonKey"`
+	JSONString  string `json:"jsonString"`
+	JSONNumber  string `json:"jsonNumber"`
+	JSONLiteral string `json:"jsonLiteral"` // true, false and null
+	JSONDelim   string `json:"jsonDelim"`   // braces and brackets
+}
+
+// DefaultTheme is the name of the theme used unless another one is configured
+const DefaultTheme = "dark"
+
+// themes are the built-in themes
+var themes = map[string]Theme{
+	"dark": {
+		Selected:     "#61AFEF",
+		Normal:       "#ABB2BF",
+		SelectedDesc: "#4B5669",
+		NormalDesc:   "#3E4551",
+		Dimmed:       "#636D83",
+		Highlight:    "#FFB86C",
+		Help:         "240",
+		FileSelected: "205",
+		Cursor:       "212",
+		Directory:    "99",
+		File:         "255",
+		Symlink:      "36",
+		Error:        "196",
+		Permission:   "244",
+		Metadata:     "240",
+		Disabled:     "243",
+		JSONKey:      "6",
+		JSONString:   "2",
+		JSONNumber:   "4",
+		JSONLiteral:  "5",
+		JSONDelim:    "3",
+	},
+	"light": {
+		Selected:     "#0366D6",
+		Normal:       "#24292E",
+		SelectedDesc: "#586069",
+		NormalDesc:   "#6A737D",
+		Dimmed:       "#959DA5",
+		Highlight:    "#D15704",
+		Help:         "244",
+		FileSelected: "161",
+		Cursor:       "161",
+		Directory:    "55",
+		File:         "235",
+		Symlink:      "30",
+		Error:        "160",
+		Permission:   "240",
+		Metadata:     "243",
+		Disabled:     "248",
+		JSONKey:      "#005CC5",
+		JSONString:   "#22863A",
+		JSONNumber:   "#6F42C1",
+		JSONLiteral:  "#D73A49",
+		JSONDelim:    "#24292E",
+	},
+	// ansi only uses the 16 colors of the terminal palette, so it follows
+	// the colors configured in the terminal
+	"ansi": {
+		Selected:     "12",
+		Normal:       "7",
+		SelectedDesc: "8",
+		NormalDesc:   "8",
+		Dimmed:       "8",
+		Highlight:    "11",
+		Help:         "8",
+		FileSelected: "13",
+		Cursor:       "13",
+		Directory:    "12",
+		File:         "7",
+		Symlink:      "14",
+		Error:        "9",
+		Permission:   "8",
+		Metadata:     "8",
+		Disabled:     "8",
+		JSONKey:      "6",
+		JSONString:   "2",
+		JSONNumber:   "4",
+		JSONLiteral:  "5",
+		JSONDelim:    "3",
+	},
+}
+
+// ThemeNames returns the names of the built-in themes
+func ThemeNames() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// userTheme is a theme defined in the config file. Colors that are not set
+// are taken from the base theme.
+type userTheme struct {
+	Base string `json:"base"`
+}
+
+// LoadTheme returns the built-in or user-defined theme name. An empty name
+// selects the theme of the config file, or DefaultTheme if it has none.
+func (c Config) LoadTheme(name string) (Theme, error) {
+	if name == "" {
+		name = c.Theme
+	}
+	if name == "" {
+		name = DefaultTheme
+	}
+	if raw, ok := c.Themes[name]; ok {
+		var user userTheme
+		if err := json.Unmarshal(raw, &user); err != nil {
+			return Theme{}, fmt.Errorf("invalid theme %q: %w", name, err)
+		}
+		if user.Base == "" {
+			user.Base = DefaultTheme
+		}
+		theme, ok := themes[user.Base]
+		if !ok {
+			return Theme{}, fmt.Errorf("theme %q has unknown base theme %q", name, user.Base)
+		}
+		if err := json.Unmarshal(raw, &theme); err != nil {
+			return Theme{}, fmt.Errorf("invalid theme %q: %w", name, err)
+		}
+		if err := theme.validate(); err != nil {
+			return Theme{}, fmt.Errorf("invalid theme %q: %w", name, err)
+		}
+		return theme, nil
+	}
+
+	theme, ok := themes[name]
+	if !ok {
+		return Theme{}, fmt.Errorf("unknown theme %q, must be %s or defined in the config file", name, strings.Join(ThemeNames(), ", "))
+	}
+	return theme, nil
+}
+
+// colors returns the colors of the theme by name for validation
+func (t Theme) colors() map[string]string {
+	return map[string]string{
+		"selected": t.Selected, "normal": t.Normal, "selectedDesc": t.SelectedDesc,
+		"normalDesc": t.NormalDesc, "dimmed": t.Dimmed, "highlight": t.Highlight,
+		"help": t.Help, "fileSelected": t.FileSelected, "cursor": t.Cursor,
+		"directory": t.Directory, "file": t.File, "symlink": t.Symlink,
+		"error": t.Error, "permission": t.Permission, "metadata": t.Metadata,
+		"disabled": t.Disabled, "jsonKey": t.JSONKey, "jsonString": t.JSONString,
+		"jsonNumber": t.JSONNumber, "jsonLiteral": t.JSONLiteral, "jsonDelim": t.JSONDelim,
+	}
+}
+
+func (t Theme) validate() error {
+	for name, color := range t.colors() {
+		if _, err := ansiColor(color); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// ansiColor returns the escape sequence setting the foreground color, using
+// the short form for the 16 colors of the terminal palette
+func ansiColor(color string) (string, error) {
+	if hex, ok := strings.CutPrefix(color, "#"); ok {
+		rgb, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || len(hex) != 6 {
+			return "", fmt.Errorf("invalid color %q, must be #RRGGBB or 0 to 255", color)
+		}
+		return fmt.Sprintf("\x1b[38;2;%d;%d;%dm", rgb>>16, rgb>>8&0xff, rgb&0xff), nil
+	}
+
+	n, err := strconv.Atoi(color)
+	switch {
+	case err != nil || n < 0 || n > 255:
+		return "", fmt.Errorf("invalid color %q, must be #RRGGBB or 0 to 255", color)
+	case n < 8:
+		return fmt.Sprintf("\x1b[%dm", 30+n), nil
+	case n < 16:
+		return fmt.Sprintf("\x1b[%dm", 90+n-8), nil
+	default:
+		return fmt.Sprintf("\x1b[38;5;%dm", n), nil
+	}
+}
+
+// jsonColors are the escape sequences of JSON tokens. All of them are empty
+// when colors are disabled.
+type jsonColors struct {
+	key, str, number, literal, delim string
+	reset                            string
+}
+
+func (t Theme) jsonColors() jsonColors {
+	// Colors are validated when the theme is loaded
+	color := func(c string) string {
+		s, _ := ansiColor(c)
+		return s
+	}
+	return jsonColors{
+		key:     color(t.JSONKey),
+		str:     color(t.JSONString),
+		number:  color(t.JSONNumber),
+		literal: color(t.JSONLiteral),
+		delim:   color(t.JSONDelim),
+		reset:   "\x1b[0m",
+	}
+}
+
+// filepickerStyles returns the styles of the file picker in the theme colors
+func (t Theme) filepickerStyles() filepicker.Styles {
+	s := filepicker.DefaultStyles()
+	s.Selected = s.Selected.Foreground(lipgloss.Color(t.FileSelected))
+	s.Directory = s.Directory.Foreground(lipgloss.Color(t.Directory))
+	s.File = s.File.Foreground(lipgloss.Color(t.File))
+	s.Error = s.Error.Foreground(lipgloss.Color(t.Error))
+	s.Symlink = s.Symlink.Foreground(lipgloss.Color(t.Symlink))
+	s.Permission = s.Permission.Foreground(lipgloss.Color(t.Permission))
+	s.FileSize = s.FileSize.Foreground(lipgloss.Color(t.Metadata))
+	s.ModTime = s.ModTime.Foreground(lipgloss.Color(t.Metadata))
+	s.DisabledFile = s.DisabledFile.Foreground(lipgloss.Color(t.Disabled))
+	s.DisabledCursor = s.DisabledCursor.Foreground(lipgloss.Color(t.Disabled))
+	s.EmptyDirectory = s.EmptyDirectory.Foreground(lipgloss.Color(t.Metadata))
+	s.Cursor = s.Cursor.Foreground(lipgloss.Color(t.Cursor))
+	s.Help = s.Help.Foreground(lipgloss.Color(t.Help))
+	s.FilterMatch = s.FilterMatch.Foreground(lipgloss.Color(t.Highlight))
+	s.Marked = s.Marked.Foreground(lipgloss.Color(t.Highlight))
+	return s
+}
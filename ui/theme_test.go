@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuiltinThemes(t *testing.T) {
+	assert.Equal(t, []string{"ansi", "dark", "light"}, ThemeNames())
+	for _, name := range ThemeNames() {
+		assert.NoError(t, themes[name].validate(), name)
+	}
+}
+
+func TestLoadTheme(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{
+  "theme": "mine",
+  "themes": {
+    "mine": {"base": "light", "selected": "#112233", "jsonKey": "1"},
+    "plain": {"normal": "15"},
+    "broken": {"selected": "blue"},
+    "orphan": {"base": "neon"}
+  }
+}`), 0o644))
+
+	config, err := LoadConfig(path)
+	require.NoError(t, err)
+
+	// The theme of the config file is used by default
+	theme, err := config.LoadTheme("")
+	require.NoError(t, err)
+	assert.Equal(t, "#112233", theme.Selected)
+	assert.Equal(t, themes["light"].Normal, theme.Normal)
+	assert.Equal(t, "\x1b[31m", theme.jsonColors().key)
+
+	// User themes are based on the default theme unless they have a base
+	theme, err = config.LoadTheme("plain")
+	require.NoError(t, err)
+	assert.Equal(t, "15", theme.Normal)
+	assert.Equal(t, themes[DefaultTheme].Selected, theme.Selected)
+
+	theme, err = config.LoadTheme("ansi")
+	require.NoError(t, err)
+	assert.Equal(t, themes["ansi"], theme)
+
+	_, err = config.LoadTheme("broken")
+	assert.ErrorContains(t, err, `invalid color "blue"`)
+	_, err = config.LoadTheme("orphan")
+	assert.ErrorContains(t, err, `unknown base theme "neon"`)
+	_, err = config.LoadTheme("unknown")
+	assert.ErrorContains(t, err, "must be ansi, dark, light")
+
+	// A missing config file uses the default theme
+	config, err = LoadConfig(filepath.Join(dir, "missing.json"))
+	require.NoError(t, err)
+	theme, err = config.LoadTheme("")
+	require.NoError(t, err)
+	assert.Equal(t, themes[DefaultTheme], theme)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"theme": `), 0o644))
+	_, err = LoadConfig(path)
+	assert.Error(t, err)
+}
+
+func TestANSIColor(t *testing.T) {
+	tests := []struct {
+		color   string
+		want    string
+		wantErr bool
+	}{
+		{color: "3", want: "\x1b[33m"},
+		{color: "12", want: "\x1b[94m"},
+		{color: "240", want: "\x1b[38;5;240m"},
+		{color: "#61AFEF", want: "\x1b[38;2;97;175;239m"},
+		{color: "256", wantErr: true},
+		{color: "#FFF", wantErr: true},
+		{color: "red", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.color, func(t *testing.T) {
+			got, err := ansiColor(tt.color)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,255 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/bubbles/key"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// usageBarWidth is the largest width of the bars of the disk usage
+const usageBarWidth = 30
+
+type usageMsg struct {
+	layer *container.Layer
+	usage *container.Usage
+	err   error
+}
+
+// showUsage shows the disk usage of the layer or layers viewed from the
+// directory of the file list, computing it in the background the first time
+func (m *Model) showUsage() tea.Cmd {
+	if m.currentLayer == nil {
+		return nil
+	}
+	m.mode = UsageMode
+	if m.usageLayer == m.currentLayer && m.usage != nil {
+		m.openUsage(m.filepicker.CurrentPath())
+		return m.announce("Showing the disk usage")
+	}
+	m.usage, m.usageLayer, m.usageDirs = nil, m.currentLayer, nil
+	layer := m.currentLayer
+	return tea.Batch(func() tea.Msg {
+		usage, err := layer.DiskUsage(context.Background())
+		return usageMsg{layer: layer, usage: usage, err: err}
+	}, m.spinner.Tick)
+}
+
+// setUsage shows the disk usage computed, unless another layer has been
+// opened since
+func (m *Model) setUsage(msg usageMsg) tea.Cmd {
+	if msg.layer != m.usageLayer {
+		return nil
+	}
+	if msg.err != nil {
+		m.usageLayer = nil
+		if m.mode == UsageMode {
+			m.mode = FileMode
+		}
+		m.message = fmt.Sprintf("Error: %v", msg.err)
+		return hideMessageAfter(3 * time.Second)
+	}
+	m.usage = msg.usage
+	if m.mode != UsageMode {
+		return nil
+	}
+	m.openUsage(m.filepicker.CurrentPath())
+	return m.announce("Showing the disk usage")
+}
+
+// openUsage shows the directory of the disk usage at the path, or the root
+// if it isn't there
+func (m *Model) openUsage(dir string) {
+	m.usageDirs = []*container.Usage{m.usage}
+	if dir != "." && m.usage.Find(dir) != nil {
+		p := "."
+		for _, name := range strings.Split(dir, "/") {
+			p = path.Join(p, name)
+			m.usageDirs = append(m.usageDirs, m.usage.Find(p))
+		}
+	}
+	m.usageIndex, m.usageOffset = 0, 0
+	m.showTypes, m.fileTypes = false, nil
+}
+
+// usageDir returns the directory whose disk usage is shown
+func (m *Model) usageDir() *container.Usage {
+	if len(m.usageDirs) == 0 {
+		return nil
+	}
+	return m.usageDirs[len(m.usageDirs)-1]
+}
+
+// usageHeight is the number of files of the disk usage shown at once
+func (m *Model) usageHeight() int {
+	return max(m.height-8, 1)
+}
+
+// updateUsage handles the keys of the disk usage. enter opens the selected
+// directory and the back keys go up to its parent, then to the file list.
+// The file types of the directory are shown instead of its files until t is
+// pressed again or a back key.
+func (m *Model) updateUsage(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	dir := m.usageDir()
+	if dir == nil {
+		// The computation goes on in the background
+		if msg.Type == tea.KeyEsc || key.Matches(msg, m.keys.diskUsage) {
+			m.mode = FileMode
+		}
+		return m, nil
+	}
+	last := len(dir.Children) - 1
+	if m.showTypes {
+		last = len(m.fileTypes) - 1
+	}
+	switch {
+	case key.Matches(msg, m.keys.up):
+		m.usageIndex--
+	case key.Matches(msg, m.keys.down):
+		m.usageIndex++
+	case key.Matches(msg, m.keys.pageUp):
+		m.usageIndex -= m.usageHeight()
+	case key.Matches(msg, m.keys.pageDown):
+		m.usageIndex += m.usageHeight()
+	case key.Matches(msg, m.keys.first):
+		m.usageIndex = 0
+	case key.Matches(msg, m.keys.last):
+		m.usageIndex = last
+	case key.Matches(msg, m.keys.fileTypes):
+		m.showTypes, m.fileTypes = !m.showTypes, nil
+		if m.showTypes {
+			m.fileTypes = dir.Types(m.usagePath())
+		}
+		m.usageIndex, m.usageOffset = 0, 0
+		return m, nil
+	case m.showTypes && key.Matches(msg, m.keys.back):
+		m.showTypes, m.fileTypes = false, nil
+		m.usageIndex, m.usageOffset = 0, 0
+		return m, nil
+	case m.showTypes && key.Matches(msg, m.keys.enter):
+		return m, nil
+	case key.Matches(msg, m.keys.enter):
+		if m.usageIndex > last {
+			return m, nil
+		}
+		if selected := dir.Children[m.usageIndex]; selected.Dir && len(selected.Children) > 0 {
+			m.usageDirs = append(m.usageDirs, selected)
+			m.usageIndex, m.usageOffset = 0, 0
+		}
+		return m, nil
+	case key.Matches(msg, m.keys.diskUsage),
+		key.Matches(msg, m.keys.back) && (len(m.usageDirs) == 1 || msg.Type == tea.KeyEsc):
+		m.mode = FileMode
+		return m, nil
+	case key.Matches(msg, m.keys.back):
+		m.usageDirs = m.usageDirs[:len(m.usageDirs)-1]
+		// The directory left is selected in its parent
+		m.usageIndex, m.usageOffset = 0, 0
+		for i, c := range m.usageDir().Children {
+			if c == dir {
+				m.usageIndex = i
+			}
+		}
+	}
+	m.usageIndex = max(min(m.usageIndex, last), 0)
+	if m.usageIndex < m.usageOffset {
+		m.usageOffset = m.usageIndex
+	}
+	if height := m.usageHeight(); m.usageIndex >= m.usageOffset+height {
+		m.usageOffset = m.usageIndex - height + 1
+	}
+	return m, nil
+}
+
+// usagePath returns the path of the directory whose disk usage is shown
+func (m *Model) usagePath() string {
+	p := "/"
+	for _, dir := range m.usageDirs[1:] {
+		p = path.Join(p, dir.Name)
+	}
+	return p
+}
+
+// usageView renders the files of the directory by size, each with a bar
+// proportional to its share of the directory, like ncdu, or its file types
+func (m *Model) usageView() string {
+	dir := m.usageDir()
+	if dir == nil {
+		if m.accessible {
+			return "\n\n  Computing the disk usage..."
+		}
+		return fmt.Sprintf("\n\n  %s Computing the disk usage...", m.spinner.View())
+	}
+
+	titleStyle := lipgloss.NewStyle().Bold(true).Foreground(lipgloss.Color(m.theme.Selected))
+	files := fmt.Sprintf("%d files", dir.Files)
+	if dir.Files == 1 {
+		files = "1 file"
+	}
+	title := fmt.Sprintf("%s  %s in %s", titleStyle.Render(filepicker.SanitizeName(m.usagePath())),
+		filepicker.FormatSize(dir.Size, m.sizeFormat), files)
+	if m.showTypes {
+		title += " by type"
+	}
+	var b strings.Builder
+	b.WriteString(title + "\n\n")
+
+	rows := len(dir.Children)
+	if m.showTypes {
+		rows = len(m.fileTypes)
+	}
+	if rows == 0 {
+		b.WriteString("  The directory is empty")
+		return b.String()
+	}
+	end := min(m.usageOffset+m.usageHeight(), rows)
+	for i := m.usageOffset; i < end; i++ {
+		if m.showTypes {
+			t := m.fileTypes[i]
+			count := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed)).Render(fmt.Sprintf("  %d files", t.Files))
+			if t.Files == 1 {
+				count = lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Dimmed)).Render("  1 file")
+			}
+			b.WriteString(m.usageRow(i, t.Size, dir.Size, filepicker.SanitizeName(t.Name), m.theme.File) + count + "\n")
+			continue
+		}
+		c := dir.Children[i]
+		name, color := filepicker.SanitizeName(c.Name), m.theme.File
+		if c.Dir {
+			name, color = name+"/", m.theme.Directory
+		}
+		b.WriteString(m.usageRow(i, c.Size, dir.Size, name, color) + "\n")
+	}
+	return b.String()
+}
+
+// usageRow renders the size of a file or file type with a bar of its share
+// of the total
+func (m *Model) usageRow(i int, size, total int64, name, color string) string {
+	share := 0.0
+	if total > 0 {
+		share = float64(size) / float64(total)
+	}
+	barWidth := min(usageBarWidth, max(m.width/4, 5))
+	filled := int(share*float64(barWidth) + 0.5)
+	bar := strings.Repeat("█", filled) + strings.Repeat("░", barWidth-filled)
+
+	sizeStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Metadata))
+	barStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(m.theme.Highlight))
+	nameStyle := lipgloss.NewStyle().Foreground(lipgloss.Color(color))
+	cursor := "  "
+	if i == m.usageIndex {
+		cursor = "> "
+		nameStyle = nameStyle.Foreground(lipgloss.Color(m.theme.FileSelected)).Bold(true)
+	}
+	return fmt.Sprintf("%s%s %s %5.1f%%  %s", cursor,
+		sizeStyle.Render(fmt.Sprintf("%9s", filepicker.FormatSize(size, m.sizeFormat))),
+		barStyle.Render(bar), share*100, nameStyle.Render(name))
+}
@@ -0,0 +1,191 @@
+package ui
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/knqyf263/sou/container"
+	"github.com/knqyf263/sou/ui/filepicker"
+)
+
+// watchTimeout is how long the Docker daemon is given to inspect the image
+const watchTimeout = 10 * time.Second
+
+// watchMsg is the time to check the image ID again. Checks of an image
+// since reloaded have an older generation and are dropped.
+type watchMsg struct {
+	gen int
+}
+
+type imageIDMsg struct {
+	gen int
+	id  string
+	err error
+}
+
+// resumePoint is where the image was browsed before it was reloaded
+type resumePoint struct {
+	diffID     string // layer browsed or selected, empty for the layers opened together
+	fromBottom int    // position of the layer from the base of the image
+	path       string // directory or file browsed
+	open       bool   // whether the layer was opened or only selected
+}
+
+// SetWatch checks every interval whether the tag of a local image was
+// rebuilt, and offers to reload it. The layer and path being browsed are
+// opened again in the new image if it still has them.
+func (m *Model) SetWatch(interval time.Duration) {
+	m.watchInterval = interval
+}
+
+// startWatch checks the ID of the loaded image, if it is a local one, to
+// know when it changes
+func (m *Model) startWatch() tea.Cmd {
+	m.watchGen++
+	m.watchID = ""
+	m.rebuilt = false
+	if m.watchInterval <= 0 || !m.isLocalImage {
+		return nil
+	}
+	return checkImageID(m.ref, m.watchGen)
+}
+
+// checkImageID gets the ID of the image the reference points to
+func checkImageID(ref string, gen int) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
+		defer cancel()
+		id, err := container.LocalImageID(ctx, ref)
+		return imageIDMsg{gen: gen, id: id, err: err}
+	}
+}
+
+// updateWatch records the ID of the image the first time, and offers to
+// reload the image once it changes. The checks stop then.
+func (m *Model) updateWatch(msg imageIDMsg) tea.Cmd {
+	if msg.gen != m.watchGen {
+		return nil
+	}
+	next := tea.Tick(m.watchInterval, func(time.Time) tea.Msg { return watchMsg{gen: msg.gen} })
+	switch {
+	case msg.err != nil:
+		// The tag is missing while it is being rebuilt
+		debug("Failed to check the image ID: %v", msg.err)
+		return next
+	case m.watchID == "":
+		m.watchID = msg.id
+		return next
+	case msg.id == m.watchID:
+		return next
+	}
+	m.rebuilt = true
+	return m.announce("%s was rebuilt, press R to reload it", filepicker.SanitizeName(m.ref))
+}
+
+// reload loads the image again, to be browsed where it was
+func (m *Model) reload() tea.Cmd {
+	if work := m.workInProgress(); work != "" {
+		m.message = fmt.Sprintf("%s in progress, reload the image once it is done", work)
+		return hideMessageAfter(3 * time.Second)
+	}
+	m.resume = m.resumePoint()
+	m.closeLayer()
+	if err := m.image.Close(); err != nil {
+		debug("Failed to close the image: %v", err)
+	}
+	m.image = nil
+	m.currentFile = nil
+	m.marked = nil
+	m.activeTab = 0
+	m.message = ""
+	m.watchGen++
+	m.rebuilt = false
+	return m.pullImage()
+}
+
+// refresh pulls the image from the registry instead of reading the local
+// one, whose tag may be older than the one of the registry, and browses it
+// where the local one was. The image is always pulled from then on.
+func (m *Model) refresh() tea.Cmd {
+	if !m.isLocalImage || container.IsSIF(m.ref) || container.IsImageArchive(m.ref) {
+		m.message = "The image was not loaded from a local image of a registry"
+		return hideMessageAfter(3 * time.Second)
+	}
+	if work := m.workInProgress(); work != "" {
+		m.message = fmt.Sprintf("%s in progress, pull the image once it is done", work)
+		return hideMessageAfter(3 * time.Second)
+	}
+	debug("Pulling %s from the registry instead of the local image", m.ref)
+	m.pullPolicy = container.PullAlways
+	return m.reload()
+}
+
+// resumePoint returns the layer and the path being browsed, or the layer
+// selected in the list of layers
+func (m *Model) resumePoint() *resumePoint {
+	diffID := ""
+	switch {
+	case m.currentLayer != nil && m.currentLayer.MergedDiffIDs() != nil:
+		// Layers opened together are opened as all of the layers
+	case m.currentLayer != nil:
+		diffID = m.currentLayer.DiffID
+	default:
+		item, ok := m.list.SelectedItem().(layerItem)
+		if !ok {
+			return nil
+		}
+		return m.layerResumePoint(item.diffID, "", false)
+	}
+
+	path := "/" + strings.TrimPrefix(m.filepicker.CurrentPath(), ".")
+	if m.currentFile != nil && (m.mode == ViewMode || m.mode == DiffMode && m.diffReturn == ViewMode) {
+		path = m.currentFile.Path
+	}
+	return m.layerResumePoint(diffID, path, true)
+}
+
+func (m *Model) layerResumePoint(diffID, path string, open bool) *resumePoint {
+	r := &resumePoint{diffID: diffID, path: path, open: open}
+	for i, l := range m.image.Layers {
+		if l.DiffID == diffID {
+			r.fromBottom = len(m.image.Layers) - 1 - i
+		}
+	}
+	return r
+}
+
+// resumeAt opens or selects the layer browsed before the image was
+// reloaded, or the layer at the same position from the base if it was
+// rebuilt, and opens the path in it
+func (m *Model) resumeAt(r *resumePoint) {
+	if r.diffID == "" {
+		m.SetStart("", r.path)
+		return
+	}
+	layers := m.image.Layers
+	n := 0
+	for i, l := range layers {
+		if l.DiffID == r.diffID {
+			n = i + 1
+		}
+	}
+	if n == 0 && r.fromBottom < len(layers) {
+		n = len(layers) - r.fromBottom
+	}
+	if n == 0 {
+		return
+	}
+	if r.open {
+		m.SetStart(strconv.Itoa(n), r.path)
+		return
+	}
+	for i, item := range m.list.Items() {
+		if item, ok := item.(layerItem); ok && item.diffID == layers[n-1].DiffID {
+			m.list.Select(i)
+		}
+	}
+}